@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gigvault/ocsp/internal/config"
+	"github.com/gigvault/ocsp/internal/crl"
+	"github.com/gigvault/ocsp/internal/storage"
+	sharedlogger "github.com/gigvault/shared/pkg/logger"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// commandEnv holds the dependencies shared by one-shot subcommands
+type commandEnv struct {
+	cfg    *config.Config
+	logger *sharedlogger.Logger
+	pool   *pgxpool.Pool
+	store  *storage.Postgres
+	// statuses routes status reads and writes to the shards, Cassandra or Spanner when one is
+	// enabled, and is store otherwise
+	statuses  statusDB
+	sharded   *storage.Sharded
+	cassandra *storage.Cassandra
+	spanner   *storage.Spanner
+}
+
+// openCommandEnv loads the configuration and connects to the database, exiting on failure
+func openCommandEnv(ctx context.Context) *commandEnv {
+	cfg, _ := loadConfig(defaultConfigPath(), nil)
+	logger, err := sharedlogger.New(cfg.Logging.Level, cfg.Logging.Format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize logger: %v\n", err)
+		os.Exit(1)
+	}
+
+	pool, err := connectDB(ctx, cfg, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to database: %v\n", err)
+		os.Exit(1)
+	}
+
+	env := &commandEnv{cfg: cfg, logger: logger, pool: pool, store: storage.NewPostgres(pool)}
+	env.statuses = env.store
+	if cfg.Sharding.Enabled {
+		if env.sharded, err = connectShards(ctx, cfg.Sharding, cfg.DatabasePool, env.store); err != nil {
+			env.fail("Failed to connect to status database shards: %v", err)
+		}
+		env.statuses = env.sharded
+	}
+	if cfg.Cassandra.Enabled {
+		if env.cassandra, err = connectCassandra(cfg.Cassandra, env.store); err != nil {
+			env.fail("Failed to connect to Cassandra: %v", err)
+		}
+		env.statuses = env.cassandra
+	}
+	if cfg.Spanner.Enabled {
+		if env.spanner, err = connectSpanner(ctx, cfg.Spanner, env.store); err != nil {
+			env.fail("Failed to connect to Spanner: %v", err)
+		}
+		env.statuses = env.spanner
+	}
+	return env
+}
+
+// Close releases the database connections and flushes the logger
+func (e *commandEnv) Close() {
+	if e.cassandra != nil {
+		e.cassandra.Close()
+	}
+	if e.spanner != nil {
+		e.spanner.Close()
+	}
+	e.pool.Close()
+	e.logger.Sync()
+}
+
+// fail closes the environment and exits with status 1 after printing the message
+func (e *commandEnv) fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	e.Close()
+	os.Exit(1)
+}
+
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") || crl.IsLDAP(source)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gigvault/ocsp/internal/crl"
+	"github.com/gigvault/ocsp/internal/dryrun"
+)
+
+// runImportCRL imports a CRL from a file path or URL: ocsp import-crl [-dry-run] <path|url>
+func runImportCRL(args []string) {
+	flags := flag.NewFlagSet("import-crl", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report the changes the CRL would make without applying them")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: ocsp import-crl [-dry-run] <path|url>")
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	env := openCommandEnv(ctx)
+	defer env.Close()
+
+	importer, err := newCRLImporter(env.cfg.CRLImport, env.statuses, env.logger)
+	if err != nil {
+		env.fail("Failed to initialize CRL importer: %v", err)
+	}
+
+	source := flags.Arg(0)
+	if *dryRun {
+		planCRLImport(ctx, env, importer, source)
+		return
+	}
+
+	var result *crl.ImportResult
+	if isURL(source) {
+		result, err = importer.ImportURL(ctx, source)
+	} else {
+		result, err = importer.ImportFile(ctx, source)
+	}
+	if err != nil {
+		env.fail("Import failed: %v", err)
+	}
+
+	fmt.Printf("Imported CRL from %s (number %s): %d revoked, %d released\n",
+		result.Issuer, result.Number, result.Revoked, result.Released)
+}
+
+// planCRLImport prints every status the CRL would create or change, flagging revocation rule
+// violations; it exits with status 1 when there are violations
+func planCRLImport(ctx context.Context, env *commandEnv, importer *crl.Importer, source string) {
+	var data []byte
+	var err error
+	if isURL(source) {
+		data, err = importer.Fetch(ctx, source)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		env.fail("Failed to read CRL: %v", err)
+	}
+
+	list, err := crl.Parse(data)
+	if err == nil {
+		err = importer.Verify(list)
+	}
+	if err != nil {
+		env.fail("Invalid CRL: %v", err)
+	}
+
+	result, err := importer.DryRun(ctx, list)
+	if err != nil {
+		env.fail("Dry run failed: %v", err)
+	}
+
+	plan := result.Plan
+	fmt.Printf("Dry run of CRL from %s (number %s): %d created, %d updated, %d unchanged, %d violations; nothing applied\n",
+		result.Issuer, result.Number, plan.Creates, plan.Updates, plan.Unchanged, plan.Violations)
+	for _, change := range plan.Changes {
+		if change.Action == dryrun.ActionUnchanged && change.Violation == "" {
+			continue
+		}
+		from := change.FromStatus
+		if from == "" {
+			from = "none"
+		}
+		fmt.Printf("  %s\t%s\t%s %s -> %s %s", change.Serial, change.Action,
+			from, change.FromReason, change.ToStatus, change.ToReason)
+		if change.Violation != "" {
+			fmt.Printf("\tviolation: %s", change.Violation)
+		}
+		fmt.Println()
+	}
+
+	if plan.Violations > 0 {
+		env.fail("CRL would break revocation rules")
+	}
+}
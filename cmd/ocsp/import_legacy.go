@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gigvault/ocsp/internal/legacy"
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+// runImportLegacy imports another CA's revocation export: ocsp import-legacy -format certutil|ejbca <path>
+func runImportLegacy(args []string) {
+	flags := flag.NewFlagSet("import-legacy", flag.ExitOnError)
+	format := flags.String("format", "", "export format: certutil (Microsoft AD CS) or ejbca")
+	dryRun := flags.Bool("dry-run", false, "parse and validate the export without applying it")
+	flags.Parse(args)
+	if flags.NArg() != 1 || *format == "" {
+		fmt.Fprintln(os.Stderr, "usage: ocsp import-legacy -format certutil|ejbca [-dry-run] <path>")
+		os.Exit(2)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open export: %v\n", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	updates, err := legacy.Parse(*format, file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse %s export: %v\n", *format, err)
+		os.Exit(1)
+	}
+
+	revoked := 0
+	for _, update := range updates {
+		if update.Status == storage.StatusRevoked {
+			revoked++
+		}
+	}
+	if *dryRun {
+		fmt.Printf("Parsed %d statuses (%d revoked); nothing applied\n", len(updates), revoked)
+		return
+	}
+
+	ctx := context.Background()
+	env := openCommandEnv(ctx)
+	defer env.Close()
+
+	if err := env.statuses.ApplyBatch(ctx, updates); err != nil {
+		env.fail("Import failed: %v", err)
+	}
+	fmt.Printf("Imported %d statuses (%d revoked) from %s export\n", len(updates), revoked, *format)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/crl"
+	"github.com/gigvault/ocsp/internal/events"
+	"github.com/gigvault/ocsp/internal/loadtest"
+	"github.com/gigvault/shared/api/proto/ocsp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const loadtestUsage = "usage: ocsp loadtest -target url -rps n -duration d (-serials file [-zipf s] | -log file) [-issuer cert] [-get]"
+
+// runLoadtest replays lookups against a responder at a fixed rate and reports latency, errors,
+// statuses and cache hits. Targets are http(s):// RFC 6960 responder URLs, which need -issuer,
+// or grpc:// addresses looked up with CheckStatus
+func runLoadtest(args []string) {
+	flags := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	target := flags.String("target", "", "http(s)://host/path of an RFC 6960 responder, or grpc://host:port")
+	issuerPath := flags.String("issuer", "", "PEM issuer certificate the requests name (HTTP targets)")
+	serialsPath := flags.String("serials", "", "file of hex serials, one per line, most requested first")
+	zipfExponent := flags.Float64("zipf", 1.1, "exponent of the Zipf distribution over -serials (> 1; higher is more skewed)")
+	logPath := flags.String("log", "", "access log whose serial distribution is replayed, instead of -serials")
+	rps := flags.Float64("rps", 100, "requests per second")
+	duration := flags.Duration("duration", time.Minute, "how long to send requests")
+	timeout := flags.Duration("timeout", 5*time.Second, "timeout of each request")
+	maxInFlight := flags.Int("max-in-flight", 1000, "outstanding requests before further ones are dropped")
+	get := flags.Bool("get", false, "send base64 GET requests, as CDNs cache them, instead of POST")
+	seed := flags.Int64("seed", 1, "seed of the serial sampling, so runs are repeatable")
+	tlsCA := flags.String("tls-ca", "", "CA certificate for a TLS gRPC target")
+	tlsCert := flags.String("tls-cert", "", "client certificate for a mutual TLS gRPC target")
+	tlsKey := flags.String("tls-key", "", "client key for a mutual TLS gRPC target")
+	flags.Parse(args)
+	if flags.NArg() != 0 || *target == "" || (*serialsPath == "") == (*logPath == "") || *rps <= 0 || *duration <= 0 || *maxInFlight <= 0 {
+		fmt.Fprintln(os.Stderr, loadtestUsage)
+		os.Exit(2)
+	}
+
+	sampler, count, err := newSampler(*serialsPath, *logPath, *zipfExponent, *seed)
+	if err != nil {
+		loadtestFail("Failed to read serials: %v", err)
+	}
+
+	var lookups loadtest.Target
+	switch {
+	case strings.HasPrefix(*target, "grpc://"):
+		creds := insecure.NewCredentials()
+		if *tlsCA != "" {
+			tlsConfig, err := events.LoadTLSConfig(*tlsCA, *tlsCert, *tlsKey)
+			if err != nil {
+				loadtestFail("Failed to load TLS settings: %v", err)
+			}
+			creds = credentials.NewTLS(tlsConfig)
+		}
+		conn, err := grpc.NewClient(strings.TrimPrefix(*target, "grpc://"), grpc.WithTransportCredentials(creds))
+		if err != nil {
+			loadtestFail("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+		lookups = loadtest.NewGRPCTarget(ocsp.NewOCSPServiceClient(conn))
+	case strings.HasPrefix(*target, "http://"), strings.HasPrefix(*target, "https://"):
+		if *issuerPath == "" {
+			loadtestFail("HTTP targets need -issuer to build requests")
+		}
+		issuer, err := crl.LoadCertificate(*issuerPath)
+		if err != nil {
+			loadtestFail("Failed to load issuer: %v", err)
+		}
+		lookups = newHTTPLoadTarget(*target, *get, issuer, *maxInFlight)
+	default:
+		loadtestFail("Target must start with http://, https:// or grpc://")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "Sending %.0f requests/s to %s for %s, sampling %d serials\n", *rps, *target, *duration, count)
+	report := loadtest.Run(ctx, loadtest.Options{
+		RPS:         *rps,
+		Duration:    *duration,
+		Timeout:     *timeout,
+		MaxInFlight: *maxInFlight,
+		Progress: func(r loadtest.Report) {
+			fmt.Fprintf(os.Stderr, "  %s: %d completed, %.0f/s, p99 %s, %.2f%% errors\n",
+				r.Elapsed.Round(time.Second), r.Completed, r.Throughput(), r.Latency.P99.Round(time.Microsecond), 100*r.ErrorRate())
+		},
+		ProgressInterval: 10 * time.Second,
+	}, sampler, lookups)
+	printLoadtestReport(report)
+}
+
+// newSampler samples the serials file with a Zipf distribution, or replays the distribution of
+// an access log, and returns how many distinct serials it draws from
+func newSampler(serialsPath, logPath string, exponent float64, seed int64) (loadtest.Sampler, int, error) {
+	path := serialsPath
+	if path == "" {
+		path = logPath
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	if serialsPath != "" {
+		serials, err := loadtest.ReadSerials(file)
+		if err != nil {
+			return nil, 0, err
+		}
+		sampler, err := loadtest.NewZipf(serials, exponent, seed)
+		return sampler, len(serials), err
+	}
+	counts, err := loadtest.ReadAccessLog(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	sampler, err := loadtest.NewEmpirical(counts, seed)
+	return sampler, len(counts), err
+}
+
+// newHTTPLoadTarget keeps a connection per outstanding request, so connection setup is not
+// measured after warm-up
+func newHTTPLoadTarget(url string, get bool, issuer *x509.Certificate, maxInFlight int) loadtest.Target {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = maxInFlight
+	transport.MaxIdleConns = maxInFlight
+	return loadtest.NewHTTPTarget(url, get, issuer, &http.Client{Transport: transport})
+}
+
+func printLoadtestReport(r loadtest.Report) {
+	fmt.Printf("duration\t%s\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Printf("requests\tsent=%d completed=%d dropped=%d throughput=%.1f/s\n", r.Sent, r.Completed, r.Dropped, r.Throughput())
+	fmt.Printf("latency\tp50=%s p90=%s p99=%s p99.9=%s max=%s\n",
+		r.Latency.P50.Round(time.Microsecond), r.Latency.P90.Round(time.Microsecond), r.Latency.P99.Round(time.Microsecond),
+		r.Latency.P999.Round(time.Microsecond), r.Latency.Max.Round(time.Microsecond))
+	fmt.Printf("statuses\t%s\n", formatCounts(r.Statuses))
+	fmt.Printf("errors\t%.2f%% %s\n", 100*r.ErrorRate(), formatCounts(r.Errors))
+	if r.CacheObserved > 0 {
+		fmt.Printf("cache\thit ratio %.1f%% of %d responses with cache headers\n", 100*float64(r.CacheHits)/float64(r.CacheObserved), r.CacheObserved)
+	} else {
+		fmt.Printf("cache\tno cache headers seen\n")
+	}
+	if r.Dropped > 0 {
+		fmt.Fprintf(os.Stderr, "%d requests were dropped at -max-in-flight; the load generator could not keep the rate\n", r.Dropped)
+	}
+}
+
+func formatCounts(counts map[string]int) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%d", k, counts[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+func loadtestFail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
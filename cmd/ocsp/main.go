@@ -1,45 +1,1278 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"math/big"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/gigvault/ocsp/internal/acme"
+	"github.com/gigvault/ocsp/internal/alias"
+	"github.com/gigvault/ocsp/internal/anomaly"
 	"github.com/gigvault/ocsp/internal/api"
-	"github.com/gigvault/shared/pkg/config"
-	"github.com/gigvault/shared/pkg/logger"
+	"github.com/gigvault/ocsp/internal/apikeys"
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/ocsp/internal/archive"
+	"github.com/gigvault/ocsp/internal/audit"
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/casync"
+	"github.com/gigvault/ocsp/internal/cdn"
+	"github.com/gigvault/ocsp/internal/changefeed"
+	"github.com/gigvault/ocsp/internal/chaos"
+	"github.com/gigvault/ocsp/internal/coap"
+	"github.com/gigvault/ocsp/internal/compromise"
+	"github.com/gigvault/ocsp/internal/config"
+	"github.com/gigvault/ocsp/internal/crl"
+	"github.com/gigvault/ocsp/internal/ctcheck"
+	"github.com/gigvault/ocsp/internal/deadletter"
+	"github.com/gigvault/ocsp/internal/deadline"
+	"github.com/gigvault/ocsp/internal/discovery"
+	"github.com/gigvault/ocsp/internal/errreport"
+	"github.com/gigvault/ocsp/internal/events"
+	"github.com/gigvault/ocsp/internal/expiry"
+	"github.com/gigvault/ocsp/internal/failover"
+	"github.com/gigvault/ocsp/internal/featureflag"
+	"github.com/gigvault/ocsp/internal/grpcgzip"
+	"github.com/gigvault/ocsp/internal/guardrail"
+	"github.com/gigvault/ocsp/internal/hold"
+	"github.com/gigvault/ocsp/internal/ipfilter"
+	"github.com/gigvault/ocsp/internal/issuers"
+	"github.com/gigvault/ocsp/internal/ldap"
+	"github.com/gigvault/ocsp/internal/leader"
+	"github.com/gigvault/ocsp/internal/loadshed"
+	"github.com/gigvault/ocsp/internal/logging"
+	"github.com/gigvault/ocsp/internal/metering"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/mode"
+	"github.com/gigvault/ocsp/internal/nonce"
+	"github.com/gigvault/ocsp/internal/nonissued"
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"github.com/gigvault/ocsp/internal/precomputed"
+	"github.com/gigvault/ocsp/internal/replication"
+	"github.com/gigvault/ocsp/internal/reports"
+	"github.com/gigvault/ocsp/internal/responselog"
+	"github.com/gigvault/ocsp/internal/retention"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/schedule"
+	"github.com/gigvault/ocsp/internal/secrets"
+	"github.com/gigvault/ocsp/internal/selfcheck"
+	"github.com/gigvault/ocsp/internal/shadow"
+	"github.com/gigvault/ocsp/internal/shortlived"
+	"github.com/gigvault/ocsp/internal/signwatch"
+	"github.com/gigvault/ocsp/internal/sigv4"
+	"github.com/gigvault/ocsp/internal/spanner"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/internal/throttle"
+	"github.com/gigvault/ocsp/internal/toprequests"
+	"github.com/gigvault/ocsp/internal/tunables"
+	"github.com/gigvault/ocsp/internal/upstream"
+	"github.com/gigvault/ocsp/internal/watch"
+	"github.com/gigvault/ocsp/internal/watchdog"
+	"github.com/gigvault/ocsp/internal/writebehind"
+	"github.com/gigvault/ocsp/pkg/ocspext"
+	"github.com/gigvault/shared/api/proto/ca"
+	"github.com/gigvault/shared/api/proto/ocsp"
+	sharedconfig "github.com/gigvault/shared/pkg/config"
+	"github.com/gigvault/shared/pkg/db"
+	sharedlogger "github.com/gigvault/shared/pkg/logger"
+	"github.com/gocql/gocql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
 )
 
 func main() {
-	configPath := os.Getenv("CONFIG_PATH")
-	if configPath == "" {
-		configPath = "config/config.yaml"
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import-crl":
+			runImportCRL(os.Args[2:])
+			return
+		case "reconcile-crl":
+			runReconcileCRL(os.Args[2:])
+			return
+		case "import-legacy":
+			runImportLegacy(os.Args[2:])
+			return
+		case "migrate-responder":
+			runMigrateResponder(os.Args[2:])
+			return
+		case "backup":
+			runBackup(os.Args[2:])
+			return
+		case "restore":
+			runRestore(os.Args[2:])
+			return
+		case "presign":
+			runPresign(os.Args[2:])
+			return
+		case "reshard":
+			runReshard(os.Args[2:])
+			return
+		case "loadtest":
+			runLoadtest(os.Args[2:])
+			return
+		case "check-config":
+			runCheckConfig(os.Args[2:])
+			return
+		}
 	}
 
-	cfg, err := config.Load(configPath)
-	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
-	}
+	flags := flag.NewFlagSet("ocsp", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "path to the YAML configuration")
+	var overrides stringList
+	flags.Var(&overrides, "set", "override a setting by dotted YAML path, e.g. -set crl.enabled=true (repeatable)")
+	flags.Parse(os.Args[1:])
+
+	cfg, refreshAt := loadConfig(*configPath, overrides)
 
-	logger, err := logger.New(cfg.Logging.Level, cfg.Logging.Format)
+	logger, err := logging.New(cfg.Logging.Level, cfg.Logging.Format, cfg.LogPolicy)
 	if err != nil {
 		log.Fatalf("Failed to initialize logger: %v", err)
 	}
 	defer logger.Sync()
+	sharedlogger.SetGlobal(logger)
 
 	logger.Info("Starting ocsp service",
 		zap.String("service", cfg.Service.Name),
 		zap.String("version", cfg.Service.Version),
 	)
 
-	handler := api.NewHTTPHandler(logger)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	runPreflight(ctx, cfg, logger)
+
+	if cfg.Presigned.Enabled {
+		runPresigned(ctx, stop, cfg, *configPath, overrides, refreshAt, logger)
+		return
+	}
+	if cfg.Role == config.RoleResponder {
+		runResponder(ctx, stop, cfg, logger)
+		return
+	}
+
+	login := &dbLogin{}
+	login.set(cfg.Database.User, cfg.Database.Password)
+	pool, err := connectDB(ctx, cfg, login)
+	if err != nil {
+		logger.Fatal("Failed to connect to database", zap.Error(err))
+	}
+	defer db.Close(pool)
+
+	postgres := storage.NewPostgres(pool)
+
+	// Statuses live in the main database, or in shards routed by serial
+	var statuses statusDB = postgres
+	var sharded *storage.Sharded
+	if cfg.Sharding.Enabled {
+		if sharded, err = connectShards(ctx, cfg.Sharding, cfg.DatabasePool, postgres); err != nil {
+			logger.Fatal("Failed to connect to status database shards", zap.Error(err))
+		}
+		go sharded.RunHealthChecks(ctx, cfg.Sharding.HealthInterval)
+		statuses = sharded
+	}
+	if cfg.Cassandra.Enabled {
+		cassandra, err := connectCassandra(cfg.Cassandra, postgres)
+		if err != nil {
+			logger.Fatal("Failed to connect to Cassandra", zap.Error(err))
+		}
+		defer cassandra.Close()
+		statuses = cassandra
+	}
+	if cfg.Spanner.Enabled {
+		spannerDB, err := connectSpanner(ctx, cfg.Spanner, postgres)
+		if err != nil {
+			logger.Fatal("Failed to connect to Spanner", zap.Error(err))
+		}
+		defer spannerDB.Close()
+		statuses = spannerDB
+	}
+
+	// Status changes committed anywhere reach the subscribers of feed within milliseconds
+	var feed *changefeed.Feed
+	if cfg.Notifications.Enabled {
+		sources := []changefeed.Source{postgres}
+		if sharded != nil {
+			sources = sources[:0]
+			for _, shard := range sharded.Databases() {
+				sources = append(sources, shard)
+			}
+		}
+		feed = changefeed.New(sources, logger)
+	}
+
+	modeSwitch, err := mode.NewSwitch(cfg.Mode.Initial, cfg.Mode.RetryAfter, logger)
+	if err != nil {
+		logger.Fatal("Invalid operating mode", zap.Error(err))
+	}
+	// Every status write is checked against the revocation rules, and held through failovers
+	// of the main database rather than failed
+	checked := revocation.NewStore(statuses)
+	var primary storage.Store = checked
+	if cfg.Failover.Enabled {
+		held := failover.NewStore(checked, failover.NewPostgres(pool), failover.Options{
+			QueueSize:     cfg.Failover.QueueSize,
+			MaxWait:       cfg.Failover.MaxWait,
+			ProbeInterval: cfg.Failover.ProbeInterval,
+		}, logger)
+		go held.Run(ctx)
+		primary = held
+	}
+	faulty := primary
+	var injector *chaos.Injector
+	if cfg.Chaos.Enabled {
+		injector = chaos.New(chaos.Options{
+			Seed:             cfg.Chaos.Seed,
+			DBLatency:        cfg.Chaos.DBLatency,
+			DBLatencyRate:    cfg.Chaos.DBLatencyRate,
+			DBErrorRate:      cfg.Chaos.DBErrorRate,
+			PartialBatchRate: cfg.Chaos.PartialBatchRate,
+			SignerErrorRate:  cfg.Chaos.SignerErrorRate,
+		})
+		faulty = chaos.NewStore(primary, injector)
+		logger.Warn("Chaos fault injection is enabled", zap.String("environment", cfg.Service.Environment))
+	}
+	// Every writer passes the barrier, so queued write-behind writes are flushed before later
+	// writes to their serials
+	barrier := writebehind.NewBarrier(faulty)
+	guarded := mode.NewStore(barrier, modeSwitch)
+
+	// Background jobs that must not run on several replicas at once go through background
+	var elector *leader.Elector
+	if cfg.Leader.Enabled {
+		var backend leader.Backend
+		switch cfg.Leader.Backend {
+		case "kubernetes":
+			lease, err := leader.NewLease(cfg.Leader.Kubernetes.Namespace, cfg.Leader.LockName, cfg.Leader.Kubernetes.Identity,
+				cfg.Leader.Kubernetes.LeaseDuration, cfg.Leader.Interval, logger)
+			if err != nil {
+				logger.Fatal("Failed to initialize Kubernetes leader election", zap.Error(err))
+			}
+			backend = lease
+		default:
+			backend = leader.NewAdvisoryLock(pool, cfg.Leader.LockName, cfg.Leader.Interval, logger)
+		}
+		elector = leader.NewElector(backend, cfg.Leader.Interval, logger)
+	}
+	background := func(name string, run func(ctx context.Context)) {
+		if elector != nil {
+			elector.Go(name, run)
+			return
+		}
+		go run(ctx)
+	}
+	leading := func() bool {
+		return elector == nil || elector.IsLeader()
+	}
+
+	// Writes go through store so every mutation honours the operating mode and reaches the
+	// configured event sinks
+	var store storage.Store = guarded
+	if cfg.Audit.Enabled {
+		store = audit.NewStore(store, audit.NewPostgres(pool), logger)
+	}
+	meter := newMeter(ctx, cfg, logger)
+	if meter != nil {
+		defer meter.Close()
+		store = metering.NewStore(store, meter)
+	}
+	var detector *anomaly.Detector
+	if cfg.Anomaly.Enabled {
+		detector = newDetector(cfg.Anomaly, logging.Component(logger, logging.ComponentAnomaly))
+		store = anomaly.NewStore(store, detector)
+	}
+	var sinks []events.Sink
+	if cfg.Events.Kafka.Enabled {
+		publisher, err := newKafkaPublisher(cfg.Events.Kafka)
+		if err != nil {
+			logger.Fatal("Failed to initialize Kafka publisher", zap.Error(err))
+		}
+		defer publisher.Close()
+		sinks = append(sinks, events.Sink{Name: "kafka", Publisher: publisher})
+	}
+	var js jetstream.JetStream
+	if cfg.Events.NATS.Enabled {
+		conn, stream, err := newNATSConnection(cfg.Events.NATS)
+		if err != nil {
+			logger.Fatal("Failed to initialize NATS", zap.Error(err))
+		}
+		defer conn.Drain()
+		js = stream
+		sinks = append(sinks, events.Sink{Name: "nats", Publisher: events.NewNATSPublisher(js, cfg.Events.NATS.Subject)})
+	}
+	for _, webhook := range cfg.Events.Webhooks {
+		publisher, err := events.NewWebhookPublisher(events.WebhookOptions{
+			URL:             webhook.URL,
+			Secret:          webhook.Secret,
+			RevocationsOnly: webhook.RevocationsOnly,
+			MaxAttempts:     webhook.MaxAttempts,
+			InitialBackoff:  webhook.InitialBackoff,
+			MaxBackoff:      webhook.MaxBackoff,
+			DeadLetterPath:  webhook.DeadLetterPath,
+		}, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize webhook publisher", zap.Error(err))
+		}
+		go publisher.Run(ctx)
+		sinks = append(sinks, events.Sink{Name: "webhook", Publisher: publisher})
+	}
+	// Writes replicated from other regions are only passed to the sinks serving this region
+	var regional []events.Sink
+	var distributor *cdn.Distributor
+	if cfg.CDN.Enabled {
+		distributor, err = newCDNDistributor(cfg.CDN, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize CDN purging", zap.Error(err))
+		}
+		sinks = append(sinks, events.Sink{Name: "cdn", Publisher: distributor})
+		regional = append(regional, events.Sink{Name: "cdn", Publisher: distributor})
+	}
+	var hub *watch.Hub
+	if cfg.Watch.Enabled {
+		hub = watch.NewHub()
+		sinks = append(sinks, events.Sink{Name: "watch", Publisher: hub})
+		regional = append(regional, events.Sink{Name: "watch", Publisher: hub})
+		if feed != nil {
+			feed.Subscribe(hub)
+		}
+	}
+	if cfg.Replication.Enabled {
+		publisher := replication.NewPublisher(js, cfg.Replication.SubjectPrefix, cfg.Replication.Region)
+		publisher.SetSource(store)
+		sinks = append(sinks, events.Sink{Name: "replication", Publisher: publisher})
+	}
+	if len(sinks) > 0 {
+		publishing := events.NewStore(store, sinks, logger)
+		if cfg.Events.Outbox.Enabled {
+			// Events are written with each change and published by the relay; an advisory lock
+			// keeps replicas from relaying the same outbox at once
+			publishing.UseOutbox()
+			outboxes := []events.Outbox{postgres}
+			if sharded != nil {
+				outboxes = outboxes[:0]
+				for _, shard := range sharded.Databases() {
+					outboxes = append(outboxes, shard)
+				}
+			}
+			relay := events.NewRelay(outboxes, sinks, events.RelayOptions{
+				PollInterval: cfg.Events.Outbox.PollInterval,
+				BatchSize:    cfg.Events.Outbox.BatchSize,
+				Retention:    cfg.Events.Outbox.Retention,
+			}, logger)
+			background("event_outbox", relay.Run)
+		}
+		store = publishing
+	}
+	// Outermost, so refused writes publish nothing
+	var limits *guardrail.Store
+	if cfg.Guardrails.Enabled {
+		limits = guardrail.NewStore(store, guardrail.NewPostgres(pool), guardrail.Limits{
+			Window:         cfg.Guardrails.Window,
+			MaxRevocations: cfg.Guardrails.MaxRevocations,
+			MaxPercent:     cfg.Guardrails.MaxPercent,
+			MinPopulation:  cfg.Guardrails.MinPopulation,
+			Action:         cfg.Guardrails.Action,
+		}, logger)
+		store = limits
+	}
+	if js != nil && cfg.Events.NATS.Consumer.Enabled {
+		consumer := events.NewNATSConsumer(js, store, events.NATSConsumerOptions{
+			Stream:     cfg.Events.NATS.Consumer.Stream,
+			Subject:    cfg.Events.NATS.Consumer.Subject,
+			Durable:    cfg.Events.NATS.Consumer.Durable,
+			MaxDeliver: cfg.Events.NATS.Consumer.MaxDeliver,
+		}, logger)
+		go func() {
+			if err := consumer.Run(ctx); err != nil {
+				logger.Error("JetStream status intake stopped", zap.Error(err))
+			}
+		}()
+	}
+
+	handler := api.NewHTTPHandler(logging.Component(logger, logging.ComponentHTTP))
+	handler.Register(api.NewModeHandler(modeSwitch))
+	if meter != nil {
+		handler.Register(api.NewUsageHandler(meter))
+	}
+	if sharded != nil {
+		handler.Register(api.NewShardsHandler(sharded))
+	}
+	if cfg.Replication.Enabled {
+		// Replicated writes bypass store so they are not published back to other regions
+		consumer := replication.NewConsumer(js, guarded, regional, replication.ConsumerOptions{
+			Region:        cfg.Replication.Region,
+			Stream:        cfg.Replication.Stream,
+			SubjectPrefix: cfg.Replication.SubjectPrefix,
+			MaxDeliver:    cfg.Replication.MaxDeliver,
+		}, logger)
+		go func() {
+			if err := consumer.Run(ctx); err != nil {
+				logger.Error("Replication intake stopped", zap.String("region", cfg.Replication.Region), zap.Error(err))
+			}
+		}()
+		handler.Register(api.NewReplicationHandler(consumer))
+	}
+	if cfg.Backup.Enabled {
+		handler.Register(api.NewBackupHandler(postgres, []byte(cfg.Backup.SigningKey), cfg.Service.Name))
+	}
+
+	reload := newReloader(*configPath, overrides, cfg, refreshAt, logger)
+	reload.add("database_credentials", func(ctx context.Context, cfg *config.Config) error {
+		login.set(cfg.Database.User, cfg.Database.Password)
+		return nil
+	})
+	if cfg.Audit.Enabled {
+		key, err := audit.LoadKey(cfg.Audit.SigningKeyPath, cfg.Audit.CertificatePath)
+		if err != nil {
+			logger.Fatal("Failed to load audit signing key", zap.Error(err))
+		}
+		exporter := audit.NewExporter(audit.NewPostgres(pool), key, cfg.Service.Name)
+		handler.Register(api.NewAuditHandler(exporter))
+		reload.add("audit", func(ctx context.Context, cfg *config.Config) error {
+			key, err := audit.LoadKey(cfg.Audit.SigningKeyPath, cfg.Audit.CertificatePath)
+			if err != nil {
+				return err
+			}
+			exporter.SetKey(key)
+			return nil
+		})
+	}
+
+	rules, err := flagRules(cfg.FeatureFlags)
+	if err != nil {
+		logger.Fatal("Invalid feature flags", zap.Error(err))
+	}
+	flagSet := featureflag.NewSet(rules)
+	var flagDB *featureflag.Postgres
+	if cfg.FeatureFlags.Database.Enabled {
+		flagDB = featureflag.NewPostgres(pool)
+		go flagSet.Watch(ctx, flagDB, cfg.FeatureFlags.Database.RefreshInterval, logger)
+	}
+	reload.add("feature_flags", func(ctx context.Context, cfg *config.Config) error {
+		rules, err := flagRules(cfg.FeatureFlags)
+		if err != nil {
+			return err
+		}
+		flagSet.SetConfig(rules)
+		return nil
+	})
+	handler.Register(api.NewFlagsHandler(flagSet, flagDB))
+
+	// Components register their tunables as they are built; persisted values are applied once
+	// all of them are, before serving
+	var tunableSet *tunables.Registry
+	if cfg.Tunables.Enabled {
+		replica, _ := os.Hostname()
+		tunableSet = tunables.New(tunables.NewPostgres(pool), replica, logger)
+		tunableSet.Register("log_level", tunables.Tunable{
+			Description: "Level of every log component without a level of its own in log_policy.components",
+			Get:         logging.Level,
+			Set: func(value string) error {
+				if err := logging.SetLevel(value); err != nil {
+					return fmt.Errorf("%w: %v", tunables.ErrInvalid, err)
+				}
+				return nil
+			},
+		})
+		handler.Register(api.NewTunablesHandler(tunableSet))
+	}
+
+	importer, err := newCRLImporter(cfg.CRLImport, store, logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize CRL importer", zap.Error(err))
+	}
+	reload.add("crl_import", func(ctx context.Context, cfg *config.Config) error {
+		issuer, err := loadOptionalCertificate(cfg.CRLImport.IssuerCertPath)
+		if err != nil {
+			return err
+		}
+		importer.SetIssuer(issuer)
+		return nil
+	})
+	handler.Register(api.NewCRLHandler(importer))
+
+	// Operator writes over gRPC and the bulk API pass the two-person rule; CRL imports, ACME
+	// intake and background jobs do not
+	operatorStore := store
+	var approvals *approval.Store
+	// Admin API changes need an authenticated principal, whether or not approvals are enabled
+	var authenticator *approval.Authenticator
+	if path := principalsPath(cfg); path != "" {
+		principals, err := approval.LoadPrincipals(path)
+		if err != nil {
+			logger.Fatal("Failed to load principals", zap.Error(err))
+		}
+		authenticator = approval.NewAuthenticator(principals)
+		handler.Use(authenticator.HTTPMiddleware)
+		reload.add("principals", func(ctx context.Context, cfg *config.Config) error {
+			principals, err := approval.LoadPrincipals(principalsPath(cfg))
+			if err != nil {
+				return err
+			}
+			authenticator.SetPrincipals(principals)
+			return nil
+		})
+	}
+	handler.Use(approval.RequirePrincipalForChanges)
+	if cfg.Approvals.Enabled {
+		var err error
+		approvals, err = approval.NewStore(store, approval.NewPostgres(pool), approval.Policy{
+			CASerials: cfg.Approvals.CASerials,
+			Reasons:   cfg.Approvals.Reasons,
+			MaxBatch:  cfg.Approvals.MaxBatch,
+		}, cfg.Approvals.Approvers, cfg.Approvals.TTL, logger)
+		if err != nil {
+			logger.Fatal("Invalid approval policy", zap.Error(err))
+		}
+		operatorStore = approvals
+		handler.Register(api.NewApprovalsHandler(approvals))
+	}
+	var apiKeys *apikeys.Manager
+	if cfg.APIKeys.Enabled {
+		apiKeys = apikeys.NewManager(apikeys.NewPostgres(pool), apikeys.Options{
+			CacheTTL:      cfg.APIKeys.CacheTTL,
+			RotationGrace: cfg.APIKeys.RotationGrace,
+		}, logger)
+		handler.Register(api.NewAPIKeysHandler(apiKeys))
+	}
+	bulkImporter := bulk.NewImporter(operatorStore, bulk.DefaultBatchSize, logger)
+	var deadLetters *deadletter.Queue
+	if cfg.DeadLetters.Enabled {
+		deadLetters = deadletter.NewQueue(deadletter.NewPostgres(pool), operatorStore, logger)
+		bulkImporter.SetDeadLetters(deadLetters)
+		handler.Register(api.NewDeadLettersHandler(deadLetters))
+	}
+	handler.Register(api.NewBulkHandler(bulkImporter))
+	handler.Register(api.NewRangeHandler(statuses))
+	if cfg.Scheduled.Enabled {
+		// Revocations the approval policy covers are refused when scheduled, so applying the
+		// rest later bypasses it
+		scheduler := schedule.NewScheduler(schedule.NewPostgres(pool), store, cfg.Scheduled.MaxHorizon, cfg.Scheduled.BatchSize, logger)
+		if approvals != nil {
+			scheduler.SetApprovalGate(approvals)
+		}
+		handler.Register(api.NewScheduleHandler(scheduler))
+		background("scheduled_revocations", func(ctx context.Context) {
+			scheduler.Run(ctx, cfg.Scheduled.Interval)
+		})
+	}
+	var issuerRegistry *issuers.Registry
+	if cfg.Issuers.Enabled {
+		roots, err := issuers.LoadRoots(cfg.Issuers.RootsPath)
+		if err != nil {
+			logger.Fatal("Failed to load issuer discovery roots", zap.Error(err))
+		}
+		issuerRegistry = issuers.NewRegistry(issuers.NewPostgres(pool), roots, cfg.Issuers.Timeout, cfg.Issuers.MaxDepth, logger)
+		handler.Register(api.NewIssuersHandler(issuerRegistry))
+	}
+	if cfg.Holds.Enabled {
+		holds := hold.NewManager(hold.NewPostgres(pool), store, cfg.Holds.MaxDuration, cfg.Holds.BatchSize, logger)
+		if approvals != nil {
+			holds.SetApprovalGate(approvals)
+		}
+		handler.Register(api.NewHoldsHandler(holds))
+		background("timed_holds", func(ctx context.Context) {
+			holds.Run(ctx, cfg.Holds.Interval)
+		})
+	}
+	if cfg.Expired.Enabled {
+		handler.Register(api.NewExpiryHandler(statuses))
+	}
+	if hub != nil {
+		handler.Register(api.NewWatchHandler(store, hub, cfg.Watch.MaxWait))
+	}
+	if cfg.ACME.Enabled {
+		if cfg.ACME.Secret == "" {
+			logger.Fatal("acme.secret is required when ACME intake is enabled")
+		}
+		handler.Register(api.NewACMEHandler(acme.NewIntake(store, logger), cfg.ACME.Secret))
+	}
+
+	if cfg.CRLSync.Enabled {
+		syncer, err := newCRLSyncer(cfg.CRLSync, importer, store, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize upstream CRL sync", zap.Error(err))
+		}
+		reload.add("crl_sync", func(ctx context.Context, cfg *config.Config) error {
+			sources, err := loadCRLSources(cfg.CRLSync)
+			if err != nil {
+				return err
+			}
+			syncer.SetSources(sources)
+			return nil
+		})
+		background("crl_sync", syncer.Run)
+	}
+
+	var archiver *archive.Archiver
+	if cfg.Archive.Enabled {
+		archiver, err = newArchiver(cfg.Archive, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize archive", zap.Error(err))
+		}
+		if cfg.Archive.SnapshotInterval > 0 {
+			background("archive_snapshots", func(ctx context.Context) {
+				archiver.RunSnapshots(ctx, statuses, cfg.Archive.SnapshotInterval)
+			})
+		}
+	}
+
+	// crlPublishers stays nil unless CRLs are generated
+	var crlPublishers map[string]*crl.Publisher
+	if cfg.CRL.Enabled {
+		publishers, err := newCRLPublishers(cfg.CRL, statuses, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize CRL generation", zap.Error(err))
+		}
+		crlPublishers = publishers
+		// The compromise response re-signs with the validity of the latest configuration
+		var crlSettings atomic.Pointer[config.CRLConfig]
+		crlSettings.Store(&cfg.CRL)
+		resign := func(ctx context.Context, key *compromise.Key) error {
+			settings := crlSettings.Load()
+			var errs []error
+			for path, publisher := range publishers {
+				if err := publisher.Reload(ctx, key.Certificate, key.Signer, settings.Validity, settings.Delta.Validity); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", path, err))
+				}
+			}
+			return errors.Join(errs...)
+		}
+
+		var responder *compromise.Responder
+		if cfg.Compromise.Enabled {
+			active, standby, err := loadCompromiseKeys(cfg)
+			if err != nil {
+				logger.Fatal("Failed to load CRL signing keys", zap.Error(err))
+			}
+			responder, err = compromise.NewResponder(compromise.NewPostgres(pool), store, active, standby, resign,
+				newAnomalyHooks(cfg.Anomaly.Hooks, logger), logger)
+			if err != nil {
+				logger.Fatal("Invalid standby signing key", zap.Error(err))
+			}
+			// A replica starting with a key compromised while it was down switches before serving
+			if err := responder.Sync(ctx); err != nil {
+				logger.Fatal("Configured CRL signing key is compromised", zap.Error(err))
+			}
+			go responder.Watch(ctx, cfg.Compromise.PollInterval)
+			handler.Register(api.NewCompromiseHandler(responder))
+		}
+		reload.add("crl", func(ctx context.Context, cfg *config.Config) error {
+			crlSettings.Store(&cfg.CRL)
+			if responder != nil {
+				active, standby, err := loadCompromiseKeys(cfg)
+				if err != nil {
+					return err
+				}
+				return responder.Reload(ctx, active, standby)
+			}
+			issuer, signer, err := loadCRLIssuer(cfg.CRL)
+			if err != nil {
+				return err
+			}
+			return resign(ctx, &compromise.Key{Certificate: issuer, Signer: signer})
+		})
+		// Every replica generates the CRL it serves; only the leader pushes it to the CDN and archive
+		for path, publisher := range publishers {
+			if distributor != nil && cfg.CDN.PurgeCRLs {
+				paths := []string{path, path + ".pem"}
+				if cfg.CRL.Delta.Enabled {
+					paths = append(paths, path+"/delta", path+"/delta.pem")
+				}
+				publisher.OnPublish(func(ctx context.Context, list *crl.CRL) {
+					if leading() {
+						distributor.Purge(ctx, paths...)
+					}
+				})
+			}
+			if archiver != nil {
+				name := path
+				publisher.OnPublish(func(ctx context.Context, list *crl.CRL) {
+					if !leading() {
+						return
+					}
+					if err := archiver.ArchiveCRL(ctx, name, list); err != nil {
+						logger.Error("Failed to archive CRL", zap.String("path", name), zap.Error(err))
+					}
+				})
+			}
+			handler.Handle(path, publisher)
+			handler.Handle(path+".pem", publisher)
+			if cfg.CRL.Delta.Enabled {
+				handler.Handle(path+"/delta", publisher.DeltaHandler())
+				handler.Handle(path+"/delta.pem", publisher.DeltaHandler())
+			}
+			go publisher.Run(ctx)
+		}
+	}
+
+	if cfg.CASync.Enabled {
+		conn, err := newCAConnection(cfg.CASync)
+		if err != nil {
+			logger.Fatal("Failed to connect to CA service", zap.Error(err))
+		}
+		defer conn.Close()
+
+		syncer := casync.NewSyncer(ca.NewCAServiceClient(conn), guarded, cfg.CASync.Interval, cfg.CASync.PageSize, logger)
+		if cfg.Retention.Enabled && cfg.Retention.Statuses > 0 {
+			syncer.SetExpiryRecorder(statuses)
+		}
+		if issuerRegistry != nil {
+			syncer.SetIssuerRegistry(issuerRegistry)
+		}
+		background("ca_sync", syncer.Run)
+	}
+
+	if cfg.Retention.Enabled {
+		purger := retention.NewPostgres(pool, cfg.Retention.BatchSize)
+		var classes []retention.Class
+		if cfg.Retention.Statuses > 0 {
+			classes = append(classes, retention.Class{Name: retention.ClassStatuses, Keep: cfg.Retention.Statuses, Purge: purger.PurgeStatuses})
+		}
+		if cfg.Retention.Audit > 0 {
+			classes = append(classes, retention.Class{Name: retention.ClassAudit, Keep: cfg.Retention.Audit, Purge: purger.PurgeAudit})
+		}
+		if cfg.Retention.Archive > 0 {
+			classes = append(classes, retention.Class{Name: retention.ClassArchive, Keep: cfg.Retention.Archive,
+				Purge: func(ctx context.Context, cutoff time.Time) (int64, error) {
+					n, err := archiver.Purge(ctx, cutoff)
+					return int64(n), err
+				}})
+		}
+		enforcer := retention.NewEnforcer(classes, logger)
+		handler.Register(api.NewRetentionHandler(enforcer))
+		background("retention", func(ctx context.Context) {
+			enforcer.Run(ctx, cfg.Retention.Interval)
+		})
+	}
+
+	if cfg.CTCheck.Enabled {
+		anomalyLogger := logging.Component(logger, logging.ComponentAnomaly)
+		checker := ctcheck.NewChecker(statuses, ctcheck.Options{
+			SearchURLs: cfg.CTCheck.SearchURLs,
+			Interval:   cfg.CTCheck.Interval,
+			Lookback:   cfg.CTCheck.Lookback,
+		}, anomalyLogger, newAnomalyHooks(cfg.Anomaly.Hooks, anomalyLogger)...)
+		background("ct_check", checker.Run)
+	}
+
+	if cfg.Watchdog.Enabled {
+		issuer, err := crl.LoadCertificate(cfg.Watchdog.IssuerCertPath)
+		if err != nil {
+			logger.Fatal("Failed to load watchdog issuer certificate", zap.Error(err))
+		}
+		anomalyLogger := logging.Component(logger, logging.ComponentAnomaly)
+		dog := watchdog.New(statuses, watchdog.Options{
+			URL:              cfg.Watchdog.URL,
+			Issuer:           issuer,
+			Serials:          cfg.Watchdog.Serials,
+			SampleSize:       cfg.Watchdog.SampleSize,
+			Lookback:         cfg.Watchdog.Lookback,
+			Interval:         cfg.Watchdog.Interval,
+			Timeout:          cfg.Watchdog.Timeout,
+			MaxAge:           cfg.Watchdog.MaxAge,
+			ClockSkew:        cfg.Watchdog.ClockSkew,
+			PropagationDelay: cfg.Watchdog.PropagationDelay,
+			UseGET:           cfg.Watchdog.UseGET,
+		}, anomalyLogger, newAnomalyHooks(cfg.Anomaly.Hooks, anomalyLogger)...)
+		background("watchdog", dog.Run)
+	}
+
+	if cfg.Metrics.Enabled {
+		handler.Handle(cfg.Metrics.Path, metrics.Handler())
+
+		// Freshness is measured on the main database's status table, which is empty when sharded,
+		// or on the signed responses clients are served when precomputed
+		if sharded == nil {
+			freshness := metrics.NewFreshnessExporter(pool, logging.Component(logger, logging.ComponentMetrics),
+				cfg.Metrics.Freshness.Interval,
+				cfg.Metrics.Freshness.RefreshSLA,
+			)
+			if cfg.Precomputed.Enabled {
+				freshness.MeasureSigned()
+			}
+			go freshness.Run(ctx)
+		}
+	}
+
+	if cfg.Reports.Enabled {
+		scheduler := reports.NewScheduler(pool, logger, reports.Options{
+			Service:    cfg.Service.Name,
+			Interval:   cfg.Reports.Interval,
+			TopReasons: cfg.Reports.TopReasons,
+			WebhookURL: cfg.Reports.WebhookURL,
+			Email: reports.EmailGateway{
+				URL:  cfg.Reports.EmailGateway.URL,
+				From: cfg.Reports.EmailGateway.From,
+				To:   cfg.Reports.EmailGateway.To,
+			},
+		})
+		background("reports", scheduler.Run)
+	}
+
+	var aliases *alias.Postgres
+	if cfg.SerialAliases.Enabled {
+		aliases = alias.NewPostgres(pool)
+		handler.Register(api.NewAliasHandler(aliases, statuses))
+	}
+
+	var shortLived *shortlived.Verifier
+	if cfg.ShortLived.Enabled {
+		var issuers []shortlived.Issuer
+		for _, issuer := range cfg.ShortLived.Issuers {
+			cert, err := crl.LoadCertificate(issuer.IssuerCertPath)
+			if err != nil {
+				logger.Fatal("Failed to load short-lived issuer certificate", zap.String("path", issuer.IssuerCertPath), zap.Error(err))
+			}
+			issuers = append(issuers, shortlived.Issuer{Certificate: cert, Secret: []byte(issuer.Secret), MaxLifetime: issuer.MaxLifetime})
+		}
+		shortLived = shortlived.NewVerifier(issuers)
+	}
+
+	var tracker *toprequests.Tracker
+	if cfg.TopRequests.Enabled {
+		tracker = newTopRequests(cfg.TopRequests)
+		handler.Register(api.NewTopRequestsHandler(tracker))
+		if cfg.TopRequests.MetricsTop > 0 {
+			background("top_requests", func(ctx context.Context) {
+				tracker.Run(ctx, cfg.TopRequests.PublishInterval, cfg.TopRequests.MetricsTop)
+			})
+		}
+	}
+
+	var precomputedResponder *precomputed.Responder
+	if cfg.Precomputed.Enabled {
+		signer, err := loadPrecomputedSigner(cfg.Precomputed)
+		if err != nil {
+			logger.Fatal("Failed to load precomputed response signing key", zap.Error(err))
+		}
+		if injector != nil {
+			signer.Key = chaos.NewSigner(signer.Key, injector)
+		}
+		var signWatch *signwatch.Watch
+		if cfg.SigningWatch.Enabled {
+			anomalyLogger := logging.Component(logger, logging.ComponentAnomaly)
+			signWatch = signwatch.New(signwatch.Options{
+				Threshold:    cfg.SigningWatch.Threshold,
+				Window:       cfg.SigningWatch.Window,
+				MinSamples:   cfg.SigningWatch.MinSamples,
+				StallTimeout: cfg.SigningWatch.StallTimeout,
+			}, anomalyLogger, newAnomalyHooks(cfg.Anomaly.Hooks, anomalyLogger)...)
+			// Signing keys are PEM files; the backend label leaves room for remote ones
+			signer.Key = signWatch.Wrap(signer.Key, "file")
+			background("signing_watch", func(ctx context.Context) {
+				signWatch.Run(ctx, cfg.SigningWatch.Interval)
+			})
+		}
+		if cfg.Extensions.Enabled {
+			signer.Extensions, err = newExtensionBuilder(cfg.Extensions)
+			if err != nil {
+				logger.Fatal("Failed to initialize response extensions", zap.Error(err))
+			}
+			if cfg.Extensions.CRLID.Enabled {
+				source, err := crlIDSource(cfg.CRL, cfg.Extensions.CRLID, crlPublishers, signer.Issuer)
+				if err != nil {
+					logger.Fatal("Failed to initialize the CrlID extension", zap.Error(err))
+				}
+				signer.Extensions = ocspext.Chain(signer.Extensions, precomputed.CRLID(source))
+			}
+		}
+		if cfg.Expired.Enabled {
+			signer.Expired, err = expiredPolicy(cfg.Expired, signer.Issuer)
+			if err != nil {
+				logger.Fatal("Failed to load expired certificate policy", zap.Error(err))
+			}
+		}
+		table, err := precomputed.NewTable(pool, signer.Issuer)
+		if err != nil {
+			logger.Fatal("Failed to initialize precomputed responses", zap.Error(err))
+		}
+		precomputedResponder, err = precomputed.NewResponder(table, signer.Issuer, cfg.Precomputed.Path, requestLimits(cfg), logging.Component(logger, logging.ComponentHTTP))
+		if err != nil {
+			logger.Fatal("Failed to initialize precomputed responder", zap.Error(err))
+		}
+		if aliases != nil {
+			for _, path := range cfg.SerialAliases.IssuerCertPaths {
+				issuer, err := crl.LoadCertificate(path)
+				if err != nil {
+					logger.Fatal("Failed to load serial alias issuer certificate", zap.String("path", path), zap.Error(err))
+				}
+				if issuer.Equal(signer.Issuer) {
+					precomputedResponder.SetAliases(alias.NewStore(statuses, aliases), signer)
+				}
+			}
+		}
+		if cfg.Extensions.Enabled && cfg.Extensions.PerRequest {
+			precomputedResponder.SetPerRequest(signer)
+		}
+		if shortLived != nil {
+			if proofs := shortLived.ForIssuer(signer.Issuer); proofs != nil {
+				precomputedResponder.SetShortLived(proofs, signer, cfg.ShortLived.Validity)
+			}
+		}
+		for _, rolled := range cfg.Precomputed.PreviousIssuers {
+			previous, err := loadPreviousSigner(rolled, signer)
+			if err == nil && signWatch != nil {
+				previous.Key = signWatch.Wrap(previous.Key, "file")
+			}
+			if err == nil {
+				err = precomputedResponder.AddPrevious(previous)
+			}
+			if err != nil {
+				logger.Fatal("Failed to register previous issuer key", zap.String("path", rolled.IssuerCertPath), zap.Error(err))
+			}
+		}
+		if observers := requestObservers(tracker, meter); observers != nil {
+			precomputedResponder.SetObserver(observers)
+		}
+		if detector != nil {
+			precomputedResponder.SetLookupObserver(detector)
+		}
+		if cfg.NonceReplay.Enabled {
+			precomputedResponder.SetNonceCache(nonce.NewCache(cfg.NonceReplay.Window, cfg.NonceReplay.MaxEntries, cfg.NonceReplay.MaxPerClient), cfg.NonceReplay.Require)
+		}
+		if cfg.SelfCheck.Enabled {
+			handler.Handle(cfg.SelfCheck.Path, newSelfCheck(cfg, statuses, signer.Issuer, signer, precomputedResponder, logger))
+		}
+		refresher := precomputed.NewRefresher(table, signer, cfg.Precomputed.BatchSize, logger)
+		refresher.SetConcurrency(cfg.Precomputed.Concurrency)
+		if tunableSet != nil {
+			tunableSet.Register("precomputed.concurrency", tunables.Int("Responses of a refresh batch signed at once", 1,
+				refresher.Concurrency, refresher.SetConcurrency))
+		}
+		if meter != nil {
+			refresher.SetMeter(meter)
+		}
+		if cfg.ResponseLog.Enabled {
+			responseLog := responselog.NewLog(responselog.NewPostgres(pool))
+			refresher.SetLog(responseLog)
+			precomputedResponder.SetLog(responseLog)
+			handler.Register(api.NewResponseLogHandler(responseLog))
+		}
+		if feed != nil {
+			feed.Subscribe(refresher)
+		}
+		background("precomputed", func(ctx context.Context) {
+			refresher.Run(ctx, cfg.Precomputed.Interval)
+		})
+		if drift := cfg.Precomputed.Drift; drift.Enabled {
+			anomalyLogger := logging.Component(logger, logging.ComponentAnomaly)
+			verifier := precomputed.NewDriftVerifier(refresher, precomputed.DriftOptions{
+				SampleSize:        drift.SampleSize,
+				RevokedSampleSize: drift.RevokedSampleSize,
+				Lookback:          drift.Lookback,
+				Grace:             drift.Grace,
+				Correct:           drift.Correct,
+			}, anomalyLogger, newAnomalyHooks(cfg.Anomaly.Hooks, anomalyLogger)...)
+			background("precomputed_drift", func(ctx context.Context) {
+				verifier.Run(ctx, drift.Interval)
+			})
+		}
+	}
+
+	// The writer role presigns responses but leaves answering RFC 6960 requests to responders
+	if cfg.Role == config.RoleWriter {
+		precomputedResponder = nil
+	}
 	router := handler.Routes()
+	if precomputedResponder != nil {
+		router = mountResponder(strings.TrimSuffix(cfg.Precomputed.Path, "/"), precomputedResponder, router)
+	}
+	var mirror *shadow.Mirror
+	if cfg.Shadow.Enabled {
+		mirror = shadow.New(shadow.Options{
+			Percent:     cfg.Shadow.Percent,
+			Timeout:     cfg.Shadow.Timeout,
+			MaxInFlight: cfg.Shadow.MaxInFlight,
+		}, logger)
+		if cfg.Shadow.HTTPURL != "" {
+			client, err := newShadowHTTPClient(cfg.Shadow)
+			if err != nil {
+				logger.Fatal("Failed to initialize shadow HTTP client", zap.Error(err))
+			}
+			router = shadow.Middleware(mirror, cfg.Shadow.HTTPURL, client, []string{strings.TrimSuffix(cfg.Precomputed.Path, "/")}, router)
+		}
+	}
+
+	var interceptors []grpc.UnaryServerInterceptor
+	if cfg.ErrorReporting.DSN != "" {
+		environment := cfg.ErrorReporting.Environment
+		if environment == "" {
+			environment = cfg.Service.Environment
+		}
+
+		reporter, err := errreport.New(errreport.Options{
+			DSN:         cfg.ErrorReporting.DSN,
+			Environment: environment,
+			Release:     cfg.Service.Version,
+		}, logger)
+		if err != nil {
+			logger.Fatal("Failed to initialize error reporting", zap.Error(err))
+		}
+		go reporter.Run(ctx)
+
+		router = errreport.HTTPMiddleware(reporter)(router)
+		interceptors = append(interceptors, errreport.UnaryServerInterceptor(reporter))
+	}
+	interceptors = append(interceptors, metrics.UnaryServerInterceptor())
+	interceptors = append(interceptors, deadline.UnaryServerInterceptor(grpcDeadlines(cfg.GRPC.Deadlines)))
+	if cfg.LoadShedding.Enabled {
+		shedder := loadshed.New(loadshed.Options{
+			InitialLimit:  cfg.LoadShedding.InitialLimit,
+			MinLimit:      cfg.LoadShedding.MinLimit,
+			MaxLimit:      cfg.LoadShedding.MaxLimit,
+			LatencyTarget: cfg.LoadShedding.LatencyTarget,
+			Backoff:       cfg.LoadShedding.Backoff,
+		})
+		interceptors = append(interceptors, loadshed.UnaryServerInterceptor(shedder))
+		if cfg.Precomputed.Enabled {
+			router = loadshed.Middleware(shedder, []string{strings.TrimSuffix(cfg.Precomputed.Path, "/")}, router)
+		}
+	}
+	// API keys go first so the principals authenticator leaves their tokens alone
+	if apiKeys != nil {
+		issuers, err := statusIssuers(cfg)
+		if err != nil {
+			logger.Fatal("Failed to load the issuers API keys are scoped by", zap.Error(err))
+		}
+		interceptors = append(interceptors, apikeys.UnaryServerInterceptor(apiKeys, cfg.APIKeys.Require, issuers))
+	}
+	if authenticator != nil {
+		interceptors = append(interceptors, authenticator.UnaryServerInterceptor())
+	}
+	if meter != nil {
+		interceptors = append(interceptors, metering.UnaryServerInterceptor(meter))
+	}
+	if limits != nil {
+		router = guardrail.HTTPMiddleware(router)
+		interceptors = append(interceptors, guardrail.UnaryServerInterceptor())
+	}
+	if detector != nil {
+		interceptors = append(interceptors, anomaly.UnaryServerInterceptor(detector))
+	}
+	if tracker != nil {
+		interceptors = append(interceptors, toprequests.UnaryServerInterceptor(tracker))
+	}
+	if mirror != nil && cfg.Shadow.GRPCAddress != "" {
+		conn, err := newShadowConnection(cfg.Shadow)
+		if err != nil {
+			logger.Fatal("Failed to connect to shadow gRPC target", zap.Error(err))
+		}
+		defer conn.Close()
+		interceptors = append(interceptors, shadow.UnaryServerInterceptor(mirror, ocsp.NewOCSPServiceClient(conn)))
+	}
+
+	// Only status lookups fall back upstream; imports and audits see local state alone
+	lookupStore := operatorStore
+	if cfg.UpstreamOCSP.Enabled {
+		issuer, err := crl.LoadCertificate(cfg.UpstreamOCSP.IssuerCertPath)
+		if err != nil {
+			logger.Fatal("Failed to load upstream OCSP issuer", zap.Error(err))
+		}
+		resolver := upstream.NewResolver(upstream.Options{
+			URL:         cfg.UpstreamOCSP.URL,
+			Issuer:      issuer,
+			MaxCacheTTL: cfg.UpstreamOCSP.MaxCacheTTL,
+			CacheSize:   cfg.UpstreamOCSP.CacheSize,
+		}, logger)
+		lookupStore = featureflag.NewGate(flagSet, featureflag.UpstreamFallback, lookupStore, upstream.NewStore(lookupStore, resolver, logger))
+		if tunableSet != nil {
+			tunableSet.Register("upstream_ocsp.cache_size", tunables.Int("Upstream OCSP responses cached", 0,
+				resolver.CacheSize, resolver.SetCacheSize))
+		}
+		reload.add("upstream_ocsp", func(ctx context.Context, cfg *config.Config) error {
+			issuer, err := crl.LoadCertificate(cfg.UpstreamOCSP.IssuerCertPath)
+			if err != nil {
+				return err
+			}
+			resolver.SetIssuer(issuer)
+			return nil
+		})
+	}
+	if aliases != nil {
+		lookupStore = alias.NewStore(lookupStore, aliases)
+	}
+	if shortLived != nil {
+		lookupStore = shortlived.NewStore(lookupStore, shortLived, cfg.ShortLived.Validity)
+	}
+	lookupStore = featureflag.NewGate(flagSet, featureflag.RevokedForUnknown, lookupStore, nonissued.NewStore(lookupStore))
+	go reload.Run(ctx, cfg.Reload.WatchInterval)
+	if feed != nil {
+		go feed.Run(ctx)
+	}
+	if elector != nil {
+		go elector.Run(ctx)
+	}
+
+	// Only gRPC UpdateStatus writes are queued; imports, sync and the HTTP API write through,
+	// after the barrier has flushed queued writes to the same serials
+	grpcStore := lookupStore
+	var writeBehind *writebehind.Store
+	if cfg.WriteBehind.Enabled {
+		writeBehind = writebehind.NewStore(lookupStore, writebehind.Options{
+			Statuses:       cfg.WriteBehind.Statuses,
+			QueueSize:      cfg.WriteBehind.QueueSize,
+			BatchSize:      cfg.WriteBehind.BatchSize,
+			FlushInterval:  cfg.WriteBehind.FlushInterval,
+			EnqueueTimeout: cfg.WriteBehind.EnqueueTimeout,
+			MaxAttempts:    cfg.WriteBehind.MaxAttempts,
+		}, modeSwitch.CheckWrite, logger)
+		if deadLetters != nil {
+			writeBehind.SetDeadLetters(deadLetters)
+		}
+		go writeBehind.Run(ctx)
+		barrier.Attach(writeBehind)
+		grpcStore = writeBehind
+	}
+
+	grpcServer := newGRPCServer(cfg.GRPC, interceptors, logger)
+	grpcService := api.NewOCSPGRPCServer(grpcStore)
+	if approvals != nil {
+		grpcService.SetApprovalGate(approvals)
+	}
+	if limits != nil {
+		grpcService.SetBatchChecker(limits)
+	}
+	if deadLetters != nil {
+		grpcService.SetDeadLetters(deadLetters)
+	}
+	ocsp.RegisterOCSPServiceServer(grpcServer, grpcService)
+
+	var responderPaths []string
+	if cfg.CRL.Enabled {
+		responderPaths = append(responderPaths, cfg.CRL.Path)
+	}
+	if precomputedResponder != nil {
+		responderPaths = append(responderPaths, strings.TrimSuffix(cfg.Precomputed.Path, "/"))
+	}
+	// Service discovery publishes the replica as healthy while its databases answer
+	health := func(ctx context.Context) error {
+		if err := postgres.Ping(ctx); err != nil {
+			return fmt.Errorf("database: %w", err)
+		}
+		if pinger, ok := statuses.(interface{ Ping(context.Context) error }); ok && statuses != statusDB(postgres) {
+			if err := pinger.Ping(ctx); err != nil {
+				return fmt.Errorf("status store: %w", err)
+			}
+		}
+		return nil
+	}
+	ocspPath := ""
+	if precomputedResponder != nil {
+		ocspPath = strings.TrimSuffix(cfg.Precomputed.Path, "/")
+	}
+	limiter := throttle.New(cfg.RequestLimits.MaxConcurrentPerSource)
+	if tunableSet != nil {
+		if limiter != nil {
+			tunableSet.Register("request_limits.max_concurrent_per_source", tunables.Int("Concurrent RFC 6960 requests admitted per source", 1,
+				limiter.Max, limiter.SetMax))
+		}
+		if err := tunableSet.Load(ctx); err != nil {
+			logger.Error("Failed to load persisted runtime tunables", zap.Error(err))
+		}
+	}
+	serve(cfg, router, grpcServer, responderPaths, ocspPath, limiter, health, stop, logger)
+	if writeBehind != nil {
+		// Acknowledged writes must reach the database before the pool closes
+		<-writeBehind.Done()
+	}
+}
+
+// requestLimits returns the limits RFC 6960 requests are parsed within
+func requestLimits(cfg *config.Config) ocspreq.Limits {
+	return ocspreq.Limits{
+		MaxBodyBytes:      cfg.RequestLimits.MaxBodyBytes,
+		MaxCertIDs:        cfg.RequestLimits.MaxCertIDs,
+		MaxExtensions:     cfg.RequestLimits.MaxExtensions,
+		MaxExtensionBytes: cfg.RequestLimits.MaxExtensionBytes,
+	}
+}
+
+// newSelfCheck creates the self-check of issuer's responder for the canary serial. signer is
+// nil in the responder role, which holds no signing key
+func newSelfCheck(cfg *config.Config, source selfcheck.Source, issuer *x509.Certificate, signer *precomputed.Signer, responder http.Handler, logger *sharedlogger.Logger) *selfcheck.Checker {
+	checker, err := selfcheck.New(selfcheck.Options{
+		Serial:   cfg.SelfCheck.Serial,
+		Issuer:   issuer,
+		Path:     cfg.Precomputed.Path,
+		Limits:   requestLimits(cfg),
+		Timeout:  cfg.SelfCheck.Timeout,
+		CacheFor: cfg.SelfCheck.CacheFor,
+	}, source, signer, responder, logging.Component(logger, logging.ComponentHTTP))
+	if err != nil {
+		logger.Fatal("Failed to initialize self-check", zap.Error(err))
+	}
+	return checker
+}
+
+// mountResponder routes requests at or below path to responder ahead of routes. Base64 GET
+// requests may contain // and must reach the responder without path cleaning
+func mountResponder(path string, responder, routes http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == path || strings.HasPrefix(r.URL.Path, path+"/") {
+			responder.ServeHTTP(w, r)
+			return
+		}
+		routes.ServeHTTP(w, r)
+	})
+}
+
+// serve runs the gRPC and HTTP servers, and CoAP if enabled, until SIGINT or SIGTERM, then cancels
+// background work through stop and shuts them down gracefully
+func serve(cfg *config.Config, router http.Handler, grpcServer *grpc.Server, responderPaths []string, ocspPath string, limiter *throttle.Limiter, health func(context.Context) error, stop context.CancelFunc, logger *sharedlogger.Logger) {
+	admin, err := ipfilter.New(ipfilter.SurfaceAdmin, cfg.Access.Admin.Allow, cfg.Access.Admin.Deny)
+	if err != nil {
+		logger.Fatal("Invalid admin access rules", zap.Error(err))
+	}
+	responder, err := ipfilter.New(ipfilter.SurfaceResponder, cfg.Access.Responder.Allow, cfg.Access.Responder.Deny)
+	if err != nil {
+		logger.Fatal("Invalid responder access rules", zap.Error(err))
+	}
+	routes := []ipfilter.Route{{Prefix: "/api/v1/", Filter: admin}}
+	for _, path := range responderPaths {
+		routes = append(routes, ipfilter.Route{Prefix: path, Filter: responder})
+	}
+	router = throttle.Middleware(limiter, responderPaths, router)
+	router = ipfilter.Middleware(routes, router)
+
+	grpcAddr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.GRPCPort)
+	lis, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		logger.Fatal("Failed to listen for gRPC", zap.String("address", grpcAddr), zap.Error(err))
+	}
+	lis = ipfilter.Listener(lis, admin)
+
+	go func() {
+		logger.Info("Starting gRPC server", zap.String("address", grpcAddr))
+		if err := grpcServer.Serve(lis); err != nil {
+			logger.Fatal("gRPC server error", zap.Error(err))
+		}
+	}()
 
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.HTTPPort)
 	srv := &http.Server{
@@ -57,17 +1290,873 @@ func main() {
 		}
 	}()
 
+	var coapServer *coap.Server
+	if cfg.CoAP.Enabled && ocspPath != "" {
+		coapAddr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.CoAP.Port)
+		conn, err := net.ListenPacket("udp", coapAddr)
+		if err != nil {
+			logger.Fatal("Failed to listen for CoAP", zap.String("address", coapAddr), zap.Error(err))
+		}
+		coapServer = coap.NewServer(router, ocspPath, cfg.CoAP.BlockSize, cfg.CoAP.MaxInFlight, cfg.CoAP.Timeout, logging.Component(logger, logging.ComponentHTTP))
+		go func() {
+			logger.Info("Starting CoAP server", zap.String("address", coapAddr), zap.String("path", ocspPath))
+			if err := coapServer.Serve(conn); err != nil {
+				logger.Fatal("CoAP server error", zap.Error(err))
+			}
+		}()
+	}
+
+	var registration *discovery.Registration
+	if cfg.Discovery.Enabled {
+		if registration, err = newRegistration(cfg, health, logger); err != nil {
+			logger.Fatal("Failed to initialize service discovery", zap.Error(err))
+		}
+		registration.Start(context.Background())
+	}
+
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
 	logger.Info("Shutting down server...")
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if registration != nil {
+		deregisterCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := registration.Stop(deregisterCtx); err != nil {
+			logger.Warn("Failed to deregister from service discovery", zap.Error(err))
+		}
+		cancel()
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
+	if coapServer != nil {
+		coapServer.Close()
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
 		logger.Error("Server forced to shutdown", zap.Error(err))
 	}
+	grpcServer.GracefulStop()
 
 	logger.Info("Server exited")
 }
+
+// statusIssuers returns the hex SHA-1 key hashes of the issuers whose statuses the service
+// holds: those it signs CRLs and precomputed responses for
+func statusIssuers(cfg *config.Config) ([]string, error) {
+	var paths []string
+	if cfg.CRL.Enabled {
+		paths = append(paths, cfg.CRL.IssuerCertPath)
+	}
+	if cfg.Precomputed.Enabled {
+		paths = append(paths, cfg.Precomputed.IssuerCertPath)
+	}
+	var issuers []string
+	for _, path := range paths {
+		cert, err := crl.LoadCertificate(path)
+		if err != nil {
+			return nil, err
+		}
+		keyHash, err := ocspreq.IssuerKeyHash(cert, crypto.SHA1)
+		if err != nil {
+			return nil, err
+		}
+		if issuer := hex.EncodeToString(keyHash); !slices.Contains(issuers, issuer) {
+			issuers = append(issuers, issuer)
+		}
+	}
+	return issuers, nil
+}
+
+// principalsPath returns the file of the principals allowed to use the admin API, if any
+func principalsPath(cfg *config.Config) string {
+	if cfg.AdminAuth.PrincipalsPath != "" {
+		return cfg.AdminAuth.PrincipalsPath
+	}
+	if cfg.Approvals.Enabled {
+		return cfg.Approvals.PrincipalsPath
+	}
+	return ""
+}
+
+// newRegistration describes this replica to the configured discovery provider. Its address is
+// discovery.address, server.host, or the hostname when the servers listen on every interface
+func newRegistration(cfg *config.Config, health func(context.Context) error, logger *sharedlogger.Logger) (*discovery.Registration, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hostname: %w", err)
+	}
+	address := cfg.Discovery.Address
+	if address == "" {
+		address = cfg.Server.Host
+		if ip := net.ParseIP(address); address == "" || ip != nil && ip.IsUnspecified() {
+			address = hostname
+		}
+	}
+	service := cfg.Discovery.ServiceName
+	if service == "" {
+		service = cfg.Service.Name
+	}
+	id := cfg.Discovery.InstanceID
+	if id == "" {
+		id = fmt.Sprintf("%s-%s-%d", service, hostname, cfg.Server.HTTPPort)
+	}
+	instance := discovery.Instance{
+		ID:       id,
+		Service:  service,
+		Address:  address,
+		HTTPPort: cfg.Server.HTTPPort,
+		GRPCPort: cfg.Server.GRPCPort,
+		Tags:     cfg.Discovery.Tags,
+		Meta: map[string]string{
+			"role":        cfg.Role,
+			"version":     cfg.Service.Version,
+			"environment": cfg.Service.Environment,
+		},
+	}
+
+	var registry discovery.Registry
+	switch cfg.Discovery.Provider {
+	case "consul":
+		tlsConfig, err := clientTLS(cfg.Discovery.Consul.TLS)
+		if err != nil {
+			return nil, err
+		}
+		registry = discovery.NewConsul(cfg.Discovery.Consul.Address, cfg.Discovery.Consul.Token, cfg.Discovery.TTL, cfg.Discovery.Consul.DeregisterAfter, tlsConfig)
+	case "etcd":
+		tlsConfig, err := clientTLS(cfg.Discovery.Etcd.TLS)
+		if err != nil {
+			return nil, err
+		}
+		registry = discovery.NewEtcd(cfg.Discovery.Etcd.Endpoints, cfg.Discovery.Etcd.Prefix, cfg.Discovery.Etcd.Username, cfg.Discovery.Etcd.Password, cfg.Discovery.TTL, tlsConfig)
+	default:
+		return nil, fmt.Errorf("unknown discovery provider %q", cfg.Discovery.Provider)
+	}
+	return discovery.NewRegistration(registry, instance, cfg.Discovery.Interval, health, logger), nil
+}
+
+// clientTLS loads the TLS settings of a client, or returns nil to keep its defaults
+func clientTLS(cfg config.TLSClientConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	return events.LoadTLSConfig(cfg.CAPath, cfg.CertPath, cfg.KeyPath)
+}
+
+// stringList collects the values of a repeated flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func defaultConfigPath() string {
+	if path := os.Getenv("CONFIG_PATH"); path != "" {
+		return path
+	}
+	return "config/config.yaml"
+}
+
+// loadConfig loads the file with environment overrides, applies flag overrides on top, resolves
+// secret references and exits listing every invalid setting. It also returns when leased
+// secrets must be fetched again
+func loadConfig(path string, overrides []string) (*config.Config, time.Time) {
+	cfg, refreshAt, err := readConfig(path, overrides)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return cfg, refreshAt
+}
+
+func readConfig(path string, overrides []string) (*config.Config, time.Time, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := cfg.ApplyOverrides(overrides); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid config override: %w", err)
+	}
+	refreshAt, err := secrets.Resolve(context.Background(), cfg)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to resolve secrets: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, time.Time{}, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, refreshAt, nil
+}
+
+// dbLogin holds the credentials new database connections log in with, so credentials rotated
+// in a secret store apply to connections opened after the next reload
+type dbLogin struct {
+	mu       sync.RWMutex
+	user     string
+	password string
+}
+
+func (l *dbLogin) set(user, password string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.user, l.password = user, password
+}
+
+func (l *dbLogin) get() (string, string) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.user, l.password
+}
+
+// statusDB is where statuses are kept: the main database, shards routed by serial, Cassandra
+// or Spanner
+type statusDB interface {
+	storage.Store
+	storage.CRLNumbers
+	storage.Seeder
+	storage.ExpiryRecorder
+	storage.Replica
+	storage.SerialRanger
+	ForEachRecord(ctx context.Context, fn func(storage.Record) error) error
+	ListCRLNumbers(ctx context.Context) ([]storage.CRLNumber, error)
+	ListRevokedSince(ctx context.Context, since time.Time) ([]storage.Record, error)
+}
+
+// connectShards connects to every shard in cfg, active and retiring
+func connectShards(ctx context.Context, cfg config.ShardingConfig, tuning config.DatabasePoolConfig, home *storage.Postgres) (*storage.Sharded, error) {
+	connect := func(shards []config.ShardConfig) ([]*storage.Shard, error) {
+		var connected []*storage.Shard
+		for _, shard := range shards {
+			pool, err := connectPool(ctx, shard.DatabaseConfig, tuning, nil)
+			if err != nil {
+				return nil, fmt.Errorf("shard %s: %w", shard.Name, err)
+			}
+			connected = append(connected, &storage.Shard{Name: shard.Name, Store: storage.NewPostgres(pool)})
+		}
+		return connected, nil
+	}
+	active, err := connect(cfg.Shards)
+	if err != nil {
+		return nil, err
+	}
+	retiring, err := connect(cfg.Retiring)
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewSharded(home, active, retiring, cfg.Resharding)
+}
+
+// connectCassandra connects to the Cassandra or ScyllaDB nodes in cfg; CRL numbers stay in home
+func connectCassandra(cfg config.CassandraConfig, home *storage.Postgres) (*storage.Cassandra, error) {
+	opts := storage.CassandraOptions{Keyspace: cfg.Keyspace, BatchSize: cfg.BatchSize}
+	for _, level := range []struct {
+		name string
+		into *gocql.Consistency
+	}{
+		{cfg.Consistency.Read, &opts.Read},
+		{cfg.Consistency.Write, &opts.Write},
+		{cfg.Consistency.Batch, &opts.Batch},
+		{cfg.Consistency.Scan, &opts.Scan},
+	} {
+		parsed, err := gocql.ParseConsistencyWrapper(level.name)
+		if err != nil {
+			return nil, err
+		}
+		*level.into = parsed
+	}
+	if err := opts.Serial.UnmarshalText([]byte(strings.ToUpper(cfg.Consistency.Serial))); err != nil {
+		return nil, err
+	}
+
+	cluster := gocql.NewCluster(cfg.Hosts...)
+	cluster.ConnectTimeout = cfg.ConnectTimeout
+	if cfg.Username != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{Username: cfg.Username, Password: cfg.Password}
+	}
+	if cfg.TLS.Enabled {
+		tlsConfig, err := events.LoadTLSConfig(cfg.TLS.CAPath, cfg.TLS.CertPath, cfg.TLS.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		cluster.SslOpts = &gocql.SslOptions{Config: tlsConfig, EnableHostVerification: true}
+	}
+	policy := gocql.RoundRobinHostPolicy()
+	if cfg.LocalDatacenter != "" {
+		policy = gocql.DCAwareRoundRobinPolicy(cfg.LocalDatacenter)
+	}
+	cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(policy)
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewCassandra(session, home, opts), nil
+}
+
+// connectSpanner opens a session to the Spanner database in cfg; CRL numbers stay in home
+func connectSpanner(ctx context.Context, cfg config.SpannerConfig, home *storage.Postgres) (*storage.Spanner, error) {
+	client, err := spanner.NewClient(ctx, spanner.Options{
+		Database: cfg.Database,
+		Endpoint: cfg.Endpoint,
+		Emulator: cfg.Emulator,
+		Sessions: cfg.Sessions,
+		Timeout:  cfg.Timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return storage.NewSpanner(client, home, storage.SpannerOptions{Staleness: cfg.Staleness, BatchSize: cfg.BatchSize}), nil
+}
+
+// connectDB opens the pool to the main database; with a non-nil login, every new connection
+// takes its credentials from it instead of from cfg
+func connectDB(ctx context.Context, cfg *config.Config, login *dbLogin) (*pgxpool.Pool, error) {
+	return connectPool(ctx, cfg.Database, cfg.DatabasePool, login)
+}
+
+// connectPool opens a pool to database sized and tuned by tuning, taking credentials from
+// login when it is non-nil
+func connectPool(ctx context.Context, database sharedconfig.DatabaseConfig, tuning config.DatabasePoolConfig, login *dbLogin) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(fmt.Sprintf("host=%s port=%d dbname=%s user=%s password=%s sslmode=%s",
+		dsnQuote(database.Host), database.Port, dsnQuote(database.Database),
+		dsnQuote(database.User), dsnQuote(database.Password), dsnQuote(database.SSLMode)))
+	if err != nil {
+		// The DSN holds the password, so it is never part of the error
+		return nil, fmt.Errorf("invalid database settings for %s:%d", database.Host, database.Port)
+	}
+	tunePool(poolConfig, tuning)
+	if login != nil {
+		poolConfig.BeforeConnect = func(ctx context.Context, conn *pgx.ConnConfig) error {
+			conn.User, conn.Password = login.get()
+			return nil
+		}
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create connection pool to %s:%d", database.Host, database.Port)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping database at %s:%d", database.Host, database.Port)
+	}
+	return pool, nil
+}
+
+// tunePool applies the pool sizes, lifetimes and query mode of tuning to poolConfig
+func tunePool(poolConfig *pgxpool.Config, tuning config.DatabasePoolConfig) {
+	poolConfig.MinConns = tuning.MinConns
+	poolConfig.MaxConns = tuning.MaxConns
+	poolConfig.MaxConnLifetime = tuning.MaxConnLifetime
+	poolConfig.MaxConnLifetimeJitter = tuning.MaxConnLifetimeJitter
+	poolConfig.MaxConnIdleTime = tuning.MaxConnIdleTime
+	poolConfig.HealthCheckPeriod = tuning.HealthCheckPeriod
+
+	conn := poolConfig.ConnConfig
+	conn.StatementCacheCapacity = tuning.StatementCacheSize
+	conn.DescriptionCacheCapacity = tuning.StatementCacheSize
+	switch mode := tuning.QueryExecMode; {
+	case tuning.PgBouncer || mode == "simple_protocol":
+		conn.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	case mode == "cache_describe":
+		conn.DefaultQueryExecMode = pgx.QueryExecModeCacheDescribe
+	case mode == "describe_exec":
+		conn.DefaultQueryExecMode = pgx.QueryExecModeDescribeExec
+	default:
+		conn.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+	}
+	if tuning.StatementTimeout > 0 {
+		conn.RuntimeParams["statement_timeout"] = strconv.FormatInt(tuning.StatementTimeout.Milliseconds(), 10)
+	}
+}
+
+// dsnQuote quotes a keyword/value DSN value so passwords from secret stores may hold spaces
+// and quotes
+func dsnQuote(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+func newCRLImporter(cfg config.CRLImportConfig, store storage.Store, logger *sharedlogger.Logger) (*crl.Importer, error) {
+	issuer, err := loadOptionalCertificate(cfg.IssuerCertPath)
+	if err != nil {
+		return nil, err
+	}
+	importer := crl.NewImporter(store, issuer, logger)
+	tlsConfig, err := clientTLS(cfg.LDAP.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load LDAP TLS settings: %w", err)
+	}
+	importer.SetLDAP(ldap.NewClient(cfg.LDAP.BindDN, cfg.LDAP.Password, cfg.LDAP.StartTLS, tlsConfig, cfg.LDAP.Timeout))
+	return importer, nil
+}
+
+// loadOptionalCertificate loads the certificate at path, or returns nil when path is empty
+func loadOptionalCertificate(path string) (*x509.Certificate, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return crl.LoadCertificate(path)
+}
+
+func newCRLSyncer(cfg config.CRLSyncConfig, importer *crl.Importer, store storage.Store, logger *sharedlogger.Logger) (*crl.Syncer, error) {
+	sources, err := loadCRLSources(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return crl.NewSyncer(importer, store, sources, cfg.Interval, logger), nil
+}
+
+func loadCRLSources(cfg config.CRLSyncConfig) ([]crl.Source, error) {
+	sources := make([]crl.Source, 0, len(cfg.Sources))
+	for _, src := range cfg.Sources {
+		issuer, err := loadOptionalCertificate(src.IssuerCertPath)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, crl.Source{URL: src.URL, Issuer: issuer})
+	}
+	return sources, nil
+}
+
+// loadCRLIssuer loads the certificate and key generated CRLs are signed with
+func loadCRLIssuer(cfg config.CRLConfig) (*x509.Certificate, crypto.Signer, error) {
+	issuer, err := crl.LoadCertificate(cfg.IssuerCertPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, err := crl.LoadSigner(cfg.IssuerKeyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return issuer, signer, nil
+}
+
+// newExtensionBuilder chains the static extensions with the registered builders the
+// configuration selects, in that order
+func newExtensionBuilder(cfg config.ExtensionsConfig) (ocspext.Builder, error) {
+	var static ocspext.Extensions
+	for _, ext := range cfg.Static {
+		oid, err := ocspext.ParseOID(ext.OID)
+		if err != nil {
+			return nil, err
+		}
+		value, err := hex.DecodeString(ext.Value)
+		if err != nil {
+			return nil, fmt.Errorf("extension %s: %w", ext.OID, err)
+		}
+		extension := pkix.Extension{Id: oid, Critical: ext.Critical, Value: value}
+		if ext.Scope == "response" {
+			static.Response = append(static.Response, extension)
+		} else {
+			static.Single = append(static.Single, extension)
+		}
+	}
+	builders := []ocspext.Builder{ocspext.Static(static)}
+	for _, b := range cfg.Builders {
+		builder, err := ocspext.New(b.Name, b.Options)
+		if err != nil {
+			return nil, err
+		}
+		builders = append(builders, builder)
+	}
+	return ocspext.Chain(builders...), nil
+}
+
+// crlIDSource finds the publisher of the CRL covering a serial, and the URL that CRL is
+// published at. The CRLs must be signed by the issuer of the responses naming them
+func crlIDSource(cfg config.CRLConfig, crlID config.CRLIDConfig, publishers map[string]*crl.Publisher, issuer *x509.Certificate) (precomputed.CRLSource, error) {
+	crlIssuer, err := crl.LoadCertificate(cfg.IssuerCertPath)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(crlIssuer.Raw, issuer.Raw) {
+		return nil, errors.New("crl.issuer_cert_path is not the issuer of precomputed responses")
+	}
+	if cfg.Partitions.Count <= 1 {
+		publisher := publishers[cfg.Path]
+		return func(*big.Int) (*crl.CRL, string) {
+			return publisher.Current(), crlID.URL
+		}, nil
+	}
+	partitions := make([]*crl.Publisher, cfg.Partitions.Count)
+	for i := range partitions {
+		partitions[i] = publishers[fmt.Sprintf("%s/%d", cfg.Path, i)]
+	}
+	baseURL := strings.TrimSuffix(cfg.Partitions.BaseURL, "/")
+	return func(serial *big.Int) (*crl.CRL, string) {
+		i := crl.PartitionOf(serial, len(partitions))
+		return partitions[i].Current(), fmt.Sprintf("%s/%d", baseURL, i)
+	}, nil
+}
+
+// expiredPolicy returns the expired certificate policy of issuer: its own when it is listed,
+// otherwise the default
+func expiredPolicy(cfg config.ExpiredConfig, issuer *x509.Certificate) (*expiry.Policy, error) {
+	for _, listed := range cfg.Issuers {
+		cert, err := crl.LoadCertificate(listed.IssuerCertPath)
+		if err != nil {
+			return nil, err
+		}
+		if cert.Equal(issuer) {
+			return &expiry.Policy{Name: listed.Policy, Grace: listed.Grace, Retention: listed.ArchiveRetention}, nil
+		}
+	}
+	return &expiry.Policy{Name: cfg.Policy, Grace: cfg.Grace, Retention: cfg.ArchiveRetention}, nil
+}
+
+// loadPrecomputedSigner loads the certificates and key precomputed responses are signed with
+func loadPrecomputedSigner(cfg config.PrecomputedConfig) (*precomputed.Signer, error) {
+	issuer, err := crl.LoadCertificate(cfg.IssuerCertPath)
+	if err != nil {
+		return nil, err
+	}
+	responder, err := loadOptionalCertificate(cfg.ResponderCertPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := crl.LoadSigner(cfg.SigningKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &precomputed.Signer{Issuer: issuer, Responder: responder, Key: key, Validity: cfg.Validity, Jitter: cfg.Jitter, RefreshBefore: cfg.RefreshBefore}, nil
+}
+
+// loadPreviousSigner loads the signer for an earlier key of current's issuer, signing like
+// current
+func loadPreviousSigner(cfg config.PreviousIssuerConfig, current *precomputed.Signer) (*precomputed.Signer, error) {
+	signer, err := loadPrecomputedSigner(config.PrecomputedConfig{
+		IssuerCertPath:    cfg.IssuerCertPath,
+		ResponderCertPath: cfg.ResponderCertPath,
+		SigningKeyPath:    cfg.SigningKeyPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	previous := *current
+	previous.Issuer, previous.Responder, previous.Key = signer.Issuer, signer.Responder, signer.Key
+	return &previous, nil
+}
+
+// loadCompromiseKeys loads the CRL signing key and the standby key held in reserve for it
+func loadCompromiseKeys(cfg *config.Config) (active, standby *compromise.Key, err error) {
+	if active, err = compromise.LoadKey(cfg.CRL.IssuerCertPath, cfg.CRL.IssuerKeyPath); err != nil {
+		return nil, nil, err
+	}
+	if standby, err = compromise.LoadKey(cfg.Compromise.StandbyCertPath, cfg.Compromise.StandbyKeyPath); err != nil {
+		return nil, nil, err
+	}
+	return active, standby, nil
+}
+
+// newCRLPublishers returns the CRL publishers keyed by the path they are served at: one at
+// the configured path, or one per partition below it
+func newCRLPublishers(cfg config.CRLConfig, store statusDB, logger *sharedlogger.Logger) (map[string]*crl.Publisher, error) {
+	issuer, signer, err := loadCRLIssuer(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var deltaInterval time.Duration
+	if cfg.Delta.Enabled {
+		deltaInterval = cfg.Delta.Interval
+	}
+
+	newOptions := func() crl.GeneratorOptions {
+		opts := crl.GeneratorOptions{Validity: cfg.Validity, Numbers: store}
+		if cfg.IDP.Enabled {
+			opts.IDP = &crl.IssuingDistributionPoint{
+				URIs:          cfg.IDP.URIs,
+				OnlyUserCerts: cfg.IDP.OnlyUserCerts,
+				OnlyCACerts:   cfg.IDP.OnlyCACerts,
+			}
+		}
+		if cfg.Delta.Enabled {
+			opts.DeltaValidity = cfg.Delta.Validity
+			opts.DeltaURL = cfg.Delta.URL
+		}
+		return opts
+	}
+
+	publishers := make(map[string]*crl.Publisher)
+	if cfg.Partitions.Count <= 1 {
+		generator := crl.NewGenerator(store, issuer, signer, newOptions(), logger)
+		publishers[cfg.Path] = crl.NewPublisher(generator, cfg.Interval, deltaInterval, logger)
+		return publishers, nil
+	}
+
+	if cfg.Partitions.BaseURL == "" {
+		return nil, fmt.Errorf("crl.partitions.base_url is required when partitioning CRLs")
+	}
+	for i := 0; i < cfg.Partitions.Count; i++ {
+		url := fmt.Sprintf("%s/%d", strings.TrimSuffix(cfg.Partitions.BaseURL, "/"), i)
+
+		opts := newOptions()
+		opts.Partitions = cfg.Partitions.Count
+		opts.Partition = i
+		// Partitioned CRLs must declare their own distribution point so clients can tell them apart
+		idp := crl.IssuingDistributionPoint{URIs: []string{url}}
+		if opts.IDP != nil {
+			idp.OnlyUserCerts = opts.IDP.OnlyUserCerts
+			idp.OnlyCACerts = opts.IDP.OnlyCACerts
+		}
+		opts.IDP = &idp
+		if cfg.Delta.Enabled {
+			opts.DeltaURL = url + "/delta"
+		}
+
+		partitionLogger := &sharedlogger.Logger{Logger: logger.With(zap.Int("crl_partition", i))}
+		generator := crl.NewGenerator(store, issuer, signer, opts, partitionLogger)
+		publishers[fmt.Sprintf("%s/%d", cfg.Path, i)] = crl.NewPublisher(generator, cfg.Interval, deltaInterval, partitionLogger)
+	}
+	return publishers, nil
+}
+
+// flagRules converts configured flag rules, rejecting flags the service does not know
+func flagRules(cfg config.FeatureFlagsConfig) (map[string]featureflag.Rule, error) {
+	rules := make(map[string]featureflag.Rule, len(cfg.Rules))
+	for name, rule := range cfg.Rules {
+		if !featureflag.Known(name) {
+			return nil, fmt.Errorf("unknown feature flag %q (known: %s)", name, strings.Join(featureflag.Names(), ", "))
+		}
+		rules[name] = featureflag.Rule{Enabled: rule.Enabled, Percent: rule.Percent, Serials: rule.Serials}
+	}
+	return rules, nil
+}
+
+func newKafkaPublisher(cfg config.KafkaConfig) (*events.KafkaPublisher, error) {
+	opts := events.KafkaOptions{
+		Brokers:       cfg.Brokers,
+		Topic:         cfg.Topic,
+		SASLMechanism: cfg.SASL.Mechanism,
+		Username:      cfg.SASL.Username,
+		Password:      cfg.SASL.Password,
+	}
+	if cfg.TLS.Enabled {
+		tlsConfig, err := events.LoadTLSConfig(cfg.TLS.CAPath, cfg.TLS.CertPath, cfg.TLS.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLS = tlsConfig
+	}
+	return events.NewKafkaPublisher(opts)
+}
+
+func newNATSConnection(cfg config.NATSConfig) (*nats.Conn, jetstream.JetStream, error) {
+	opts := events.NATSOptions{URL: cfg.URL, CredentialsPath: cfg.CredentialsPath}
+	if cfg.TLS.Enabled {
+		tlsConfig, err := events.LoadTLSConfig(cfg.TLS.CAPath, cfg.TLS.CertPath, cfg.TLS.KeyPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts.TLS = tlsConfig
+	}
+	return events.ConnectNATS(opts)
+}
+
+func newArchiver(cfg config.ArchiveConfig, logger *sharedlogger.Logger) (*archive.Archiver, error) {
+	bucket, err := newS3(cfg.S3)
+	if err != nil {
+		return nil, err
+	}
+	return archive.NewArchiver(bucket, cfg.Prefix, logger), nil
+}
+
+// newS3 connects to a bucket, taking missing credentials from the AWS environment variables
+func newS3(cfg config.S3Config) (*archive.S3, error) {
+	accessKeyID, secretAccessKey := cfg.AccessKeyID, cfg.SecretAccessKey
+	if accessKeyID == "" {
+		accessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if secretAccessKey == "" {
+		secretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	return archive.NewS3(archive.S3Options{
+		Endpoint:        cfg.Endpoint,
+		Region:          cfg.Region,
+		Bucket:          cfg.Bucket,
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		PathStyle:       cfg.PathStyle,
+	})
+}
+
+// newMeter starts metering usage when it is enabled, exporting to the configured bucket if
+// there is one. Callers close the meter at shutdown to export the last period
+func newMeter(ctx context.Context, cfg *config.Config, logger *sharedlogger.Logger) *metering.Meter {
+	if !cfg.Metering.Enabled {
+		return nil
+	}
+	replica := cfg.Metering.Replica
+	if replica == "" {
+		replica, _ = os.Hostname()
+	}
+	var store archive.ObjectStore
+	if cfg.Metering.S3.Bucket != "" {
+		bucket, err := newS3(cfg.Metering.S3)
+		if err != nil {
+			logger.Fatal("Failed to initialize usage export", zap.Error(err))
+		}
+		store = bucket
+	}
+	meter := metering.New(metering.Options{
+		Period:  cfg.Metering.Period,
+		Replica: replica,
+		Prefix:  cfg.Metering.Prefix,
+	}, store, logger)
+	go meter.Run(ctx)
+	return meter
+}
+
+// requestObservers combines whichever of the top requests tracker and the meter are enabled,
+// or returns nil when neither is
+func requestObservers(tracker *toprequests.Tracker, meter *metering.Meter) ocspreq.Observer {
+	var observers ocspreq.Observers
+	if tracker != nil {
+		observers = append(observers, tracker)
+	}
+	if meter != nil {
+		observers = append(observers, meter)
+	}
+	if len(observers) == 0 {
+		return nil
+	}
+	return observers
+}
+
+func newCDNDistributor(cfg config.CDNConfig, logger *sharedlogger.Logger) (*cdn.Distributor, error) {
+	var purgers []cdn.Purger
+	if cfg.Fastly.Enabled {
+		fastly, err := cdn.NewFastly(cfg.Fastly.APIToken, cfg.Fastly.Host, cfg.Fastly.ServiceID, cfg.Fastly.SoftPurge)
+		if err != nil {
+			return nil, err
+		}
+		purgers = append(purgers, fastly)
+	}
+	if cfg.CloudFront.Enabled {
+		creds := sigv4.Credentials{AccessKeyID: cfg.CloudFront.AccessKeyID, SecretAccessKey: cfg.CloudFront.SecretAccessKey}
+		if creds.AccessKeyID == "" {
+			creds.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		}
+		if creds.SecretAccessKey == "" {
+			creds.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		cloudFront, err := cdn.NewCloudFront(cfg.CloudFront.DistributionID, creds)
+		if err != nil {
+			return nil, err
+		}
+		purgers = append(purgers, cloudFront)
+	}
+	if len(purgers) == 0 {
+		return nil, fmt.Errorf("cdn is enabled but no provider is configured")
+	}
+	return cdn.NewDistributor(purgers, cfg.StatusPaths, cfg.MaxPaths, logger), nil
+}
+
+// newGRPCServer creates the gRPC server with the transport settings of cfg
+// newTopRequests creates a tracker sized by cfg
+func newTopRequests(cfg config.TopRequestsConfig) *toprequests.Tracker {
+	return toprequests.New(toprequests.Options{
+		Windows:  cfg.Windows,
+		Bucket:   cfg.Bucket,
+		Capacity: cfg.Capacity,
+	})
+}
+
+// grpcDeadlines maps the configured deadlines to the methods they bound
+func grpcDeadlines(cfg config.GRPCDeadlinesConfig) deadline.Deadlines {
+	return deadline.Deadlines{
+		"CheckStatus":       cfg.CheckStatus,
+		"UpdateStatus":      cfg.UpdateStatus,
+		"BatchUpdateStatus": cfg.BatchUpdateStatus,
+	}
+}
+
+func newGRPCServer(cfg config.GRPCConfig, interceptors []grpc.UnaryServerInterceptor, logger *sharedlogger.Logger) *grpc.Server {
+	if cfg.Gzip {
+		if err := grpcgzip.Register(cfg.GzipLevel); err != nil {
+			logger.Fatal("Failed to enable gRPC compression", zap.Error(err))
+		}
+	}
+	return grpc.NewServer(
+		grpc.ChainUnaryInterceptor(interceptors...),
+		grpc.MaxRecvMsgSize(cfg.MaxRecvMessageSize),
+		grpc.MaxSendMsgSize(cfg.MaxSendMessageSize),
+		grpc.MaxConcurrentStreams(cfg.MaxConcurrentStreams),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:                  cfg.Keepalive.Time,
+			Timeout:               cfg.Keepalive.Timeout,
+			MaxConnectionIdle:     cfg.Keepalive.MaxConnectionIdle,
+			MaxConnectionAge:      cfg.Keepalive.MaxConnectionAge,
+			MaxConnectionAgeGrace: cfg.Keepalive.MaxConnectionAgeGrace,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             cfg.Keepalive.MinTime,
+			PermitWithoutStream: cfg.Keepalive.PermitWithoutStream,
+		}),
+	)
+}
+
+func newCAConnection(cfg config.CASyncConfig) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if cfg.TLS.Enabled {
+		tlsConfig, err := events.LoadTLSConfig(cfg.TLS.CAPath, cfg.TLS.CertPath, cfg.TLS.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	return grpc.NewClient(cfg.Address, grpc.WithTransportCredentials(creds))
+}
+
+func newShadowConnection(cfg config.ShadowConfig) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if cfg.TLS.Enabled {
+		tlsConfig, err := events.LoadTLSConfig(cfg.TLS.CAPath, cfg.TLS.CertPath, cfg.TLS.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	return grpc.NewClient(cfg.GRPCAddress, grpc.WithTransportCredentials(creds))
+}
+
+func newShadowHTTPClient(cfg config.ShadowConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.TLS.Enabled {
+		tlsConfig, err := events.LoadTLSConfig(cfg.TLS.CAPath, cfg.TLS.CertPath, cfg.TLS.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+func newDetector(cfg config.AnomalyConfig, logger *sharedlogger.Logger) *anomaly.Detector {
+	return anomaly.NewDetector(anomaly.Thresholds{
+		Window:              cfg.Window,
+		Cooldown:            cfg.Cooldown,
+		UnknownResponses:    cfg.UnknownThreshold,
+		Revocations:         cfg.RevocationThreshold,
+		SequentialSerialRun: cfg.ScanRunLength,
+	}, newAnomalyHooks(cfg.Hooks, logger)...)
+}
+
+func newAnomalyHooks(cfg config.AnomalyHooksConfig, logger *sharedlogger.Logger) []anomaly.Hook {
+	var hooks []anomaly.Hook
+	if cfg.Log {
+		hooks = append(hooks, anomaly.NewLogHook(logger))
+	}
+	if cfg.Metric {
+		hooks = append(hooks, anomaly.MetricHook{})
+	}
+	if cfg.WebhookURL != "" {
+		hooks = append(hooks, anomaly.NewWebhookHook(cfg.WebhookURL, logger))
+	}
+	return hooks
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/crl"
+	"github.com/gigvault/ocsp/internal/legacy"
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+const migrateResponderUsage = "usage: ocsp migrate-responder -url url -issuer cert [-format serials|certutil|ejbca] [-trusted cert]... [-overwrite] [-skip-failed] [-dry-run | -verify] <path>"
+
+// runMigrateResponder seeds the status database from a legacy OCSP responder's answers about
+// the serials in a list or CA export. Every answer is verified, and answers are compared with
+// the export's statuses; with -verify they are compared with the status database instead
+func runMigrateResponder(args []string) {
+	flags := flag.NewFlagSet("migrate-responder", flag.ExitOnError)
+	responderURL := flags.String("url", "", "URL of the legacy OCSP responder")
+	issuerPath := flags.String("issuer", "", "PEM certificate of the CA whose certificates are queried")
+	format := flags.String("format", "serials", "input format: serials (one hex serial per line), certutil or ejbca")
+	var trusted stringList
+	flags.Var(&trusted, "trusted", "PEM responder certificate trusted although the issuer did not delegate to it (repeatable)")
+	concurrency := flags.Int("concurrency", 8, "queries in flight")
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout of each query")
+	clockSkew := flags.Duration("clock-skew", time.Minute, "clock difference tolerated in the responder's validity windows")
+	get := flags.Bool("get", false, "send requests as GET where short enough, instead of POST")
+	overwrite := flags.Bool("overwrite", false, "replace statuses the database already holds instead of keeping them")
+	skipFailed := flags.Bool("skip-failed", false, "seed the verified answers although some serials failed or disagree")
+	dryRun := flags.Bool("dry-run", false, "query and compare without seeding the database")
+	verify := flags.Bool("verify", false, "compare the responder's answers with the status database instead of seeding it")
+	flags.Parse(args)
+	if flags.NArg() != 1 || *responderURL == "" || *issuerPath == "" || *concurrency <= 0 || (*dryRun && *verify) {
+		fmt.Fprintln(os.Stderr, migrateResponderUsage)
+		os.Exit(2)
+	}
+
+	serials, expected, err := readMigrationInput(*format, flags.Arg(0))
+	if err != nil {
+		migrateFail("Failed to read %s: %v", flags.Arg(0), err)
+	}
+	issuer, err := crl.LoadCertificate(*issuerPath)
+	if err != nil {
+		migrateFail("Failed to load issuer: %v", err)
+	}
+	var responders []*x509.Certificate
+	for _, path := range trusted {
+		cert, err := crl.LoadCertificate(path)
+		if err != nil {
+			migrateFail("Failed to load trusted responder %s: %v", path, err)
+		}
+		responders = append(responders, cert)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	scraper := legacy.NewScraper(legacy.ScrapeOptions{
+		URL:               *responderURL,
+		Issuer:            issuer,
+		TrustedResponders: responders,
+		Concurrency:       *concurrency,
+		Timeout:           *timeout,
+		UseGET:            *get,
+		ClockSkew:         *clockSkew,
+	})
+	fmt.Fprintf(os.Stderr, "Querying %s for %d serials\n", *responderURL, len(serials))
+	answers := scraper.Scrape(ctx, serials, func(done int) {
+		if done%1000 == 0 {
+			fmt.Fprintf(os.Stderr, "  %d/%d answered\n", done, len(serials))
+		}
+	})
+	if ctx.Err() != nil {
+		migrateFail("Interrupted; nothing seeded")
+	}
+
+	counts := make(map[string]int)
+	var failed []legacy.Answer
+	for _, answer := range answers {
+		if answer.Err != nil {
+			failed = append(failed, answer)
+			continue
+		}
+		counts[answer.Update.Status]++
+	}
+	fmt.Printf("Queried %d serials: %d good, %d revoked, %d unknown, %d failed\n",
+		len(answers), counts[storage.StatusGood], counts[storage.StatusRevoked], counts[storage.StatusUnknown], len(failed))
+	for _, answer := range failed {
+		fmt.Printf("  %s\tfailed\t%v\n", answer.Serial, answer.Err)
+	}
+
+	if *verify {
+		verifyMigration(ctx, answers, len(failed))
+		return
+	}
+
+	var mismatches []legacy.Mismatch
+	if expected != nil {
+		mismatches = legacy.Compare(answers, expected)
+		fmt.Printf("Compared with the %s export: %d mismatches\n", *format, len(mismatches))
+		printMismatches(mismatches, "export")
+	}
+	problems := len(failed) + len(mismatches)
+	if problems > 0 && !*skipFailed && !*dryRun {
+		migrateFail("Nothing seeded: rerun with the failed serials, or pass -skip-failed to seed the verified answers")
+	}
+
+	disagree := make(map[string]bool, len(mismatches))
+	for _, m := range mismatches {
+		disagree[m.Serial] = true
+	}
+	var seeded []legacy.Answer
+	var updates []storage.Update
+	for _, answer := range answers {
+		if answer.Err != nil || disagree[answer.Serial] || answer.Update.Status == storage.StatusUnknown {
+			continue
+		}
+		seeded = append(seeded, answer)
+		updates = append(updates, answer.Update)
+	}
+	if *dryRun {
+		fmt.Printf("Dry run: would seed %d statuses; nothing applied\n", len(updates))
+		if problems > 0 {
+			migrateFail("Legacy responder answers failed or disagree")
+		}
+		return
+	}
+
+	env := openCommandEnv(ctx)
+	defer env.Close()
+	if *overwrite {
+		if err := env.statuses.ApplyBatch(ctx, updates); err != nil {
+			env.fail("Seeding failed: %v", err)
+		}
+		fmt.Printf("Seeded %d statuses\n", len(updates))
+	} else {
+		inserted, err := env.statuses.InsertMissing(ctx, updates)
+		if err != nil {
+			env.fail("Seeding failed: %v", err)
+		}
+		fmt.Printf("Seeded %d statuses; %d already present were kept\n", inserted, len(updates)-inserted)
+	}
+
+	// Read back every seeded serial, so the cutover rests on what the database now answers
+	local, err := localStatuses(ctx, env.statuses, seeded)
+	if err != nil {
+		env.fail("Failed to read back seeded statuses: %v", err)
+	}
+	if mismatches := legacy.Compare(seeded, local); len(mismatches) > 0 {
+		printMismatches(mismatches, "local")
+		env.fail("Statuses do not read back as the legacy responder answered; -overwrite replaces those already present")
+	}
+	fmt.Printf("Read back %d seeded statuses: all match the legacy responder\n", len(seeded))
+}
+
+// verifyMigration compares the responder's answers with the status database and exits with
+// status 1 when any serial failed or disagrees
+func verifyMigration(ctx context.Context, answers []legacy.Answer, failed int) {
+	env := openCommandEnv(ctx)
+	defer env.Close()
+
+	local, err := localStatuses(ctx, env.statuses, answers)
+	if err != nil {
+		env.fail("Failed to read statuses: %v", err)
+	}
+	mismatches := legacy.Compare(answers, local)
+	fmt.Printf("Compared with the status database: %d mismatches\n", len(mismatches))
+	printMismatches(mismatches, "local")
+	if failed+len(mismatches) > 0 {
+		env.fail("Legacy responder and status database disagree")
+	}
+}
+
+// localStatuses reads the status database's records for the answered serials
+func localStatuses(ctx context.Context, store storage.Store, answers []legacy.Answer) (map[string]storage.Update, error) {
+	local := make(map[string]storage.Update, len(answers))
+	for _, answer := range answers {
+		if answer.Err != nil {
+			continue
+		}
+		rec, err := store.Get(ctx, answer.Serial)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("serial %s: %w", answer.Serial, err)
+		}
+		local[answer.Serial] = storage.Update{
+			Serial:           rec.Serial,
+			Status:           rec.Status,
+			RevokedAt:        rec.RevokedAt,
+			RevocationReason: rec.RevocationReason,
+		}
+	}
+	return local, nil
+}
+
+// readMigrationInput reads the serials to query and, from a CA export, the statuses the
+// answers are expected to match; expected is nil for a plain serial list
+func readMigrationInput(format, path string) ([]string, map[string]storage.Update, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	if format == "serials" {
+		serials, err := legacy.ReadSerials(file)
+		return serials, nil, err
+	}
+
+	updates, err := legacy.Parse(format, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	var serials []string
+	expected := make(map[string]storage.Update, len(updates))
+	for _, update := range updates {
+		if _, ok := expected[update.Serial]; !ok {
+			serials = append(serials, update.Serial)
+		}
+		expected[update.Serial] = update
+	}
+	return serials, expected, nil
+}
+
+func printMismatches(mismatches []legacy.Mismatch, other string) {
+	for _, m := range mismatches {
+		fmt.Printf("  %s\t%s\tresponder=%s\t%s=%s\n", m.Serial, m.Kind, m.Responder, other, m.Other)
+	}
+}
+
+func migrateFail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
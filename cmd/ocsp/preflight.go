@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gigvault/ocsp/internal/config"
+	"github.com/gigvault/ocsp/internal/preflight"
+	sharedlogger "github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// runPreflight checks what the configuration depends on before the service starts, logging
+// every problem and exiting when any is an error, or with preflight.strict a warning
+func runPreflight(ctx context.Context, cfg *config.Config, logger *sharedlogger.Logger) {
+	if !cfg.Preflight.Enabled {
+		return
+	}
+	report := preflight.Run(ctx, cfg, preflightOptions(cfg))
+	for _, p := range report.Problems {
+		fields := []zap.Field{zap.String("setting", p.Path), zap.String("problem", p.Message)}
+		if p.Severity == preflight.SeverityError {
+			logger.Error("Preflight check failed", fields...)
+		} else {
+			logger.Warn("Preflight check warned", fields...)
+		}
+	}
+	if err := report.Err(cfg.Preflight.Strict); err != nil {
+		logger.Fatal("Refusing to start: preflight checks failed",
+			zap.Int("errors", report.Count(preflight.SeverityError)), zap.Int("warnings", report.Count(preflight.SeverityWarning)))
+	}
+}
+
+// preflightOptions checks the main database, except in presigned mode, which runs without one
+func preflightOptions(cfg *config.Config) preflight.Options {
+	opts := preflight.Options{Timeout: cfg.Preflight.Timeout}
+	if !cfg.Presigned.Enabled {
+		opts.Connect = func(ctx context.Context) (preflight.Database, func(), error) {
+			pool, err := connectDB(ctx, cfg, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			return pool, pool.Close, nil
+		}
+	}
+	return opts
+}
+
+// runCheckConfig validates a configuration and runs the preflight checks against it without
+// starting the service, printing every problem found:
+// ocsp check-config [-config path] [-set path=value ...] [-json]
+func runCheckConfig(args []string) {
+	flags := flag.NewFlagSet("check-config", flag.ExitOnError)
+	configPath := flags.String("config", defaultConfigPath(), "path to the YAML configuration")
+	var overrides stringList
+	flags.Var(&overrides, "set", "override a setting by dotted YAML path, e.g. -set crl.enabled=true (repeatable)")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+
+	cfg, _, err := readConfig(*configPath, overrides)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if cfg.Preflight.Timeout <= 0 {
+		cfg.Preflight.Timeout = config.Default().Preflight.Timeout
+	}
+	report := preflight.Run(context.Background(), cfg, preflightOptions(cfg))
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		for _, p := range report.Problems {
+			fmt.Printf("%s\t%s\n", p.Severity, p)
+		}
+		fmt.Printf("%d errors, %d warnings\n", report.Count(preflight.SeverityError), report.Count(preflight.SeverityWarning))
+	}
+	if report.Err(cfg.Preflight.Strict) != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/anomaly"
+	"github.com/gigvault/ocsp/internal/api"
+	"github.com/gigvault/ocsp/internal/backup"
+	"github.com/gigvault/ocsp/internal/config"
+	"github.com/gigvault/ocsp/internal/crl"
+	"github.com/gigvault/ocsp/internal/deadline"
+	"github.com/gigvault/ocsp/internal/logging"
+	"github.com/gigvault/ocsp/internal/metering"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/presign"
+	"github.com/gigvault/ocsp/internal/throttle"
+	"github.com/gigvault/ocsp/internal/toprequests"
+	"github.com/gigvault/shared/api/proto/ocsp"
+	sharedlogger "github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// runPresign signs a response for every status in a backup archive with an offline key:
+// ocsp presign -issuer cert -key key -out bundle [-responder cert] [-validity d] [-workers n] [-format f] <backup>
+func runPresign(args []string) {
+	flags := flag.NewFlagSet("presign", flag.ExitOnError)
+	issuerPath := flags.String("issuer", "", "PEM issuer certificate")
+	keyPath := flags.String("key", "", "PEM private key of the issuer, or of the delegated responder")
+	responderPath := flags.String("responder", "", "PEM delegated OCSP responder certificate (default: the issuer signs)")
+	validity := flags.Duration("validity", 7*24*time.Hour, "how long the responses are valid")
+	out := flags.String("out", "", "path the bundle is written to")
+	workers := flags.Int("workers", 0, "responses signed in parallel (default: one per CPU)")
+	format := flags.String("format", "bundle", "output format: bundle (gzipped NDJSON) or mapped (indexed file served from disk)")
+	backupKey := flags.String("backup-key", os.Getenv("OCSP_BACKUP_SIGNING_KEY"), "key the backup archive was signed with (OCSP_BACKUP_SIGNING_KEY)")
+	flags.Parse(args)
+	if flags.NArg() != 1 || *issuerPath == "" || *keyPath == "" || *out == "" {
+		fmt.Fprintln(os.Stderr, "usage: ocsp presign -issuer cert -key key -out bundle [-responder cert] [-validity d] [-workers n] [-format f] <backup>")
+		os.Exit(2)
+	}
+	sign := presign.Sign
+	switch *format {
+	case "bundle":
+	case "mapped":
+		sign = presign.SignMapped
+	default:
+		presignFail("Unknown format %q: use bundle or mapped", *format)
+	}
+
+	issuer, err := crl.LoadCertificate(*issuerPath)
+	if err != nil {
+		presignFail("Failed to load issuer: %v", err)
+	}
+	signer, err := crl.LoadSigner(*keyPath)
+	if err != nil {
+		presignFail("Failed to load signing key: %v", err)
+	}
+	var responder *x509.Certificate
+	if *responderPath != "" {
+		if responder, err = crl.LoadCertificate(*responderPath); err != nil {
+			presignFail("Failed to load responder certificate: %v", err)
+		}
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		presignFail("Failed to open backup: %v", err)
+	}
+	defer file.Close()
+	ctx := context.Background()
+	archive, records, err := backup.Read(ctx, file, []byte(*backupKey))
+	if err != nil {
+		presignFail("Failed to read backup: %v", err)
+	}
+
+	bundle, err := os.Create(*out)
+	if err != nil {
+		presignFail("Failed to create bundle: %v", err)
+	}
+	summary, err := sign(ctx, bundle, records, presign.SignOptions{
+		Issuer:    issuer,
+		Responder: responder,
+		Signer:    signer,
+		Validity:  *validity,
+		Workers:   *workers,
+	})
+	if err == nil {
+		err = bundle.Close()
+	}
+	if err != nil {
+		bundle.Close()
+		os.Remove(*out)
+		presignFail("Signing failed: %v", err)
+	}
+	fmt.Printf("Signed %d responses from a backup taken %s, valid until %s",
+		summary.Responses, archive.CreatedAt.Format("2006-01-02 15:04:05 MST"), summary.NextUpdate.Format("2006-01-02 15:04:05 MST"))
+	if summary.Skipped > 0 {
+		fmt.Printf(" (%d invalid serials skipped)", summary.Skipped)
+	}
+	fmt.Println()
+}
+
+func presignFail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// runPresigned serves lookups and RFC 6960 requests from a presigned bundle only: no database,
+// no signing key, and no feature that writes statuses
+func runPresigned(ctx context.Context, stop context.CancelFunc, cfg *config.Config, configPath string, overrides []string, refreshAt time.Time, logger *sharedlogger.Logger) {
+	holder := &presign.Holder{}
+	load := func(cfg *config.Config) error {
+		issuer, err := crl.LoadCertificate(cfg.Presigned.IssuerCertPath)
+		if err != nil {
+			return err
+		}
+		src, err := holder.LoadFile(cfg.Presigned.BundlePath, issuer)
+		if err != nil {
+			return err
+		}
+		info := src.Info()
+		logger.Info("Loaded presigned bundle",
+			zap.String("format", info.Format),
+			zap.Int("responses", info.Responses),
+			zap.Time("this_update", info.ThisUpdate),
+			zap.Time("next_update", info.NextUpdate),
+		)
+		if time.Now().After(info.NextUpdate) {
+			logger.Warn("Presigned bundle has expired; sign and deliver a new one", zap.Time("next_update", info.NextUpdate))
+		}
+		return nil
+	}
+	if err := load(cfg); err != nil {
+		logger.Fatal("Failed to load presigned bundle", zap.Error(err))
+	}
+
+	reload := newReloader(configPath, overrides, cfg, refreshAt, logger)
+	reload.add("presigned", func(ctx context.Context, cfg *config.Config) error {
+		return load(cfg)
+	})
+	go reload.Run(ctx, cfg.Reload.WatchInterval)
+
+	handler := api.NewHTTPHandler(logging.Component(logger, logging.ComponentHTTP))
+	if cfg.Metrics.Enabled {
+		handler.Handle(cfg.Metrics.Path, metrics.Handler())
+	}
+	interceptors := []grpc.UnaryServerInterceptor{
+		metrics.UnaryServerInterceptor(),
+		deadline.UnaryServerInterceptor(grpcDeadlines(cfg.GRPC.Deadlines)),
+	}
+	var tracker *toprequests.Tracker
+	if cfg.TopRequests.Enabled {
+		tracker = newTopRequests(cfg.TopRequests)
+		handler.Register(api.NewTopRequestsHandler(tracker))
+		interceptors = append(interceptors, toprequests.UnaryServerInterceptor(tracker))
+		if cfg.TopRequests.MetricsTop > 0 {
+			go tracker.Run(ctx, cfg.TopRequests.PublishInterval, cfg.TopRequests.MetricsTop)
+		}
+	}
+
+	path := strings.TrimSuffix(cfg.Presigned.Path, "/")
+	responder := presign.NewResponder(holder, path, requestLimits(cfg))
+	meter := newMeter(ctx, cfg, logger)
+	if meter != nil {
+		defer meter.Close()
+		handler.Register(api.NewUsageHandler(meter))
+		interceptors = append(interceptors, metering.UnaryServerInterceptor(meter))
+	}
+	if observers := requestObservers(tracker, meter); observers != nil {
+		responder.SetObserver(observers)
+	}
+	if cfg.Anomaly.Enabled {
+		detector := newDetector(cfg.Anomaly, logging.Component(logger, logging.ComponentAnomaly))
+		interceptors = append(interceptors, anomaly.UnaryServerInterceptor(detector))
+		responder.SetLookupObserver(detector)
+	}
+	router := mountResponder(path, responder, handler.Routes())
+
+	grpcServer := newGRPCServer(cfg.GRPC, interceptors, logger)
+	ocsp.RegisterOCSPServiceServer(grpcServer, api.NewOCSPGRPCServer(presign.NewStore(holder)))
+
+	logger.Info("Serving presigned responses only", zap.String("path", path))
+	// An expired bundle still answers, but clients reject the responses
+	serve(cfg, router, grpcServer, []string{path}, path, throttle.New(cfg.RequestLimits.MaxConcurrentPerSource), func(ctx context.Context) error {
+		if next := holder.Source().Info().NextUpdate; time.Now().After(next) {
+			return fmt.Errorf("presigned bundle expired at %s", next.Format(time.RFC3339))
+		}
+		return nil
+	}, stop, logger)
+}
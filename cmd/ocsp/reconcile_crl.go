@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/gigvault/ocsp/internal/crl"
+)
+
+// runReconcileCRL compares a CRL against the status database: ocsp reconcile-crl <path|url>.
+// It exits with status 1 when discrepancies are found so scheduled audits can alert on it
+func runReconcileCRL(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: ocsp reconcile-crl <path|url>")
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	env := openCommandEnv(ctx)
+	defer env.Close()
+
+	importer, err := newCRLImporter(env.cfg.CRLImport, env.statuses, env.logger)
+	if err != nil {
+		env.fail("Failed to initialize CRL importer: %v", err)
+	}
+
+	var data []byte
+	source := args[0]
+	if isURL(source) {
+		data, err = importer.Fetch(ctx, source)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		env.fail("Failed to read CRL: %v", err)
+	}
+
+	list, err := crl.Parse(data)
+	if err == nil {
+		err = importer.Verify(list)
+	}
+	if err != nil {
+		env.fail("Invalid CRL: %v", err)
+	}
+
+	result, err := importer.Reconcile(ctx, list)
+	if err != nil {
+		env.fail("Reconciliation failed: %v", err)
+	}
+
+	fmt.Printf("Reconciled CRL from %s (number %s): %d CRL entries, %d revoked locally, %d discrepancies\n",
+		result.Issuer, result.Number, result.CRLEntries, result.LocalRevoked, len(result.Discrepancies))
+	for _, d := range result.Discrepancies {
+		fmt.Printf("  %s\t%s\tlocal=%s %s\tcrl=%s\n", d.Serial, d.Kind, d.LocalStatus, d.LocalReason, d.CRLReason)
+	}
+
+	if len(result.Discrepancies) > 0 {
+		env.fail("CRL and status database disagree")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/config"
+	sharedlogger "github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// reloader re-reads the configuration on SIGHUP, on file changes and before leased secrets expire,
+// and applies what can change without a restart
+type reloader struct {
+	path      string
+	overrides []string
+	logger    *sharedlogger.Logger
+
+	current   *config.Config
+	refreshAt time.Time
+	steps     []reloadStep
+}
+
+// reloadStep applies one component's reloadable settings. Steps load everything they need
+// before changing anything, so a failed step leaves its component as it was
+type reloadStep struct {
+	name  string
+	apply func(ctx context.Context, cfg *config.Config) error
+}
+
+func newReloader(path string, overrides []string, cfg *config.Config, refreshAt time.Time, logger *sharedlogger.Logger) *reloader {
+	return &reloader{path: path, overrides: overrides, current: cfg, refreshAt: refreshAt, logger: logger}
+}
+
+// add registers a reload step; steps run in registration order
+func (r *reloader) add(name string, apply func(ctx context.Context, cfg *config.Config) error) {
+	r.steps = append(r.steps, reloadStep{name: name, apply: apply})
+}
+
+// Run reloads on every SIGHUP, when secrets are due for refresh and, with a positive
+// watchInterval, whenever the config file's modification time changes, until the context is
+// cancelled
+func (r *reloader) Run(ctx context.Context, watchInterval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if watchInterval > 0 {
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	modTime := r.modTime()
+
+	for {
+		var refresh <-chan time.Time
+		var timer *time.Timer
+		if !r.refreshAt.IsZero() {
+			timer = time.NewTimer(time.Until(r.refreshAt))
+			refresh = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-hup:
+			r.logger.Info("Received SIGHUP, reloading configuration")
+		case <-refresh:
+			r.logger.Info("Refreshing secrets, reloading configuration")
+			// Retry a failed refresh rather than waiting for the next signal
+			r.refreshAt = time.Now().Add(time.Minute)
+		case <-tick:
+			latest := r.modTime()
+			if latest.Equal(modTime) {
+				continue
+			}
+			modTime = latest
+			r.logger.Info("Configuration file changed, reloading", zap.String("path", r.path))
+		}
+
+		if err := r.Reload(ctx); err != nil {
+			r.logger.Error("Configuration reload failed", zap.Error(err))
+		}
+	}
+}
+
+// Reload reads and validates the configuration and runs every step. An invalid file is
+// rejected as a whole; otherwise failed steps are reported and the others still apply
+func (r *reloader) Reload(ctx context.Context) error {
+	cfg, refreshAt, err := readConfig(r.path, r.overrides)
+	if err != nil {
+		return err
+	}
+	r.refreshAt = refreshAt
+
+	var errs []error
+	for _, step := range r.steps {
+		if err := step.apply(ctx, cfg); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", step.name, err))
+			continue
+		}
+		r.logger.Info("Reloaded", zap.String("component", step.name))
+	}
+
+	if restartRequired(r.current, cfg) {
+		r.logger.Warn("Configuration changes outside issuers, keys, CRL validity and feature flags take effect after a restart")
+	}
+	r.current = cfg
+	return errors.Join(errs...)
+}
+
+func (r *reloader) modTime() time.Time {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// restartRequired reports whether the configurations differ in anything besides the settings
+// the reload steps apply
+func restartRequired(old, new *config.Config) bool {
+	a, b := *old, *new
+	for _, cfg := range []*config.Config{&a, &b} {
+		cfg.CRLImport.IssuerCertPath = ""
+		cfg.CRLSync.Sources = nil
+		cfg.CRL.IssuerCertPath, cfg.CRL.IssuerKeyPath = "", ""
+		cfg.CRL.Validity, cfg.CRL.Delta.Validity = 0, 0
+		cfg.UpstreamOCSP.IssuerCertPath = ""
+		cfg.FeatureFlags.Rules = nil
+		cfg.Presigned.BundlePath, cfg.Presigned.IssuerCertPath = "", ""
+		cfg.Database.User, cfg.Database.Password = "", ""
+		cfg.Approvals.PrincipalsPath = ""
+		cfg.Audit.SigningKeyPath, cfg.Audit.CertificatePath = "", ""
+		cfg.Compromise.StandbyCertPath, cfg.Compromise.StandbyKeyPath = "", ""
+	}
+	return !reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+// runReshard moves statuses to the shard they hash to after shards were added or retired:
+// ocsp reshard [-dry-run]. sharding.resharding must be set while it runs and until it is done
+func runReshard(args []string) {
+	flags := flag.NewFlagSet("reshard", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "count the statuses that would move without moving them")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: ocsp reshard [-dry-run]")
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	env := openCommandEnv(ctx)
+	defer env.Close()
+
+	if env.sharded == nil {
+		env.fail("Resharding requires sharding.enabled")
+	}
+	if !env.cfg.Sharding.Resharding && !*dryRun {
+		env.fail("Set sharding.resharding on every instance before moving statuses")
+	}
+
+	progress := func(r storage.ReshardReport) {
+		fmt.Fprintf(os.Stderr, "  %s: scanned %d, moved %d\n", r.Shard, r.Scanned, r.Moved)
+	}
+	reports, err := env.sharded.Reshard(ctx, *dryRun, progress)
+	for _, r := range reports {
+		fmt.Printf("%s\tscanned=%d moved=%d superseded=%d skipped=%d\n", r.Shard, r.Scanned, r.Moved, r.Superseded, r.Skipped)
+	}
+	if err != nil {
+		env.fail("Resharding failed: %v", err)
+	}
+	if *dryRun {
+		fmt.Println("Dry run: no statuses were moved")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gigvault/ocsp/internal/api"
+	"github.com/gigvault/ocsp/internal/config"
+	"github.com/gigvault/ocsp/internal/crl"
+	"github.com/gigvault/ocsp/internal/deadline"
+	"github.com/gigvault/ocsp/internal/logging"
+	"github.com/gigvault/ocsp/internal/metering"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/mode"
+	"github.com/gigvault/ocsp/internal/precomputed"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/internal/throttle"
+	"github.com/gigvault/ocsp/internal/toprequests"
+	"github.com/gigvault/shared/api/proto/ocsp"
+	"github.com/gigvault/shared/pkg/db"
+	sharedlogger "github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// writable is a database table the responder role must not be able to change
+type writable interface {
+	Writable(ctx context.Context) (bool, error)
+}
+
+// runResponder serves the responder role: RFC 6960 requests from the responses the writer
+// role presigns into the database, and gRPC lookups, with no signing key and nothing that
+// writes. The database user should only be granted SELECT; startup fails if it can change
+// statuses or signed responses
+func runResponder(ctx context.Context, stop context.CancelFunc, cfg *config.Config, logger *sharedlogger.Logger) {
+	pool, err := connectDB(ctx, cfg, nil)
+	if err != nil {
+		logger.Fatal("Failed to connect to database", zap.Error(err))
+	}
+	defer db.Close(pool)
+
+	issuer, err := crl.LoadCertificate(cfg.Precomputed.IssuerCertPath)
+	if err != nil {
+		logger.Fatal("Failed to load precomputed response issuer", zap.Error(err))
+	}
+	table, err := precomputed.NewTable(pool, issuer)
+	if err != nil {
+		logger.Fatal("Failed to initialize precomputed responses", zap.Error(err))
+	}
+
+	// Precomputed responses rule out sharding, so statuses are in the main database too
+	statuses := storage.NewPostgres(pool)
+	for name, table := range map[string]writable{"signed_responses": table, "ocsp_responses": statuses} {
+		canWrite, err := table.Writable(ctx)
+		if err != nil {
+			logger.Fatal("Failed to check database privileges", zap.String("table", name), zap.Error(err))
+		}
+		if canWrite {
+			logger.Fatal("Refusing to serve the responder role with a database user that can write; grant it SELECT only", zap.String("table", name))
+		}
+	}
+
+	// Mutations are refused before they reach the database
+	readOnly, err := mode.NewSwitch(mode.ReadOnly, cfg.Mode.RetryAfter, logger)
+	if err != nil {
+		logger.Fatal("Invalid operating mode", zap.Error(err))
+	}
+
+	handler := api.NewHTTPHandler(logging.Component(logger, logging.ComponentHTTP))
+	if cfg.Metrics.Enabled {
+		handler.Handle(cfg.Metrics.Path, metrics.Handler())
+	}
+	interceptors := []grpc.UnaryServerInterceptor{
+		metrics.UnaryServerInterceptor(),
+		deadline.UnaryServerInterceptor(grpcDeadlines(cfg.GRPC.Deadlines)),
+	}
+	var tracker *toprequests.Tracker
+	if cfg.TopRequests.Enabled {
+		tracker = newTopRequests(cfg.TopRequests)
+		handler.Register(api.NewTopRequestsHandler(tracker))
+		interceptors = append(interceptors, toprequests.UnaryServerInterceptor(tracker))
+		if cfg.TopRequests.MetricsTop > 0 {
+			go tracker.Run(ctx, cfg.TopRequests.PublishInterval, cfg.TopRequests.MetricsTop)
+		}
+	}
+
+	path := strings.TrimSuffix(cfg.Precomputed.Path, "/")
+	responder, err := precomputed.NewResponder(table, issuer, cfg.Precomputed.Path, requestLimits(cfg), logging.Component(logger, logging.ComponentHTTP))
+	if err != nil {
+		logger.Fatal("Failed to initialize precomputed responder", zap.Error(err))
+	}
+	meter := newMeter(ctx, cfg, logger)
+	if meter != nil {
+		defer meter.Close()
+		handler.Register(api.NewUsageHandler(meter))
+		interceptors = append(interceptors, metering.UnaryServerInterceptor(meter))
+	}
+	if observers := requestObservers(tracker, meter); observers != nil {
+		responder.SetObserver(observers)
+	}
+	if cfg.SelfCheck.Enabled {
+		handler.Handle(cfg.SelfCheck.Path, newSelfCheck(cfg, statuses, issuer, nil, responder, logger))
+	}
+	router := mountResponder(path, responder, handler.Routes())
+
+	grpcServer := newGRPCServer(cfg.GRPC, interceptors, logger)
+	ocsp.RegisterOCSPServiceServer(grpcServer, api.NewOCSPGRPCServer(mode.NewStore(statuses, readOnly)))
+
+	logger.Info("Serving the responder role", zap.String("path", path))
+	serve(cfg, router, grpcServer, []string{path}, path, throttle.New(cfg.RequestLimits.MaxConcurrentPerSource), func(ctx context.Context) error {
+		return pool.Ping(ctx)
+	}, stop, logger)
+}
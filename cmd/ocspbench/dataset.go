@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// revokedEvery makes one serial in this many revoked in the seeded dataset, about the share
+// of a busy public CA
+const revokedEvery = 50
+
+// seedChunk is how many statuses are copied per statement while seeding
+const seedChunk = 100_000
+
+// dataset is a database seeded with a deterministic set of statuses, so serials can be
+// derived from their index rather than held in memory
+type dataset struct {
+	pool    *pgxpool.Pool
+	store   *storage.Postgres
+	serials int
+}
+
+// openDataset connects to url and seeds it with n statuses if it is empty. A database holding
+// exactly n statuses is assumed to be seeded already; any other is refused, so the benchmark
+// never runs against a database it did not create
+func openDataset(ctx context.Context, url string, n int) (*dataset, error) {
+	pool, err := pgxpool.New(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	d := &dataset{pool: pool, store: storage.NewPostgres(pool), serials: n}
+
+	var count int
+	if err := pool.QueryRow(ctx, `SELECT COUNT(*) FROM ocsp_responses`).Scan(&count); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	switch count {
+	case n:
+		return d, nil
+	case 0:
+		if err := d.seed(ctx); err != nil {
+			pool.Close()
+			return nil, err
+		}
+		return d, nil
+	default:
+		pool.Close()
+		return nil, fmt.Errorf("database holds %d statuses, not the %d of this dataset; use an empty database", count, n)
+	}
+}
+
+// seed copies the dataset in and analyzes the table so plans match a long-lived database
+func (d *dataset) seed(ctx context.Context) error {
+	started := time.Now()
+	now := started.UTC()
+	nextUpdate := now.Add(7 * 24 * time.Hour)
+	revokedAt := now.Add(-24 * time.Hour)
+	for start := 0; start < d.serials; start += seedChunk {
+		end := min(start+seedChunk, d.serials)
+		rows := make([][]interface{}, 0, end-start)
+		for i := start; i < end; i++ {
+			if i%revokedEvery == 0 {
+				rows = append(rows, []interface{}{serialAt(i), storage.StatusRevoked, now, nextUpdate, revokedAt, "keyCompromise"})
+			} else {
+				rows = append(rows, []interface{}{serialAt(i), storage.StatusGood, now, nextUpdate, nil, ""})
+			}
+		}
+		_, err := d.pool.CopyFrom(ctx, pgx.Identifier{"ocsp_responses"},
+			[]string{"serial", "status", "this_update", "next_update", "revoked_at", "revocation_reason"},
+			pgx.CopyFromRows(rows))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "  seeded %d/%d statuses\n", end, d.serials)
+	}
+	if _, err := d.pool.Exec(ctx, `ANALYZE ocsp_responses`); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Seeded %d statuses in %s\n", d.serials, time.Since(started).Round(time.Second))
+	return nil
+}
+
+func (d *dataset) Close() {
+	d.pool.Close()
+}
+
+// serialAt returns the i-th serial of the dataset: 128 bits that look random, as CA serials
+// do, so index locality matches production. Serials beyond the dataset's size are absent
+func serialAt(i int) string {
+	hi, lo := mix(uint64(i)), mix(uint64(i)^0x9e3779b97f4a7c15)
+	hi = hi&^(1<<63) | 1<<60 // positive, and no leading zero nibble
+	return fmt.Sprintf("%x%016x", hi, lo)
+}
+
+// mix is the splitmix64 finalizer
+func mix(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
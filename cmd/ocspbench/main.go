@@ -0,0 +1,287 @@
+// Command ocspbench measures the storage, presigned serving and signing layers against a
+// seeded dataset, reporting latency, CPU and allocations per scenario, and fails when a run
+// regresses against a saved baseline
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: ocspbench [flags]
+
+Scenarios:
+  lookup-hot      status lookups cycling through 1000 serials, served from the database cache
+  lookup-cold     status lookups of serials drawn from the whole dataset
+  import          batch imports of new serials, removed again afterwards
+  mass-revoke     revocation of -revocations serials in batches, then the revoked list
+  sign            offline signing of -sign-serials responses
+  presigned       RFC 6960 requests answered from an in-memory presigned bundle
+
+Database scenarios need -db naming a database with the migrations applied. An empty
+database is seeded with -serials statuses; a database seeded earlier with the same count is
+reused. Other databases are refused.
+
+Flags:
+`
+
+// result is the outcome of one scenario
+type result struct {
+	Scenario    string        `json:"scenario"`
+	Ops         int           `json:"ops"`
+	Rows        int           `json:"rows,omitempty"`
+	Duration    time.Duration `json:"duration_ns"`
+	NsPerOp     float64       `json:"ns_per_op"`
+	OpsPerSec   float64       `json:"ops_per_sec"`
+	P50         time.Duration `json:"p50_ns"`
+	P99         time.Duration `json:"p99_ns"`
+	AllocsPerOp float64       `json:"allocs_per_op"`
+	BytesPerOp  float64       `json:"bytes_per_op"`
+}
+
+// report is what -json writes and -baseline reads
+type report struct {
+	Serials   int       `json:"serials"`
+	GoVersion string    `json:"go_version"`
+	CPUs      int       `json:"cpus"`
+	StartedAt time.Time `json:"started_at"`
+	Results   []result  `json:"results"`
+}
+
+type options struct {
+	db            string
+	serials       int
+	ops           int
+	concurrency   int
+	batch         int
+	revocations   int
+	signSerials   int
+	profileDir    string
+	jsonPath      string
+	baselinePath  string
+	maxRegression float64
+}
+
+func main() {
+	flags := flag.NewFlagSet("ocspbench", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	var opts options
+	flags.StringVar(&opts.db, "db", os.Getenv("OCSP_BENCH_DB"), "Postgres URL of the benchmark database (OCSP_BENCH_DB)")
+	flags.IntVar(&opts.serials, "serials", 1_000_000, "statuses in the dataset")
+	flags.IntVar(&opts.ops, "ops", 100_000, "lookups and presigned requests per scenario")
+	flags.IntVar(&opts.concurrency, "concurrency", runtime.GOMAXPROCS(0)*4, "concurrent lookups and requests")
+	flags.IntVar(&opts.batch, "batch", 1000, "updates per batch in import and mass-revoke")
+	flags.IntVar(&opts.revocations, "revocations", 100_000, "serials revoked by mass-revoke")
+	flags.IntVar(&opts.signSerials, "sign-serials", 20_000, "responses signed by sign and served by presigned")
+	flags.StringVar(&opts.profileDir, "profile", "", "directory for per-scenario CPU and allocation profiles")
+	flags.StringVar(&opts.jsonPath, "json", "", "write the results as JSON to this path")
+	flags.StringVar(&opts.baselinePath, "baseline", "", "JSON results of an earlier run to compare against")
+	flags.Float64Var(&opts.maxRegression, "max-regression", 0.10, "fail when ns/op or allocs/op grow by more than this fraction over the baseline")
+	scenarios := flags.String("scenarios", "lookup-hot,lookup-cold,import,mass-revoke,sign,presigned", "comma-separated scenarios to run")
+	flags.Parse(os.Args[1:])
+
+	ctx := context.Background()
+	rep := report{Serials: opts.serials, GoVersion: runtime.Version(), CPUs: runtime.GOMAXPROCS(0), StartedAt: time.Now().UTC()}
+
+	var db *dataset
+	for _, name := range strings.Split(*scenarios, ",") {
+		s, ok := registry[name]
+		if !ok {
+			fail("Unknown scenario %q", name)
+		}
+		if s.needsDB && db == nil {
+			if opts.db == "" {
+				fmt.Fprintf(os.Stderr, "Skipping %s: no -db given\n", name)
+				continue
+			}
+			var err error
+			if db, err = openDataset(ctx, opts.db, opts.serials); err != nil {
+				fail("Failed to prepare dataset: %v", err)
+			}
+			defer db.Close()
+		}
+
+		fmt.Fprintf(os.Stderr, "Running %s...\n", name)
+		res, err := s.run(ctx, db, opts)
+		if err != nil {
+			fail("%s failed: %v", name, err)
+		}
+		res.Scenario = name
+		rep.Results = append(rep.Results, res)
+	}
+
+	printResults(rep.Results)
+	if opts.jsonPath != "" {
+		data, err := json.MarshalIndent(rep, "", "  ")
+		if err == nil {
+			err = os.WriteFile(opts.jsonPath, append(data, '\n'), 0o644)
+		}
+		if err != nil {
+			fail("Failed to write results: %v", err)
+		}
+	}
+	if opts.baselinePath != "" {
+		if regressions := compare(opts.baselinePath, rep, opts.maxRegression); len(regressions) > 0 {
+			for _, r := range regressions {
+				fmt.Fprintln(os.Stderr, r)
+			}
+			fail("%d regressions against %s", len(regressions), opts.baselinePath)
+		}
+	}
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// measure runs ops calls of op across concurrency workers, recording each call's latency and
+// the allocations of the whole run. op receives the index of the call. With a profile
+// directory, CPU and allocation profiles of the run are written under the scenario's name
+func measure(name string, opts options, ops, concurrency int, op func(i int) error) (result, error) {
+	latencies := make([]time.Duration, ops)
+	var next atomic.Int64
+	var failed atomic.Pointer[error]
+
+	stopProfile, err := startProfile(opts.profileDir, name)
+	if err != nil {
+		return result{}, err
+	}
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	started := time.Now()
+
+	var wg sync.WaitGroup
+	for range min(concurrency, ops) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= ops || failed.Load() != nil {
+					return
+				}
+				opStarted := time.Now()
+				if err := op(i); err != nil {
+					failed.CompareAndSwap(nil, &err)
+					return
+				}
+				latencies[i] = time.Since(opStarted)
+			}
+		}()
+	}
+	wg.Wait()
+
+	elapsed := time.Since(started)
+	runtime.ReadMemStats(&after)
+	if err := stopProfile(); err != nil {
+		return result{}, err
+	}
+	if err := failed.Load(); err != nil {
+		return result{}, *err
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return result{
+		Ops:         ops,
+		Duration:    elapsed,
+		NsPerOp:     float64(elapsed.Nanoseconds()) / float64(ops),
+		OpsPerSec:   float64(ops) / elapsed.Seconds(),
+		P50:         latencies[ops/2],
+		P99:         latencies[ops*99/100],
+		AllocsPerOp: float64(after.Mallocs-before.Mallocs) / float64(ops),
+		BytesPerOp:  float64(after.TotalAlloc-before.TotalAlloc) / float64(ops),
+	}, nil
+}
+
+// startProfile starts a CPU profile for the scenario; the returned function stops it and
+// writes the allocation profile
+func startProfile(dir, name string) (func() error, error) {
+	if dir == "" {
+		return func() error { return nil }, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	cpu, err := os.Create(filepath.Join(dir, name+".cpu.pprof"))
+	if err != nil {
+		return nil, err
+	}
+	if err := pprof.StartCPUProfile(cpu); err != nil {
+		cpu.Close()
+		return nil, err
+	}
+	return func() error {
+		pprof.StopCPUProfile()
+		if err := cpu.Close(); err != nil {
+			return err
+		}
+		allocs, err := os.Create(filepath.Join(dir, name+".allocs.pprof"))
+		if err != nil {
+			return err
+		}
+		defer allocs.Close()
+		return pprof.Lookup("allocs").WriteTo(allocs, 0)
+	}, nil
+}
+
+func printResults(results []result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "scenario\tops\tns/op\tops/s\tp50\tp99\tallocs/op\tB/op\t")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%d\t%.0f\t%.0f\t%s\t%s\t%.1f\t%.0f\t\n",
+			r.Scenario, r.Ops, r.NsPerOp, r.OpsPerSec, r.P50.Round(time.Microsecond), r.P99.Round(time.Microsecond), r.AllocsPerOp, r.BytesPerOp)
+	}
+	w.Flush()
+}
+
+// compare lists the scenarios whose ns/op or allocs/op grew by more than maxRegression over
+// the baseline. Scenarios missing from either run are not compared
+func compare(path string, current report, maxRegression float64) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fail("Failed to read baseline: %v", err)
+	}
+	var baseline report
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		fail("Invalid baseline: %v", err)
+	}
+	if baseline.Serials != current.Serials {
+		fmt.Fprintf(os.Stderr, "Baseline was taken with %d serials, this run with %d\n", baseline.Serials, current.Serials)
+	}
+
+	previous := make(map[string]result, len(baseline.Results))
+	for _, r := range baseline.Results {
+		previous[r.Scenario] = r
+	}
+	var regressions []string
+	for _, r := range current.Results {
+		base, ok := previous[r.Scenario]
+		if !ok {
+			continue
+		}
+		if base.NsPerOp > 0 && r.NsPerOp > base.NsPerOp*(1+maxRegression) {
+			regressions = append(regressions, fmt.Sprintf("%s: %.0f ns/op, baseline %.0f", r.Scenario, r.NsPerOp, base.NsPerOp))
+		}
+		if base.AllocsPerOp > 0 && r.AllocsPerOp > base.AllocsPerOp*(1+maxRegression) {
+			regressions = append(regressions, fmt.Sprintf("%s: %.1f allocs/op, baseline %.1f", r.Scenario, r.AllocsPerOp, base.AllocsPerOp))
+		}
+	}
+	return regressions
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	mathrand "math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"github.com/gigvault/ocsp/internal/presign"
+	"github.com/gigvault/ocsp/internal/storage"
+	"golang.org/x/crypto/ocsp"
+)
+
+// hotSerials is the working set of lookup-hot, small enough to stay in the database cache
+const hotSerials = 1000
+
+type scenario struct {
+	needsDB bool
+	run     func(ctx context.Context, d *dataset, opts options) (result, error)
+}
+
+var registry = map[string]scenario{
+	"lookup-hot":  {needsDB: true, run: lookupHot},
+	"lookup-cold": {needsDB: true, run: lookupCold},
+	"import":      {needsDB: true, run: batchImport},
+	"mass-revoke": {needsDB: true, run: massRevoke},
+	"sign":        {run: signResponses},
+	"presigned":   {run: presignedRequests},
+}
+
+func lookupHot(ctx context.Context, d *dataset, opts options) (result, error) {
+	return measure("lookup-hot", opts, opts.ops, opts.concurrency, func(i int) error {
+		_, err := d.store.Get(ctx, serialAt(i%min(hotSerials, d.serials)))
+		return err
+	})
+}
+
+// lookupCold draws serials from the whole dataset; on a dataset larger than the database
+// cache most lookups read from disk
+func lookupCold(ctx context.Context, d *dataset, opts options) (result, error) {
+	order := mathrand.New(mathrand.NewSource(1)).Perm(d.serials)
+	return measure("lookup-cold", opts, opts.ops, opts.concurrency, func(i int) error {
+		_, err := d.store.Get(ctx, serialAt(order[i%len(order)]))
+		return err
+	})
+}
+
+// batchImport applies batches of new serials, then deletes them so the dataset is unchanged
+func batchImport(ctx context.Context, d *dataset, opts options) (result, error) {
+	batches := max(1, opts.revocations/opts.batch)
+	serials := make([]string, 0, batches*opts.batch)
+	for i := 0; i < batches*opts.batch; i++ {
+		serials = append(serials, serialAt(d.serials+i))
+	}
+	defer d.pool.Exec(context.WithoutCancel(ctx), `DELETE FROM ocsp_responses WHERE serial = ANY($1)`, serials)
+
+	res, err := measure("import", opts, batches, 1, func(i int) error {
+		updates := make([]storage.Update, opts.batch)
+		for j := range updates {
+			updates[j] = storage.Update{Serial: serials[i*opts.batch+j], Status: storage.StatusGood}
+		}
+		return d.store.ApplyBatch(ctx, updates)
+	})
+	res.Rows = batches * opts.batch
+	return res, err
+}
+
+// massRevoke revokes good serials in batches, lists the revoked serials as a CRL build would,
+// then marks the serials good again
+func massRevoke(ctx context.Context, d *dataset, opts options) (result, error) {
+	var serials []string
+	for i := 1; len(serials) < opts.revocations && i < d.serials; i++ {
+		if i%revokedEvery != 0 {
+			serials = append(serials, serialAt(i))
+		}
+	}
+	batches := max(1, len(serials)/opts.batch)
+	revokedAt := time.Now().UTC()
+	apply := func(i int, status string) error {
+		updates := make([]storage.Update, 0, opts.batch)
+		for _, serial := range serials[i*opts.batch : min((i+1)*opts.batch, len(serials))] {
+			update := storage.Update{Serial: serial, Status: status}
+			if status == storage.StatusRevoked {
+				update.RevokedAt, update.RevocationReason = &revokedAt, "keyCompromise"
+			}
+			updates = append(updates, update)
+		}
+		return d.store.ApplyBatch(ctx, updates)
+	}
+	defer func() {
+		for i := 0; i < batches; i++ {
+			apply(i, storage.StatusGood)
+		}
+	}()
+
+	res, err := measure("mass-revoke", opts, batches+1, 1, func(i int) error {
+		if i < batches {
+			return apply(i, storage.StatusRevoked)
+		}
+		revoked, err := d.store.ListRevoked(ctx)
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "  listed %d revoked serials\n", len(revoked))
+		}
+		return err
+	})
+	res.Rows = batches * opts.batch
+	return res, err
+}
+
+// signResponses signs the dataset's first serials offline, one op per response
+func signResponses(ctx context.Context, d *dataset, opts options) (result, error) {
+	issuer, key, err := benchIssuer()
+	if err != nil {
+		return result{}, err
+	}
+	records := benchRecords(opts.signSerials)
+	res, err := measure("sign", opts, 1, 1, func(int) error {
+		_, err := presign.Sign(ctx, io.Discard, records, presign.SignOptions{Issuer: issuer, Signer: key, Validity: time.Hour})
+		return err
+	})
+	// One op signed every record; report per response
+	res.Ops, res.Rows = len(records), len(records)
+	res.NsPerOp /= float64(len(records))
+	res.OpsPerSec *= float64(len(records))
+	res.AllocsPerOp /= float64(len(records))
+	res.BytesPerOp /= float64(len(records))
+	res.P50, res.P99 = 0, 0
+	return res, err
+}
+
+// presignedRequests serves POSTed RFC 6960 requests for random serials of a signed bundle.
+// Allocations include building each test request and recorder
+func presignedRequests(ctx context.Context, d *dataset, opts options) (result, error) {
+	issuer, key, err := benchIssuer()
+	if err != nil {
+		return result{}, err
+	}
+	records := benchRecords(opts.signSerials)
+	dir, err := os.MkdirTemp("", "ocspbench")
+	if err != nil {
+		return result{}, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bundle.ndjson.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		return result{}, err
+	}
+	_, err = presign.Sign(ctx, file, records, presign.SignOptions{Issuer: issuer, Signer: key, Validity: time.Hour})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return result{}, err
+	}
+	holder := &presign.Holder{}
+	if _, err := holder.LoadFile(path, issuer); err != nil {
+		return result{}, err
+	}
+	responder := presign.NewResponder(holder, "/ocsp", ocspreq.Limits{MaxBodyBytes: 10 << 10, MaxCertIDs: 1})
+
+	requests := make([][]byte, 1024)
+	random := mathrand.New(mathrand.NewSource(1))
+	for i := range requests {
+		serial, _ := new(big.Int).SetString(records[random.Intn(len(records))].Serial, 16)
+		req, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: serial}, issuer, &ocsp.RequestOptions{Hash: crypto.SHA1})
+		if err != nil {
+			return result{}, err
+		}
+		requests[i] = req
+	}
+
+	return measure("presigned", opts, opts.ops, opts.concurrency, func(i int) error {
+		req := httptest.NewRequest(http.MethodPost, "/ocsp", bytes.NewReader(requests[i%len(requests)]))
+		req.Header.Set("Content-Type", "application/ocsp-request")
+		rec := httptest.NewRecorder()
+		responder.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || rec.Body.Len() < 100 {
+			return fmt.Errorf("unexpected response: %d, %d bytes", rec.Code, rec.Body.Len())
+		}
+		return nil
+	})
+}
+
+// benchRecords returns the first n serials of the dataset with its share of revocations
+func benchRecords(n int) []storage.Record {
+	now := time.Now().UTC()
+	records := make([]storage.Record, n)
+	for i := range records {
+		records[i] = storage.Record{Serial: serialAt(i), Status: storage.StatusGood, ThisUpdate: now}
+		if i%revokedEvery == 0 {
+			records[i].Status, records[i].RevocationReason = storage.StatusRevoked, "keyCompromise"
+		}
+	}
+	return records
+}
+
+// benchIssuer creates a throwaway P-256 issuer
+func benchIssuer() (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ocspbench issuer"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+type outcome int
+
+const (
+	outcomePass outcome = iota
+	outcomeWarn
+	outcomeFail
+	outcomeSkip
+)
+
+func (o outcome) String() string {
+	return [...]string{"PASS", "WARN", "FAIL", "SKIP"}[o]
+}
+
+// result is the outcome of one check
+type result struct {
+	name    string
+	outcome outcome
+	detail  string
+}
+
+// clockSkew is how far ahead of the local clock a response may be dated
+const clockSkew = 5 * time.Minute
+
+// suite checks one responder. revoked may be nil, which skips the checks needing a revoked
+// certificate; stranger is an issuer the responder does not serve
+type suite struct {
+	client   *http.Client
+	url      string
+	issuer   *x509.Certificate
+	stranger *x509.Certificate
+	good     *big.Int
+	revoked  *big.Int
+	unknown  *big.Int
+}
+
+// exchange is one HTTP round trip
+type exchange struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (s *suite) post(der []byte) (*exchange, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(der))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	return s.do(req)
+}
+
+// get sends der base64-encoded in the path; with escape, every reserved character is
+// percent-encoded, as OpenSSL and most browsers send it
+func (s *suite) get(der []byte, escape bool) (*exchange, error) {
+	encoded := base64.StdEncoding.EncodeToString(der)
+	if escape {
+		encoded = url.QueryEscape(encoded)
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(s.url, "/")+"/"+encoded, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.do(req)
+}
+
+func (s *suite) do(req *http.Request) (*exchange, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	return &exchange{status: resp.StatusCode, header: resp.Header, body: body}, nil
+}
+
+// checks lists the checks in the order they run
+func (s *suite) checks() []struct {
+	name string
+	run  func() (outcome, string)
+} {
+	return []struct {
+		name string
+		run  func() (outcome, string)
+	}{
+		{"post-sha1-good", func() (outcome, string) { return s.lookup(s.good, crypto.SHA1, ocsp.Good, s.post) }},
+		{"post-sha256-good", func() (outcome, string) { return s.lookup(s.good, crypto.SHA256, ocsp.Good, s.post) }},
+		{"get-good", func() (outcome, string) { return s.lookup(s.good, crypto.SHA1, ocsp.Good, s.getRaw) }},
+		{"get-escaped-good", func() (outcome, string) {
+			return s.lookup(s.good, crypto.SHA1, ocsp.Good, func(der []byte) (*exchange, error) { return s.get(der, true) })
+		}},
+		{"post-revoked", func() (outcome, string) {
+			if s.revoked == nil {
+				return outcomeSkip, "no revoked serial given"
+			}
+			return s.lookup(s.revoked, crypto.SHA1, ocsp.Revoked, s.post)
+		}},
+		{"nonce", s.nonce},
+		{"multi-cert", s.multiCert},
+		{"unknown-issuer", s.unknownIssuer},
+		{"unknown-serial", s.unknownSerial},
+		{"malformed", s.malformed},
+		{"method-not-allowed", s.methodNotAllowed},
+	}
+}
+
+// run runs every check
+func (s *suite) run() []result {
+	var results []result
+	for _, check := range s.checks() {
+		outcome, detail := check.run()
+		results = append(results, result{name: check.name, outcome: outcome, detail: detail})
+	}
+	return results
+}
+
+func (s *suite) getRaw(der []byte) (*exchange, error) {
+	return s.get(der, false)
+}
+
+// lookup asks for one serial and checks the response carries want
+func (s *suite) lookup(serial *big.Int, hash crypto.Hash, want int, send func([]byte) (*exchange, error)) (outcome, string) {
+	id := certID{issuer: s.issuer, serial: serial, hash: hash}
+	der, err := buildRequest([]certID{id}, nil)
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	ex, err := send(der)
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	resp, warnings, err := s.verify(ex, id)
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	if resp.Status != want {
+		return outcomeFail, fmt.Sprintf("status %s, want %s", statusName(resp.Status), statusName(want))
+	}
+	if want == ocsp.Revoked && resp.RevokedAt.After(time.Now().Add(clockSkew)) {
+		return outcomeFail, "revocationTime is in the future"
+	}
+	if cacheControl := ex.header.Get("Cache-Control"); !strings.Contains(cacheControl, "max-age") {
+		warnings = append(warnings, "no Cache-Control max-age (RFC 5019 section 6.2)")
+	}
+	return report(warnings, statusName(resp.Status))
+}
+
+// verify checks a successful response to a request for id: the HTTP status and content type,
+// a single DER encoding, a signature by the issuer or by a responder it authorized for OCSP
+// signing, the serial, and a current validity period. A CertID hashed differently from the
+// request is a warning: RFC 6960 clients match CertIDs exactly, so OpenSSL finds no status
+func (s *suite) verify(ex *exchange, id certID) (*ocsp.Response, []string, error) {
+	if ex.status != http.StatusOK {
+		return nil, nil, fmt.Errorf("HTTP status %d", ex.status)
+	}
+	if contentType := ex.header.Get("Content-Type"); contentType != "application/ocsp-response" {
+		return nil, nil, fmt.Errorf("Content-Type %q, want application/ocsp-response", contentType)
+	}
+	var raw asn1.RawValue
+	if rest, err := asn1.Unmarshal(ex.body, &raw); err != nil || len(rest) > 0 {
+		return nil, nil, errors.New("response is not a single DER value")
+	}
+	resp, err := ocsp.ParseResponseForCert(ex.body, &x509.Certificate{SerialNumber: id.serial}, s.issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.Certificate != nil && !hasOCSPSigning(resp.Certificate) {
+		return nil, nil, errors.New("delegated responder certificate lacks id-kp-OCSPSigning")
+	}
+	if resp.SerialNumber.Cmp(id.serial) != 0 {
+		return nil, nil, fmt.Errorf("response names serial %x, want %x", resp.SerialNumber, id.serial)
+	}
+	now := time.Now()
+	if resp.ThisUpdate.After(now.Add(clockSkew)) || resp.ProducedAt.After(now.Add(clockSkew)) {
+		return nil, nil, errors.New("thisUpdate or producedAt is in the future")
+	}
+	if !resp.NextUpdate.IsZero() && !resp.NextUpdate.After(now) {
+		return nil, nil, fmt.Errorf("expired: nextUpdate %s", resp.NextUpdate.UTC().Format(time.RFC3339))
+	}
+
+	var warnings []string
+	if resp.IssuerHash != id.hash {
+		warnings = append(warnings, fmt.Sprintf("CertID hashed with %s, request used %s", resp.IssuerHash, id.hash))
+	}
+	return resp, warnings, nil
+}
+
+// nonce sends a request with a nonce. RFC 8954 lets a responder leave it out of the response,
+// but a nonce it includes must be the one sent
+func (s *suite) nonce() (outcome, string) {
+	value := make([]byte, 32)
+	rand.Read(value)
+	id := certID{issuer: s.issuer, serial: s.good, hash: crypto.SHA1}
+	der, err := buildRequest([]certID{id}, value)
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	ex, err := s.post(der)
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	resp, warnings, err := s.verify(ex, id)
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	wrapped, _ := asn1.Marshal(value)
+	for _, ext := range resp.Extensions {
+		if !ext.Id.Equal(oidNonce) {
+			continue
+		}
+		if !bytes.Equal(ext.Value, wrapped) {
+			return outcomeFail, "response carries a different nonce"
+		}
+		return report(warnings, "nonce echoed")
+	}
+	return report(warnings, "nonce not echoed (allowed by RFC 8954)")
+}
+
+// multiCert asks for two serials at once. The response must answer both, or refuse the
+// request; answering only the first leaves the client without the second status
+func (s *suite) multiCert() (outcome, string) {
+	second := s.revoked
+	if second == nil {
+		second = s.unknown
+	}
+	ids := []certID{
+		{issuer: s.issuer, serial: s.good, hash: crypto.SHA1},
+		{issuer: s.issuer, serial: second, hash: crypto.SHA1},
+	}
+	der, err := buildRequest(ids, nil)
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	ex, err := s.post(der)
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	if status, ok := errorStatus(ex.body); ok {
+		if status == ocsp.Malformed || status == ocsp.Unauthorized {
+			return outcomeWarn, fmt.Sprintf("refused with %s; clients must ask for one certificate at a time", status)
+		}
+		return outcomeFail, fmt.Sprintf("refused with %s", status)
+	}
+	var warnings []string
+	for _, id := range ids {
+		_, w, err := s.verify(ex, id)
+		if err != nil {
+			return outcomeFail, fmt.Sprintf("serial %x: %v", id.serial, err)
+		}
+		warnings = append(warnings, w...)
+	}
+	return report(warnings, "both serials answered")
+}
+
+// unknownIssuer asks for a serial of an issuer the responder does not serve, which RFC 5019
+// answers unauthorized; a signed unknown status is also conformant
+func (s *suite) unknownIssuer() (outcome, string) {
+	id := certID{issuer: s.stranger, serial: s.good, hash: crypto.SHA1}
+	der, err := buildRequest([]certID{id}, nil)
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	ex, err := s.post(der)
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	if status, ok := errorStatus(ex.body); ok {
+		if status != ocsp.Unauthorized {
+			return outcomeFail, fmt.Sprintf("answered %s, want unauthorized", status)
+		}
+		return exact(ex, status)
+	}
+	resp, err := ocsp.ParseResponse(ex.body, s.issuer)
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	if resp.Status != ocsp.Unknown {
+		return outcomeFail, fmt.Sprintf("answered %s for an issuer it does not serve", statusName(resp.Status))
+	}
+	return outcomePass, "unknown"
+}
+
+// unknownSerial asks for a serial that was never issued: unauthorized, unknown, or revoked
+// as RFC 6960 section 2.2 allows, but never good
+func (s *suite) unknownSerial() (outcome, string) {
+	id := certID{issuer: s.issuer, serial: s.unknown, hash: crypto.SHA1}
+	der, err := buildRequest([]certID{id}, nil)
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	ex, err := s.post(der)
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	if status, ok := errorStatus(ex.body); ok {
+		if status != ocsp.Unauthorized {
+			return outcomeFail, fmt.Sprintf("answered %s, want unauthorized", status)
+		}
+		return exact(ex, status)
+	}
+	resp, warnings, err := s.verify(ex, id)
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	if resp.Status == ocsp.Good {
+		return outcomeFail, "answered good for a serial that was never issued"
+	}
+	return report(warnings, statusName(resp.Status))
+}
+
+// malformed sends a body that is not DER, which must be answered malformedRequest
+func (s *suite) malformed() (outcome, string) {
+	ex, err := s.post([]byte("not an OCSP request"))
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	status, ok := errorStatus(ex.body)
+	if !ok || status != ocsp.Malformed {
+		return outcomeFail, "not answered malformedRequest"
+	}
+	return exact(ex, status)
+}
+
+func (s *suite) methodNotAllowed() (outcome, string) {
+	req, err := http.NewRequest(http.MethodPut, s.url, strings.NewReader("x"))
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	ex, err := s.do(req)
+	if err != nil {
+		return outcomeFail, err.Error()
+	}
+	if ex.status != http.StatusMethodNotAllowed {
+		return outcomeFail, fmt.Sprintf("HTTP status %d, want 405", ex.status)
+	}
+	return outcomePass, "405"
+}
+
+// errorStatus returns the status of an unsuccessful response
+func errorStatus(der []byte) (ocsp.ResponseStatus, bool) {
+	var resp struct {
+		Status asn1.Enumerated
+		Bytes  asn1.RawValue `asn1:"explicit,tag:0,optional"`
+	}
+	if _, err := asn1.Unmarshal(der, &resp); err != nil || resp.Status == 0 {
+		return 0, false
+	}
+	return ocsp.ResponseStatus(resp.Status), true
+}
+
+// exact checks an unsuccessful response byte for byte: RFC 6960 leaves responseBytes out,
+// so there is exactly one encoding, and it must come with HTTP 200
+func exact(ex *exchange, status ocsp.ResponseStatus) (outcome, string) {
+	want := []byte{0x30, 0x03, 0x0a, 0x01, byte(status)}
+	if !bytes.Equal(ex.body, want) {
+		return outcomeFail, fmt.Sprintf("%s encoded as %x, want %x", status, ex.body, want)
+	}
+	if ex.status != http.StatusOK {
+		return outcomeWarn, fmt.Sprintf("%s with HTTP status %d", status, ex.status)
+	}
+	return outcomePass, status.String()
+}
+
+func hasOCSPSigning(cert *x509.Certificate) bool {
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageOCSPSigning {
+			return true
+		}
+	}
+	return false
+}
+
+func report(warnings []string, detail string) (outcome, string) {
+	if len(warnings) > 0 {
+		return outcomeWarn, strings.Join(warnings, "; ")
+	}
+	return outcomePass, detail
+}
+
+func statusName(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
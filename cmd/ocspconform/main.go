@@ -0,0 +1,188 @@
+// Command ocspconform checks an RFC 6960 responder with the request shapes real clients send:
+// GET and POST, SHA-1 and SHA-256 CertIDs, nonces, several certificates at once, unknown
+// issuers and serials, and malformed requests. Without -url it starts a presigned responder
+// for a throwaway CA and checks that, so it runs in CI without a database
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"flag"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/config"
+	"github.com/gigvault/ocsp/internal/crl"
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"github.com/gigvault/ocsp/internal/presign"
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+const usage = `usage: ocspconform [flags]
+
+Without -url, a presigned responder with the default request limits is started for a
+throwaway CA and checked. Against a deployment, give -url, -issuer and -good, and -revoked
+to check a revoked certificate too.
+
+Exits 1 when a check fails, or with -strict when one warns.
+
+Flags:
+`
+
+func main() {
+	flags := flag.NewFlagSet("ocspconform", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	responderURL := flags.String("url", "", "responder URL, e.g. http://ocsp.example.com/ocsp")
+	issuerPath := flags.String("issuer", "", "PEM issuer certificate of the responder under test")
+	good := flags.String("good", "", "hex serial of a good certificate")
+	revoked := flags.String("revoked", "", "hex serial of a revoked certificate")
+	unknown := flags.String("unknown", "7fffffffffffffffffffffffffffffff", "hex serial that was never issued")
+	withOpenSSL := flags.Bool("openssl", false, "also query the responder with openssl ocsp")
+	strict := flags.Bool("strict", false, "fail when a check warns")
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout of each HTTP request")
+	flags.Parse(os.Args[1:])
+
+	stranger, _, err := newCA("ocspconform unknown issuer")
+	if err != nil {
+		fail("Failed to create issuer: %v", err)
+	}
+	s := &suite{client: &http.Client{Timeout: *timeout}, stranger: stranger, unknown: parseSerial("unknown", *unknown)}
+
+	if *responderURL == "" {
+		server, err := startLocal(s)
+		if err != nil {
+			fail("Failed to start responder: %v", err)
+		}
+		defer server.Close()
+		fmt.Fprintf(os.Stderr, "Checking a local presigned responder at %s\n", s.url)
+	} else {
+		if *issuerPath == "" || *good == "" {
+			fail("-url needs -issuer and -good")
+		}
+		if s.issuer, err = crl.LoadCertificate(*issuerPath); err != nil {
+			fail("Failed to load issuer: %v", err)
+		}
+		s.url, s.good = *responderURL, parseSerial("good", *good)
+		if *revoked != "" {
+			s.revoked = parseSerial("revoked", *revoked)
+		}
+	}
+
+	results := s.run()
+	if *withOpenSSL {
+		results = append(results, s.openssl()...)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	failed := 0
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.outcome, r.name, r.detail)
+		if r.outcome == outcomeFail || *strict && r.outcome == outcomeWarn {
+			failed++
+		}
+	}
+	w.Flush()
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d checks failed\n", failed, len(results))
+		os.Exit(1)
+	}
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+func parseSerial(name, hex string) *big.Int {
+	serial, ok := new(big.Int).SetString(hex, 16)
+	if !ok || serial.Sign() <= 0 {
+		fail("Invalid -%s serial %q", name, hex)
+	}
+	return serial
+}
+
+// startLocal signs a bundle with a good and a revoked serial for a new CA, serves it with the
+// presigned responder and points s at it
+func startLocal(s *suite) (*httptest.Server, error) {
+	issuer, key, err := newCA("ocspconform issuer")
+	if err != nil {
+		return nil, err
+	}
+	s.issuer, s.good, s.revoked = issuer, big.NewInt(0x1001), big.NewInt(0x1002)
+
+	now := time.Now().UTC()
+	revokedAt := now.Add(-time.Hour)
+	records := []storage.Record{
+		{Serial: s.good.Text(16), Status: storage.StatusGood, ThisUpdate: now},
+		{Serial: s.revoked.Text(16), Status: storage.StatusRevoked, ThisUpdate: now, RevokedAt: &revokedAt, RevocationReason: "keyCompromise"},
+	}
+	dir, err := os.MkdirTemp("", "ocspconform")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bundle.ndjson.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = presign.Sign(context.Background(), file, records, presign.SignOptions{Issuer: issuer, Signer: key, Validity: time.Hour})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	holder := &presign.Holder{}
+	if _, err := holder.LoadFile(path, issuer); err != nil {
+		return nil, err
+	}
+
+	limits := config.Default().RequestLimits
+	responder := presign.NewResponder(holder, "/ocsp", ocspreq.Limits{
+		MaxBodyBytes:      limits.MaxBodyBytes,
+		MaxCertIDs:        limits.MaxCertIDs,
+		MaxExtensions:     limits.MaxExtensions,
+		MaxExtensionBytes: limits.MaxExtensionBytes,
+	})
+	server := httptest.NewServer(responder)
+	s.url = server.URL + "/ocsp"
+	return server, nil
+}
+
+// newCA creates a self-signed P-256 CA
+func newCA(name string) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
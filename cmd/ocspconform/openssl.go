@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// opensslTimeout bounds each openssl ocsp run
+const opensslTimeout = 30 * time.Second
+
+// openssl queries the responder with the openssl ocsp command, which must verify the response
+// against the issuer and report the expected status
+func (s *suite) openssl() []result {
+	path, err := exec.LookPath("openssl")
+	if err != nil {
+		return []result{{name: "openssl", outcome: outcomeSkip, detail: "openssl not found"}}
+	}
+	dir, err := os.MkdirTemp("", "ocspconform")
+	if err != nil {
+		return []result{{name: "openssl", outcome: outcomeFail, detail: err.Error()}}
+	}
+	defer os.RemoveAll(dir)
+	issuerPath := filepath.Join(dir, "issuer.pem")
+	if err := os.WriteFile(issuerPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.issuer.Raw}), 0o644); err != nil {
+		return []result{{name: "openssl", outcome: outcomeFail, detail: err.Error()}}
+	}
+
+	cases := []struct {
+		name   string
+		serial *big.Int
+		want   string
+		args   []string
+	}{
+		{"openssl-sha1-good", s.good, "good", nil},
+		{"openssl-sha256-good", s.good, "good", []string{"-sha256"}},
+		{"openssl-nonce-good", s.good, "good", []string{"-nonce"}},
+		{"openssl-revoked", s.revoked, "revoked", nil},
+	}
+	var results []result
+	for _, c := range cases {
+		if c.serial == nil {
+			results = append(results, result{name: c.name, outcome: outcomeSkip, detail: "no revoked serial given"})
+			continue
+		}
+		outcome, detail := s.runOpenSSL(path, issuerPath, c.serial, c.want, c.args)
+		results = append(results, result{name: c.name, outcome: outcome, detail: detail})
+	}
+	return results
+}
+
+func (s *suite) runOpenSSL(path, issuerPath string, serial *big.Int, want string, extra []string) (outcome, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), opensslTimeout)
+	defer cancel()
+	// A CertID digest applies to the serials after it
+	args := append([]string{"ocsp", "-issuer", issuerPath, "-CAfile", issuerPath, "-partial_chain"}, extra...)
+	args = append(args, "-serial", fmt.Sprintf("0x%x", serial), "-url", s.url)
+	if !contains(extra, "-nonce") {
+		args = append(args, "-no_nonce")
+	}
+	output, err := exec.CommandContext(ctx, path, args...).CombinedOutput()
+	text := string(output)
+	if !strings.Contains(text, "Response verify OK") {
+		return outcomeFail, firstLine(text, err)
+	}
+	status := statusLine(text, serial)
+	switch {
+	case status == want:
+	case strings.Contains(status, "No Status found") && contains(extra, "-sha256"):
+		// The response names the certificate by a SHA-1 CertID, which RFC 5019 clients use
+		return outcomeWarn, "no status for a SHA-256 CertID; only clients sending SHA-1 CertIDs are answered"
+	case status != "":
+		return outcomeFail, fmt.Sprintf("status %q, want %s", status, want)
+	default:
+		return outcomeFail, firstLine(text, err)
+	}
+	if i := strings.Index(text, "WARNING"); i >= 0 {
+		return outcomeWarn, firstLine(text[i:], nil)
+	}
+	return outcomePass, want
+}
+
+// statusLine returns what openssl reports for serial, such as good or revoked
+func statusLine(text string, serial *big.Int) string {
+	prefix := fmt.Sprintf("0x%x: ", serial)
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.ToLower(line), prefix) {
+			return strings.TrimSpace(line[len(prefix):])
+		}
+	}
+	return ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// firstLine returns the first non-empty line of openssl's output, or err when there is none
+func firstLine(text string, err error) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return "no output"
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var (
+	oidNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+
+	hashOIDs = map[crypto.Hash]asn1.ObjectIdentifier{
+		crypto.SHA1:   {1, 3, 14, 3, 2, 26},
+		crypto.SHA256: {2, 16, 840, 1, 101, 3, 4, 2, 1},
+	}
+
+	tagRequestExtensions = cbasn1.Tag(2).ContextSpecific().Constructed()
+)
+
+// certID names one certificate in a request
+type certID struct {
+	issuer *x509.Certificate
+	serial *big.Int
+	hash   crypto.Hash
+}
+
+// buildRequest encodes an unsigned OCSPRequest for ids, with a nonce extension when nonce is
+// not nil. CertID hash algorithms carry a NULL parameter, as OpenSSL sends them
+func buildRequest(ids []certID, nonce []byte) ([]byte, error) {
+	type hashes struct{ name, key []byte }
+	encoded := make([]hashes, len(ids))
+	for i, id := range ids {
+		if _, ok := hashOIDs[id.hash]; !ok {
+			return nil, errors.New("unsupported CertID hash")
+		}
+		key, err := ocspreq.IssuerKeyHash(id.issuer, id.hash)
+		if err != nil {
+			return nil, err
+		}
+		h := id.hash.New()
+		h.Write(id.issuer.RawSubject)
+		encoded[i] = hashes{name: h.Sum(nil), key: key}
+	}
+
+	var b cryptobyte.Builder
+	b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				for i, id := range ids {
+					b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+						b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+							b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+								b.AddASN1ObjectIdentifier(hashOIDs[id.hash])
+								b.AddASN1NULL()
+							})
+							b.AddASN1OctetString(encoded[i].name)
+							b.AddASN1OctetString(encoded[i].key)
+							b.AddASN1BigInt(id.serial)
+						})
+					})
+				}
+			})
+			if nonce != nil {
+				b.AddASN1(tagRequestExtensions, func(b *cryptobyte.Builder) {
+					b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+						b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+							b.AddASN1ObjectIdentifier(oidNonce)
+							b.AddASN1(cbasn1.OCTET_STRING, func(b *cryptobyte.Builder) {
+								b.AddASN1OctetString(nonce)
+							})
+						})
+					})
+				})
+			}
+		})
+	})
+	return b.Bytes()
+}
@@ -0,0 +1,923 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/audit"
+	"github.com/gigvault/ocsp/internal/compromise"
+	"github.com/gigvault/ocsp/internal/crl"
+	"github.com/gigvault/ocsp/internal/guardrail"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/api/proto/ocsp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// runImportCRL uploads a CRL file, or asks the responder to fetch a URL itself
+func runImportCRL(c *client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: ocspctl import-crl <path|url>")
+	}
+
+	query := url.Values{}
+	if c.dryRun {
+		query.Set("dry_run", "true")
+	}
+	var body io.Reader
+	if strings.HasPrefix(args[0], "http://") || strings.HasPrefix(args[0], "https://") {
+		query.Set("url", args[0])
+	} else {
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	endpoint := c.httpURL + "/api/v1/crl/import"
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+
+	var result struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodPost, endpoint, body, &result); err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	json.Indent(&out, result.Data, "", "  ")
+	fmt.Println(out.String())
+	return nil
+}
+
+// runExport prints the revocations in the published CRL as CSV accepted by POST /statuses/import
+func runExport(c *client, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	path := flags.String("path", "/crl", "CRL distribution point path on the responder")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+
+	req, err := c.newRequest(ctx, http.MethodGet, c.httpURL+*path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", *path, resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	list, err := crl.Parse(data)
+	if err != nil {
+		return err
+	}
+	updates, _ := crl.Updates(list)
+
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"serial", "status", "reason", "date"})
+	for _, update := range updates {
+		if update.Status != storage.StatusRevoked {
+			continue
+		}
+		date := ""
+		if update.RevokedAt != nil {
+			date = update.RevokedAt.UTC().Format(time.RFC3339)
+		}
+		w.Write([]string{update.Serial, update.Status, update.RevocationReason, date})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// runStats prints the responder's own metric samples, skipping Go runtime and process metrics
+func runStats(c *client, args []string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	req, err := c.newRequest(ctx, http.MethodGet, c.httpURL+"/metrics", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET /metrics returned %d", resp.StatusCode)
+	}
+
+	prefix := metrics.Namespace + "_"
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, prefix) {
+			fmt.Println(line)
+		}
+	}
+	return scanner.Err()
+}
+
+// runBackup downloads a signed backup archive: ocspctl backup <path|->
+func runBackup(c *client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: ocspctl backup <path|->")
+	}
+
+	return c.download(c.httpURL+"/api/v1/backup", args[0])
+}
+
+// download saves a GET response body to path, or stdout for -
+func (c *client) download(endpoint, path string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	req, err := c.newRequest(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download returned status %d", resp.StatusCode)
+	}
+
+	var out io.Writer = os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	n, err := io.Copy(out, resp.Body)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d bytes\n", n)
+	return nil
+}
+
+// runRestore uploads a backup archive into an empty responder: ocspctl restore <path>
+func runRestore(c *client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: ocspctl restore <path>")
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ctx, cancel := c.context()
+	defer cancel()
+
+	var result struct {
+		Data struct {
+			Statuses   int       `json:"statuses"`
+			CRLNumbers int       `json:"crl_numbers"`
+			CreatedAt  time.Time `json:"created_at"`
+		} `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodPost, c.httpURL+"/api/v1/restore", file, &result); err != nil {
+		return err
+	}
+	fmt.Printf("restored %d statuses and %d CRL numbers from backup taken %s\n",
+		result.Data.Statuses, result.Data.CRLNumbers, result.Data.CreatedAt.Format(time.RFC3339))
+	return nil
+}
+
+// runMode prints the operating mode, or switches it: ocspctl mode [-reason r] [normal|read_only|maintenance]
+func runMode(c *client, args []string) error {
+	flags := flag.NewFlagSet("mode", flag.ContinueOnError)
+	reason := flags.String("reason", "", "why the mode is being changed, shown to other operators")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 1 {
+		return errors.New("usage: ocspctl mode [-reason r] [normal|read_only|maintenance]")
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+
+	method, body := http.MethodGet, io.Reader(nil)
+	if flags.NArg() == 1 {
+		data, err := json.Marshal(map[string]string{"mode": flags.Arg(0), "reason": *reason})
+		if err != nil {
+			return err
+		}
+		method, body = http.MethodPut, bytes.NewReader(data)
+	}
+
+	var result struct {
+		Data struct {
+			Mode   string    `json:"mode"`
+			Reason string    `json:"reason"`
+			Since  time.Time `json:"since"`
+		} `json:"data"`
+	}
+	if err := c.do(ctx, method, c.httpURL+"/api/v1/mode", body, &result); err != nil {
+		return err
+	}
+	fmt.Printf("%s\tsince %s\t%s\n", result.Data.Mode, result.Data.Since.Format(time.RFC3339), orDash(result.Data.Reason))
+	return nil
+}
+
+// runAudit downloads a signed export of the audit trail, or verifies one offline:
+// ocspctl audit export -from t [-to t] <path|-> | ocspctl audit verify -cert c.pem <path>
+func runAudit(c *client, args []string) error {
+	const usage = "usage: ocspctl audit export -from t [-to t] <path|-> | audit verify -cert c.pem <path>"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	from := flags.String("from", "", "start of the range (RFC 3339)")
+	to := flags.String("to", "", "end of the range (RFC 3339); defaults to now")
+	certPath := flags.String("cert", "", "certificate the export must be signed with")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "export":
+		if *from == "" {
+			return errors.New(usage)
+		}
+		query := url.Values{"from": {*from}}
+		if *to != "" {
+			query.Set("to", *to)
+		}
+		return c.download(c.httpURL+"/api/v1/audit/export?"+query.Encode(), flags.Arg(0))
+
+	case "verify":
+		if *certPath == "" {
+			return errors.New(usage)
+		}
+		cert, err := crl.LoadCertificate(*certPath)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		summary, _, err := audit.Verify(file, cert)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("verified %d entries from %s to %s, exported by %s at %s\n", summary.Entries,
+			summary.From.Format(time.RFC3339), summary.To.Format(time.RFC3339), summary.Service, summary.CreatedAt.Format(time.RFC3339))
+		return nil
+
+	default:
+		return errors.New(usage)
+	}
+}
+
+// runApprovals lists staged revocations or decides one:
+// ocspctl approvals [-state s] [list|show <id>|approve <id>|reject <id>]
+func runApprovals(c *client, args []string) error {
+	const usage = "usage: ocspctl approvals [-state s] [list|show <id>|approve <id>|reject <id>]"
+	flags := flag.NewFlagSet("approvals", flag.ContinueOnError)
+	state := flags.String("state", "pending", "state to list; empty lists every request")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	action := "list"
+	if flags.NArg() > 0 {
+		action = flags.Arg(0)
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+
+	if action == "list" {
+		if flags.NArg() > 1 {
+			return errors.New(usage)
+		}
+		var result struct {
+			Data struct {
+				Requests []approvalRequest `json:"requests"`
+			} `json:"data"`
+		}
+		endpoint := c.httpURL + "/api/v1/approvals?state=" + url.QueryEscape(*state)
+		if err := c.do(ctx, http.MethodGet, endpoint, nil, &result); err != nil {
+			return err
+		}
+		for _, req := range result.Data.Requests {
+			req.print()
+		}
+		return nil
+	}
+
+	if flags.NArg() != 2 {
+		return errors.New(usage)
+	}
+	method, suffix := http.MethodPost, ""
+	switch action {
+	case "show":
+		method = http.MethodGet
+	case "approve", "reject":
+		suffix = "/" + action
+	default:
+		return errors.New(usage)
+	}
+	var result struct {
+		Data approvalRequest `json:"data"`
+	}
+	endpoint := c.httpURL + "/api/v1/approvals/" + url.PathEscape(flags.Arg(1)) + suffix
+	if err := c.do(ctx, method, endpoint, nil, &result); err != nil {
+		return err
+	}
+	result.Data.print()
+	if action == "show" {
+		for _, update := range result.Data.Updates {
+			fmt.Printf("  %s\t%s\t%s\n", update.Serial, update.Status, orDash(update.RevocationReason))
+		}
+	}
+	return nil
+}
+
+type approvalRequest struct {
+	ID          int64            `json:"id"`
+	State       string           `json:"state"`
+	Reason      string           `json:"reason"`
+	Updates     []storage.Update `json:"updates"`
+	RequestedBy string           `json:"requested_by"`
+	ExpiresAt   time.Time        `json:"expires_at"`
+	DecidedBy   string           `json:"decided_by"`
+}
+
+func (r approvalRequest) print() {
+	fmt.Printf("%d\t%s\t%d updates\trequested by %s\texpires %s\tdecided by %s\t%s\n",
+		r.ID, r.State, len(r.Updates), r.RequestedBy, r.ExpiresAt.Format(time.RFC3339), orDash(r.DecidedBy), r.Reason)
+}
+
+// runDeadLetters lists the rows imports and batches could not apply, and fixes, replays or
+// discards them. replay without IDs replays every pending row
+func runDeadLetters(c *client, args []string) error {
+	const usage = "usage: ocspctl dead-letters [-state s] [-source s] [list|show <id>|fix <id> <serial> <status> [reason] [date]|replay [<id>...]|discard <id>...]"
+	flags := flag.NewFlagSet("dead-letters", flag.ContinueOnError)
+	state := flags.String("state", "pending", "state to list; empty lists every row")
+	source := flags.String("source", "", "only rows from import, batch or write_behind")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	action := "list"
+	if flags.NArg() > 0 {
+		action = flags.Arg(0)
+	}
+	ids := flags.Args()
+	if len(ids) > 0 {
+		ids = ids[1:]
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+	endpoint := c.httpURL + "/api/v1/dead-letters"
+
+	switch action {
+	case "list":
+		if len(ids) > 0 {
+			return errors.New(usage)
+		}
+		query := url.Values{"state": {*state}, "source": {*source}}
+		for {
+			var result struct {
+				Data struct {
+					Items         []deadLetter `json:"items"`
+					NextPageToken string       `json:"next_page_token"`
+				} `json:"data"`
+			}
+			if err := c.do(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil, &result); err != nil {
+				return err
+			}
+			for _, item := range result.Data.Items {
+				item.print()
+			}
+			if result.Data.NextPageToken == "" {
+				return nil
+			}
+			query.Set("page_token", result.Data.NextPageToken)
+		}
+	case "replay":
+		if len(ids) == 0 {
+			var result struct {
+				Data struct {
+					Replayed int `json:"replayed"`
+					Staged   int `json:"staged"`
+					Failed   int `json:"failed"`
+				} `json:"data"`
+			}
+			if err := c.do(ctx, http.MethodPost, endpoint+"/replay?source="+url.QueryEscape(*source), nil, &result); err != nil {
+				return err
+			}
+			fmt.Printf("replayed %d, staged %d, failed %d\n", result.Data.Replayed, result.Data.Staged, result.Data.Failed)
+			return nil
+		}
+	case "show", "discard":
+		if len(ids) == 0 || (action == "show" && len(ids) > 1) {
+			return errors.New(usage)
+		}
+	case "fix":
+		if len(ids) < 3 || len(ids) > 5 {
+			return errors.New(usage)
+		}
+		row := map[string]string{"serial": ids[1], "status": ids[2]}
+		if len(ids) > 3 {
+			row["reason"] = ids[3]
+		}
+		if len(ids) > 4 {
+			row["date"] = ids[4]
+		}
+		body, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		var result struct {
+			Data deadLetter `json:"data"`
+		}
+		if err := c.do(ctx, http.MethodPut, endpoint+"/"+url.PathEscape(ids[0]), bytes.NewReader(body), &result); err != nil {
+			return err
+		}
+		result.Data.print()
+		return nil
+	default:
+		return errors.New(usage)
+	}
+
+	method, suffix := http.MethodPost, "/"+action
+	if action == "show" {
+		method, suffix = http.MethodGet, ""
+	}
+	for _, id := range ids {
+		var result struct {
+			Data deadLetter `json:"data"`
+		}
+		if err := c.do(ctx, method, endpoint+"/"+url.PathEscape(id)+suffix, nil, &result); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		result.Data.print()
+	}
+	return nil
+}
+
+type deadLetter struct {
+	ID     int64  `json:"id"`
+	State  string `json:"state"`
+	Source string `json:"source"`
+	Line   int    `json:"line"`
+	Row    struct {
+		Serial string `json:"serial"`
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+		Date   string `json:"date"`
+	} `json:"row"`
+	Error    string `json:"error"`
+	Attempts int    `json:"attempts"`
+}
+
+func (d deadLetter) print() {
+	fmt.Printf("%d\t%s\t%s:%d\t%s\t%s\t%s\t%s\t%d attempts\t%s\n", d.ID, d.State, d.Source, d.Line,
+		orDash(d.Row.Serial), orDash(d.Row.Status), orDash(d.Row.Reason), orDash(d.Row.Date), d.Attempts, d.Error)
+}
+
+// runCompromise shows the signing keys, or runs the compromise response for the active one
+func runCompromise(c *client, args []string) error {
+	const usage = "usage: ocspctl compromise [status|respond <issuer key hash>]"
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+
+	switch {
+	case action == "status" && len(args) <= 1:
+		var result struct {
+			Data compromise.Status `json:"data"`
+		}
+		if err := c.do(ctx, http.MethodGet, c.httpURL+"/api/v1/compromise", nil, &result); err != nil {
+			return err
+		}
+		fmt.Printf("active\t%s\nstandby\t%s\n", result.Data.ActiveKey, orDash(result.Data.StandbyKey))
+		for _, rec := range result.Data.Compromised {
+			fmt.Printf("compromised\t%s\tserial %s\t%s\tby %s\t%s\n",
+				rec.KeyHash, rec.CertificateSerial, rec.CompromisedAt.Format(time.RFC3339), rec.Actor, rec.Subject)
+		}
+		return nil
+
+	case action == "respond" && len(args) == 2:
+		body, err := json.Marshal(map[string]string{"issuer": args[1]})
+		if err != nil {
+			return err
+		}
+		var result struct {
+			Data compromise.Result `json:"data"`
+		}
+		if err := c.do(ctx, http.MethodPost, c.httpURL+"/api/v1/compromise", bytes.NewReader(body), &result); err != nil {
+			return err
+		}
+		fmt.Printf("Key %s recorded as compromised by %s; now signing with %s\n",
+			result.Data.CompromisedKey, result.Data.Actor, result.Data.ActiveKey)
+		if result.Data.RevokedSerial != "" {
+			fmt.Printf("Revoked certificate %s\n", result.Data.RevokedSerial)
+		}
+		return nil
+
+	default:
+		return errors.New(usage)
+	}
+}
+
+// runAPIKeys lists, creates, rotates and revokes the API keys of the gRPC mutation API. Tokens
+// are printed once, when a key is created or rotated
+func runAPIKeys(c *client, args []string) error {
+	const usage = "usage: ocspctl api-keys [-tenant t] [list|show <id>|create [-issuers h,...] [-rate r] [-burst n] <tenant> <name>|rotate <id>|revoke <id>...]"
+	flags := flag.NewFlagSet("api-keys", flag.ContinueOnError)
+	tenant := flags.String("tenant", "", "only keys of this tenant")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	action := "list"
+	if flags.NArg() > 0 {
+		action = flags.Arg(0)
+	}
+	ids := flags.Args()
+	if len(ids) > 0 {
+		ids = ids[1:]
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+	endpoint := c.httpURL + "/api/v1/api-keys"
+
+	switch action {
+	case "list":
+		if len(ids) > 0 {
+			return errors.New(usage)
+		}
+		query := url.Values{"tenant": {*tenant}}
+		for {
+			var result struct {
+				Data struct {
+					Keys          []apiKey `json:"keys"`
+					NextPageToken string   `json:"next_page_token"`
+				} `json:"data"`
+			}
+			if err := c.do(ctx, http.MethodGet, endpoint+"?"+query.Encode(), nil, &result); err != nil {
+				return err
+			}
+			for _, key := range result.Data.Keys {
+				key.print()
+			}
+			if result.Data.NextPageToken == "" {
+				return nil
+			}
+			query.Set("page_token", result.Data.NextPageToken)
+		}
+	case "create":
+		create := flag.NewFlagSet("api-keys create", flag.ContinueOnError)
+		issuers := create.String("issuers", "", "comma-separated hex issuer key hashes the key is limited to")
+		rate := create.Float64("rate", 0, "requests per second the key may make; 0 is unlimited")
+		burst := create.Int("burst", 0, "requests the key may make at once; 0 is one second of its rate")
+		if err := create.Parse(ids); err != nil {
+			return err
+		}
+		if create.NArg() != 2 {
+			return errors.New(usage)
+		}
+		spec := map[string]interface{}{
+			"tenant":          create.Arg(0),
+			"name":            create.Arg(1),
+			"issuers":         []string{},
+			"rate_per_second": *rate,
+			"burst":           *burst,
+		}
+		if *issuers != "" {
+			spec["issuers"] = strings.Split(*issuers, ",")
+		}
+		body, err := json.Marshal(spec)
+		if err != nil {
+			return err
+		}
+		return c.issueAPIKey(ctx, endpoint, bytes.NewReader(body))
+	case "rotate":
+		if len(ids) != 1 {
+			return errors.New(usage)
+		}
+		return c.issueAPIKey(ctx, endpoint+"/"+url.PathEscape(ids[0])+"/rotate", nil)
+	case "show", "revoke":
+		if len(ids) == 0 || (action == "show" && len(ids) > 1) {
+			return errors.New(usage)
+		}
+	default:
+		return errors.New(usage)
+	}
+
+	method, suffix := http.MethodPost, "/"+action
+	if action == "show" {
+		method, suffix = http.MethodGet, ""
+	}
+	for _, id := range ids {
+		var result struct {
+			Data apiKey `json:"data"`
+		}
+		if err := c.do(ctx, method, endpoint+"/"+url.PathEscape(id)+suffix, nil, &result); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		result.Data.print()
+	}
+	return nil
+}
+
+// issueAPIKey creates or rotates a key and prints it with its token
+func (c *client) issueAPIKey(ctx context.Context, endpoint string, body io.Reader) error {
+	var result struct {
+		Data struct {
+			Key   apiKey `json:"key"`
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodPost, endpoint, body, &result); err != nil {
+		return err
+	}
+	result.Data.Key.print()
+	fmt.Printf("token\t%s\n", result.Data.Token)
+	fmt.Fprintln(os.Stderr, "Store the token now; it cannot be shown again")
+	return nil
+}
+
+type apiKey struct {
+	ID            string     `json:"id"`
+	Tenant        string     `json:"tenant"`
+	Name          string     `json:"name"`
+	Issuers       []string   `json:"issuers"`
+	RatePerSecond float64    `json:"rate_per_second"`
+	Burst         int        `json:"burst"`
+	CreatedBy     string     `json:"created_by"`
+	RevokedAt     *time.Time `json:"revoked_at"`
+}
+
+func (k apiKey) print() {
+	state, rate, issuers := "live", "unlimited", "any issuer"
+	if k.RevokedAt != nil {
+		state = "revoked " + k.RevokedAt.Format(time.RFC3339)
+	}
+	if k.RatePerSecond > 0 {
+		rate = fmt.Sprintf("%g/s burst %d", k.RatePerSecond, k.Burst)
+	}
+	if len(k.Issuers) > 0 {
+		issuers = strings.Join(k.Issuers, ",")
+	}
+	fmt.Printf("%s\t%s/%s\t%s\t%s\t%s\tcreated by %s\n", k.ID, k.Tenant, k.Name, state, rate, issuers, orDash(k.CreatedBy))
+}
+
+// runTunables shows and changes runtime tunables. set without -persist lasts until the replica
+// restarts; reset goes back to the configured value
+func runTunables(c *client, args []string) error {
+	const usage = "usage: ocspctl tunables [-reason r] [-persist] [list|history [name]|set <name> <value>|reset <name>]"
+	flags := flag.NewFlagSet("tunables", flag.ContinueOnError)
+	reason := flags.String("reason", "", "why the tunable is being changed, recorded in its history")
+	persist := flags.Bool("persist", false, "store the value for every replica, surviving restarts")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	action := "list"
+	if flags.NArg() > 0 {
+		action = flags.Arg(0)
+	}
+	operands := flags.Args()
+	if len(operands) > 0 {
+		operands = operands[1:]
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+	endpoint := c.httpURL + "/api/v1/tunables"
+
+	switch action {
+	case "list":
+		if len(operands) > 0 {
+			return errors.New(usage)
+		}
+		var result struct {
+			Data []tunable `json:"data"`
+		}
+		if err := c.do(ctx, http.MethodGet, endpoint, nil, &result); err != nil {
+			return err
+		}
+		for _, t := range result.Data {
+			t.print()
+		}
+		return nil
+	case "history":
+		if len(operands) > 1 {
+			return errors.New(usage)
+		}
+		query := url.Values{}
+		if len(operands) == 1 {
+			query.Set("name", operands[0])
+		}
+		var result struct {
+			Data []struct {
+				Time      time.Time `json:"time"`
+				Name      string    `json:"name"`
+				OldValue  string    `json:"old_value"`
+				NewValue  string    `json:"new_value"`
+				Persisted bool      `json:"persisted"`
+				Actor     string    `json:"actor"`
+				Reason    string    `json:"reason"`
+				Replica   string    `json:"replica"`
+			} `json:"data"`
+		}
+		if err := c.do(ctx, http.MethodGet, endpoint+"/history?"+query.Encode(), nil, &result); err != nil {
+			return err
+		}
+		for _, change := range result.Data {
+			scope := "until restart"
+			if change.Persisted {
+				scope = "persisted"
+			}
+			fmt.Printf("%s\t%s\t%s -> %s\t%s\ton %s\tby %s\t%s\n", change.Time.Format(time.RFC3339), change.Name,
+				change.OldValue, change.NewValue, scope, change.Replica, change.Actor, orDash(change.Reason))
+		}
+		return nil
+	case "set":
+		if len(operands) != 2 {
+			return errors.New(usage)
+		}
+		body, err := json.Marshal(map[string]interface{}{"value": operands[1], "reason": *reason, "persist": *persist})
+		if err != nil {
+			return err
+		}
+		var result struct {
+			Data tunable `json:"data"`
+		}
+		if err := c.do(ctx, http.MethodPut, endpoint+"/"+url.PathEscape(operands[0]), bytes.NewReader(body), &result); err != nil {
+			return err
+		}
+		result.Data.print()
+		return nil
+	case "reset":
+		if len(operands) != 1 {
+			return errors.New(usage)
+		}
+		var result struct {
+			Data tunable `json:"data"`
+		}
+		query := url.Values{"reason": {*reason}}
+		if err := c.do(ctx, http.MethodDelete, endpoint+"/"+url.PathEscape(operands[0])+"?"+query.Encode(), nil, &result); err != nil {
+			return err
+		}
+		result.Data.print()
+		return nil
+	default:
+		return errors.New(usage)
+	}
+}
+
+type tunable struct {
+	Name       string     `json:"name"`
+	Value      string     `json:"value"`
+	Configured string     `json:"configured"`
+	Persisted  bool       `json:"persisted"`
+	ChangedBy  string     `json:"changed_by"`
+	ChangedAt  *time.Time `json:"changed_at"`
+	Reason     string     `json:"reason"`
+}
+
+func (t tunable) print() {
+	state := "configured"
+	if t.ChangedAt != nil {
+		state = "changed " + t.ChangedAt.Format(time.RFC3339) + " by " + t.ChangedBy
+		if t.Persisted {
+			state += ", persisted"
+		}
+	}
+	fmt.Printf("%s\t%s\t(configured %s)\t%s\t%s\n", t.Name, t.Value, t.Configured, state, orDash(t.Reason))
+}
+
+// runHealth checks the HTTP liveness and readiness endpoints and that the gRPC API answers
+func runHealth(c *client, args []string) error {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	healthy := true
+	for _, path := range []string{"/health", "/ready"} {
+		req, err := c.newRequest(ctx, http.MethodGet, c.httpURL+path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			fmt.Printf("%s\tfail\t%v\n", path, err)
+			healthy = false
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			fmt.Printf("%s\tfail\tstatus %d\n", path, resp.StatusCode)
+			healthy = false
+			continue
+		}
+		fmt.Printf("%s\tok\n", path)
+	}
+
+	// An empty serial is rejected with InvalidArgument, which proves the service is answering
+	_, err := c.grpc.CheckStatus(ctx, &ocsp.CheckStatusRequest{})
+	if code := status.Code(err); code == codes.InvalidArgument || code == codes.OK {
+		fmt.Println("grpc\tok")
+	} else {
+		fmt.Printf("grpc\tfail\t%v\n", err)
+		healthy = false
+	}
+
+	if !healthy {
+		return errors.New("responder is unhealthy")
+	}
+	return nil
+}
+
+func (c *client) newRequest(ctx context.Context, method, endpoint string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.confirm != "" {
+		req.Header.Set(guardrail.ConfirmHeader, c.confirm)
+	}
+	return req, nil
+}
+
+// do sends a request to a JSON API endpoint and decodes the response into out
+func (c *client) do(ctx context.Context, method, endpoint string, body io.Reader, out interface{}) error {
+	req, err := c.newRequest(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("%s (status %d)", apiErr.Error.Message, resp.StatusCode)
+		}
+		return fmt.Errorf("request returned status %d", resp.StatusCode)
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,213 @@
+// Command ocspctl administers an OCSP responder over its gRPC and HTTP APIs
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/events"
+	"github.com/gigvault/shared/api/proto/ocsp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const usage = `usage: ocspctl [flags] <command> [args]
+
+Commands:
+  check <serial>...                           show the status of serials
+  list [-prefix p] [-from s] [-to s] [-status s] [-serials]
+                                              list statuses by serial prefix or numeric range
+  revoke [-reason r] [-at time] <serial>...   revoke serials
+  unrevoke <serial>...                        mark serials good again, whatever their reason
+  hold-release <serial>...                    release serials on certificateHold only
+  set-reason <reason> <serial>...             change the revocation reason of revoked serials
+  set-validity <duration|0> <serial>...       override how long responses about serials are valid
+  import-crl <path|url>                       import revocations from a CRL
+  export [-path /crl]                         print the published CRL as bulk import CSV
+  stats                                       print the responder's ocsp_* metrics
+  health                                      check liveness, readiness and gRPC reachability
+  backup <path|->                             download a signed backup archive
+  restore <path>                              restore a backup into an empty responder
+  audit export -from t [-to t] <path|->       download a signed export of the audit trail
+  audit verify -cert c.pem <path>             verify an audit export offline
+  mode [-reason r] [normal|read_only|maintenance]
+                                              show or switch the operating mode
+  approvals [-state s] [list|show <id>|approve <id>|reject <id>]
+                                              list or decide revocations staged for approval
+  dead-letters [-state s] [-source s] [list|show <id>|replay [<id>...]|discard <id>...]
+                                              list, replay or discard rows imports and batches could not apply
+  dead-letters fix <id> <serial> <status> [reason] [date]
+                                              correct a dead-lettered row before replaying it
+  compromise [status|respond <issuer key hash>]
+                                              show signing keys, or switch out a compromised one
+  api-keys [-tenant t] [list|show <id>|rotate <id>|revoke <id>...]
+                                              list, rotate or revoke the API keys of the gRPC mutation API
+  api-keys create [-issuers h,...] [-rate r] [-burst n] <tenant> <name>
+                                              create an API key and print its token
+  tunables [list|history [name]]              show runtime tunables or their recent changes
+  tunables [-reason r] [-persist] [set <name> <value>|reset <name>]
+                                              change a tunable until restart, or for good with -persist
+
+With -dry-run, revoke, unrevoke, hold-release, set-reason, set-validity and import-crl report
+what would change without writing anything. When a change is refused for revoking too many
+serials, the error names a confirmation token; pass it with -confirm to proceed. Changes through
+the HTTP API need -token with the token of an admin principal.
+
+Flags:
+`
+
+// client holds the connections shared by commands
+type client struct {
+	grpc    ocsp.OCSPServiceClient
+	conn    *grpc.ClientConn
+	httpURL string
+	http    *http.Client
+	token   string
+	timeout time.Duration
+	dryRun  bool
+	confirm string
+	issuer  string
+	// override lets updates release revocations other than certificateHold
+	override bool
+}
+
+func main() {
+	grpcAddr := flag.String("grpc", envOr("OCSPCTL_GRPC", "localhost:9084"), "gRPC address of the responder (OCSPCTL_GRPC)")
+	httpURL := flag.String("http", envOr("OCSPCTL_HTTP", "http://localhost:8084"), "HTTP base URL of the responder (OCSPCTL_HTTP)")
+	useTLS := flag.Bool("tls", false, "connect to gRPC with TLS")
+	caPath := flag.String("ca", "", "CA bundle verifying the server certificate")
+	certPath := flag.String("cert", "", "client certificate for mTLS")
+	keyPath := flag.String("key", "", "client key for mTLS")
+	token := flag.String("token", os.Getenv("OCSPCTL_TOKEN"), "bearer token sent with every request (OCSPCTL_TOKEN)")
+	timeout := flag.Duration("timeout", 30*time.Second, "per-command timeout")
+	dryRun := flag.Bool("dry-run", false, "validate and report changes without applying them")
+	confirm := flag.String("confirm", "", "confirmation token for revocations over the responder's mass-revocation limits")
+	issuer := flag.String("issuer", os.Getenv("OCSPCTL_ISSUER"), "hex issuer key hash status changes are metered under (OCSPCTL_ISSUER)")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var tlsConfig *tls.Config
+	if *useTLS || *caPath != "" || *certPath != "" {
+		cfg, err := events.LoadTLSConfig(*caPath, *certPath, *keyPath)
+		if err != nil {
+			fail("Invalid TLS settings: %v", err)
+		}
+		tlsConfig = cfg
+	}
+
+	c, err := newClient(*grpcAddr, *httpURL, tlsConfig, *token, *timeout)
+	if err != nil {
+		fail("Failed to connect: %v", err)
+	}
+	defer c.conn.Close()
+	c.dryRun = *dryRun
+	c.confirm = *confirm
+	c.issuer = *issuer
+
+	command, args := flag.Arg(0), flag.Args()[1:]
+	commands := map[string]func(*client, []string) error{
+		"check":        runCheck,
+		"list":         runList,
+		"revoke":       runRevoke,
+		"unrevoke":     runUnrevoke,
+		"hold-release": runHoldRelease,
+		"set-reason":   runSetReason,
+		"set-validity": runSetValidity,
+		"import-crl":   runImportCRL,
+		"export":       runExport,
+		"stats":        runStats,
+		"health":       runHealth,
+		"backup":       runBackup,
+		"restore":      runRestore,
+		"mode":         runMode,
+		"approvals":    runApprovals,
+		"audit":        runAudit,
+		"dead-letters": runDeadLetters,
+		"compromise":   runCompromise,
+		"api-keys":     runAPIKeys,
+		"tunables":     runTunables,
+	}
+	run, ok := commands[command]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(c, args); err != nil {
+		c.conn.Close()
+		fail("%s: %v", command, err)
+	}
+}
+
+func newClient(grpcAddr, httpURL string, tlsConfig *tls.Config, token string, timeout time.Duration) (*client, error) {
+	creds := insecure.NewCredentials()
+	httpTransport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+		httpTransport.TLSClientConfig = tlsConfig
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerToken{token: token, secure: tlsConfig != nil}))
+	}
+	conn, err := grpc.NewClient(grpcAddr, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client{
+		grpc:    ocsp.NewOCSPServiceClient(conn),
+		conn:    conn,
+		httpURL: httpURL,
+		http:    &http.Client{Transport: httpTransport},
+		token:   token,
+		timeout: timeout,
+	}, nil
+}
+
+// context returns a context bounded by the command timeout
+func (c *client) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), c.timeout)
+}
+
+// bearerToken attaches an Authorization header to every RPC
+type bearerToken struct {
+	token  string
+	secure bool
+}
+
+func (t bearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+
+// RequireTransportSecurity is false without TLS so plaintext connections to a local or
+// mesh-terminated responder still work
+func (t bearerToken) RequireTransportSecurity() bool {
+	return t.secure
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/apikeys"
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/guardrail"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/api/proto/ocsp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// runCheck prints one line per serial: serial, status, reason, revocation and update times
+func runCheck(c *client, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: ocspctl check <serial>...")
+	}
+	serials, err := normalizeSerials(args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+
+	for _, serial := range serials {
+		resp, err := c.grpc.CheckStatus(ctx, &ocsp.CheckStatusRequest{SerialNumber: serial})
+		if err != nil {
+			return fmt.Errorf("%s: %w", serial, err)
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\n", serial, resp.Status, orDash(resp.RevocationReason),
+			formatTimestamp(resp.RevokedAt), formatTimestamp(resp.ThisUpdate))
+	}
+	return nil
+}
+
+// runList prints the statuses in a serial prefix or numeric range, one line each as check
+// does, or just the serials with -serials, to pipe into revoke
+func runList(c *client, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	prefix := flags.String("prefix", "", "hex prefix of the serials")
+	from := flags.String("from", "", "lowest serial, inclusive")
+	to := flags.String("to", "", "highest serial, inclusive")
+	status := flags.String("status", "", "only list this status")
+	serialsOnly := flags.Bool("serials", false, "print only the serials")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 || (*prefix == "" && *from == "" && *to == "") {
+		return errors.New("usage: ocspctl list [-prefix p] [-from s] [-to s] [-status s] [-serials]")
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+
+	query := url.Values{}
+	for name, value := range map[string]string{"prefix": *prefix, "from": *from, "to": *to, "status": *status} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	for {
+		var result struct {
+			Data struct {
+				Statuses      []storage.Record `json:"statuses"`
+				NextPageToken string           `json:"next_page_token"`
+			} `json:"data"`
+		}
+		if err := c.do(ctx, http.MethodGet, c.httpURL+"/api/v1/statuses?"+query.Encode(), nil, &result); err != nil {
+			return err
+		}
+		for _, rec := range result.Data.Statuses {
+			if *serialsOnly {
+				fmt.Println(rec.Serial)
+				continue
+			}
+			revokedAt := "-"
+			if rec.RevokedAt != nil {
+				revokedAt = rec.RevokedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", rec.Serial, rec.Status, orDash(rec.RevocationReason),
+				revokedAt, rec.ThisUpdate.UTC().Format(time.RFC3339))
+		}
+		if result.Data.NextPageToken == "" {
+			return nil
+		}
+		query.Set("page_token", result.Data.NextPageToken)
+	}
+}
+
+// runRevoke revokes serials with a reason and optional revocation time
+func runRevoke(c *client, args []string) error {
+	flags := flag.NewFlagSet("revoke", flag.ContinueOnError)
+	reason := flags.String("reason", revocation.ReasonUnspecified, "RFC 5280 reason name or code")
+	at := flags.String("at", "", "revocation time (RFC 3339); defaults to now")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: ocspctl revoke [-reason r] [-at time] <serial>...")
+	}
+
+	name, ok := revocation.ParseReason(*reason)
+	if !ok {
+		return fmt.Errorf("unknown revocation reason %q", *reason)
+	}
+	revokedAt := time.Now().UTC()
+	if *at != "" {
+		t, err := time.Parse(time.RFC3339, *at)
+		if err != nil {
+			return fmt.Errorf("invalid -at time: %w", err)
+		}
+		revokedAt = t
+	}
+
+	serials, err := normalizeSerials(flags.Args())
+	if err != nil {
+		return err
+	}
+	updates := make([]*ocsp.UpdateStatusRequest, len(serials))
+	for i, serial := range serials {
+		updates[i] = &ocsp.UpdateStatusRequest{
+			SerialNumber:     serial,
+			Status:           storage.StatusRevoked,
+			RevokedAt:        timestamppb.New(revokedAt),
+			RevocationReason: name,
+		}
+	}
+	return c.apply(updates)
+}
+
+// runUnrevoke marks serials good regardless of why they were revoked
+func runUnrevoke(c *client, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: ocspctl unrevoke <serial>...")
+	}
+	serials, err := normalizeSerials(args)
+	if err != nil {
+		return err
+	}
+	c.override = true
+	return c.apply(goodUpdates(serials))
+}
+
+// runHoldRelease marks serials good only if they are currently on certificateHold, the one
+// reason RFC 5280 allows to be lifted
+func runHoldRelease(c *client, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: ocspctl hold-release <serial>...")
+	}
+	serials, err := normalizeSerials(args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := c.context()
+	defer cancel()
+	for _, serial := range serials {
+		resp, err := c.grpc.CheckStatus(ctx, &ocsp.CheckStatusRequest{SerialNumber: serial})
+		if err != nil {
+			return fmt.Errorf("%s: %w", serial, err)
+		}
+		if resp.Status != storage.StatusRevoked || resp.RevocationReason != revocation.ReasonCertificateHold {
+			return fmt.Errorf("%s is %s %s, not on certificateHold; use unrevoke to override", serial, resp.Status, resp.RevocationReason)
+		}
+	}
+	return c.apply(goodUpdates(serials))
+}
+
+// runSetReason changes the revocation reason of revoked serials, leaving the rest of their
+// status as stored
+func runSetReason(c *client, args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: ocspctl set-reason <reason> <serial>...")
+	}
+	name, ok := revocation.ParseReason(args[0])
+	if !ok {
+		return fmt.Errorf("unknown revocation reason %q", args[0])
+	}
+	serials, err := normalizeSerials(args[1:])
+	if err != nil {
+		return err
+	}
+	updates := make([]*ocsp.UpdateStatusRequest, len(serials))
+	for i, serial := range serials {
+		updates[i] = &ocsp.UpdateStatusRequest{SerialNumber: serial, RevocationReason: name}
+	}
+	return c.patch("revocation_reason", updates)
+}
+
+// runSetValidity sets how long responses about serials are valid, leaving their status as
+// stored; 0 removes the override
+func runSetValidity(c *client, args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: ocspctl set-validity <duration|0> <serial>...")
+	}
+	if _, err := time.ParseDuration(args[0]); err != nil {
+		return fmt.Errorf("invalid validity: %w", err)
+	}
+	serials, err := normalizeSerials(args[1:])
+	if err != nil {
+		return err
+	}
+	updates := make([]*ocsp.UpdateStatusRequest, len(serials))
+	for i, serial := range serials {
+		updates[i] = &ocsp.UpdateStatusRequest{SerialNumber: serial}
+	}
+	return c.patch("validity", updates, validityKey, args[0])
+}
+
+// Metadata keys the responder reads from status updates
+const (
+	dryRunKey     = "x-dry-run"
+	updateMaskKey = "x-update-mask"
+	validityKey   = "x-response-validity"
+	overrideKey   = "x-override-transition"
+)
+
+// updateContext returns a context for status updates carrying the dry-run, override,
+// confirmation and issuer metadata
+func (c *client) updateContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := c.context()
+	if c.dryRun {
+		ctx = metadata.AppendToOutgoingContext(ctx, dryRunKey, "true")
+	}
+	if c.override {
+		ctx = metadata.AppendToOutgoingContext(ctx, overrideKey, "true")
+	}
+	if c.confirm != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, guardrail.ConfirmMetadataKey, c.confirm)
+	}
+	if c.issuer != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, apikeys.IssuerMetadataKey, c.issuer)
+	}
+	return ctx, cancel
+}
+
+// patch sends updates one at a time with an update mask, so only the masked fields change;
+// pairs are further metadata keys and values
+func (c *client) patch(mask string, updates []*ocsp.UpdateStatusRequest, pairs ...string) error {
+	ctx, cancel := c.updateContext()
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, append([]string{updateMaskKey, mask}, pairs...)...)
+
+	for _, update := range updates {
+		resp, err := c.grpc.UpdateStatus(ctx, update)
+		if err != nil {
+			return fmt.Errorf("%s: %w", update.SerialNumber, err)
+		}
+		if c.dryRun {
+			fmt.Println(resp.Message)
+		} else {
+			fmt.Printf("%s\t%s updated\n", update.SerialNumber, mask)
+		}
+	}
+	return nil
+}
+
+// apply sends one update directly or several as an atomic batch
+func (c *client) apply(updates []*ocsp.UpdateStatusRequest) error {
+	ctx, cancel := c.updateContext()
+	defer cancel()
+
+	if len(updates) == 1 {
+		resp, err := c.grpc.UpdateStatus(ctx, updates[0])
+		if err != nil {
+			return err
+		}
+		if c.dryRun {
+			fmt.Println(resp.Message)
+		} else {
+			fmt.Printf("%s\t%s\n", updates[0].SerialNumber, updates[0].Status)
+		}
+		return nil
+	}
+
+	var header metadata.MD
+	resp, err := c.grpc.BatchUpdateStatus(ctx, &ocsp.BatchUpdateStatusRequest{Updates: updates}, grpc.Header(&header))
+	if err != nil {
+		return err
+	}
+	for _, msg := range resp.Errors {
+		fmt.Println(msg)
+	}
+	if c.dryRun {
+		fmt.Printf("dry run: would apply %d, fail %d; %s\n", resp.SuccessCount, resp.FailureCount,
+			strings.Join(header.Get(dryRunKey+"-summary"), " "))
+	} else {
+		fmt.Printf("applied %d, failed %d\n", resp.SuccessCount, resp.FailureCount)
+	}
+	if resp.FailureCount > 0 {
+		return fmt.Errorf("%d updates failed", resp.FailureCount)
+	}
+	return nil
+}
+
+func goodUpdates(serials []string) []*ocsp.UpdateStatusRequest {
+	updates := make([]*ocsp.UpdateStatusRequest, len(serials))
+	for i, serial := range serials {
+		updates[i] = &ocsp.UpdateStatusRequest{SerialNumber: serial, Status: storage.StatusGood}
+	}
+	return updates
+}
+
+func normalizeSerials(values []string) ([]string, error) {
+	serials := make([]string, len(values))
+	for i, value := range values {
+		serial, err := bulk.NormalizeSerial(value)
+		if err != nil {
+			return nil, err
+		}
+		serials[i] = serial
+	}
+	return serials, nil
+}
+
+func formatTimestamp(ts *timestamppb.Timestamp) string {
+	if ts == nil {
+		return "-"
+	}
+	return ts.AsTime().UTC().Format(time.RFC3339)
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
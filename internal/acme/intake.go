@@ -0,0 +1,104 @@
+// Package acme applies revocations requested through the gigvault ACME front end
+package acme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/gigvault/shared/pkg/models"
+	"go.uber.org/zap"
+)
+
+// ErrInvalidRevocation is returned when a revocation cannot be applied as requested
+var ErrInvalidRevocation = errors.New("invalid ACME revocation")
+
+// Revocation is a revokeCert request accepted by the ACME front end
+type Revocation struct {
+	Serial string `json:"serial"`
+	// Reason is the RFC 8555 revokeCert reason code; omitted means unspecified
+	Reason    *int      `json:"reason,omitempty"`
+	RevokedAt time.Time `json:"revoked_at"`
+	Account   string    `json:"account,omitempty"`
+}
+
+// subscriberReasons are the reason codes a subscriber may request through ACME under the
+// CA/Browser Forum Baseline Requirements (7.2.2); certificateHold, cACompromise and
+// aACompromise are never valid, and privilegeWithdrawn is reserved for the CA
+var subscriberReasons = map[int]bool{
+	models.ReasonUnspecified:          true,
+	models.ReasonKeyCompromise:        true,
+	models.ReasonAffiliationChanged:   true,
+	models.ReasonSuperseded:           true,
+	models.ReasonCessationOfOperation: true,
+}
+
+// Intake validates ACME revocations and applies them
+type Intake struct {
+	store  storage.Store
+	logger *logger.Logger
+}
+
+// NewIntake creates an ACME revocation intake
+func NewIntake(store storage.Store, logger *logger.Logger) *Intake {
+	return &Intake{store: store, logger: logger}
+}
+
+// Apply translates the revocations and applies them in one transaction; nothing is applied
+// if any revocation is invalid
+func (i *Intake) Apply(ctx context.Context, revocations []Revocation) error {
+	updates := make([]storage.Update, 0, len(revocations))
+	for n, rev := range revocations {
+		update, err := Translate(rev)
+		if err != nil {
+			return fmt.Errorf("%w %d: %v", ErrInvalidRevocation, n, err)
+		}
+		updates = append(updates, update)
+	}
+
+	if err := i.store.ApplyBatch(ctx, updates); err != nil {
+		return fmt.Errorf("failed to apply ACME revocations: %w", err)
+	}
+
+	for _, update := range updates {
+		i.logger.Info("ACME revocation applied",
+			zap.String("serial", update.Serial),
+			zap.String("reason", update.RevocationReason),
+		)
+	}
+	return nil
+}
+
+// Translate maps an ACME revocation to a status update, rejecting reasons subscribers may not request
+func Translate(rev Revocation) (storage.Update, error) {
+	serial, err := bulk.NormalizeSerial(rev.Serial)
+	if err != nil {
+		return storage.Update{}, err
+	}
+
+	code := models.ReasonUnspecified
+	if rev.Reason != nil {
+		code = *rev.Reason
+	}
+	if !subscriberReasons[code] {
+		return storage.Update{}, fmt.Errorf("reason code %d is not permitted for ACME revocation", code)
+	}
+
+	revokedAt := rev.RevokedAt
+	if revokedAt.IsZero() {
+		revokedAt = time.Now()
+	}
+	revokedAt = revokedAt.UTC()
+
+	return storage.Update{
+		Serial:           serial,
+		Status:           storage.StatusRevoked,
+		RevokedAt:        &revokedAt,
+		RevocationReason: revocation.ReasonName(code),
+	}, nil
+}
@@ -0,0 +1,76 @@
+// Package alias resolves serials that share another certificate's status. Relying parties may
+// query a CT precertificate's serial when the CA issued it with a serial other than the final
+// certificate's; aliasing it to the final serial makes both answer alike, revocations included.
+// A serial's own stored status always wins over an alias
+package alias
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrChain refuses an alias to a serial that is itself an alias, or for a serial other aliases
+// stand for; aliases resolve one level deep
+var ErrChain = errors.New("aliases cannot be chained")
+
+var lookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "serial_alias_lookups_total",
+	Help:      "Lookups answered through a serial alias, by result: resolved, or dangling when the aliased serial has no status.",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(lookups)
+}
+
+// Resolver looks aliases up
+type Resolver interface {
+	// Resolve returns the serial alias stands for, or storage.ErrNotFound
+	Resolve(ctx context.Context, alias string) (string, error)
+}
+
+// Store answers Get for serials without a status of their own with the status of the serial
+// they alias
+type Store struct {
+	storage.Store
+	resolver Resolver
+}
+
+// NewStore wraps store so aliased serials read as the serial they stand for
+func NewStore(store storage.Store, resolver Resolver) *Store {
+	return &Store{Store: store, resolver: resolver}
+}
+
+// Get returns the stored status, or that of the serial it aliases
+func (s *Store) Get(ctx context.Context, serial string) (*storage.Record, error) {
+	rec, err := s.Store.Get(ctx, serial)
+	if !errors.Is(err, storage.ErrNotFound) {
+		return rec, err
+	}
+	return s.Aliased(ctx, serial)
+}
+
+// Aliased returns the status of the serial alias stands for, under the alias, or
+// storage.ErrNotFound when alias is no alias or that serial has no status
+func (s *Store) Aliased(ctx context.Context, alias string) (*storage.Record, error) {
+	serial, err := s.resolver.Resolve(ctx, alias)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := s.Store.Get(ctx, serial)
+	if errors.Is(err, storage.ErrNotFound) {
+		lookups.WithLabelValues("dangling").Inc()
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	lookups.WithLabelValues("resolved").Inc()
+	aliased := *rec
+	aliased.Serial = alias
+	return &aliased, nil
+}
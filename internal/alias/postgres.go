@@ -0,0 +1,60 @@
+package alias
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Postgres stores aliases in the serial_aliases table so every replica shares them
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgres creates a Postgres alias store
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Resolve returns the serial alias stands for, or storage.ErrNotFound
+func (p *Postgres) Resolve(ctx context.Context, alias string) (string, error) {
+	var serial string
+	err := p.db.QueryRow(ctx, `SELECT serial FROM serial_aliases WHERE alias = $1`, alias).Scan(&serial)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", storage.ErrNotFound
+	}
+	return serial, err
+}
+
+// Put makes alias stand for serial, replacing what it stood for before. It returns ErrChain
+// when serial is itself an alias or other aliases stand for alias
+func (p *Postgres) Put(ctx context.Context, alias, serial string) error {
+	tag, err := p.db.Exec(ctx, `
+		INSERT INTO serial_aliases (alias, serial, created_at)
+		SELECT $1, $2, NOW()
+		WHERE NOT EXISTS (SELECT 1 FROM serial_aliases WHERE alias = $2 OR serial = $1)
+		ON CONFLICT (alias) DO UPDATE SET serial = EXCLUDED.serial, created_at = NOW()
+	`, alias, serial)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrChain
+	}
+	return nil
+}
+
+// Delete removes alias, or returns storage.ErrNotFound
+func (p *Postgres) Delete(ctx context.Context, alias string) error {
+	tag, err := p.db.Exec(ctx, `DELETE FROM serial_aliases WHERE alias = $1`, alias)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// Kind identifies the type of anomaly that was detected
+type Kind string
+
+const (
+	KindUnknownSpike    Kind = "unknown_spike"
+	KindRevocationSurge Kind = "revocation_surge"
+	KindSerialScan      Kind = "serial_scan"
+	// KindUnloggedRevocation is a revoked serial absent from Certificate Transparency
+	KindUnloggedRevocation Kind = "unlogged_revocation"
+	// KindWatchdogFailure is a watchdog run whose lookups at the public endpoint failed
+	KindWatchdogFailure Kind = "watchdog_failure"
+	// KindSlowSigning is a signing key whose latency crossed its threshold or that stopped
+	// answering
+	KindSlowSigning Kind = "slow_signing"
+	// KindResponseDrift is a stored precomputed response disagreeing with its status
+	KindResponseDrift Kind = "response_drift"
+)
+
+// Alert describes a crossed threshold
+type Alert struct {
+	Kind      Kind      `json:"kind"`
+	Message   string    `json:"message"`
+	Source    string    `json:"source,omitempty"`
+	Count     int64     `json:"count"`
+	Threshold int64     `json:"threshold"`
+	Window    string    `json:"window"`
+	FiredAt   time.Time `json:"fired_at"`
+}
+
+// Hook receives alerts when a threshold is crossed
+type Hook interface {
+	Fire(ctx context.Context, alert Alert)
+}
+
+// Thresholds configures when the detector raises alerts
+type Thresholds struct {
+	Window              time.Duration
+	Cooldown            time.Duration
+	UnknownResponses    int64
+	Revocations         int64
+	SequentialSerialRun int
+}
+
+// Detector watches request outcomes for unusual patterns
+type Detector struct {
+	thresholds Thresholds
+	hooks      []Hook
+
+	mu          sync.Mutex
+	unknowns    *windowCounter
+	revocations *windowCounter
+	scans       map[string]*serialRun
+	lastFired   map[string]time.Time
+	lastPrune   time.Time
+}
+
+type serialRun struct {
+	last   *big.Int
+	length int
+	seen   time.Time
+}
+
+// NewDetector creates a new anomaly detector
+func NewDetector(thresholds Thresholds, hooks ...Hook) *Detector {
+	return &Detector{
+		thresholds:  thresholds,
+		hooks:       hooks,
+		unknowns:    newWindowCounter(thresholds.Window),
+		revocations: newWindowCounter(thresholds.Window),
+		scans:       make(map[string]*serialRun),
+		lastFired:   make(map[string]time.Time),
+	}
+}
+
+// ObserveLookup records the outcome of a status lookup from the given source
+func (d *Detector) ObserveLookup(ctx context.Context, source, serial, status string) {
+	d.observeLookup(ctx, source, serial, status == "unknown")
+}
+
+// ObserveServed records an OCSP request from source answered by an HTTP responder; known is
+// false when the responder had no status for serial
+func (d *Detector) ObserveServed(ctx context.Context, source, serial string, known bool) {
+	d.observeLookup(ctx, source, serial, !known)
+}
+
+func (d *Detector) observeLookup(ctx context.Context, source, serial string, unknown bool) {
+	now := time.Now()
+	var alerts []Alert
+
+	d.mu.Lock()
+	if unknown && d.thresholds.UnknownResponses > 0 {
+		if count := d.unknowns.add(now); count >= d.thresholds.UnknownResponses {
+			alerts = d.collect(alerts, now, Alert{
+				Kind:      KindUnknownSpike,
+				Message:   fmt.Sprintf("%d unknown responses within %s", count, d.thresholds.Window),
+				Count:     count,
+				Threshold: d.thresholds.UnknownResponses,
+			})
+		}
+	}
+	if d.thresholds.SequentialSerialRun > 0 {
+		if length := d.trackRun(now, source, serial); length >= d.thresholds.SequentialSerialRun {
+			alerts = d.collect(alerts, now, Alert{
+				Kind:      KindSerialScan,
+				Message:   fmt.Sprintf("%d sequential serials queried", length),
+				Source:    source,
+				Count:     int64(length),
+				Threshold: int64(d.thresholds.SequentialSerialRun),
+			})
+		}
+	}
+	d.mu.Unlock()
+
+	d.fire(ctx, alerts)
+}
+
+// ObserveRevocation records a revocation applied on behalf of the given source
+func (d *Detector) ObserveRevocation(ctx context.Context, source string) {
+	if d.thresholds.Revocations <= 0 {
+		return
+	}
+
+	now := time.Now()
+	var alerts []Alert
+
+	d.mu.Lock()
+	if count := d.revocations.add(now); count >= d.thresholds.Revocations {
+		alerts = d.collect(alerts, now, Alert{
+			Kind:      KindRevocationSurge,
+			Message:   fmt.Sprintf("%d revocations within %s", count, d.thresholds.Window),
+			Source:    source,
+			Count:     count,
+			Threshold: d.thresholds.Revocations,
+		})
+	}
+	d.mu.Unlock()
+
+	d.fire(ctx, alerts)
+}
+
+// collect appends the alert unless the same alert fired within the cooldown; callers hold d.mu
+func (d *Detector) collect(alerts []Alert, now time.Time, alert Alert) []Alert {
+	key := string(alert.Kind) + "|" + alert.Source
+	if last, ok := d.lastFired[key]; ok && now.Sub(last) < d.thresholds.Cooldown {
+		return alerts
+	}
+	d.lastFired[key] = now
+
+	alert.Window = d.thresholds.Window.String()
+	alert.FiredAt = now
+	return append(alerts, alert)
+}
+
+// trackRun extends the source's run of consecutive serials; callers hold d.mu
+func (d *Detector) trackRun(now time.Time, source, serial string) int {
+	if now.Sub(d.lastPrune) > d.thresholds.Window {
+		for src, run := range d.scans {
+			if now.Sub(run.seen) > d.thresholds.Window {
+				delete(d.scans, src)
+			}
+		}
+		d.lastPrune = now
+	}
+
+	value, ok := new(big.Int).SetString(serial, 16)
+	if !ok {
+		return 0
+	}
+
+	run, ok := d.scans[source]
+	if !ok || now.Sub(run.seen) > d.thresholds.Window {
+		d.scans[source] = &serialRun{last: value, length: 1, seen: now}
+		return 1
+	}
+
+	diff := new(big.Int).Sub(value, run.last)
+	if diff.IsInt64() && (diff.Int64() == 1 || diff.Int64() == -1) {
+		run.length++
+	} else if diff.Sign() != 0 {
+		run.length = 1
+	}
+	run.last = value
+	run.seen = now
+
+	return run.length
+}
+
+func (d *Detector) fire(ctx context.Context, alerts []Alert) {
+	for _, alert := range alerts {
+		for _, hook := range d.hooks {
+			hook.Fire(ctx, alert)
+		}
+	}
+}
+
+// windowCounter counts events over a sliding window using one-second buckets
+type windowCounter struct {
+	buckets []int64
+	stamps  []int64
+}
+
+func newWindowCounter(window time.Duration) *windowCounter {
+	size := int(window / time.Second)
+	if size < 1 {
+		size = 1
+	}
+	return &windowCounter{
+		buckets: make([]int64, size),
+		stamps:  make([]int64, size),
+	}
+}
+
+// add records an event and returns the number of events in the window
+func (c *windowCounter) add(now time.Time) int64 {
+	sec := now.Unix()
+	idx := int(sec % int64(len(c.buckets)))
+	if c.stamps[idx] != sec {
+		c.stamps[idx] = sec
+		c.buckets[idx] = 0
+	}
+	c.buckets[idx]++
+
+	var total int64
+	oldest := sec - int64(len(c.buckets))
+	for i, stamp := range c.stamps {
+		if stamp > oldest {
+			total += c.buckets[i]
+		}
+	}
+	return total
+}
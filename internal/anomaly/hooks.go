@@ -0,0 +1,100 @@
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var alertsFired = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "anomaly_alerts_total",
+	Help:      "Number of anomaly alerts fired, by kind.",
+}, []string{"kind"})
+
+func init() {
+	metrics.Registry.MustRegister(alertsFired)
+}
+
+// LogHook writes alerts to the service log
+type LogHook struct {
+	logger *logger.Logger
+}
+
+// NewLogHook creates a hook that logs alerts at warn level
+func NewLogHook(logger *logger.Logger) *LogHook {
+	return &LogHook{logger: logger}
+}
+
+// Fire logs the alert
+func (h *LogHook) Fire(ctx context.Context, alert Alert) {
+	h.logger.Warn("Anomaly detected",
+		zap.String("kind", string(alert.Kind)),
+		zap.String("message", alert.Message),
+		zap.String("source", alert.Source),
+		zap.Int64("count", alert.Count),
+		zap.Int64("threshold", alert.Threshold),
+	)
+}
+
+// MetricHook counts alerts in the anomaly_alerts_total metric
+type MetricHook struct{}
+
+// Fire increments the counter for the alert kind
+func (MetricHook) Fire(ctx context.Context, alert Alert) {
+	alertsFired.WithLabelValues(string(alert.Kind)).Inc()
+}
+
+// WebhookHook posts alerts as JSON to a configured URL
+type WebhookHook struct {
+	url    string
+	client *http.Client
+	logger *logger.Logger
+}
+
+// NewWebhookHook creates a hook that delivers alerts to the given URL
+func NewWebhookHook(url string, logger *logger.Logger) *WebhookHook {
+	return &WebhookHook{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+	}
+}
+
+// Fire delivers the alert asynchronously so request handling is never blocked
+func (h *WebhookHook) Fire(ctx context.Context, alert Alert) {
+	go func() {
+		if err := h.deliver(alert); err != nil {
+			h.logger.Warn("Failed to deliver anomaly webhook",
+				zap.String("kind", string(alert.Kind)),
+				zap.Error(err),
+			)
+		}
+	}()
+}
+
+func (h *WebhookHook) deliver(alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
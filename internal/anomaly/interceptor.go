@@ -0,0 +1,41 @@
+package anomaly
+
+import (
+	"context"
+	"net"
+
+	"github.com/gigvault/shared/api/proto/ocsp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+// UnaryServerInterceptor feeds CheckStatus outcomes into the detector; revocations are
+// observed by Store once they commit
+func UnaryServerInterceptor(d *Detector) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return resp, err
+		}
+
+		if r, ok := req.(*ocsp.CheckStatusRequest); ok {
+			if out, ok := resp.(*ocsp.CheckStatusResponse); ok {
+				d.ObserveLookup(ctx, sourceFromContext(ctx), r.SerialNumber, out.Status)
+			}
+		}
+
+		return resp, err
+	}
+}
+
+func sourceFromContext(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
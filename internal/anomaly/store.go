@@ -0,0 +1,53 @@
+package anomaly
+
+import (
+	"context"
+
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+// Store feeds the revocations that commit through it into the detector, whichever API, import
+// or sync made them
+type Store struct {
+	storage.Store
+	detector *Detector
+}
+
+// NewStore wraps store so its committed revocations are observed by d
+func NewStore(store storage.Store, d *Detector) *Store {
+	return &Store{Store: store, detector: d}
+}
+
+// Upsert writes the update and observes it if it revoked
+func (s *Store) Upsert(ctx context.Context, update storage.Update) error {
+	if err := s.Store.Upsert(ctx, update); err != nil {
+		return err
+	}
+	if update.Status == storage.StatusRevoked {
+		s.detector.ObserveRevocation(ctx, writeSource(ctx))
+	}
+	return nil
+}
+
+// ApplyBatch writes the updates and observes each revocation among them
+func (s *Store) ApplyBatch(ctx context.Context, updates []storage.Update) error {
+	if err := s.Store.ApplyBatch(ctx, updates); err != nil {
+		return err
+	}
+	source := writeSource(ctx)
+	for _, update := range updates {
+		if update.Status == storage.StatusRevoked {
+			s.detector.ObserveRevocation(ctx, source)
+		}
+	}
+	return nil
+}
+
+// writeSource names who made a write: the authenticated principal, else the gRPC peer
+func writeSource(ctx context.Context) string {
+	if principal := approval.PrincipalFrom(ctx); principal != "" {
+		return principal
+	}
+	return sourceFromContext(ctx)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/acme"
+	"github.com/gigvault/ocsp/internal/events"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// acmeMaxBody bounds a revocation batch upload
+	acmeMaxBody = 1 << 20
+	// acmeMaxSkew is how far a request timestamp may drift from the local clock
+	acmeMaxSkew = 5 * time.Minute
+)
+
+// ACMEHandler accepts revocations from the ACME front end
+type ACMEHandler struct {
+	intake *acme.Intake
+	secret string
+}
+
+// NewACMEHandler creates an ACME intake handler; requests must be signed with secret
+// like outbound event webhooks
+func NewACMEHandler(intake *acme.Intake, secret string) *ACMEHandler {
+	return &ACMEHandler{intake: intake, secret: secret}
+}
+
+// RegisterRoutes mounts the ACME intake endpoint
+func (h *ACMEHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/acme/revocations", h.Revoke).Methods("POST")
+}
+
+// SelfAuthenticating marks the intake as checking its own request signatures instead of
+// needing an admin principal
+func (h *ACMEHandler) SelfAuthenticating() {}
+
+// Revoke applies a signed batch of revocations atomically
+func (h *ACMEHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, acmeMaxBody))
+	if err != nil {
+		httputil.BadRequest(w, "failed to read request body")
+		return
+	}
+	if !h.verify(r, body) {
+		httputil.Unauthorized(w, "invalid request signature")
+		return
+	}
+
+	var req struct {
+		Revocations []acme.Revocation `json:"revocations"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		httputil.BadRequest(w, "invalid JSON body")
+		return
+	}
+	if len(req.Revocations) == 0 {
+		httputil.BadRequest(w, "no revocations provided")
+		return
+	}
+	if err := h.intake.Apply(r.Context(), req.Revocations); err != nil {
+		if errors.Is(err, acme.ErrInvalidRevocation) {
+			httputil.BadRequest(w, err.Error())
+			return
+		}
+		writeStoreError(w, err)
+		return
+	}
+
+	httputil.Success(w, map[string]int{"applied": len(req.Revocations)})
+}
+
+func (h *ACMEHandler) verify(r *http.Request, body []byte) bool {
+	timestamp := r.Header.Get(events.HeaderTimestamp)
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := time.Since(time.Unix(secs, 0)); skew > acmeMaxSkew || skew < -acmeMaxSkew {
+		return false
+	}
+
+	signature := strings.TrimPrefix(r.Header.Get(events.HeaderSignature), "sha256=")
+	expected := events.Sign(h.secret, timestamp, body)
+	return hmac.Equal([]byte(signature), []byte(expected))
+}
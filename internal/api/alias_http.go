@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gigvault/ocsp/internal/alias"
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// AliasHandler manages serial aliases, such as CT precertificate serials standing for the final
+// certificate's
+type AliasHandler struct {
+	aliases  *alias.Postgres
+	statuses storage.Store
+}
+
+// NewAliasHandler creates an alias handler. statuses is the store of stored statuses, without
+// aliases resolved, so an alias for a serial with a status of its own is refused
+func NewAliasHandler(aliases *alias.Postgres, statuses storage.Store) *AliasHandler {
+	return &AliasHandler{aliases: aliases, statuses: statuses}
+}
+
+// RegisterRoutes mounts the alias endpoints
+func (h *AliasHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/aliases/{alias}", h.Get).Methods("GET")
+	api.HandleFunc("/aliases/{alias}", h.Put).Methods("PUT")
+	api.HandleFunc("/aliases/{alias}", h.Delete).Methods("DELETE")
+}
+
+type aliasBody struct {
+	Alias  string `json:"alias"`
+	Serial string `json:"serial"`
+}
+
+// Get returns the serial an alias stands for
+func (h *AliasHandler) Get(w http.ResponseWriter, r *http.Request) {
+	name, ok := aliasVar(w, r)
+	if !ok {
+		return
+	}
+	serial, err := h.aliases.Resolve(r.Context(), name)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		httputil.NotFound(w, "no such alias")
+	case err != nil:
+		httputil.InternalError(w, err)
+	default:
+		httputil.Success(w, aliasBody{Alias: name, Serial: serial})
+	}
+}
+
+// Put makes the alias stand for the serial in a {"serial"} body. The alias must have no status
+// of its own, which would always win over it, and aliases cannot be chained
+func (h *AliasHandler) Put(w http.ResponseWriter, r *http.Request) {
+	name, ok := aliasVar(w, r)
+	if !ok {
+		return
+	}
+	var body aliasBody
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil {
+		httputil.BadRequest(w, "body must be a JSON object with the serial")
+		return
+	}
+	serial, err := bulk.NormalizeSerial(body.Serial)
+	if err != nil {
+		httputil.BadRequest(w, err.Error())
+		return
+	}
+	if serial == name {
+		httputil.BadRequest(w, "a serial cannot alias itself")
+		return
+	}
+
+	if _, err := h.statuses.Get(r.Context(), name); err == nil {
+		httputil.Conflict(w, "the alias has a status of its own, which would always be served instead")
+		return
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		httputil.InternalError(w, err)
+		return
+	}
+	switch err := h.aliases.Put(r.Context(), name, serial); {
+	case errors.Is(err, alias.ErrChain):
+		httputil.Conflict(w, err.Error())
+	case err != nil:
+		httputil.InternalError(w, err)
+	default:
+		httputil.Success(w, aliasBody{Alias: name, Serial: serial})
+	}
+}
+
+// Delete removes an alias
+func (h *AliasHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	name, ok := aliasVar(w, r)
+	if !ok {
+		return
+	}
+	switch err := h.aliases.Delete(r.Context(), name); {
+	case errors.Is(err, storage.ErrNotFound):
+		httputil.NotFound(w, "no such alias")
+	case err != nil:
+		httputil.InternalError(w, err)
+	default:
+		httputil.Success(w, map[string]string{"deleted": name})
+	}
+}
+
+func aliasVar(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name, err := bulk.NormalizeSerial(mux.Vars(r)["alias"])
+	if err != nil {
+		httputil.BadRequest(w, err.Error())
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gigvault/ocsp/internal/apikeys"
+	"github.com/gigvault/ocsp/internal/pagetoken"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// defaultAPIKeyLimit is the size of an API key listing page without a limit parameter
+const defaultAPIKeyLimit = 100
+
+// apiKeyTokenScope names API key listings in page tokens
+const apiKeyTokenScope = "api-keys"
+
+// APIKeysHandler creates, lists, rotates and revokes the API keys of the gRPC mutation API
+type APIKeysHandler struct {
+	keys *apikeys.Manager
+}
+
+// NewAPIKeysHandler creates an API key handler
+func NewAPIKeysHandler(keys *apikeys.Manager) *APIKeysHandler {
+	return &APIKeysHandler{keys: keys}
+}
+
+// RegisterRoutes mounts the API key endpoints
+func (h *APIKeysHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/api-keys", h.List).Methods("GET")
+	api.HandleFunc("/api-keys", h.Create).Methods("POST")
+	api.HandleFunc("/api-keys/{id:[0-9a-f]+}", h.Get).Methods("GET")
+	api.HandleFunc("/api-keys/{id:[0-9a-f]+}/rotate", h.Rotate).Methods("POST")
+	api.HandleFunc("/api-keys/{id:[0-9a-f]+}/revoke", h.Revoke).Methods("POST")
+}
+
+type apiKeyPage struct {
+	Keys []apikeys.Key `json:"keys"`
+	// NextPageToken is the page_token of the next page, or empty when this page is the last
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// issuedAPIKey is a key with its token, which is only ever returned here
+type issuedAPIKey struct {
+	Key   *apikeys.Key `json:"key"`
+	Token string       `json:"token"`
+}
+
+// List returns keys ordered by ID, of the tenant parameter when it is set; page_token and limit
+// page through them
+func (h *APIKeysHandler) List(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	tenant := query.Get("tenant")
+	filters := []string{tenant}
+	var after string
+	if value := query.Get("page_token"); value != "" {
+		var err error
+		if after, err = pagetoken.Decode(value, apiKeyTokenScope, filters); err != nil {
+			httputil.BadRequest(w, pagetoken.ErrInvalid.Error())
+			return
+		}
+	}
+	limit := defaultAPIKeyLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > apikeys.MaxLimit {
+			httputil.BadRequest(w, "limit must be between 1 and "+strconv.Itoa(apikeys.MaxLimit))
+			return
+		}
+	}
+
+	keys, err := h.keys.List(r.Context(), tenant, after, limit)
+	if err != nil {
+		httputil.InternalError(w, err)
+		return
+	}
+	page := apiKeyPage{Keys: keys}
+	if page.Keys == nil {
+		page.Keys = []apikeys.Key{}
+	}
+	if len(keys) == limit {
+		page.NextPageToken = pagetoken.Encode(apiKeyTokenScope, filters, keys[len(keys)-1].ID)
+	}
+	httputil.Success(w, page)
+}
+
+// Create adds a key from a {"tenant", "name", "issuers", "rate_per_second", "burst"} body and
+// returns it with its token, which cannot be retrieved again
+func (h *APIKeysHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var spec apikeys.Spec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		httputil.BadRequest(w, "invalid JSON body")
+		return
+	}
+	key, token, err := h.keys.Create(r.Context(), spec)
+	if err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+	httputil.Success(w, issuedAPIKey{Key: key, Token: token})
+}
+
+// Get returns one key
+func (h *APIKeysHandler) Get(w http.ResponseWriter, r *http.Request) {
+	h.act(w, r, h.keys.Get)
+}
+
+// Rotate gives a key a new token; the old one keeps working for the rotation grace period
+func (h *APIKeysHandler) Rotate(w http.ResponseWriter, r *http.Request) {
+	key, token, err := h.keys.Rotate(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+	httputil.Success(w, issuedAPIKey{Key: key, Token: token})
+}
+
+// Revoke stops a key from authenticating
+func (h *APIKeysHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	h.act(w, r, h.keys.Revoke)
+}
+
+func (h *APIKeysHandler) act(w http.ResponseWriter, r *http.Request, action func(ctx context.Context, id string) (*apikeys.Key, error)) {
+	key, err := action(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		writeAPIKeyError(w, err)
+		return
+	}
+	httputil.Success(w, key)
+}
+
+func writeAPIKeyError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, apikeys.ErrNotFound):
+		httputil.NotFound(w, err.Error())
+	case errors.Is(err, apikeys.ErrRevoked), errors.Is(err, apikeys.ErrNameTaken):
+		httputil.Conflict(w, err.Error())
+	case errors.Is(err, apikeys.ErrInvalid):
+		httputil.BadRequest(w, err.Error())
+	default:
+		httputil.InternalError(w, err)
+	}
+}
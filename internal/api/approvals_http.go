@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// ApprovalsHandler lists staged revocations and lets approvers decide them
+type ApprovalsHandler struct {
+	store *approval.Store
+}
+
+// NewApprovalsHandler creates an approval handler
+func NewApprovalsHandler(store *approval.Store) *ApprovalsHandler {
+	return &ApprovalsHandler{store: store}
+}
+
+// RegisterRoutes mounts the approval endpoints
+func (h *ApprovalsHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/approvals", h.List).Methods("GET")
+	api.HandleFunc("/approvals/{id:[0-9]+}", h.Get).Methods("GET")
+	api.HandleFunc("/approvals/{id:[0-9]+}/approve", h.Approve).Methods("POST")
+	api.HandleFunc("/approvals/{id:[0-9]+}/reject", h.Reject).Methods("POST")
+}
+
+// List returns the newest requests, filtered by the optional state query parameter
+func (h *ApprovalsHandler) List(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", approval.StatePending, approval.StateApplying, approval.StateApproved, approval.StateRejected:
+	default:
+		httputil.BadRequest(w, "state must be pending, applying, approved or rejected")
+		return
+	}
+	requests, err := h.store.List(r.Context(), state)
+	if err != nil {
+		httputil.InternalError(w, err)
+		return
+	}
+	httputil.Success(w, map[string]interface{}{"requests": requests})
+}
+
+// Get returns one request
+func (h *ApprovalsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, h.store.Request)
+}
+
+// Approve applies a pending request; the caller must be an approver other than the requester
+func (h *ApprovalsHandler) Approve(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, h.store.Approve)
+}
+
+// Reject discards a pending request
+func (h *ApprovalsHandler) Reject(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, h.store.Reject)
+}
+
+func (h *ApprovalsHandler) decide(w http.ResponseWriter, r *http.Request, action func(ctx context.Context, id int64) (*approval.Request, error)) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httputil.BadRequest(w, "invalid request id")
+		return
+	}
+	req, err := action(r.Context(), id)
+	switch {
+	case err == nil:
+		httputil.Success(w, req)
+	case errors.Is(err, approval.ErrNotFound):
+		httputil.NotFound(w, err.Error())
+	case errors.Is(err, approval.ErrNotPending):
+		httputil.Conflict(w, err.Error())
+	case errors.Is(err, approval.ErrSelfApproval), errors.Is(err, approval.ErrNotApprover):
+		httputil.Forbidden(w, err.Error())
+	default:
+		writeStoreError(w, err)
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/audit"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// AuditHandler serves signed exports of the audit trail
+type AuditHandler struct {
+	exporter *audit.Exporter
+}
+
+// NewAuditHandler creates a new audit export handler
+func NewAuditHandler(exporter *audit.Exporter) *AuditHandler {
+	return &AuditHandler{exporter: exporter}
+}
+
+// RegisterRoutes mounts the audit endpoints
+func (h *AuditHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/audit/export", h.Export).Methods("GET")
+}
+
+// Export streams the changes recorded between the from and to query parameters (RFC 3339; to
+// defaults to now) as a signed JSONL bundle
+func (h *AuditHandler) Export(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, err := time.Parse(time.RFC3339, query.Get("from"))
+	if err != nil {
+		httputil.BadRequest(w, "from must be an RFC 3339 time")
+		return
+	}
+	to := time.Now()
+	if value := query.Get("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			httputil.BadRequest(w, "to must be an RFC 3339 time")
+			return
+		}
+	}
+	if !from.Before(to) {
+		httputil.BadRequest(w, "from must be before to")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="ocsp-audit-%s-%s.jsonl"`,
+		from.UTC().Format("20060102T150405Z"), to.UTC().Format("20060102T150405Z")))
+	// Headers are already sent once streaming starts, so a failure can only cut the export
+	// short; verification rejects exports without a valid trailer
+	h.exporter.Export(r.Context(), w, from, to)
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/ocsp/internal/backup"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// BackupHandler serves backup downloads and restores
+type BackupHandler struct {
+	store   *storage.Postgres
+	key     []byte
+	service string
+}
+
+// NewBackupHandler creates a new backup handler signing archives with key
+func NewBackupHandler(store *storage.Postgres, key []byte, service string) *BackupHandler {
+	return &BackupHandler{store: store, key: key, service: service}
+}
+
+// RegisterRoutes mounts the backup endpoints; both need an admin principal
+func (h *BackupHandler) RegisterRoutes(api *mux.Router) {
+	api.Handle("/backup", approval.RequirePrincipal(http.HandlerFunc(h.Backup))).Methods("GET")
+	api.Handle("/restore", approval.RequirePrincipal(http.HandlerFunc(h.Restore))).Methods("POST")
+}
+
+// Backup streams a signed archive of all revocation state
+func (h *BackupHandler) Backup(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="ocsp-backup-%s.ndjson.gz"`,
+		time.Now().UTC().Format("20060102T150405Z")))
+	// Headers are already sent once streaming starts, so a failure can only cut the archive
+	// short; restores reject archives without a valid trailer
+	backup.Write(r.Context(), w, h.store, h.key, h.service)
+}
+
+// Restore loads an archive from the request body into an empty database
+func (h *BackupHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	summary, err := backup.Restore(r.Context(), r.Body, h.store, h.key)
+	switch {
+	case errors.Is(err, storage.ErrNotEmpty):
+		httputil.Conflict(w, err.Error())
+	case errors.Is(err, backup.ErrBadSignature):
+		httputil.BadRequest(w, err.Error())
+	case err != nil:
+		httputil.InternalError(w, err)
+	default:
+		httputil.Success(w, summary)
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// BulkHandler serves bulk status uploads
+type BulkHandler struct {
+	importer *bulk.Importer
+}
+
+// NewBulkHandler creates a new bulk upload handler
+func NewBulkHandler(importer *bulk.Importer) *BulkHandler {
+	return &BulkHandler{importer: importer}
+}
+
+// RegisterRoutes mounts the bulk import endpoint
+func (h *BulkHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/statuses/import", h.Import).Methods("POST")
+}
+
+// Import applies CSV or NDJSON rows from the request body, streaming NDJSON events back
+// with per-row errors and progress after every applied batch. With ?dry_run=true nothing
+// is written and the would-be changes are streamed instead
+func (h *BulkHandler) Import(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = uploadFormat(r.Header.Get("Content-Type"))
+	}
+	if format != bulk.FormatCSV && format != bulk.FormatNDJSON {
+		httputil.BadRequest(w, "format must be csv or ndjson (set ?format= or Content-Type)")
+		return
+	}
+
+	// Large uploads outlive the server-wide read and write timeouts
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	emit := func(event bulk.Event) {
+		encoder.Encode(event)
+		rc.Flush()
+	}
+
+	run := h.importer.Import
+	if dryRunRequested(r) {
+		run = h.importer.DryRun
+	}
+	summary, err := run(r.Context(), format, r.Body, emit)
+	if err != nil {
+		summary.Type = "aborted"
+		summary.Error = err.Error()
+	}
+	emit(summary)
+}
+
+// dryRunRequested reports whether the dry_run query parameter is set to a true value
+func dryRunRequested(r *http.Request) bool {
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	return dryRun
+}
+
+func uploadFormat(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return bulk.FormatCSV
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return bulk.FormatNDJSON
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gigvault/ocsp/internal/compromise"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// CompromiseHandler runs the key compromise response
+type CompromiseHandler struct {
+	responder *compromise.Responder
+}
+
+// NewCompromiseHandler creates a compromise handler
+func NewCompromiseHandler(responder *compromise.Responder) *CompromiseHandler {
+	return &CompromiseHandler{responder: responder}
+}
+
+// RegisterRoutes mounts the compromise endpoints
+func (h *CompromiseHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/compromise", h.Status).Methods("GET")
+	api.HandleFunc("/compromise", h.Respond).Methods("POST")
+}
+
+type compromiseRequest struct {
+	// Issuer is the hex SHA-256 key hash of the active signing certificate, so an operator
+	// cannot take out a key other than the one they looked up
+	Issuer string `json:"issuer"`
+}
+
+// Status returns the active and standby keys and the recorded compromises
+func (h *CompromiseHandler) Status(w http.ResponseWriter, r *http.Request) {
+	status, err := h.responder.Status(r.Context())
+	if err != nil {
+		httputil.InternalError(w, err)
+		return
+	}
+	httputil.Success(w, status)
+}
+
+// Respond records the active key as compromised, revokes its certificate and switches to the
+// standby key
+func (h *CompromiseHandler) Respond(w http.ResponseWriter, r *http.Request) {
+	var req compromiseRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || req.Issuer == "" {
+		httputil.BadRequest(w, "body must be a JSON object with the issuer key hash")
+		return
+	}
+
+	result, err := h.responder.Respond(r.Context(), req.Issuer)
+	switch {
+	case err == nil:
+		httputil.Success(w, result)
+	case errors.Is(err, compromise.ErrIssuerMismatch):
+		httputil.Conflict(w, err.Error())
+	case errors.Is(err, compromise.ErrNoStandby):
+		httputil.Error(w, http.StatusPreconditionFailed, "no_standby_key", err.Error())
+	case result != nil:
+		// The compromise is recorded, so replicas will keep trying to switch; report what failed
+		httputil.Error(w, http.StatusInternalServerError, "incomplete_response", err.Error())
+	default:
+		httputil.InternalError(w, err)
+	}
+}
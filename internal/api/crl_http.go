@@ -0,0 +1,98 @@
+package api
+
+import (
+	"crypto/x509"
+	"net/http"
+
+	"github.com/gigvault/ocsp/internal/crl"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// CRLHandler serves CRL administration endpoints
+type CRLHandler struct {
+	importer *crl.Importer
+}
+
+// NewCRLHandler creates a new CRL handler
+func NewCRLHandler(importer *crl.Importer) *CRLHandler {
+	return &CRLHandler{importer: importer}
+}
+
+// RegisterRoutes mounts the CRL endpoints
+func (h *CRLHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/crl/import", h.Import).Methods("POST")
+	api.HandleFunc("/crl/reconcile", h.Reconcile).Methods("POST")
+}
+
+// Import applies a CRL uploaded in the request body (PEM or DER), or fetched from the url query
+// parameter. With ?dry_run=true it reports the changes the import would make instead
+func (h *CRLHandler) Import(w http.ResponseWriter, r *http.Request) {
+	list, err := h.readCRL(r)
+	if err != nil {
+		httputil.BadRequest(w, err.Error())
+		return
+	}
+
+	if dryRunRequested(r) {
+		plan, err := h.importer.DryRun(r.Context(), list)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		httputil.Success(w, plan)
+		return
+	}
+
+	result, err := h.importer.Apply(r.Context(), list)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	httputil.Success(w, result)
+}
+
+// Reconcile reports discrepancies between a CRL, supplied like Import, and the status database
+func (h *CRLHandler) Reconcile(w http.ResponseWriter, r *http.Request) {
+	list, err := h.readCRL(r)
+	if err != nil {
+		httputil.BadRequest(w, err.Error())
+		return
+	}
+
+	result, err := h.importer.Reconcile(r.Context(), list)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	httputil.Success(w, result)
+}
+
+// readCRL reads, parses, and verifies the CRL from the request body or url query parameter
+func (h *CRLHandler) readCRL(r *http.Request) (*x509.RevocationList, error) {
+	var data []byte
+	if url := r.URL.Query().Get("url"); url != "" {
+		fetched, err := h.importer.Fetch(r.Context(), url)
+		if err != nil {
+			return nil, err
+		}
+		data = fetched
+	} else {
+		body, err := crl.ReadLimited(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		data = body
+	}
+
+	list, err := crl.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.importer.Verify(list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
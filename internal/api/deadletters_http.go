@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/deadletter"
+	"github.com/gigvault/ocsp/internal/pagetoken"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// Sizes of dead letter listings and replays
+const (
+	defaultDeadLetterLimit = 100
+	defaultReplayLimit     = 1000
+	maxReplayLimit         = 100000
+)
+
+// deadLetterTokenScope names dead letter listings in page tokens
+const deadLetterTokenScope = "dead-letters"
+
+// DeadLettersHandler lists failed import and batch rows and lets operators fix, replay or
+// discard them
+type DeadLettersHandler struct {
+	queue *deadletter.Queue
+}
+
+// NewDeadLettersHandler creates a dead letter handler
+func NewDeadLettersHandler(queue *deadletter.Queue) *DeadLettersHandler {
+	return &DeadLettersHandler{queue: queue}
+}
+
+// RegisterRoutes mounts the dead letter endpoints
+func (h *DeadLettersHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/dead-letters", h.List).Methods("GET")
+	api.HandleFunc("/dead-letters/replay", h.ReplayPending).Methods("POST")
+	api.HandleFunc("/dead-letters/{id:[0-9]+}", h.Get).Methods("GET")
+	api.HandleFunc("/dead-letters/{id:[0-9]+}", h.Fix).Methods("PUT")
+	api.HandleFunc("/dead-letters/{id:[0-9]+}/replay", h.Replay).Methods("POST")
+	api.HandleFunc("/dead-letters/{id:[0-9]+}/discard", h.Discard).Methods("POST")
+}
+
+type deadLetterPage struct {
+	Items []deadletter.Item `json:"items"`
+	// NextPageToken is the page_token of the next page, or empty when this page is the last
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// List returns items oldest first, filtered by the optional state and source parameters;
+// page_token and limit page through them
+func (h *DeadLettersHandler) List(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := deadletter.Filter{State: query.Get("state"), Source: query.Get("source"), Limit: defaultDeadLetterLimit}
+	switch filter.State {
+	case "", deadletter.StatePending, deadletter.StateReplayed, deadletter.StateDiscarded:
+	default:
+		httputil.BadRequest(w, "state must be pending, replayed or discarded")
+		return
+	}
+	if !validDeadLetterSource(filter.Source) {
+		httputil.BadRequest(w, "source must be import, batch or write_behind")
+		return
+	}
+	filters := []string{filter.State, filter.Source}
+	if value := query.Get("page_token"); value != "" {
+		after, err := pagetoken.Decode(value, deadLetterTokenScope, filters)
+		if err == nil {
+			filter.AfterID, err = strconv.ParseInt(after, 10, 64)
+		}
+		if err != nil {
+			httputil.BadRequest(w, pagetoken.ErrInvalid.Error())
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 || filter.Limit > deadletter.MaxLimit {
+			httputil.BadRequest(w, "limit must be between 1 and "+strconv.Itoa(deadletter.MaxLimit))
+			return
+		}
+	}
+
+	items, err := h.queue.List(r.Context(), filter)
+	if err != nil {
+		httputil.InternalError(w, err)
+		return
+	}
+	page := deadLetterPage{Items: items}
+	if page.Items == nil {
+		page.Items = []deadletter.Item{}
+	}
+	if len(items) == filter.Limit {
+		page.NextPageToken = pagetoken.Encode(deadLetterTokenScope, filters, strconv.FormatInt(items[len(items)-1].ID, 10))
+	}
+	httputil.Success(w, page)
+}
+
+// Get returns one item
+func (h *DeadLettersHandler) Get(w http.ResponseWriter, r *http.Request) {
+	h.act(w, r, h.queue.Get)
+}
+
+// Fix replaces the row of a pending item from a {"serial", "status", "reason", "date"} body,
+// which must validate
+func (h *DeadLettersHandler) Fix(w http.ResponseWriter, r *http.Request) {
+	var row bulk.Row
+	if err := json.NewDecoder(r.Body).Decode(&row); err != nil {
+		httputil.BadRequest(w, "invalid JSON body")
+		return
+	}
+	h.act(w, r, func(ctx context.Context, id int64) (*deadletter.Item, error) {
+		return h.queue.Fix(ctx, id, row)
+	})
+}
+
+// Replay applies a pending item's row to the status store
+func (h *DeadLettersHandler) Replay(w http.ResponseWriter, r *http.Request) {
+	h.act(w, r, h.queue.Replay)
+}
+
+// Discard gives up on a pending item
+func (h *DeadLettersHandler) Discard(w http.ResponseWriter, r *http.Request) {
+	h.act(w, r, h.queue.Discard)
+}
+
+// ReplayPending replays up to limit pending items, oldest first, of the optional source
+func (h *DeadLettersHandler) ReplayPending(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	source := query.Get("source")
+	if !validDeadLetterSource(source) {
+		httputil.BadRequest(w, "source must be import, batch or write_behind")
+		return
+	}
+	limit := defaultReplayLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxReplayLimit {
+			httputil.BadRequest(w, "limit must be between 1 and "+strconv.Itoa(maxReplayLimit))
+			return
+		}
+	}
+
+	summary, err := h.queue.ReplayPending(r.Context(), source, limit)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	httputil.Success(w, summary)
+}
+
+func (h *DeadLettersHandler) act(w http.ResponseWriter, r *http.Request, action func(ctx context.Context, id int64) (*deadletter.Item, error)) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httputil.BadRequest(w, "invalid dead letter id")
+		return
+	}
+	item, err := action(r.Context(), id)
+	var pending *approval.PendingError
+	switch {
+	case err == nil:
+		httputil.Success(w, item)
+	case errors.As(err, &pending):
+		// Replayed into an approval request
+		writeStoreError(w, err)
+	case errors.Is(err, deadletter.ErrNotFound):
+		httputil.NotFound(w, err.Error())
+	case errors.Is(err, deadletter.ErrNotPending):
+		httputil.Conflict(w, err.Error())
+	case errors.Is(err, deadletter.ErrInvalid):
+		httputil.Error(w, http.StatusUnprocessableEntity, "invalid_row", err.Error())
+	default:
+		writeStoreError(w, err)
+	}
+}
+
+func validDeadLetterSource(source string) bool {
+	return source == "" || source == bulk.SourceImport || source == bulk.SourceBatch || source == bulk.SourceWriteBehind
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// ExpiryStore keeps statuses and the expiry of their certificates
+type ExpiryStore interface {
+	storage.Store
+	storage.ExpiryRecorder
+}
+
+// ExpiryHandler records certificate expiry for deployments that do not sync it from the CA
+type ExpiryHandler struct {
+	statuses ExpiryStore
+}
+
+// NewExpiryHandler creates a certificate expiry handler
+func NewExpiryHandler(statuses ExpiryStore) *ExpiryHandler {
+	return &ExpiryHandler{statuses: statuses}
+}
+
+// RegisterRoutes mounts the certificate expiry endpoint
+func (h *ExpiryHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/statuses/{serial}/expiry", h.Put).Methods("PUT")
+}
+
+type expiryBody struct {
+	Serial   string    `json:"serial"`
+	NotAfter time.Time `json:"not_after"`
+}
+
+// Put records the notAfter, in RFC 3339, of a certificate with a stored status from a
+// {"not_after"} body
+func (h *ExpiryHandler) Put(w http.ResponseWriter, r *http.Request) {
+	serial, err := bulk.NormalizeSerial(mux.Vars(r)["serial"])
+	if err != nil {
+		httputil.BadRequest(w, err.Error())
+		return
+	}
+	var body expiryBody
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil || body.NotAfter.IsZero() {
+		httputil.BadRequest(w, "body must be a JSON object with not_after in RFC 3339")
+		return
+	}
+
+	// RecordExpiry ignores serials without a status, so check first to report them
+	if _, err := h.statuses.Get(r.Context(), serial); errors.Is(err, storage.ErrNotFound) {
+		httputil.NotFound(w, "no status for this serial")
+		return
+	} else if err != nil {
+		httputil.InternalError(w, err)
+		return
+	}
+	if err := h.statuses.RecordExpiry(r.Context(), map[string]time.Time{serial: body.NotAfter}); err != nil {
+		httputil.InternalError(w, err)
+		return
+	}
+	httputil.Success(w, expiryBody{Serial: serial, NotAfter: body.NotAfter.UTC()})
+}
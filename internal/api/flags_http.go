@@ -0,0 +1,93 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gigvault/ocsp/internal/featureflag"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// FlagsHandler reports feature flags and, when they are database-backed, changes them
+type FlagsHandler struct {
+	set *featureflag.Set
+	db  *featureflag.Postgres
+}
+
+// NewFlagsHandler creates a feature flag handler; db may be nil when flags come from the
+// configuration only
+func NewFlagsHandler(set *featureflag.Set, db *featureflag.Postgres) *FlagsHandler {
+	return &FlagsHandler{set: set, db: db}
+}
+
+// RegisterRoutes mounts the feature flag endpoints
+func (h *FlagsHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/flags", h.List).Methods("GET")
+	api.HandleFunc("/flags/{name}", h.Put).Methods("PUT")
+	api.HandleFunc("/flags/{name}", h.Delete).Methods("DELETE")
+}
+
+// List returns the effective rule of every known flag
+func (h *FlagsHandler) List(w http.ResponseWriter, r *http.Request) {
+	httputil.Success(w, h.set.Rules())
+}
+
+// Put stores a rule from an {"enabled", "percent", "serials"} body for every replica and
+// applies it here immediately; other replicas pick it up on their next refresh
+func (h *FlagsHandler) Put(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.writable(w, r)
+	if !ok {
+		return
+	}
+	var rule featureflag.Rule
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&rule); err != nil {
+		httputil.BadRequest(w, "invalid JSON body")
+		return
+	}
+	if err := rule.Validate(); err != nil {
+		httputil.BadRequest(w, err.Error())
+		return
+	}
+	if err := h.db.Save(r.Context(), name, rule); err != nil {
+		httputil.InternalError(w, err)
+		return
+	}
+	h.refresh(w, r)
+}
+
+// Delete removes the stored rule so the configured one applies again
+func (h *FlagsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	name, ok := h.writable(w, r)
+	if !ok {
+		return
+	}
+	if err := h.db.Delete(r.Context(), name); err != nil {
+		httputil.InternalError(w, err)
+		return
+	}
+	h.refresh(w, r)
+}
+
+func (h *FlagsHandler) writable(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if h.db == nil {
+		httputil.Conflict(w, "feature flags are configuration-only; enable feature_flags.database to change them at runtime")
+		return "", false
+	}
+	name := mux.Vars(r)["name"]
+	if !featureflag.Known(name) {
+		httputil.NotFound(w, "unknown feature flag")
+		return "", false
+	}
+	return name, true
+}
+
+func (h *FlagsHandler) refresh(w http.ResponseWriter, r *http.Request) {
+	rules, err := h.db.Load(r.Context())
+	if err != nil {
+		httputil.InternalError(w, err)
+		return
+	}
+	h.set.SetDatabase(rules)
+	httputil.Success(w, h.set.Rules())
+}
@@ -2,32 +2,72 @@ package api
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/dryrun"
+	"github.com/gigvault/ocsp/internal/failover"
+	"github.com/gigvault/ocsp/internal/guardrail"
+	"github.com/gigvault/ocsp/internal/logging"
+	"github.com/gigvault/ocsp/internal/mode"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/internal/writebehind"
 	"github.com/gigvault/shared/api/proto/ocsp"
 	"github.com/gigvault/shared/pkg/logger"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // OCSPGRPCServer implements the OCSP gRPC service
 type OCSPGRPCServer struct {
 	ocsp.UnimplementedOCSPServiceServer
-	db     *pgxpool.Pool
-	logger *logger.Logger
+	store       storage.Store
+	approvals   ApprovalGate
+	limits      BatchChecker
+	deadLetters bulk.DeadLetters
+	logger      *logger.Logger
 }
 
 // NewOCSPGRPCServer creates a new OCSP gRPC server
-func NewOCSPGRPCServer(db *pgxpool.Pool) *OCSPGRPCServer {
+func NewOCSPGRPCServer(store storage.Store) *OCSPGRPCServer {
 	return &OCSPGRPCServer{
-		db:     db,
-		logger: logger.Global(),
+		store:  store,
+		logger: logging.Component(logger.Global(), logging.ComponentGRPC),
 	}
 }
 
+// SetApprovalGate makes BatchUpdateStatus stage batches the approval policy applies to as one
+// request, rather than letting each item be staged on its own
+func (s *OCSPGRPCServer) SetApprovalGate(gate ApprovalGate) {
+	s.approvals = gate
+}
+
+// SetBatchChecker makes BatchUpdateStatus refuse a whole batch the checker rejects, rather
+// than applying its items until one of them crosses a limit
+func (s *OCSPGRPCServer) SetBatchChecker(checker BatchChecker) {
+	s.limits = checker
+}
+
+// SetDeadLetters makes BatchUpdateStatus keep the items it could not apply in deadLetters, so
+// they can be corrected and replayed
+func (s *OCSPGRPCServer) SetDeadLetters(deadLetters bulk.DeadLetters) {
+	s.deadLetters = deadLetters
+}
+
 // UpdateStatus updates the status of a certificate
 func (s *OCSPGRPCServer) UpdateStatus(ctx context.Context, req *ocsp.UpdateStatusRequest) (*ocsp.UpdateStatusResponse, error) {
 	s.logger.Info("Received UpdateStatus request",
@@ -35,46 +75,41 @@ func (s *OCSPGRPCServer) UpdateStatus(ctx context.Context, req *ocsp.UpdateStatu
 		zap.String("status", req.Status),
 	)
 
-	// Validate input
-	if req.SerialNumber == "" {
-		return nil, status.Error(codes.InvalidArgument, "serial number is required")
+	mask, err := updateMask(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if req.Status == "" {
-		req.Status = "good"
+	var update storage.Update
+	if mask != nil {
+		update, err = s.maskedUpdate(ctx, req, mask)
+	} else {
+		update, err = requestUpdate(ctx, req)
 	}
-
-	// Validate status value
-	if req.Status != "good" && req.Status != "revoked" && req.Status != "unknown" {
-		return nil, status.Error(codes.InvalidArgument, "invalid status (must be: good, revoked, or unknown)")
+	if err != nil {
+		return nil, err
 	}
 
-	// Insert or update OCSP status
-	query := `
-		INSERT INTO ocsp_responses (serial, status, this_update, next_update, revoked_at, revocation_reason)
-		VALUES ($1, $2, NOW(), NOW() + INTERVAL '24 hours', $3, $4)
-		ON CONFLICT (serial) DO UPDATE SET
-			status = EXCLUDED.status,
-			this_update = NOW(),
-			next_update = NOW() + INTERVAL '24 hours',
-			revoked_at = EXCLUDED.revoked_at,
-			revocation_reason = EXCLUDED.revocation_reason
-	`
-
-	var revokedAt *time.Time
-	if req.Status == "revoked" && req.RevokedAt != nil {
-		t := req.RevokedAt.AsTime()
-		revokedAt = &t
+	if hasMetadataFlag(ctx, OverrideTransitionMetadataKey) {
+		ctx = revocation.WithOverride(ctx)
+	}
+	if isDryRun(ctx) {
+		report, err := dryrun.Plan(ctx, s.store, []storage.Update{update})
+		if err != nil {
+			s.logger.Error("Failed to plan OCSP status update", zap.Error(err))
+			return nil, storeStatus(err, "failed to read current status")
+		}
+		return &ocsp.UpdateStatusResponse{
+			Success: true,
+			Message: describeChange(report.Changes[0]),
+		}, nil
 	}
 
-	_, err := s.db.Exec(ctx, query,
-		req.SerialNumber,
-		req.Status,
-		revokedAt,
-		req.RevocationReason,
-	)
-	if err != nil {
+	if hasMetadataFlag(ctx, SyncWriteMetadataKey) {
+		ctx = writebehind.WithSync(ctx)
+	}
+	if err := s.store.Upsert(ctx, update); err != nil {
 		s.logger.Error("Failed to update OCSP status", zap.Error(err))
-		return nil, status.Error(codes.Internal, "failed to update status")
+		return nil, storeStatus(err, "failed to update status")
 	}
 
 	s.logger.Info("OCSP status updated", zap.String("serial", req.SerialNumber))
@@ -87,55 +122,47 @@ func (s *OCSPGRPCServer) UpdateStatus(ctx context.Context, req *ocsp.UpdateStatu
 
 // CheckStatus checks the status of a certificate
 func (s *OCSPGRPCServer) CheckStatus(ctx context.Context, req *ocsp.CheckStatusRequest) (*ocsp.CheckStatusResponse, error) {
-	s.logger.Info("Received CheckStatus request", zap.String("serial", req.SerialNumber))
-
 	if req.SerialNumber == "" {
 		return nil, status.Error(codes.InvalidArgument, "serial number is required")
 	}
 
-	// Query OCSP status
-	query := `
-		SELECT status, this_update, next_update, revoked_at, revocation_reason
-		FROM ocsp_responses
-		WHERE serial = $1
-	`
-
-	var statusStr, revocationReason string
-	var thisUpdate, nextUpdate time.Time
-	var revokedAt *time.Time
-
-	err := s.db.QueryRow(ctx, query, req.SerialNumber).Scan(
-		&statusStr,
-		&thisUpdate,
-		&nextUpdate,
-		&revokedAt,
-		&revocationReason,
-	)
+	rec, err := s.store.Get(ctx, req.SerialNumber)
+	if errors.Is(err, mode.ErrMaintenance) || errors.Is(err, failover.ErrFailover) {
+		return nil, storeStatus(err, "")
+	}
+	// Running out of time is not a reason to answer unknown
+	if err != nil && ctx.Err() != nil {
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
 	if err != nil {
 		// Certificate not found - return unknown status
-		s.logger.Warn("Certificate status not found", zap.String("serial", req.SerialNumber))
+		if errors.Is(err, storage.ErrNotFound) {
+			s.logger.Warn("Certificate status not found", zap.String("serial", req.SerialNumber))
+		} else {
+			s.logger.Error("Failed to query OCSP status", zap.String("serial", req.SerialNumber), zap.Error(err))
+		}
 		return &ocsp.CheckStatusResponse{
-			Status:     "unknown",
+			Status:     storage.StatusUnknown,
 			ThisUpdate: timestamppb.Now(),
 			NextUpdate: timestamppb.New(time.Now().Add(24 * time.Hour)),
 		}, nil
 	}
 
 	resp := &ocsp.CheckStatusResponse{
-		Status:     statusStr,
-		ThisUpdate: timestamppb.New(thisUpdate),
-		NextUpdate: timestamppb.New(nextUpdate),
+		Status:     rec.Status,
+		ThisUpdate: timestamppb.New(rec.ThisUpdate),
+		NextUpdate: timestamppb.New(rec.NextUpdate),
 	}
 
-	if revokedAt != nil {
-		resp.RevokedAt = timestamppb.New(*revokedAt)
-		resp.RevocationReason = revocationReason
+	if rec.RevokedAt != nil {
+		resp.RevokedAt = timestamppb.New(*rec.RevokedAt)
+		resp.RevocationReason = rec.RevocationReason
 	}
 
-	s.logger.Info("OCSP status checked",
-		zap.String("serial", req.SerialNumber),
-		zap.String("status", statusStr),
-	)
+	// Lookups are the hot path: the entry and its fields are only built when debug is enabled
+	if entry := s.logger.Check(zap.DebugLevel, "OCSP status checked"); entry != nil {
+		entry.Write(zap.String("serial", req.SerialNumber), zap.String("status", rec.Status))
+	}
 
 	return resp, nil
 }
@@ -144,19 +171,53 @@ func (s *OCSPGRPCServer) CheckStatus(ctx context.Context, req *ocsp.CheckStatusR
 func (s *OCSPGRPCServer) BatchUpdateStatus(ctx context.Context, req *ocsp.BatchUpdateStatusRequest) (*ocsp.BatchUpdateStatusResponse, error) {
 	s.logger.Info("Received BatchUpdateStatus request", zap.Int("count", len(req.Updates)))
 
+	if mask, err := updateMask(ctx); err != nil || mask != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%s applies to UpdateStatus only", UpdateMaskMetadataKey)
+	}
+
+	if hasMetadataFlag(ctx, OverrideTransitionMetadataKey) {
+		ctx = revocation.WithOverride(ctx)
+	}
+	if isDryRun(ctx) {
+		return s.planBatch(ctx, req)
+	}
+
+	if s.approvals != nil {
+		if resp, staged, err := s.stageBatch(ctx, req); staged {
+			return resp, err
+		}
+	}
+	if s.limits != nil {
+		var err error
+		if ctx, err = s.checkBatch(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+
 	successCount := 0
 	failureCount := 0
 	var errors []string
+	var failures []bulk.Failure
 
-	for _, update := range req.Updates {
+	for i, update := range req.Updates {
 		_, err := s.UpdateStatus(ctx, update)
+		// Nothing in the batch can succeed while writes are suspended or the write-behind
+		// queue is full, nor once the call's deadline has passed
+		if code := status.Code(err); code == codes.FailedPrecondition || code == codes.Unavailable || code == codes.ResourceExhausted {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, status.Errorf(status.FromContextError(ctx.Err()).Code(), "%v after %d of %d updates", ctx.Err(), successCount, len(req.Updates))
+		}
 		if err != nil {
 			failureCount++
 			errors = append(errors, err.Error())
+			failures = append(failures, batchFailure(i, update, err))
 		} else {
 			successCount++
 		}
 	}
+	errors = s.deadLetter(ctx, failures, errors)
 
 	s.logger.Info("Batch update completed",
 		zap.Int("success", successCount),
@@ -169,3 +230,390 @@ func (s *OCSPGRPCServer) BatchUpdateStatus(ctx context.Context, req *ocsp.BatchU
 		Errors:       errors,
 	}, nil
 }
+
+// deadLetter keeps the failed items of a batch as dead letters, adding to errs when they could
+// not be kept
+func (s *OCSPGRPCServer) deadLetter(ctx context.Context, failures []bulk.Failure, errs []string) []string {
+	if s.deadLetters == nil || len(failures) == 0 {
+		return errs
+	}
+	if err := s.deadLetters.Add(ctx, bulk.SourceBatch, failures); err != nil {
+		s.logger.Error("Failed to dead-letter batch items", zap.Int("count", len(failures)), zap.Error(err))
+		return append(errs, fmt.Sprintf("failed to dead-letter %d failed items: %v", len(failures), err))
+	}
+	return errs
+}
+
+// batchFailure describes the failed item at index i of a batch as a row, as it was submitted
+func batchFailure(i int, req *ocsp.UpdateStatusRequest, err error) bulk.Failure {
+	row := bulk.Row{Serial: req.SerialNumber, Status: req.Status, Reason: req.RevocationReason}
+	if row.Status == "" {
+		row.Status = storage.StatusGood
+	}
+	if req.RevokedAt != nil {
+		row.Date = req.RevokedAt.AsTime().UTC().Format(time.RFC3339Nano)
+	}
+	return bulk.Failure{Line: i + 1, Row: row, Error: status.Convert(err).Message()}
+}
+
+// ApprovalGate reports whether a batch of updates would be staged for a second approval
+type ApprovalGate interface {
+	RequiresApproval(updates []storage.Update) bool
+}
+
+// BatchChecker refuses batches of updates that must not be written together. Checked marks a
+// context whose writes belong to a batch that passed
+type BatchChecker interface {
+	Check(ctx context.Context, updates []storage.Update) error
+	Checked(ctx context.Context) context.Context
+}
+
+func (s *OCSPGRPCServer) checkBatch(ctx context.Context, req *ocsp.BatchUpdateStatusRequest) (context.Context, error) {
+	updates := make([]storage.Update, 0, len(req.Updates))
+	for _, r := range req.Updates {
+		if update, err := requestUpdate(ctx, r); err == nil {
+			updates = append(updates, update)
+		}
+	}
+	if err := s.limits.Check(ctx, updates); err != nil {
+		s.logger.Warn("Batch refused", zap.Int("count", len(updates)), zap.Error(err))
+		return ctx, storeStatus(err, "failed to check batch")
+	}
+	return s.limits.Checked(ctx), nil
+}
+
+// stageBatch submits a batch the approval policy applies to as a whole, so it is staged as one
+// request instead of item by item. staged is false when the batch needs no approval
+func (s *OCSPGRPCServer) stageBatch(ctx context.Context, req *ocsp.BatchUpdateStatusRequest) (*ocsp.BatchUpdateStatusResponse, bool, error) {
+	var failures []string
+	var invalid []bulk.Failure
+	updates := make([]storage.Update, 0, len(req.Updates))
+	for i, r := range req.Updates {
+		update, err := requestUpdate(ctx, r)
+		if err != nil {
+			failures = append(failures, err.Error())
+			invalid = append(invalid, batchFailure(i, r, err))
+			continue
+		}
+		updates = append(updates, update)
+	}
+	if len(updates) == 0 || !s.approvals.RequiresApproval(updates) {
+		return nil, false, nil
+	}
+
+	err := s.store.ApplyBatch(ctx, updates)
+	var pending *approval.PendingError
+	if !errors.As(err, &pending) {
+		if err == nil {
+			return &ocsp.BatchUpdateStatusResponse{
+				SuccessCount: int32(len(updates)),
+				FailureCount: int32(len(invalid)),
+				Errors:       s.deadLetter(ctx, invalid, failures),
+			}, true, nil
+		}
+		return nil, true, storeStatus(err, "failed to stage batch")
+	}
+	s.logger.Info("Batch staged for approval", zap.Int64("request", pending.ID), zap.Int("count", len(updates)))
+	return &ocsp.BatchUpdateStatusResponse{
+		FailureCount: int32(len(req.Updates)),
+		Errors:       append(s.deadLetter(ctx, invalid, failures), pending.Error()),
+	}, true, nil
+}
+
+// storeStatus converts a store error to a gRPC status code, or INTERNAL with msg
+func storeStatus(err error, msg string) error {
+	var maintenance *mode.MaintenanceError
+	var pending *approval.PendingError
+	var limit *guardrail.LimitError
+	var transition *revocation.TransitionError
+	switch {
+	case errors.As(err, &transition):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, mode.ErrReadOnly):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, writebehind.ErrFull):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return status.FromContextError(err).Err()
+	case errors.As(err, &pending):
+		st := status.New(codes.FailedPrecondition, err.Error())
+		if detailed, detailErr := st.WithDetails(&errdetails.ErrorInfo{
+			Reason:   "APPROVAL_PENDING",
+			Domain:   "ocsp.gigvault",
+			Metadata: map[string]string{"request_id": strconv.FormatInt(pending.ID, 10)},
+		}); detailErr == nil {
+			st = detailed
+		}
+		return st.Err()
+	case errors.Is(err, approval.ErrAnonymous):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.As(err, &limit):
+		st := status.New(codes.FailedPrecondition, err.Error())
+		info := &errdetails.ErrorInfo{
+			Reason:   "REVOCATION_LIMIT",
+			Domain:   "ocsp.gigvault",
+			Metadata: map[string]string{"rule": limit.Rule},
+		}
+		if limit.Token != "" {
+			info.Metadata["confirmation"] = limit.Token
+		}
+		if detailed, detailErr := st.WithDetails(info); detailErr == nil {
+			st = detailed
+		}
+		return st.Err()
+	case errors.Is(err, failover.ErrFailover):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.As(err, &maintenance):
+		st := status.New(codes.Unavailable, err.Error())
+		if detailed, detailErr := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(maintenance.RetryAfter)}); detailErr == nil {
+			st = detailed
+		}
+		return st.Err()
+	}
+	return status.Error(codes.Internal, msg)
+}
+
+// DryRunMetadataKey is the request metadata key that turns UpdateStatus and BatchUpdateStatus
+// into dry runs: the updates are validated and compared with stored statuses, but not written
+const DryRunMetadataKey = "x-dry-run"
+
+// SyncWriteMetadataKey is the request metadata key that makes UpdateStatus and
+// BatchUpdateStatus write before acknowledging, even when write-behind queues their status
+const SyncWriteMetadataKey = "x-write-sync"
+
+// OverrideTransitionMetadataKey is the request metadata key that lets UpdateStatus and
+// BatchUpdateStatus release a revocation other than certificateHold
+const OverrideTransitionMetadataKey = "x-override-transition"
+
+// ValidityMetadataKey is the request metadata key that sets how long signed responses about the
+// certificates in UpdateStatus and BatchUpdateStatus are valid, such as "1h" for a certificate
+// under investigation, overriding the issuer's default until it is set to 0
+const ValidityMetadataKey = "x-response-validity"
+
+// UpdateMaskMetadataKey is the request metadata key listing the fields a partial UpdateStatus
+// changes, as in a FieldMask's JSON form, or under its "-bin" variant as a serialized FieldMask
+const UpdateMaskMetadataKey = "x-update-mask"
+
+// updateMaskPaths are the paths an update mask may name
+var updateMaskPaths = map[string]bool{
+	"status":            true,
+	"revoked_at":        true,
+	"revocation_reason": true,
+	"validity":          true,
+}
+
+func isDryRun(ctx context.Context) bool {
+	return hasMetadataFlag(ctx, DryRunMetadataKey)
+}
+
+// hasMetadataFlag reports whether the request metadata sets key to true
+func hasMetadataFlag(ctx context.Context, key string) bool {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get(key) {
+		if value == "true" || value == "1" {
+			return true
+		}
+	}
+	return false
+}
+
+// requestUpdate validates an UpdateStatus request and converts it to a status update, with the
+// validity override from the request metadata
+func requestUpdate(ctx context.Context, req *ocsp.UpdateStatusRequest) (storage.Update, error) {
+	if req.SerialNumber == "" {
+		return storage.Update{}, status.Error(codes.InvalidArgument, "serial number is required")
+	}
+	statusValue := req.Status
+	if statusValue == "" {
+		statusValue = storage.StatusGood
+	}
+	if !storage.ValidStatus(statusValue) {
+		return storage.Update{}, status.Error(codes.InvalidArgument, "invalid status (must be: good, revoked, or unknown)")
+	}
+
+	update := storage.Update{
+		Serial:           req.SerialNumber,
+		Status:           statusValue,
+		RevocationReason: req.RevocationReason,
+	}
+	if statusValue == storage.StatusRevoked && req.RevokedAt != nil {
+		t := req.RevokedAt.AsTime()
+		update.RevokedAt = &t
+	}
+	validity, err := responseValidity(ctx)
+	if err != nil {
+		return storage.Update{}, err
+	}
+	update.Validity = validity
+	return update, nil
+}
+
+// updateMask returns the update mask the request metadata sets, or nil when it sets none
+func updateMask(ctx context.Context) (*fieldmaskpb.FieldMask, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	binary, text := md.Get(UpdateMaskMetadataKey+"-bin"), md.Get(UpdateMaskMetadataKey)
+	if len(binary) == 0 && len(text) == 0 {
+		return nil, nil
+	}
+
+	mask := &fieldmaskpb.FieldMask{}
+	for _, value := range binary {
+		var decoded fieldmaskpb.FieldMask
+		if err := proto.Unmarshal([]byte(value), &decoded); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%s-bin is not a serialized FieldMask: %v", UpdateMaskMetadataKey, err)
+		}
+		mask.Paths = append(mask.Paths, decoded.Paths...)
+	}
+	for _, value := range text {
+		for _, path := range strings.Split(value, ",") {
+			mask.Paths = append(mask.Paths, strings.TrimSpace(path))
+		}
+	}
+	for _, path := range mask.Paths {
+		if !updateMaskPaths[path] {
+			return nil, status.Errorf(codes.InvalidArgument, "update mask path %q must be status, revoked_at, revocation_reason or validity", path)
+		}
+	}
+	if len(mask.Paths) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "update mask names no fields")
+	}
+	mask.Normalize()
+	return mask, nil
+}
+
+// maskedUpdate converts an UpdateStatus request to a status update that changes only the fields
+// in mask, taking the rest from the stored status. Revocation details of a status that ends up
+// other than revoked are cleared
+func (s *OCSPGRPCServer) maskedUpdate(ctx context.Context, req *ocsp.UpdateStatusRequest, mask *fieldmaskpb.FieldMask) (storage.Update, error) {
+	if req.SerialNumber == "" {
+		return storage.Update{}, status.Error(codes.InvalidArgument, "serial number is required")
+	}
+	rec, err := s.store.Get(ctx, req.SerialNumber)
+	if errors.Is(err, storage.ErrNotFound) {
+		return storage.Update{}, status.Errorf(codes.NotFound, "no status stored for %s; send the full status without %s", req.SerialNumber, UpdateMaskMetadataKey)
+	}
+	if err != nil {
+		return storage.Update{}, storeStatus(err, "failed to read current status")
+	}
+
+	update := storage.Update{
+		Serial:           req.SerialNumber,
+		Status:           rec.Status,
+		RevokedAt:        rec.RevokedAt,
+		RevocationReason: rec.RevocationReason,
+	}
+	revocationSet := false
+	for _, path := range mask.Paths {
+		switch path {
+		case "status":
+			update.Status = req.Status
+			if update.Status == "" {
+				update.Status = storage.StatusGood
+			}
+			if !storage.ValidStatus(update.Status) {
+				return storage.Update{}, status.Error(codes.InvalidArgument, "invalid status (must be: good, revoked, or unknown)")
+			}
+		case "revoked_at":
+			update.RevokedAt = nil
+			if req.RevokedAt != nil {
+				t := req.RevokedAt.AsTime()
+				update.RevokedAt = &t
+				revocationSet = true
+			}
+		case "revocation_reason":
+			update.RevocationReason = req.RevocationReason
+			revocationSet = revocationSet || req.RevocationReason != ""
+		case "validity":
+			if update.Validity, err = responseValidity(ctx); err != nil {
+				return storage.Update{}, err
+			}
+			if update.Validity == nil {
+				return storage.Update{}, status.Errorf(codes.InvalidArgument, "the update mask names validity but %s is not set", ValidityMetadataKey)
+			}
+		}
+	}
+	if update.Status != storage.StatusRevoked {
+		if revocationSet {
+			return storage.Update{}, status.Errorf(codes.InvalidArgument, "revoked_at and revocation_reason apply only to revoked statuses, and %s is %s", req.SerialNumber, update.Status)
+		}
+		update.RevokedAt, update.RevocationReason = nil, ""
+	}
+	return update, nil
+}
+
+// responseValidity returns the validity override the request metadata sets, or nil when it
+// sets none
+func responseValidity(ctx context.Context) (*time.Duration, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get(ValidityMetadataKey)
+	if len(values) == 0 {
+		return nil, nil
+	}
+	validity, err := time.ParseDuration(values[0])
+	if err != nil || (validity != 0 && validity < time.Minute) {
+		return nil, status.Errorf(codes.InvalidArgument, "%s must be a duration of at least 1m, or 0 to remove the override", ValidityMetadataKey)
+	}
+	return &validity, nil
+}
+
+// planBatch answers a dry-run batch: the counts are what would succeed and fail, Errors lists
+// invalid updates and revocation rule violations, and a summary is sent as response metadata
+func (s *OCSPGRPCServer) planBatch(ctx context.Context, req *ocsp.BatchUpdateStatusRequest) (*ocsp.BatchUpdateStatusResponse, error) {
+	var failures []string
+	updates := make([]storage.Update, 0, len(req.Updates))
+	for _, r := range req.Updates {
+		update, err := requestUpdate(ctx, r)
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		updates = append(updates, update)
+	}
+
+	report, err := dryrun.Plan(ctx, s.store, updates)
+	if err != nil {
+		s.logger.Error("Failed to plan batch update", zap.Error(err))
+		return nil, storeStatus(err, "failed to read current statuses")
+	}
+	for _, change := range report.Changes {
+		if change.Violation != "" {
+			failures = append(failures, fmt.Sprintf("%s: %s", change.Serial, change.Violation))
+		}
+	}
+
+	grpc.SetHeader(ctx, metadata.Pairs(DryRunMetadataKey+"-summary", fmt.Sprintf(
+		"creates=%d updates=%d unchanged=%d violations=%d",
+		report.Creates, report.Updates, report.Unchanged, report.Violations)))
+
+	return &ocsp.BatchUpdateStatusResponse{
+		SuccessCount: int32(len(updates) - report.Violations),
+		FailureCount: int32(len(req.Updates) - len(updates) + report.Violations),
+		Errors:       failures,
+	}, nil
+}
+
+// describeChange renders a planned change as an UpdateStatus response message
+func describeChange(change dryrun.Change) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "dry run: would %s %s", change.Action, change.Serial)
+	switch change.Action {
+	case dryrun.ActionCreate:
+		fmt.Fprintf(&b, " as %s", statusText(change.ToStatus, change.ToReason))
+	case dryrun.ActionUpdate:
+		fmt.Fprintf(&b, " from %s to %s", statusText(change.FromStatus, change.FromReason), statusText(change.ToStatus, change.ToReason))
+	case dryrun.ActionUnchanged:
+		b.Reset()
+		fmt.Fprintf(&b, "dry run: %s is already %s", change.Serial, statusText(change.ToStatus, change.ToReason))
+	}
+	if change.Violation != "" {
+		fmt.Fprintf(&b, "; violation: %s", change.Violation)
+	}
+	return b.String()
+}
+
+func statusText(status, reason string) string {
+	if reason == "" {
+		return status
+	}
+	return status + " (" + reason + ")"
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/pkg/testsupport"
+	"github.com/gigvault/shared/api/proto/ocsp"
+	"github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+)
+
+func init() {
+	logger.SetGlobal(&logger.Logger{Logger: zap.NewNop()})
+}
+
+func TestDryRunBatchCountsViolations(t *testing.T) {
+	store := testsupport.NewStore(nil)
+	revokedAt := time.Now().Add(-time.Hour)
+	store.Put(storage.Record{Serial: "0a", Status: storage.StatusRevoked, RevokedAt: &revokedAt, RevocationReason: revocation.ReasonKeyCompromise})
+	store.Put(storage.Record{Serial: "0b", Status: storage.StatusGood})
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(DryRunMetadataKey, "true"))
+	resp, err := NewOCSPGRPCServer(store).BatchUpdateStatus(ctx, &ocsp.BatchUpdateStatusRequest{Updates: []*ocsp.UpdateStatusRequest{
+		// Releasing a key compromise is refused, so the hold after it is planned against the
+		// stored revocation and refused too
+		{SerialNumber: "0a", Status: storage.StatusGood},
+		{SerialNumber: "0a", Status: storage.StatusRevoked, RevocationReason: revocation.ReasonCertificateHold},
+		{SerialNumber: "0b", Status: storage.StatusRevoked, RevocationReason: revocation.ReasonSuperseded},
+		{SerialNumber: "0c", Status: storage.StatusGood},
+		{SerialNumber: "0d", Status: "expired"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.SuccessCount != 2 || resp.FailureCount != 3 || len(resp.Errors) != 3 {
+		t.Fatalf("BatchUpdateStatus() = %d succeeded, %d failed, errors %q", resp.SuccessCount, resp.FailureCount, resp.Errors)
+	}
+	if !strings.Contains(resp.Errors[1], "0a: ") || !strings.Contains(resp.Errors[2], "cannot be placed on hold") {
+		t.Errorf("errors = %q", resp.Errors)
+	}
+	if rec, err := store.Get(context.Background(), "0b"); err != nil || rec.Status != storage.StatusGood {
+		t.Errorf("dry run changed 0b to %+v, %v", rec, err)
+	}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/hold"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// HoldsHandler places timed certificate holds and changes them before they end
+type HoldsHandler struct {
+	holds *hold.Manager
+}
+
+// NewHoldsHandler creates a timed hold handler
+func NewHoldsHandler(holds *hold.Manager) *HoldsHandler {
+	return &HoldsHandler{holds: holds}
+}
+
+// RegisterRoutes mounts the timed hold endpoints
+func (h *HoldsHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/holds", h.List).Methods("GET")
+	api.HandleFunc("/holds", h.Create).Methods("POST")
+	api.HandleFunc("/holds/{serial}", h.Get).Methods("GET")
+	api.HandleFunc("/holds/{serial}", h.Extend).Methods("PUT")
+	api.HandleFunc("/holds/{serial}/release", h.Release).Methods("POST")
+	api.HandleFunc("/holds/{serial}/revoke", h.Revoke).Methods("POST")
+}
+
+// holdBody gives the end of a hold as release_at, in RFC 3339, or as a duration from now such
+// as "72h"
+type holdBody struct {
+	Serial           string    `json:"serial"`
+	ReleaseAt        time.Time `json:"release_at"`
+	Duration         string    `json:"duration"`
+	RevocationReason string    `json:"revocation_reason"`
+}
+
+// releaseAt returns the end of the hold the body asks for
+func (b holdBody) releaseAt() (time.Time, bool) {
+	if b.Duration == "" {
+		return b.ReleaseAt, !b.ReleaseAt.IsZero()
+	}
+	d, err := time.ParseDuration(b.Duration)
+	if err != nil || !b.ReleaseAt.IsZero() {
+		return time.Time{}, false
+	}
+	return time.Now().Add(d), true
+}
+
+// Create places a hold from a {"serial", "release_at" or "duration"} body
+func (h *HoldsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	body, ok := decodeHoldBody(w, r)
+	if !ok {
+		return
+	}
+	serial, err := bulk.NormalizeSerial(body.Serial)
+	if err != nil {
+		httputil.BadRequest(w, err.Error())
+		return
+	}
+	releaseAt, ok := body.releaseAt()
+	if !ok {
+		httputil.BadRequest(w, "give either release_at in RFC 3339 or a duration such as 72h")
+		return
+	}
+	held, err := h.holds.Hold(r.Context(), serial, releaseAt)
+	writeHold(w, held, err)
+}
+
+// List returns the most recently placed holds, filtered by the optional state query parameter
+func (h *HoldsHandler) List(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", hold.StateHeld, hold.StateReleased, hold.StateRevoked, hold.StateLapsed:
+	default:
+		httputil.BadRequest(w, "state must be held, released, revoked or lapsed")
+		return
+	}
+	holds, err := h.holds.List(r.Context(), state)
+	if err != nil {
+		httputil.InternalError(w, err)
+		return
+	}
+	httputil.Success(w, map[string]interface{}{"holds": holds})
+}
+
+// Get returns the hold of one serial
+func (h *HoldsHandler) Get(w http.ResponseWriter, r *http.Request) {
+	h.act(w, r, h.holds.Get)
+}
+
+// Extend moves the end of a hold, from a {"release_at" or "duration"} body
+func (h *HoldsHandler) Extend(w http.ResponseWriter, r *http.Request) {
+	body, ok := decodeHoldBody(w, r)
+	if !ok {
+		return
+	}
+	releaseAt, ok := body.releaseAt()
+	if !ok {
+		httputil.BadRequest(w, "give either release_at in RFC 3339 or a duration such as 72h")
+		return
+	}
+	h.act(w, r, func(ctx context.Context, serial string) (*hold.Hold, error) {
+		return h.holds.Extend(ctx, serial, releaseAt)
+	})
+}
+
+// Release ends a hold now, returning the serial to good
+func (h *HoldsHandler) Release(w http.ResponseWriter, r *http.Request) {
+	h.act(w, r, h.holds.Release)
+}
+
+// Revoke makes a hold permanent with the reason in a {"revocation_reason"} body
+func (h *HoldsHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	body, ok := decodeHoldBody(w, r)
+	if !ok {
+		return
+	}
+	reason, ok := revocation.ParseReason(body.RevocationReason)
+	if !ok {
+		httputil.BadRequest(w, "unknown revocation_reason")
+		return
+	}
+	h.act(w, r, func(ctx context.Context, serial string) (*hold.Hold, error) {
+		return h.holds.Revoke(ctx, serial, reason)
+	})
+}
+
+func (h *HoldsHandler) act(w http.ResponseWriter, r *http.Request, action func(ctx context.Context, serial string) (*hold.Hold, error)) {
+	serial, err := bulk.NormalizeSerial(mux.Vars(r)["serial"])
+	if err != nil {
+		httputil.BadRequest(w, err.Error())
+		return
+	}
+	held, err := action(r.Context(), serial)
+	writeHold(w, held, err)
+}
+
+func decodeHoldBody(w http.ResponseWriter, r *http.Request) (holdBody, bool) {
+	var body holdBody
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil {
+		httputil.BadRequest(w, "body must be a JSON object")
+		return holdBody{}, false
+	}
+	return body, true
+}
+
+func writeHold(w http.ResponseWriter, held *hold.Hold, err error) {
+	switch {
+	case err == nil:
+		httputil.Success(w, held)
+	case errors.Is(err, hold.ErrInvalid):
+		httputil.BadRequest(w, err.Error())
+	case errors.Is(err, hold.ErrNotFound):
+		httputil.NotFound(w, err.Error())
+	case errors.Is(err, hold.ErrNotHeld), errors.Is(err, hold.ErrAlreadyHeld), errors.Is(err, hold.ErrNeedsApproval):
+		httputil.Conflict(w, err.Error())
+	default:
+		writeStoreError(w, err)
+	}
+}
@@ -10,22 +10,75 @@ import (
 )
 
 type HTTPHandler struct {
-	logger *logger.Logger
+	logger      *logger.Logger
+	handlers    map[string]http.Handler
+	registrars  []RouteRegistrar
+	middlewares []mux.MiddlewareFunc
+}
+
+// RouteRegistrar registers feature-specific routes under the API prefix
+type RouteRegistrar interface {
+	RegisterRoutes(api *mux.Router)
+}
+
+// SelfAuthenticating is a RouteRegistrar whose routes authenticate their callers themselves,
+// such as by a request signature; they are mounted without the API middlewares
+type SelfAuthenticating interface {
+	RouteRegistrar
+	SelfAuthenticating()
 }
 
 func NewHTTPHandler(logger *logger.Logger) *HTTPHandler {
-	return &HTTPHandler{logger: logger}
+	return &HTTPHandler{
+		logger:   logger,
+		handlers: make(map[string]http.Handler),
+	}
+}
+
+// Register adds a feature handler whose routes are mounted under /api/v1
+func (h *HTTPHandler) Register(registrar RouteRegistrar) {
+	h.registrars = append(h.registrars, registrar)
+}
+
+// Use adds middlewares wrapping every route under /api/v1, such as authentication
+func (h *HTTPHandler) Use(middlewares ...mux.MiddlewareFunc) {
+	h.middlewares = append(h.middlewares, middlewares...)
+}
+
+// Handle registers an additional handler served outside the API prefix, such as the metrics endpoint
+func (h *HTTPHandler) Handle(path string, handler http.Handler) {
+	h.handlers[path] = handler
 }
 
 func (h *HTTPHandler) Routes() http.Handler {
 	r := mux.NewRouter()
+	r.Use(h.loggingMiddleware)
+	// Middlewares only wrap matched routes, so requests matching none are logged here
+	r.NotFoundHandler = h.logUnmatched(http.NotFoundHandler())
+	r.MethodNotAllowedHandler = h.logUnmatched(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
 	r.HandleFunc("/health", h.Health).Methods("GET")
 	r.HandleFunc("/ready", h.Ready).Methods("GET")
-	
+
+	// Routes that authenticate their callers themselves match first, outside the middlewares
+	open := r.PathPrefix("/api/v1").Subrouter()
 	api := r.PathPrefix("/api/v1").Subrouter()
+	api.Use(h.middlewares...)
 	api.HandleFunc("/status", h.Status).Methods("GET")
-	
-	return h.loggingMiddleware(r)
+	for _, registrar := range h.registrars {
+		if _, ok := registrar.(SelfAuthenticating); ok {
+			registrar.RegisterRoutes(open)
+			continue
+		}
+		registrar.RegisterRoutes(api)
+	}
+
+	for path, handler := range h.handlers {
+		r.Handle(path, handler)
+	}
+
+	return r
 }
 
 func (h *HTTPHandler) Health(w http.ResponseWriter, r *http.Request) {
@@ -46,12 +99,30 @@ func (h *HTTPHandler) Status(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// loggingMiddleware logs each request with the template of the route it matched rather than
+// its path, which may carry serials or base64 OCSP requests
 func (h *HTTPHandler) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		h.logger.Info("HTTP request",
-			zap.String("method", r.Method),
-			zap.String("path", r.URL.Path),
-		)
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		h.logRequest(r, route)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *HTTPHandler) logUnmatched(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.logRequest(r, "unmatched")
 		next.ServeHTTP(w, r)
 	})
 }
+
+func (h *HTTPHandler) logRequest(r *http.Request, route string) {
+	if entry := h.logger.Check(zap.InfoLevel, "HTTP request"); entry != nil {
+		entry.Write(zap.String("method", r.Method), zap.String("route", route))
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type serialRoutes struct{}
+
+func (serialRoutes) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/serials/{serial}", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+}
+
+func TestRequestsAreLoggedByRoute(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	h := NewHTTPHandler(&logger.Logger{Logger: zap.New(core)})
+	h.Register(serialRoutes{})
+	routes := h.Routes()
+
+	for _, tc := range []struct {
+		method, path, route string
+		status              int
+	}{
+		{http.MethodGet, "/api/v1/serials/0a1b2c", "/api/v1/serials/{serial}", http.StatusOK},
+		{http.MethodGet, "/health", "/health", http.StatusOK},
+		{http.MethodGet, "/api/v1/unknown/0a1b2c", "unmatched", http.StatusNotFound},
+		{http.MethodDelete, "/api/v1/serials/0a1b2c", "unmatched", http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, httptest.NewRequest(tc.method, tc.path, nil))
+		if w.Code != tc.status {
+			t.Errorf("%s %s answered %d, want %d", tc.method, tc.path, w.Code, tc.status)
+		}
+		entries := logs.TakeAll()
+		if len(entries) != 1 {
+			t.Errorf("%s %s logged %d entries, want 1", tc.method, tc.path, len(entries))
+			continue
+		}
+		if fields := entries[0].ContextMap(); fields["route"] != tc.route || fields["method"] != tc.method {
+			t.Errorf("%s %s logged %v, want route %s", tc.method, tc.path, fields, tc.route)
+		}
+	}
+}
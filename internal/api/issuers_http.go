@@ -0,0 +1,88 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gigvault/ocsp/internal/issuers"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// maxLeafUpload bounds the size of the leaf certificates posted to /issuers/discover
+const maxLeafUpload = 1 << 20
+
+// IssuersHandler lists registered issuers and registers the issuers of uploaded leaf certificates
+type IssuersHandler struct {
+	registry *issuers.Registry
+}
+
+// NewIssuersHandler creates an issuer registry handler
+func NewIssuersHandler(registry *issuers.Registry) *IssuersHandler {
+	return &IssuersHandler{registry: registry}
+}
+
+// RegisterRoutes mounts the issuer registry endpoints
+func (h *IssuersHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/issuers", h.List).Methods("GET")
+	api.HandleFunc("/issuers/discover", h.Discover).Methods("POST")
+	api.HandleFunc("/issuers/{key_hash}", h.Get).Methods("GET")
+}
+
+// discovered is the outcome of registering the issuer of one uploaded certificate
+type discovered struct {
+	Serial string          `json:"serial"`
+	Issuer *issuers.Issuer `json:"issuer,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// List returns every registered issuer
+func (h *IssuersHandler) List(w http.ResponseWriter, r *http.Request) {
+	list, err := h.registry.List(r.Context())
+	if err != nil {
+		httputil.InternalError(w, err)
+		return
+	}
+	httputil.Success(w, map[string]interface{}{"issuers": list})
+}
+
+// Get returns the issuer with a hex SHA-1 key hash
+func (h *IssuersHandler) Get(w http.ResponseWriter, r *http.Request) {
+	issuer, err := h.registry.Get(r.Context(), strings.ToLower(mux.Vars(r)["key_hash"]))
+	switch {
+	case err == nil:
+		httputil.Success(w, issuer)
+	case errors.Is(err, issuers.ErrNotFound):
+		httputil.NotFound(w, err.Error())
+	default:
+		httputil.InternalError(w, err)
+	}
+}
+
+// Discover registers the issuers of the PEM, DER or PKCS #7 leaf certificates in the body,
+// reporting each certificate's issuer or why it could not be registered
+func (h *IssuersHandler) Discover(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxLeafUpload))
+	if err != nil {
+		httputil.BadRequest(w, fmt.Sprintf("body must be at most %d bytes of certificates", maxLeafUpload))
+		return
+	}
+	leaves, err := issuers.ParseCertificates(body)
+	if err != nil {
+		httputil.BadRequest(w, "invalid certificates: "+err.Error())
+		return
+	}
+
+	results := make([]discovered, 0, len(leaves))
+	for _, leaf := range leaves {
+		result := discovered{Serial: fmt.Sprintf("%x", leaf.SerialNumber)}
+		if result.Issuer, err = h.registry.Register(r.Context(), leaf); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	httputil.Success(w, map[string]interface{}{"certificates": results})
+}
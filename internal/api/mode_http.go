@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/ocsp/internal/failover"
+	"github.com/gigvault/ocsp/internal/guardrail"
+	"github.com/gigvault/ocsp/internal/mode"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// ModeHandler reports and switches the operating mode
+type ModeHandler struct {
+	sw *mode.Switch
+}
+
+// NewModeHandler creates a new operating mode handler
+func NewModeHandler(sw *mode.Switch) *ModeHandler {
+	return &ModeHandler{sw: sw}
+}
+
+// RegisterRoutes mounts the mode endpoints
+func (h *ModeHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/mode", h.Get).Methods("GET")
+	api.HandleFunc("/mode", h.Set).Methods("PUT")
+}
+
+// Get returns the current operating mode
+func (h *ModeHandler) Get(w http.ResponseWriter, r *http.Request) {
+	httputil.Success(w, h.sw.State())
+}
+
+// Set switches the operating mode from a {"mode": ..., "reason": ...} body
+func (h *ModeHandler) Set(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Mode   string `json:"mode"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		httputil.BadRequest(w, "invalid JSON body")
+		return
+	}
+	if err := h.sw.Set(req.Mode, req.Reason); err != nil {
+		httputil.BadRequest(w, err.Error())
+		return
+	}
+	httputil.Success(w, h.sw.State())
+}
+
+// writeStoreError answers a failed store call with the status matching its cause
+func writeStoreError(w http.ResponseWriter, err error) {
+	var maintenance *mode.MaintenanceError
+	var pending *approval.PendingError
+	var limit *guardrail.LimitError
+	var transition *revocation.TransitionError
+	switch {
+	case errors.As(err, &transition):
+		httputil.Error(w, http.StatusConflict, "invalid_transition", err.Error())
+	case errors.Is(err, mode.ErrReadOnly):
+		httputil.Error(w, http.StatusConflict, "read_only", err.Error())
+	case errors.As(err, &pending):
+		httputil.JSON(w, http.StatusAccepted, httputil.Response{
+			Success: true,
+			Data:    map[string]interface{}{"approval_request": pending.ID, "reason": pending.Reason},
+		})
+	case errors.Is(err, approval.ErrAnonymous):
+		httputil.Forbidden(w, err.Error())
+	case errors.As(err, &limit) && limit.Token != "":
+		httputil.Error(w, http.StatusPreconditionRequired, "confirmation_required", err.Error())
+	case errors.As(err, &limit):
+		httputil.Error(w, http.StatusConflict, "revocation_limit", err.Error())
+	case errors.Is(err, failover.ErrFailover):
+		httputil.Error(w, http.StatusServiceUnavailable, "failover", err.Error())
+	case errors.As(err, &maintenance):
+		w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(maintenance.RetryAfter.Seconds()))))
+		httputil.Error(w, http.StatusServiceUnavailable, "maintenance", err.Error())
+	default:
+		httputil.InternalError(w, err)
+	}
+}
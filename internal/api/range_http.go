@@ -0,0 +1,127 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/pagetoken"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// Page sizes of serial range listings
+const (
+	defaultRangeLimit = 1000
+	maxRangeLimit     = 10000
+)
+
+// RangeHandler lists statuses by serial prefix or numeric range, for reviewing certificates
+// issued in sequence
+type RangeHandler struct {
+	statuses storage.SerialRanger
+}
+
+// NewRangeHandler creates a serial range handler
+func NewRangeHandler(statuses storage.SerialRanger) *RangeHandler {
+	return &RangeHandler{statuses: statuses}
+}
+
+// RegisterRoutes mounts the serial range endpoint
+func (h *RangeHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/statuses", h.List).Methods("GET")
+}
+
+type rangePage struct {
+	Statuses []storage.Record `json:"statuses"`
+	// NextPageToken is the page_token of the next page, or empty when this page is the last
+	NextPageToken string `json:"next_page_token,omitempty"`
+	// Next is the after parameter of the next page, for callers paging by serial
+	Next string `json:"next,omitempty"`
+}
+
+// rangeTokenScope names serial range listings in page tokens
+const rangeTokenScope = "statuses"
+
+// List returns statuses in numeric serial order. prefix keeps serials whose hex starts with
+// it; from and to bound serials inclusively; status keeps one status; page_token, or after,
+// and limit page. At least one of prefix, from and to is required, so a listing cannot walk the
+// whole table by accident
+func (h *RangeHandler) List(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	rng := storage.SerialRange{Status: query.Get("status"), Limit: defaultRangeLimit}
+
+	if value := query.Get("prefix"); value != "" {
+		prefix := strings.ToLower(strings.ReplaceAll(value, ":", ""))
+		prefix = strings.TrimPrefix(prefix, "0x")
+		// Stored serials have no leading zeros, so neither can a prefix of one
+		if prefix == "" || prefix[0] == '0' || strings.Trim(prefix, "0123456789abcdef") != "" {
+			httputil.BadRequest(w, "prefix must be hex digits not starting with 0")
+			return
+		}
+		rng.Prefix = prefix
+	}
+	for _, bound := range []struct {
+		name string
+		dst  *string
+	}{{"from", &rng.From}, {"to", &rng.To}, {"after", &rng.After}} {
+		if value := query.Get(bound.name); value != "" {
+			serial, err := bulk.NormalizeSerial(value)
+			if err != nil {
+				httputil.BadRequest(w, bound.name+": "+err.Error())
+				return
+			}
+			*bound.dst = serial
+		}
+	}
+	if rng.Prefix == "" && rng.From == "" && rng.To == "" {
+		httputil.BadRequest(w, "give a prefix, from or to")
+		return
+	}
+	if rng.From != "" && rng.To != "" && storage.SerialLess(rng.To, rng.From) {
+		httputil.BadRequest(w, "from must not be above to")
+		return
+	}
+	if rng.Status != "" && !storage.ValidStatus(rng.Status) {
+		httputil.BadRequest(w, "status must be good, revoked or unknown")
+		return
+	}
+	filters := []string{rng.Prefix, rng.From, rng.To, rng.Status}
+	if value := query.Get("page_token"); value != "" {
+		if rng.After != "" {
+			httputil.BadRequest(w, "give either page_token or after")
+			return
+		}
+		after, err := pagetoken.Decode(value, rangeTokenScope, filters)
+		if err != nil {
+			httputil.BadRequest(w, err.Error())
+			return
+		}
+		rng.After = after
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxRangeLimit {
+			httputil.BadRequest(w, "limit must be between 1 and "+strconv.Itoa(maxRangeLimit))
+			return
+		}
+		rng.Limit = limit
+	}
+
+	records, err := h.statuses.ListRange(r.Context(), rng)
+	if err != nil {
+		httputil.InternalError(w, err)
+		return
+	}
+	page := rangePage{Statuses: records}
+	if page.Statuses == nil {
+		page.Statuses = []storage.Record{}
+	}
+	if len(records) == rng.Limit {
+		page.Next = records[len(records)-1].Serial
+		page.NextPageToken = pagetoken.Encode(rangeTokenScope, filters, page.Next)
+	}
+	httputil.Success(w, page)
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gigvault/ocsp/internal/replication"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// ReplicationHandler reports conflicts between regions
+type ReplicationHandler struct {
+	consumer *replication.Consumer
+}
+
+// NewReplicationHandler creates a new replication handler
+func NewReplicationHandler(consumer *replication.Consumer) *ReplicationHandler {
+	return &ReplicationHandler{consumer: consumer}
+}
+
+// RegisterRoutes mounts the replication endpoints
+func (h *ReplicationHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/replication/conflicts", h.Conflicts).Methods("GET")
+}
+
+// Conflicts lists the most recent contradictory revocations seen from other regions
+func (h *ReplicationHandler) Conflicts(w http.ResponseWriter, r *http.Request) {
+	httputil.Success(w, h.consumer.Conflicts())
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/pagetoken"
+	"github.com/gigvault/ocsp/internal/responselog"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// defaultResponseLogLimit is the page size when the limit parameter is not given
+const defaultResponseLogLimit = 100
+
+// ResponseLogHandler serves the log of signed responses
+type ResponseLogHandler struct {
+	log *responselog.Log
+}
+
+// NewResponseLogHandler creates a response log handler
+func NewResponseLogHandler(log *responselog.Log) *ResponseLogHandler {
+	return &ResponseLogHandler{log: log}
+}
+
+// RegisterRoutes mounts the response log endpoint
+func (h *ResponseLogHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/response-log/{serial}", h.List).Methods("GET")
+}
+
+type responseLogPage struct {
+	Entries []responselog.Entry `json:"entries"`
+	// NextPageToken is the page_token of the next page, or empty when this page is the last
+	NextPageToken string `json:"next_page_token,omitempty"`
+	// NextAfterID is the after_id of the next page, for callers paging by ID
+	NextAfterID int64 `json:"next_after_id,omitempty"`
+}
+
+// responseLogTokenScope names response log listings in page tokens
+const responseLogTokenScope = "response-log"
+
+// List returns the responses signed for a serial, oldest first. The at parameter (RFC 3339)
+// keeps those valid at that time; page_token, or after_id, and limit page through the rest
+func (h *ResponseLogHandler) List(w http.ResponseWriter, r *http.Request) {
+	serial, err := bulk.NormalizeSerial(mux.Vars(r)["serial"])
+	if err != nil {
+		httputil.BadRequest(w, err.Error())
+		return
+	}
+	query := r.URL.Query()
+	filter := responselog.Filter{Serial: serial, Limit: defaultResponseLogLimit}
+	if value := query.Get("at"); value != "" {
+		if filter.At, err = time.Parse(time.RFC3339, value); err != nil {
+			httputil.BadRequest(w, "at must be an RFC 3339 time")
+			return
+		}
+	}
+	if value := query.Get("after_id"); value != "" {
+		if filter.AfterID, err = strconv.ParseInt(value, 10, 64); err != nil || filter.AfterID < 0 {
+			httputil.BadRequest(w, "after_id must be a non-negative integer")
+			return
+		}
+	}
+	filters := []string{serial, ""}
+	if !filter.At.IsZero() {
+		filters[1] = filter.At.UTC().Format(time.RFC3339Nano)
+	}
+	if value := query.Get("page_token"); value != "" {
+		if filter.AfterID != 0 {
+			httputil.BadRequest(w, "give either page_token or after_id")
+			return
+		}
+		after, err := pagetoken.Decode(value, responseLogTokenScope, filters)
+		if err == nil {
+			filter.AfterID, err = strconv.ParseInt(after, 10, 64)
+		}
+		if err != nil {
+			httputil.BadRequest(w, pagetoken.ErrInvalid.Error())
+			return
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		if filter.Limit, err = strconv.Atoi(value); err != nil || filter.Limit < 1 || filter.Limit > responselog.MaxLimit {
+			httputil.BadRequest(w, "limit must be between 1 and "+strconv.Itoa(responselog.MaxLimit))
+			return
+		}
+	}
+
+	entries, err := h.log.Query(r.Context(), filter)
+	if err != nil {
+		httputil.InternalError(w, err)
+		return
+	}
+	page := responseLogPage{Entries: entries}
+	if page.Entries == nil {
+		page.Entries = []responselog.Entry{}
+	}
+	if len(entries) == filter.Limit {
+		page.NextAfterID = entries[len(entries)-1].ID
+		page.NextPageToken = pagetoken.Encode(responseLogTokenScope, filters, strconv.FormatInt(page.NextAfterID, 10))
+	}
+	httputil.Success(w, page)
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gigvault/ocsp/internal/retention"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// RetentionHandler reports and triggers retention purges
+type RetentionHandler struct {
+	enforcer *retention.Enforcer
+}
+
+// NewRetentionHandler creates a retention handler
+func NewRetentionHandler(enforcer *retention.Enforcer) *RetentionHandler {
+	return &RetentionHandler{enforcer: enforcer}
+}
+
+// RegisterRoutes mounts the retention endpoints
+func (h *RetentionHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/retention", h.Last).Methods("GET")
+	api.HandleFunc("/retention/run", h.Run).Methods("POST")
+}
+
+// Last returns what the latest purge on this replica deleted
+func (h *RetentionHandler) Last(w http.ResponseWriter, r *http.Request) {
+	report := h.enforcer.Last()
+	if report == nil {
+		httputil.NotFound(w, "no retention purge has run on this replica")
+		return
+	}
+	httputil.Success(w, report)
+}
+
+// Run purges every class now and returns the report
+func (h *RetentionHandler) Run(w http.ResponseWriter, r *http.Request) {
+	httputil.Success(w, h.enforcer.Enforce(r.Context()))
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/schedule"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// ScheduleHandler manages revocations that take effect at a future time
+type ScheduleHandler struct {
+	scheduler *schedule.Scheduler
+}
+
+// NewScheduleHandler creates a scheduled revocation handler
+func NewScheduleHandler(scheduler *schedule.Scheduler) *ScheduleHandler {
+	return &ScheduleHandler{scheduler: scheduler}
+}
+
+// RegisterRoutes mounts the scheduled revocation endpoints
+func (h *ScheduleHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/revocations/scheduled", h.List).Methods("GET")
+	api.HandleFunc("/revocations/scheduled", h.Create).Methods("POST")
+	api.HandleFunc("/revocations/scheduled/{id:[0-9]+}", h.Get).Methods("GET")
+	api.HandleFunc("/revocations/scheduled/{id:[0-9]+}/cancel", h.Cancel).Methods("POST")
+}
+
+type scheduleBody struct {
+	Serial           string    `json:"serial"`
+	RevocationReason string    `json:"revocation_reason"`
+	EffectiveAt      time.Time `json:"effective_at"`
+}
+
+// Create schedules the revocation in a {"serial", "revocation_reason", "effective_at"} body,
+// with effective_at in RFC 3339
+func (h *ScheduleHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var body scheduleBody
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil {
+		httputil.BadRequest(w, "body must be a JSON object with serial, revocation_reason and effective_at")
+		return
+	}
+	serial, err := bulk.NormalizeSerial(body.Serial)
+	if err != nil {
+		httputil.BadRequest(w, err.Error())
+		return
+	}
+	reason, ok := revocation.ParseReason(body.RevocationReason)
+	if !ok {
+		httputil.BadRequest(w, "unknown revocation_reason")
+		return
+	}
+	if body.EffectiveAt.IsZero() {
+		httputil.BadRequest(w, "effective_at is required")
+		return
+	}
+
+	rev, err := h.scheduler.Schedule(r.Context(), serial, reason, body.EffectiveAt)
+	switch {
+	case err == nil:
+		httputil.Success(w, rev)
+	case errors.Is(err, schedule.ErrInvalid):
+		httputil.BadRequest(w, err.Error())
+	case errors.Is(err, schedule.ErrAlreadyScheduled), errors.Is(err, schedule.ErrNeedsApproval):
+		httputil.Conflict(w, err.Error())
+	default:
+		httputil.InternalError(w, err)
+	}
+}
+
+// List returns the newest scheduled revocations, filtered by the optional state query parameter
+func (h *ScheduleHandler) List(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "", schedule.StatePending, schedule.StateApplying, schedule.StateApplied, schedule.StateCancelled, schedule.StateFailed:
+	default:
+		httputil.BadRequest(w, "state must be pending, applying, applied, cancelled or failed")
+		return
+	}
+	revocations, err := h.scheduler.List(r.Context(), state)
+	if err != nil {
+		httputil.InternalError(w, err)
+		return
+	}
+	httputil.Success(w, map[string]interface{}{"revocations": revocations})
+}
+
+// Get returns one scheduled revocation
+func (h *ScheduleHandler) Get(w http.ResponseWriter, r *http.Request) {
+	h.act(w, r, h.scheduler.Get)
+}
+
+// Cancel withdraws a scheduled revocation that has not taken effect yet
+func (h *ScheduleHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	h.act(w, r, h.scheduler.Cancel)
+}
+
+func (h *ScheduleHandler) act(w http.ResponseWriter, r *http.Request, action func(ctx context.Context, id int64) (*schedule.Revocation, error)) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		httputil.BadRequest(w, "invalid scheduled revocation id")
+		return
+	}
+	rev, err := action(r.Context(), id)
+	switch {
+	case err == nil:
+		httputil.Success(w, rev)
+	case errors.Is(err, schedule.ErrNotFound):
+		httputil.NotFound(w, err.Error())
+	case errors.Is(err, schedule.ErrNotPending):
+		httputil.Conflict(w, err.Error())
+	default:
+		httputil.InternalError(w, err)
+	}
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// ShardsHandler reports the health of the status database shards
+type ShardsHandler struct {
+	sharded *storage.Sharded
+}
+
+// NewShardsHandler creates a shards handler
+func NewShardsHandler(sharded *storage.Sharded) *ShardsHandler {
+	return &ShardsHandler{sharded: sharded}
+}
+
+// RegisterRoutes mounts the shards endpoint
+func (h *ShardsHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/shards", h.List).Methods("GET")
+}
+
+// List returns every shard with the outcome of its latest health check on this replica
+func (h *ShardsHandler) List(w http.ResponseWriter, r *http.Request) {
+	httputil.Success(w, h.sharded.Health())
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/toprequests"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// Sizes of top request listings
+const (
+	defaultTopRequestsLimit = 20
+	maxTopRequestsLimit     = 1000
+)
+
+// TopRequestsHandler serves the most requested serials and issuers
+type TopRequestsHandler struct {
+	tracker *toprequests.Tracker
+}
+
+// NewTopRequestsHandler creates a top requests handler
+func NewTopRequestsHandler(tracker *toprequests.Tracker) *TopRequestsHandler {
+	return &TopRequestsHandler{tracker: tracker}
+}
+
+// RegisterRoutes mounts the top requests endpoint
+func (h *TopRequestsHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/top-requests", h.List).Methods("GET")
+}
+
+// List returns the limit most requested serials and issuers of every configured window, or of
+// the one named by the window parameter
+func (h *TopRequestsHandler) List(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	windows := h.tracker.Windows()
+	if value := query.Get("window"); value != "" {
+		window, err := time.ParseDuration(value)
+		if err != nil || !containsWindow(windows, window) {
+			httputil.BadRequest(w, "window must be one of the configured top_requests.windows")
+			return
+		}
+		windows = []time.Duration{window}
+	}
+	limit := defaultTopRequestsLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxTopRequestsLimit {
+			httputil.BadRequest(w, "limit must be between 1 and "+strconv.Itoa(maxTopRequestsLimit))
+			return
+		}
+	}
+
+	tops := make([]toprequests.Top, 0, len(windows))
+	for _, window := range windows {
+		tops = append(tops, h.tracker.Top(window, limit))
+	}
+	httputil.Success(w, tops)
+}
+
+func containsWindow(windows []time.Duration, window time.Duration) bool {
+	for _, w := range windows {
+		if w == window {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gigvault/ocsp/internal/tunables"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// defaultTunableHistoryLimit is the size of a history listing without a limit
+const defaultTunableHistoryLimit = 100
+
+// TunablesHandler reports and changes runtime tunables
+type TunablesHandler struct {
+	registry *tunables.Registry
+}
+
+// NewTunablesHandler creates a runtime tunables handler
+func NewTunablesHandler(registry *tunables.Registry) *TunablesHandler {
+	return &TunablesHandler{registry: registry}
+}
+
+// RegisterRoutes mounts the tunables endpoints
+func (h *TunablesHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/tunables", h.List).Methods("GET")
+	api.HandleFunc("/tunables/history", h.History).Methods("GET")
+	api.HandleFunc("/tunables/{name}", h.Get).Methods("GET")
+	api.HandleFunc("/tunables/{name}", h.Set).Methods("PUT")
+	api.HandleFunc("/tunables/{name}", h.Reset).Methods("DELETE")
+}
+
+// List returns every tunable with its current and configured value
+func (h *TunablesHandler) List(w http.ResponseWriter, r *http.Request) {
+	httputil.Success(w, h.registry.List())
+}
+
+// Get returns one tunable
+func (h *TunablesHandler) Get(w http.ResponseWriter, r *http.Request) {
+	state, err := h.registry.Get(mux.Vars(r)["name"])
+	if err != nil {
+		writeTunableError(w, err)
+		return
+	}
+	httputil.Success(w, state)
+}
+
+// Set changes a tunable from a {"value": ..., "reason": ..., "persist": bool} body. Without
+// persist the change lasts until this replica restarts
+func (h *TunablesHandler) Set(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Value   string `json:"value"`
+		Reason  string `json:"reason"`
+		Persist bool   `json:"persist"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		httputil.BadRequest(w, "invalid JSON body")
+		return
+	}
+	if req.Reason == "" {
+		httputil.BadRequest(w, "reason is required")
+		return
+	}
+	state, err := h.registry.Set(r.Context(), mux.Vars(r)["name"], req.Value, req.Reason, req.Persist)
+	if err != nil {
+		writeTunableError(w, err)
+		return
+	}
+	httputil.Success(w, state)
+}
+
+// Reset applies the configured value of a tunable again and removes any persisted value; the
+// reason query parameter is recorded with the change
+func (h *TunablesHandler) Reset(w http.ResponseWriter, r *http.Request) {
+	reason := r.URL.Query().Get("reason")
+	if reason == "" {
+		httputil.BadRequest(w, "reason is required")
+		return
+	}
+	state, err := h.registry.Reset(r.Context(), mux.Vars(r)["name"], reason)
+	if err != nil {
+		writeTunableError(w, err)
+		return
+	}
+	httputil.Success(w, state)
+}
+
+// History returns the most recent changes on any replica, newest first, of the tunable given by
+// the optional name parameter; limit bounds them
+func (h *TunablesHandler) History(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultTunableHistoryLimit
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > tunables.MaxHistory {
+			httputil.BadRequest(w, "limit must be between 1 and "+strconv.Itoa(tunables.MaxHistory))
+			return
+		}
+		limit = n
+	}
+	history, err := h.registry.History(r.Context(), query.Get("name"), limit)
+	if err != nil {
+		httputil.InternalError(w, err)
+		return
+	}
+	if history == nil {
+		history = []tunables.Change{}
+	}
+	httputil.Success(w, history)
+}
+
+// writeTunableError answers 404 for unknown tunables, 400 for refused values and 500 otherwise
+func writeTunableError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, tunables.ErrUnknown):
+		httputil.NotFound(w, err.Error())
+	case errors.Is(err, tunables.ErrInvalid):
+		httputil.BadRequest(w, err.Error())
+	default:
+		httputil.InternalError(w, err)
+	}
+}
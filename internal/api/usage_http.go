@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gigvault/ocsp/internal/metering"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// UsageHandler serves the usage this replica has metered
+type UsageHandler struct {
+	meter *metering.Meter
+}
+
+// NewUsageHandler creates a usage handler for the replica's meter
+func NewUsageHandler(meter *metering.Meter) *UsageHandler {
+	return &UsageHandler{meter: meter}
+}
+
+// RegisterRoutes mounts the usage endpoint
+func (h *UsageHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/usage", h.Get).Methods("GET")
+}
+
+// Get returns the recent closed periods and the current one so far, as JSON or, with
+// format=csv, in the export layout
+func (h *UsageHandler) Get(w http.ResponseWriter, r *http.Request) {
+	periods := h.meter.Periods()
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		httputil.Success(w, map[string]interface{}{"replica": h.meter.Replica(), "periods": periods})
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		if err := metering.WriteCSV(w, h.meter.Replica(), periods); err != nil {
+			httputil.InternalError(w, err)
+		}
+	default:
+		httputil.BadRequest(w, "format must be json or csv")
+	}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/internal/watch"
+	"github.com/gigvault/shared/pkg/httputil"
+	"github.com/gorilla/mux"
+)
+
+// WatchHandler serves per-serial statuses with long-poll semantics for stapling fleets
+type WatchHandler struct {
+	store   storage.Store
+	hub     *watch.Hub
+	maxWait time.Duration
+}
+
+// NewWatchHandler creates a status watch handler; requested waits are capped at maxWait
+func NewWatchHandler(store storage.Store, hub *watch.Hub, maxWait time.Duration) *WatchHandler {
+	return &WatchHandler{store: store, hub: hub, maxWait: maxWait}
+}
+
+// RegisterRoutes mounts the status watch endpoint
+func (h *WatchHandler) RegisterRoutes(api *mux.Router) {
+	api.HandleFunc("/statuses/{serial}", h.Get).Methods("GET")
+}
+
+// Get returns the current status with an ETag. When If-None-Match carries the current ETag
+// and ?wait= is set, the request blocks until the status changes or the wait elapses, in
+// which case it answers 304 Not Modified
+func (h *WatchHandler) Get(w http.ResponseWriter, r *http.Request) {
+	serial, err := bulk.NormalizeSerial(mux.Vars(r)["serial"])
+	if err != nil {
+		httputil.BadRequest(w, err.Error())
+		return
+	}
+
+	var wait time.Duration
+	if value := r.URL.Query().Get("wait"); value != "" {
+		wait, err = time.ParseDuration(value)
+		if err != nil || wait < 0 {
+			httputil.BadRequest(w, "wait must be a non-negative duration")
+			return
+		}
+		if wait > h.maxWait {
+			wait = h.maxWait
+		}
+	}
+
+	// Subscribe before reading so a change between the read and the wait is not missed
+	changed, release := h.hub.Wait(serial)
+	defer release()
+
+	rec, ok := h.lookup(w, r, serial)
+	if !ok {
+		return
+	}
+	tag := etag(rec)
+
+	if wait > 0 && r.Header.Get("If-None-Match") == tag {
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Now().Add(wait + 10*time.Second))
+
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		case <-timer.C:
+		}
+
+		if rec, ok = h.lookup(w, r, serial); !ok {
+			return
+		}
+		tag = etag(rec)
+	}
+
+	w.Header().Set("ETag", tag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == tag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	httputil.Success(w, rec)
+}
+
+func (h *WatchHandler) lookup(w http.ResponseWriter, r *http.Request, serial string) (*storage.Record, bool) {
+	rec, err := h.store.Get(r.Context(), serial)
+	if errors.Is(err, storage.ErrNotFound) {
+		httputil.NotFound(w, "no status for serial")
+		return nil, false
+	}
+	if err != nil {
+		writeStoreError(w, err)
+		return nil, false
+	}
+	return rec, true
+}
+
+// etag changes whenever the status is rewritten, since every write moves this_update
+func etag(rec *storage.Record) string {
+	return fmt.Sprintf(`"%x"`, rec.ThisUpdate.UnixNano())
+}
@@ -0,0 +1,387 @@
+// Package apikeys manages the per-tenant API keys of the gRPC mutation API
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// TokenPrefix starts every token, so API keys are told apart from principal tokens
+const TokenPrefix = "gvk_"
+
+// MaxLimit bounds the keys returned by one listing
+const MaxLimit = 1000
+
+// Lengths of the hex key ID and secret in a token
+const (
+	idLength     = 32
+	secretLength = 64
+)
+
+// maxNameLength bounds tenant and key names, as the table does
+const maxNameLength = 128
+
+// sha1Size is the length of an issuer key hash, which OCSP computes with SHA-1
+const sha1Size = 20
+
+var (
+	// ErrNotFound is returned for unknown key IDs
+	ErrNotFound = errors.New("API key not found")
+	// ErrRevoked is returned when rotating or revoking a revoked key
+	ErrRevoked = errors.New("API key is revoked")
+	// ErrNameTaken is returned when a tenant already has a live key of the name
+	ErrNameTaken = errors.New("tenant already has a live API key of that name")
+	// ErrInvalid is wrapped by the errors for invalid key specifications
+	ErrInvalid = errors.New("invalid API key")
+	// ErrUnknownToken is returned for tokens that match no live key
+	ErrUnknownToken = errors.New("unknown or revoked API key")
+)
+
+var requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "api_key_requests_total",
+	Help:      "RPCs checked against API keys, by outcome: accepted, missing, unknown, forbidden (issuer) or throttled.",
+}, []string{"outcome"})
+
+func init() {
+	metrics.Registry.MustRegister(requests)
+}
+
+// Key is an API key without its secret
+type Key struct {
+	ID     string `json:"id"`
+	Tenant string `json:"tenant"`
+	Name   string `json:"name"`
+	// Issuers holds the hex SHA-1 issuer key hashes the key may change statuses for; empty
+	// permits every issuer
+	Issuers []string `json:"issuers"`
+	// RatePerSecond and Burst size the key's token bucket; a rate of 0 leaves it unlimited
+	RatePerSecond float64    `json:"rate_per_second"`
+	Burst         int        `json:"burst"`
+	CreatedAt     time.Time  `json:"created_at"`
+	CreatedBy     string     `json:"created_by,omitempty"`
+	RotatedAt     *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy     string     `json:"revoked_by,omitempty"`
+}
+
+// Principal is the name the key's calls are attributed to, in approvals and the audit trail
+func (k *Key) Principal() string {
+	return k.Tenant + "/" + k.Name
+}
+
+// Permits reports whether the key may change statuses of the issuer with the hex key hash
+func (k *Key) Permits(issuer string) bool {
+	return len(k.Issuers) == 0 || slices.Contains(k.Issuers, strings.ToLower(issuer))
+}
+
+// PermitsAll reports whether the key may change statuses held for every one of issuers. A key
+// limited to some issuers permits none when issuers is empty, since nothing shows whose
+// statuses it would change
+func (k *Key) PermitsAll(issuers []string) bool {
+	if len(k.Issuers) == 0 {
+		return true
+	}
+	if len(issuers) == 0 {
+		return false
+	}
+	for _, issuer := range issuers {
+		if !k.Permits(issuer) {
+			return false
+		}
+	}
+	return true
+}
+
+// stored is a key with the digests of its secrets
+type stored struct {
+	Key
+	digest            string
+	previousDigest    string
+	previousExpiresAt *time.Time
+}
+
+// matches reports whether secret is the key's current secret, or the one its last rotation
+// replaced while that is still in its grace period
+func (s *stored) matches(secret string, now time.Time) bool {
+	digest := []byte(digestOf(secret))
+	if subtle.ConstantTimeCompare(digest, []byte(s.digest)) == 1 {
+		return true
+	}
+	return s.previousDigest != "" && s.previousExpiresAt != nil && now.Before(*s.previousExpiresAt) &&
+		subtle.ConstantTimeCompare(digest, []byte(s.previousDigest)) == 1
+}
+
+// Spec describes a key to create
+type Spec struct {
+	Tenant        string   `json:"tenant"`
+	Name          string   `json:"name"`
+	Issuers       []string `json:"issuers"`
+	RatePerSecond float64  `json:"rate_per_second"`
+	Burst         int      `json:"burst"`
+}
+
+// Options configures a Manager
+type Options struct {
+	// CacheTTL is how long a key read from the database is trusted; revocations on other
+	// replicas take up to this long to apply here
+	CacheTTL time.Duration
+	// RotationGrace is how long the secret a rotation replaces keeps working
+	RotationGrace time.Duration
+}
+
+// Manager creates, rotates and revokes keys, authenticates tokens and enforces rate limits
+type Manager struct {
+	db     *Postgres
+	opts   Options
+	logger *logger.Logger
+
+	mu      sync.Mutex
+	cache   map[string]*cached
+	buckets map[string]*bucket
+}
+
+type cached struct {
+	key      *stored
+	loadedAt time.Time
+}
+
+// NewManager creates a manager over the api_keys table
+func NewManager(db *Postgres, opts Options, logger *logger.Logger) *Manager {
+	return &Manager{
+		db:      db,
+		opts:    opts,
+		logger:  logger,
+		cache:   make(map[string]*cached),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Create adds a key on behalf of the principal in ctx, returning it with its token
+func (m *Manager) Create(ctx context.Context, spec Spec) (*Key, string, error) {
+	key, err := normalize(spec)
+	if err != nil {
+		return nil, "", err
+	}
+	if key.ID, err = randomHex(idLength / 2); err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(secretLength / 2)
+	if err != nil {
+		return nil, "", err
+	}
+	key.CreatedBy = approval.PrincipalFrom(ctx)
+	if err := m.db.Insert(ctx, key, digestOf(secret)); err != nil {
+		return nil, "", err
+	}
+	m.logger.Info("Created API key", zap.String("id", key.ID), zap.String("principal", key.Principal()), zap.String("created_by", key.CreatedBy))
+	return key, TokenPrefix + key.ID + "_" + secret, nil
+}
+
+// Get returns one key
+func (m *Manager) Get(ctx context.Context, id string) (*Key, error) {
+	key, err := m.db.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &key.Key, nil
+}
+
+// List returns up to limit keys of tenant, or of every tenant when it is empty, after afterID
+func (m *Manager) List(ctx context.Context, tenant, afterID string, limit int) ([]Key, error) {
+	return m.db.List(ctx, tenant, afterID, limit)
+}
+
+// Rotate gives a live key a new secret, returning the key with its new token. The replaced
+// secret keeps working for the rotation grace period, so clients can switch without failures
+func (m *Manager) Rotate(ctx context.Context, id string) (*Key, string, error) {
+	secret, err := randomHex(secretLength / 2)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := m.db.Rotate(ctx, id, digestOf(secret), time.Now().Add(m.opts.RotationGrace)); err != nil {
+		return nil, "", err
+	}
+	m.forget(id, false)
+	key, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	m.logger.Info("Rotated API key", zap.String("id", id), zap.String("principal", key.Principal()), zap.String("rotated_by", approval.PrincipalFrom(ctx)))
+	return key, TokenPrefix + id + "_" + secret, nil
+}
+
+// Revoke stops a key from authenticating on behalf of the principal in ctx. Other replicas
+// stop accepting it within the cache TTL
+func (m *Manager) Revoke(ctx context.Context, id string) (*Key, error) {
+	if err := m.db.Revoke(ctx, id, approval.PrincipalFrom(ctx)); err != nil {
+		return nil, err
+	}
+	m.forget(id, true)
+	key, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	m.logger.Info("Revoked API key", zap.String("id", id), zap.String("principal", key.Principal()), zap.String("revoked_by", key.RevokedBy))
+	return key, nil
+}
+
+// Authenticate returns the live key a token belongs to, or ErrUnknownToken
+func (m *Manager) Authenticate(ctx context.Context, token string) (*Key, error) {
+	rest, ok := strings.CutPrefix(token, TokenPrefix)
+	if !ok || len(rest) != idLength+1+secretLength || rest[idLength] != '_' {
+		return nil, ErrUnknownToken
+	}
+	id, secret := rest[:idLength], rest[idLength+1:]
+
+	key, err := m.lookup(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrUnknownToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil || !key.matches(secret, time.Now()) {
+		return nil, ErrUnknownToken
+	}
+	return &key.Key, nil
+}
+
+// Allow takes cost tokens from the key's bucket, reporting false when it holds too few. Buckets
+// are per replica, so a key spread over n replicas may reach n times its rate
+func (m *Manager) Allow(key *Key, cost int) bool {
+	if key.RatePerSecond <= 0 {
+		return true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.buckets[key.ID]
+	if !ok {
+		b = &bucket{}
+		m.buckets[key.ID] = b
+	}
+	return b.take(key.RatePerSecond, burstOf(key), float64(cost), time.Now())
+}
+
+// lookup returns a key from the cache, reading it again once it is older than the cache TTL
+func (m *Manager) lookup(ctx context.Context, id string) (*stored, error) {
+	m.mu.Lock()
+	entry, ok := m.cache[id]
+	m.mu.Unlock()
+	if ok && time.Since(entry.loadedAt) < m.opts.CacheTTL {
+		return entry.key, nil
+	}
+
+	key, err := m.db.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key.RevokedAt != nil {
+		// Revoked keys stay cached so their tokens are refused without a query, but lose
+		// their bucket
+		delete(m.buckets, id)
+	}
+	m.cache[id] = &cached{key: key, loadedAt: time.Now()}
+	return key, nil
+}
+
+// forget drops a changed key from the cache, and its bucket once it is revoked; a rotated key
+// keeps what it has used of its rate
+func (m *Manager) forget(id string, revoked bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.cache, id)
+	if revoked {
+		delete(m.buckets, id)
+	}
+}
+
+// normalize validates a specification into a key
+func normalize(spec Spec) (*Key, error) {
+	key := &Key{
+		Tenant:        strings.TrimSpace(spec.Tenant),
+		Name:          strings.TrimSpace(spec.Name),
+		Issuers:       []string{},
+		RatePerSecond: spec.RatePerSecond,
+		Burst:         spec.Burst,
+	}
+	switch {
+	case key.Tenant == "" || len(key.Tenant) > maxNameLength || strings.Contains(key.Tenant, "/"):
+		return nil, fmt.Errorf("%w: tenant must be 1 to %d characters without /", ErrInvalid, maxNameLength)
+	case key.Name == "" || len(key.Name) > maxNameLength:
+		return nil, fmt.Errorf("%w: name must be 1 to %d characters", ErrInvalid, maxNameLength)
+	case key.RatePerSecond < 0 || math.IsNaN(key.RatePerSecond) || math.IsInf(key.RatePerSecond, 0):
+		return nil, fmt.Errorf("%w: rate_per_second must not be negative", ErrInvalid)
+	case key.Burst < 0:
+		return nil, fmt.Errorf("%w: burst must not be negative", ErrInvalid)
+	}
+	for _, issuer := range spec.Issuers {
+		issuer = strings.ToLower(strings.TrimSpace(issuer))
+		if raw, err := hex.DecodeString(issuer); err != nil || len(raw) != sha1Size {
+			return nil, fmt.Errorf("%w: issuer %q is not a hex SHA-1 issuer key hash", ErrInvalid, issuer)
+		}
+		if !slices.Contains(key.Issuers, issuer) {
+			key.Issuers = append(key.Issuers, issuer)
+		}
+	}
+	return key, nil
+}
+
+// burstOf returns the key's bucket size, one second of its rate when it sets none
+func burstOf(key *Key) float64 {
+	if key.Burst > 0 {
+		return float64(key.Burst)
+	}
+	return max(1, math.Ceil(key.RatePerSecond))
+}
+
+// bucket is a token bucket refilled continuously
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func (b *bucket) take(rate, burst, cost float64, now time.Time) bool {
+	if b.updated.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = min(burst, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	}
+	b.updated = now
+	if b.tokens < cost {
+		return false
+	}
+	b.tokens -= cost
+	return true
+}
+
+func digestOf(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	raw := make([]byte, n)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate API key: %w", err)
+	}
+	return hex.EncodeToString(raw), nil
+}
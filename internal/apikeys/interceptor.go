@@ -0,0 +1,89 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/shared/api/proto/ocsp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// IssuerMetadataKey is the request metadata key naming, as a hex SHA-1 issuer key hash, the
+// issuer a call is metered under. It grants nothing: keys are scoped by the issuers the service
+// holds statuses for
+const IssuerMetadataKey = "x-issuer"
+
+type keyKey struct{}
+
+// WithKey returns a context carrying the API key a call authenticated with
+func WithKey(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, keyKey{}, key)
+}
+
+// KeyFrom returns the API key a call authenticated with, or nil
+func KeyFrom(ctx context.Context) *Key {
+	key, _ := ctx.Value(keyKey{}).(*Key)
+	return key
+}
+
+// mutations are the RPCs that change statuses
+var mutations = map[string]bool{
+	ocsp.OCSPService_UpdateStatus_FullMethodName:      true,
+	ocsp.OCSPService_BatchUpdateStatus_FullMethodName: true,
+}
+
+// UnaryServerInterceptor authenticates, rate limits and attributes RPCs carrying an API key.
+// With require, mutation RPCs without a key are refused
+func UnaryServerInterceptor(m *Manager, require bool, issuers []string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		mutation := mutations[info.FullMethod]
+		md, _ := metadata.FromIncomingContext(ctx)
+		var token string
+		if values := md.Get("authorization"); len(values) > 0 {
+			token, _ = strings.CutPrefix(values[0], "Bearer ")
+			token = strings.TrimSpace(token)
+		}
+		if !strings.HasPrefix(token, TokenPrefix) {
+			if mutation && require {
+				requests.WithLabelValues("missing").Inc()
+				return nil, status.Error(codes.Unauthenticated, "status changes need an API key")
+			}
+			return handler(ctx, req)
+		}
+
+		key, err := m.Authenticate(ctx, token)
+		if errors.Is(err, ErrUnknownToken) {
+			requests.WithLabelValues("unknown").Inc()
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Unavailable, "failed to check API key: %v", err)
+		}
+
+		if mutation && !key.PermitsAll(issuers) {
+			requests.WithLabelValues("forbidden").Inc()
+			return nil, status.Errorf(codes.PermissionDenied, "API key %s may only change statuses of its issuers, and this service holds statuses of others", key.Principal())
+		}
+
+		// A batch costs one token per update, so batching does not get around the limit
+		cost := 1
+		if batch, ok := req.(*ocsp.BatchUpdateStatusRequest); ok {
+			cost = max(cost, len(batch.GetUpdates()))
+		}
+		if !m.Allow(key, cost) {
+			requests.WithLabelValues("throttled").Inc()
+			if float64(cost) > burstOf(key) {
+				return nil, status.Errorf(codes.ResourceExhausted, "batch of %d updates exceeds the burst of API key %s", cost, key.Principal())
+			}
+			return nil, status.Errorf(codes.ResourceExhausted, "API key %s is over its rate limit", key.Principal())
+		}
+
+		requests.WithLabelValues("accepted").Inc()
+		return handler(WithKey(approval.WithPrincipal(ctx, key.Principal()), key), req)
+	}
+}
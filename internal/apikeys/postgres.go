@@ -0,0 +1,122 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// uniqueViolation is the SQLSTATE of a duplicate live key name
+const uniqueViolation = "23505"
+
+// Postgres keeps API keys in the api_keys table so every replica accepts them
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgres creates a Postgres API key store
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+const selectKey = `SELECT id, tenant, name, digest, COALESCE(previous_digest, ''), previous_expires_at, issuers,
+	rate_per_second, burst, created_at, created_by, rotated_at, revoked_at, COALESCE(revoked_by, '') FROM api_keys`
+
+// Insert adds a key with the digest of its secret. It returns ErrNameTaken when the tenant
+// already has a live key of that name
+func (p *Postgres) Insert(ctx context.Context, key *Key, digest string) error {
+	err := p.db.QueryRow(ctx, `
+		INSERT INTO api_keys (id, tenant, name, digest, issuers, rate_per_second, burst, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING created_at
+	`, key.ID, key.Tenant, key.Name, digest, key.Issuers, key.RatePerSecond, key.Burst, key.CreatedBy).Scan(&key.CreatedAt)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrNameTaken
+	}
+	return err
+}
+
+// Get returns one key with its digests, or ErrNotFound
+func (p *Postgres) Get(ctx context.Context, id string) (*stored, error) {
+	key, err := scanKey(p.db.QueryRow(ctx, selectKey+` WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return key, err
+}
+
+// List returns up to limit keys of tenant, or of every tenant when it is empty, ordered by ID
+// after afterID
+func (p *Postgres) List(ctx context.Context, tenant, afterID string, limit int) ([]Key, error) {
+	rows, err := p.db.Query(ctx, selectKey+`
+		WHERE ($1 = '' OR tenant = $1) AND id > $2
+		ORDER BY id
+		LIMIT $3
+	`, tenant, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []Key
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key.Key)
+	}
+	return keys, rows.Err()
+}
+
+// Rotate replaces the digest of a live key, keeping the replaced one valid until graceUntil. It
+// returns ErrRevoked when the key is revoked
+func (p *Postgres) Rotate(ctx context.Context, id, digest string, graceUntil time.Time) error {
+	tag, err := p.db.Exec(ctx, `
+		UPDATE api_keys SET previous_digest = digest, previous_expires_at = $3, digest = $2, rotated_at = NOW()
+		WHERE id = $1 AND revoked_at IS NULL
+	`, id, digest, graceUntil)
+	if err != nil {
+		return err
+	}
+	return p.affected(ctx, id, tag.RowsAffected())
+}
+
+// Revoke stops a live key from authenticating, recording revokedBy. It returns ErrRevoked when
+// the key is already revoked
+func (p *Postgres) Revoke(ctx context.Context, id, revokedBy string) error {
+	tag, err := p.db.Exec(ctx, `
+		UPDATE api_keys SET revoked_at = NOW(), revoked_by = NULLIF($2, '')
+		WHERE id = $1 AND revoked_at IS NULL
+	`, id, revokedBy)
+	if err != nil {
+		return err
+	}
+	return p.affected(ctx, id, tag.RowsAffected())
+}
+
+// affected returns ErrNotFound or ErrRevoked when an update of a live key changed nothing
+func (p *Postgres) affected(ctx context.Context, id string, n int64) error {
+	if n > 0 {
+		return nil
+	}
+	if _, err := p.Get(ctx, id); err != nil {
+		return err
+	}
+	return ErrRevoked
+}
+
+func scanKey(row pgx.Row) (*stored, error) {
+	var key stored
+	if err := row.Scan(&key.ID, &key.Tenant, &key.Name, &key.digest, &key.previousDigest, &key.previousExpiresAt,
+		&key.Issuers, &key.RatePerSecond, &key.Burst, &key.CreatedAt, &key.CreatedBy, &key.RotatedAt,
+		&key.RevokedAt, &key.RevokedBy); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
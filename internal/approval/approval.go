@@ -0,0 +1,303 @@
+// Package approval stages high-impact revocations as pending requests that take effect only
+// once a second authorized principal approves them
+package approval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Request states
+const (
+	StatePending  = "pending"
+	StateApplying = "applying"
+	StateApproved = "approved"
+	StateRejected = "rejected"
+)
+
+var (
+	// ErrNotFound is returned for unknown request IDs
+	ErrNotFound = errors.New("approval request not found")
+	// ErrNotPending is returned when a request was already decided, is being applied or expired
+	ErrNotPending = errors.New("approval request is no longer pending")
+	// ErrAnonymous is returned when a change needing approval comes from an unauthenticated
+	// caller, since nobody could then tell the approver apart from the requester
+	ErrAnonymous = errors.New("this change needs a second approval and must be requested by an authenticated principal")
+	// ErrSelfApproval is returned when the requester tries to approve their own request
+	ErrSelfApproval = errors.New("a request must be approved by a principal other than its requester")
+	// ErrNotApprover is returned when the caller may not decide requests
+	ErrNotApprover = errors.New("caller is not an approver")
+)
+
+var staged = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "approval_requests_total",
+	Help:      "Revocation approval requests, by outcome: staged, approved or rejected.",
+}, []string{"outcome"})
+
+func init() {
+	metrics.Registry.MustRegister(staged)
+}
+
+// PendingError reports that updates were staged rather than applied
+type PendingError struct {
+	ID     int64
+	Reason string
+}
+
+func (e *PendingError) Error() string {
+	return fmt.Sprintf("staged for a second approval as request %d (%s)", e.ID, e.Reason)
+}
+
+// Request is a staged set of updates
+type Request struct {
+	ID          int64            `json:"id"`
+	State       string           `json:"state"`
+	Reason      string           `json:"reason"`
+	Updates     []storage.Update `json:"updates"`
+	RequestedBy string           `json:"requested_by"`
+	RequestedAt time.Time        `json:"requested_at"`
+	ExpiresAt   time.Time        `json:"expires_at"`
+	DecidedBy   string           `json:"decided_by,omitempty"`
+	DecidedAt   *time.Time       `json:"decided_at,omitempty"`
+}
+
+// Policy selects the revocations that need a second approval
+type Policy struct {
+	// CASerials are serials of CA certificates; revoking any of them needs approval
+	CASerials []string
+	// Reasons are revocation reasons that need approval, such as keyCompromise
+	Reasons []string
+	// MaxBatch is the largest number of revocations a batch may carry without approval; 0
+	// disables the batch rule
+	MaxBatch int
+}
+
+type policy struct {
+	caSerials map[string]bool
+	reasons   map[string]bool
+	maxBatch  int
+}
+
+func compile(p Policy) (policy, error) {
+	c := policy{caSerials: make(map[string]bool), reasons: make(map[string]bool), maxBatch: p.MaxBatch}
+	for _, serial := range p.CASerials {
+		normalized, err := bulk.NormalizeSerial(serial)
+		if err != nil {
+			return policy{}, err
+		}
+		c.caSerials[normalized] = true
+	}
+	for _, reason := range p.Reasons {
+		name, ok := revocation.ParseReason(reason)
+		if !ok {
+			return policy{}, fmt.Errorf("unknown revocation reason %q", reason)
+		}
+		c.reasons[name] = true
+	}
+	return c, nil
+}
+
+// match returns why updates need approval, or "" when they do not
+func (p policy) match(updates []storage.Update) string {
+	revocations := 0
+	for _, u := range updates {
+		if u.Status != storage.StatusRevoked {
+			continue
+		}
+		revocations++
+		if serial, err := bulk.NormalizeSerial(u.Serial); err == nil && p.caSerials[serial] {
+			return fmt.Sprintf("revokes CA certificate %s", serial)
+		}
+		if reason, ok := revocation.ParseReason(u.RevocationReason); ok && p.reasons[reason] {
+			return fmt.Sprintf("revokes %s with reason %s", u.Serial, reason)
+		}
+	}
+	if p.maxBatch > 0 && revocations > p.maxBatch {
+		return fmt.Sprintf("revokes %d serials in one batch, more than %d", revocations, p.maxBatch)
+	}
+	return ""
+}
+
+// Store stages writes matching the policy instead of passing them to next. Reads and other
+// writes pass through unchanged
+type Store struct {
+	next      storage.Store
+	db        *Postgres
+	policy    policy
+	approvers map[string]bool
+	ttl       time.Duration
+	logger    *logger.Logger
+}
+
+// NewStore creates an approval gate in front of next. Only principals in approvers may decide
+// requests, and requests not decided within ttl expire
+func NewStore(next storage.Store, db *Postgres, p Policy, approvers []string, ttl time.Duration, logger *logger.Logger) (*Store, error) {
+	compiled, err := compile(p)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{next: next, db: db, policy: compiled, approvers: make(map[string]bool), ttl: ttl, logger: logger}
+	for _, name := range approvers {
+		s.approvers[name] = true
+	}
+	return s, nil
+}
+
+// RequiresApproval reports whether updates, applied as one batch, would be staged
+func (s *Store) RequiresApproval(updates []storage.Update) bool {
+	return s.policy.match(updates) != ""
+}
+
+// Get passes through
+func (s *Store) Get(ctx context.Context, serial string) (*storage.Record, error) {
+	return s.next.Get(ctx, serial)
+}
+
+// ListRevoked passes through
+func (s *Store) ListRevoked(ctx context.Context) ([]storage.Record, error) {
+	return s.next.ListRevoked(ctx)
+}
+
+// Upsert stages the update if it needs approval, returning a *PendingError
+func (s *Store) Upsert(ctx context.Context, update storage.Update) error {
+	if err := s.stage(ctx, []storage.Update{update}); err != nil {
+		return err
+	}
+	return s.next.Upsert(ctx, update)
+}
+
+// ApplyBatch stages the whole batch if it needs approval, returning a *PendingError
+func (s *Store) ApplyBatch(ctx context.Context, updates []storage.Update) error {
+	if err := s.stage(ctx, updates); err != nil {
+		return err
+	}
+	return s.next.ApplyBatch(ctx, updates)
+}
+
+// stage records updates needing approval and returns the *PendingError; nil means the updates
+// may be applied directly
+func (s *Store) stage(ctx context.Context, updates []storage.Update) error {
+	reason := s.policy.match(updates)
+	if reason == "" {
+		return nil
+	}
+	requester := PrincipalFrom(ctx)
+	if requester == "" {
+		return ErrAnonymous
+	}
+	id, err := s.db.Create(ctx, reason, updates, requester)
+	if err != nil {
+		return fmt.Errorf("failed to stage approval request: %w", err)
+	}
+	staged.WithLabelValues("staged").Inc()
+	s.logger.Info("Staged revocation for approval",
+		zap.Int64("request", id),
+		zap.String("reason", reason),
+		zap.String("requested_by", requester),
+		zap.Int("updates", len(updates)),
+	)
+	return &PendingError{ID: id, Reason: reason}
+}
+
+// List returns requests in a state, newest first; an empty state lists every request
+func (s *Store) List(ctx context.Context, state string) ([]Request, error) {
+	requests, err := s.db.List(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+	for i := range requests {
+		requests[i].ExpiresAt = requests[i].RequestedAt.Add(s.ttl)
+	}
+	return requests, nil
+}
+
+// Approve applies a pending request on behalf of the principal in ctx, who must be an
+// approver other than the requester
+func (s *Store) Approve(ctx context.Context, id int64) (*Request, error) {
+	approver, err := s.decider(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if req.RequestedBy == approver {
+		return nil, ErrSelfApproval
+	}
+	// Claiming the request first keeps two approvers from applying it twice
+	if err := s.db.Transition(ctx, id, StatePending, StateApplying, "", s.ttl); err != nil {
+		return nil, err
+	}
+	if err := s.next.ApplyBatch(ctx, req.Updates); err != nil {
+		if resetErr := s.db.Transition(ctx, id, StateApplying, StatePending, "", 0); resetErr != nil {
+			s.logger.Error("Failed to return approval request to pending", zap.Int64("request", id), zap.Error(resetErr))
+		}
+		return nil, err
+	}
+	if err := s.db.Transition(ctx, id, StateApplying, StateApproved, approver, 0); err != nil {
+		return nil, err
+	}
+	staged.WithLabelValues("approved").Inc()
+	s.logger.Info("Approved revocation request",
+		zap.Int64("request", id),
+		zap.String("requested_by", req.RequestedBy),
+		zap.String("approved_by", approver),
+	)
+	return s.get(ctx, id)
+}
+
+// Reject discards a pending request. The requester may withdraw their own request; anyone
+// else must be an approver
+func (s *Store) Reject(ctx context.Context, id int64) (*Request, error) {
+	caller := PrincipalFrom(ctx)
+	if caller == "" {
+		return nil, ErrNotApprover
+	}
+	req, err := s.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if caller != req.RequestedBy && !s.approvers[caller] {
+		return nil, ErrNotApprover
+	}
+	if err := s.db.Transition(ctx, id, StatePending, StateRejected, caller, 0); err != nil {
+		return nil, err
+	}
+	staged.WithLabelValues("rejected").Inc()
+	s.logger.Info("Rejected revocation request", zap.Int64("request", id), zap.String("rejected_by", caller))
+	return s.get(ctx, id)
+}
+
+// Request returns one request
+func (s *Store) Request(ctx context.Context, id int64) (*Request, error) {
+	return s.get(ctx, id)
+}
+
+func (s *Store) get(ctx context.Context, id int64) (*Request, error) {
+	req, err := s.db.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	req.ExpiresAt = req.RequestedAt.Add(s.ttl)
+	return req, nil
+}
+
+func (s *Store) decider(ctx context.Context) (string, error) {
+	caller := PrincipalFrom(ctx)
+	if caller == "" || !s.approvers[caller] {
+		return "", ErrNotApprover
+	}
+	return caller, nil
+}
@@ -0,0 +1,109 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// listLimit bounds how many requests List returns
+const listLimit = 500
+
+// Postgres keeps approval requests in the approval_requests table so every replica sees them
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgres creates a Postgres approval request store
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Create stores a pending request and returns its ID
+func (p *Postgres) Create(ctx context.Context, reason string, updates []storage.Update, requestedBy string) (int64, error) {
+	data, err := json.Marshal(updates)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = p.db.QueryRow(ctx, `
+		INSERT INTO approval_requests (state, reason, updates, requested_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, StatePending, reason, data, requestedBy).Scan(&id)
+	return id, err
+}
+
+const selectRequest = `SELECT id, state, reason, updates, requested_by, requested_at, COALESCE(decided_by, ''), decided_at FROM approval_requests`
+
+// Get returns one request, or ErrNotFound
+func (p *Postgres) Get(ctx context.Context, id int64) (*Request, error) {
+	req, err := scanRequest(p.db.QueryRow(ctx, selectRequest+` WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return req, err
+}
+
+// List returns the newest requests in a state, or in any state when state is empty
+func (p *Postgres) List(ctx context.Context, state string) ([]Request, error) {
+	rows, err := p.db.Query(ctx, selectRequest+`
+		WHERE $1 = '' OR state = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, state, listLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var requests []Request
+	for rows.Next() {
+		req, err := scanRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, *req)
+	}
+	return requests, rows.Err()
+}
+
+// Transition moves a request from one state to another, recording decidedBy when set. With a
+// positive ttl, requests older than ttl count as expired. It returns ErrNotPending when the
+// request is not in state from
+func (p *Postgres) Transition(ctx context.Context, id int64, from, to, decidedBy string, ttl time.Duration) error {
+	tag, err := p.db.Exec(ctx, `
+		UPDATE approval_requests SET
+			state = $3,
+			decided_by = COALESCE(NULLIF($4, ''), decided_by),
+			decided_at = CASE WHEN $4 = '' THEN decided_at ELSE NOW() END
+		WHERE id = $1 AND state = $2 AND ($5::float8 <= 0 OR requested_at > NOW() - make_interval(secs => $5::float8))
+	`, id, from, to, decidedBy, ttl.Seconds())
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		if _, err := p.Get(ctx, id); err != nil {
+			return err
+		}
+		return ErrNotPending
+	}
+	return nil
+}
+
+func scanRequest(row pgx.Row) (*Request, error) {
+	var req Request
+	var updates []byte
+	if err := row.Scan(&req.ID, &req.State, &req.Reason, &updates, &req.RequestedBy, &req.RequestedAt, &req.DecidedBy, &req.DecidedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(updates, &req.Updates); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
@@ -0,0 +1,179 @@
+package approval
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gigvault/shared/pkg/httputil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the authenticated caller's name
+func WithPrincipal(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, principalKey{}, name)
+}
+
+// PrincipalFrom returns the authenticated caller's name, or "" for anonymous callers
+func PrincipalFrom(ctx context.Context) string {
+	name, _ := ctx.Value(principalKey{}).(string)
+	return name
+}
+
+// Principals maps bearer tokens to principal names. Only SHA-256 digests of the tokens are
+// kept, so the file they are loaded from never holds a usable credential
+type Principals struct {
+	digests map[string]string // hex digest -> name
+}
+
+// LoadPrincipals reads one principal per line as "<name> <hex SHA-256 of token>"; blank lines
+// and lines starting with # are ignored
+func LoadPrincipals(path string) (*Principals, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open principals file: %w", err)
+	}
+	defer file.Close()
+
+	p := &Principals{digests: make(map[string]string)}
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("principals line %d: want \"<name> <sha256 hex>\"", n)
+		}
+		digest := strings.ToLower(fields[1])
+		if raw, err := hex.DecodeString(digest); err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("principals line %d: %q is not a hex SHA-256 digest", n, fields[1])
+		}
+		if _, dup := p.digests[digest]; dup {
+			return nil, fmt.Errorf("principals line %d: token digest is already assigned", n)
+		}
+		p.digests[digest] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read principals file: %w", err)
+	}
+	return p, nil
+}
+
+// Authenticate returns the principal a bearer token belongs to
+func (p *Principals) Authenticate(token string) (string, bool) {
+	sum := sha256.Sum256([]byte(token))
+	digest := hex.EncodeToString(sum[:])
+	for candidate, name := range p.digests {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(digest)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// Authenticator identifies callers by bearer token. Callers without a token stay anonymous;
+// a token that matches no principal is rejected
+type Authenticator struct {
+	principals atomic.Pointer[Principals]
+}
+
+// NewAuthenticator creates an authenticator over principals
+func NewAuthenticator(principals *Principals) *Authenticator {
+	a := &Authenticator{}
+	a.principals.Store(principals)
+	return a
+}
+
+// SetPrincipals swaps in a reloaded principals file
+func (a *Authenticator) SetPrincipals(principals *Principals) {
+	a.principals.Store(principals)
+}
+
+func (a *Authenticator) identify(header string) (name string, ok bool) {
+	if header == "" {
+		return "", true
+	}
+	token, found := strings.CutPrefix(header, "Bearer ")
+	if !found {
+		return "", false
+	}
+	return a.principals.Load().Authenticate(strings.TrimSpace(token))
+}
+
+// HTTPMiddleware attaches the caller's principal to the request context, answering 401 to an
+// unknown token
+func (a *Authenticator) HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := a.identify(r.Header.Get("Authorization"))
+		if !ok {
+			httputil.Unauthorized(w, "unknown bearer token")
+			return
+		}
+		if name != "" {
+			r = r.WithContext(WithPrincipal(r.Context(), name))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePrincipal answers 401 to requests without an authenticated principal. It must come
+// after HTTPMiddleware
+func RequirePrincipal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if PrincipalFrom(r.Context()) == "" {
+			httputil.Unauthorized(w, "this endpoint needs the bearer token of an admin principal")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePrincipalForChanges applies RequirePrincipal to every request but GET and HEAD
+func RequirePrincipalForChanges(next http.Handler) http.Handler {
+	required := RequirePrincipal(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		required.ServeHTTP(w, r)
+	})
+}
+
+// UnaryServerInterceptor attaches the caller's principal to the RPC context, failing an RPC
+// with an unknown token as UNAUTHENTICATED. RPCs an earlier interceptor already identified,
+// such as by API key, keep their principal
+func (a *Authenticator) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if PrincipalFrom(ctx) != "" {
+			return handler(ctx, req)
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		var header string
+		if values := md.Get("authorization"); len(values) > 0 {
+			header = values[0]
+		}
+		name, ok := a.identify(header)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "unknown bearer token")
+		}
+		if name != "" {
+			ctx = WithPrincipal(ctx, name)
+		}
+		return handler(ctx, req)
+	}
+}
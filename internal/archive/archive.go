@@ -0,0 +1,181 @@
+// Package archive writes generated CRLs and status snapshots to object storage for retention
+// and as a static last-resort fallback
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/crl"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Object kinds, used in keys and metric labels
+const (
+	KindCRL      = "crl"
+	KindDeltaCRL = "delta_crl"
+	KindSnapshot = "snapshot"
+)
+
+var uploads = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "archive_uploads_total",
+	Help:      "Objects written to the archive by kind and result.",
+}, []string{"kind", "result"})
+
+func init() {
+	metrics.Registry.MustRegister(uploads)
+}
+
+// ObjectStore stores immutable objects by key
+type ObjectStore interface {
+	Put(ctx context.Context, key, contentType string, body []byte) error
+}
+
+// Object is a stored object as listed by a Pruner
+type Object struct {
+	Key          string
+	LastModified time.Time
+}
+
+// Pruner is an ObjectStore that can also list and delete objects, so the retention policy can
+// expire them without a bucket lifecycle rule
+type Pruner interface {
+	List(ctx context.Context, prefix string) ([]Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// RecordSource streams every stored status
+type RecordSource interface {
+	ForEachRecord(ctx context.Context, fn func(storage.Record) error) error
+}
+
+// Archiver lays objects out under date-partitioned keys so bucket lifecycle rules can expire
+// them by prefix:
+//
+//	<prefix>crls/<name>/<kind>/YYYY/MM/DD/<crl number>.crl
+//	<prefix>crls/<name>/<kind>/latest.crl
+//	<prefix>statuses/YYYY/MM/DD/<timestamp>.ndjson.gz
+type Archiver struct {
+	store  ObjectStore
+	prefix string
+	logger *logger.Logger
+}
+
+// NewArchiver creates an archiver writing below prefix
+func NewArchiver(store ObjectStore, prefix string, logger *logger.Logger) *Archiver {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &Archiver{store: store, prefix: prefix, logger: logger}
+}
+
+// ArchiveCRL stores a generated CRL and replaces the latest copy served as a fallback. name
+// distinguishes CRL scopes such as partitions and is typically the distribution point path
+func (a *Archiver) ArchiveCRL(ctx context.Context, name string, list *crl.CRL) error {
+	kind := KindCRL
+	if list.IsDelta() {
+		kind = KindDeltaCRL
+	}
+
+	dir := path.Join(a.prefix+"crls", strings.Trim(name, "/"), kind)
+	key := path.Join(dir, list.ThisUpdate.UTC().Format("2006/01/02"), list.Number.String()+".crl")
+	if err := a.put(ctx, kind, key, "application/pkix-crl", list.DER); err != nil {
+		return err
+	}
+	return a.put(ctx, kind, path.Join(dir, "latest.crl"), "application/pkix-crl", list.DER)
+}
+
+// Snapshot stores every status as gzipped NDJSON and returns the object key
+func (a *Archiver) Snapshot(ctx context.Context, source RecordSource) (string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+
+	count := 0
+	err := source.ForEachRecord(ctx, func(rec storage.Record) error {
+		count++
+		return enc.Encode(rec)
+	})
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		uploads.WithLabelValues(KindSnapshot, "error").Inc()
+		return "", fmt.Errorf("failed to build status snapshot: %w", err)
+	}
+
+	now := time.Now().UTC()
+	key := path.Join(a.prefix+"statuses", now.Format("2006/01/02"), now.Format("20060102T150405Z")+".ndjson.gz")
+	if err := a.put(ctx, KindSnapshot, key, "application/gzip", buf.Bytes()); err != nil {
+		return "", err
+	}
+
+	a.logger.Info("Status snapshot archived", zap.String("key", key), zap.Int("records", count), zap.Int("bytes", buf.Len()))
+	return key, nil
+}
+
+// RunSnapshots takes a snapshot immediately and then on every interval until the context is cancelled
+func (a *Archiver) RunSnapshots(ctx context.Context, source RecordSource, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := a.Snapshot(ctx, source); err != nil {
+			a.logger.Error("Failed to archive status snapshot", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge deletes the CRLs and snapshots stored before cutoff and returns how many it deleted.
+// The latest copy of each CRL is kept however old it is, since it is the fallback
+func (a *Archiver) Purge(ctx context.Context, cutoff time.Time) (int, error) {
+	pruner, ok := a.store.(Pruner)
+	if !ok {
+		return 0, errors.New("archive store cannot list or delete objects")
+	}
+	deleted := 0
+	// Only the archive's own layout is listed, so other objects sharing the bucket are safe
+	for _, dir := range []string{"crls/", "statuses/"} {
+		objects, err := pruner.List(ctx, a.prefix+dir)
+		if err != nil {
+			return deleted, err
+		}
+		for _, obj := range objects {
+			if !obj.LastModified.Before(cutoff) || path.Base(obj.Key) == "latest.crl" {
+				continue
+			}
+			if err := pruner.Delete(ctx, obj.Key); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (a *Archiver) put(ctx context.Context, kind, key, contentType string, body []byte) error {
+	if err := a.store.Put(ctx, key, contentType, body); err != nil {
+		uploads.WithLabelValues(kind, "error").Inc()
+		return fmt.Errorf("failed to archive %s: %w", key, err)
+	}
+	uploads.WithLabelValues(kind, "success").Inc()
+	return nil
+}
@@ -0,0 +1,184 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/sigv4"
+)
+
+// maxListBytes bounds one page of a bucket listing, which holds at most 1000 keys
+const maxListBytes = 4 << 20
+
+// S3Options configures an S3-compatible bucket. Google Cloud Storage is reachable through its
+// interoperability endpoint https://storage.googleapis.com with HMAC keys and region "auto"
+type S3Options struct {
+	// Endpoint defaults to https://s3.<region>.amazonaws.com
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle addresses the bucket in the path instead of the host name
+	PathStyle bool
+	Timeout   time.Duration
+}
+
+// S3 uploads, lists and deletes objects with AWS Signature Version 4
+type S3 struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3 creates an S3-compatible object store
+func NewS3(opts S3Options) (*S3, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("bucket is required")
+	}
+	if opts.Region == "" {
+		return nil, fmt.Errorf("region is required")
+	}
+	if opts.AccessKeyID == "" || opts.SecretAccessKey == "" {
+		return nil, fmt.Errorf("access key ID and secret access key are required")
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", opts.Region)
+	}
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", opts.Endpoint)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+
+	return &S3{
+		opts:     opts,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: opts.Timeout},
+	}, nil
+}
+
+// Put uploads body under key, replacing any existing object
+func (s *S3) Put(ctx context.Context, key, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := s.send(req, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError("upload of "+key, resp)
+	}
+	return nil
+}
+
+// List returns every object whose key starts with prefix, following continuation tokens
+func (s *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		target := s.bucketURL("/")
+		target.RawQuery = query.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.send(req, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := statusError("listing of "+prefix, resp)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(io.LimitReader(resp.Body, maxListBytes)).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse listing of %s: %w", prefix, err)
+		}
+		for _, c := range result.Contents {
+			objects = append(objects, Object{Key: c.Key, LastModified: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Delete removes the object stored under key; deleting a missing object succeeds
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.send(req, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return statusError("deletion of "+key, resp)
+	}
+	return nil
+}
+
+func (s *S3) objectURL(key string) string {
+	target := s.bucketURL("/" + key)
+	return target.String()
+}
+
+// bucketURL addresses path within the bucket, in the host name or the path
+func (s *S3) bucketURL(path string) *url.URL {
+	target := *s.endpoint
+	if s.opts.PathStyle {
+		path = "/" + s.opts.Bucket + path
+	} else {
+		target.Host = s.opts.Bucket + "." + target.Host
+	}
+	target.Path = path
+	target.RawPath = sigv4.EscapePath(path)
+	return &target
+}
+
+func (s *S3) send(req *http.Request, body []byte) (*http.Response, error) {
+	sigv4.Sign(req, body, sigv4.Credentials{
+		AccessKeyID:     s.opts.AccessKeyID,
+		SecretAccessKey: s.opts.SecretAccessKey,
+	}, s.opts.Region, "s3", time.Now())
+	return s.client.Do(req)
+}
+
+func statusError(what string, resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s failed with status %d: %s", what, resp.StatusCode, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,89 @@
+// Package audit records every committed status change with the principal that made it, and
+// exports ranges of that trail as signed JSONL bundles for auditors
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Anonymous is recorded as the actor of changes made without an authenticated principal
+const Anonymous = "anonymous"
+
+var writeFailures = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "audit_write_failures_total",
+	Help:      "Committed status changes that could not be recorded in the audit trail.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(writeFailures)
+}
+
+// Entry is one recorded status change
+type Entry struct {
+	ID               int64      `json:"id"`
+	Time             time.Time  `json:"time"`
+	Serial           string     `json:"serial"`
+	Status           string     `json:"status"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RevocationReason string     `json:"revocation_reason,omitempty"`
+	Actor            string     `json:"actor"`
+}
+
+// Store records the writes it passes to the wrapped store once they commit. A failure to
+// record is logged and counted but does not fail the write, which has already been applied
+type Store struct {
+	storage.Store
+	db     *Postgres
+	logger *logger.Logger
+}
+
+// NewStore wraps store so its committed writes are recorded in db
+func NewStore(store storage.Store, db *Postgres, logger *logger.Logger) *Store {
+	return &Store{Store: store, db: db, logger: logger}
+}
+
+// Upsert writes the update and records it
+func (s *Store) Upsert(ctx context.Context, update storage.Update) error {
+	if err := s.Store.Upsert(ctx, update); err != nil {
+		return err
+	}
+	s.record(ctx, []storage.Update{update})
+	return nil
+}
+
+// ApplyBatch writes the updates and records each of them
+func (s *Store) ApplyBatch(ctx context.Context, updates []storage.Update) error {
+	if err := s.Store.ApplyBatch(ctx, updates); err != nil {
+		return err
+	}
+	s.record(ctx, updates)
+	return nil
+}
+
+func (s *Store) record(ctx context.Context, updates []storage.Update) {
+	if len(updates) == 0 {
+		return
+	}
+	actor := approval.PrincipalFrom(ctx)
+	if actor == "" {
+		actor = Anonymous
+	}
+	// The write has committed, so record it even if the caller has gone away
+	if err := s.db.Append(context.WithoutCancel(ctx), updates, actor); err != nil {
+		writeFailures.Add(float64(len(updates)))
+		s.logger.Error("Failed to record status changes in the audit trail",
+			zap.Int("updates", len(updates)),
+			zap.String("actor", actor),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,273 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/crl"
+)
+
+// Format and Version identify an audit export
+const (
+	Format  = "gigvault-ocsp-audit"
+	Version = 1
+)
+
+const (
+	lineHeader  = "header"
+	lineEntry   = "entry"
+	lineTrailer = "trailer"
+)
+
+// maxLineBytes bounds one line when verifying an export
+const maxLineBytes = 1 << 20
+
+// ErrBadSignature is returned when an export's digest or signature does not verify
+var ErrBadSignature = errors.New("audit export signature does not verify")
+
+// Header describes an export
+type Header struct {
+	Format      string    `json:"format"`
+	Version     int       `json:"version"`
+	Service     string    `json:"service"`
+	CreatedAt   time.Time `json:"created_at"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	Certificate []byte    `json:"certificate"` // DER of the certificate whose key signed the export
+}
+
+// Summary is what an export or verification reports
+type Summary struct {
+	Header
+	Entries int    `json:"entries"`
+	Digest  string `json:"digest"`
+}
+
+type headerLine struct {
+	Type string `json:"type"`
+	Header
+}
+
+type entryLine struct {
+	Type string `json:"type"`
+	Entry
+}
+
+// trailerLine closes an export. Digest is the SHA-256 of every preceding byte, and Signature
+// is the responder's signature over that digest
+type trailerLine struct {
+	Type      string `json:"type"`
+	Entries   int    `json:"entries"`
+	Digest    string `json:"digest"`
+	Signature []byte `json:"signature"`
+}
+
+// Key signs exports; Certificate is embedded so auditors know which key to verify against
+type Key struct {
+	Signer      crypto.Signer
+	Certificate *x509.Certificate
+}
+
+// LoadKey reads a PEM private key and the PEM certificate for its public key
+func LoadKey(keyPath, certPath string) (*Key, error) {
+	signer, err := crl.LoadSigner(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := crl.LoadCertificate(certPath)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return nil, errors.New("audit signing key does not match its certificate")
+	}
+	return &Key{Signer: signer, Certificate: cert}, nil
+}
+
+// Exporter writes signed exports of the audit trail
+type Exporter struct {
+	db      *Postgres
+	service string
+	key     atomic.Pointer[Key]
+}
+
+// NewExporter creates an exporter signing with key
+func NewExporter(db *Postgres, key *Key, service string) *Exporter {
+	e := &Exporter{db: db, service: service}
+	e.key.Store(key)
+	return e
+}
+
+// SetKey swaps in a reloaded signing key
+func (e *Exporter) SetKey(key *Key) {
+	e.key.Store(key)
+}
+
+// Export streams the entries recorded in [from, to) to w as JSONL: a header, one line per
+// entry, and a trailer carrying the digest of the preceding bytes and its signature. An export
+// cut short has no trailer and fails verification
+func (e *Exporter) Export(ctx context.Context, w io.Writer, from, to time.Time) (*Summary, error) {
+	key := e.key.Load()
+	digest := sha256.New()
+	buf := bufio.NewWriter(io.MultiWriter(w, digest))
+	encoder := json.NewEncoder(buf)
+
+	summary := &Summary{Header: Header{
+		Format:      Format,
+		Version:     Version,
+		Service:     e.service,
+		CreatedAt:   time.Now().UTC(),
+		From:        from.UTC(),
+		To:          to.UTC(),
+		Certificate: key.Certificate.Raw,
+	}}
+	if err := encoder.Encode(headerLine{Type: lineHeader, Header: summary.Header}); err != nil {
+		return nil, err
+	}
+	err := e.db.ForEach(ctx, from, to, func(entry Entry) error {
+		summary.Entries++
+		return encoder.Encode(entryLine{Type: lineEntry, Entry: entry})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export audit trail: %w", err)
+	}
+
+	// The trailer is written after the digested bytes, straight to w
+	if err := buf.Flush(); err != nil {
+		return nil, err
+	}
+	sum := digest.Sum(nil)
+	signature, err := sign(key.Signer, sum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign audit export: %w", err)
+	}
+	summary.Digest = hex.EncodeToString(sum)
+	trailer := trailerLine{Type: lineTrailer, Entries: summary.Entries, Digest: summary.Digest, Signature: signature}
+	if err := json.NewEncoder(w).Encode(trailer); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// Verify checks an export against trusted, the certificate the auditor expects it to be signed
+// with, and returns its entries. Nothing is returned unless the whole export is read, its
+// count and digest match and the signature verifies
+func Verify(r io.Reader, trusted *x509.Certificate) (*Summary, []Entry, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	digest := sha256.New()
+
+	var summary *Summary
+	var entries []Entry
+	for n := 1; scanner.Scan(); n++ {
+		data := scanner.Bytes()
+		var l struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &l); err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if n == 1 && l.Type != lineHeader {
+			return nil, nil, errors.New("export does not start with a header")
+		}
+
+		switch l.Type {
+		case lineHeader:
+			var h headerLine
+			if err := json.Unmarshal(data, &h); err != nil || n != 1 || h.Format != Format {
+				return nil, nil, fmt.Errorf("line %d: unexpected header", n)
+			}
+			if h.Version != Version {
+				return nil, nil, fmt.Errorf("unsupported audit export version %d (this build reads version %d)", h.Version, Version)
+			}
+			if !bytes.Equal(h.Certificate, trusted.Raw) {
+				return nil, nil, errors.New("export was signed with a different certificate")
+			}
+			summary = &Summary{Header: h.Header}
+			digestLine(digest, data)
+
+		case lineEntry:
+			var e entryLine
+			if err := json.Unmarshal(data, &e); err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			entries = append(entries, e.Entry)
+			digestLine(digest, data)
+
+		case lineTrailer:
+			var t trailerLine
+			if err := json.Unmarshal(data, &t); err != nil {
+				return nil, nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if scanner.Scan() {
+				return nil, nil, fmt.Errorf("line %d: data after trailer", n+1)
+			}
+			if t.Entries != len(entries) {
+				return nil, nil, fmt.Errorf("export lists %d entries but holds %d", t.Entries, len(entries))
+			}
+			sum := digest.Sum(nil)
+			if t.Digest != hex.EncodeToString(sum) || verify(trusted.PublicKey, sum, t.Signature) != nil {
+				return nil, nil, ErrBadSignature
+			}
+			summary.Entries, summary.Digest = len(entries), t.Digest
+			return summary, entries, nil
+
+		default:
+			return nil, nil, fmt.Errorf("line %d: unknown line type %q", n, l.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read audit export: %w", err)
+	}
+	return nil, nil, errors.New("audit export is truncated: no trailer")
+}
+
+func digestLine(digest hash.Hash, line []byte) {
+	digest.Write(line)
+	digest.Write([]byte{'\n'})
+}
+
+// sign signs a SHA-256 digest; Ed25519 keys sign the digest bytes as the message
+func sign(signer crypto.Signer, digest []byte) ([]byte, error) {
+	var opts crypto.SignerOpts = crypto.SHA256
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		opts = crypto.Hash(0)
+	}
+	return signer.Sign(rand.Reader, digest, opts)
+}
+
+func verify(pub crypto.PublicKey, digest, signature []byte) error {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest, signature) {
+			return ErrBadSignature
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, digest, signature) {
+			return ErrBadSignature
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
@@ -0,0 +1,102 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// batchChunkSize bounds the number of statements queued per pgx batch
+const batchChunkSize = 1000
+
+// exportPageSize is how many entries ForEach reads per query
+const exportPageSize = 10000
+
+const appendQuery = `
+	INSERT INTO audit_log (recorded_at, serial, status, revoked_at, revocation_reason, actor)
+	VALUES (NOW(), $1, $2, $3, $4, $5)
+`
+
+// Postgres keeps the audit trail in the append-only audit_log table
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgres creates a Postgres audit trail
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Append records updates made by actor in a single transaction
+func (p *Postgres) Append(ctx context.Context, updates []storage.Update, actor string) error {
+	return db.WithTransaction(ctx, p.db, func(tx pgx.Tx) error {
+		for start := 0; start < len(updates); start += batchChunkSize {
+			end := min(start+batchChunkSize, len(updates))
+			batch := &pgx.Batch{}
+			for _, u := range updates[start:end] {
+				var revokedAt *time.Time
+				reason := ""
+				if u.Status == storage.StatusRevoked {
+					revokedAt, reason = u.RevokedAt, u.RevocationReason
+				}
+				batch.Queue(appendQuery, u.Serial, u.Status, revokedAt, reason, actor)
+			}
+			if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+				return fmt.Errorf("failed to record updates %d-%d: %w", start, end, err)
+			}
+		}
+		return nil
+	})
+}
+
+// ForEach streams the entries recorded in [from, to), oldest first. Entries are read
+// exportPageSize at a time, each page seeking past the last ID of the one before, so a long
+// export holds no transaction open and the trail being appended to does not shift it
+func (p *Postgres) ForEach(ctx context.Context, from, to time.Time, fn func(Entry) error) error {
+	var afterID int64
+	for {
+		page, err := p.page(ctx, from, to, afterID)
+		if err != nil {
+			return err
+		}
+		for _, e := range page {
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPageSize {
+			return nil
+		}
+		afterID = page[len(page)-1].ID
+	}
+}
+
+// page returns up to exportPageSize entries recorded in [from, to) with IDs above afterID
+func (p *Postgres) page(ctx context.Context, from, to time.Time, afterID int64) ([]Entry, error) {
+	rows, err := p.db.Query(ctx, `
+		SELECT id, recorded_at, serial, status, revoked_at, revocation_reason, actor
+		FROM audit_log
+		WHERE recorded_at >= $1 AND recorded_at < $2 AND id > $3
+		ORDER BY id
+		LIMIT $4
+	`, from, to, afterID, exportPageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.ID, &e.Time, &e.Serial, &e.Status, &e.RevokedAt, &e.RevocationReason, &e.Actor); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,376 @@
+// Package backup writes and restores signed archives of the full revocation state
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+// Archive identification; Version changes whenever the line layout does
+const (
+	Format  = "gigvault-ocsp-backup"
+	Version = 2
+)
+
+// restoreBatchSize is the number of records copied per statement during restore
+const restoreBatchSize = 5000
+
+// ErrBadSignature is returned when an archive was not signed with the restoring key, or was altered
+var ErrBadSignature = errors.New("backup signature does not match")
+
+// Line types. An archive is gzipped NDJSON: a header, every status, CRL number and archived
+// table row, and a trailer whose signature is an HMAC-SHA256 of every byte before it
+const (
+	lineHeader    = "header"
+	lineStatus    = "status"
+	lineCRLNumber = "crl_number"
+	lineRow       = "row"
+	lineTrailer   = "trailer"
+)
+
+// Header opens an archive
+type Header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Service   string    `json:"service,omitempty"`
+}
+
+// Summary describes a written or restored archive
+type Summary struct {
+	Header
+	Statuses   int            `json:"statuses"`
+	CRLNumbers int            `json:"crl_numbers"`
+	Rows       map[string]int `json:"rows,omitempty"`
+}
+
+type line struct {
+	Type string `json:"type"`
+}
+
+type headerLine struct {
+	Type string `json:"type"`
+	Header
+}
+
+type statusLine struct {
+	Type string `json:"type"`
+	storage.Record
+}
+
+type crlNumberLine struct {
+	Type string `json:"type"`
+	storage.CRLNumber
+}
+
+type rowLine struct {
+	Type  string          `json:"type"`
+	Table string          `json:"table"`
+	Row   json.RawMessage `json:"row"`
+}
+
+type trailerLine struct {
+	Type       string         `json:"type"`
+	Statuses   int            `json:"statuses"`
+	CRLNumbers int            `json:"crl_numbers"`
+	Rows       map[string]int `json:"rows"`
+	Signature  string         `json:"signature"`
+}
+
+// Source is the state a backup is taken from
+type Source interface {
+	ForEachRecord(ctx context.Context, fn func(storage.Record) error) error
+	ListCRLNumbers(ctx context.Context) ([]storage.CRLNumber, error)
+	ForEachRow(ctx context.Context, table string, fn func(json.RawMessage) error) error
+}
+
+// Target is an empty database a backup is restored into
+type Target interface {
+	Restore(ctx context.Context, fn func(*storage.Restore) error) error
+}
+
+// Write streams a signed archive of every status, CRL number and archived table row to w
+func Write(ctx context.Context, w io.Writer, src Source, key []byte, service string) (*Summary, error) {
+	if len(key) == 0 {
+		return nil, errors.New("a signing key is required")
+	}
+
+	gz := gzip.NewWriter(w)
+	mac := hmac.New(sha256.New, key)
+	buf := bufio.NewWriter(io.MultiWriter(gz, mac))
+	encoder := json.NewEncoder(buf)
+
+	summary := &Summary{Header: Header{Format: Format, Version: Version, CreatedAt: time.Now().UTC(), Service: service}}
+	if err := encoder.Encode(headerLine{Type: lineHeader, Header: summary.Header}); err != nil {
+		return nil, err
+	}
+
+	err := src.ForEachRecord(ctx, func(rec storage.Record) error {
+		summary.Statuses++
+		return encoder.Encode(statusLine{Type: lineStatus, Record: rec})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to export statuses: %w", err)
+	}
+
+	numbers, err := src.ListCRLNumbers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to export CRL numbers: %w", err)
+	}
+	for _, n := range numbers {
+		if err := encoder.Encode(crlNumberLine{Type: lineCRLNumber, CRLNumber: n}); err != nil {
+			return nil, err
+		}
+	}
+	summary.CRLNumbers = len(numbers)
+
+	summary.Rows = make(map[string]int, len(storage.ArchivedTables))
+	for _, table := range storage.ArchivedTables {
+		err := src.ForEachRow(ctx, table, func(row json.RawMessage) error {
+			summary.Rows[table]++
+			return encoder.Encode(rowLine{Type: lineRow, Table: table, Row: row})
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s: %w", table, err)
+		}
+	}
+
+	// The trailer is written after the signed bytes, straight to the gzip stream
+	if err := buf.Flush(); err != nil {
+		return nil, err
+	}
+	trailer := trailerLine{
+		Type:       lineTrailer,
+		Statuses:   summary.Statuses,
+		CRLNumbers: summary.CRLNumbers,
+		Rows:       summary.Rows,
+		Signature:  hex.EncodeToString(mac.Sum(nil)),
+	}
+	if err := json.NewEncoder(gz).Encode(trailer); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// Restore reads an archive into an empty target in a single transaction. Nothing is committed
+// unless the whole archive is read, its counts match and its signature verifies with key
+func Restore(ctx context.Context, r io.Reader, dst Target, key []byte) (*Summary, error) {
+	if len(key) == 0 {
+		return nil, errors.New("a signing key is required")
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	defer gz.Close()
+
+	var summary *Summary
+	err = dst.Restore(ctx, func(tx *storage.Restore) error {
+		s, err := restoreLines(ctx, bufio.NewReaderSize(gz, 64*1024), tx, hmac.New(sha256.New, key))
+		summary = s
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// Read verifies an archive and returns its statuses without a database, for offline tools.
+// Nothing is returned unless the whole archive is read, its counts match and its signature
+// verifies with key
+func Read(ctx context.Context, r io.Reader, key []byte) (*Summary, []storage.Record, error) {
+	if len(key) == 0 {
+		return nil, nil, errors.New("a signing key is required")
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	defer gz.Close()
+
+	collected := &collector{}
+	summary, err := restoreLines(ctx, bufio.NewReaderSize(gz, 64*1024), collected, hmac.New(sha256.New, key))
+	if err != nil {
+		return nil, nil, err
+	}
+	return summary, collected.records, nil
+}
+
+// sink receives restored lines; *storage.Restore writes them to the database
+type sink interface {
+	InsertRecords(ctx context.Context, records []storage.Record) error
+	InsertCRLNumbers(ctx context.Context, numbers []storage.CRLNumber) error
+	InsertRows(ctx context.Context, table string, rows []json.RawMessage) error
+}
+
+// collector keeps restored statuses in memory
+type collector struct {
+	records []storage.Record
+}
+
+func (c *collector) InsertRecords(ctx context.Context, records []storage.Record) error {
+	c.records = append(c.records, records...)
+	return nil
+}
+
+func (c *collector) InsertCRLNumbers(ctx context.Context, numbers []storage.CRLNumber) error {
+	return nil
+}
+
+func (c *collector) InsertRows(ctx context.Context, table string, rows []json.RawMessage) error {
+	return nil
+}
+
+// archivedTable reports whether table is one of storage.ArchivedTables
+func archivedTable(table string) bool {
+	for _, t := range storage.ArchivedTables {
+		if t == table {
+			return true
+		}
+	}
+	return false
+}
+
+func restoreLines(ctx context.Context, reader *bufio.Reader, tx sink, mac hash.Hash) (*Summary, error) {
+	summary := &Summary{Rows: make(map[string]int)}
+	var records []storage.Record
+	var numbers []storage.CRLNumber
+	rows := make(map[string][]json.RawMessage)
+	flush := func() error {
+		if len(records) == 0 {
+			return nil
+		}
+		if err := tx.InsertRecords(ctx, records); err != nil {
+			return fmt.Errorf("failed to restore statuses: %w", err)
+		}
+		records = records[:0]
+		return nil
+	}
+
+	for n := 1; ; n++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(data) == 0 {
+			return nil, errors.New("archive is truncated: no trailer")
+		}
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		var l line
+		if err := json.Unmarshal(data, &l); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if n == 1 && l.Type != lineHeader {
+			return nil, errors.New("archive does not start with a header")
+		}
+
+		switch l.Type {
+		case lineHeader:
+			var h headerLine
+			if err := json.Unmarshal(data, &h); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if n != 1 || h.Format != Format {
+				return nil, fmt.Errorf("line %d: unexpected header", n)
+			}
+			if h.Version != Version {
+				return nil, fmt.Errorf("unsupported backup version %d (this build reads version %d)", h.Version, Version)
+			}
+			summary.Header = h.Header
+
+		case lineStatus:
+			var s statusLine
+			if err := json.Unmarshal(data, &s); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if !storage.ValidStatus(s.Status) || s.Serial == "" {
+				return nil, fmt.Errorf("line %d: invalid status record", n)
+			}
+			records = append(records, s.Record)
+			summary.Statuses++
+			if len(records) >= restoreBatchSize {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+
+		case lineCRLNumber:
+			var c crlNumberLine
+			if err := json.Unmarshal(data, &c); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			numbers = append(numbers, c.CRLNumber)
+
+		case lineRow:
+			var r rowLine
+			if err := json.Unmarshal(data, &r); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if !archivedTable(r.Table) || len(r.Row) == 0 {
+				return nil, fmt.Errorf("line %d: invalid %q row", n, r.Table)
+			}
+			rows[r.Table] = append(rows[r.Table], r.Row)
+			summary.Rows[r.Table]++
+
+		case lineTrailer:
+			var t trailerLine
+			if err := json.Unmarshal(data, &t); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			signature, err := hex.DecodeString(t.Signature)
+			if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+				return nil, ErrBadSignature
+			}
+			if t.Statuses != summary.Statuses || t.CRLNumbers != len(numbers) {
+				return nil, fmt.Errorf("archive lists %d statuses and %d CRL numbers but holds %d and %d",
+					t.Statuses, t.CRLNumbers, summary.Statuses, len(numbers))
+			}
+			for _, table := range storage.ArchivedTables {
+				if t.Rows[table] != summary.Rows[table] {
+					return nil, fmt.Errorf("archive lists %d %s rows but holds %d", t.Rows[table], table, summary.Rows[table])
+				}
+			}
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			if len(numbers) > 0 {
+				if err := tx.InsertCRLNumbers(ctx, numbers); err != nil {
+					return nil, fmt.Errorf("failed to restore CRL numbers: %w", err)
+				}
+			}
+			summary.CRLNumbers = len(numbers)
+			for _, table := range storage.ArchivedTables {
+				if len(rows[table]) == 0 {
+					continue
+				}
+				if err := tx.InsertRows(ctx, table, rows[table]); err != nil {
+					return nil, fmt.Errorf("failed to restore %s: %w", table, err)
+				}
+			}
+			return summary, nil
+
+		default:
+			return nil, fmt.Errorf("line %d: unknown line type %q", n, l.Type)
+		}
+
+		mac.Write(data)
+	}
+}
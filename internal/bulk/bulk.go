@@ -0,0 +1,393 @@
+// Package bulk validates and applies uploaded status rows in batches
+package bulk
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/dryrun"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Upload formats
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// DefaultBatchSize is the number of valid rows applied per transaction
+const DefaultBatchSize = 1000
+
+// Sources of dead-lettered rows
+const (
+	SourceImport = "import"
+	SourceBatch  = "batch"
+	// SourceWriteBehind rows are queued writes the database refused when they were flushed
+	SourceWriteBehind = "write_behind"
+)
+
+// Row is one uploaded status, before normalization
+type Row struct {
+	Serial string `json:"serial"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	Date   string `json:"date"`
+}
+
+// Event is streamed back to the uploader as rows are processed
+type Event struct {
+	Type      string `json:"type"` // "error", "change", "progress", "done" or "aborted"
+	Line      int    `json:"line,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Processed int    `json:"processed"`
+	Applied   int    `json:"applied"`
+	Failed    int    `json:"failed"`
+	// DeadLettered counts failed rows kept for correction and replay
+	DeadLettered int `json:"dead_lettered,omitempty"`
+
+	// Dry runs count rows that would be applied as Applied, and report each row that would
+	// change a status or break revocation rules as a "change" event
+	DryRun     bool           `json:"dry_run,omitempty"`
+	Violations int            `json:"violations,omitempty"`
+	Change     *dryrun.Change `json:"change,omitempty"`
+}
+
+// Failure is a row that could not be applied, as submitted, with why
+type Failure struct {
+	// Line is the row's line in an upload, or its position in a gRPC batch
+	Line  int
+	Row   Row
+	Error string
+}
+
+// DeadLetters keeps rows that could not be applied so they can be corrected and replayed
+type DeadLetters interface {
+	Add(ctx context.Context, source string, failures []Failure) error
+}
+
+// Importer applies uploaded rows to the status store
+type Importer struct {
+	store       storage.Store
+	batchSize   int
+	deadLetters DeadLetters
+	logger      *logger.Logger
+}
+
+// NewImporter creates a bulk importer; a non-positive batchSize uses DefaultBatchSize
+func NewImporter(store storage.Store, batchSize int, logger *logger.Logger) *Importer {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &Importer{store: store, batchSize: batchSize, logger: logger}
+}
+
+// SetDeadLetters makes Import keep invalid rows and the rows of a failed batch in deadLetters.
+// An import that cannot keep them aborts rather than dropping them
+func (i *Importer) SetDeadLetters(deadLetters DeadLetters) {
+	i.deadLetters = deadLetters
+}
+
+// Import reads rows in the given format, reporting invalid rows and per-batch progress
+// through emit. Invalid rows are skipped; a failed batch aborts the import, leaving
+// earlier batches applied. Both are dead-lettered when dead letters are set
+func (i *Importer) Import(ctx context.Context, format string, r io.Reader, emit func(Event)) (Event, error) {
+	return i.run(ctx, format, r, emit, false)
+}
+
+// DryRun validates and normalizes rows like Import and compares them with stored statuses,
+// emitting a "change" event for every row that would create or change a status or violate
+// revocation rules, without writing anything
+func (i *Importer) DryRun(ctx context.Context, format string, r io.Reader, emit func(Event)) (Event, error) {
+	return i.run(ctx, format, r, emit, true)
+}
+
+func (i *Importer) run(ctx context.Context, format string, r io.Reader, emit func(Event), dryRun bool) (Event, error) {
+	next, err := rowReader(format, r)
+	if err != nil {
+		return Event{}, err
+	}
+
+	summary := Event{Type: "progress", DryRun: dryRun}
+	batch := make([]storage.Update, 0, i.batchSize)
+	// rows are the batch as submitted, and invalid the rows that failed validation since the
+	// last flush, both kept for dead-lettering
+	var rows, invalid []Failure
+	deadLetter := func(failures []Failure) error {
+		if i.deadLetters == nil || dryRun || len(failures) == 0 {
+			return nil
+		}
+		if err := i.deadLetters.Add(ctx, SourceImport, failures); err != nil {
+			return fmt.Errorf("failed to dead-letter %d rows ending at row %d: %w", len(failures), summary.Processed, err)
+		}
+		summary.DeadLettered += len(failures)
+		return nil
+	}
+	flush := func() error {
+		if err := deadLetter(invalid); err != nil {
+			return err
+		}
+		invalid = invalid[:0]
+		if len(batch) == 0 {
+			return nil
+		}
+		if dryRun {
+			if err := i.plan(ctx, batch, &summary, emit); err != nil {
+				return fmt.Errorf("failed to plan batch ending at row %d: %w", summary.Processed, err)
+			}
+		} else if err := i.store.ApplyBatch(ctx, batch); err != nil {
+			for n := range rows {
+				rows[n].Error = err.Error()
+			}
+			if dlErr := deadLetter(rows); dlErr != nil {
+				return errors.Join(fmt.Errorf("failed to apply batch ending at row %d: %w", summary.Processed, err), dlErr)
+			}
+			return fmt.Errorf("failed to apply batch ending at row %d: %w", summary.Processed, err)
+		}
+		summary.Applied += len(batch)
+		batch, rows = batch[:0], rows[:0]
+		emit(summary)
+		return nil
+	}
+
+	for {
+		line, row, err := next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *RowError
+		if err != nil && !errors.As(err, &rowErr) {
+			return summary, errors.Join(fmt.Errorf("failed to read upload after line %d: %w", line, err), deadLetter(invalid))
+		}
+		summary.Processed++
+		if err == nil {
+			var update storage.Update
+			if update, err = Normalize(row); err == nil {
+				batch = append(batch, update)
+				rows = append(rows, Failure{Line: line, Row: row})
+			}
+		}
+		if err != nil {
+			summary.Failed++
+			invalid = append(invalid, Failure{Line: line, Row: row, Error: err.Error()})
+			event := summary
+			event.Type, event.Line, event.Error = "error", line, err.Error()
+			emit(event)
+			if len(invalid) >= i.batchSize {
+				if err := deadLetter(invalid); err != nil {
+					return summary, err
+				}
+				invalid = invalid[:0]
+			}
+			continue
+		}
+
+		if len(batch) >= i.batchSize {
+			if err := flush(); err != nil {
+				return summary, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return summary, err
+	}
+
+	i.logger.Info("Bulk status import completed",
+		zap.Bool("dry_run", dryRun),
+		zap.Int("processed", summary.Processed),
+		zap.Int("applied", summary.Applied),
+		zap.Int("failed", summary.Failed),
+	)
+	summary.Type = "done"
+	return summary, nil
+}
+
+// plan emits the changes a batch would make, counting rule violations into the summary
+func (i *Importer) plan(ctx context.Context, batch []storage.Update, summary *Event, emit func(Event)) error {
+	report, err := dryrun.Plan(ctx, i.store, batch)
+	if err != nil {
+		return err
+	}
+	summary.Violations += report.Violations
+	for n := range report.Changes {
+		change := &report.Changes[n]
+		if change.Action == dryrun.ActionUnchanged && change.Violation == "" {
+			continue
+		}
+		event := *summary
+		event.Type, event.Change = "change", change
+		emit(event)
+	}
+	return nil
+}
+
+// Normalize validates a row and converts it to a status update
+func Normalize(row Row) (storage.Update, error) {
+	serial, err := NormalizeSerial(row.Serial)
+	if err != nil {
+		return storage.Update{}, err
+	}
+
+	status := strings.ToLower(strings.TrimSpace(row.Status))
+	if !storage.ValidStatus(status) {
+		return storage.Update{}, fmt.Errorf("invalid status %q", row.Status)
+	}
+	update := storage.Update{Serial: serial, Status: status}
+	if status != storage.StatusRevoked {
+		return update, nil
+	}
+
+	reason, ok := revocation.ParseReason(row.Reason)
+	if !ok {
+		return storage.Update{}, fmt.Errorf("invalid revocation reason %q", row.Reason)
+	}
+	update.RevocationReason = reason
+
+	if strings.TrimSpace(row.Date) != "" {
+		revokedAt, err := parseDate(row.Date)
+		if err != nil {
+			return storage.Update{}, err
+		}
+		update.RevokedAt = &revokedAt
+	}
+	return update, nil
+}
+
+// NormalizeSerial converts a hex serial, optionally 0x-prefixed or colon separated, to lowercase hex
+func NormalizeSerial(value string) (string, error) {
+	cleaned := strings.NewReplacer(":", "", " ", "").Replace(strings.TrimSpace(value))
+	cleaned = strings.TrimPrefix(strings.TrimPrefix(cleaned, "0x"), "0X")
+	n, ok := new(big.Int).SetString(cleaned, 16)
+	if !ok || n.Sign() <= 0 {
+		return "", fmt.Errorf("invalid serial %q", value)
+	}
+	return n.Text(16), nil
+}
+
+// parseDate accepts RFC 3339 timestamps, dates, and Unix seconds
+func parseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid revocation date %q", value)
+}
+
+// RowError is a malformed row; the import skips it and continues
+type RowError struct {
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// rowReader returns a function yielding each row with its line number, io.EOF at the end,
+// a *RowError for a malformed row, or any other error when the upload cannot be read further
+func rowReader(format string, r io.Reader) (func() (int, Row, error), error) {
+	switch format {
+	case FormatCSV:
+		return csvRows(r), nil
+	case FormatNDJSON:
+		return ndjsonRows(r), nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// csvRows reads serial,status,reason,date columns, in that order unless a header names them
+func csvRows(r io.Reader) func() (int, Row, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	columns := map[string]int{"serial": 0, "status": 1, "reason": 2, "date": 3}
+	first := true
+
+	return func() (int, Row, error) {
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return 0, Row{}, io.EOF
+			}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return parseErr.Line, Row{}, &RowError{Err: parseErr.Err}
+			}
+			if err != nil {
+				return 0, Row{}, err
+			}
+			line, _ := reader.FieldPos(0)
+
+			if first {
+				first = false
+				if len(record) > 0 && strings.EqualFold(strings.TrimSpace(record[0]), "serial") {
+					columns = make(map[string]int, len(record))
+					for i, name := range record {
+						columns[strings.ToLower(strings.TrimSpace(name))] = i
+					}
+					continue
+				}
+			}
+
+			field := func(name string) string {
+				if i, ok := columns[name]; ok && i < len(record) {
+					return record[i]
+				}
+				return ""
+			}
+			return line, Row{
+				Serial: field("serial"),
+				Status: field("status"),
+				Reason: field("reason"),
+				Date:   field("date"),
+			}, nil
+		}
+	}
+}
+
+// ndjsonRows reads one JSON Row object per line, skipping blank lines
+func ndjsonRows(r io.Reader) func() (int, Row, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+
+	return func() (int, Row, error) {
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			var row Row
+			if err := json.Unmarshal([]byte(text), &row); err != nil {
+				return line, Row{}, &RowError{Err: fmt.Errorf("invalid JSON: %w", err)}
+			}
+			return line, row, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return line, Row{}, err
+		}
+		return line, Row{}, io.EOF
+	}
+}
@@ -0,0 +1,169 @@
+// Package casync pre-creates good statuses for certificates issued by the gigvault CA service
+package casync
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/issuers"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/api/proto/ca"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// validStatus is the CA service's status for issued, unrevoked, unexpired certificates
+const validStatus = "valid"
+
+var (
+	seeded = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "ca_sync_seeded_total",
+		Help:      "Good statuses created for certificates listed by the CA service.",
+	})
+	lastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "ca_sync_last_success_timestamp_seconds",
+		Help:      "Completion time of the last full CA listing.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(seeded, lastSuccess)
+}
+
+// Syncer lists valid certificates from the CA service and seeds good rows for serials the
+// responder has never seen. Existing rows, in particular revocations, are never changed
+type Syncer struct {
+	client   ca.CAServiceClient
+	seeder   storage.Seeder
+	expiry   storage.ExpiryRecorder
+	issuers  *issuers.Registry
+	interval time.Duration
+	pageSize int32
+	logger   *logger.Logger
+}
+
+// NewSyncer creates a CA issuance syncer
+func NewSyncer(client ca.CAServiceClient, seeder storage.Seeder, interval time.Duration, pageSize int, logger *logger.Logger) *Syncer {
+	return &Syncer{
+		client:   client,
+		seeder:   seeder,
+		interval: interval,
+		pageSize: int32(pageSize),
+		logger:   logger,
+	}
+}
+
+// SetExpiryRecorder makes each sync also record when the listed certificates expire
+func (s *Syncer) SetExpiryRecorder(expiry storage.ExpiryRecorder) {
+	s.expiry = expiry
+}
+
+// SetIssuerRegistry makes each sync register the issuer of the first certificate of every
+// page, fetched from the CA service, so issuers the CA starts using are discovered
+func (s *Syncer) SetIssuerRegistry(registry *issuers.Registry) {
+	s.issuers = registry
+}
+
+// Run syncs immediately and then on every interval until the context is cancelled
+func (s *Syncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Sync(ctx); err != nil {
+			s.logger.Error("CA issuance sync failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync pages through every valid certificate once. The CA API has no issued-since filter,
+// so each run rescans; rows already present cost one conflicting insert each
+func (s *Syncer) Sync(ctx context.Context) error {
+	listed, inserted := 0, 0
+	token := ""
+	for {
+		resp, err := s.client.ListCertificates(ctx, &ca.ListCertificatesRequest{
+			Status:    validStatus,
+			PageSize:  s.pageSize,
+			PageToken: token,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list certificates: %w", err)
+		}
+
+		updates := make([]storage.Update, 0, len(resp.Certificates))
+		notAfter := make(map[string]time.Time)
+		for _, cert := range resp.Certificates {
+			serial, err := bulk.NormalizeSerial(cert.SerialNumber)
+			if err != nil {
+				s.logger.Warn("Skipping certificate with invalid serial", zap.String("serial", cert.SerialNumber))
+				continue
+			}
+			updates = append(updates, storage.Update{Serial: serial, Status: storage.StatusGood})
+			if cert.NotAfter != nil {
+				notAfter[serial] = cert.NotAfter.AsTime()
+			}
+		}
+
+		n, err := s.seeder.InsertMissing(ctx, updates)
+		if err != nil {
+			return fmt.Errorf("failed to seed statuses: %w", err)
+		}
+		if s.expiry != nil {
+			if err := s.expiry.RecordExpiry(ctx, notAfter); err != nil {
+				return fmt.Errorf("failed to record certificate expiry: %w", err)
+			}
+		}
+		if s.issuers != nil && len(resp.Certificates) > 0 {
+			s.registerIssuer(ctx, resp.Certificates[0].SerialNumber)
+		}
+		listed += len(resp.Certificates)
+		inserted += n
+		seeded.Add(float64(n))
+
+		token = resp.NextPageToken
+		if token == "" {
+			break
+		}
+	}
+
+	lastSuccess.SetToCurrentTime()
+	s.logger.Info("CA issuance sync completed", zap.Int("listed", listed), zap.Int("seeded", inserted))
+	return nil
+}
+
+// registerIssuer registers the issuer of a listed certificate. Failures are logged rather than
+// failing the sync, which seeds statuses whether or not the issuer is known
+func (s *Syncer) registerIssuer(ctx context.Context, serial string) {
+	resp, err := s.client.GetCertificate(ctx, &ca.GetCertificateRequest{SerialNumber: serial})
+	if err != nil {
+		s.logger.Warn("Failed to fetch certificate for issuer discovery", zap.String("serial", serial), zap.Error(err))
+		return
+	}
+	block, _ := pem.Decode([]byte(resp.CertificatePem))
+	if block == nil {
+		s.logger.Warn("CA service returned a certificate that is not PEM", zap.String("serial", serial))
+		return
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err == nil {
+		_, err = s.issuers.Register(ctx, leaf)
+	}
+	if err != nil {
+		s.logger.Warn("Failed to register issuer", zap.String("serial", serial), zap.Error(err))
+	}
+}
@@ -0,0 +1,105 @@
+// Package cdn purges edge-cached status and CRL URLs when the underlying data changes
+package cdn
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gigvault/ocsp/internal/events"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var purges = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "cdn_purges_total",
+	Help:      "CDN purge requests by provider and result.",
+}, []string{"provider", "result"})
+
+func init() {
+	metrics.Registry.MustRegister(purges)
+}
+
+// Purger invalidates cached copies of paths at a CDN
+type Purger interface {
+	// Name identifies the provider in logs and metrics
+	Name() string
+	Purge(ctx context.Context, paths []string) error
+	// PurgeAll invalidates everything the CDN caches for the responder
+	PurgeAll(ctx context.Context) error
+}
+
+// Distributor purges status URLs for changed serials and CRL URLs for new CRLs. It implements
+// events.Publisher so it can be registered as an event sink
+type Distributor struct {
+	purgers   []Purger
+	templates []string
+	maxPaths  int
+	logger    *logger.Logger
+}
+
+// NewDistributor creates a distributor; templates are status paths containing {serial}.
+// Batches touching more than maxPaths paths, such as bulk imports, purge everything instead
+func NewDistributor(purgers []Purger, templates []string, maxPaths int, logger *logger.Logger) *Distributor {
+	return &Distributor{purgers: purgers, templates: templates, maxPaths: maxPaths, logger: logger}
+}
+
+// Publish purges the status paths of every serial in events
+func (d *Distributor) Publish(ctx context.Context, events []events.Event) error {
+	if len(d.templates) == 0 {
+		return nil
+	}
+
+	paths := make([]string, 0, len(events)*len(d.templates))
+	seen := make(map[string]bool, len(events))
+	for _, event := range events {
+		if seen[event.Serial] {
+			continue
+		}
+		seen[event.Serial] = true
+		for _, template := range d.templates {
+			paths = append(paths, strings.ReplaceAll(template, "{serial}", event.Serial))
+		}
+	}
+	return d.Purge(ctx, paths...)
+}
+
+// Close is a no-op; purges are synchronous
+func (d *Distributor) Close() error {
+	return nil
+}
+
+// Purge invalidates paths at every configured CDN, attempting all of them before reporting failure
+func (d *Distributor) Purge(ctx context.Context, paths ...string) error {
+	purgeAll := d.maxPaths > 0 && len(paths) > d.maxPaths
+
+	var failed []string
+	for _, purger := range d.purgers {
+		var err error
+		if purgeAll {
+			err = purger.PurgeAll(ctx)
+		} else {
+			err = purger.Purge(ctx, paths)
+		}
+		if err != nil {
+			purges.WithLabelValues(purger.Name(), "error").Inc()
+			d.logger.Warn("CDN purge failed",
+				zap.String("provider", purger.Name()),
+				zap.Int("paths", len(paths)),
+				zap.Bool("purge_all", purgeAll),
+				zap.Error(err),
+			)
+			failed = append(failed, purger.Name())
+			continue
+		}
+		purges.WithLabelValues(purger.Name(), "success").Inc()
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("purge failed at %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/sigv4"
+)
+
+// cloudFrontAPI is global; CloudFront requests are always signed for us-east-1
+const cloudFrontAPI = "https://cloudfront.amazonaws.com/2020-05-31/distribution/"
+
+type invalidationBatch struct {
+	XMLName         xml.Name `xml:"http://cloudfront.amazonaws.com/doc/2020-05-31/ InvalidationBatch"`
+	Quantity        int      `xml:"Paths>Quantity"`
+	Items           []string `xml:"Paths>Items>Path"`
+	CallerReference string   `xml:"CallerReference"`
+}
+
+// CloudFront creates invalidations on a CloudFront distribution
+type CloudFront struct {
+	distributionID string
+	creds          sigv4.Credentials
+	client         *http.Client
+}
+
+// NewCloudFront creates a CloudFront purger
+func NewCloudFront(distributionID string, creds sigv4.Credentials) (*CloudFront, error) {
+	if distributionID == "" {
+		return nil, fmt.Errorf("cloudfront distribution ID is required")
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("cloudfront access key ID and secret access key are required")
+	}
+	return &CloudFront{
+		distributionID: distributionID,
+		creds:          creds,
+		client:         &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Name returns the provider name
+func (c *CloudFront) Name() string {
+	return "cloudfront"
+}
+
+// PurgeAll invalidates every path of the distribution
+func (c *CloudFront) PurgeAll(ctx context.Context) error {
+	return c.Purge(ctx, []string{"/*"})
+}
+
+// Purge creates one invalidation covering every path
+func (c *CloudFront) Purge(ctx context.Context, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	body, err := xml.Marshal(invalidationBatch{
+		Quantity:        len(paths),
+		Items:           paths,
+		CallerReference: strconv.FormatInt(time.Now().UnixNano(), 10),
+	})
+	if err != nil {
+		return err
+	}
+
+	url := cloudFrontAPI + sigv4.EscapePath(c.distributionID) + "/invalidation"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	sigv4.Sign(req, body, c.creds, "us-east-1", "cloudfront", time.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("invalidation returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package cdn
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const fastlyAPI = "https://api.fastly.com"
+
+// Fastly purges individual URLs through the Fastly API
+type Fastly struct {
+	token     string
+	host      string
+	serviceID string
+	soft      bool
+	client    *http.Client
+}
+
+// NewFastly creates a Fastly purger for URLs served from host; serviceID is only needed for
+// PurgeAll. Soft purges mark content stale instead of evicting it, so the edge can keep
+// serving it if the origin is down
+func NewFastly(token, host, serviceID string, soft bool) (*Fastly, error) {
+	if token == "" || host == "" {
+		return nil, fmt.Errorf("fastly API token and host are required")
+	}
+	return &Fastly{
+		token:     token,
+		host:      host,
+		serviceID: serviceID,
+		soft:      soft,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Name returns the provider name
+func (f *Fastly) Name() string {
+	return "fastly"
+}
+
+// Purge purges each path; Fastly has no batch URL purge
+func (f *Fastly) Purge(ctx context.Context, paths []string) error {
+	for _, path := range paths {
+		if err := f.purge(ctx, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeAll purges the whole service. Fastly does not soft purge everything
+func (f *Fastly) PurgeAll(ctx context.Context) error {
+	if f.serviceID == "" {
+		return fmt.Errorf("fastly service ID is required to purge everything")
+	}
+	return f.post(ctx, "/service/"+f.serviceID+"/purge_all", false)
+}
+
+func (f *Fastly) purge(ctx context.Context, path string) error {
+	return f.post(ctx, "/purge/"+f.host+path, f.soft)
+}
+
+func (f *Fastly) post(ctx context.Context, path string, soft bool) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fastlyAPI+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Fastly-Key", f.token)
+	req.Header.Set("Accept", "application/json")
+	if soft {
+		req.Header.Set("Fastly-Soft-Purge", "1")
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("purge of %s returned status %d: %s", path, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
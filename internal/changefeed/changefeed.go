@@ -0,0 +1,118 @@
+// Package changefeed passes the serials of committed status changes, notified by a trigger on
+// ocsp_responses, to subscribers on every replica
+package changefeed
+
+import (
+	"context"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Channel is the notification channel the migration's trigger sends serials on
+const Channel = "ocsp_status_changed"
+
+// Reconnects back off from minBackoff up to maxBackoff
+const (
+	minBackoff = 100 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+var (
+	notifications = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "change_notifications_total",
+		Help:      "Status change notifications received from the database.",
+	})
+	listening = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "change_notifications_listening",
+		Help:      "Status databases whose change notifications this replica is listening to.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(notifications, listening)
+}
+
+// Source is a status database that sends change notifications
+type Source interface {
+	Listen(ctx context.Context, channel string, listening func(), fn func(payload string)) error
+}
+
+// Subscriber reacts to status changes. Calls come from the feed's listening goroutines and
+// must not block
+type Subscriber interface {
+	// Changed is called with the serial of a committed change
+	Changed(serial string)
+	// Missed is called whenever listening (re)starts, since changes may have gone unnoticed
+	Missed()
+}
+
+// Feed passes the change notifications of its sources to subscribers
+type Feed struct {
+	sources     []Source
+	subscribers []Subscriber
+	logger      *logger.Logger
+}
+
+// New creates a feed listening to sources
+func New(sources []Source, logger *logger.Logger) *Feed {
+	return &Feed{sources: sources, logger: logger}
+}
+
+// Subscribe adds a subscriber; call it before Run
+func (f *Feed) Subscribe(subscriber Subscriber) {
+	f.subscribers = append(f.subscribers, subscriber)
+}
+
+// Run listens to every source until ctx is cancelled, reconnecting with backoff
+func (f *Feed) Run(ctx context.Context) {
+	done := make(chan struct{})
+	for _, source := range f.sources {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			f.listen(ctx, source)
+		}()
+	}
+	for range f.sources {
+		<-done
+	}
+}
+
+func (f *Feed) listen(ctx context.Context, source Source) {
+	backoff := minBackoff
+	for {
+		connected := false
+		err := source.Listen(ctx, Channel, func() {
+			connected = true
+			backoff = minBackoff
+			listening.Inc()
+			for _, subscriber := range f.subscribers {
+				subscriber.Missed()
+			}
+		}, func(serial string) {
+			notifications.Inc()
+			for _, subscriber := range f.subscribers {
+				subscriber.Changed(serial)
+			}
+		})
+		if connected {
+			listening.Dec()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		f.logger.Warn("Lost status change notifications, reconnecting", zap.Duration("backoff", backoff), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
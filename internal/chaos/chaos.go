@@ -0,0 +1,207 @@
+// Package chaos injects faults at configured rates, so the resilience paths (tryLater, load
+// shedding, retries of failed batches, signing failures) are exercised before production
+// needs them. Status store calls are delayed or fail, batch writes fail after applying part of
+// the batch, and signing fails. It must never be enabled in production
+package chaos
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrInjected is wrapped by every injected failure
+var ErrInjected = errors.New("injected fault")
+
+// Fault kinds, used in metric labels
+const (
+	faultDBLatency    = "db_latency"
+	faultDBError      = "db_error"
+	faultPartialBatch = "partial_batch"
+	faultSignerError  = "signer_error"
+)
+
+var injected = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "chaos_faults_total",
+	Help:      "Faults injected for resilience testing, by kind.",
+}, []string{"fault"})
+
+func init() {
+	metrics.Registry.MustRegister(injected)
+}
+
+// Options sets the probability, between 0 and 1, of each fault per call
+type Options struct {
+	// Seed makes the sequence of faults repeatable; 0 seeds from the clock
+	Seed int64
+	// DBLatency is added to a status store call at DBLatencyRate
+	DBLatency     time.Duration
+	DBLatencyRate float64
+	// DBErrorRate fails a status store call without calling the store
+	DBErrorRate float64
+	// PartialBatchRate fails a batch write after applying a random prefix of it
+	PartialBatchRate float64
+	// SignerErrorRate fails a signature
+	SignerErrorRate float64
+}
+
+// Injector decides which calls fail. It is safe for concurrent use
+type Injector struct {
+	opts Options
+
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// New creates an injector
+func New(opts Options) *Injector {
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Injector{opts: opts, random: rand.New(rand.NewSource(seed))}
+}
+
+// roll reports whether a fault with probability rate happens
+func (i *Injector) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.random.Float64() < rate
+}
+
+func (i *Injector) intn(n int) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.random.Intn(n)
+}
+
+// beforeDB delays a status store call and decides whether it fails
+func (i *Injector) beforeDB(ctx context.Context, op string) error {
+	if i.roll(i.opts.DBLatencyRate) {
+		injected.WithLabelValues(faultDBLatency).Inc()
+		timer := time.NewTimer(i.opts.DBLatency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+	if i.roll(i.opts.DBErrorRate) {
+		injected.WithLabelValues(faultDBError).Inc()
+		return fmt.Errorf("%s: %w", op, ErrInjected)
+	}
+	return nil
+}
+
+// Store injects faults into the calls to a status store
+type Store struct {
+	storage.Store
+	injector *Injector
+}
+
+// NewStore wraps store with the injector's faults
+func NewStore(store storage.Store, injector *Injector) *Store {
+	return &Store{Store: store, injector: injector}
+}
+
+// Get returns the status for a serial
+func (s *Store) Get(ctx context.Context, serial string) (*storage.Record, error) {
+	if err := s.injector.beforeDB(ctx, "get"); err != nil {
+		return nil, err
+	}
+	return s.Store.Get(ctx, serial)
+}
+
+// ListRevoked returns every revoked serial
+func (s *Store) ListRevoked(ctx context.Context) ([]storage.Record, error) {
+	if err := s.injector.beforeDB(ctx, "list revoked"); err != nil {
+		return nil, err
+	}
+	return s.Store.ListRevoked(ctx)
+}
+
+// Upsert inserts or replaces the status for a single serial
+func (s *Store) Upsert(ctx context.Context, update storage.Update) error {
+	if err := s.injector.beforeDB(ctx, "upsert"); err != nil {
+		return err
+	}
+	return s.Store.Upsert(ctx, update)
+}
+
+// ApplyBatch applies the updates, or at PartialBatchRate only a random prefix of them before
+// failing, as a batch split across transactions would. Callers retrying the whole batch must
+// cope with part of it having been applied
+func (s *Store) ApplyBatch(ctx context.Context, updates []storage.Update) error {
+	if err := s.injector.beforeDB(ctx, "apply batch"); err != nil {
+		return err
+	}
+	if len(updates) == 0 || !s.injector.roll(s.injector.opts.PartialBatchRate) {
+		return s.Store.ApplyBatch(ctx, updates)
+	}
+	injected.WithLabelValues(faultPartialBatch).Inc()
+	applied := s.injector.intn(len(updates))
+	if applied > 0 {
+		if err := s.Store.ApplyBatch(ctx, updates[:applied]); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("apply batch: failed after %d of %d updates: %w", applied, len(updates), ErrInjected)
+}
+
+// InsertMissing seeds statuses through the wrapped store, which must be a storage.Seeder
+func (s *Store) InsertMissing(ctx context.Context, updates []storage.Update) (int, error) {
+	seeder, ok := s.Store.(storage.Seeder)
+	if !ok {
+		return 0, fmt.Errorf("store does not support seeding")
+	}
+	if err := s.injector.beforeDB(ctx, "insert missing"); err != nil {
+		return 0, err
+	}
+	return seeder.InsertMissing(ctx, updates)
+}
+
+// ApplyIfNewer applies a replicated status through the wrapped store, which must be a
+// storage.Replica
+func (s *Store) ApplyIfNewer(ctx context.Context, rec storage.Record) (bool, error) {
+	replica, ok := s.Store.(storage.Replica)
+	if !ok {
+		return false, fmt.Errorf("store does not support replication")
+	}
+	if err := s.injector.beforeDB(ctx, "apply if newer"); err != nil {
+		return false, err
+	}
+	return replica.ApplyIfNewer(ctx, rec)
+}
+
+// signer fails signatures at SignerErrorRate
+type signer struct {
+	crypto.Signer
+	injector *Injector
+}
+
+// NewSigner wraps key with the injector's signing failures
+func NewSigner(key crypto.Signer, injector *Injector) crypto.Signer {
+	return &signer{Signer: key, injector: injector}
+}
+
+func (s *signer) Sign(random io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if s.injector.roll(s.injector.opts.SignerErrorRate) {
+		injected.WithLabelValues(faultSignerError).Inc()
+		return nil, fmt.Errorf("sign: %w", ErrInjected)
+	}
+	return s.Signer.Sign(random, digest, opts)
+}
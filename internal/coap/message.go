@@ -0,0 +1,253 @@
+package coap
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// Message types
+const (
+	typeCON = 0
+	typeNON = 1
+	typeACK = 2
+	typeRST = 3
+)
+
+// code builds a code from its class and detail, written c.dd
+func code(class, detail uint8) uint8 {
+	return class<<5 | detail
+}
+
+// Codes used by the server
+var (
+	codeEmpty              = code(0, 0)
+	codeGET                = code(0, 1)
+	codePOST               = code(0, 2)
+	codeContent            = code(2, 5)
+	codeBadRequest         = code(4, 0)
+	codeBadOption          = code(4, 2)
+	codeForbidden          = code(4, 3)
+	codeNotFound           = code(4, 4)
+	codeMethodNotAllowed   = code(4, 5)
+	codeEntityTooLarge     = code(4, 13)
+	codeTooManyRequests    = code(4, 29)
+	codeInternalError      = code(5, 0)
+	codeServiceUnavailable = code(5, 3)
+)
+
+// codeName formats a code as c.dd
+func codeName(c uint8) string {
+	detail := c & 0x1f
+	return string([]byte{'0' + c>>5, '.', '0' + detail/10, '0' + detail%10})
+}
+
+// Option numbers
+const (
+	optionURIHost  = 3
+	optionETag     = 4
+	optionURIPort  = 7
+	optionURIPath  = 11
+	optionMaxAge   = 14
+	optionURIQuery = 15
+	optionAccept   = 17
+	optionBlock2   = 23
+	optionBlock1   = 27
+	optionSize2    = 28
+	optionSize1    = 60
+)
+
+// understood are the critical options the server processes; a request with any other
+// critical option, one with an odd number, is rejected with 4.02
+var understood = map[uint16]bool{
+	optionURIHost:  true,
+	optionURIPort:  true,
+	optionURIPath:  true,
+	optionURIQuery: true,
+	optionAccept:   true,
+	optionBlock2:   true,
+	optionBlock1:   true,
+}
+
+var errFormat = errors.New("malformed CoAP message")
+
+type option struct {
+	number uint16
+	value  []byte
+}
+
+// message is a CoAP message as carried in one UDP datagram (RFC 7252 section 3)
+type message struct {
+	typ       uint8
+	code      uint8
+	messageID uint16
+	token     []byte
+	options   []option
+	payload   []byte
+}
+
+// parse decodes a datagram. The token, option values and payload alias data
+func parse(data []byte) (*message, error) {
+	if len(data) < 4 || data[0]>>6 != 1 {
+		return nil, errFormat
+	}
+	tokenLength := int(data[0] & 0x0f)
+	if tokenLength > 8 || len(data) < 4+tokenLength {
+		return nil, errFormat
+	}
+	m := &message{
+		typ:       data[0] >> 4 & 0x03,
+		code:      data[1],
+		messageID: binary.BigEndian.Uint16(data[2:4]),
+		token:     data[4 : 4+tokenLength],
+	}
+	rest := data[4+tokenLength:]
+	number := 0
+	for len(rest) > 0 {
+		if rest[0] == 0xff {
+			if len(rest) == 1 {
+				return nil, errFormat
+			}
+			m.payload = rest[1:]
+			break
+		}
+		delta, length := int(rest[0]>>4), int(rest[0]&0x0f)
+		rest = rest[1:]
+		var ok bool
+		if delta, rest, ok = extended(delta, rest); !ok {
+			return nil, errFormat
+		}
+		if length, rest, ok = extended(length, rest); !ok {
+			return nil, errFormat
+		}
+		number += delta
+		if number > 0xffff || len(rest) < length {
+			return nil, errFormat
+		}
+		m.options = append(m.options, option{number: uint16(number), value: rest[:length]})
+		rest = rest[length:]
+	}
+	if m.code == codeEmpty && (len(m.token) > 0 || len(m.options) > 0 || m.payload != nil) {
+		return nil, errFormat
+	}
+	return m, nil
+}
+
+// extended reads the extended form of an option delta or length
+func extended(v int, rest []byte) (int, []byte, bool) {
+	switch v {
+	case 13:
+		if len(rest) < 1 {
+			return 0, nil, false
+		}
+		return int(rest[0]) + 13, rest[1:], true
+	case 14:
+		if len(rest) < 2 {
+			return 0, nil, false
+		}
+		return int(binary.BigEndian.Uint16(rest)) + 269, rest[2:], true
+	case 15:
+		return 0, nil, false
+	}
+	return v, rest, true
+}
+
+// marshal encodes the message, with its options in number order
+func (m *message) marshal() []byte {
+	sort.SliceStable(m.options, func(i, j int) bool { return m.options[i].number < m.options[j].number })
+	out := make([]byte, 0, 4+len(m.token)+8*len(m.options)+1+len(m.payload))
+	out = append(out, 1<<6|m.typ<<4|uint8(len(m.token)), m.code, byte(m.messageID>>8), byte(m.messageID))
+	out = append(out, m.token...)
+	previous := 0
+	for _, opt := range m.options {
+		delta, length := int(opt.number)-previous, len(opt.value)
+		deltaNibble, deltaExt := nibble(delta)
+		lengthNibble, lengthExt := nibble(length)
+		out = append(out, deltaNibble<<4|lengthNibble)
+		out = append(out, deltaExt...)
+		out = append(out, lengthExt...)
+		out = append(out, opt.value...)
+		previous = int(opt.number)
+	}
+	if len(m.payload) > 0 {
+		out = append(out, 0xff)
+		out = append(out, m.payload...)
+	}
+	return out
+}
+
+// nibble returns the 4-bit field and extended bytes encoding an option delta or length
+func nibble(v int) (uint8, []byte) {
+	switch {
+	case v < 13:
+		return uint8(v), nil
+	case v < 269:
+		return 13, []byte{byte(v - 13)}
+	default:
+		return 14, []byte{byte((v - 269) >> 8), byte(v - 269)}
+	}
+}
+
+// option returns the first value of an option, and whether it is present
+func (m *message) option(number uint16) ([]byte, bool) {
+	for _, opt := range m.options {
+		if opt.number == number {
+			return opt.value, true
+		}
+	}
+	return nil, false
+}
+
+func (m *message) addUint(number uint16, v uint32) {
+	m.options = append(m.options, option{number: number, value: encodeUint(v)})
+}
+
+// encodeUint encodes an unsigned option value in as few bytes as possible, none for zero
+func encodeUint(v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	i := 0
+	for i < 4 && buf[i] == 0 {
+		i++
+	}
+	return buf[i:]
+}
+
+func decodeUint(value []byte) (uint32, bool) {
+	if len(value) > 4 {
+		return 0, false
+	}
+	var v uint32
+	for _, b := range value {
+		v = v<<8 | uint32(b)
+	}
+	return v, true
+}
+
+// block is a Block1 or Block2 option value (RFC 7959 section 2.2)
+type block struct {
+	num  uint32
+	more bool
+	size int
+}
+
+// parseBlock decodes a block option. The size exponent 7, reserved for BERT, is refused
+func parseBlock(value []byte) (block, bool) {
+	v, ok := decodeUint(value)
+	if !ok || len(value) > 3 || v&0x07 == 7 {
+		return block{}, false
+	}
+	return block{num: v >> 4, more: v&0x08 != 0, size: 1 << (v&0x07 + 4)}, true
+}
+
+func (b block) encode() uint32 {
+	szx := uint32(0)
+	for 1<<(szx+4) < b.size {
+		szx++
+	}
+	v := b.num<<4 | szx
+	if b.more {
+		v |= 0x08
+	}
+	return v
+}
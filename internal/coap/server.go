@@ -0,0 +1,396 @@
+// Package coap answers RFC 6960 requests over CoAP (RFC 7252) through the responder's HTTP handler
+package coap
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "coap_requests_total",
+	Help:      "CoAP requests by response code, such as 2.05 or 4.04.",
+}, []string{"code"})
+
+func init() {
+	metrics.Registry.MustRegister(requests)
+}
+
+// maxDatagram bounds the datagrams read; a request must fit one, as Block1 is not supported
+const maxDatagram = 64 << 10
+
+// transferLifetime is how long a response sent block-wise is kept for the following blocks.
+// A client asking for a block after it lapsed gets a new response with a different ETag
+const transferLifetime = time.Minute
+
+// maxTransfers bounds the responses kept for block-wise transfers
+const maxTransfers = 4096
+
+// Server answers CoAP GET and POST requests at or below a path. It is stateless apart from
+// responses in the middle of a block-wise transfer: OCSP lookups are idempotent, so a
+// retransmitted confirmable request is answered again rather than from a record of message IDs,
+// as RFC 7252 section 4.5 allows
+type Server struct {
+	handler   http.Handler
+	path      string
+	blockSize int
+	timeout   time.Duration
+	slots     chan struct{}
+	logger    *logger.Logger
+
+	nextID atomic.Uint32
+	conn   net.PacketConn
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	transfers map[string]*transfer
+}
+
+// transfer is a response being sent block-wise
+type transfer struct {
+	code    uint8
+	body    []byte
+	etag    []byte
+	maxAge  time.Time
+	expires time.Time
+}
+
+// NewServer creates a server passing requests below path to handler. Responses are split into
+// blocks of blockSize bytes, a power of two from 16 to 1024, or smaller blocks when the client
+// asks for them. At most maxInFlight requests are answered at once, each within timeout
+func NewServer(handler http.Handler, path string, blockSize, maxInFlight int, timeout time.Duration, logger *logger.Logger) *Server {
+	s := &Server{
+		handler:   handler,
+		path:      strings.TrimSuffix(path, "/"),
+		blockSize: blockSize,
+		timeout:   timeout,
+		slots:     make(chan struct{}, maxInFlight),
+		logger:    logger,
+		transfers: make(map[string]*transfer),
+	}
+	s.nextID.Store(rand.Uint32())
+	return s
+}
+
+// Serve answers the datagrams arriving on conn until Close
+func (s *Server) Serve(conn net.PacketConn) error {
+	s.conn = conn
+	for {
+		buf := make([]byte, maxDatagram)
+		n, peer, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return err
+		}
+		req, err := parse(buf[:n])
+		if err != nil {
+			s.reject(buf[:n], peer)
+			continue
+		}
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			s.busy(req, peer)
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer func() {
+				<-s.slots
+				s.wg.Done()
+			}()
+			s.handle(req, peer)
+		}()
+	}
+}
+
+// Close stops reading requests and waits for those being answered
+func (s *Server) Close() error {
+	err := s.conn.Close()
+	s.wg.Wait()
+	return err
+}
+
+// reject answers a confirmable message that cannot be parsed with a reset, as RFC 7252
+// section 4.2 requires; anything else is dropped
+func (s *Server) reject(data []byte, peer net.Addr) {
+	if len(data) >= 4 && data[0]>>6 == 1 && data[0]>>4&0x03 == typeCON {
+		s.send(&message{typ: typeRST, messageID: uint16(data[2])<<8 | uint16(data[3])}, peer)
+	}
+}
+
+// busy answers a request arriving while every slot is taken with 5.03, asking the client to
+// retry after a second
+func (s *Server) busy(req *message, peer net.Addr) {
+	if req.code>>5 != 0 || req.code == codeEmpty || req.typ == typeACK || req.typ == typeRST {
+		return
+	}
+	resp := &message{code: codeServiceUnavailable}
+	resp.addUint(optionMaxAge, 1)
+	s.reply(req, resp, peer)
+}
+
+func (s *Server) handle(req *message, peer net.Addr) {
+	switch {
+	case req.typ == typeACK || req.typ == typeRST:
+		// Responses are piggybacked or non-confirmable, so nothing awaits an acknowledgement
+		return
+	case req.code == codeEmpty || req.code>>5 != 0:
+		// An empty confirmable message is a ping; a response sent to a server is rejected
+		if req.typ == typeCON {
+			s.send(&message{typ: typeRST, messageID: req.messageID}, peer)
+		}
+		return
+	}
+	s.reply(req, s.respond(req, peer), peer)
+}
+
+// reply sends resp to the request: piggybacked in the acknowledgement of a confirmable
+// request, or as a non-confirmable message
+func (s *Server) reply(req, resp *message, peer net.Addr) {
+	resp.token = req.token
+	if req.typ == typeCON {
+		resp.typ, resp.messageID = typeACK, req.messageID
+	} else {
+		resp.typ, resp.messageID = typeNON, uint16(s.nextID.Add(1))
+	}
+	requests.WithLabelValues(codeName(resp.code)).Inc()
+	s.send(resp, peer)
+}
+
+func (s *Server) send(m *message, peer net.Addr) {
+	if _, err := s.conn.WriteTo(m.marshal(), peer); err != nil && !errors.Is(err, net.ErrClosed) {
+		s.logger.Warn("Failed to send CoAP message", zap.String("peer", peer.String()), zap.Error(err))
+	}
+}
+
+// respond answers a request, sending the response block-wise when it exceeds a block
+func (s *Server) respond(req *message, peer net.Addr) *message {
+	for _, opt := range req.options {
+		if opt.number%2 == 1 && !understood[opt.number] {
+			return &message{code: codeBadOption, payload: []byte("unsupported critical option " + strconv.Itoa(int(opt.number)))}
+		}
+	}
+	if req.code != codeGET && req.code != codePOST {
+		return &message{code: codeMethodNotAllowed}
+	}
+	var segments []string
+	for _, opt := range req.options {
+		if opt.number == optionURIPath {
+			segments = append(segments, string(opt.value))
+		}
+	}
+	path := "/" + strings.Join(segments, "/")
+	if path != s.path && !strings.HasPrefix(path, s.path+"/") {
+		return &message{code: codeNotFound}
+	}
+	if value, ok := req.option(optionBlock1); ok {
+		if b, ok := parseBlock(value); !ok || b.num > 0 || b.more {
+			resp := &message{code: codeEntityTooLarge, payload: []byte("requests must fit in one datagram")}
+			resp.addUint(optionSize1, uint32(maxDatagram))
+			return resp
+		}
+	}
+
+	size := s.blockSize
+	var num uint32
+	value, blockwise := req.option(optionBlock2)
+	if blockwise {
+		b, ok := parseBlock(value)
+		if !ok {
+			return &message{code: codeBadOption, payload: []byte("invalid Block2 option")}
+		}
+		num = b.num
+		if b.size < size {
+			size = b.size
+		}
+	}
+
+	key := peer.String() + "\x00" + codeName(req.code) + path + "\x00" + string(req.payload)
+	t := s.transfer(key, num)
+	if t == nil {
+		t = s.serve(req, path, peer)
+	}
+	if len(t.body) <= size && num == 0 {
+		resp := &message{code: t.code, payload: t.body}
+		s.addMaxAge(resp, t)
+		if blockwise {
+			resp.addUint(optionBlock2, block{size: size}.encode())
+		}
+		return resp
+	}
+
+	start := int(num) * size
+	if start >= len(t.body) {
+		return &message{code: codeBadOption, payload: []byte("Block2 past the end of the response")}
+	}
+	end := min(start+size, len(t.body))
+	resp := &message{code: t.code, payload: t.body[start:end]}
+	resp.options = append(resp.options, option{number: optionETag, value: t.etag})
+	s.addMaxAge(resp, t)
+	resp.addUint(optionBlock2, block{num: num, more: end < len(t.body), size: size}.encode())
+	if num == 0 {
+		resp.addUint(optionSize2, uint32(len(t.body)))
+	}
+	if end < len(t.body) {
+		s.keep(key, t)
+	}
+	return resp
+}
+
+// addMaxAge sets Max-Age to the time left until the response goes stale, zero for responses
+// that must not be cached
+func (s *Server) addMaxAge(resp *message, t *transfer) {
+	maxAge := int64(0)
+	if left := time.Until(t.maxAge); left > 0 {
+		maxAge = int64(left / time.Second)
+	}
+	resp.addUint(optionMaxAge, uint32(maxAge))
+}
+
+// transfer returns the response kept for a later block of a block-wise transfer
+func (s *Server) transfer(key string, num uint32) *transfer {
+	if num == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.transfers[key]
+	if t == nil || time.Now().After(t.expires) {
+		return nil
+	}
+	return t
+}
+
+// keep holds a response for the following blocks, unless too many transfers are under way
+func (s *Server) keep(key string, t *transfer) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.transfers[key]; !ok && len(s.transfers) >= maxTransfers {
+		for k, kept := range s.transfers {
+			if now.After(kept.expires) {
+				delete(s.transfers, k)
+			}
+		}
+		if len(s.transfers) >= maxTransfers {
+			return
+		}
+	}
+	s.transfers[key] = t
+}
+
+// serve passes the request to the HTTP handler: GET with the path as it arrived, POST with the
+// payload as the body
+func (s *Server) serve(req *message, path string, peer net.Addr) *transfer {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	method := http.MethodGet
+	var body []byte
+	if req.code == codePOST {
+		method, body = http.MethodPost, req.payload
+	}
+	target := &url.URL{Scheme: "http", Host: "coap", Path: path}
+	httpReq, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return &transfer{code: codeBadRequest}
+	}
+	httpReq.RemoteAddr = peer.String()
+	if method == http.MethodPost {
+		httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	}
+
+	w := &recorder{header: http.Header{}, status: http.StatusOK}
+	s.handler.ServeHTTP(w, httpReq)
+
+	now := time.Now()
+	t := &transfer{code: statusCode(w.status), body: w.body.Bytes(), expires: now.Add(transferLifetime)}
+	if w.status == http.StatusOK {
+		t.maxAge = now.Add(maxAge(w.header.Get("Cache-Control")))
+	}
+	sum := sha256.Sum256(t.body)
+	t.etag = sum[:8]
+	return t
+}
+
+// maxAge returns the max-age directive of a Cache-Control header
+func maxAge(header string) time.Duration {
+	for _, directive := range strings.Split(header, ",") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(directive), "max-age="); ok {
+			if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return 0
+}
+
+// statusCode maps the HTTP status of the handler's response to a CoAP response code
+func statusCode(status int) uint8 {
+	switch status {
+	case http.StatusOK:
+		return codeContent
+	case http.StatusForbidden:
+		return codeForbidden
+	case http.StatusNotFound:
+		return codeNotFound
+	case http.StatusMethodNotAllowed:
+		return codeMethodNotAllowed
+	case http.StatusRequestEntityTooLarge:
+		return codeEntityTooLarge
+	case http.StatusTooManyRequests:
+		return codeTooManyRequests
+	case http.StatusServiceUnavailable:
+		return codeServiceUnavailable
+	}
+	if status >= 500 {
+		return codeInternalError
+	}
+	return codeBadRequest
+}
+
+// recorder collects the handler's response
+type recorder struct {
+	header http.Header
+	status int
+	wrote  bool
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wrote {
+		r.status, r.wrote = status, true
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.wrote = true
+	return r.body.Write(p)
+}
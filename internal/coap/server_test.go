@@ -0,0 +1,262 @@
+package coap
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := &message{
+		typ:       typeCON,
+		code:      codePOST,
+		messageID: 0x1234,
+		token:     []byte{1, 2, 3},
+		options: []option{
+			{number: optionURIPath, value: []byte("ocsp")},
+			{number: optionBlock2, value: encodeUint(block{num: 2, size: 64}.encode())},
+			// Extended delta and length forms
+			{number: 2048, value: bytes.Repeat([]byte{'x'}, 300)},
+			{number: optionSize1, value: bytes.Repeat([]byte{'y'}, 20)},
+		},
+		payload: []byte("request"),
+	}
+	got, err := parse(m.marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.typ != m.typ || got.code != m.code || got.messageID != m.messageID || !bytes.Equal(got.token, m.token) || !bytes.Equal(got.payload, m.payload) {
+		t.Errorf("parse(marshal()) = %+v", got)
+	}
+	if len(got.options) != 4 {
+		t.Fatalf("options = %d, want 4", len(got.options))
+	}
+	for i, opt := range m.options {
+		if got.options[i].number != opt.number || !bytes.Equal(got.options[i].value, opt.value) {
+			t.Errorf("option %d = %d %q", i, got.options[i].number, got.options[i].value)
+		}
+	}
+	value, _ := got.option(optionBlock2)
+	if b, ok := parseBlock(value); !ok || b.num != 2 || b.more || b.size != 64 {
+		t.Errorf("Block2 = %+v, %v", b, ok)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"short":             {0x40, 0x01},
+		"version":           {0x80, 0x01, 0, 1},
+		"token too long":    {0x49, 0x01, 0, 1},
+		"token truncated":   {0x44, 0x01, 0, 1, 1, 2},
+		"empty payload":     {0x40, 0x01, 0, 1, 0xff},
+		"reserved delta":    {0x40, 0x01, 0, 1, 0xf0},
+		"value truncated":   {0x40, 0x01, 0, 1, 0xb4, 'o'},
+		"empty with option": {0x40, 0x00, 0, 1, 0xb1, 'o'},
+	} {
+		if _, err := parse(data); err != errFormat {
+			t.Errorf("%s: parse() error = %v, want errFormat", name, err)
+		}
+	}
+}
+
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	id   uint16
+}
+
+// newTestServer serves handler at /ocsp over a loopback socket, in blocks of blockSize
+func newTestServer(t *testing.T, handler http.Handler, blockSize int) *testClient {
+	t.Helper()
+	packets, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(handler, "/ocsp", blockSize, 4, time.Second, &logger.Logger{Logger: zap.NewNop()})
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(packets) }()
+	t.Cleanup(func() {
+		server.Close()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+
+	conn, err := net.Dial("udp", packets.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn}
+}
+
+// exchange sends a datagram and returns the message received in answer
+func (c *testClient) exchange(data []byte) *message {
+	c.t.Helper()
+	if _, err := c.conn.Write(data); err != nil {
+		c.t.Fatal(err)
+	}
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, maxDatagram)
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	m, err := parse(buf[:n])
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return m
+}
+
+// request sends a confirmable request and checks it was acknowledged with its token
+func (c *testClient) request(req *message) *message {
+	c.t.Helper()
+	c.id++
+	req.typ, req.messageID, req.token = typeCON, c.id, []byte{0xbe, 0xef}
+	resp := c.exchange(req.marshal())
+	if resp.typ != typeACK || resp.messageID != c.id || !bytes.Equal(resp.token, req.token) {
+		c.t.Fatalf("response type %d, ID %d, token %x does not acknowledge request %d", resp.typ, resp.messageID, resp.token, c.id)
+	}
+	return resp
+}
+
+func uintOption(t *testing.T, m *message, number uint16) uint32 {
+	t.Helper()
+	value, ok := m.option(number)
+	if !ok {
+		t.Fatalf("option %d missing", number)
+	}
+	v, _ := decodeUint(value)
+	return v
+}
+
+// seen is what the handler received, passed over a channel as the socket hides the ordering
+// from the race detector
+type seen struct {
+	method, path, contentType, body string
+}
+
+func TestServerForwardsRequests(t *testing.T) {
+	requests := make(chan seen, 1)
+	client := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- seen{r.Method, r.URL.Path, r.Header.Get("Content-Type"), string(body)}
+		w.Header().Set("Cache-Control", "max-age=300, public")
+		w.Write([]byte("response"))
+	}), 1024)
+
+	resp := client.request(&message{code: codePOST, options: []option{{number: optionURIPath, value: []byte("ocsp")}}, payload: []byte("request")})
+	if resp.code != codeContent || string(resp.payload) != "response" {
+		t.Errorf("POST answered %s %q", codeName(resp.code), resp.payload)
+	}
+	if age := uintOption(t, resp, optionMaxAge); age < 299 || age > 300 {
+		t.Errorf("Max-Age = %d, want 300", age)
+	}
+	if got := <-requests; got != (seen{http.MethodPost, "/ocsp", "application/ocsp-request", "request"}) {
+		t.Errorf("handler saw %+v", got)
+	}
+
+	resp = client.request(&message{code: codeGET, options: []option{
+		{number: optionURIPath, value: []byte("ocsp")},
+		{number: optionURIPath, value: []byte("MEMwQTA/")},
+	}})
+	if got := <-requests; resp.code != codeContent || got.method != http.MethodGet || got.path != "/ocsp/MEMwQTA/" {
+		t.Errorf("GET answered %s after the handler saw %+v", codeName(resp.code), got)
+	}
+}
+
+func TestServerBlockwise(t *testing.T) {
+	response := bytes.Repeat([]byte("0123456789"), 10)
+	var calls atomic.Int32
+	client := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Write(response)
+	}), 32)
+
+	var received, etag []byte
+	for num := uint32(0); ; num++ {
+		req := &message{code: codePOST, options: []option{{number: optionURIPath, value: []byte("ocsp")}}, payload: []byte("request")}
+		if num > 0 {
+			req.addUint(optionBlock2, block{num: num, size: 32}.encode())
+		}
+		resp := client.request(req)
+		if resp.code != codeContent {
+			t.Fatalf("block %d answered %s", num, codeName(resp.code))
+		}
+		if num == 0 {
+			etag, _ = resp.option(optionETag)
+			if size := uintOption(t, resp, optionSize2); size != uint32(len(response)) {
+				t.Errorf("Size2 = %d, want %d", size, len(response))
+			}
+		} else if tag, _ := resp.option(optionETag); !bytes.Equal(tag, etag) {
+			t.Errorf("block %d ETag %x, want %x", num, tag, etag)
+		}
+		value, _ := resp.option(optionBlock2)
+		b, ok := parseBlock(value)
+		if !ok || b.num != num || b.size != 32 {
+			t.Fatalf("block %d Block2 = %+v, %v", num, b, ok)
+		}
+		received = append(received, resp.payload...)
+		if !b.more {
+			break
+		}
+	}
+	if !bytes.Equal(received, response) {
+		t.Errorf("reassembled %q, want %q", received, response)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("handler called %d times for one block-wise transfer", n)
+	}
+
+	// A client may ask for smaller blocks than the server's
+	req := &message{code: codeGET, options: []option{{number: optionURIPath, value: []byte("ocsp")}}}
+	req.addUint(optionBlock2, block{size: 16}.encode())
+	resp := client.request(req)
+	value, _ := resp.option(optionBlock2)
+	if b, _ := parseBlock(value); len(resp.payload) != 16 || !b.more || b.size != 16 {
+		t.Errorf("first 16-byte block = %d bytes, %+v", len(resp.payload), b)
+	}
+}
+
+func TestServerRejects(t *testing.T) {
+	client := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("response"))
+	}), 1024)
+	path := option{number: optionURIPath, value: []byte("ocsp")}
+	block1 := option{number: optionBlock1, value: encodeUint(block{more: true, size: 1024}.encode())}
+
+	for name, tc := range map[string]struct {
+		req  *message
+		code uint8
+	}{
+		"critical option": {&message{code: codeGET, options: []option{path, {number: 9, value: []byte{1}}}}, codeBadOption},
+		"other path":      {&message{code: codeGET, options: []option{{number: optionURIPath, value: []byte("crl")}}}, codeNotFound},
+		"method":          {&message{code: code(0, 3), options: []option{path}}, codeMethodNotAllowed},
+		"block1":          {&message{code: codePOST, options: []option{path, block1}, payload: []byte("part")}, codeEntityTooLarge},
+		"block2 past end": {&message{code: codeGET, options: []option{path, {number: optionBlock2, value: encodeUint(block{num: 5, size: 16}.encode())}}}, codeBadOption},
+		"invalid block2":  {&message{code: codeGET, options: []option{path, {number: optionBlock2, value: []byte{0x07}}}}, codeBadOption},
+	} {
+		if resp := client.request(tc.req); resp.code != tc.code {
+			t.Errorf("%s: answered %s, want %s", name, codeName(resp.code), codeName(tc.code))
+		}
+	}
+
+	// A confirmable ping and a malformed confirmable message are both reset
+	for name, data := range map[string][]byte{
+		"ping":      (&message{typ: typeCON, code: codeEmpty, messageID: 77}).marshal(),
+		"malformed": {0x40, 0x01, 0, 78, 0xf0},
+	} {
+		resp := client.exchange(data)
+		if resp.typ != typeRST || resp.messageID != uint16(data[2])<<8|uint16(data[3]) {
+			t.Errorf("%s: answered type %d, ID %d, want a reset", name, resp.typ, resp.messageID)
+		}
+	}
+}
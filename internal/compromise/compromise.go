@@ -0,0 +1,302 @@
+// Package compromise carries out the response to a compromised CRL signing key in one step:
+// the key is recorded as compromised, its certificate is revoked, CRLs are re-signed with a
+// standby key and alerts are raised. Every replica watches the record and switches too
+package compromise
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/anomaly"
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/ocsp/internal/crl"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// KindKeyCompromise is the alert kind raised when a signing key is switched out
+const KindKeyCompromise anomaly.Kind = "key_compromise"
+
+var (
+	// ErrIssuerMismatch is returned when the named issuer is not the one CRLs are signed for
+	ErrIssuerMismatch = errors.New("issuer does not match the active signing certificate")
+	// ErrNoStandby is returned when there is no uncompromised standby key to switch to
+	ErrNoStandby = errors.New("no uncompromised standby key is configured")
+	// ErrCompromised is returned when a key recorded as compromised is loaded for signing
+	ErrCompromised = errors.New("key is recorded as compromised")
+)
+
+var switches = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "compromise_key_switches_total",
+	Help:      "Switches to the standby signing key, by whether this replica ran the response or followed another.",
+}, []string{"source"})
+
+func init() {
+	metrics.Registry.MustRegister(switches)
+}
+
+// Key is a signing key and the certificate for its public key
+type Key struct {
+	Certificate *x509.Certificate
+	Signer      crypto.Signer
+}
+
+// LoadKey reads a PEM certificate and the PEM private key for it
+func LoadKey(certPath, keyPath string) (*Key, error) {
+	cert, err := crl.LoadCertificate(certPath)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := crl.LoadSigner(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return nil, fmt.Errorf("key at %s does not match certificate %s", keyPath, certPath)
+	}
+	return &Key{Certificate: cert, Signer: signer}, nil
+}
+
+// Hash identifies the key: the hex SHA-256 of the certificate's subject public key info
+func (k *Key) Hash() string {
+	sum := sha256.Sum256(k.Certificate.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+// SwitchFunc starts signing with key; it re-signs everything signed with the previous key
+type SwitchFunc func(ctx context.Context, key *Key) error
+
+// Result reports a completed compromise response
+type Result struct {
+	CompromisedKey string    `json:"compromised_key"`
+	ActiveKey      string    `json:"active_key"`
+	RevokedSerial  string    `json:"revoked_serial,omitempty"`
+	Actor          string    `json:"actor"`
+	At             time.Time `json:"at"`
+}
+
+// Status reports the active key and the recorded compromises
+type Status struct {
+	ActiveKey   string   `json:"active_key"`
+	StandbyKey  string   `json:"standby_key,omitempty"`
+	Compromised []Record `json:"compromised"`
+}
+
+// Responder holds the active and standby signing keys and runs the compromise response
+type Responder struct {
+	db     *Postgres
+	store  storage.Store
+	apply  SwitchFunc
+	hooks  []anomaly.Hook
+	logger *logger.Logger
+
+	mu      sync.Mutex
+	active  *Key
+	standby *Key
+}
+
+// NewResponder creates a responder signing with active and holding standby in reserve.
+// Revocations are written to store, and apply switches the signers over
+func NewResponder(db *Postgres, store storage.Store, active, standby *Key, apply SwitchFunc, hooks []anomaly.Hook, logger *logger.Logger) (*Responder, error) {
+	if standby != nil && !sameSubject(active, standby) {
+		return nil, errors.New("standby certificate must have the same subject as the active one")
+	}
+	return &Responder{db: db, store: store, apply: apply, hooks: hooks, logger: logger, active: active, standby: standby}, nil
+}
+
+// Reload replaces the keys after a configuration reload and starts signing with active. An
+// active key recorded as compromised is refused and the current keys are kept
+func (r *Responder) Reload(ctx context.Context, active, standby *Key) error {
+	if standby != nil && !sameSubject(active, standby) {
+		return errors.New("standby certificate must have the same subject as the active one")
+	}
+	if err := r.Check(ctx, active); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.apply(ctx, active); err != nil {
+		return err
+	}
+	r.active, r.standby = active, standby
+	return nil
+}
+
+// Check returns ErrCompromised if key is recorded as compromised
+func (r *Responder) Check(ctx context.Context, key *Key) error {
+	compromised, err := r.db.IsCompromised(ctx, key.Hash())
+	if err != nil {
+		return fmt.Errorf("failed to check for a recorded compromise: %w", err)
+	}
+	if compromised {
+		return fmt.Errorf("%w: %s", ErrCompromised, key.Hash())
+	}
+	return nil
+}
+
+// Respond runs the compromise response for the active key, which must belong to issuer, the
+// hex SHA-256 key hash of the signing certificate. The compromise is recorded first, so other
+// replicas switch even if a later step fails; the certificate is revoked unless it is
+// self-signed, CRLs are re-signed with the standby key and alerts are fired
+func (r *Responder) Respond(ctx context.Context, issuer string) (*Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !strings.EqualFold(issuer, r.active.Hash()) {
+		return nil, ErrIssuerMismatch
+	}
+	if r.standby == nil {
+		return nil, ErrNoStandby
+	}
+	if err := r.Check(ctx, r.standby); err != nil {
+		if errors.Is(err, ErrCompromised) {
+			return nil, ErrNoStandby
+		}
+		return nil, err
+	}
+
+	actor := approval.PrincipalFrom(ctx)
+	if actor == "" {
+		actor = "anonymous"
+	}
+	compromised := r.active
+	result := &Result{
+		CompromisedKey: compromised.Hash(),
+		ActiveKey:      r.standby.Hash(),
+		Actor:          actor,
+		At:             time.Now().UTC(),
+	}
+	// The steps below must run to completion once started
+	ctx = context.WithoutCancel(ctx)
+	if err := r.db.Record(ctx, Record{
+		KeyHash:           compromised.Hash(),
+		CertificateSerial: compromised.Certificate.SerialNumber.Text(16),
+		Subject:           compromised.Certificate.Subject.String(),
+		Actor:             actor,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to record the compromise: %w", err)
+	}
+
+	var errs []error
+	if !isSelfSigned(compromised.Certificate) {
+		reason := revocation.ReasonKeyCompromise
+		if compromised.Certificate.IsCA {
+			reason = revocation.ReasonCACompromise
+		}
+		serial := compromised.Certificate.SerialNumber.Text(16)
+		err := r.store.Upsert(ctx, storage.Update{
+			Serial:           serial,
+			Status:           storage.StatusRevoked,
+			RevokedAt:        &result.At,
+			RevocationReason: reason,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to revoke certificate %s: %w", serial, err))
+		} else {
+			result.RevokedSerial = serial
+		}
+	}
+	if err := r.switchToStandby(ctx, "respond", actor); err != nil {
+		errs = append(errs, err)
+	}
+	return result, errors.Join(errs...)
+}
+
+// Status reports the keys and recorded compromises
+func (r *Responder) Status(ctx context.Context) (*Status, error) {
+	records, err := r.db.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := &Status{ActiveKey: r.active.Hash(), Compromised: records}
+	if r.standby != nil {
+		status.StandbyKey = r.standby.Hash()
+	}
+	return status, nil
+}
+
+// Sync switches to the standby key if the active key has been recorded as compromised,
+// whether by this replica before a restart or by another one
+func (r *Responder) Sync(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.Check(ctx, r.active); !errors.Is(err, ErrCompromised) {
+		return err
+	}
+	if r.standby == nil {
+		return ErrNoStandby
+	}
+	if err := r.Check(ctx, r.standby); err != nil {
+		if errors.Is(err, ErrCompromised) {
+			return ErrNoStandby
+		}
+		return err
+	}
+	return r.switchToStandby(ctx, "follow", "")
+}
+
+// Watch calls Sync every interval until ctx is cancelled
+func (r *Responder) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Sync(ctx); err != nil {
+				r.logger.Error("Active signing key is compromised and cannot be switched out", zap.Error(err))
+			}
+		}
+	}
+}
+
+// switchToStandby must be called with mu held
+func (r *Responder) switchToStandby(ctx context.Context, source, actor string) error {
+	compromised, standby := r.active, r.standby
+	if err := r.apply(ctx, standby); err != nil {
+		return fmt.Errorf("failed to switch to the standby key: %w", err)
+	}
+	r.active, r.standby = standby, nil
+	switches.WithLabelValues(source).Inc()
+
+	message := fmt.Sprintf("signing key %s for %s is compromised; now signing with standby key %s",
+		compromised.Hash(), compromised.Certificate.Subject, standby.Hash())
+	if actor != "" {
+		message += " (response run by " + actor + ")"
+	}
+	r.logger.Warn("Switched to the standby signing key",
+		zap.String("compromised_key", compromised.Hash()),
+		zap.String("active_key", standby.Hash()),
+		zap.String("source", source),
+	)
+	alert := anomaly.Alert{Kind: KindKeyCompromise, Message: message, Source: compromised.Hash(), Count: 1, FiredAt: time.Now()}
+	for _, hook := range r.hooks {
+		hook.Fire(ctx, alert)
+	}
+	return nil
+}
+
+func sameSubject(a, b *Key) bool {
+	return string(a.Certificate.RawSubject) == string(b.Certificate.RawSubject)
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return string(cert.RawIssuer) == string(cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
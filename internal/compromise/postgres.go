@@ -0,0 +1,67 @@
+package compromise
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Record is a key recorded as compromised
+type Record struct {
+	KeyHash           string    `json:"key_hash"`
+	CertificateSerial string    `json:"certificate_serial"`
+	Subject           string    `json:"subject"`
+	Actor             string    `json:"actor"`
+	CompromisedAt     time.Time `json:"compromised_at"`
+}
+
+// Postgres keeps compromised keys in the compromised_keys table so every replica sees them
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgres creates a Postgres compromise record
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Record marks a key as compromised; recording it again keeps the first record
+func (p *Postgres) Record(ctx context.Context, rec Record) error {
+	_, err := p.db.Exec(ctx, `
+		INSERT INTO compromised_keys (key_hash, certificate_serial, subject, actor)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key_hash) DO NOTHING
+	`, rec.KeyHash, rec.CertificateSerial, rec.Subject, rec.Actor)
+	return err
+}
+
+// IsCompromised reports whether a key is recorded as compromised
+func (p *Postgres) IsCompromised(ctx context.Context, keyHash string) (bool, error) {
+	var compromised bool
+	err := p.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM compromised_keys WHERE key_hash = $1)`, keyHash).Scan(&compromised)
+	return compromised, err
+}
+
+// List returns every recorded compromise, newest first
+func (p *Postgres) List(ctx context.Context) ([]Record, error) {
+	rows, err := p.db.Query(ctx, `
+		SELECT key_hash, certificate_serial, subject, actor, compromised_at
+		FROM compromised_keys
+		ORDER BY compromised_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []Record{}
+	for rows.Next() {
+		var rec Record
+		if err := rows.Scan(&rec.KeyHash, &rec.CertificateSerial, &rec.Subject, &rec.Actor, &rec.CompromisedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
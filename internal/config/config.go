@@ -0,0 +1,1437 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	sharedconfig "github.com/gigvault/shared/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Config extends the shared service configuration with OCSP-specific settings
+type Config struct {
+	sharedconfig.Config `yaml:",inline"`
+
+	DatabasePool DatabasePoolConfig `yaml:"database_pool"`
+	GRPC         GRPCConfig         `yaml:"grpc"`
+
+	Metrics   MetricsConfig   `yaml:"metrics"`
+	Anomaly   AnomalyConfig   `yaml:"anomaly"`
+	LogPolicy LogPolicyConfig `yaml:"log_policy"`
+
+	ErrorReporting ErrorReportingConfig  `yaml:"error_reporting"`
+	Reports        ReportsConfig         `yaml:"reports"`
+	CRLImport      CRLImportConfig       `yaml:"crl_import"`
+	CRL            CRLConfig             `yaml:"crl"`
+	CRLSync        CRLSyncConfig         `yaml:"crl_sync"`
+	Events         EventsConfig          `yaml:"events"`
+	ACME           ACMEConfig            `yaml:"acme"`
+	CTCheck        CTCheckConfig         `yaml:"ct_check"`
+	UpstreamOCSP   UpstreamOCSPConfig    `yaml:"upstream_ocsp"`
+	CASync         CASyncConfig          `yaml:"ca_sync"`
+	Archive        ArchiveConfig         `yaml:"archive"`
+	CDN            CDNConfig             `yaml:"cdn"`
+	Watch          WatchConfig           `yaml:"watch"`
+	Reload         ReloadConfig          `yaml:"reload"`
+	Mode           ModeConfig            `yaml:"mode"`
+	Leader         LeaderConfig          `yaml:"leader"`
+	Backup         BackupConfig          `yaml:"backup"`
+	Replication    ReplicationConfig     `yaml:"replication"`
+	FeatureFlags   FeatureFlagsConfig    `yaml:"feature_flags"`
+	Presigned      PresignedConfig       `yaml:"presigned"`
+	Access         AccessConfig          `yaml:"access"`
+	RequestLimits  RequestLimitsConfig   `yaml:"request_limits"`
+	Secrets        SecretsConfig         `yaml:"secrets"`
+	Approvals      ApprovalsConfig       `yaml:"approvals"`
+	Guardrails     GuardrailsConfig      `yaml:"guardrails"`
+	NonceReplay    NonceReplayConfig     `yaml:"nonce_replay"`
+	Audit          AuditConfig           `yaml:"audit"`
+	Compromise     CompromiseConfig      `yaml:"compromise"`
+	Retention      RetentionConfig       `yaml:"retention"`
+	Precomputed    PrecomputedConfig     `yaml:"precomputed"`
+	WriteBehind    WriteBehindConfig     `yaml:"write_behind"`
+	Sharding       ShardingConfig        `yaml:"sharding"`
+	LoadShedding   LoadSheddingConfig    `yaml:"load_shedding"`
+	Chaos          ChaosConfig           `yaml:"chaos"`
+	Shadow         ShadowConfig          `yaml:"shadow"`
+	Watchdog       WatchdogConfig        `yaml:"watchdog"`
+	ShortLived     ShortLivedConfig      `yaml:"short_lived"`
+	SerialAliases  SerialAliasesConfig   `yaml:"serial_aliases"`
+	Scheduled      ScheduledConfig       `yaml:"scheduled_revocations"`
+	Holds          HoldsConfig           `yaml:"timed_holds"`
+	Extensions     ExtensionsConfig      `yaml:"response_extensions"`
+	Expired        ExpiredConfig         `yaml:"expired_certificates"`
+	ResponseLog    ResponseLogConfig     `yaml:"response_log"`
+	TopRequests    TopRequestsConfig     `yaml:"top_requests"`
+	DeadLetters    DeadLettersConfig     `yaml:"dead_letters"`
+	Role           string                `yaml:"role"`
+	Notifications  NotificationsConfig   `yaml:"change_notifications"`
+	APIKeys        APIKeysConfig         `yaml:"api_keys"`
+	Metering       MeteringConfig        `yaml:"metering"`
+	Cassandra      CassandraConfig       `yaml:"cassandra"`
+	Spanner        SpannerConfig         `yaml:"spanner"`
+	Discovery      DiscoveryConfig       `yaml:"discovery"`
+	CoAP           CoAPConfig            `yaml:"coap"`
+	SigningWatch   SigningWatchConfig    `yaml:"signing_watch"`
+	SelfCheck      SelfCheckConfig       `yaml:"selfcheck"`
+	Preflight      PreflightConfig       `yaml:"preflight"`
+	Failover       FailoverConfig        `yaml:"database_failover"`
+	Tunables       TunablesConfig        `yaml:"tunables"`
+	AdminAuth      AdminAuthConfig       `yaml:"admin_auth"`
+	Issuers        IssuerDiscoveryConfig `yaml:"issuer_discovery"`
+}
+
+// Service roles. The combined role runs everything in one process. The writer role serves
+// the gRPC mutation API and the admin HTTP API and presigns responses, but answers no RFC 6960
+// requests. The responder role answers them from the responses the writer presigned, or from
+// a presigned bundle, and serves gRPC lookups, with no signing key and no database writes
+const (
+	RoleCombined  = "combined"
+	RoleWriter    = "writer"
+	RoleResponder = "responder"
+)
+
+// AuditConfig records every committed status change with the principal that made it, and
+// serves signed exports of the record at GET /api/v1/audit/export. Exports are signed with
+// the key at SigningKeyPath and carry the certificate at CertificatePath for verification
+type AuditConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	SigningKeyPath  string `yaml:"signing_key_path"`
+	CertificatePath string `yaml:"certificate_path"`
+}
+
+// CompromiseConfig enables POST /api/v1/compromise, which takes the CRL signing key out of
+// service: it is recorded as compromised, its certificate is revoked and CRLs are re-signed
+// with the standby key. Replicas check for a recorded compromise every PollInterval
+type CompromiseConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	StandbyCertPath string        `yaml:"standby_cert_path"`
+	StandbyKeyPath  string        `yaml:"standby_key_path"`
+	PollInterval    time.Duration `yaml:"poll_interval"`
+}
+
+// RetentionConfig purges each data class once it is older than its period, every Interval on
+// the leader; a zero period keeps the class forever. Statuses are aged from certificate expiry,
+// which CA sync records; audit records and archived objects from when they were written
+type RetentionConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Interval  time.Duration `yaml:"interval"`
+	BatchSize int           `yaml:"batch_size"`
+	Statuses  time.Duration `yaml:"statuses"`
+	Audit     time.Duration `yaml:"audit"`
+	Archive   time.Duration `yaml:"archive"`
+}
+
+// PrecomputedConfig answers RFC 6960 requests at Path from responses the leader signs ahead
+type PrecomputedConfig struct {
+	Enabled           bool          `yaml:"enabled"`
+	Path              string        `yaml:"path"`
+	IssuerCertPath    string        `yaml:"issuer_cert_path"`
+	ResponderCertPath string        `yaml:"responder_cert_path"`
+	SigningKeyPath    string        `yaml:"signing_key_path"`
+	Validity          time.Duration `yaml:"validity"`
+	Jitter            time.Duration `yaml:"jitter"`
+	RefreshBefore     time.Duration `yaml:"refresh_before"`
+	Interval          time.Duration `yaml:"interval"`
+	BatchSize         int           `yaml:"batch_size"`
+	Concurrency       int           `yaml:"concurrency"`
+	// PreviousIssuers are earlier certificates of the issuer, with the same subject and a key
+	// it has rolled away from; requests naming them are answered on demand with their keys
+	PreviousIssuers []PreviousIssuerConfig `yaml:"previous_issuers"`
+	Drift           PrecomputedDriftConfig `yaml:"drift"`
+}
+
+// PrecomputedDriftConfig compares a sample of stored responses with their statuses
+type PrecomputedDriftConfig struct {
+	Enabled           bool          `yaml:"enabled"`
+	Interval          time.Duration `yaml:"interval"`
+	SampleSize        int           `yaml:"sample_size"`
+	RevokedSampleSize int           `yaml:"revoked_sample_size"`
+	Lookback          time.Duration `yaml:"lookback"`
+	Grace             time.Duration `yaml:"grace"`
+	Correct           bool          `yaml:"correct"`
+}
+
+// PreviousIssuerConfig is an earlier issuer certificate and the key signing for it: its own, or
+// that of a delegated responder certified by it at ResponderCertPath
+type PreviousIssuerConfig struct {
+	IssuerCertPath    string `yaml:"issuer_cert_path"`
+	ResponderCertPath string `yaml:"responder_cert_path"`
+	SigningKeyPath    string `yaml:"signing_key_path"`
+}
+
+// WriteBehindConfig acknowledges UpdateStatus writes of the listed Statuses before they are written
+type WriteBehindConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	Statuses       []string      `yaml:"statuses"`
+	QueueSize      int           `yaml:"queue_size"`
+	BatchSize      int           `yaml:"batch_size"`
+	FlushInterval  time.Duration `yaml:"flush_interval"`
+	EnqueueTimeout time.Duration `yaml:"enqueue_timeout"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+}
+
+// ShardingConfig spreads statuses across the databases in Shards by a consistent hash of the
+// serial; everything else stays in the main database. Shards in Retiring receive no writes.
+// With Resharding, lookups that miss a serial's shard search every other shard, so statuses
+// can be moved with ocsp reshard while serving. Shards are pinged every HealthInterval
+type ShardingConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	Shards         []ShardConfig `yaml:"shards"`
+	Retiring       []ShardConfig `yaml:"retiring"`
+	Resharding     bool          `yaml:"resharding"`
+	HealthInterval time.Duration `yaml:"health_interval"`
+}
+
+// ShardConfig is one shard database. The order of Shards decides where serials live, so
+// shards are only ever appended, and appending one calls for a reshard
+type ShardConfig struct {
+	Name                        string `yaml:"name"`
+	sharedconfig.DatabaseConfig `yaml:",inline"`
+}
+
+// DatabasePoolConfig tunes the connection pools to the main database and the shards
+type DatabasePoolConfig struct {
+	MinConns              int32         `yaml:"min_conns"`
+	MaxConns              int32         `yaml:"max_conns"`
+	MaxConnLifetime       time.Duration `yaml:"max_conn_lifetime"`
+	MaxConnLifetimeJitter time.Duration `yaml:"max_conn_lifetime_jitter"`
+	MaxConnIdleTime       time.Duration `yaml:"max_conn_idle_time"`
+	HealthCheckPeriod     time.Duration `yaml:"health_check_period"`
+	StatementTimeout      time.Duration `yaml:"statement_timeout"`
+	QueryExecMode         string        `yaml:"query_exec_mode"`
+	StatementCacheSize    int           `yaml:"statement_cache_size"`
+	PgBouncer             bool          `yaml:"pgbouncer"`
+}
+
+// GRPCConfig tunes the gRPC server transport. With Gzip, requests compressed with gzip are
+// accepted and answered compressed at GzipLevel; without it they are refused. Message sizes are
+// bytes after decompression, and MaxConcurrentStreams bounds the calls in flight per connection
+type GRPCConfig struct {
+	Gzip                 bool                `yaml:"gzip"`
+	GzipLevel            int                 `yaml:"gzip_level"`
+	MaxRecvMessageSize   int                 `yaml:"max_recv_message_size"`
+	MaxSendMessageSize   int                 `yaml:"max_send_message_size"`
+	MaxConcurrentStreams uint32              `yaml:"max_concurrent_streams"`
+	Keepalive            GRPCKeepaliveConfig `yaml:"keepalive"`
+	Deadlines            GRPCDeadlinesConfig `yaml:"deadlines"`
+}
+
+// GRPCDeadlinesConfig bounds how long each method's calls may take. A client's own deadline
+// applies when it is sooner; zero leaves a method bounded by the client alone
+type GRPCDeadlinesConfig struct {
+	CheckStatus       time.Duration `yaml:"check_status"`
+	UpdateStatus      time.Duration `yaml:"update_status"`
+	BatchUpdateStatus time.Duration `yaml:"batch_update_status"`
+}
+
+// GRPCKeepaliveConfig configures gRPC keepalive pings and connection ages
+type GRPCKeepaliveConfig struct {
+	Time                  time.Duration `yaml:"time"`
+	Timeout               time.Duration `yaml:"timeout"`
+	MinTime               time.Duration `yaml:"min_time"`
+	PermitWithoutStream   bool          `yaml:"permit_without_stream"`
+	MaxConnectionIdle     time.Duration `yaml:"max_connection_idle"`
+	MaxConnectionAge      time.Duration `yaml:"max_connection_age"`
+	MaxConnectionAgeGrace time.Duration `yaml:"max_connection_age_grace"`
+}
+
+// LoadSheddingConfig bounds the RFC 6960 requests and gRPC CheckStatus calls in flight by a
+// limit between MinLimit and MaxLimit, starting at InitialLimit. The limit grows while requests
+// finish within LatencyTarget and is multiplied by Backoff when they do not, and requests over
+// it are answered tryLater or UNAVAILABLE at once
+type LoadSheddingConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	InitialLimit  int           `yaml:"initial_limit"`
+	MinLimit      int           `yaml:"min_limit"`
+	MaxLimit      int           `yaml:"max_limit"`
+	LatencyTarget time.Duration `yaml:"latency_target"`
+	Backoff       float64       `yaml:"backoff"`
+}
+
+// ChaosConfig injects faults for resilience testing: status store calls are delayed by
+// DBLatency or fail, batch writes fail after applying part of the batch, and precomputed
+// responses fail to sign, each with the given probability per call. Seed makes the faults
+// repeatable. It is refused when service.environment is production
+type ChaosConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	Seed             int64         `yaml:"seed"`
+	DBLatency        time.Duration `yaml:"db_latency"`
+	DBLatencyRate    float64       `yaml:"db_latency_rate"`
+	DBErrorRate      float64       `yaml:"db_error_rate"`
+	PartialBatchRate float64       `yaml:"partial_batch_rate"`
+	SignerErrorRate  float64       `yaml:"signer_error_rate"`
+}
+
+// ShadowConfig mirrors Percent of live lookups to a second instance and logs where its
+// answers differ from the ones served: RFC 6960 requests to the precomputed responder are
+// replayed against HTTPURL, and gRPC CheckStatus calls against GRPCAddress. Shadow calls run
+// in the background within Timeout, at most MaxInFlight at once
+type ShadowConfig struct {
+	Enabled     bool            `yaml:"enabled"`
+	Percent     float64         `yaml:"percent"`
+	HTTPURL     string          `yaml:"http_url"`
+	GRPCAddress string          `yaml:"grpc_address"`
+	TLS         TLSClientConfig `yaml:"tls"`
+	Timeout     time.Duration   `yaml:"timeout"`
+	MaxInFlight int             `yaml:"max_in_flight"`
+}
+
+// WatchdogConfig looks serials up at the public endpoint URL on every Interval, verifies the
+// responses against the issuer at IssuerCertPath and compares their status with the stored
+// one; failed runs alert through the anomaly hooks. Serials are checked on every run, along
+// with SampleSize serials revoked within Lookback
+type WatchdogConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	URL              string        `yaml:"url"`
+	IssuerCertPath   string        `yaml:"issuer_cert_path"`
+	Serials          []string      `yaml:"serials"`
+	SampleSize       int           `yaml:"sample_size"`
+	Lookback         time.Duration `yaml:"lookback"`
+	Interval         time.Duration `yaml:"interval"`
+	Timeout          time.Duration `yaml:"timeout"`
+	MaxAge           time.Duration `yaml:"max_age"`
+	ClockSkew        time.Duration `yaml:"clock_skew"`
+	PropagationDelay time.Duration `yaml:"propagation_delay"`
+	UseGET           bool          `yaml:"use_get"`
+}
+
+// ShortLivedConfig answers good for short-lived certificates of Issuers without a stored status:
+// their serials, minted with pkg/shortserial, carry their expiry and an HMAC keyed with the
+// issuer's secret. Answers are valid for Validity, or until the certificate expires if sooner
+type ShortLivedConfig struct {
+	Enabled  bool                     `yaml:"enabled"`
+	Validity time.Duration            `yaml:"validity"`
+	Issuers  []ShortLivedIssuerConfig `yaml:"issuers"`
+}
+
+// ShortLivedIssuerConfig is an issuer of short-lived certificates. Serials claiming to expire more
+// than MaxLifetime ahead are not vouched for
+type ShortLivedIssuerConfig struct {
+	IssuerCertPath string        `yaml:"issuer_cert_path"`
+	Secret         string        `yaml:"secret"`
+	MaxLifetime    time.Duration `yaml:"max_lifetime"`
+}
+
+// SerialAliasesConfig resolves serials without a status of their own, such as CT precertificate
+// serials, to the status of the serial they alias; aliases are managed at /api/v1/aliases.
+// Status lookups resolve them whenever enabled, and the precomputed responder when its issuer is
+// one of IssuerCertPaths
+type SerialAliasesConfig struct {
+	Enabled         bool     `yaml:"enabled"`
+	IssuerCertPaths []string `yaml:"issuer_cert_paths"`
+}
+
+// ScheduledConfig accepts revocations that take effect at a future time at
+// /api/v1/revocations/scheduled. The leader applies those due every Interval, up to BatchSize
+// per run; they may be scheduled at most MaxHorizon ahead and cancelled until they take effect
+type ScheduledConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Interval   time.Duration `yaml:"interval"`
+	MaxHorizon time.Duration `yaml:"max_horizon"`
+	BatchSize  int           `yaml:"batch_size"`
+}
+
+// HoldsConfig places certificateHold revocations that end at a set time at /api/v1/holds. The
+// leader returns serials whose hold ended to good every Interval, up to BatchSize per run,
+// unless the hold was extended or made permanent. Holds may end at most MaxDuration ahead
+type HoldsConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Interval    time.Duration `yaml:"interval"`
+	MaxDuration time.Duration `yaml:"max_duration"`
+	BatchSize   int           `yaml:"batch_size"`
+}
+
+// ExtensionsConfig adds deployment-specific extensions to the responses the precomputed
+// responder signs: the Static ones, and those computed per response by the Builders registered
+// with pkg/ocspext under their names. With PerRequest, stored responses are re-signed for every
+// request so builders see it, at the cost of a signature per request
+type ExtensionsConfig struct {
+	Enabled    bool                     `yaml:"enabled"`
+	PerRequest bool                     `yaml:"per_request"`
+	Static     []StaticExtensionConfig  `yaml:"static"`
+	Builders   []ExtensionBuilderConfig `yaml:"builders"`
+	CRLID      CRLIDConfig              `yaml:"crl_id"`
+}
+
+// CRLIDConfig adds a CrlID extension naming the generated CRL to the responses of revoked
+// certificates. URL is where the CRL is published; partitioned CRLs use crl.partitions.base_url
+type CRLIDConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	URL     string `yaml:"url"`
+}
+
+// StaticExtensionConfig is an extension added to every response, with its DER value in hex.
+// Scope is single, for the singleExtensions, or response, for the responseExtensions
+type StaticExtensionConfig struct {
+	OID      string `yaml:"oid"`
+	Value    string `yaml:"value"`
+	Critical bool   `yaml:"critical"`
+	Scope    string `yaml:"scope"`
+}
+
+// ExtensionBuilderConfig selects a registered extension builder and gives it its options
+type ExtensionBuilderConfig struct {
+	Name    string            `yaml:"name"`
+	Options map[string]string `yaml:"options"`
+}
+
+// ExpiredConfig decides how the precomputed responder answers for expired certificates
+type ExpiredConfig struct {
+	Enabled          bool                  `yaml:"enabled"`
+	Policy           string                `yaml:"policy"`
+	Grace            time.Duration         `yaml:"grace"`
+	ArchiveRetention time.Duration         `yaml:"archive_retention"`
+	Issuers          []ExpiredIssuerConfig `yaml:"issuers"`
+}
+
+// ExpiredIssuerConfig is the expired certificate policy of the issuer at IssuerCertPath
+type ExpiredIssuerConfig struct {
+	IssuerCertPath   string        `yaml:"issuer_cert_path"`
+	Policy           string        `yaml:"policy"`
+	Grace            time.Duration `yaml:"grace"`
+	ArchiveRetention time.Duration `yaml:"archive_retention"`
+}
+
+// ResponseLogConfig records every response the precomputed responder signs, by the refresh
+// job or on demand, in the append-only response_log table before it is stored or served, and
+// serves the log at GET /api/v1/response-log/{serial}
+type ResponseLogConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// TopRequestsConfig counts the most requested serials and issuers over each of Windows, which
+// slide by Bucket. Every bucket counts at most Capacity serials and Capacity issuers, so memory
+// stays bounded under scanning. The MetricsTop most requested keys of each window are published
+// as gauges every PublishInterval; zero publishes none
+type TopRequestsConfig struct {
+	Enabled         bool            `yaml:"enabled"`
+	Windows         []time.Duration `yaml:"windows"`
+	Bucket          time.Duration   `yaml:"bucket"`
+	Capacity        int             `yaml:"capacity"`
+	MetricsTop      int             `yaml:"metrics_top"`
+	PublishInterval time.Duration   `yaml:"publish_interval"`
+}
+
+// DeadLettersConfig keeps the rows bulk imports and gRPC batches could not apply in the
+// dead_letters table, served at /api/v1/dead-letters for correction and replay
+type DeadLettersConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// NotificationsConfig makes every replica listen for the status change notifications the
+// database sends (migration 018), so long-polling watchers wake and precomputed responses are
+// re-signed as soon as any replica or tool changes a status, instead of on the next poll
+type NotificationsConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// APIKeysConfig enables the managed API keys of the gRPC mutation API
+type APIKeysConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Require       bool          `yaml:"require"`
+	CacheTTL      time.Duration `yaml:"cache_ttl"`
+	RotationGrace time.Duration `yaml:"rotation_grace"`
+}
+
+// MeteringConfig counts lookups served, status changes applied and responses presigned per
+// tenant and issuer over each Period. With an S3 bucket, every replica writes each closed
+// period as CSV below Prefix; Replica names it there and defaults to the host name. Recent
+// periods are served at /api/v1/usage either way
+type MeteringConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Period  time.Duration `yaml:"period"`
+	Replica string        `yaml:"replica"`
+	Prefix  string        `yaml:"prefix"`
+	S3      S3Config      `yaml:"s3"`
+}
+
+// CassandraConfig keeps statuses in a Cassandra or ScyllaDB keyspace instead of the main database
+type CassandraConfig struct {
+	Enabled         bool                       `yaml:"enabled"`
+	Hosts           []string                   `yaml:"hosts"`
+	Keyspace        string                     `yaml:"keyspace"`
+	LocalDatacenter string                     `yaml:"local_datacenter"`
+	Username        string                     `yaml:"username"`
+	Password        string                     `yaml:"password"`
+	TLS             TLSClientConfig            `yaml:"tls"`
+	ConnectTimeout  time.Duration              `yaml:"connect_timeout"`
+	BatchSize       int                        `yaml:"batch_size"`
+	Consistency     CassandraConsistencyConfig `yaml:"consistency"`
+}
+
+// CassandraConsistencyConfig names the consistency level of each kind of operation: Read for
+// lookups, Write for single status changes, Batch for batches, Scan for listings such as CRL
+// generation, and Serial for the Paxos round of conditional writes
+type CassandraConsistencyConfig struct {
+	Read   string `yaml:"read"`
+	Write  string `yaml:"write"`
+	Batch  string `yaml:"batch"`
+	Scan   string `yaml:"scan"`
+	Serial string `yaml:"serial"`
+}
+
+// SpannerConfig keeps statuses in a Cloud Spanner database instead of the main database
+type SpannerConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Database  string        `yaml:"database"`
+	Endpoint  string        `yaml:"endpoint"`
+	Emulator  bool          `yaml:"emulator"`
+	Sessions  int           `yaml:"sessions"`
+	Staleness time.Duration `yaml:"staleness"`
+	BatchSize int           `yaml:"batch_size"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+// DiscoveryConfig registers each replica with Consul or etcd
+type DiscoveryConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Provider    string        `yaml:"provider"`
+	ServiceName string        `yaml:"service_name"`
+	InstanceID  string        `yaml:"instance_id"`
+	Address     string        `yaml:"address"`
+	Tags        []string      `yaml:"tags"`
+	Interval    time.Duration `yaml:"interval"`
+	TTL         time.Duration `yaml:"ttl"`
+	Consul      ConsulConfig  `yaml:"consul"`
+	Etcd        EtcdConfig    `yaml:"etcd"`
+}
+
+// ConsulConfig holds the URL of the local Consul agent and its ACL token. A service whose
+// check has been critical for DeregisterAfter is removed by Consul
+type ConsulConfig struct {
+	Address         string          `yaml:"address"`
+	Token           string          `yaml:"token"`
+	DeregisterAfter time.Duration   `yaml:"deregister_after"`
+	TLS             TLSClientConfig `yaml:"tls"`
+}
+
+// EtcdConfig holds the etcd endpoints, tried in order, and the key prefix registrations are
+// written under as <prefix><service>/<instance>. Username and Password are needed when etcd
+// has authentication enabled
+type EtcdConfig struct {
+	Endpoints []string        `yaml:"endpoints"`
+	Prefix    string          `yaml:"prefix"`
+	Username  string          `yaml:"username"`
+	Password  string          `yaml:"password"`
+	TLS       TLSClientConfig `yaml:"tls"`
+}
+
+// CoAPConfig answers RFC 6960 requests over CoAP on UDP Port
+type CoAPConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Port        int           `yaml:"port"`
+	BlockSize   int           `yaml:"block_size"`
+	MaxInFlight int           `yaml:"max_in_flight"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+// SigningWatchConfig degrades precomputed signing keys that become slow or stall
+type SigningWatchConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Threshold    time.Duration `yaml:"threshold"`
+	Window       time.Duration `yaml:"window"`
+	MinSamples   int           `yaml:"min_samples"`
+	StallTimeout time.Duration `yaml:"stall_timeout"`
+	Interval     time.Duration `yaml:"interval"`
+}
+
+// SelfCheckConfig serves Path, a load balancer probe running a canary request through the responder
+type SelfCheckConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Path     string        `yaml:"path"`
+	Serial   string        `yaml:"serial"`
+	Timeout  time.Duration `yaml:"timeout"`
+	CacheFor time.Duration `yaml:"cache_for"`
+}
+
+// PreflightConfig checks at startup what the enabled features depend on
+type PreflightConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Timeout time.Duration `yaml:"timeout"`
+	Strict  bool          `yaml:"strict"`
+}
+
+// FailoverConfig holds status writes through a failover of the main database
+type FailoverConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	QueueSize     int           `yaml:"queue_size"`
+	MaxWait       time.Duration `yaml:"max_wait"`
+	ProbeInterval time.Duration `yaml:"probe_interval"`
+}
+
+// TunablesConfig serves /api/v1/tunables, which changes the log level, the upstream OCSP cache
+// size, the precomputed refresh concurrency and the per-source request limit while the service
+// runs. A change lasts until the replica restarts unless it is persisted, in which case every
+// replica applies it when it starts. Changes are recorded in the tunable_changes table
+type TunablesConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// AdminAuthConfig authenticates the HTTP API under /api/v1: requests that change anything, and
+// backups, need a bearer token listed in PrincipalsPath as "<name> <hex SHA-256 of token>".
+// PrincipalsPath defaults to approvals.principals_path; with neither, those requests are refused
+type AdminAuthConfig struct {
+	PrincipalsPath string `yaml:"principals_path"`
+}
+
+// NonceReplayConfig makes responses signed on demand refuse a request whose nonce was answered
+// within Window, and with Require, requests without a nonce. Nonces are remembered per replica;
+// past MaxEntries, or MaxPerClient from one source, the oldest are forgotten early
+type NonceReplayConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Require      bool          `yaml:"require"`
+	Window       time.Duration `yaml:"window"`
+	MaxEntries   int           `yaml:"max_entries"`
+	MaxPerClient int           `yaml:"max_per_client"`
+}
+
+// GuardrailsConfig bounds how many serials may be revoked within Window, as a count and as a
+// percentage of the serials in the status table. Writes crossing a limit are refused; with
+// Action "confirm" they may be resent with the confirmation token from the refusal, which
+// stays valid for the rest of the window
+type GuardrailsConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	Window         time.Duration `yaml:"window"`
+	MaxRevocations int           `yaml:"max_revocations"` // 0 disables
+	MaxPercent     float64       `yaml:"max_percent"`     // 0 disables
+	MinPopulation  int64         `yaml:"min_population"`
+	Action         string        `yaml:"action"` // block or confirm
+}
+
+// ApprovalsConfig holds the two-person rule for high-impact revocations
+type ApprovalsConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	PrincipalsPath string        `yaml:"principals_path"`
+	Approvers      []string      `yaml:"approvers"`
+	CASerials      []string      `yaml:"ca_serials"`
+	Reasons        []string      `yaml:"reasons"`
+	MaxBatch       int           `yaml:"max_batch"` // 0 disables the batch rule
+	TTL            time.Duration `yaml:"ttl"`
+}
+
+// SecretsConfig configures the stores vault:, awssm: and gcpsm: secret references are fetched from
+type SecretsConfig struct {
+	Dir             string             `yaml:"dir"`
+	RefreshInterval time.Duration      `yaml:"refresh_interval"`
+	Timeout         time.Duration      `yaml:"timeout"`
+	Vault           VaultSecretsConfig `yaml:"vault"`
+	AWS             AWSSecretsConfig   `yaml:"aws"`
+}
+
+// VaultSecretsConfig holds the Vault server and how to log in to it. Auth is token (Token, or
+// VAULT_TOKEN when empty), kubernetes (Role and the service account JWT at JWTPath) or approle
+// (RoleID and SecretID); AuthMount defaults to the method name
+type VaultSecretsConfig struct {
+	Address   string `yaml:"address"`
+	Namespace string `yaml:"namespace"`
+	CAPath    string `yaml:"ca_path"`
+	Auth      string `yaml:"auth"`
+	AuthMount string `yaml:"auth_mount"`
+	Token     string `yaml:"token"`
+	Role      string `yaml:"role"`
+	JWTPath   string `yaml:"jwt_path"`
+	RoleID    string `yaml:"role_id"`
+	SecretID  string `yaml:"secret_id"`
+}
+
+// AWSSecretsConfig holds the AWS Secrets Manager region; empty credentials fall back to
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+type AWSSecretsConfig struct {
+	Region          string `yaml:"region"`
+	Endpoint        string `yaml:"endpoint"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+// RequestLimitsConfig bounds what one OCSP request may contain and how many requests one
+// source may have in flight. Oversized requests are answered malformedRequest; sources over
+// MaxConcurrentPerSource get 429. IPv6 sources are counted per /64
+type RequestLimitsConfig struct {
+	MaxBodyBytes           int `yaml:"max_body_bytes"`
+	MaxCertIDs             int `yaml:"max_cert_ids"`
+	MaxExtensions          int `yaml:"max_extensions"`
+	MaxExtensionBytes      int `yaml:"max_extension_bytes"`
+	MaxConcurrentPerSource int `yaml:"max_concurrent_per_source"` // 0 disables
+}
+
+// AccessConfig holds source address rules per surface. Admin covers the gRPC server and the
+// HTTP API under /api/v1; Responder covers the presigned OCSP path and CRL distribution points
+type AccessConfig struct {
+	Admin     CIDRRulesConfig `yaml:"admin"`
+	Responder CIDRRulesConfig `yaml:"responder"`
+}
+
+// CIDRRulesConfig lists CIDRs or addresses; Deny wins over Allow, and an empty Allow admits
+// every source not denied
+type CIDRRulesConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// PresignedConfig serves OCSP responses signed offline with ocsp presign. When enabled the
+// responder holds no key and uses no database: it answers RFC 6960 requests at Path and gRPC
+// lookups from the bundle, and a reload picks up a newly delivered bundle. BundlePath may also
+// name a mapped file, which is served from disk
+type PresignedConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	BundlePath     string `yaml:"bundle_path"`
+	IssuerCertPath string `yaml:"issuer_cert_path"`
+	Path           string `yaml:"path"`
+}
+
+// FeatureFlagsConfig holds rollout rules for flag-gated behaviors, keyed by flag name. Rules
+// are applied again on reload; with Database enabled, rules in the feature_flags table
+// override them and can be changed at runtime through PUT /api/v1/flags/{name}
+type FeatureFlagsConfig struct {
+	Rules    map[string]FlagRuleConfig `yaml:"rules"`
+	Database FlagsDatabaseConfig       `yaml:"database"`
+}
+
+// FlagRuleConfig turns a flag on for the listed serials and a stable Percent of all others
+type FlagRuleConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Percent int      `yaml:"percent"`
+	Serials []string `yaml:"serials"`
+}
+
+// FlagsDatabaseConfig holds settings for sharing flag rules between replicas through Postgres
+type FlagsDatabaseConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// ReplicationConfig holds settings for active-active replication between regions over the
+// NATS connection in events.nats. Each region publishes its status writes to
+// SubjectPrefix.<Region>; a single Stream must capture SubjectPrefix.> in every region
+type ReplicationConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Region        string `yaml:"region"`
+	Stream        string `yaml:"stream"`
+	SubjectPrefix string `yaml:"subject_prefix"`
+	MaxDeliver    int    `yaml:"max_deliver"`
+}
+
+// BackupConfig holds the key backup archives are signed and verified with; Enabled also
+// serves GET /api/v1/backup and POST /api/v1/restore
+type BackupConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	SigningKey string `yaml:"signing_key"`
+}
+
+// LeaderConfig holds settings for electing the replica that runs background jobs (CRL sync,
+// CA sync, CT checks, reports, archival and CDN pushes). Backend is postgres, an advisory lock
+// named LockName, or kubernetes, a Lease named LockName in the cluster the service runs in
+type LeaderConfig struct {
+	Enabled    bool                   `yaml:"enabled"`
+	Backend    string                 `yaml:"backend"`
+	LockName   string                 `yaml:"lock_name"`
+	Interval   time.Duration          `yaml:"interval"`
+	Kubernetes LeaderKubernetesConfig `yaml:"kubernetes"`
+}
+
+// LeaderKubernetesConfig holds the Lease settings. Namespace defaults to the pod's and
+// Identity to the pod name with a random suffix. The leader renews the Lease every
+// leader.interval, and a follower takes it over once it has gone LeaseDuration without renewal
+type LeaderKubernetesConfig struct {
+	Namespace     string        `yaml:"namespace"`
+	Identity      string        `yaml:"identity"`
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+}
+
+// ModeConfig holds the operating mode the service starts in; it can be switched at runtime
+// through PUT /api/v1/mode. RetryAfter is advertised to lookups refused during maintenance
+type ModeConfig struct {
+	Initial    string        `yaml:"initial"`
+	RetryAfter time.Duration `yaml:"retry_after"`
+}
+
+// ReloadConfig holds settings for applying configuration changes without a restart; SIGHUP
+// always triggers a reload, and a positive WatchInterval also polls the file for changes
+type ReloadConfig struct {
+	WatchInterval time.Duration `yaml:"watch_interval"`
+}
+
+// WatchConfig holds settings for the long-poll status API used by stapling fleets
+type WatchConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	MaxWait time.Duration `yaml:"max_wait"`
+}
+
+// CDNConfig holds settings for purging edge-cached status and CRL URLs on change. StatusPaths
+// contain {serial}; batches touching more than MaxPaths paths purge everything instead
+type CDNConfig struct {
+	Enabled     bool             `yaml:"enabled"`
+	StatusPaths []string         `yaml:"status_paths"`
+	PurgeCRLs   bool             `yaml:"purge_crls"`
+	MaxPaths    int              `yaml:"max_paths"`
+	Fastly      FastlyConfig     `yaml:"fastly"`
+	CloudFront  CloudFrontConfig `yaml:"cloudfront"`
+}
+
+// FastlyConfig holds Fastly API settings; ServiceID is only needed to purge everything
+type FastlyConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	APIToken  string `yaml:"api_token"`
+	Host      string `yaml:"host"`
+	ServiceID string `yaml:"service_id"`
+	SoftPurge bool   `yaml:"soft_purge"`
+}
+
+// CloudFrontConfig holds a CloudFront distribution; empty credentials fall back to
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+type CloudFrontConfig struct {
+	Enabled         bool   `yaml:"enabled"`
+	DistributionID  string `yaml:"distribution_id"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+// ArchiveConfig holds settings for writing CRLs and status snapshots to object storage; a zero
+// SnapshotInterval archives CRLs only
+type ArchiveConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	Prefix           string        `yaml:"prefix"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+	S3               S3Config      `yaml:"s3"`
+}
+
+// S3Config holds an S3-compatible bucket; empty credentials fall back to AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY
+type S3Config struct {
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	Bucket          string `yaml:"bucket"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	PathStyle       bool   `yaml:"path_style"`
+}
+
+// CASyncConfig holds settings for seeding good statuses from the CA service's certificate listing
+type CASyncConfig struct {
+	Enabled  bool            `yaml:"enabled"`
+	Address  string          `yaml:"address"`
+	Interval time.Duration   `yaml:"interval"`
+	PageSize int             `yaml:"page_size"`
+	TLS      TLSClientConfig `yaml:"tls"`
+}
+
+// IssuerDiscoveryConfig registers the issuers of leaf certificates, fetched from their AIA
+// caIssuers URLs and verified up to the roots in RootsPath
+type IssuerDiscoveryConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	RootsPath string        `yaml:"roots_path"`
+	Timeout   time.Duration `yaml:"timeout"`
+	MaxDepth  int           `yaml:"max_depth"`
+}
+
+// UpstreamOCSPConfig holds settings for resolving locally unknown serials at a legacy responder
+type UpstreamOCSPConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	URL            string        `yaml:"url"`
+	IssuerCertPath string        `yaml:"issuer_cert_path"`
+	MaxCacheTTL    time.Duration `yaml:"max_cache_ttl"`
+	CacheSize      int           `yaml:"cache_size"`
+}
+
+// CTCheckConfig holds settings for cross-checking revocations against Certificate Transparency;
+// alerts go to the anomaly hooks
+type CTCheckConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	Lookback time.Duration `yaml:"lookback"`
+	// SearchURLs contain a {serial} placeholder for the lowercase hex serial
+	SearchURLs []string `yaml:"search_urls"`
+}
+
+// ACMEConfig holds settings for the ACME front end revocation intake
+type ACMEConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Secret is the HMAC key the front end signs intake requests with
+	Secret string `yaml:"secret"`
+}
+
+// MetricsConfig holds Prometheus exporter settings
+type MetricsConfig struct {
+	Enabled   bool            `yaml:"enabled"`
+	Path      string          `yaml:"path"`
+	Freshness FreshnessConfig `yaml:"freshness"`
+}
+
+// FreshnessConfig holds settings for the response freshness SLI exporter
+type FreshnessConfig struct {
+	Interval   time.Duration `yaml:"interval"`
+	RefreshSLA time.Duration `yaml:"refresh_sla"`
+}
+
+// AnomalyConfig holds thresholds and hooks for the anomaly detector
+type AnomalyConfig struct {
+	Enabled             bool               `yaml:"enabled"`
+	Window              time.Duration      `yaml:"window"`
+	Cooldown            time.Duration      `yaml:"cooldown"`
+	UnknownThreshold    int64              `yaml:"unknown_threshold"`
+	RevocationThreshold int64              `yaml:"revocation_threshold"`
+	ScanRunLength       int                `yaml:"scan_run_length"`
+	Hooks               AnomalyHooksConfig `yaml:"hooks"`
+}
+
+// AnomalyHooksConfig selects where anomaly alerts are delivered
+type AnomalyHooksConfig struct {
+	Log        bool   `yaml:"log"`
+	Metric     bool   `yaml:"metric"`
+	WebhookURL string `yaml:"webhook_url"`
+}
+
+// LogPolicyConfig holds per-component log levels, sampling, and redaction rules
+type LogPolicyConfig struct {
+	Components map[string]string  `yaml:"components"`
+	Sampling   LogSamplingConfig  `yaml:"sampling"`
+	Redaction  LogRedactionConfig `yaml:"redaction"`
+}
+
+// LogSamplingConfig samples high-volume components: the first Initial entries per
+// message each Tick are logged, then every Thereafter-th entry
+type LogSamplingConfig struct {
+	Components []string      `yaml:"components"`
+	Tick       time.Duration `yaml:"tick"`
+	Initial    int           `yaml:"initial"`
+	Thereafter int           `yaml:"thereafter"`
+}
+
+// LogRedactionConfig lists log fields whose values are hashed, masked, or dropped
+type LogRedactionConfig struct {
+	Fields []string `yaml:"fields"`
+	Mode   string   `yaml:"mode"` // hash, mask, drop
+}
+
+// ErrorReportingConfig holds settings for the Sentry-compatible error reporter
+type ErrorReportingConfig struct {
+	DSN         string `yaml:"dsn"`
+	Environment string `yaml:"environment"`
+}
+
+// ReportsConfig holds settings for scheduled operational reports
+type ReportsConfig struct {
+	Enabled      bool               `yaml:"enabled"`
+	Interval     time.Duration      `yaml:"interval"`
+	TopReasons   int                `yaml:"top_reasons"`
+	WebhookURL   string             `yaml:"webhook_url"`
+	EmailGateway EmailGatewayConfig `yaml:"email_gateway"`
+}
+
+// EmailGatewayConfig describes an HTTP email gateway accepting JSON messages
+type EmailGatewayConfig struct {
+	URL  string   `yaml:"url"`
+	From string   `yaml:"from"`
+	To   []string `yaml:"to"`
+}
+
+// CRLImportConfig holds settings for importing revocations from CRLs
+type CRLImportConfig struct {
+	// IssuerCertPath, when set, requires imported CRLs to be signed by this certificate
+	IssuerCertPath string `yaml:"issuer_cert_path"`
+	// LDAP is how CRLs at ldap:// and ldaps:// URLs are read, by imports and CRL sync alike
+	LDAP LDAPConfig `yaml:"ldap"`
+}
+
+// LDAPConfig is how CRLs are read from the directories legacy enterprise PKIs publish them to.
+// BindDN and Password make a simple bind; without them searches are anonymous. A password is
+// only sent over ldaps:// or after StartTLS. TLS holds the roots and client certificate for
+// both
+type LDAPConfig struct {
+	BindDN   string          `yaml:"bind_dn"`
+	Password string          `yaml:"password"`
+	StartTLS bool            `yaml:"start_tls"`
+	TLS      TLSClientConfig `yaml:"tls"`
+	Timeout  time.Duration   `yaml:"timeout"`
+}
+
+// CRLConfig holds settings for generating and distributing signed CRLs
+type CRLConfig struct {
+	Enabled        bool             `yaml:"enabled"`
+	IssuerCertPath string           `yaml:"issuer_cert_path"`
+	IssuerKeyPath  string           `yaml:"issuer_key_path"`
+	Interval       time.Duration    `yaml:"interval"`
+	Validity       time.Duration    `yaml:"validity"`
+	Path           string           `yaml:"path"`
+	Delta          DeltaCRLConfig   `yaml:"delta"`
+	IDP            CRLIDPConfig     `yaml:"idp"`
+	Partitions     PartitionsConfig `yaml:"partitions"`
+}
+
+// PartitionsConfig splits the CRL into several smaller CRLs by serial number
+type PartitionsConfig struct {
+	// Count is the number of partitions; a serial belongs to partition serial mod Count
+	Count int `yaml:"count"`
+	// BaseURL is the public location of the partitions, each served at BaseURL/<n>
+	BaseURL string `yaml:"base_url"`
+}
+
+// CRLIDPConfig holds the Issuing Distribution Point scope advertised in generated CRLs
+type CRLIDPConfig struct {
+	Enabled       bool     `yaml:"enabled"`
+	URIs          []string `yaml:"uris"`
+	OnlyUserCerts bool     `yaml:"only_user_certs"`
+	OnlyCACerts   bool     `yaml:"only_ca_certs"`
+}
+
+// DeltaCRLConfig holds settings for delta CRLs published between full CRLs
+type DeltaCRLConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval"`
+	Validity time.Duration `yaml:"validity"`
+	// URL is the public location of the delta CRL, advertised in base CRLs
+	URL string `yaml:"url"`
+}
+
+// CRLSyncConfig holds settings for periodically fetching upstream CRLs
+type CRLSyncConfig struct {
+	Enabled  bool              `yaml:"enabled"`
+	Interval time.Duration     `yaml:"interval"`
+	Sources  []CRLSourceConfig `yaml:"sources"`
+}
+
+// CRLSourceConfig describes one upstream CRL distribution point
+type CRLSourceConfig struct {
+	URL            string `yaml:"url"`
+	IssuerCertPath string `yaml:"issuer_cert_path"`
+}
+
+// EventsConfig holds the sinks status change events are published to
+type EventsConfig struct {
+	Kafka    KafkaConfig     `yaml:"kafka"`
+	NATS     NATSConfig      `yaml:"nats"`
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+	Outbox   OutboxConfig    `yaml:"outbox"`
+}
+
+// OutboxConfig makes every status change record its event in the database, in the same
+// transaction, for a relay to publish to the sinks: events are delivered at least once and
+// never for a change that did not commit
+type OutboxConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// PollInterval is how often the relay looks for unpublished events
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// BatchSize bounds the events published together
+	BatchSize int `yaml:"batch_size"`
+	// Retention is how long published events are kept before they are purged
+	Retention time.Duration `yaml:"retention"`
+}
+
+// NATSConfig holds settings for JetStream event publishing and status intake
+type NATSConfig struct {
+	Enabled         bool               `yaml:"enabled"`
+	URL             string             `yaml:"url"`
+	Subject         string             `yaml:"subject"`
+	CredentialsPath string             `yaml:"credentials_path"`
+	TLS             TLSClientConfig    `yaml:"tls"`
+	Consumer        NATSConsumerConfig `yaml:"consumer"`
+}
+
+// NATSConsumerConfig holds settings for applying status updates received from JetStream
+type NATSConsumerConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Stream     string `yaml:"stream"`
+	Subject    string `yaml:"subject"`
+	Durable    string `yaml:"durable"`
+	MaxDeliver int    `yaml:"max_deliver"`
+}
+
+// WebhookConfig holds settings for one HMAC-signed status change webhook
+type WebhookConfig struct {
+	URL             string        `yaml:"url"`
+	Secret          string        `yaml:"secret"`
+	RevocationsOnly bool          `yaml:"revocations_only"`
+	MaxAttempts     int           `yaml:"max_attempts"`
+	InitialBackoff  time.Duration `yaml:"initial_backoff"`
+	MaxBackoff      time.Duration `yaml:"max_backoff"`
+	DeadLetterPath  string        `yaml:"dead_letter_path"`
+}
+
+// KafkaConfig holds settings for publishing status change events to Kafka
+type KafkaConfig struct {
+	Enabled bool            `yaml:"enabled"`
+	Brokers []string        `yaml:"brokers"`
+	Topic   string          `yaml:"topic"`
+	TLS     TLSClientConfig `yaml:"tls"`
+	SASL    SASLConfig      `yaml:"sasl"`
+}
+
+// TLSClientConfig holds client TLS settings for outbound connections
+type TLSClientConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	CAPath   string `yaml:"ca_path"`
+	CertPath string `yaml:"cert_path"`
+	KeyPath  string `yaml:"key_path"`
+}
+
+// SASLConfig holds SASL credentials; Mechanism is plain, scram-sha-256 or scram-sha-512
+type SASLConfig struct {
+	Mechanism string `yaml:"mechanism"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+// Load loads the shared configuration and the OCSP-specific sections from the same file, then
+// applies OCSP_* environment overrides. Callers validate after applying any flag overrides
+func Load(path string) (*Config, error) {
+	base, err := sharedconfig.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	cfg := Default()
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	// Keep the environment overrides applied by the shared loader
+	cfg.Config = *base
+
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, fmt.Errorf("invalid environment override: %w", err)
+	}
+	return cfg, nil
+}
+
+// Default returns a configuration populated with default values
+func Default() *Config {
+	return &Config{
+		DatabasePool: DatabasePoolConfig{
+			MinConns:              2,
+			MaxConns:              20,
+			MaxConnLifetime:       time.Hour,
+			MaxConnLifetimeJitter: 5 * time.Minute,
+			MaxConnIdleTime:       30 * time.Minute,
+			HealthCheckPeriod:     time.Minute,
+			QueryExecMode:         "cache_statement",
+			StatementCacheSize:    512,
+		},
+		GRPC: GRPCConfig{
+			Gzip:                 true,
+			GzipLevel:            1,
+			MaxRecvMessageSize:   16 << 20,
+			MaxSendMessageSize:   16 << 20,
+			MaxConcurrentStreams: 1000,
+			Keepalive: GRPCKeepaliveConfig{
+				Time:                  time.Minute,
+				Timeout:               20 * time.Second,
+				MinTime:               10 * time.Second,
+				PermitWithoutStream:   true,
+				MaxConnectionAge:      30 * time.Minute,
+				MaxConnectionAgeGrace: time.Minute,
+			},
+			Deadlines: GRPCDeadlinesConfig{
+				CheckStatus:       2 * time.Second,
+				UpdateStatus:      10 * time.Second,
+				BatchUpdateStatus: 5 * time.Minute,
+			},
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+			Path:    "/metrics",
+			Freshness: FreshnessConfig{
+				Interval:   30 * time.Second,
+				RefreshSLA: 12 * time.Hour,
+			},
+		},
+		Anomaly: AnomalyConfig{
+			Window:              time.Minute,
+			Cooldown:            10 * time.Minute,
+			UnknownThreshold:    500,
+			RevocationThreshold: 100,
+			ScanRunLength:       20,
+			Hooks: AnomalyHooksConfig{
+				Log:    true,
+				Metric: true,
+			},
+		},
+		CRL: CRLConfig{
+			Interval: time.Hour,
+			Validity: 24 * time.Hour,
+			Path:     "/crl",
+			Delta: DeltaCRLConfig{
+				Interval: 15 * time.Minute,
+				Validity: time.Hour,
+			},
+		},
+		CRLImport: CRLImportConfig{
+			LDAP: LDAPConfig{
+				Timeout: time.Minute,
+			},
+		},
+		CRLSync: CRLSyncConfig{
+			Interval: 30 * time.Minute,
+		},
+		UpstreamOCSP: UpstreamOCSPConfig{
+			MaxCacheTTL: time.Hour,
+			CacheSize:   100000,
+		},
+		CASync: CASyncConfig{
+			Interval: 5 * time.Minute,
+			PageSize: 500,
+		},
+		Issuers: IssuerDiscoveryConfig{
+			Timeout:  10 * time.Second,
+			MaxDepth: 4,
+		},
+		Archive: ArchiveConfig{
+			SnapshotInterval: 24 * time.Hour,
+		},
+		CDN: CDNConfig{
+			PurgeCRLs: true,
+			MaxPaths:  100,
+		},
+		Watch: WatchConfig{
+			MaxWait: 5 * time.Minute,
+		},
+		Mode: ModeConfig{
+			Initial:    "normal",
+			RetryAfter: time.Minute,
+		},
+		Presigned: PresignedConfig{
+			Path: "/ocsp",
+		},
+		RequestLimits: RequestLimitsConfig{
+			MaxBodyBytes:           10 << 10,
+			MaxCertIDs:             1,
+			MaxExtensions:          8,
+			MaxExtensionBytes:      128,
+			MaxConcurrentPerSource: 16,
+		},
+		NonceReplay: NonceReplayConfig{
+			Window:       5 * time.Minute,
+			MaxEntries:   100000,
+			MaxPerClient: 1000,
+		},
+		Guardrails: GuardrailsConfig{
+			Window:         time.Hour,
+			MaxRevocations: 1000,
+			MaxPercent:     5,
+			MinPopulation:  1000,
+			Action:         "confirm",
+		},
+		Retention: RetentionConfig{
+			Interval:  24 * time.Hour,
+			BatchSize: 10000,
+		},
+		WriteBehind: WriteBehindConfig{
+			Statuses:       []string{"good"},
+			QueueSize:      10000,
+			BatchSize:      500,
+			FlushInterval:  100 * time.Millisecond,
+			EnqueueTimeout: time.Second,
+			MaxAttempts:    5,
+		},
+		Sharding: ShardingConfig{
+			HealthInterval: 10 * time.Second,
+		},
+		LoadShedding: LoadSheddingConfig{
+			InitialLimit:  100,
+			MinLimit:      10,
+			MaxLimit:      1000,
+			LatencyTarget: 50 * time.Millisecond,
+			Backoff:       0.9,
+		},
+		Chaos: ChaosConfig{
+			DBLatency: 200 * time.Millisecond,
+		},
+		Shadow: ShadowConfig{
+			Percent:     1,
+			Timeout:     5 * time.Second,
+			MaxInFlight: 100,
+		},
+		ShortLived: ShortLivedConfig{
+			Validity: 4 * time.Hour,
+		},
+		Scheduled: ScheduledConfig{
+			Interval:   30 * time.Second,
+			MaxHorizon: 365 * 24 * time.Hour,
+			BatchSize:  100,
+		},
+		Holds: HoldsConfig{
+			Interval:    30 * time.Second,
+			MaxDuration: 90 * 24 * time.Hour,
+			BatchSize:   100,
+		},
+		Expired: ExpiredConfig{
+			Policy: "serve",
+			Grace:  30 * 24 * time.Hour,
+		},
+		TopRequests: TopRequestsConfig{
+			Windows:         []time.Duration{5 * time.Minute, time.Hour},
+			Bucket:          time.Minute,
+			Capacity:        1000,
+			MetricsTop:      10,
+			PublishInterval: 30 * time.Second,
+		},
+		Watchdog: WatchdogConfig{
+			SampleSize:       10,
+			Lookback:         24 * time.Hour,
+			Interval:         time.Minute,
+			Timeout:          10 * time.Second,
+			ClockSkew:        time.Minute,
+			PropagationDelay: 10 * time.Minute,
+		},
+		Precomputed: PrecomputedConfig{
+			Path:          "/ocsp",
+			Validity:      24 * time.Hour,
+			RefreshBefore: 8 * time.Hour,
+			Interval:      30 * time.Second,
+			BatchSize:     1000,
+			Concurrency:   1,
+			Drift: PrecomputedDriftConfig{
+				Interval:          5 * time.Minute,
+				SampleSize:        500,
+				RevokedSampleSize: 100,
+				Lookback:          24 * time.Hour,
+				Grace:             10 * time.Minute,
+				Correct:           true,
+			},
+		},
+		Compromise: CompromiseConfig{
+			PollInterval: 30 * time.Second,
+		},
+		Approvals: ApprovalsConfig{
+			Reasons: []string{"keyCompromise", "cACompromise"},
+			TTL:     24 * time.Hour,
+		},
+		Secrets: SecretsConfig{
+			Dir:     "/run/ocsp/secrets",
+			Timeout: 30 * time.Second,
+			Vault: VaultSecretsConfig{
+				Auth:    "token",
+				JWTPath: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+			},
+		},
+		FeatureFlags: FeatureFlagsConfig{
+			Database: FlagsDatabaseConfig{
+				RefreshInterval: 30 * time.Second,
+			},
+		},
+		Replication: ReplicationConfig{
+			Stream:        "OCSP_REPLICATION",
+			SubjectPrefix: "ocsp.replication",
+			MaxDeliver:    20,
+		},
+		Leader: LeaderConfig{
+			Backend:  "postgres",
+			LockName: "ocsp-background-jobs",
+			Interval: 10 * time.Second,
+			Kubernetes: LeaderKubernetesConfig{
+				LeaseDuration: 30 * time.Second,
+			},
+		},
+		CTCheck: CTCheckConfig{
+			Interval:   15 * time.Minute,
+			Lookback:   time.Hour,
+			SearchURLs: []string{"https://crt.sh/?serial={serial}&output=json"},
+		},
+		Events: EventsConfig{
+			Kafka: KafkaConfig{
+				Topic: "ocsp.status-changes",
+			},
+			NATS: NATSConfig{
+				URL:     "nats://localhost:4222",
+				Subject: "ocsp.status.changed",
+				Consumer: NATSConsumerConfig{
+					Subject:    "ocsp.status.updates",
+					Durable:    "ocsp",
+					MaxDeliver: 10,
+				},
+			},
+			Outbox: OutboxConfig{
+				PollInterval: 500 * time.Millisecond,
+				BatchSize:    500,
+				Retention:    7 * 24 * time.Hour,
+			},
+		},
+		Reports: ReportsConfig{
+			Interval:   7 * 24 * time.Hour,
+			TopReasons: 5,
+		},
+		LogPolicy: LogPolicyConfig{
+			Sampling: LogSamplingConfig{
+				Tick:       time.Second,
+				Initial:    100,
+				Thereafter: 100,
+			},
+			Redaction: LogRedactionConfig{
+				Mode: "hash",
+			},
+		},
+		Role: RoleCombined,
+		APIKeys: APIKeysConfig{
+			Require:       true,
+			CacheTTL:      10 * time.Second,
+			RotationGrace: 24 * time.Hour,
+		},
+		Metering: MeteringConfig{
+			Period: time.Hour,
+		},
+		Cassandra: CassandraConfig{
+			Keyspace:       "ocsp",
+			ConnectTimeout: 5 * time.Second,
+			BatchSize:      100,
+			Consistency: CassandraConsistencyConfig{
+				Read:   "local_quorum",
+				Write:  "local_quorum",
+				Batch:  "local_quorum",
+				Scan:   "local_quorum",
+				Serial: "local_serial",
+			},
+		},
+		Spanner: SpannerConfig{
+			Endpoint:  "https://spanner.googleapis.com",
+			Sessions:  100,
+			Staleness: 10 * time.Second,
+			BatchSize: 1000,
+			Timeout:   30 * time.Second,
+		},
+		Discovery: DiscoveryConfig{
+			Provider: "consul",
+			Interval: 10 * time.Second,
+			TTL:      30 * time.Second,
+			Consul: ConsulConfig{
+				Address:         "http://127.0.0.1:8500",
+				DeregisterAfter: 10 * time.Minute,
+			},
+			Etcd: EtcdConfig{
+				Prefix: "/gigvault/services/",
+			},
+		},
+		CoAP: CoAPConfig{
+			Port:        5683,
+			BlockSize:   1024,
+			MaxInFlight: 256,
+			Timeout:     5 * time.Second,
+		},
+		SigningWatch: SigningWatchConfig{
+			Threshold:    500 * time.Millisecond,
+			Window:       5 * time.Minute,
+			MinSamples:   20,
+			StallTimeout: 5 * time.Second,
+			Interval:     10 * time.Second,
+		},
+		SelfCheck: SelfCheckConfig{
+			Path:     "/selfcheck",
+			Timeout:  2 * time.Second,
+			CacheFor: 5 * time.Second,
+		},
+		Preflight: PreflightConfig{
+			Enabled: true,
+			Timeout: 5 * time.Second,
+		},
+		Failover: FailoverConfig{
+			QueueSize:     1000,
+			MaxWait:       30 * time.Second,
+			ProbeInterval: time.Second,
+		},
+	}
+}
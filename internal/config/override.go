@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix prefixes the environment variables that override config values. The rest of the
+// name is the dotted YAML path in upper case with dots replaced by underscores, so
+// events.kafka.topic is set by OCSP_EVENTS_KAFKA_TOPIC
+const EnvPrefix = "OCSP_"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ApplyEnv overrides every scalar and string list setting that has a matching environment variable
+func (c *Config) ApplyEnv() error {
+	return walk(reflect.ValueOf(c).Elem(), "", func(path string, field reflect.Value) error {
+		value, ok := os.LookupEnv(EnvName(path))
+		if !ok {
+			return nil
+		}
+		if err := setValue(field, value); err != nil {
+			return fmt.Errorf("%s: %w", EnvName(path), err)
+		}
+		return nil
+	})
+}
+
+// VisitStrings calls fn with the dotted YAML path and value of every string setting and stores
+// whatever fn leaves in value. Settings inside lists and maps are not visited
+func (c *Config) VisitStrings(fn func(path string, value *string) error) error {
+	return walk(reflect.ValueOf(c).Elem(), "", func(path string, field reflect.Value) error {
+		if field.Kind() != reflect.String {
+			return nil
+		}
+		value := field.String()
+		if err := fn(path, &value); err != nil {
+			return err
+		}
+		field.SetString(value)
+		return nil
+	})
+}
+
+// Set overrides the setting at a dotted YAML path such as crl.delta.enabled
+func (c *Config) Set(path, value string) error {
+	found := false
+	err := walk(reflect.ValueOf(c).Elem(), "", func(p string, field reflect.Value) error {
+		if p != path {
+			return nil
+		}
+		found = true
+		return setValue(field, value)
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if !found {
+		return fmt.Errorf("%s: unknown or non-scalar setting", path)
+	}
+	return nil
+}
+
+// ApplyOverrides applies key=value overrides, as given to the -set flag
+func (c *Config) ApplyOverrides(overrides []string) error {
+	for _, override := range overrides {
+		path, value, ok := strings.Cut(override, "=")
+		if !ok {
+			return fmt.Errorf("override %q is not of the form key=value", override)
+		}
+		if err := c.Set(strings.TrimSpace(path), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnvName returns the environment variable overriding a dotted YAML path
+func EnvName(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// walk calls fn for every settable leaf: scalars, durations and string lists. Lists of
+// sections and maps are only configurable through the file
+func walk(v reflect.Value, prefix string, fn func(path string, field reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		field := v.Field(i)
+
+		if sf.Type.Kind() == reflect.Struct && sf.Type != durationType {
+			next := prefix
+			if opts != "inline" {
+				next = join(prefix, name)
+			}
+			if err := walk(field, next, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			continue
+		}
+		if settable(sf.Type) {
+			if err := fn(join(prefix, name), field); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func join(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func settable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+// setValue parses value into field; string lists are comma separated
+func setValue(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid unsigned integer %q", value)
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+	return nil
+}
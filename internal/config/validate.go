@@ -0,0 +1,760 @@
+package config
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/ldap"
+	"github.com/gigvault/ocsp/pkg/ocspext"
+	"github.com/gigvault/ocsp/pkg/shortserial"
+	"github.com/gocql/gocql"
+)
+
+// validator accumulates problems so startup reports all of them at once
+type validator struct {
+	errs []error
+}
+
+func (v *validator) check(ok bool, path, format string, args ...interface{}) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+}
+
+func (v *validator) required(value, path string) {
+	v.check(strings.TrimSpace(value) != "", path, "is required")
+}
+
+func (v *validator) positive(d time.Duration, path string) {
+	v.check(d > 0, path, "must be a positive duration")
+}
+
+func (v *validator) expiredPolicy(policy string, grace, retention time.Duration, path string) {
+	switch policy {
+	case "serve":
+		v.positive(grace, path+".grace")
+	case "archive_cutoff":
+		v.positive(retention, path+".archive_retention")
+	case "unknown":
+	default:
+		v.check(false, path+".policy", "must be serve, unknown or archive_cutoff, got %q", policy)
+	}
+}
+
+func (v *validator) port(port int, path string) {
+	v.check(port > 0 && port < 65536, path, "must be between 1 and 65535")
+}
+
+func (v *validator) url(value, path string) {
+	u, err := url.Parse(value)
+	v.check(err == nil && u.Scheme != "" && u.Host != "", path, "must be an absolute URL, got %q", value)
+}
+
+func (v *validator) cidrs(values []string, path string) {
+	for i, value := range values {
+		_, err := netip.ParsePrefix(value)
+		if !strings.Contains(value, "/") {
+			_, err = netip.ParseAddr(value)
+		}
+		v.check(err == nil, fmt.Sprintf("%s[%d]", path, i), "must be a CIDR or IP address, got %q", value)
+	}
+}
+
+// consistency checks a CQL consistency level name; serial levels are only valid for the Paxos
+// round of conditional writes, and the others only outside it
+func (v *validator) consistency(name, path string, serial bool) {
+	if serial {
+		var level gocql.SerialConsistency
+		v.check(level.UnmarshalText([]byte(strings.ToUpper(name))) == nil, path, "must be serial or local_serial, got %q", name)
+		return
+	}
+	_, err := gocql.ParseConsistencyWrapper(name)
+	v.check(err == nil, path, "must be a non-serial consistency level such as local_quorum, got %q", name)
+}
+
+func (v *validator) tls(cfg TLSClientConfig, path string) {
+	if cfg.Enabled {
+		v.check((cfg.CertPath == "") == (cfg.KeyPath == ""), path, "cert_path and key_path must be set together")
+	}
+}
+
+// Validate reports every invalid or missing setting of the enabled features
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	v.required(c.Service.Name, "service.name")
+	if c.Presigned.Enabled {
+		v.required(c.Presigned.BundlePath, "presigned.bundle_path")
+		v.required(c.Presigned.IssuerCertPath, "presigned.issuer_cert_path")
+		v.check(strings.HasPrefix(c.Presigned.Path, "/") && c.Presigned.Path != "/", "presigned.path", "must start with / and not be the root")
+		// Presigned responders hold no key, so nothing they run may sign
+		v.check(!c.CRL.Enabled, "crl.enabled", "must be false with presigned.enabled: CRL generation needs a signing key")
+	} else {
+		v.required(c.Database.Host, "database.host")
+		v.port(c.Database.Port, "database.port")
+	}
+	switch c.Role {
+	case RoleCombined:
+	case RoleWriter:
+		v.check(!c.Presigned.Enabled, "presigned.enabled", "must be false in the writer role, which answers no RFC 6960 requests")
+	case RoleResponder:
+		v.check(c.Precomputed.Enabled || c.Presigned.Enabled, "role", "responder requires precomputed.enabled or presigned.enabled, the responses it serves")
+		// Responders hold no key, so nothing they run may sign
+		v.check(!c.CRL.Enabled, "crl.enabled", "must be false in the responder role: CRL generation needs a signing key")
+	default:
+		v.check(false, "role", "must be combined, writer or responder")
+	}
+	v.port(c.Server.HTTPPort, "server.http_port")
+	v.port(c.Server.GRPCPort, "server.grpc_port")
+	v.check(c.Server.HTTPPort != c.Server.GRPCPort, "server.grpc_port", "must differ from server.http_port")
+	if c.Security.TLSEnabled {
+		v.required(c.Security.TLSCertPath, "security.tls_cert_path")
+		v.required(c.Security.TLSKeyPath, "security.tls_key_path")
+	}
+
+	if c.Metrics.Enabled {
+		v.check(strings.HasPrefix(c.Metrics.Path, "/"), "metrics.path", "must start with /")
+		v.positive(c.Metrics.Freshness.Interval, "metrics.freshness.interval")
+	}
+	if c.Anomaly.Enabled {
+		v.positive(c.Anomaly.Window, "anomaly.window")
+	}
+	if c.Anomaly.Hooks.WebhookURL != "" {
+		v.url(c.Anomaly.Hooks.WebhookURL, "anomaly.hooks.webhook_url")
+	}
+	if c.Reports.Enabled {
+		v.positive(c.Reports.Interval, "reports.interval")
+	}
+
+	c.validateCRL(v)
+	c.validateEvents(v)
+
+	if c.ACME.Enabled {
+		v.required(c.ACME.Secret, "acme.secret")
+	}
+	if c.CTCheck.Enabled {
+		v.positive(c.CTCheck.Interval, "ct_check.interval")
+		v.check(len(c.CTCheck.SearchURLs) > 0, "ct_check.search_urls", "at least one URL is required")
+		for i, u := range c.CTCheck.SearchURLs {
+			v.check(strings.Contains(u, "{serial}"), fmt.Sprintf("ct_check.search_urls[%d]", i), "must contain {serial}")
+		}
+	}
+	if c.UpstreamOCSP.Enabled {
+		v.url(c.UpstreamOCSP.URL, "upstream_ocsp.url")
+		v.required(c.UpstreamOCSP.IssuerCertPath, "upstream_ocsp.issuer_cert_path")
+		v.check(c.UpstreamOCSP.CacheSize >= 0, "upstream_ocsp.cache_size", "must not be negative")
+	}
+	if c.CASync.Enabled {
+		v.required(c.CASync.Address, "ca_sync.address")
+		v.positive(c.CASync.Interval, "ca_sync.interval")
+		v.check(c.CASync.PageSize > 0, "ca_sync.page_size", "must be positive")
+		v.tls(c.CASync.TLS, "ca_sync.tls")
+	}
+	if c.Issuers.Enabled {
+		v.required(c.Issuers.RootsPath, "issuer_discovery.roots_path")
+		v.positive(c.Issuers.Timeout, "issuer_discovery.timeout")
+		v.check(c.Issuers.MaxDepth > 0, "issuer_discovery.max_depth", "must be positive")
+		v.check(c.Role != RoleResponder, "issuer_discovery.enabled", "must be false in the responder role, which has no database write access")
+	}
+	if c.Archive.Enabled {
+		v.required(c.Archive.S3.Bucket, "archive.s3.bucket")
+		v.required(c.Archive.S3.Region, "archive.s3.region")
+		if c.Archive.S3.Endpoint != "" {
+			v.url(c.Archive.S3.Endpoint, "archive.s3.endpoint")
+		}
+		v.check(c.Archive.SnapshotInterval >= 0, "archive.snapshot_interval", "must not be negative")
+	}
+	if c.CDN.Enabled {
+		v.check(c.CDN.Fastly.Enabled || c.CDN.CloudFront.Enabled, "cdn", "fastly or cloudfront must be enabled")
+		for i, p := range c.CDN.StatusPaths {
+			v.check(strings.Contains(p, "{serial}"), fmt.Sprintf("cdn.status_paths[%d]", i), "must contain {serial}")
+		}
+		if c.CDN.Fastly.Enabled {
+			v.required(c.CDN.Fastly.APIToken, "cdn.fastly.api_token")
+			v.required(c.CDN.Fastly.Host, "cdn.fastly.host")
+		}
+		if c.CDN.CloudFront.Enabled {
+			v.required(c.CDN.CloudFront.DistributionID, "cdn.cloudfront.distribution_id")
+		}
+	}
+	if c.Watch.Enabled {
+		v.positive(c.Watch.MaxWait, "watch.max_wait")
+	}
+	v.check(c.Reload.WatchInterval >= 0, "reload.watch_interval", "must not be negative")
+	switch c.Mode.Initial {
+	case "normal", "read_only", "maintenance":
+	default:
+		v.check(false, "mode.initial", "must be normal, read_only or maintenance, got %q", c.Mode.Initial)
+	}
+	v.positive(c.Mode.RetryAfter, "mode.retry_after")
+	if c.Backup.Enabled {
+		v.required(c.Backup.SigningKey, "backup.signing_key")
+		v.check(c.AdminAuth.PrincipalsPath != "" || (c.Approvals.Enabled && c.Approvals.PrincipalsPath != ""),
+			"admin_auth.principals_path", "is required with backup.enabled")
+	}
+	if c.Leader.Enabled {
+		v.required(c.Leader.LockName, "leader.lock_name")
+		v.positive(c.Leader.Interval, "leader.interval")
+		switch c.Leader.Backend {
+		case "postgres":
+		case "kubernetes":
+			v.check(dnsSubdomain(c.Leader.LockName), "leader.lock_name", "must be a lowercase DNS subdomain to name a Lease, got %q", c.Leader.LockName)
+			// The leader steps down when it could not renew for a whole interval before expiry
+			v.check(c.Leader.Kubernetes.LeaseDuration >= 2*c.Leader.Interval, "leader.kubernetes.lease_duration", "must be at least twice leader.interval")
+			v.check(c.Leader.Kubernetes.LeaseDuration%time.Second == 0, "leader.kubernetes.lease_duration", "must be a whole number of seconds")
+		default:
+			v.check(false, "leader.backend", "must be postgres or kubernetes, got %q", c.Leader.Backend)
+		}
+	}
+	v.cidrs(c.Access.Admin.Allow, "access.admin.allow")
+	v.cidrs(c.Access.Admin.Deny, "access.admin.deny")
+	v.cidrs(c.Access.Responder.Allow, "access.responder.allow")
+	v.cidrs(c.Access.Responder.Deny, "access.responder.deny")
+	v.check(c.RequestLimits.MaxBodyBytes > 0, "request_limits.max_body_bytes", "must be positive")
+	v.check(c.RequestLimits.MaxCertIDs > 0, "request_limits.max_cert_ids", "must be positive")
+	v.check(c.RequestLimits.MaxExtensions > 0, "request_limits.max_extensions", "must be positive")
+	v.check(c.RequestLimits.MaxExtensionBytes > 0, "request_limits.max_extension_bytes", "must be positive")
+	v.positive(c.Secrets.Timeout, "secrets.timeout")
+	v.check(c.Secrets.RefreshInterval >= 0, "secrets.refresh_interval", "must not be negative")
+	switch c.Secrets.Vault.Auth {
+	case "token", "kubernetes", "approle":
+	default:
+		v.check(false, "secrets.vault.auth", "must be token, kubernetes or approle, got %q", c.Secrets.Vault.Auth)
+	}
+	if c.Secrets.Vault.Address != "" {
+		v.url(c.Secrets.Vault.Address, "secrets.vault.address")
+	}
+	if c.Audit.Enabled {
+		v.required(c.Audit.SigningKeyPath, "audit.signing_key_path")
+		v.required(c.Audit.CertificatePath, "audit.certificate_path")
+	}
+	if c.Compromise.Enabled {
+		v.check(c.CRL.Enabled, "compromise.enabled", "requires crl.enabled, since the CRL signing key is the one switched out")
+		v.required(c.Compromise.StandbyCertPath, "compromise.standby_cert_path")
+		v.required(c.Compromise.StandbyKeyPath, "compromise.standby_key_path")
+		v.positive(c.Compromise.PollInterval, "compromise.poll_interval")
+	}
+	if c.Retention.Enabled {
+		v.positive(c.Retention.Interval, "retention.interval")
+		v.check(c.Retention.BatchSize > 0, "retention.batch_size", "must be positive")
+		v.check(c.Retention.Statuses >= 0, "retention.statuses", "must not be negative")
+		v.check(c.Retention.Audit >= 0, "retention.audit", "must not be negative")
+		v.check(c.Retention.Archive >= 0, "retention.archive", "must not be negative")
+		v.check(c.Retention.Statuses > 0 || c.Retention.Audit > 0 || c.Retention.Archive > 0, "retention", "set a period for at least one of statuses, audit and archive")
+		if c.Retention.Statuses > 0 {
+			v.check(c.CASync.Enabled, "retention.statuses", "requires ca_sync.enabled, which records certificate expiry")
+		}
+		if c.Retention.Archive > 0 {
+			v.check(c.Archive.Enabled, "retention.archive", "requires archive.enabled")
+		}
+	}
+	if c.Precomputed.Enabled {
+		v.check(!c.Presigned.Enabled, "precomputed.enabled", "must be false with presigned.enabled, which serves without a database")
+		v.check(strings.HasPrefix(c.Precomputed.Path, "/") && c.Precomputed.Path != "/", "precomputed.path", "must start with / and not be the root")
+		v.check(!c.CRL.Enabled || c.Precomputed.Path != c.CRL.Path, "precomputed.path", "must differ from crl.path")
+		v.required(c.Precomputed.IssuerCertPath, "precomputed.issuer_cert_path")
+		if c.Role != RoleResponder {
+			v.required(c.Precomputed.SigningKeyPath, "precomputed.signing_key_path")
+		}
+		v.positive(c.Precomputed.Validity, "precomputed.validity")
+		v.positive(c.Precomputed.RefreshBefore, "precomputed.refresh_before")
+		v.check(c.Precomputed.RefreshBefore < c.Precomputed.Validity, "precomputed.refresh_before", "must be shorter than precomputed.validity")
+		v.check(c.Precomputed.Jitter >= 0 && c.Precomputed.RefreshBefore+c.Precomputed.Jitter < c.Precomputed.Validity, "precomputed.jitter",
+			"must not be negative, and with precomputed.refresh_before must be shorter than precomputed.validity")
+		v.positive(c.Precomputed.Interval, "precomputed.interval")
+		v.check(c.Precomputed.BatchSize > 0, "precomputed.batch_size", "must be positive")
+		v.check(c.Precomputed.Concurrency > 0, "precomputed.concurrency", "must be positive")
+		for i, previous := range c.Precomputed.PreviousIssuers {
+			path := fmt.Sprintf("precomputed.previous_issuers[%d]", i)
+			v.required(previous.IssuerCertPath, path+".issuer_cert_path")
+			v.required(previous.SigningKeyPath, path+".signing_key_path")
+		}
+		if drift := c.Precomputed.Drift; drift.Enabled {
+			v.check(c.Role != RoleResponder, "precomputed.drift.enabled", "must be false in the responder role, which cannot re-sign")
+			v.positive(drift.Interval, "precomputed.drift.interval")
+			v.check(drift.SampleSize >= 0 && drift.RevokedSampleSize >= 0, "precomputed.drift", "sample sizes must not be negative")
+			v.check(drift.SampleSize > 0 || drift.RevokedSampleSize > 0, "precomputed.drift", "set sample_size, revoked_sample_size or both")
+			if drift.RevokedSampleSize > 0 {
+				v.positive(drift.Lookback, "precomputed.drift.lookback")
+			}
+			v.check(drift.Grace > c.Precomputed.Interval, "precomputed.drift.grace", "must be longer than precomputed.interval, so the refresher can catch up first")
+		}
+	}
+	if c.WriteBehind.Enabled {
+		v.check(len(c.WriteBehind.Statuses) > 0, "write_behind.statuses", "list at least one status")
+		for _, status := range c.WriteBehind.Statuses {
+			v.check(status == "good" || status == "revoked" || status == "unknown", "write_behind.statuses", "must be good, revoked or unknown, got %q", status)
+		}
+		v.check(c.WriteBehind.QueueSize > 0, "write_behind.queue_size", "must be positive")
+		v.check(c.WriteBehind.BatchSize > 0, "write_behind.batch_size", "must be positive")
+		v.positive(c.WriteBehind.FlushInterval, "write_behind.flush_interval")
+		v.positive(c.WriteBehind.EnqueueTimeout, "write_behind.enqueue_timeout")
+		v.check(c.WriteBehind.MaxAttempts > 0, "write_behind.max_attempts", "must be positive")
+	}
+	v.check(c.DatabasePool.MaxConns > 0, "database_pool.max_conns", "must be positive")
+	v.check(c.DatabasePool.MinConns >= 0 && c.DatabasePool.MinConns <= c.DatabasePool.MaxConns, "database_pool.min_conns", "must be between 0 and max_conns")
+	v.positive(c.DatabasePool.MaxConnLifetime, "database_pool.max_conn_lifetime")
+	v.check(c.DatabasePool.MaxConnLifetimeJitter >= 0 && c.DatabasePool.MaxConnLifetimeJitter < c.DatabasePool.MaxConnLifetime, "database_pool.max_conn_lifetime_jitter", "must be between 0 and max_conn_lifetime")
+	v.positive(c.DatabasePool.MaxConnIdleTime, "database_pool.max_conn_idle_time")
+	v.positive(c.DatabasePool.HealthCheckPeriod, "database_pool.health_check_period")
+	v.check(c.DatabasePool.StatementTimeout >= 0, "database_pool.statement_timeout", "must not be negative")
+	switch c.DatabasePool.QueryExecMode {
+	case "cache_statement", "cache_describe":
+		v.check(c.DatabasePool.StatementCacheSize > 0, "database_pool.statement_cache_size", "must be positive")
+	case "describe_exec", "simple_protocol":
+	default:
+		v.check(false, "database_pool.query_exec_mode", "must be cache_statement, cache_describe, describe_exec or simple_protocol")
+	}
+	if c.DatabasePool.PgBouncer {
+		// PgBouncer refuses unknown startup parameters, and a transaction-pooled server
+		// connection does not keep the session an advisory lock belongs to
+		v.check(c.DatabasePool.StatementTimeout == 0, "database_pool.statement_timeout", "must be unset with database_pool.pgbouncer; set statement_timeout on the database role instead")
+		v.check(!c.Leader.Enabled || c.Leader.Backend != "postgres", "leader.backend", "must not be postgres with database_pool.pgbouncer, as advisory locks need a session")
+	}
+	v.check(!c.GRPC.Gzip || c.GRPC.GzipLevel >= 1 && c.GRPC.GzipLevel <= 9, "grpc.gzip_level", "must be between 1 and 9")
+	v.check(c.GRPC.MaxRecvMessageSize > 0, "grpc.max_recv_message_size", "must be positive")
+	v.check(c.GRPC.MaxSendMessageSize > 0, "grpc.max_send_message_size", "must be positive")
+	v.check(c.GRPC.MaxConcurrentStreams > 0, "grpc.max_concurrent_streams", "must be positive")
+	v.positive(c.GRPC.Keepalive.Time, "grpc.keepalive.time")
+	v.positive(c.GRPC.Keepalive.Timeout, "grpc.keepalive.timeout")
+	v.check(c.GRPC.Keepalive.MinTime >= 0, "grpc.keepalive.min_time", "must not be negative")
+	v.check(c.GRPC.Keepalive.MaxConnectionIdle >= 0, "grpc.keepalive.max_connection_idle", "must not be negative")
+	v.check(c.GRPC.Keepalive.MaxConnectionAge >= 0, "grpc.keepalive.max_connection_age", "must not be negative")
+	v.check(c.GRPC.Keepalive.MaxConnectionAgeGrace >= 0, "grpc.keepalive.max_connection_age_grace", "must not be negative")
+	v.check(c.GRPC.Deadlines.CheckStatus >= 0, "grpc.deadlines.check_status", "must not be negative")
+	v.check(c.GRPC.Deadlines.UpdateStatus >= 0, "grpc.deadlines.update_status", "must not be negative")
+	v.check(c.GRPC.Deadlines.BatchUpdateStatus >= 0, "grpc.deadlines.batch_update_status", "must not be negative")
+	if c.LoadShedding.Enabled {
+		v.check(c.LoadShedding.MinLimit > 0, "load_shedding.min_limit", "must be positive")
+		v.check(c.LoadShedding.MaxLimit >= c.LoadShedding.MinLimit, "load_shedding.max_limit", "must be at least min_limit")
+		v.check(c.LoadShedding.InitialLimit >= c.LoadShedding.MinLimit && c.LoadShedding.InitialLimit <= c.LoadShedding.MaxLimit, "load_shedding.initial_limit", "must be between min_limit and max_limit")
+		v.positive(c.LoadShedding.LatencyTarget, "load_shedding.latency_target")
+		v.check(c.LoadShedding.Backoff > 0 && c.LoadShedding.Backoff < 1, "load_shedding.backoff", "must be between 0 and 1")
+	}
+	if c.Chaos.Enabled {
+		environment := strings.ToLower(strings.TrimSpace(c.Service.Environment))
+		v.check(environment != "" && environment != "production" && environment != "prod", "chaos.enabled",
+			"is refused in production; set service.environment to a non-production profile, got %q", c.Service.Environment)
+		v.check(!c.Presigned.Enabled, "chaos.enabled", "must be false with presigned.enabled, which serves without a database or signer")
+		v.positive(c.Chaos.DBLatency, "chaos.db_latency")
+		rate := func(rate float64, path string) {
+			v.check(rate >= 0 && rate <= 1, path, "must be between 0 and 1")
+		}
+		rate(c.Chaos.DBLatencyRate, "chaos.db_latency_rate")
+		rate(c.Chaos.DBErrorRate, "chaos.db_error_rate")
+		rate(c.Chaos.PartialBatchRate, "chaos.partial_batch_rate")
+		rate(c.Chaos.SignerErrorRate, "chaos.signer_error_rate")
+	}
+	if c.Shadow.Enabled {
+		v.check(!c.Presigned.Enabled, "shadow.enabled", "must be false with presigned.enabled")
+		v.check(c.Shadow.HTTPURL != "" || c.Shadow.GRPCAddress != "", "shadow", "set http_url, grpc_address or both")
+		if c.Shadow.HTTPURL != "" {
+			v.url(c.Shadow.HTTPURL, "shadow.http_url")
+			v.check(c.Precomputed.Enabled, "shadow.http_url", "requires precomputed.enabled, whose responder is mirrored")
+		}
+		v.check(c.Shadow.Percent > 0 && c.Shadow.Percent <= 100, "shadow.percent", "must be between 0 and 100")
+		v.positive(c.Shadow.Timeout, "shadow.timeout")
+		v.check(c.Shadow.MaxInFlight > 0, "shadow.max_in_flight", "must be positive")
+		v.tls(c.Shadow.TLS, "shadow.tls")
+	}
+	if c.SerialAliases.Enabled && len(c.SerialAliases.IssuerCertPaths) > 0 {
+		v.check(c.Precomputed.Enabled, "serial_aliases.issuer_cert_paths", "requires precomputed.enabled, whose responder resolves aliases")
+	}
+	if c.ShortLived.Enabled {
+		v.check(!c.Presigned.Enabled, "short_lived.enabled", "must be false with presigned.enabled, whose bundles cannot be signed on demand")
+		v.positive(c.ShortLived.Validity, "short_lived.validity")
+		v.check(len(c.ShortLived.Issuers) > 0, "short_lived.issuers", "at least one issuer is required")
+		for i, issuer := range c.ShortLived.Issuers {
+			path := fmt.Sprintf("short_lived.issuers[%d]", i)
+			v.required(issuer.IssuerCertPath, path+".issuer_cert_path")
+			v.check(len(issuer.Secret) >= shortserial.MinSecretSize, path+".secret", "must be at least %d bytes", shortserial.MinSecretSize)
+			v.positive(issuer.MaxLifetime, path+".max_lifetime")
+		}
+	}
+	if c.Watchdog.Enabled {
+		v.url(c.Watchdog.URL, "watchdog.url")
+		v.required(c.Watchdog.IssuerCertPath, "watchdog.issuer_cert_path")
+		v.check(len(c.Watchdog.Serials) > 0 || c.Watchdog.SampleSize > 0, "watchdog", "set serials, sample_size or both")
+		for i, serial := range c.Watchdog.Serials {
+			_, ok := new(big.Int).SetString(serial, 16)
+			v.check(ok, fmt.Sprintf("watchdog.serials[%d]", i), "must be a hex serial")
+		}
+		v.check(c.Watchdog.SampleSize >= 0, "watchdog.sample_size", "must not be negative")
+		if c.Watchdog.SampleSize > 0 {
+			v.positive(c.Watchdog.Lookback, "watchdog.lookback")
+		}
+		v.positive(c.Watchdog.Interval, "watchdog.interval")
+		v.positive(c.Watchdog.Timeout, "watchdog.timeout")
+	}
+	if c.Scheduled.Enabled {
+		v.positive(c.Scheduled.Interval, "scheduled_revocations.interval")
+		v.positive(c.Scheduled.MaxHorizon, "scheduled_revocations.max_horizon")
+		v.check(c.Scheduled.BatchSize > 0, "scheduled_revocations.batch_size", "must be positive")
+	}
+	if c.Holds.Enabled {
+		v.positive(c.Holds.Interval, "timed_holds.interval")
+		v.positive(c.Holds.MaxDuration, "timed_holds.max_duration")
+		v.check(c.Holds.BatchSize > 0, "timed_holds.batch_size", "must be positive")
+	}
+	if c.Extensions.Enabled {
+		v.check(c.Precomputed.Enabled, "response_extensions.enabled", "requires precomputed.enabled, whose responses carry them")
+		for i, ext := range c.Extensions.Static {
+			path := fmt.Sprintf("response_extensions.static[%d]", i)
+			_, err := ocspext.ParseOID(ext.OID)
+			v.check(err == nil, path+".oid", "must be a dotted OID, got %q", ext.OID)
+			value, err := hex.DecodeString(ext.Value)
+			v.check(err == nil && len(value) > 0, path+".value", "must be the DER value in hex")
+			v.check(ext.Scope == "" || ext.Scope == "single" || ext.Scope == "response", path+".scope", "must be single or response")
+		}
+		for i, builder := range c.Extensions.Builders {
+			v.required(builder.Name, fmt.Sprintf("response_extensions.builders[%d].name", i))
+		}
+		if c.Extensions.CRLID.Enabled {
+			v.check(c.CRL.Enabled, "response_extensions.crl_id.enabled", "requires crl.enabled, whose CRLs it names")
+			if c.Extensions.CRLID.URL != "" {
+				v.url(c.Extensions.CRLID.URL, "response_extensions.crl_id.url")
+			}
+		}
+	}
+	if c.Expired.Enabled {
+		v.check(c.Precomputed.Enabled, "expired_certificates.enabled", "requires precomputed.enabled, whose responses it applies to")
+		v.expiredPolicy(c.Expired.Policy, c.Expired.Grace, c.Expired.ArchiveRetention, "expired_certificates")
+		for i, issuer := range c.Expired.Issuers {
+			path := fmt.Sprintf("expired_certificates.issuers[%d]", i)
+			v.required(issuer.IssuerCertPath, path+".issuer_cert_path")
+			v.expiredPolicy(issuer.Policy, issuer.Grace, issuer.ArchiveRetention, path)
+		}
+	}
+	if c.ResponseLog.Enabled {
+		v.check(c.Precomputed.Enabled, "response_log.enabled", "requires precomputed.enabled, whose responses it records")
+	}
+	if c.TopRequests.Enabled {
+		v.positive(c.TopRequests.Bucket, "top_requests.bucket")
+		v.check(len(c.TopRequests.Windows) > 0, "top_requests.windows", "list at least one window")
+		for i, window := range c.TopRequests.Windows {
+			v.check(window > 0 && c.TopRequests.Bucket > 0 && window%c.TopRequests.Bucket == 0 && window/c.TopRequests.Bucket <= 1440,
+				fmt.Sprintf("top_requests.windows[%d]", i), "must be a multiple of top_requests.bucket, at most 1440 of them")
+		}
+		v.check(c.TopRequests.Capacity > 0, "top_requests.capacity", "must be positive")
+		v.check(c.TopRequests.MetricsTop >= 0 && c.TopRequests.MetricsTop <= c.TopRequests.Capacity, "top_requests.metrics_top",
+			"must be between 0 and top_requests.capacity")
+		if c.TopRequests.MetricsTop > 0 {
+			v.positive(c.TopRequests.PublishInterval, "top_requests.publish_interval")
+		}
+	}
+	if c.Notifications.Enabled {
+		v.check(!c.Presigned.Enabled, "change_notifications.enabled", "must be false with presigned.enabled, which serves without a database")
+		v.check(!c.DatabasePool.PgBouncer, "change_notifications.enabled", "cannot listen for notifications through PgBouncer; unset database_pool.pgbouncer")
+	}
+	if c.APIKeys.Enabled {
+		v.check(!c.Presigned.Enabled, "api_keys.enabled", "must be false with presigned.enabled, which serves without a database")
+		v.check(c.Role != RoleResponder, "api_keys.enabled", "must be false in the responder role, which serves no mutations")
+		v.positive(c.APIKeys.CacheTTL, "api_keys.cache_ttl")
+		v.check(c.APIKeys.RotationGrace >= 0, "api_keys.rotation_grace", "must not be negative")
+	}
+	if c.Metering.Enabled {
+		v.check(c.Metering.Period >= time.Minute, "metering.period", "must be at least 1m")
+		if c.Metering.S3.Bucket != "" {
+			v.required(c.Metering.S3.Region, "metering.s3.region")
+			if c.Metering.S3.Endpoint != "" {
+				v.url(c.Metering.S3.Endpoint, "metering.s3.endpoint")
+			}
+		}
+	}
+	if c.Cassandra.Enabled {
+		v.check(!c.Presigned.Enabled, "cassandra.enabled", "must be false with presigned.enabled, which serves without a database")
+		v.check(!c.Sharding.Enabled, "cassandra.enabled", "must be false with sharding.enabled; statuses live in one or the other")
+		v.check(len(c.Cassandra.Hosts) > 0, "cassandra.hosts", "list at least one node")
+		v.check(cqlIdentifier(c.Cassandra.Keyspace), "cassandra.keyspace", "must be a CQL identifier of letters, digits and underscores, got %q", c.Cassandra.Keyspace)
+		v.tls(c.Cassandra.TLS, "cassandra.tls")
+		v.positive(c.Cassandra.ConnectTimeout, "cassandra.connect_timeout")
+		v.check(c.Cassandra.BatchSize > 0, "cassandra.batch_size", "must be positive")
+		v.consistency(c.Cassandra.Consistency.Read, "cassandra.consistency.read", false)
+		v.consistency(c.Cassandra.Consistency.Write, "cassandra.consistency.write", false)
+		v.consistency(c.Cassandra.Consistency.Batch, "cassandra.consistency.batch", false)
+		v.consistency(c.Cassandra.Consistency.Scan, "cassandra.consistency.scan", false)
+		v.consistency(c.Cassandra.Consistency.Serial, "cassandra.consistency.serial", true)
+		v.check(!strings.EqualFold(c.Cassandra.Consistency.Read, "any"), "cassandra.consistency.read", "any only applies to writes")
+		v.check(!strings.EqualFold(c.Cassandra.Consistency.Scan, "any"), "cassandra.consistency.scan", "any only applies to writes")
+		// These read ocsp_responses in the main database directly, or write to it in the same
+		// transaction as a status change
+		v.check(!c.Backup.Enabled, "backup.enabled", "must be false with cassandra.enabled; use ocsp backup")
+		v.check(!c.Guardrails.Enabled, "guardrails.enabled", "must be false with cassandra.enabled")
+		v.check(!c.Reports.Enabled, "reports.enabled", "must be false with cassandra.enabled")
+		v.check(!c.Precomputed.Enabled, "precomputed.enabled", "must be false with cassandra.enabled")
+		v.check(!c.Retention.Enabled || c.Retention.Statuses == 0, "retention.statuses", "must be 0 with cassandra.enabled")
+		v.check(!c.Notifications.Enabled, "change_notifications.enabled", "must be false with cassandra.enabled, whose writes send no database notifications")
+		v.check(!c.Events.Outbox.Enabled, "events.outbox.enabled", "must be false with cassandra.enabled, which cannot write events in the same transaction")
+	}
+	if c.Spanner.Enabled {
+		v.check(!c.Presigned.Enabled, "spanner.enabled", "must be false with presigned.enabled, which serves without a database")
+		v.check(!c.Sharding.Enabled && !c.Cassandra.Enabled, "spanner.enabled", "must be false with sharding.enabled or cassandra.enabled; statuses live in one place")
+		parts := strings.Split(c.Spanner.Database, "/")
+		v.check(len(parts) == 6 && parts[0] == "projects" && parts[2] == "instances" && parts[4] == "databases" && parts[1] != "" && parts[3] != "" && parts[5] != "",
+			"spanner.database", "must be projects/<project>/instances/<instance>/databases/<database>, got %q", c.Spanner.Database)
+		v.url(c.Spanner.Endpoint, "spanner.endpoint")
+		v.check(c.Spanner.Sessions > 0, "spanner.sessions", "must be positive")
+		v.check(c.Spanner.Staleness >= 0, "spanner.staleness", "must not be negative")
+		// A commit holds at most 80000 mutated cells, and a batch row writes up to 7
+		v.check(c.Spanner.BatchSize > 0 && c.Spanner.BatchSize <= 10000, "spanner.batch_size", "must be between 1 and 10000")
+		v.positive(c.Spanner.Timeout, "spanner.timeout")
+		// These read ocsp_responses in the main database directly, or write to it in the same
+		// transaction as a status change
+		v.check(!c.Backup.Enabled, "backup.enabled", "must be false with spanner.enabled; use ocsp backup")
+		v.check(!c.Guardrails.Enabled, "guardrails.enabled", "must be false with spanner.enabled")
+		v.check(!c.Reports.Enabled, "reports.enabled", "must be false with spanner.enabled")
+		v.check(!c.Precomputed.Enabled, "precomputed.enabled", "must be false with spanner.enabled")
+		v.check(!c.Retention.Enabled || c.Retention.Statuses == 0, "retention.statuses", "must be 0 with spanner.enabled")
+		v.check(!c.Notifications.Enabled, "change_notifications.enabled", "must be false with spanner.enabled, whose writes send no database notifications")
+		v.check(!c.Events.Outbox.Enabled, "events.outbox.enabled", "must be false with spanner.enabled, which cannot write events in the same transaction")
+	}
+	if c.Discovery.Enabled {
+		switch c.Discovery.Provider {
+		case "consul":
+			v.url(c.Discovery.Consul.Address, "discovery.consul.address")
+			v.positive(c.Discovery.Consul.DeregisterAfter, "discovery.consul.deregister_after")
+			v.tls(c.Discovery.Consul.TLS, "discovery.consul.tls")
+		case "etcd":
+			v.check(len(c.Discovery.Etcd.Endpoints) > 0, "discovery.etcd.endpoints", "list at least one endpoint")
+			for i, endpoint := range c.Discovery.Etcd.Endpoints {
+				v.url(endpoint, fmt.Sprintf("discovery.etcd.endpoints[%d]", i))
+			}
+			v.check(strings.HasPrefix(c.Discovery.Etcd.Prefix, "/") && strings.HasSuffix(c.Discovery.Etcd.Prefix, "/"), "discovery.etcd.prefix", "must start and end with /, got %q", c.Discovery.Etcd.Prefix)
+			v.check(c.Discovery.Etcd.Password == "" || c.Discovery.Etcd.Username != "", "discovery.etcd.username", "is required with discovery.etcd.password")
+			v.tls(c.Discovery.Etcd.TLS, "discovery.etcd.tls")
+		default:
+			v.check(false, "discovery.provider", "must be consul or etcd, got %q", c.Discovery.Provider)
+		}
+		v.check(!strings.Contains(c.Discovery.InstanceID, "/"), "discovery.instance_id", "must not contain /")
+		v.positive(c.Discovery.Interval, "discovery.interval")
+		// A renewal may be a little late without the registration lapsing
+		v.check(c.Discovery.TTL >= 2*c.Discovery.Interval, "discovery.ttl", "must be at least twice discovery.interval")
+		// etcd leases count whole seconds
+		v.check(c.Discovery.TTL%time.Second == 0, "discovery.ttl", "must be a whole number of seconds")
+	}
+	if c.CoAP.Enabled {
+		v.check(c.Role != RoleWriter, "coap.enabled", "must be false in the writer role, which answers no RFC 6960 requests")
+		v.check(c.Precomputed.Enabled || c.Presigned.Enabled, "coap.enabled", "requires precomputed.enabled or presigned.enabled, the responder it fronts")
+		v.port(c.CoAP.Port, "coap.port")
+		size := c.CoAP.BlockSize
+		v.check(size >= 16 && size <= 1024 && size&(size-1) == 0, "coap.block_size", "must be a power of two from 16 to 1024, got %d", size)
+		v.check(c.CoAP.MaxInFlight > 0, "coap.max_in_flight", "must be positive")
+		v.positive(c.CoAP.Timeout, "coap.timeout")
+	}
+	if c.SigningWatch.Enabled {
+		v.check(c.Precomputed.Enabled, "signing_watch.enabled", "requires precomputed.enabled, whose keys it watches")
+		v.positive(c.SigningWatch.Threshold, "signing_watch.threshold")
+		v.positive(c.SigningWatch.Window, "signing_watch.window")
+		v.check(c.SigningWatch.MinSamples > 0, "signing_watch.min_samples", "must be positive")
+		v.positive(c.SigningWatch.StallTimeout, "signing_watch.stall_timeout")
+		v.positive(c.SigningWatch.Interval, "signing_watch.interval")
+		v.check(c.SigningWatch.Interval < c.SigningWatch.Window, "signing_watch.interval", "must be shorter than signing_watch.window")
+	}
+	if c.SelfCheck.Enabled {
+		v.check(c.Role != RoleWriter, "selfcheck.enabled", "must be false in the writer role, which answers no RFC 6960 requests")
+		v.check(c.Precomputed.Enabled, "selfcheck.enabled", "requires precomputed.enabled, the responder it checks")
+		v.check(strings.HasPrefix(c.SelfCheck.Path, "/"), "selfcheck.path", "must start with /")
+		responderPath := strings.TrimSuffix(c.Precomputed.Path, "/")
+		v.check(c.SelfCheck.Path != responderPath && !strings.HasPrefix(c.SelfCheck.Path, responderPath+"/"), "selfcheck.path", "must be outside precomputed.path, where the responder answers")
+		serial, ok := new(big.Int).SetString(c.SelfCheck.Serial, 16)
+		v.check(ok && serial.Sign() > 0, "selfcheck.serial", "must be the hex serial of a canary certificate")
+		v.positive(c.SelfCheck.Timeout, "selfcheck.timeout")
+		v.check(c.SelfCheck.CacheFor >= 0, "selfcheck.cache_for", "must not be negative")
+	}
+	if c.Preflight.Enabled {
+		v.positive(c.Preflight.Timeout, "preflight.timeout")
+	}
+	if c.Failover.Enabled {
+		v.check(c.Role != RoleResponder, "database_failover.enabled", "must be false in the responder role, which writes no statuses")
+		v.check(!c.Presigned.Enabled, "database_failover.enabled", "must be false with presigned.enabled, which serves without a database")
+		v.check(!c.Sharding.Enabled && !c.Cassandra.Enabled && !c.Spanner.Enabled, "database_failover.enabled",
+			"requires statuses in the main database, not in shards, Cassandra or Spanner")
+		v.check(c.Failover.QueueSize > 0, "database_failover.queue_size", "must be positive")
+		v.positive(c.Failover.MaxWait, "database_failover.max_wait")
+		v.positive(c.Failover.ProbeInterval, "database_failover.probe_interval")
+	}
+	if c.Tunables.Enabled {
+		v.check(c.Role != RoleResponder, "tunables.enabled", "must be false in the responder role, which has no database write access")
+		v.check(!c.Presigned.Enabled, "tunables.enabled", "must be false with presigned.enabled, which serves without a database")
+	}
+	if c.Sharding.Enabled {
+		v.check(!c.Presigned.Enabled, "sharding.enabled", "must be false with presigned.enabled, which serves without a database")
+		v.check(len(c.Sharding.Shards) > 0, "sharding.shards", "list at least one shard")
+		names := make(map[string]bool)
+		for i, shard := range append(append([]ShardConfig{}, c.Sharding.Shards...), c.Sharding.Retiring...) {
+			path := fmt.Sprintf("sharding.shards[%d]", i)
+			if i >= len(c.Sharding.Shards) {
+				path = fmt.Sprintf("sharding.retiring[%d]", i-len(c.Sharding.Shards))
+			}
+			v.required(shard.Name, path+".name")
+			v.check(!names[shard.Name], path+".name", "duplicate shard name %q", shard.Name)
+			names[shard.Name] = true
+			v.required(shard.Host, path+".host")
+			v.port(shard.Port, path+".port")
+		}
+		v.check(len(c.Sharding.Retiring) == 0 || c.Sharding.Resharding, "sharding.retiring", "requires sharding.resharding until the shards are drained")
+		v.positive(c.Sharding.HealthInterval, "sharding.health_interval")
+		// These read ocsp_responses in the main database directly
+		v.check(!c.Backup.Enabled, "backup.enabled", "must be false with sharding.enabled; use ocsp backup")
+		v.check(!c.Guardrails.Enabled, "guardrails.enabled", "must be false with sharding.enabled")
+		v.check(!c.Reports.Enabled, "reports.enabled", "must be false with sharding.enabled")
+		v.check(!c.Precomputed.Enabled, "precomputed.enabled", "must be false with sharding.enabled")
+		v.check(!c.Retention.Enabled || c.Retention.Statuses == 0, "retention.statuses", "must be 0 with sharding.enabled")
+	}
+	if c.NonceReplay.Enabled {
+		v.positive(c.NonceReplay.Window, "nonce_replay.window")
+		v.check(c.NonceReplay.MaxEntries > 0, "nonce_replay.max_entries", "must be positive")
+		v.check(c.NonceReplay.MaxPerClient > 0, "nonce_replay.max_per_client", "must be positive")
+		v.check(c.Precomputed.Enabled, "nonce_replay.enabled", "needs precomputed.enabled; presigned responses cannot echo nonces")
+	}
+	if c.Guardrails.Enabled {
+		v.positive(c.Guardrails.Window, "guardrails.window")
+		v.check(c.Guardrails.MaxRevocations >= 0, "guardrails.max_revocations", "must not be negative")
+		v.check(c.Guardrails.MaxPercent >= 0 && c.Guardrails.MaxPercent <= 100, "guardrails.max_percent", "must be between 0 and 100")
+		v.check(c.Guardrails.MaxRevocations > 0 || c.Guardrails.MaxPercent > 0, "guardrails", "set max_revocations, max_percent or both")
+		v.check(c.Guardrails.Action == "block" || c.Guardrails.Action == "confirm", "guardrails.action", "must be block or confirm, got %q", c.Guardrails.Action)
+	}
+	if c.Approvals.Enabled {
+		v.required(c.Approvals.PrincipalsPath, "approvals.principals_path")
+		v.check(len(c.Approvals.Approvers) >= 2, "approvals.approvers", "must name at least two principals so a requester can be approved by someone else")
+		v.positive(c.Approvals.TTL, "approvals.ttl")
+		v.check(c.Approvals.MaxBatch >= 0, "approvals.max_batch", "must not be negative")
+	}
+	v.check(c.RequestLimits.MaxConcurrentPerSource >= 0, "request_limits.max_concurrent_per_source", "must not be negative")
+	for name, rule := range c.FeatureFlags.Rules {
+		v.check(rule.Percent >= 0 && rule.Percent <= 100, fmt.Sprintf("feature_flags.rules.%s.percent", name), "must be between 0 and 100")
+	}
+	if c.FeatureFlags.Database.Enabled {
+		v.positive(c.FeatureFlags.Database.RefreshInterval, "feature_flags.database.refresh_interval")
+	}
+	if c.Replication.Enabled {
+		v.check(c.Events.NATS.Enabled, "replication.enabled", "requires events.nats.enabled")
+		v.required(c.Replication.Region, "replication.region")
+		v.check(!strings.ContainsAny(c.Replication.Region, ".*> "), "replication.region", "must not contain '.', '*', '>' or spaces")
+		v.required(c.Replication.Stream, "replication.stream")
+		v.required(c.Replication.SubjectPrefix, "replication.subject_prefix")
+	}
+
+	return errors.Join(v.errs...)
+}
+
+func (c *Config) validateCRL(v *validator) {
+	if c.CRL.Enabled {
+		v.required(c.CRL.IssuerCertPath, "crl.issuer_cert_path")
+		v.required(c.CRL.IssuerKeyPath, "crl.issuer_key_path")
+		v.positive(c.CRL.Interval, "crl.interval")
+		v.positive(c.CRL.Validity, "crl.validity")
+		v.check(c.CRL.Validity >= c.CRL.Interval, "crl.validity", "must not be shorter than crl.interval")
+		v.check(strings.HasPrefix(c.CRL.Path, "/"), "crl.path", "must start with /")
+		if c.CRL.Delta.Enabled {
+			v.positive(c.CRL.Delta.Interval, "crl.delta.interval")
+			v.positive(c.CRL.Delta.Validity, "crl.delta.validity")
+			v.check(c.CRL.Delta.Interval < c.CRL.Interval, "crl.delta.interval", "must be shorter than crl.interval")
+		}
+		if c.CRL.IDP.Enabled {
+			v.check(!(c.CRL.IDP.OnlyUserCerts && c.CRL.IDP.OnlyCACerts), "crl.idp",
+				"only_user_certs and only_ca_certs are mutually exclusive")
+		}
+		v.check(c.CRL.Partitions.Count >= 0, "crl.partitions.count", "must not be negative")
+		if c.CRL.Partitions.Count > 1 {
+			v.url(c.CRL.Partitions.BaseURL, "crl.partitions.base_url")
+		}
+	}
+
+	if c.CRLSync.Enabled {
+		v.positive(c.CRLSync.Interval, "crl_sync.interval")
+		v.check(len(c.CRLSync.Sources) > 0, "crl_sync.sources", "at least one source is required")
+		for i, src := range c.CRLSync.Sources {
+			path := fmt.Sprintf("crl_sync.sources[%d].url", i)
+			v.url(src.URL, path)
+			if u, err := url.Parse(src.URL); err == nil && (u.Scheme == "ldap" || u.Scheme == "ldaps") {
+				_, err := ldap.ParseURL(src.URL)
+				v.check(err == nil, path, "%v", err)
+			}
+		}
+	}
+	ldapConfig := c.CRLImport.LDAP
+	v.positive(ldapConfig.Timeout, "crl_import.ldap.timeout")
+	v.check(ldapConfig.Password == "" || ldapConfig.BindDN != "", "crl_import.ldap.bind_dn", "is required with crl_import.ldap.password")
+	v.tls(ldapConfig.TLS, "crl_import.ldap.tls")
+}
+
+func (c *Config) validateEvents(v *validator) {
+	kafka := c.Events.Kafka
+	if kafka.Enabled {
+		v.check(len(kafka.Brokers) > 0, "events.kafka.brokers", "at least one broker is required")
+		v.required(kafka.Topic, "events.kafka.topic")
+		v.tls(kafka.TLS, "events.kafka.tls")
+		switch kafka.SASL.Mechanism {
+		case "", "plain", "scram-sha-256", "scram-sha-512":
+		default:
+			v.check(false, "events.kafka.sasl.mechanism", "must be plain, scram-sha-256 or scram-sha-512")
+		}
+	}
+
+	nats := c.Events.NATS
+	if nats.Enabled {
+		v.required(nats.URL, "events.nats.url")
+		v.required(nats.Subject, "events.nats.subject")
+		v.tls(nats.TLS, "events.nats.tls")
+		if nats.Consumer.Enabled {
+			v.required(nats.Consumer.Stream, "events.nats.consumer.stream")
+			v.required(nats.Consumer.Subject, "events.nats.consumer.subject")
+			v.required(nats.Consumer.Durable, "events.nats.consumer.durable")
+		}
+	}
+	v.check(!nats.Consumer.Enabled || nats.Enabled, "events.nats.consumer.enabled", "requires events.nats.enabled")
+
+	for i, webhook := range c.Events.Webhooks {
+		path := fmt.Sprintf("events.webhooks[%d]", i)
+		v.url(webhook.URL, path+".url")
+		v.required(webhook.Secret, path+".secret")
+		v.check(webhook.MaxBackoff == 0 || webhook.MaxBackoff >= webhook.InitialBackoff, path+".max_backoff",
+			"must not be shorter than initial_backoff")
+	}
+
+	if outbox := c.Events.Outbox; outbox.Enabled {
+		v.positive(outbox.PollInterval, "events.outbox.poll_interval")
+		v.check(outbox.BatchSize > 0, "events.outbox.batch_size", "must be positive")
+		v.positive(outbox.Retention, "events.outbox.retention")
+	}
+}
+
+// cqlIdentifier reports whether name can be used unquoted as a CQL keyspace name
+func cqlIdentifier(name string) bool {
+	if name == "" || len(name) > 48 {
+		return false
+	}
+	for i, r := range name {
+		letter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+		if !letter && r != '_' && !(i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// dnsSubdomain reports whether name is a lowercase RFC 1123 subdomain, as Kubernetes object
+// names must be
+func dnsSubdomain(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package crl
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+)
+
+var (
+	oidDeltaCRLIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+	oidFreshestCRL       = asn1.ObjectIdentifier{2, 5, 29, 46}
+	oidIssuingDistPoint  = asn1.ObjectIdentifier{2, 5, 29, 28}
+)
+
+// distributionPointName and distributionPoint mirror the RFC 5280 structures used by x509
+type distributionPointName struct {
+	FullName []asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+type distributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+}
+
+// issuingDistPoint mirrors the RFC 5280 IssuingDistributionPoint structure; false booleans
+// are omitted as DER requires for DEFAULT FALSE fields
+type issuingDistPoint struct {
+	DistributionPoint     distributionPointName `asn1:"optional,tag:0"`
+	OnlyContainsUserCerts bool                  `asn1:"optional,tag:1"`
+	OnlyContainsCACerts   bool                  `asn1:"optional,tag:2"`
+}
+
+// uriGeneralName encodes a URI as a GeneralName uniformResourceIdentifier
+func uriGeneralName(uri string) asn1.RawValue {
+	return asn1.RawValue{Tag: 6, Class: asn1.ClassContextSpecific, Bytes: []byte(uri)}
+}
+
+// deltaCRLIndicator returns the critical extension marking a CRL as a delta of the given base
+func deltaCRLIndicator(base *big.Int) (pkix.Extension, error) {
+	value, err := asn1.Marshal(base)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidDeltaCRLIndicator, Critical: true, Value: value}, nil
+}
+
+// freshestCRL returns the extension pointing base CRL consumers at the delta CRL location
+func freshestCRL(uris ...string) (pkix.Extension, error) {
+	names := make([]asn1.RawValue, 0, len(uris))
+	for _, uri := range uris {
+		names = append(names, uriGeneralName(uri))
+	}
+
+	value, err := asn1.Marshal([]distributionPoint{{
+		DistributionPoint: distributionPointName{FullName: names},
+	}})
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidFreshestCRL, Value: value}, nil
+}
+
+// IssuingDistributionPoint describes the scope of a CRL
+type IssuingDistributionPoint struct {
+	URIs          []string
+	OnlyUserCerts bool
+	OnlyCACerts   bool
+}
+
+// extension returns the critical Issuing Distribution Point extension
+func (idp IssuingDistributionPoint) extension() (pkix.Extension, error) {
+	if idp.OnlyUserCerts && idp.OnlyCACerts {
+		return pkix.Extension{}, fmt.Errorf("a CRL cannot be scoped to both user and CA certificates")
+	}
+
+	names := make([]asn1.RawValue, 0, len(idp.URIs))
+	for _, uri := range idp.URIs {
+		names = append(names, uriGeneralName(uri))
+	}
+
+	value, err := asn1.Marshal(issuingDistPoint{
+		DistributionPoint:     distributionPointName{FullName: names},
+		OnlyContainsUserCerts: idp.OnlyUserCerts,
+		OnlyContainsCACerts:   idp.OnlyCACerts,
+	})
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidIssuingDistPoint, Critical: true, Value: value}, nil
+}
@@ -0,0 +1,331 @@
+package crl
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/gigvault/shared/pkg/models"
+	"go.uber.org/zap"
+)
+
+// CRL is a generated, signed revocation list
+type CRL struct {
+	Number     *big.Int
+	BaseNumber *big.Int // set on delta CRLs
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	Entries    int
+	DER        []byte
+
+	// revoked maps each listed serial to its reason, so deltas can be computed against a base
+	revoked map[string]string
+}
+
+// IsDelta reports whether the CRL is a delta CRL
+func (c *CRL) IsDelta() bool {
+	return c.BaseNumber != nil
+}
+
+// PEM returns the CRL in PEM encoding
+func (c *CRL) PEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: c.DER})
+}
+
+// GeneratorOptions configures CRL validity and delta CRL references
+type GeneratorOptions struct {
+	Validity      time.Duration
+	DeltaValidity time.Duration
+	// DeltaURL is advertised in base CRLs through the Freshest CRL extension
+	DeltaURL string
+	// Numbers persists CRL numbers per issuer; without it numbers are derived from the clock
+	Numbers storage.CRLNumbers
+	// IDP, when set, is added to full and delta CRLs to declare their scope
+	IDP *IssuingDistributionPoint
+	// Partitions splits revocations across that many CRLs by serial number; Partition selects
+	// the one this generator covers
+	Partitions int
+	Partition  int
+}
+
+// PartitionOf returns the CRL partition covering a serial number
+func PartitionOf(serial *big.Int, partitions int) int {
+	if partitions <= 1 {
+		return 0
+	}
+	return int(new(big.Int).Mod(serial, big.NewInt(int64(partitions))).Int64())
+}
+
+// Generator builds full and delta CRLs from the status store
+type Generator struct {
+	store  storage.Store
+	opts   GeneratorOptions
+	logger *logger.Logger
+
+	// issuance is swapped as a whole on reload so a CRL is never signed with a mix of settings
+	issuance atomic.Pointer[issuance]
+
+	mu         sync.Mutex
+	lastNumber int64
+}
+
+// issuance is the signing key and validity a CRL is generated with
+type issuance struct {
+	issuer        *x509.Certificate
+	signer        crypto.Signer
+	issuerID      string
+	validity      time.Duration
+	deltaValidity time.Duration
+}
+
+// NewGenerator creates a CRL generator signing with the issuer's key
+func NewGenerator(store storage.Store, issuer *x509.Certificate, signer crypto.Signer, opts GeneratorOptions, logger *logger.Logger) *Generator {
+	g := &Generator{
+		store:  store,
+		opts:   opts,
+		logger: logger,
+	}
+	g.Reload(issuer, signer, opts.Validity, opts.DeltaValidity)
+	return g
+}
+
+// Reload replaces the issuer, signing key and validity periods for subsequent CRLs. A new
+// issuer name or key starts a new CRL number sequence
+func (g *Generator) Reload(issuer *x509.Certificate, signer crypto.Signer, validity, deltaValidity time.Duration) {
+	g.issuance.Store(&issuance{
+		issuer:        issuer,
+		signer:        signer,
+		issuerID:      issuerID(issuer, g.opts),
+		validity:      validity,
+		deltaValidity: deltaValidity,
+	})
+}
+
+// issuerID identifies an issuer by name and key, so a re-keyed CA starts a new numbering
+// sequence; each partition is a separate CRL scope with its own sequence
+func issuerID(issuer *x509.Certificate, opts GeneratorOptions) string {
+	h := sha256.New()
+	h.Write(issuer.RawSubject)
+	h.Write(issuer.RawSubjectPublicKeyInfo)
+	if opts.Partitions > 1 {
+		fmt.Fprintf(h, "partition:%d", opts.Partition)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Generate builds and signs a full CRL covering every revoked serial
+func (g *Generator) Generate(ctx context.Context) (*CRL, error) {
+	records, err := g.listRevoked(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	iss := g.issuance.Load()
+	now := time.Now().UTC().Truncate(time.Second)
+	number, err := g.nextNumber(ctx, iss.issuerID, now)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.RevocationList{
+		Number:                    number,
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(iss.validity),
+		RevokedCertificateEntries: g.entries(records),
+	}
+	if err := g.addIDP(template); err != nil {
+		return nil, err
+	}
+	if g.opts.DeltaURL != "" {
+		ext, err := freshestCRL(g.opts.DeltaURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode freshest CRL extension: %w", err)
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, ext)
+	}
+
+	crl, err := g.sign(iss, template)
+	if err != nil {
+		return nil, err
+	}
+	crl.revoked = reasonsBySerial(records)
+	return crl, nil
+}
+
+// GenerateDelta builds a delta CRL listing changes since the base: new or changed
+// revocations, and removeFromCRL entries for serials no longer revoked
+func (g *Generator) GenerateDelta(ctx context.Context, base *CRL) (*CRL, error) {
+	records, err := g.listRevoked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current := reasonsBySerial(records)
+
+	var changed []storage.Record
+	for _, rec := range records {
+		if reason, ok := base.revoked[rec.Serial]; !ok || reason != rec.RevocationReason {
+			changed = append(changed, rec)
+		}
+	}
+	entries := g.entries(changed)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for serial := range base.revoked {
+		if _, ok := current[serial]; ok {
+			continue
+		}
+		if n, ok := new(big.Int).SetString(serial, 16); ok {
+			entries = append(entries, x509.RevocationListEntry{
+				SerialNumber:   n,
+				RevocationTime: now,
+				ReasonCode:     models.ReasonRemoveFromCRL,
+			})
+		}
+	}
+
+	indicator, err := deltaCRLIndicator(base.Number)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode delta CRL indicator: %w", err)
+	}
+
+	iss := g.issuance.Load()
+	number, err := g.nextNumber(ctx, iss.issuerID, now)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.RevocationList{
+		Number:                    number,
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(iss.deltaValidity),
+		RevokedCertificateEntries: entries,
+		ExtraExtensions:           []pkix.Extension{indicator},
+	}
+	// A delta must carry the same scope as its base
+	if err := g.addIDP(template); err != nil {
+		return nil, err
+	}
+
+	crl, err := g.sign(iss, template)
+	if err != nil {
+		return nil, err
+	}
+	crl.BaseNumber = base.Number
+	return crl, nil
+}
+
+// listRevoked returns the revoked records within this generator's partition
+func (g *Generator) listRevoked(ctx context.Context) ([]storage.Record, error) {
+	records, err := g.store.ListRevoked(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revoked certificates: %w", err)
+	}
+	if g.opts.Partitions <= 1 {
+		return records, nil
+	}
+
+	var scoped []storage.Record
+	for _, rec := range records {
+		serial, ok := new(big.Int).SetString(rec.Serial, 16)
+		if ok && PartitionOf(serial, g.opts.Partitions) == g.opts.Partition {
+			scoped = append(scoped, rec)
+		}
+	}
+	return scoped, nil
+}
+
+func (g *Generator) sign(iss *issuance, template *x509.RevocationList) (*CRL, error) {
+	der, err := x509.CreateRevocationList(rand.Reader, template, iss.issuer, iss.signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign CRL: %w", err)
+	}
+
+	return &CRL{
+		Number:     template.Number,
+		ThisUpdate: template.ThisUpdate,
+		NextUpdate: template.NextUpdate,
+		Entries:    len(template.RevokedCertificateEntries),
+		DER:        der,
+	}, nil
+}
+
+func (g *Generator) addIDP(template *x509.RevocationList) error {
+	if g.opts.IDP == nil {
+		return nil
+	}
+	ext, err := g.opts.IDP.extension()
+	if err != nil {
+		return fmt.Errorf("failed to encode issuing distribution point extension: %w", err)
+	}
+	template.ExtraExtensions = append(template.ExtraExtensions, ext)
+	return nil
+}
+
+// nextNumber allocates the next persisted CRL number for the issuer. Without a number store
+// it derives numbers from the generation time, bumping past the last issued number when two
+// CRLs share a second
+func (g *Generator) nextNumber(ctx context.Context, issuerID string, now time.Time) (*big.Int, error) {
+	if g.opts.Numbers != nil {
+		n, err := g.opts.Numbers.NextCRLNumber(ctx, issuerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate CRL number: %w", err)
+		}
+		return big.NewInt(n), nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	n := now.Unix()
+	if n <= g.lastNumber {
+		n = g.lastNumber + 1
+	}
+	g.lastNumber = n
+	return big.NewInt(n), nil
+}
+
+func reasonsBySerial(records []storage.Record) map[string]string {
+	reasons := make(map[string]string, len(records))
+	for _, rec := range records {
+		reasons[rec.Serial] = rec.RevocationReason
+	}
+	return reasons
+}
+
+func (g *Generator) entries(records []storage.Record) []x509.RevocationListEntry {
+	entries := make([]x509.RevocationListEntry, 0, len(records))
+	for _, rec := range records {
+		serial, ok := new(big.Int).SetString(rec.Serial, 16)
+		if !ok {
+			g.logger.Warn("Skipping revoked entry with non-hex serial", zap.String("serial", rec.Serial))
+			continue
+		}
+
+		revokedAt := rec.ThisUpdate
+		if rec.RevokedAt != nil {
+			revokedAt = *rec.RevokedAt
+		}
+
+		entry := x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: revokedAt.UTC(),
+		}
+		// Reason codes are only encoded when meaningful; unspecified is omitted per RFC 5280
+		if code, ok := revocation.ReasonCode(rec.RevocationReason); ok && code != 0 {
+			entry.ReasonCode = code
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
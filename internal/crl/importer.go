@@ -0,0 +1,268 @@
+package crl
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/dryrun"
+	"github.com/gigvault/ocsp/internal/ldap"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// maxCRLSize bounds the size of a CRL read from a URL or upload
+const maxCRLSize = 256 << 20
+
+// ImportResult summarizes an applied CRL import
+type ImportResult struct {
+	Issuer     string    `json:"issuer"`
+	Number     string    `json:"crl_number,omitempty"`
+	ThisUpdate time.Time `json:"this_update"`
+	NextUpdate time.Time `json:"next_update"`
+	Revoked    int       `json:"revoked"`
+	Released   int       `json:"released"`
+}
+
+// Importer maps CRL entries to revoked statuses and applies them as one batch
+type Importer struct {
+	store  storage.Store
+	client *http.Client
+	ldap   *ldap.Client
+	logger *logger.Logger
+
+	mu     sync.RWMutex
+	issuer *x509.Certificate
+}
+
+// NewImporter creates a CRL importer; when issuer is non-nil every CRL signature is verified against it
+func NewImporter(store storage.Store, issuer *x509.Certificate, logger *logger.Logger) *Importer {
+	return &Importer{
+		store:  store,
+		issuer: issuer,
+		client: &http.Client{Timeout: 60 * time.Second},
+		ldap:   ldap.NewClient("", "", false, nil, 60*time.Second),
+		logger: logger,
+	}
+}
+
+// ImportFile imports a PEM or DER encoded CRL from disk
+func (i *Importer) ImportFile(ctx context.Context, path string) (*ImportResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL file: %w", err)
+	}
+	return i.Import(ctx, data)
+}
+
+// ImportURL downloads and imports a CRL
+func (i *Importer) ImportURL(ctx context.Context, url string) (*ImportResult, error) {
+	data, err := i.Fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return i.Import(ctx, data)
+}
+
+// SetLDAP replaces the client CRLs at ldap:// and ldaps:// URLs are read with, which searches
+// anonymously by default
+func (i *Importer) SetLDAP(client *ldap.Client) {
+	i.ldap = client
+}
+
+// Fetch downloads a CRL without applying it. ldap:// and ldaps:// URLs (RFC 4516) are read
+// from the directory: the first value of the first attribute the URL names, in order, found
+// on any entry the search returns
+func (i *Importer) Fetch(ctx context.Context, url string) ([]byte, error) {
+	if IsLDAP(url) {
+		return i.fetchLDAP(ctx, url)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CRL URL: %w", err)
+	}
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download CRL: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download CRL: status %d", resp.StatusCode)
+	}
+
+	return ReadLimited(resp.Body)
+}
+
+// IsLDAP reports whether a CRL location is an LDAP URL
+func IsLDAP(url string) bool {
+	lower := strings.ToLower(url)
+	return strings.HasPrefix(lower, "ldap://") || strings.HasPrefix(lower, "ldaps://")
+}
+
+func (i *Importer) fetchLDAP(ctx context.Context, raw string) ([]byte, error) {
+	u, err := ldap.ParseURL(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CRL URL: %w", err)
+	}
+	entries, err := i.ldap.Search(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL from LDAP: %w", err)
+	}
+	for _, attr := range u.Attributes {
+		for _, entry := range entries {
+			for _, value := range entry.Values(attr) {
+				if len(value) == 0 {
+					continue
+				}
+				if len(value) > maxCRLSize {
+					return nil, fmt.Errorf("CRL exceeds maximum size of %d bytes", maxCRLSize)
+				}
+				return value, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("failed to read CRL from LDAP: %d entries found, none with %s", len(entries), strings.Join(u.Attributes, " or "))
+}
+
+// Import parses, verifies, and applies a CRL
+func (i *Importer) Import(ctx context.Context, data []byte) (*ImportResult, error) {
+	list, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.Verify(list); err != nil {
+		return nil, err
+	}
+	return i.Apply(ctx, list)
+}
+
+// SetIssuer replaces the certificate imported CRLs are verified against; nil disables verification
+func (i *Importer) SetIssuer(issuer *x509.Certificate) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.issuer = issuer
+}
+
+// Verify checks the CRL signature against the configured issuer, if any
+func (i *Importer) Verify(list *x509.RevocationList) error {
+	i.mu.RLock()
+	issuer := i.issuer
+	i.mu.RUnlock()
+
+	if issuer == nil {
+		return nil
+	}
+	if err := list.CheckSignatureFrom(issuer); err != nil {
+		return fmt.Errorf("CRL signature verification failed: %w", err)
+	}
+	return nil
+}
+
+// Apply maps the CRL entries to statuses and applies them atomically
+func (i *Importer) Apply(ctx context.Context, list *x509.RevocationList) (*ImportResult, error) {
+	updates, result := Updates(list)
+	if err := i.store.ApplyBatch(ctx, updates); err != nil {
+		return nil, fmt.Errorf("failed to apply CRL entries: %w", err)
+	}
+
+	i.logger.Info("CRL imported",
+		zap.String("issuer", result.Issuer),
+		zap.String("crl_number", result.Number),
+		zap.Int("revoked", result.Revoked),
+		zap.Int("released", result.Released),
+	)
+
+	return result, nil
+}
+
+// DryRunResult is what applying a CRL would do, without it having been applied
+type DryRunResult struct {
+	*ImportResult
+	DryRun bool           `json:"dry_run"`
+	Plan   *dryrun.Report `json:"plan"`
+}
+
+// DryRun maps the CRL entries to statuses like Apply and reports how each would change the
+// status database, without writing anything
+func (i *Importer) DryRun(ctx context.Context, list *x509.RevocationList) (*DryRunResult, error) {
+	updates, result := Updates(list)
+	plan, err := dryrun.Plan(ctx, i.store, updates)
+	if err != nil {
+		return nil, fmt.Errorf("failed to plan CRL entries: %w", err)
+	}
+	return &DryRunResult{ImportResult: result, DryRun: true, Plan: plan}, nil
+}
+
+// Parse decodes a PEM or DER encoded CRL
+func Parse(data []byte) (*x509.RevocationList, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "X509 CRL" {
+			return nil, fmt.Errorf("unexpected PEM block type %q", block.Type)
+		}
+		data = block.Bytes
+	}
+
+	list, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CRL: %w", err)
+	}
+	return list, nil
+}
+
+// Updates maps CRL entries to status updates; removeFromCRL entries release the serial back to good
+func Updates(list *x509.RevocationList) ([]storage.Update, *ImportResult) {
+	result := &ImportResult{
+		Issuer:     list.Issuer.String(),
+		ThisUpdate: list.ThisUpdate,
+		NextUpdate: list.NextUpdate,
+	}
+	if list.Number != nil {
+		result.Number = list.Number.String()
+	}
+
+	updates := make([]storage.Update, 0, len(list.RevokedCertificateEntries))
+	for _, entry := range list.RevokedCertificateEntries {
+		serial := entry.SerialNumber.Text(16)
+		reason := revocation.ReasonName(entry.ReasonCode)
+
+		if reason == revocation.ReasonRemoveFromCRL {
+			updates = append(updates, storage.Update{Serial: serial, Status: storage.StatusGood})
+			result.Released++
+			continue
+		}
+
+		revokedAt := entry.RevocationTime
+		updates = append(updates, storage.Update{
+			Serial:           serial,
+			Status:           storage.StatusRevoked,
+			RevokedAt:        &revokedAt,
+			RevocationReason: reason,
+		})
+		result.Revoked++
+	}
+
+	return updates, result
+}
+
+// ReadLimited reads a CRL body, rejecting inputs larger than the import limit
+func ReadLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxCRLSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL: %w", err)
+	}
+	if len(data) > maxCRLSize {
+		return nil, fmt.Errorf("CRL exceeds maximum size of %d bytes", maxCRLSize)
+	}
+	return data, nil
+}
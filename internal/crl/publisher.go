@@ -0,0 +1,194 @@
+package crl
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Publisher regenerates the CRL on a schedule and serves the latest one over HTTP
+type Publisher struct {
+	generator     *Generator
+	interval      time.Duration
+	deltaInterval time.Duration
+	logger        *logger.Logger
+
+	mu      sync.RWMutex
+	current *CRL
+	delta   *CRL
+
+	hooks []func(context.Context, *CRL)
+}
+
+// NewPublisher creates a new CRL publisher; a positive deltaInterval also publishes delta CRLs
+func NewPublisher(generator *Generator, interval, deltaInterval time.Duration, logger *logger.Logger) *Publisher {
+	return &Publisher{
+		generator:     generator,
+		interval:      interval,
+		deltaInterval: deltaInterval,
+		logger:        logger,
+	}
+}
+
+// OnPublish registers fn to be called with every full and delta CRL after it starts being
+// served. Hooks run synchronously on the generation loop and must not be registered after Run
+func (p *Publisher) OnPublish(fn func(ctx context.Context, crl *CRL)) {
+	p.hooks = append(p.hooks, fn)
+}
+
+// Run regenerates the CRL immediately and then on every interval until the context is cancelled
+func (p *Publisher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	var deltaTick <-chan time.Time
+	if p.deltaInterval > 0 {
+		deltaTicker := time.NewTicker(p.deltaInterval)
+		defer deltaTicker.Stop()
+		deltaTick = deltaTicker.C
+	}
+
+	if _, err := p.Refresh(ctx); err != nil {
+		p.logger.Error("Failed to generate CRL", zap.Error(err))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := p.Refresh(ctx); err != nil {
+				p.logger.Error("Failed to generate CRL", zap.Error(err))
+			}
+		case <-deltaTick:
+			if _, err := p.RefreshDelta(ctx); err != nil {
+				p.logger.Error("Failed to generate delta CRL", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Refresh generates a new CRL and makes it the one being served
+func (p *Publisher) Refresh(ctx context.Context) (*CRL, error) {
+	crl, err := p.generator.Generate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.current = crl
+	// A new base supersedes the previous delta until the next delta is generated
+	p.delta = nil
+	p.mu.Unlock()
+
+	p.logger.Info("CRL generated",
+		zap.String("crl_number", crl.Number.String()),
+		zap.Int("entries", crl.Entries),
+		zap.Time("next_update", crl.NextUpdate),
+	)
+	p.notify(ctx, crl)
+	return crl, nil
+}
+
+// Reload switches the generator to a new issuer, key and validity and immediately publishes a
+// full CRL with them, so no delta is ever issued against a base from the previous issuer
+func (p *Publisher) Reload(ctx context.Context, issuer *x509.Certificate, signer crypto.Signer, validity, deltaValidity time.Duration) error {
+	p.generator.Reload(issuer, signer, validity, deltaValidity)
+	_, err := p.Refresh(ctx)
+	return err
+}
+
+// RefreshDelta generates a delta CRL against the current base CRL
+func (p *Publisher) RefreshDelta(ctx context.Context) (*CRL, error) {
+	base := p.Current()
+	if base == nil {
+		return nil, fmt.Errorf("no base CRL available")
+	}
+
+	delta, err := p.generator.GenerateDelta(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	// Discard the delta if a new base was published while it was being generated
+	current := p.current == base
+	if current {
+		p.delta = delta
+	}
+	p.mu.Unlock()
+
+	p.logger.Info("Delta CRL generated",
+		zap.String("crl_number", delta.Number.String()),
+		zap.String("base_crl_number", base.Number.String()),
+		zap.Int("entries", delta.Entries),
+	)
+	if current {
+		p.notify(ctx, delta)
+	}
+	return delta, nil
+}
+
+func (p *Publisher) notify(ctx context.Context, crl *CRL) {
+	for _, fn := range p.hooks {
+		fn(ctx, crl)
+	}
+}
+
+// Current returns the CRL being served, or nil before the first generation
+func (p *Publisher) Current() *CRL {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.current
+}
+
+// CurrentDelta returns the delta CRL being served, or nil if none has been generated for the current base
+func (p *Publisher) CurrentDelta() *CRL {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.delta
+}
+
+// ServeHTTP serves the current CRL as DER, or as PEM when the path ends in .pem
+func (p *Publisher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	serveCRL(w, r, p.Current())
+}
+
+// DeltaHandler serves the current delta CRL
+func (p *Publisher) DeltaHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveCRL(w, r, p.CurrentDelta())
+	})
+}
+
+func serveCRL(w http.ResponseWriter, r *http.Request, crl *CRL) {
+	if crl == nil {
+		http.Error(w, "CRL not yet available", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, contentType := crl.DER, "application/pkix-crl"
+	if strings.HasSuffix(r.URL.Path, ".pem") {
+		body, contentType = crl.PEM(), "application/x-pem-file"
+	}
+
+	maxAge := int(time.Until(crl.NextUpdate).Seconds())
+	if maxAge < 0 {
+		maxAge = 0
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d, no-transform, must-revalidate", maxAge))
+	w.Header().Set("Last-Modified", crl.ThisUpdate.Format(http.TimeFormat))
+	w.Header().Set("Expires", crl.NextUpdate.Format(http.TimeFormat))
+	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, crl.Number.Text(16)))
+	w.Write(body)
+}
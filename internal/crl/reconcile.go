@@ -0,0 +1,135 @@
+package crl
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Discrepancy kinds
+const (
+	// DiscrepancyNotRevoked is a serial revoked in the CRL but not in the status database
+	DiscrepancyNotRevoked = "revoked_in_crl_only"
+	// DiscrepancyNotInCRL is a serial revoked in the status database but absent from the CRL
+	DiscrepancyNotInCRL = "revoked_locally_only"
+	// DiscrepancyReason is a serial revoked in both with different reasons
+	DiscrepancyReason = "reason_mismatch"
+)
+
+// Discrepancy is a serial whose status differs between the CRL and the status database
+type Discrepancy struct {
+	Serial      string `json:"serial"`
+	Kind        string `json:"kind"`
+	LocalStatus string `json:"local_status"`
+	LocalReason string `json:"local_reason,omitempty"`
+	CRLReason   string `json:"crl_reason,omitempty"`
+}
+
+// Reconciliation reports how a CRL compares against the status database
+type Reconciliation struct {
+	Issuer        string        `json:"issuer"`
+	Number        string        `json:"crl_number,omitempty"`
+	ThisUpdate    time.Time     `json:"this_update"`
+	CRLEntries    int           `json:"crl_entries"`
+	LocalRevoked  int           `json:"local_revoked"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+// Reconcile compares a full CRL against the status database without changing either
+func (i *Importer) Reconcile(ctx context.Context, list *x509.RevocationList) (*Reconciliation, error) {
+	for _, ext := range list.Extensions {
+		if ext.Id.Equal(oidDeltaCRLIndicator) {
+			return nil, fmt.Errorf("cannot reconcile against a delta CRL")
+		}
+	}
+
+	revoked, err := i.store.ListRevoked(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local revocations: %w", err)
+	}
+	local := reasonsBySerial(revoked)
+
+	result := &Reconciliation{
+		Issuer:        list.Issuer.String(),
+		ThisUpdate:    list.ThisUpdate,
+		CRLEntries:    len(list.RevokedCertificateEntries),
+		LocalRevoked:  len(revoked),
+		Discrepancies: []Discrepancy{},
+	}
+	if list.Number != nil {
+		result.Number = list.Number.String()
+	}
+
+	listed := make(map[string]bool, len(list.RevokedCertificateEntries))
+	for _, entry := range list.RevokedCertificateEntries {
+		serial := entry.SerialNumber.Text(16)
+		reason := revocation.ReasonName(entry.ReasonCode)
+		if reason == revocation.ReasonRemoveFromCRL {
+			continue
+		}
+		listed[serial] = true
+
+		localReason, ok := local[serial]
+		if ok {
+			if localReason != reason {
+				result.Discrepancies = append(result.Discrepancies, Discrepancy{
+					Serial:      serial,
+					Kind:        DiscrepancyReason,
+					LocalStatus: storage.StatusRevoked,
+					LocalReason: localReason,
+					CRLReason:   reason,
+				})
+			}
+			continue
+		}
+
+		status, err := i.localStatus(ctx, serial)
+		if err != nil {
+			return nil, err
+		}
+		result.Discrepancies = append(result.Discrepancies, Discrepancy{
+			Serial:      serial,
+			Kind:        DiscrepancyNotRevoked,
+			LocalStatus: status,
+			CRLReason:   reason,
+		})
+	}
+
+	for _, rec := range revoked {
+		if !listed[rec.Serial] {
+			result.Discrepancies = append(result.Discrepancies, Discrepancy{
+				Serial:      rec.Serial,
+				Kind:        DiscrepancyNotInCRL,
+				LocalStatus: storage.StatusRevoked,
+				LocalReason: rec.RevocationReason,
+			})
+		}
+	}
+
+	if len(result.Discrepancies) > 0 {
+		i.logger.Warn("CRL and status database disagree",
+			zap.String("issuer", result.Issuer),
+			zap.String("crl_number", result.Number),
+			zap.Int("discrepancies", len(result.Discrepancies)),
+		)
+	}
+	return result, nil
+}
+
+// localStatus returns the stored status for a serial, or unknown when it has none
+func (i *Importer) localStatus(ctx context.Context, serial string) (string, error) {
+	rec, err := i.store.Get(ctx, serial)
+	if errors.Is(err, storage.ErrNotFound) {
+		return storage.StatusUnknown, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up %s: %w", serial, err)
+	}
+	return rec.Status, nil
+}
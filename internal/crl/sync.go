@@ -0,0 +1,196 @@
+package crl
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	upstreamUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "upstream_crl_up",
+		Help:      "Whether the last fetch of an upstream CRL succeeded (1) or failed (0).",
+	}, []string{"url"})
+	upstreamExpired = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "upstream_crl_expired",
+		Help:      "Whether the last fetched upstream CRL is past its nextUpdate.",
+	}, []string{"url"})
+	upstreamNextUpdate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "upstream_crl_next_update_timestamp_seconds",
+		Help:      "nextUpdate of the last fetched upstream CRL.",
+	}, []string{"url"})
+	upstreamApplied = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "upstream_crl_applied_entries_total",
+		Help:      "Number of status changes applied from upstream CRLs.",
+	}, []string{"url"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(upstreamUp, upstreamExpired, upstreamNextUpdate, upstreamApplied)
+}
+
+// Source is an upstream CRL distribution point
+type Source struct {
+	URL string
+	// Issuer, when set, must have signed the CRL
+	Issuer *x509.Certificate
+}
+
+// Syncer periodically fetches upstream CRLs and applies revocations missing locally
+type Syncer struct {
+	importer *Importer
+	store    storage.Store
+	interval time.Duration
+	logger   *logger.Logger
+
+	// lastNumber is only touched by the sync loop
+	lastNumber map[string]string
+
+	mu      sync.Mutex
+	sources []Source
+}
+
+// NewSyncer creates a new upstream CRL syncer
+func NewSyncer(importer *Importer, store storage.Store, sources []Source, interval time.Duration, logger *logger.Logger) *Syncer {
+	return &Syncer{
+		importer:   importer,
+		store:      store,
+		sources:    sources,
+		interval:   interval,
+		logger:     logger,
+		lastNumber: make(map[string]string),
+	}
+}
+
+// Run syncs every source immediately and then on every interval until the context is cancelled
+func (s *Syncer) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		for _, src := range s.Sources() {
+			if err := s.SyncSource(ctx, src); err != nil {
+				s.logger.Error("Upstream CRL sync failed", zap.String("url", src.URL), zap.Error(err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sources returns the CRLs currently being synced
+func (s *Syncer) Sources() []Source {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sources
+}
+
+// SetSources replaces the synced CRLs from the next run on
+func (s *Syncer) SetSources(sources []Source) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sources = sources
+}
+
+// SyncSource fetches one upstream CRL and applies entries that differ from local state
+func (s *Syncer) SyncSource(ctx context.Context, src Source) error {
+	data, err := s.importer.Fetch(ctx, src.URL)
+	if err != nil {
+		upstreamUp.WithLabelValues(src.URL).Set(0)
+		return err
+	}
+
+	list, err := Parse(data)
+	if err != nil {
+		upstreamUp.WithLabelValues(src.URL).Set(0)
+		return err
+	}
+	if src.Issuer != nil {
+		if err := list.CheckSignatureFrom(src.Issuer); err != nil {
+			upstreamUp.WithLabelValues(src.URL).Set(0)
+			return fmt.Errorf("CRL signature verification failed: %w", err)
+		}
+	}
+	upstreamUp.WithLabelValues(src.URL).Set(1)
+
+	upstreamNextUpdate.WithLabelValues(src.URL).Set(float64(list.NextUpdate.Unix()))
+	if !list.NextUpdate.IsZero() && time.Now().After(list.NextUpdate) {
+		upstreamExpired.WithLabelValues(src.URL).Set(1)
+		s.logger.Error("Upstream CRL is expired",
+			zap.String("url", src.URL),
+			zap.Time("next_update", list.NextUpdate),
+		)
+	} else {
+		upstreamExpired.WithLabelValues(src.URL).Set(0)
+	}
+
+	number := ""
+	if list.Number != nil {
+		number = list.Number.String()
+		if s.lastNumber[src.URL] == number {
+			return nil
+		}
+	}
+
+	updates, err := s.diff(ctx, list)
+	if err != nil {
+		return err
+	}
+	if len(updates) > 0 {
+		if err := s.store.ApplyBatch(ctx, updates); err != nil {
+			return fmt.Errorf("failed to apply upstream CRL entries: %w", err)
+		}
+		upstreamApplied.WithLabelValues(src.URL).Add(float64(len(updates)))
+	}
+	s.lastNumber[src.URL] = number
+
+	s.logger.Info("Upstream CRL synced",
+		zap.String("url", src.URL),
+		zap.String("crl_number", number),
+		zap.Int("applied", len(updates)),
+	)
+	return nil
+}
+
+// diff returns the CRL updates whose outcome differs from the stored revocations
+func (s *Syncer) diff(ctx context.Context, list *x509.RevocationList) ([]storage.Update, error) {
+	revoked, err := s.store.ListRevoked(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list local revocations: %w", err)
+	}
+	local := reasonsBySerial(revoked)
+
+	all, _ := Updates(list)
+	var updates []storage.Update
+	for _, update := range all {
+		reason, isRevoked := local[update.Serial]
+		switch update.Status {
+		case storage.StatusRevoked:
+			if isRevoked && reason == update.RevocationReason {
+				continue
+			}
+		case storage.StatusGood:
+			if !isRevoked {
+				continue
+			}
+		}
+		updates = append(updates, update)
+	}
+	return updates, nil
+}
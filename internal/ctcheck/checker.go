@@ -0,0 +1,201 @@
+// Package ctcheck cross-checks new revocations against Certificate Transparency search services
+package ctcheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/anomaly"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// maxPending bounds serials whose lookups failed and are retried on the next run
+const maxPending = 10000
+
+var (
+	checked = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "ct_revocation_checks_total",
+		Help:      "Revoked serials checked against Certificate Transparency, by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(checked)
+}
+
+// Source lists recently revoked serials
+type Source interface {
+	ListRevokedSince(ctx context.Context, since time.Time) ([]storage.Record, error)
+}
+
+// Options configures the checker
+type Options struct {
+	// SearchURLs are CT search endpoints with a {serial} placeholder, such as
+	// https://crt.sh/?serial={serial}&output=json; a serial found in any of them is logged
+	SearchURLs []string
+	Interval   time.Duration
+	// Lookback is how far back the first run looks for revocations
+	Lookback time.Duration
+}
+
+// Checker periodically looks up newly revoked serials in CT and alerts on serials never logged
+type Checker struct {
+	source Source
+	opts   Options
+	client *http.Client
+	hooks  []anomaly.Hook
+	logger *logger.Logger
+
+	since   time.Time
+	pending map[string]bool
+}
+
+// NewChecker creates a CT cross-checker that reports unlogged revocations to hooks
+func NewChecker(source Source, opts Options, logger *logger.Logger, hooks ...anomaly.Hook) *Checker {
+	return &Checker{
+		source:  source,
+		opts:    opts,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		hooks:   hooks,
+		logger:  logger,
+		since:   time.Now().Add(-opts.Lookback),
+		pending: make(map[string]bool),
+	}
+}
+
+// Run checks immediately and then on every interval until the context is cancelled
+func (c *Checker) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.Check(ctx); err != nil {
+			c.logger.Error("CT cross-check failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check looks up every serial revoked since the previous check, plus earlier failed lookups
+func (c *Checker) Check(ctx context.Context) error {
+	started := time.Now()
+	records, err := c.source.ListRevokedSince(ctx, c.since)
+	if err != nil {
+		return fmt.Errorf("failed to list recent revocations: %w", err)
+	}
+	c.since = started
+
+	serials := make(map[string]bool, len(records)+len(c.pending))
+	for serial := range c.pending {
+		serials[serial] = true
+	}
+	for _, rec := range records {
+		serials[rec.Serial] = true
+	}
+
+	for serial := range serials {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		found, err := c.lookup(ctx, serial)
+		if err != nil {
+			checked.WithLabelValues("error").Inc()
+			if len(c.pending) < maxPending {
+				c.pending[serial] = true
+			}
+			c.logger.Warn("CT lookup failed", zap.String("serial", serial), zap.Error(err))
+			continue
+		}
+		delete(c.pending, serial)
+
+		if found {
+			checked.WithLabelValues("logged").Inc()
+			continue
+		}
+		checked.WithLabelValues("unlogged").Inc()
+		c.fire(ctx, serial)
+	}
+	return nil
+}
+
+// lookup reports whether any search service knows a certificate with the serial
+func (c *Checker) lookup(ctx context.Context, serial string) (bool, error) {
+	var lastErr error
+	for _, template := range c.opts.SearchURLs {
+		found, err := c.search(ctx, strings.ReplaceAll(template, "{serial}", serial))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if found {
+			return true, nil
+		}
+	}
+	// Only report a serial as unlogged when every service answered
+	if lastErr != nil {
+		return false, lastErr
+	}
+	return false, nil
+}
+
+// search treats 404, an empty body, or an empty JSON array or null as not found
+func (c *Checker) search(ctx context.Context, url string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("CT search returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return false, err
+	}
+	var results []json.RawMessage
+	if err := json.Unmarshal(body, &results); err == nil {
+		return len(results) > 0, nil
+	}
+	trimmed := strings.TrimSpace(string(body))
+	return trimmed != "" && trimmed != "null", nil
+}
+
+func (c *Checker) fire(ctx context.Context, serial string) {
+	alert := anomaly.Alert{
+		Kind:    anomaly.KindUnloggedRevocation,
+		Message: fmt.Sprintf("revoked serial %s was not found in any configured CT log", serial),
+		Source:  serial,
+		Count:   1,
+		FiredAt: time.Now(),
+	}
+	for _, hook := range c.hooks {
+		hook.Fire(ctx, alert)
+	}
+}
@@ -0,0 +1,215 @@
+// Package deadletter keeps the status rows a bulk import, gRPC batch or write-behind flush could
+// not apply, as submitted and with the error, so they can be corrected and replayed instead of
+// being lost. Items wait as pending until they are replayed successfully or discarded
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Item states
+const (
+	StatePending   = "pending"
+	StateReplayed  = "replayed"
+	StateDiscarded = "discarded"
+)
+
+// MaxLimit bounds the items returned by one listing
+const MaxLimit = 1000
+
+var (
+	// ErrNotFound is returned for unknown item IDs
+	ErrNotFound = errors.New("dead letter not found")
+	// ErrNotPending is returned when an item was already replayed or discarded
+	ErrNotPending = errors.New("dead letter is no longer pending")
+	// ErrInvalid is wrapped by the errors for rows that still do not validate
+	ErrInvalid = errors.New("invalid dead letter row")
+)
+
+var outcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "dead_letters_total",
+	Help:      "Dead-lettered status rows, by outcome: recorded, replayed, failed (a replay) or discarded.",
+}, []string{"outcome"})
+
+func init() {
+	metrics.Registry.MustRegister(outcomes)
+}
+
+// Item is a row that could not be applied
+type Item struct {
+	ID     int64  `json:"id"`
+	State  string `json:"state"`
+	Source string `json:"source"`
+	// Line is the row's line in the upload, or its position in the gRPC batch
+	Line int      `json:"line,omitempty"`
+	Row  bulk.Row `json:"row"`
+	// Error is why the row last failed, and Attempts how often it has failed
+	Error       string     `json:"error"`
+	Attempts    int        `json:"attempts"`
+	FailedAt    time.Time  `json:"failed_at"`
+	SubmittedBy string     `json:"submitted_by,omitempty"`
+	DecidedBy   string     `json:"decided_by,omitempty"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+}
+
+// Filter selects items to list
+type Filter struct {
+	// State and Source, when set, keep items with that value
+	State, Source string
+	// AfterID continues a listing after the last item of the previous page
+	AfterID int64
+	Limit   int
+}
+
+// Summary counts the outcomes of replaying pending items
+type Summary struct {
+	Replayed int `json:"replayed"`
+	// Staged counts rows the approval policy staged for a second approval when replayed
+	Staged int `json:"staged"`
+	Failed int `json:"failed"`
+}
+
+// Queue records dead letters and replays them into the status store
+type Queue struct {
+	db     *Postgres
+	store  storage.Store
+	logger *logger.Logger
+}
+
+// NewQueue creates a dead letter queue replaying into store
+func NewQueue(db *Postgres, store storage.Store, logger *logger.Logger) *Queue {
+	return &Queue{db: db, store: store, logger: logger}
+}
+
+// Add records failures from source as pending items, submitted by the principal in ctx
+func (q *Queue) Add(ctx context.Context, source string, failures []bulk.Failure) error {
+	if len(failures) == 0 {
+		return nil
+	}
+	if err := q.db.Insert(ctx, source, failures, approval.PrincipalFrom(ctx)); err != nil {
+		return err
+	}
+	outcomes.WithLabelValues("recorded").Add(float64(len(failures)))
+	q.logger.Warn("Dead-lettered status rows", zap.String("source", source), zap.Int("count", len(failures)))
+	return nil
+}
+
+// Get returns one item
+func (q *Queue) Get(ctx context.Context, id int64) (*Item, error) {
+	return q.db.Get(ctx, id)
+}
+
+// List returns the items matching filter, oldest first
+func (q *Queue) List(ctx context.Context, filter Filter) ([]Item, error) {
+	return q.db.List(ctx, filter)
+}
+
+// Fix replaces the row of a pending item with a corrected one, which must validate
+func (q *Queue) Fix(ctx context.Context, id int64, row bulk.Row) (*Item, error) {
+	if _, err := bulk.Normalize(row); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if err := q.db.Fix(ctx, id, row); err != nil {
+		return nil, err
+	}
+	return q.db.Get(ctx, id)
+}
+
+// Discard gives up on a pending item on behalf of the principal in ctx
+func (q *Queue) Discard(ctx context.Context, id int64) (*Item, error) {
+	if err := q.db.Transition(ctx, id, StateDiscarded, approval.PrincipalFrom(ctx)); err != nil {
+		return nil, err
+	}
+	outcomes.WithLabelValues("discarded").Inc()
+	q.logger.Info("Discarded dead letter", zap.Int64("id", id), zap.String("discarded_by", approval.PrincipalFrom(ctx)))
+	return q.db.Get(ctx, id)
+}
+
+// Replay applies a pending item's row through the status store, like any other write. A row
+// the approval policy stages counts as replayed, since the approval request now holds it, and
+// the staging error is returned with the item. On failure the item stays pending with the error
+func (q *Queue) Replay(ctx context.Context, id int64) (*Item, error) {
+	item, err := q.db.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if item.State != StatePending {
+		return nil, ErrNotPending
+	}
+
+	update, err := bulk.Normalize(item.Row)
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrInvalid, err)
+	} else {
+		err = q.store.Upsert(ctx, update)
+	}
+	var pending *approval.PendingError
+	if err != nil && !errors.As(err, &pending) {
+		if recordErr := q.db.Failed(ctx, id, err.Error()); recordErr != nil {
+			q.logger.Error("Failed to record failed dead letter replay", zap.Int64("id", id), zap.Error(recordErr))
+		}
+		outcomes.WithLabelValues("failed").Inc()
+		return nil, err
+	}
+
+	if transitionErr := q.db.Transition(ctx, id, StateReplayed, approval.PrincipalFrom(ctx)); transitionErr != nil {
+		return nil, transitionErr
+	}
+	outcomes.WithLabelValues("replayed").Inc()
+	q.logger.Info("Replayed dead letter", zap.Int64("id", id), zap.String("serial", update.Serial), zap.Bool("staged", pending != nil))
+	item, getErr := q.db.Get(ctx, id)
+	if getErr != nil {
+		return nil, getErr
+	}
+	return item, err
+}
+
+// ReplayPending replays up to limit pending items, oldest first, from source or from every
+// source when it is empty. Rows that still do not validate are counted and skipped; any other
+// failure, such as read-only mode or a revocation limit, stops the replay
+func (q *Queue) ReplayPending(ctx context.Context, source string, limit int) (Summary, error) {
+	var summary Summary
+	filter := Filter{State: StatePending, Source: source}
+	for done := 0; done < limit; {
+		filter.Limit = min(limit-done, MaxLimit)
+		items, err := q.db.List(ctx, filter)
+		if err != nil {
+			return summary, err
+		}
+		for _, item := range items {
+			_, err := q.Replay(ctx, item.ID)
+			var pending *approval.PendingError
+			switch {
+			case err == nil:
+				summary.Replayed++
+			case errors.As(err, &pending):
+				summary.Staged++
+			case errors.Is(err, ErrInvalid):
+				summary.Failed++
+			case errors.Is(err, ErrNotPending):
+				// Replayed or discarded by someone else meanwhile
+			default:
+				summary.Failed++
+				return summary, err
+			}
+		}
+		if len(items) < filter.Limit {
+			break
+		}
+		done += len(items)
+		filter.AfterID = items[len(items)-1].ID
+	}
+	return summary, nil
+}
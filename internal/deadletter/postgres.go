@@ -0,0 +1,124 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var columns = []string{"source", "line", "serial", "status", "reason", "date", "error", "submitted_by"}
+
+// Postgres keeps dead letters in the dead_letters table so every replica sees them
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgres creates a Postgres dead letter store
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Insert copies failures into the table as pending items
+func (p *Postgres) Insert(ctx context.Context, source string, failures []bulk.Failure, submittedBy string) error {
+	rows := make([][]interface{}, len(failures))
+	for i, f := range failures {
+		rows[i] = []interface{}{source, f.Line, f.Row.Serial, f.Row.Status, f.Row.Reason, f.Row.Date, f.Error, submittedBy}
+	}
+	_, err := p.db.CopyFrom(ctx, pgx.Identifier{"dead_letters"}, columns, pgx.CopyFromRows(rows))
+	return err
+}
+
+const selectItem = `SELECT id, state, source, line, serial, status, reason, date, error, attempts, failed_at, submitted_by, COALESCE(decided_by, ''), decided_at FROM dead_letters`
+
+// Get returns one item, or ErrNotFound
+func (p *Postgres) Get(ctx context.Context, id int64) (*Item, error) {
+	item, err := scanItem(p.db.QueryRow(ctx, selectItem+` WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return item, err
+}
+
+// List returns up to filter.Limit items matching filter, oldest first
+func (p *Postgres) List(ctx context.Context, filter Filter) ([]Item, error) {
+	rows, err := p.db.Query(ctx, selectItem+`
+		WHERE ($1 = '' OR state = $1) AND ($2 = '' OR source = $2) AND id > $3
+		ORDER BY id
+		LIMIT $4
+	`, filter.State, filter.Source, filter.AfterID, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *item)
+	}
+	return items, rows.Err()
+}
+
+// Fix replaces the row of a pending item. It returns ErrNotPending when the item is not pending
+func (p *Postgres) Fix(ctx context.Context, id int64, row bulk.Row) error {
+	tag, err := p.db.Exec(ctx, `
+		UPDATE dead_letters SET serial = $3, status = $4, reason = $5, date = $6
+		WHERE id = $1 AND state = $2
+	`, id, StatePending, row.Serial, row.Status, row.Reason, row.Date)
+	if err != nil {
+		return err
+	}
+	return p.affected(ctx, id, tag.RowsAffected())
+}
+
+// Failed records another failed replay of a pending item
+func (p *Postgres) Failed(ctx context.Context, id int64, message string) error {
+	tag, err := p.db.Exec(ctx, `
+		UPDATE dead_letters SET error = $3, attempts = attempts + 1, failed_at = NOW()
+		WHERE id = $1 AND state = $2
+	`, id, StatePending, message)
+	if err != nil {
+		return err
+	}
+	return p.affected(ctx, id, tag.RowsAffected())
+}
+
+// Transition moves a pending item to state, recording decidedBy. It returns ErrNotPending when
+// the item is not pending
+func (p *Postgres) Transition(ctx context.Context, id int64, state, decidedBy string) error {
+	tag, err := p.db.Exec(ctx, `
+		UPDATE dead_letters SET state = $3, decided_by = NULLIF($4, ''), decided_at = NOW()
+		WHERE id = $1 AND state = $2
+	`, id, StatePending, state, decidedBy)
+	if err != nil {
+		return err
+	}
+	return p.affected(ctx, id, tag.RowsAffected())
+}
+
+// affected returns ErrNotFound or ErrNotPending when an update of a pending item changed nothing
+func (p *Postgres) affected(ctx context.Context, id int64, n int64) error {
+	if n > 0 {
+		return nil
+	}
+	if _, err := p.Get(ctx, id); err != nil {
+		return err
+	}
+	return ErrNotPending
+}
+
+func scanItem(row pgx.Row) (*Item, error) {
+	var item Item
+	if err := row.Scan(&item.ID, &item.State, &item.Source, &item.Line, &item.Row.Serial, &item.Row.Status,
+		&item.Row.Reason, &item.Row.Date, &item.Error, &item.Attempts, &item.FailedAt, &item.SubmittedBy,
+		&item.DecidedBy, &item.DecidedAt); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
@@ -0,0 +1,73 @@
+// Package deadline bounds every gRPC call with a per-method deadline, so a call without one,
+// or with one too generous, cannot hold a database connection or signer indefinitely
+package deadline
+
+import (
+	"context"
+	"errors"
+	"path"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Causes, used in metric labels: whose deadline ran out
+const (
+	causeClient = "client"
+	causeServer = "server"
+)
+
+var exceeded = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "grpc_deadline_exceeded_total",
+	Help:      "gRPC calls that failed because their deadline ran out, by method and by whether the client's or the server's deadline applied.",
+}, []string{"method", "cause"})
+
+func init() {
+	metrics.Registry.MustRegister(exceeded)
+}
+
+// Deadlines maps method names, such as CheckStatus, to the longest a call may take. Methods
+// not listed, or listed with zero, keep whatever deadline the client sent
+type Deadlines map[string]time.Duration
+
+// UnaryServerInterceptor gives each call the deadline of its method unless the client's is
+// sooner. A call failing after its deadline ran out is answered DEADLINE_EXCEEDED, whatever
+// error the layer that noticed returned, and counted by cause
+func UnaryServerInterceptor(deadlines Deadlines) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		cause := causeClient
+		if timeout := deadlines[path.Base(info.FullMethod)]; timeout > 0 {
+			if client, ok := ctx.Deadline(); !ok || time.Until(client) > timeout {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+				cause = causeServer
+			}
+		}
+
+		resp, err := handler(ctx, req)
+		if err == nil || !expired(ctx) {
+			return resp, err
+		}
+		exceeded.WithLabelValues(info.FullMethod, cause).Inc()
+		if status.Code(err) == codes.DeadlineExceeded {
+			return nil, err
+		}
+		return nil, status.Errorf(codes.DeadlineExceeded, "%s exceeded the %s deadline", path.Base(info.FullMethod), cause)
+	}
+}
+
+// expired reports whether ctx ended because its deadline passed. A client that gives up at its
+// deadline resets the stream, which can cancel ctx just before the deadline fires on this side
+func expired(ctx context.Context) bool {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return true
+	}
+	deadline, ok := ctx.Deadline()
+	return ok && ctx.Err() != nil && !time.Now().Before(deadline)
+}
@@ -0,0 +1,156 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Consul registers the instance with the local Consul agent, which syncs it to the catalog.
+// Health is published through a TTL check the agent marks critical when renewals stop
+type Consul struct {
+	address         string
+	token           string
+	ttl             time.Duration
+	deregisterAfter time.Duration
+	client          *http.Client
+
+	id string
+}
+
+// NewConsul creates a registry for the agent at address, such as http://127.0.0.1:8500. A
+// check that stays critical for deregisterAfter removes the service from the agent
+func NewConsul(address, token string, ttl, deregisterAfter time.Duration, tlsConfig *tls.Config) *Consul {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &Consul{
+		address:         strings.TrimSuffix(address, "/"),
+		token:           token,
+		ttl:             ttl,
+		deregisterAfter: deregisterAfter,
+		client:          &http.Client{Timeout: 10 * time.Second, Transport: transport},
+	}
+}
+
+// Name returns the provider name
+func (c *Consul) Name() string {
+	return "consul"
+}
+
+type consulCheck struct {
+	CheckID                        string
+	Name                           string
+	TTL                            string
+	Status                         string
+	Notes                          string `json:",omitempty"`
+	DeregisterCriticalServiceAfter string
+}
+
+type consulService struct {
+	ID      string
+	Name    string
+	Tags    []string          `json:",omitempty"`
+	Address string            `json:",omitempty"`
+	Port    int               `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+	Check   consulCheck
+}
+
+// Register registers the service on its HTTP port with the gRPC port in its meta, as
+// grpc_port, and a TTL check in the given state
+func (c *Consul) Register(ctx context.Context, instance Instance, health Health) error {
+	meta := map[string]string{}
+	for k, v := range instance.Meta {
+		meta[k] = v
+	}
+	if instance.GRPCPort > 0 {
+		meta["grpc_port"] = strconv.Itoa(instance.GRPCPort)
+	}
+	service := consulService{
+		ID:      instance.ID,
+		Name:    instance.Service,
+		Tags:    instance.Tags,
+		Address: instance.Address,
+		Port:    instance.HTTPPort,
+		Meta:    meta,
+		Check: consulCheck{
+			CheckID:                        c.checkID(instance.ID),
+			Name:                           instance.Service + " health",
+			TTL:                            c.ttl.String(),
+			Status:                         health.state(),
+			Notes:                          "Renewed by the service itself",
+			DeregisterCriticalServiceAfter: c.deregisterAfter.String(),
+		},
+	}
+	if _, err := c.put(ctx, "/v1/agent/service/register", service); err != nil {
+		return err
+	}
+	c.id = instance.ID
+	// The registration does not record why the check is in its state
+	return c.Renew(ctx, health)
+}
+
+// Renew resets the TTL check's timer and sets its state and output
+func (c *Consul) Renew(ctx context.Context, health Health) error {
+	status, err := c.put(ctx, "/v1/agent/check/update/"+url.PathEscape(c.checkID(c.id)), map[string]string{
+		"Status": health.state(),
+		"Output": health.Output,
+	})
+	// Agents answer 404 for an unknown check since 1.11 and 500 before
+	if err != nil && (status == http.StatusNotFound || status == http.StatusInternalServerError && strings.Contains(err.Error(), "Unknown check")) {
+		return ErrNotRegistered
+	}
+	return err
+}
+
+// Deregister removes the service and its check from the agent
+func (c *Consul) Deregister(ctx context.Context) error {
+	_, err := c.put(ctx, "/v1/agent/service/deregister/"+url.PathEscape(c.id), nil)
+	return err
+}
+
+func (c *Consul) checkID(id string) string {
+	return "service:" + id
+}
+
+// put sends body as JSON and returns the response status with an error for anything but 200
+func (c *Consul) put(ctx context.Context, path string, body interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.address+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return resp.StatusCode, fmt.Errorf("consul returned status %d for %s: %s", resp.StatusCode, path, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,199 @@
+// Package discovery registers the service with Consul or etcd, so other services find its
+// endpoints without hardcoded addresses, and keeps its health status there current
+package discovery
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	registered = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "discovery_registered",
+		Help:      "Whether this replica is registered with service discovery (1) or not (0).",
+	})
+	renewals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "discovery_renewals_total",
+		Help:      "Service discovery registrations and renewals by provider, published health and result.",
+	}, []string{"provider", "health", "result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(registered, renewals)
+}
+
+// ErrNotRegistered is returned by Registry.Renew when the registry no longer holds the
+// instance, such as after a Consul agent restart or an expired etcd lease
+var ErrNotRegistered = errors.New("instance is not registered")
+
+// Instance is one replica as other services see it
+type Instance struct {
+	ID       string
+	Service  string
+	Address  string
+	HTTPPort int
+	GRPCPort int
+	Tags     []string
+	// Meta holds free-form details such as the role and version
+	Meta map[string]string
+}
+
+// Health is the status published for an instance
+type Health struct {
+	Passing bool
+	// Output describes why the instance is failing, or that it is healthy
+	Output string
+}
+
+// state names the health the way Consul does: passing or critical
+func (h Health) state() string {
+	if h.Passing {
+		return "passing"
+	}
+	return "critical"
+}
+
+// Registry is a service catalog an instance can register with
+type Registry interface {
+	// Name identifies the provider in logs and metrics
+	Name() string
+	// Register adds the instance, replacing an earlier registration with the same ID
+	Register(ctx context.Context, instance Instance, health Health) error
+	// Renew extends the registration and publishes health
+	Renew(ctx context.Context, health Health) error
+	// Deregister removes the instance
+	Deregister(ctx context.Context) error
+}
+
+// Registration keeps one instance registered while the replica runs. Every interval it
+// publishes the result of check, registering again whenever the registry has lost the
+// instance, so a registry that restarts or loses its data is repopulated without an operator
+type Registration struct {
+	registry Registry
+	instance Instance
+	interval time.Duration
+	check    func(ctx context.Context) error
+	logger   *logger.Logger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	// registered is only touched by the renewal loop, and by Stop once the loop has ended
+	registered bool
+}
+
+// NewRegistration registers instance in registry once started. A nil check always passes
+func NewRegistration(registry Registry, instance Instance, interval time.Duration, check func(ctx context.Context) error, logger *logger.Logger) *Registration {
+	return &Registration{
+		registry: registry,
+		instance: instance,
+		interval: interval,
+		check:    check,
+		logger:   logger,
+		done:     make(chan struct{}),
+	}
+}
+
+// Start registers the instance and renews it in the background until Stop
+func (r *Registration) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	go r.run(ctx)
+}
+
+func (r *Registration) run(ctx context.Context) {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.renew(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renew publishes the current health, registering first when needed
+func (r *Registration) renew(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, r.interval)
+	defer cancel()
+
+	health := Health{Passing: true, Output: "ok"}
+	if r.check != nil {
+		if err := r.check(ctx); err != nil {
+			health = Health{Output: err.Error()}
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	state := health.state()
+
+	if r.registered {
+		err := r.registry.Renew(ctx, health)
+		if err == nil {
+			renewals.WithLabelValues(r.registry.Name(), state, "success").Inc()
+			return
+		}
+		if !errors.Is(err, ErrNotRegistered) {
+			renewals.WithLabelValues(r.registry.Name(), state, "error").Inc()
+			if ctx.Err() == nil {
+				r.logger.Warn("Failed to renew service registration", zap.String("provider", r.registry.Name()), zap.Error(err))
+			}
+			return
+		}
+		r.logger.Warn("Service registration was lost; registering again", zap.String("provider", r.registry.Name()))
+		r.setRegistered(false)
+	}
+
+	if err := r.registry.Register(ctx, r.instance, health); err != nil {
+		renewals.WithLabelValues(r.registry.Name(), state, "error").Inc()
+		if ctx.Err() == nil {
+			r.logger.Warn("Failed to register with service discovery", zap.String("provider", r.registry.Name()), zap.Error(err))
+		}
+		return
+	}
+	renewals.WithLabelValues(r.registry.Name(), state, "success").Inc()
+	r.setRegistered(true)
+	r.logger.Info("Registered with service discovery",
+		zap.String("provider", r.registry.Name()),
+		zap.String("service", r.instance.Service),
+		zap.String("instance", r.instance.ID),
+		zap.String("address", r.instance.Address),
+		zap.Bool("passing", health.Passing),
+	)
+}
+
+func (r *Registration) setRegistered(ok bool) {
+	r.registered = ok
+	if ok {
+		registered.Set(1)
+	} else {
+		registered.Set(0)
+	}
+}
+
+// Stop ends renewals and deregisters the instance, so clients stop choosing it before the
+// servers shut down
+func (r *Registration) Stop(ctx context.Context) error {
+	r.cancel()
+	<-r.done
+	if !r.registered {
+		return nil
+	}
+	if err := r.registry.Deregister(ctx); err != nil {
+		return err
+	}
+	r.setRegistered(false)
+	r.logger.Info("Deregistered from service discovery", zap.String("provider", r.registry.Name()))
+	return nil
+}
@@ -0,0 +1,262 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// etcdUnauthenticated is the gRPC code etcd returns for an expired or invalid auth token
+const etcdUnauthenticated = 16
+
+// Etcd writes the instance as JSON under <prefix><service>/<id>, attached to a lease kept
+// alive by Renew, through etcd's v3 JSON gateway. A replica that stops renewing disappears
+// when its lease expires; consumers watch the prefix to follow the instances
+type Etcd struct {
+	endpoints []string
+	prefix    string
+	username  string
+	password  string
+	ttl       time.Duration
+	client    *http.Client
+
+	// current is the index of the endpoint that last answered
+	current int
+	token   string
+
+	key     string
+	lease   string
+	record  etcdRecord
+	written Health
+}
+
+// etcdRecord is the value stored for an instance
+type etcdRecord struct {
+	ID       string            `json:"id"`
+	Service  string            `json:"service"`
+	Address  string            `json:"address"`
+	HTTPPort int               `json:"http_port"`
+	GRPCPort int               `json:"grpc_port"`
+	Tags     []string          `json:"tags,omitempty"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	Status   string            `json:"status"`
+	Output   string            `json:"output"`
+}
+
+// NewEtcd creates a registry writing under prefix, which ends in /. Endpoints are tried in
+// order, moving on when one cannot be reached
+func NewEtcd(endpoints []string, prefix, username, password string, ttl time.Duration, tlsConfig *tls.Config) *Etcd {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	trimmed := make([]string, len(endpoints))
+	for i, endpoint := range endpoints {
+		trimmed[i] = strings.TrimSuffix(endpoint, "/")
+	}
+	return &Etcd{
+		endpoints: trimmed,
+		prefix:    prefix,
+		username:  username,
+		password:  password,
+		ttl:       ttl,
+		client:    &http.Client{Timeout: 10 * time.Second, Transport: transport},
+	}
+}
+
+// Name returns the provider name
+func (e *Etcd) Name() string {
+	return "etcd"
+}
+
+// Register grants a lease of the TTL and writes the instance under it
+func (e *Etcd) Register(ctx context.Context, instance Instance, health Health) error {
+	var grant struct {
+		ID  string `json:"ID"`
+		TTL string `json:"TTL"`
+	}
+	if err := e.call(ctx, "/v3/lease/grant", map[string]int64{"TTL": int64(e.ttl / time.Second)}, &grant); err != nil {
+		return fmt.Errorf("failed to grant lease: %w", err)
+	}
+	if grant.ID == "" {
+		return errors.New("etcd granted no lease")
+	}
+
+	e.key = e.prefix + instance.Service + "/" + instance.ID
+	e.lease = grant.ID
+	e.record = etcdRecord{
+		ID:       instance.ID,
+		Service:  instance.Service,
+		Address:  instance.Address,
+		HTTPPort: instance.HTTPPort,
+		GRPCPort: instance.GRPCPort,
+		Tags:     instance.Tags,
+		Meta:     instance.Meta,
+	}
+	return e.write(ctx, health)
+}
+
+// Renew keeps the lease alive and rewrites the value when the health has changed since it
+// was last written
+func (e *Etcd) Renew(ctx context.Context, health Health) error {
+	var keepalive struct {
+		Result struct {
+			TTL string `json:"TTL"`
+		} `json:"result"`
+		Error *etcdError `json:"error"`
+	}
+	if err := e.call(ctx, "/v3/lease/keepalive", map[string]string{"ID": e.lease}, &keepalive); err != nil {
+		return fmt.Errorf("failed to keep lease alive: %w", err)
+	}
+	if keepalive.Error != nil {
+		return keepalive.Error
+	}
+	// An expired lease is answered with a TTL of zero, which the gateway leaves out
+	if ttl, _ := strconv.ParseInt(keepalive.Result.TTL, 10, 64); ttl <= 0 {
+		return ErrNotRegistered
+	}
+	if health == e.written {
+		return nil
+	}
+	return e.write(ctx, health)
+}
+
+// Deregister revokes the lease, which deletes the key
+func (e *Etcd) Deregister(ctx context.Context) error {
+	err := e.call(ctx, "/v3/lease/revoke", map[string]string{"ID": e.lease}, nil)
+	var etcdErr *etcdError
+	if errors.As(err, &etcdErr) && strings.Contains(etcdErr.Message, "lease not found") {
+		return nil
+	}
+	return err
+}
+
+func (e *Etcd) write(ctx context.Context, health Health) error {
+	record := e.record
+	record.Status = health.state()
+	record.Output = health.Output
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	err = e.call(ctx, "/v3/kv/put", map[string]string{
+		"key":   base64.StdEncoding.EncodeToString([]byte(e.key)),
+		"value": base64.StdEncoding.EncodeToString(value),
+		"lease": e.lease,
+	}, nil)
+	var etcdErr *etcdError
+	if errors.As(err, &etcdErr) && strings.Contains(etcdErr.Message, "lease not found") {
+		return ErrNotRegistered
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", e.key, err)
+	}
+	e.written = health
+	return nil
+}
+
+// etcdError is an error response of the JSON gateway, with its gRPC code
+type etcdError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *etcdError) Error() string {
+	return fmt.Sprintf("etcd error %d: %s", e.Code, e.Message)
+}
+
+// call posts body to path, authenticating first when a username is configured and again when
+// the token has expired
+func (e *Etcd) call(ctx context.Context, path string, body, out interface{}) error {
+	if e.username != "" && e.token == "" {
+		if err := e.authenticate(ctx); err != nil {
+			return err
+		}
+	}
+	err := e.post(ctx, path, body, out)
+	var etcdErr *etcdError
+	if e.username != "" && errors.As(err, &etcdErr) && etcdErr.Code == etcdUnauthenticated {
+		if err := e.authenticate(ctx); err != nil {
+			return err
+		}
+		err = e.post(ctx, path, body, out)
+	}
+	return err
+}
+
+func (e *Etcd) authenticate(ctx context.Context) error {
+	e.token = ""
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := e.post(ctx, "/v3/auth/authenticate", map[string]string{"name": e.username, "password": e.password}, &resp); err != nil {
+		return fmt.Errorf("etcd authentication failed: %w", err)
+	}
+	if resp.Token == "" {
+		return errors.New("etcd authentication returned no token")
+	}
+	e.token = resp.Token
+	return nil
+}
+
+// post sends the request to the endpoint that last answered, moving through the others in
+// order while they cannot be reached
+func (e *Etcd) post(ctx context.Context, path string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i := range e.endpoints {
+		index := (e.current + i) % len(e.endpoints)
+		resp, err := e.send(ctx, e.endpoints[index]+path, data)
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			errs = append(errs, err)
+			continue
+		}
+		e.current = index
+		defer resp.Body.Close()
+
+		raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			etcdErr := &etcdError{}
+			if json.Unmarshal(raw, etcdErr) != nil || etcdErr.Message == "" {
+				return fmt.Errorf("etcd returned status %d for %s: %s", resp.StatusCode, path, strings.TrimSpace(string(raw)))
+			}
+			return etcdErr
+		}
+		if out == nil {
+			return nil
+		}
+		if err := json.Unmarshal(raw, out); err != nil {
+			return fmt.Errorf("invalid etcd response to %s: %w", path, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("no etcd endpoint answered: %w", errors.Join(errs...))
+}
+
+func (e *Etcd) send(ctx context.Context, url string, data []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		req.Header.Set("Authorization", e.token)
+	}
+	return e.client.Do(req)
+}
@@ -0,0 +1,133 @@
+// Package dryrun reports what status updates would change without applying them
+package dryrun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+// Change actions
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionUnchanged = "unchanged"
+)
+
+// Change is the effect one update would have on the stored status of its serial
+type Change struct {
+	Serial     string     `json:"serial"`
+	Action     string     `json:"action"`
+	FromStatus string     `json:"from_status,omitempty"`
+	FromReason string     `json:"from_reason,omitempty"`
+	ToStatus   string     `json:"to_status"`
+	ToReason   string     `json:"to_reason,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	// Violation explains why the transition breaks revocation rules; the update would be refused
+	Violation string `json:"violation,omitempty"`
+}
+
+// Report is the outcome of a dry run
+type Report struct {
+	Creates    int      `json:"creates"`
+	Updates    int      `json:"updates"`
+	Unchanged  int      `json:"unchanged"`
+	Violations int      `json:"violations"`
+	Changes    []Change `json:"changes"`
+}
+
+// Plan compares each update with the stored status and reports the change it would make.
+// Updates are planned in order, so a serial listed twice is compared against its earlier
+// update unless that one would be refused
+func Plan(ctx context.Context, store storage.Store, updates []storage.Update) (*Report, error) {
+	report := &Report{Changes: make([]Change, 0, len(updates))}
+	pending := make(map[string]*storage.Record)
+
+	for _, update := range updates {
+		current, ok := pending[update.Serial]
+		if !ok {
+			rec, err := store.Get(ctx, update.Serial)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return nil, fmt.Errorf("failed to read status of %s: %w", update.Serial, err)
+			}
+			current = rec
+		}
+
+		change := Change{
+			Serial:    update.Serial,
+			ToStatus:  update.Status,
+			ToReason:  reasonOf(update.Status, update.RevocationReason),
+			RevokedAt: update.RevokedAt,
+		}
+		switch {
+		case current == nil:
+			change.Action = ActionCreate
+			report.Creates++
+		case sameStatus(current, update):
+			change.Action = ActionUnchanged
+			report.Unchanged++
+		default:
+			change.Action = ActionUpdate
+			report.Updates++
+		}
+		if current != nil {
+			change.FromStatus = current.Status
+			change.FromReason = reasonOf(current.Status, current.RevocationReason)
+		}
+		from := current
+		if revocation.Overridden(ctx) {
+			from = nil
+		}
+		if err := revocation.CheckTransition(from, update); err != nil {
+			change.Violation = err.Error()
+			report.Violations++
+		}
+		report.Changes = append(report.Changes, change)
+		// A refused update is never written, so later ones are planned against current
+		if change.Violation != "" {
+			continue
+		}
+
+		pending[update.Serial] = &storage.Record{
+			Serial:           update.Serial,
+			Status:           update.Status,
+			RevokedAt:        update.RevokedAt,
+			RevocationReason: update.RevocationReason,
+		}
+	}
+	return report, nil
+}
+
+// sameStatus reports whether applying the update would leave the status, reason and
+// revocation time as they are
+func sameStatus(rec *storage.Record, update storage.Update) bool {
+	if rec.Status != update.Status {
+		return false
+	}
+	if update.Status != storage.StatusRevoked {
+		return true
+	}
+	if reasonOf(rec.Status, rec.RevocationReason) != reasonOf(update.Status, update.RevocationReason) {
+		return false
+	}
+	if rec.RevokedAt == nil || update.RevokedAt == nil {
+		return rec.RevokedAt == nil && update.RevokedAt == nil
+	}
+	// Postgres keeps microsecond precision
+	return rec.RevokedAt.Truncate(time.Microsecond).Equal(update.RevokedAt.Truncate(time.Microsecond))
+}
+
+// reasonOf returns the effective revocation reason, treating a blank reason as unspecified
+func reasonOf(status, reason string) string {
+	if status != storage.StatusRevoked {
+		return ""
+	}
+	if reason == "" {
+		return revocation.ReasonUnspecified
+	}
+	return reason
+}
@@ -0,0 +1,52 @@
+package errreport
+
+import (
+	"context"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor reports panics and Internal-class errors returned by RPC handlers
+func UnaryServerInterceptor(r *Reporter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		tags := map[string]string{"transport": "grpc", "method": info.FullMethod}
+
+		defer func() {
+			if p := recover(); p != nil {
+				r.CapturePanic(p, tags, nil)
+				resp, err = nil, status.Error(codes.Internal, "internal error")
+			}
+		}()
+
+		resp, err = handler(ctx, req)
+		if err != nil && isInternal(status.Code(err)) {
+			r.CaptureError(err, tags, nil)
+		}
+		return resp, err
+	}
+}
+
+// HTTPMiddleware reports panics raised by HTTP handlers and answers with a 500
+func HTTPMiddleware(r *Reporter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			defer func() {
+				if p := recover(); p != nil {
+					r.CapturePanic(p,
+						map[string]string{"transport": "http", "method": req.Method},
+						map[string]string{"path": req.URL.Path, "remote_addr": req.RemoteAddr},
+					)
+					http.Error(w, "internal error", http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+func isInternal(code codes.Code) bool {
+	return code == codes.Internal || code == codes.Unknown || code == codes.DataLoss
+}
@@ -0,0 +1,216 @@
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	clientName = "gigvault-ocsp/1.0"
+	queueSize  = 100
+)
+
+// Options configures a Reporter
+type Options struct {
+	DSN         string
+	Environment string
+	Release     string
+}
+
+// Reporter delivers panics and internal errors to a Sentry-compatible store endpoint
+type Reporter struct {
+	endpoint   string
+	authHeader string
+	opts       Options
+	serverName string
+	client     *http.Client
+	logger     *logger.Logger
+	queue      chan *event
+}
+
+// event is the subset of the Sentry event payload populated by the reporter
+type event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Exception   []exception       `json:"exception,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]string `json:"extra,omitempty"`
+}
+
+type exception struct {
+	Type       string     `json:"type"`
+	Value      string     `json:"value"`
+	Stacktrace stacktrace `json:"stacktrace"`
+}
+
+type stacktrace struct {
+	Frames []frame `json:"frames"`
+}
+
+type frame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// New creates a reporter from a DSN of the form https://<key>@<host>/<project>
+func New(opts Options, logger *logger.Logger) (*Reporter, error) {
+	u, err := url.Parse(opts.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid error reporting DSN: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("invalid error reporting DSN: missing public key")
+	}
+
+	project := strings.Trim(u.Path, "/")
+	if project == "" {
+		return nil, fmt.Errorf("invalid error reporting DSN: missing project ID")
+	}
+
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=%s, sentry_key=%s", clientName, u.User.Username())
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+
+	hostname, _ := os.Hostname()
+
+	return &Reporter{
+		endpoint:   fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, project),
+		authHeader: auth,
+		opts:       opts,
+		serverName: hostname,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		logger:     logger,
+		queue:      make(chan *event, queueSize),
+	}, nil
+}
+
+// Run delivers queued events until the context is cancelled
+func (r *Reporter) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-r.queue:
+			if err := r.send(ctx, ev); err != nil {
+				r.logger.Warn("Failed to deliver error report", zap.String("event_id", ev.EventID), zap.Error(err))
+			}
+		}
+	}
+}
+
+// CapturePanic reports a recovered panic with the stack of the panicking goroutine
+func (r *Reporter) CapturePanic(recovered interface{}, tags, extra map[string]string) {
+	r.enqueue("fatal", "panic", fmt.Sprint(recovered), tags, extra)
+}
+
+// CaptureError reports an error with the caller's stack
+func (r *Reporter) CaptureError(err error, tags, extra map[string]string) {
+	r.enqueue("error", fmt.Sprintf("%T", err), err.Error(), tags, extra)
+}
+
+func (r *Reporter) enqueue(level, errType, value string, tags, extra map[string]string) {
+	ev := &event{
+		EventID:     newEventID(),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		Level:       level,
+		Platform:    "go",
+		Logger:      "ocsp",
+		ServerName:  r.serverName,
+		Environment: r.opts.Environment,
+		Release:     r.opts.Release,
+		Exception: []exception{{
+			Type:       errType,
+			Value:      value,
+			Stacktrace: stacktrace{Frames: callerFrames(4)},
+		}},
+		Tags:  tags,
+		Extra: extra,
+	}
+
+	// Never block request handling on the reporting backend
+	select {
+	case r.queue <- ev:
+	default:
+		r.logger.Warn("Error report queue full, dropping event", zap.String("event_id", ev.EventID))
+	}
+}
+
+func (r *Reporter) send(ctx context.Context, ev *event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", r.authHeader)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("store endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// callerFrames returns the current stack, oldest frame first as the store API expects
+func callerFrames(skip int) []frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var out []frame
+	for {
+		f, more := frames.Next()
+		out = append(out, frame{
+			Function: f.Function,
+			Filename: f.File,
+			Lineno:   f.Line,
+			InApp:    strings.HasPrefix(f.Function, "github.com/gigvault/"),
+		})
+		if !more {
+			break
+		}
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,146 @@
+// Package events publishes certificate status changes to downstream systems
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// SchemaVersion is incremented on incompatible changes to Event
+const SchemaVersion = 1
+
+// TypeStatusChanged is the type of events emitted for every status mutation
+const TypeStatusChanged = "ocsp.status.changed"
+
+// Event is the published record of a status mutation
+type Event struct {
+	SchemaVersion    int        `json:"schema_version"`
+	ID               string     `json:"id"`
+	Type             string     `json:"type"`
+	Time             time.Time  `json:"time"`
+	Serial           string     `json:"serial"`
+	Status           string     `json:"status"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RevocationReason string     `json:"revocation_reason,omitempty"`
+}
+
+// Publisher delivers events to a sink
+type Publisher interface {
+	Publish(ctx context.Context, events []Event) error
+	Close() error
+}
+
+var publishFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "events_publish_failures_total",
+	Help:      "Status change events that could not be published.",
+}, []string{"sink"})
+
+func init() {
+	metrics.Registry.MustRegister(publishFailures)
+}
+
+// NewEvent builds the event for an applied update
+func NewEvent(update storage.Update, at time.Time) Event {
+	event := Event{
+		SchemaVersion: SchemaVersion,
+		ID:            newID(),
+		Type:          TypeStatusChanged,
+		Time:          at.UTC(),
+		Serial:        update.Serial,
+		Status:        update.Status,
+	}
+	if update.Status == storage.StatusRevoked {
+		event.RevokedAt = update.RevokedAt
+		event.RevocationReason = update.RevocationReason
+	}
+	return event
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Sink is a named event destination; the name labels failure logs and metrics
+type Sink struct {
+	Name      string
+	Publisher Publisher
+}
+
+// Store wraps a status store and publishes an event for every committed mutation to each
+// sink. Publishing happens after the write commits; failures are logged and counted but do
+// not fail the write. With UseOutbox, events are recorded with the write instead
+type Store struct {
+	storage.Store
+	sinks  []Sink
+	logger *logger.Logger
+	outbox bool
+}
+
+// NewStore wraps store so its mutations are published to the given sinks
+func NewStore(store storage.Store, sinks []Sink, logger *logger.Logger) *Store {
+	return &Store{Store: store, sinks: sinks, logger: logger}
+}
+
+// UseOutbox makes the store record events in the event outbox, in the transaction of each
+// write, instead of publishing them after the write commits; a Relay then publishes them
+func (s *Store) UseOutbox() {
+	s.outbox = true
+}
+
+// Upsert writes the update and publishes its event
+func (s *Store) Upsert(ctx context.Context, update storage.Update) error {
+	if s.outbox {
+		return s.Store.Upsert(storage.WithOutbox(ctx), update)
+	}
+	if err := s.Store.Upsert(ctx, update); err != nil {
+		return err
+	}
+	s.publish(ctx, []storage.Update{update})
+	return nil
+}
+
+// ApplyBatch writes the updates and publishes one event per update
+func (s *Store) ApplyBatch(ctx context.Context, updates []storage.Update) error {
+	if s.outbox {
+		return s.Store.ApplyBatch(storage.WithOutbox(ctx), updates)
+	}
+	if err := s.Store.ApplyBatch(ctx, updates); err != nil {
+		return err
+	}
+	s.publish(ctx, updates)
+	return nil
+}
+
+func (s *Store) publish(ctx context.Context, updates []storage.Update) {
+	if len(updates) == 0 {
+		return
+	}
+
+	now := time.Now()
+	batch := make([]Event, len(updates))
+	for i, update := range updates {
+		batch[i] = NewEvent(update, now)
+	}
+
+	for _, sink := range s.sinks {
+		if err := sink.Publisher.Publish(ctx, batch); err != nil {
+			publishFailures.WithLabelValues(sink.Name).Add(float64(len(batch)))
+			s.logger.Error("Failed to publish status change events",
+				zap.String("sink", sink.Name),
+				zap.Int("events", len(batch)),
+				zap.Error(err),
+			)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package events
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// KafkaOptions configures the Kafka publisher
+type KafkaOptions struct {
+	Brokers []string
+	Topic   string
+	TLS     *tls.Config
+	// SASLMechanism is plain, scram-sha-256, scram-sha-512, or empty to disable SASL
+	SASLMechanism string
+	Username      string
+	Password      string
+}
+
+// KafkaPublisher writes events as JSON to a topic, keyed by serial so a certificate's
+// changes stay ordered within a partition
+type KafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaPublisher creates a Kafka publisher
+func NewKafkaPublisher(opts KafkaOptions) (*KafkaPublisher, error) {
+	if len(opts.Brokers) == 0 || opts.Topic == "" {
+		return nil, fmt.Errorf("kafka brokers and topic are required")
+	}
+
+	mechanism, err := saslMechanism(opts.SASLMechanism, opts.Username, opts.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(opts.Brokers...),
+			Topic:        opts.Topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			BatchTimeout: 10 * time.Millisecond,
+			WriteTimeout: 10 * time.Second,
+			Transport: &kafka.Transport{
+				TLS:  opts.TLS,
+				SASL: mechanism,
+			},
+		},
+	}, nil
+}
+
+// Publish writes the events and waits for all in-sync replicas to acknowledge them
+func (p *KafkaPublisher) Publish(ctx context.Context, events []Event) error {
+	messages := make([]kafka.Message, len(events))
+	for i, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		messages[i] = kafka.Message{
+			Key:   []byte(event.Serial),
+			Value: value,
+			Headers: []kafka.Header{
+				{Key: "type", Value: []byte(event.Type)},
+				{Key: "schema_version", Value: []byte(fmt.Sprint(event.SchemaVersion))},
+			},
+		}
+	}
+	return p.writer.WriteMessages(ctx, messages...)
+}
+
+// Close flushes pending writes and closes broker connections
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
+
+func saslMechanism(name, username, password string) (sasl.Mechanism, error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	default:
+		return nil, fmt.Errorf("unsupported SASL mechanism %q", name)
+	}
+}
+
+// LoadTLSConfig builds a client TLS configuration from PEM files; caPath and the
+// certificate pair are each optional
+func LoadTLSConfig(caPath, certPath, keyPath string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caPath != "" {
+		pem, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caPath)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if certPath != "" || keyPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
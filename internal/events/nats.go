@@ -0,0 +1,161 @@
+package events
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+)
+
+// natsRetryDelay is how long a failed intake message waits before redelivery
+const natsRetryDelay = 5 * time.Second
+
+// NATSOptions configures the NATS connection
+type NATSOptions struct {
+	URL string
+	TLS *tls.Config
+	// CredentialsPath is a NATS .creds file holding the user JWT and seed
+	CredentialsPath string
+}
+
+// ConnectNATS connects to NATS and returns a JetStream context on the connection
+func ConnectNATS(opts NATSOptions) (*nats.Conn, jetstream.JetStream, error) {
+	connOpts := []nats.Option{
+		nats.Name("gigvault-ocsp"),
+		nats.MaxReconnects(-1),
+	}
+	if opts.TLS != nil {
+		connOpts = append(connOpts, nats.Secure(opts.TLS))
+	}
+	if opts.CredentialsPath != "" {
+		connOpts = append(connOpts, nats.UserCredentials(opts.CredentialsPath))
+	}
+
+	conn, err := nats.Connect(opts.URL, connOpts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+	return conn, js, nil
+}
+
+// NATSPublisher publishes events to a JetStream subject. Each publish waits for the stream
+// acknowledgement and carries the event ID as Nats-Msg-Id so retried publishes are deduplicated
+type NATSPublisher struct {
+	js      jetstream.JetStream
+	subject string
+}
+
+// NewNATSPublisher creates a JetStream publisher; a stream must already capture subject
+func NewNATSPublisher(js jetstream.JetStream, subject string) *NATSPublisher {
+	return &NATSPublisher{js: js, subject: subject}
+}
+
+// Publish publishes each event and returns the first failure
+func (p *NATSPublisher) Publish(ctx context.Context, events []Event) error {
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+		if _, err := p.js.Publish(ctx, p.subject, data, jetstream.WithMsgID(event.ID)); err != nil {
+			return fmt.Errorf("failed to publish event %s: %w", event.ID, err)
+		}
+	}
+	return nil
+}
+
+// Close is a no-op; the connection is owned by the caller
+func (p *NATSPublisher) Close() error {
+	return nil
+}
+
+// NATSConsumerOptions configures status intake from JetStream
+type NATSConsumerOptions struct {
+	Stream  string
+	Subject string
+	Durable string
+	// MaxDeliver bounds redeliveries of a message that keeps failing to apply
+	MaxDeliver int
+}
+
+// NATSConsumer applies status updates received on a JetStream subject. Messages are JSON
+// objects with serial, status, reason and date fields, validated like bulk uploads. A message
+// is acknowledged only after it is applied, giving at-least-once processing
+type NATSConsumer struct {
+	js     jetstream.JetStream
+	store  storage.Store
+	opts   NATSConsumerOptions
+	logger *logger.Logger
+}
+
+// NewNATSConsumer creates a JetStream status intake consumer
+func NewNATSConsumer(js jetstream.JetStream, store storage.Store, opts NATSConsumerOptions, logger *logger.Logger) *NATSConsumer {
+	return &NATSConsumer{js: js, store: store, opts: opts, logger: logger}
+}
+
+// Run consumes updates until the context is cancelled
+func (c *NATSConsumer) Run(ctx context.Context) error {
+	consumer, err := c.js.CreateOrUpdateConsumer(ctx, c.opts.Stream, jetstream.ConsumerConfig{
+		Durable:       c.opts.Durable,
+		FilterSubject: c.opts.Subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		MaxDeliver:    c.opts.MaxDeliver,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create JetStream consumer: %w", err)
+	}
+
+	consumeCtx, err := consumer.Consume(func(msg jetstream.Msg) {
+		c.handle(ctx, msg)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start JetStream consumer: %w", err)
+	}
+	defer consumeCtx.Stop()
+
+	c.logger.Info("Consuming status updates from JetStream",
+		zap.String("stream", c.opts.Stream),
+		zap.String("subject", c.opts.Subject),
+	)
+	<-ctx.Done()
+	return nil
+}
+
+func (c *NATSConsumer) handle(ctx context.Context, msg jetstream.Msg) {
+	update, err := decodeUpdate(msg.Data())
+	if err != nil {
+		// Malformed messages will never apply; terminate rather than redeliver
+		c.logger.Warn("Rejected invalid status update message", zap.String("subject", msg.Subject()), zap.Error(err))
+		msg.Term()
+		return
+	}
+
+	if err := c.store.Upsert(ctx, update); err != nil {
+		c.logger.Error("Failed to apply status update message", zap.String("serial", update.Serial), zap.Error(err))
+		msg.NakWithDelay(natsRetryDelay)
+		return
+	}
+	msg.Ack()
+}
+
+func decodeUpdate(data []byte) (storage.Update, error) {
+	var row bulk.Row
+	if err := json.Unmarshal(data, &row); err != nil {
+		return storage.Update{}, errors.New("message is not a JSON status update")
+	}
+	return bulk.Normalize(row)
+}
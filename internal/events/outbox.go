@@ -0,0 +1,191 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// outboxPurgeInterval is how often published entries past their retention are deleted
+const outboxPurgeInterval = 10 * time.Minute
+
+var (
+	outboxRelayed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "events_outbox_relayed_total",
+		Help:      "Status change events published from the event outbox.",
+	})
+	outboxBacklog = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "events_outbox_backlog",
+		Help:      "Status change events in the event outbox awaiting publication.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(outboxRelayed, outboxBacklog)
+}
+
+// Outbox is a database recording status change events in the transactions of the changes
+type Outbox interface {
+	RelayOutbox(ctx context.Context, limit int, publish func([]storage.OutboxEntry) error) (int, error)
+	OutboxBacklog(ctx context.Context) (int64, error)
+	PurgeOutbox(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// Deliverer is implemented by publishers that queue events in memory, to deliver them and
+// wait instead; the Relay prefers it so events stay in the outbox until they are delivered
+type Deliverer interface {
+	Deliver(ctx context.Context, events []Event) error
+}
+
+// RelayOptions configures a Relay
+type RelayOptions struct {
+	PollInterval time.Duration
+	BatchSize    int
+	// Retention is how long published entries are kept before they are purged
+	Retention time.Duration
+}
+
+// Relay publishes the events recorded in outboxes to every sink, oldest first, and marks them
+// published once all sinks accepted them. Events a sink refused are published again to every
+// sink that has not taken them yet, so delivery is at least once: after a crash or a failed
+// commit, consumers may see an event again, with the same ID
+type Relay struct {
+	outboxes []Outbox
+	sinks    []Sink
+	opts     RelayOptions
+	logger   *logger.Logger
+
+	// delivered holds, per outbox, the sink and event ID pairs of a batch that some other sink
+	// refused, so retrying the batch skips the sinks that already took it
+	delivered []map[string]bool
+}
+
+// NewRelay creates a relay from outboxes to sinks
+func NewRelay(outboxes []Outbox, sinks []Sink, opts RelayOptions, logger *logger.Logger) *Relay {
+	return &Relay{
+		outboxes:  outboxes,
+		sinks:     sinks,
+		opts:      opts,
+		logger:    logger,
+		delivered: make([]map[string]bool, len(outboxes)),
+	}
+}
+
+// Run relays every poll interval until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.opts.PollInterval)
+	defer ticker.Stop()
+
+	var purged time.Time
+	for {
+		r.relay(ctx)
+		if time.Since(purged) >= outboxPurgeInterval {
+			r.purge(ctx)
+			purged = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay drains each outbox, a batch at a time, until it is empty or a batch fails
+func (r *Relay) relay(ctx context.Context) {
+	var backlog int64
+	for i, outbox := range r.outboxes {
+		for ctx.Err() == nil {
+			n, err := outbox.RelayOutbox(ctx, r.opts.BatchSize, func(entries []storage.OutboxEntry) error {
+				return r.publish(ctx, i, entries)
+			})
+			if err != nil {
+				r.logger.Error("Failed to relay status change events", zap.Error(err))
+				break
+			}
+			outboxRelayed.Add(float64(n))
+			if n < r.opts.BatchSize {
+				break
+			}
+		}
+		n, err := outbox.OutboxBacklog(ctx)
+		if err != nil {
+			r.logger.Warn("Failed to measure the event outbox backlog", zap.Error(err))
+			continue
+		}
+		backlog += n
+	}
+	outboxBacklog.Set(float64(backlog))
+}
+
+// publish passes a batch of outbox i to every sink that has not taken it yet
+func (r *Relay) publish(ctx context.Context, i int, entries []storage.OutboxEntry) error {
+	batch := make([]Event, len(entries))
+	for j, e := range entries {
+		batch[j] = Event{
+			SchemaVersion:    SchemaVersion,
+			ID:               e.EventID,
+			Type:             TypeStatusChanged,
+			Time:             e.CreatedAt.UTC(),
+			Serial:           e.Serial,
+			Status:           e.Status,
+			RevokedAt:        e.RevokedAt,
+			RevocationReason: e.RevocationReason,
+		}
+	}
+
+	if r.delivered[i] == nil {
+		r.delivered[i] = make(map[string]bool)
+	}
+	delivered := r.delivered[i]
+	for _, sink := range r.sinks {
+		var pending []Event
+		for _, event := range batch {
+			if !delivered[sink.Name+"\x00"+event.ID] {
+				pending = append(pending, event)
+			}
+		}
+		if len(pending) == 0 {
+			continue
+		}
+
+		var err error
+		if deliverer, ok := sink.Publisher.(Deliverer); ok {
+			err = deliverer.Deliver(ctx, pending)
+		} else {
+			err = sink.Publisher.Publish(ctx, pending)
+		}
+		if err != nil {
+			publishFailures.WithLabelValues(sink.Name).Add(float64(len(pending)))
+			return fmt.Errorf("sink %s: %w", sink.Name, err)
+		}
+		for _, event := range pending {
+			delivered[sink.Name+"\x00"+event.ID] = true
+		}
+	}
+	r.delivered[i] = nil
+	return nil
+}
+
+// purge deletes published entries past their retention
+func (r *Relay) purge(ctx context.Context) {
+	cutoff := time.Now().Add(-r.opts.Retention)
+	for _, outbox := range r.outboxes {
+		n, err := outbox.PurgeOutbox(ctx, cutoff)
+		if err != nil {
+			r.logger.Warn("Failed to purge the event outbox", zap.Error(err))
+			continue
+		}
+		if n > 0 {
+			r.logger.Info("Purged published status change events", zap.Int64("events", n))
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Webhook request headers. The signature is the hex HMAC-SHA256 of "<timestamp>.<body>"
+// keyed with the shared secret, so receivers can reject replayed deliveries
+const (
+	HeaderSignature = "X-Gigvault-Signature"
+	HeaderTimestamp = "X-Gigvault-Timestamp"
+	HeaderEventID   = "X-Gigvault-Event-Id"
+)
+
+// webhookQueueSize bounds events awaiting delivery before they are dead-lettered
+const webhookQueueSize = 1000
+
+// WebhookOptions configures a webhook publisher
+type WebhookOptions struct {
+	URL    string
+	Secret string
+	// RevocationsOnly limits deliveries to events for revoked certificates
+	RevocationsOnly bool
+	MaxAttempts     int
+	InitialBackoff  time.Duration
+	MaxBackoff      time.Duration
+	// DeadLetterPath is a file that undeliverable events are appended to as JSON lines;
+	// when empty they are only logged
+	DeadLetterPath string
+}
+
+// WebhookPublisher delivers signed events to an HTTP endpoint from a background queue,
+// retrying with exponential backoff
+type WebhookPublisher struct {
+	opts   WebhookOptions
+	client *http.Client
+	queue  chan Event
+	logger *logger.Logger
+
+	deadLetterMu sync.Mutex
+}
+
+// deadLetter is the record written for an undeliverable event
+type deadLetter struct {
+	Time     time.Time `json:"time"`
+	URL      string    `json:"url"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	Event    Event     `json:"event"`
+}
+
+// NewWebhookPublisher creates a webhook publisher; call Run to start delivery. Unset retry
+// settings default to 5 attempts backing off from 1s up to 5m
+func NewWebhookPublisher(opts WebhookOptions, logger *logger.Logger) (*WebhookPublisher, error) {
+	if opts.URL == "" || opts.Secret == "" {
+		return nil, fmt.Errorf("webhook url and secret are required")
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = time.Second
+	}
+	if opts.MaxBackoff < opts.InitialBackoff {
+		opts.MaxBackoff = 5 * time.Minute
+	}
+
+	return &WebhookPublisher{
+		opts:   opts,
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan Event, webhookQueueSize),
+		logger: logger,
+	}, nil
+}
+
+// Publish queues the events for delivery without waiting for it
+func (p *WebhookPublisher) Publish(ctx context.Context, events []Event) error {
+	dropped := 0
+	for _, event := range events {
+		if p.opts.RevocationsOnly && event.Status != storage.StatusRevoked {
+			continue
+		}
+		select {
+		case p.queue <- event:
+		default:
+			p.deadLetter(event, 0, fmt.Errorf("delivery queue full"))
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		return fmt.Errorf("webhook queue full, dead-lettered %d events", dropped)
+	}
+	return nil
+}
+
+// Deliver posts the events in order, waiting for each, for callers that keep events until
+// they are delivered, such as the outbox Relay. It returns at the first failure worth
+// retrying; an event the endpoint rejects outright is dead-lettered so it cannot hold back the
+// rest
+func (p *WebhookPublisher) Deliver(ctx context.Context, events []Event) error {
+	for _, event := range events {
+		if p.opts.RevocationsOnly && event.Status != storage.StatusRevoked {
+			continue
+		}
+		body, err := json.Marshal(event)
+		if err != nil {
+			p.deadLetter(event, 0, err)
+			continue
+		}
+		retryable, err := p.deliver(ctx, event.ID, body)
+		switch {
+		case err == nil:
+		case retryable:
+			return fmt.Errorf("event %s: %w", event.ID, err)
+		default:
+			p.deadLetter(event, 1, err)
+		}
+	}
+	return nil
+}
+
+// Close is a no-op; queued events are drained when Run's context is cancelled
+func (p *WebhookPublisher) Close() error {
+	return nil
+}
+
+// Run delivers queued events until the context is cancelled, then dead-letters whatever is left
+func (p *WebhookPublisher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case event := <-p.queue:
+					p.deadLetter(event, 0, fmt.Errorf("shutdown before delivery"))
+				default:
+					return
+				}
+			}
+		case event := <-p.queue:
+			p.deliverWithRetry(ctx, event)
+		}
+	}
+}
+
+func (p *WebhookPublisher) deliverWithRetry(ctx context.Context, event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		p.deadLetter(event, 0, err)
+		return
+	}
+
+	backoff := p.opts.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		retryable, err := p.deliver(ctx, event.ID, body)
+		if err == nil {
+			return
+		}
+		if !retryable || attempt >= p.opts.MaxAttempts {
+			p.deadLetter(event, attempt, err)
+			return
+		}
+
+		p.logger.Warn("Webhook delivery failed, retrying",
+			zap.String("url", p.opts.URL),
+			zap.String("event_id", event.ID),
+			zap.Int("attempt", attempt),
+			zap.Error(err),
+		)
+
+		// Full jitter keeps retries from many replicas from arriving in lockstep
+		wait := time.Duration(rand.Int63n(int64(backoff) + 1))
+		select {
+		case <-ctx.Done():
+			p.deadLetter(event, attempt, fmt.Errorf("shutdown during retry: %w", err))
+			return
+		case <-time.After(wait):
+		}
+		if backoff *= 2; backoff > p.opts.MaxBackoff {
+			backoff = p.opts.MaxBackoff
+		}
+	}
+}
+
+// deliver posts one event, reporting whether a failure is worth retrying
+func (p *WebhookPublisher) deliver(ctx context.Context, id string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderEventID, id)
+	req.Header.Set(HeaderSignature, "sha256="+Sign(p.opts.Secret, timestamp, body))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("webhook rejected event with status %d", resp.StatusCode)
+	}
+}
+
+// Sign returns the hex HMAC-SHA256 signature of a webhook delivery
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *WebhookPublisher) deadLetter(event Event, attempts int, cause error) {
+	p.logger.Error("Webhook event undeliverable",
+		zap.String("url", p.opts.URL),
+		zap.String("event_id", event.ID),
+		zap.String("serial", event.Serial),
+		zap.Int("attempts", attempts),
+		zap.Error(cause),
+	)
+	if p.opts.DeadLetterPath == "" {
+		return
+	}
+
+	line, err := json.Marshal(deadLetter{
+		Time:     time.Now().UTC(),
+		URL:      p.opts.URL,
+		Attempts: attempts,
+		Error:    cause.Error(),
+		Event:    event,
+	})
+	if err != nil {
+		return
+	}
+
+	p.deadLetterMu.Lock()
+	defer p.deadLetterMu.Unlock()
+
+	file, err := os.OpenFile(p.opts.DeadLetterPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		p.logger.Error("Failed to open webhook dead-letter log", zap.Error(err))
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		p.logger.Error("Failed to write webhook dead-letter log", zap.Error(err))
+	}
+}
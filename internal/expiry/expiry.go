@@ -0,0 +1,74 @@
+// Package expiry decides how responses answer for certificates past their notAfter: with the
+// last known status for a grace period, with unknown, or with the last known status and an
+// archive cutoff (RFC 6960 section 4.4.4) telling relying parties how far back it is kept
+package expiry
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"time"
+)
+
+// Policies
+const (
+	// PolicyServe answers with the last known status for Grace after notAfter, then unknown
+	PolicyServe = "serve"
+	// PolicyUnknown answers unknown from notAfter
+	PolicyUnknown = "unknown"
+	// PolicyArchiveCutoff keeps answering with the last known status, adding an archive cutoff
+	PolicyArchiveCutoff = "archive_cutoff"
+)
+
+// OIDArchiveCutoff is id-pkix-ocsp-archive-cutoff
+var OIDArchiveCutoff = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 6}
+
+// Policy is how one issuer answers for its expired certificates
+type Policy struct {
+	Name string
+	// Grace is how long PolicyServe keeps answering with the last known status
+	Grace time.Duration
+	// Retention is how long PolicyArchiveCutoff keeps statuses of expired certificates: the
+	// archive cutoff is the time of the response less Retention
+	Retention time.Duration
+}
+
+// Decision is how to answer for one certificate
+type Decision struct {
+	// Unknown answers unknown instead of the stored status
+	Unknown bool
+	// Until, when set, is when the decision changes; responses should not be valid past it
+	Until time.Time
+	// ArchiveCutoff, when set, goes in an archive cutoff extension
+	ArchiveCutoff time.Time
+}
+
+// Decide returns how to answer at now for a certificate expiring at notAfter. Certificates with
+// no recorded expiry are answered as stored
+func (p Policy) Decide(notAfter *time.Time, now time.Time) Decision {
+	if notAfter == nil {
+		return Decision{}
+	}
+	if now.Before(*notAfter) {
+		return Decision{Until: *notAfter}
+	}
+	switch p.Name {
+	case PolicyServe:
+		if end := notAfter.Add(p.Grace); now.Before(end) {
+			return Decision{Until: end}
+		}
+		return Decision{Unknown: true}
+	case PolicyArchiveCutoff:
+		return Decision{ArchiveCutoff: now.Add(-p.Retention)}
+	default:
+		return Decision{Unknown: true}
+	}
+}
+
+// Extension returns the archive cutoff extension for cutoff
+func Extension(cutoff time.Time) (pkix.Extension, error) {
+	value, err := asn1.MarshalWithParams(cutoff.UTC().Truncate(time.Second), "generalized")
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: OIDArchiveCutoff, Value: value}, nil
+}
@@ -0,0 +1,374 @@
+// Package failover holds and replays status writes while the status database fails over
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// ErrFailover is returned for calls that could not be served while the database failed over;
+// callers may retry them
+var ErrFailover = errors.New("database failover in progress")
+
+// Held write results, used in metric labels
+const (
+	resultHeld     = "held"
+	resultReplayed = "replayed"
+	resultFailed   = "failed"
+	resultExpired  = "expired"
+	resultRejected = "rejected"
+)
+
+var (
+	failovers = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "database_failovers_total",
+		Help:      "Failovers of the status database detected, each pausing writes until the primary accepts them again.",
+	})
+	paused = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "database_failover_paused",
+		Help:      "1 while status writes are held for a database failover, 0 otherwise.",
+	})
+	held = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "database_failover_writes_total",
+		Help:      "Status writes held during database failovers, by result: held, replayed, failed (on replay), expired (waited too long) or rejected (queue full).",
+	}, []string{"result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(failovers, paused, held)
+}
+
+// IsFailover reports whether err shows the primary going away or refusing writes: a dropped or
+// refused connection, a server shutting down or starting up, or a read-only transaction on a
+// demoted primary. Errors of the call's own context are not failovers
+func IsFailover(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "25006", // read_only_sql_transaction
+			"57P01", // admin_shutdown
+			"57P02", // crash_shutdown
+			"57P03": // cannot_connect_now
+			return true
+		}
+		// Class 08: connection exceptions
+		return strings.HasPrefix(pgErr.Code, "08")
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Primary is the database whose failovers are ridden out
+type Primary interface {
+	// Reset closes the connections, so new ones resolve and reach the current primary
+	Reset()
+	// Writable reports whether the database reached accepts writes
+	Writable(ctx context.Context) (bool, error)
+}
+
+// Options configures a Store
+type Options struct {
+	// QueueSize bounds the writes held during a failover; later ones fail at once
+	QueueSize int
+	// MaxWait bounds how long a write is held
+	MaxWait time.Duration
+	// ProbeInterval is how often the primary is probed while writes are held
+	ProbeInterval time.Duration
+}
+
+// Held write states
+const (
+	waiting int32 = iota
+	claimed
+	abandoned
+)
+
+// write is a held write and the caller waiting for it
+type write struct {
+	ctx   context.Context
+	apply func(ctx context.Context) error
+	state atomic.Int32
+	done  chan error
+}
+
+// claim takes the write for replay, unless its caller gave up on it
+func (w *write) claim() bool {
+	return w.state.CompareAndSwap(waiting, claimed)
+}
+
+// abandon gives up on the write, unless it is being replayed
+func (w *write) abandon() bool {
+	return w.state.CompareAndSwap(waiting, abandoned)
+}
+
+// Store holds writes to the wrapped store through failovers of primary; Run must be running
+type Store struct {
+	storage.Store
+	primary Primary
+	opts    Options
+	logger  *logger.Logger
+	wake    chan struct{}
+
+	mu     sync.Mutex
+	paused bool
+	since  time.Time
+	queue  []*write
+}
+
+// NewStore wraps store, whose writes go to primary
+func NewStore(store storage.Store, primary Primary, opts Options, logger *logger.Logger) *Store {
+	return &Store{Store: store, primary: primary, opts: opts, logger: logger, wake: make(chan struct{}, 1)}
+}
+
+// Get returns the status for a serial. A failover fails the lookup with ErrFailover and
+// pauses writes
+func (s *Store) Get(ctx context.Context, serial string) (*storage.Record, error) {
+	rec, err := s.Store.Get(ctx, serial)
+	return rec, s.read(err)
+}
+
+// ListRevoked returns every revoked serial. A failover fails the listing with ErrFailover and
+// pauses writes
+func (s *Store) ListRevoked(ctx context.Context) ([]storage.Record, error) {
+	records, err := s.Store.ListRevoked(ctx)
+	return records, s.read(err)
+}
+
+func (s *Store) read(err error) error {
+	if !IsFailover(err) {
+		return err
+	}
+	s.pause(err)
+	return fmt.Errorf("%w: %w", ErrFailover, err)
+}
+
+// Upsert inserts or replaces the status for a single serial, held through a failover
+func (s *Store) Upsert(ctx context.Context, update storage.Update) error {
+	return s.write(ctx, func(ctx context.Context) error {
+		return s.Store.Upsert(ctx, update)
+	})
+}
+
+// ApplyBatch applies all updates atomically, held through a failover
+func (s *Store) ApplyBatch(ctx context.Context, updates []storage.Update) error {
+	return s.write(ctx, func(ctx context.Context) error {
+		return s.Store.ApplyBatch(ctx, updates)
+	})
+}
+
+// InsertMissing seeds statuses through the wrapped store, which must be a storage.Seeder,
+// held through a failover
+func (s *Store) InsertMissing(ctx context.Context, updates []storage.Update) (int, error) {
+	seeder, ok := s.Store.(storage.Seeder)
+	if !ok {
+		return 0, fmt.Errorf("store does not support seeding")
+	}
+	var inserted int
+	err := s.write(ctx, func(ctx context.Context) (err error) {
+		inserted, err = seeder.InsertMissing(ctx, updates)
+		return err
+	})
+	return inserted, err
+}
+
+// ApplyIfNewer applies a replicated status through the wrapped store, which must be a
+// storage.Replica, held through a failover
+func (s *Store) ApplyIfNewer(ctx context.Context, rec storage.Record) (bool, error) {
+	replica, ok := s.Store.(storage.Replica)
+	if !ok {
+		return false, fmt.Errorf("store does not support replication")
+	}
+	var stored bool
+	err := s.write(ctx, func(ctx context.Context) (err error) {
+		stored, err = replica.ApplyIfNewer(ctx, rec)
+		return err
+	})
+	return stored, err
+}
+
+// Paused reports whether writes are held for a failover
+func (s *Store) Paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// write applies a write at once unless writes are paused; one failing for a failover pauses
+// them and is held
+func (s *Store) write(ctx context.Context, apply func(ctx context.Context) error) error {
+	if !s.Paused() {
+		err := apply(ctx)
+		if !IsFailover(err) {
+			return err
+		}
+		s.pause(err)
+	}
+	return s.hold(ctx, apply)
+}
+
+// hold queues a write until it is replayed, or fails it with ErrFailover once it waited MaxWait
+// or with ctx's error when that ends first
+func (s *Store) hold(ctx context.Context, apply func(ctx context.Context) error) error {
+	w := &write{ctx: ctx, apply: apply, done: make(chan error, 1)}
+	s.mu.Lock()
+	if !s.paused {
+		// The primary came back since the write failed
+		s.mu.Unlock()
+		return s.write(ctx, apply)
+	}
+	live := s.queue[:0]
+	for _, queued := range s.queue {
+		if queued.state.Load() != abandoned {
+			live = append(live, queued)
+		}
+	}
+	s.queue = live
+	if len(s.queue) >= s.opts.QueueSize {
+		s.mu.Unlock()
+		held.WithLabelValues(resultRejected).Inc()
+		return fmt.Errorf("%w: %d writes already held", ErrFailover, s.opts.QueueSize)
+	}
+	s.queue = append(s.queue, w)
+	s.mu.Unlock()
+	held.WithLabelValues(resultHeld).Inc()
+
+	timer := time.NewTimer(s.opts.MaxWait)
+	defer timer.Stop()
+	select {
+	case err := <-w.done:
+		return err
+	case <-timer.C:
+		if w.abandon() {
+			held.WithLabelValues(resultExpired).Inc()
+			return fmt.Errorf("%w: primary not writable after %s", ErrFailover, s.opts.MaxWait)
+		}
+	case <-ctx.Done():
+		if w.abandon() {
+			held.WithLabelValues(resultExpired).Inc()
+			return ctx.Err()
+		}
+	}
+	// The write is being replayed; its outcome is the caller's
+	return <-w.done
+}
+
+// pause holds writes and drops the connections, once per failover
+func (s *Store) pause(cause error) {
+	s.mu.Lock()
+	first := !s.paused
+	if first {
+		s.paused, s.since = true, time.Now()
+	}
+	s.mu.Unlock()
+	if !first {
+		return
+	}
+	failovers.Inc()
+	paused.Set(1)
+	s.logger.Warn("Database failover detected, holding status writes until the primary accepts them", zap.Error(cause))
+	s.primary.Reset()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run probes the primary every probe interval while writes are held, replaying them once it
+// accepts writes, until ctx is cancelled
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+		if s.Paused() {
+			s.recover(ctx)
+		}
+	}
+}
+
+// recover probes the primary and, if it accepts writes, replays the held writes in order.
+// Writes are resumed only once the queue is empty, so none overtakes a held one
+func (s *Store) recover(ctx context.Context) {
+	probeCtx, cancel := context.WithTimeout(ctx, s.opts.ProbeInterval)
+	writable, err := s.primary.Writable(probeCtx)
+	cancel()
+	if err != nil || !writable {
+		s.logger.Debug("Database primary not writable yet", zap.Bool("writable", writable), zap.Error(err))
+		// A demoted primary reached through a stale address answers as read-only
+		s.primary.Reset()
+		return
+	}
+
+	replayed := 0
+	for {
+		s.mu.Lock()
+		batch := s.queue
+		s.queue = nil
+		if len(batch) == 0 {
+			s.paused = false
+			since := s.since
+			s.mu.Unlock()
+			paused.Set(0)
+			s.logger.Info("Database primary accepts writes again, resumed status writes",
+				zap.Duration("paused_for", time.Since(since)), zap.Int("replayed", replayed))
+			return
+		}
+		s.mu.Unlock()
+
+		for i, w := range batch {
+			if !w.claim() {
+				continue
+			}
+			writeCtx, cancel := context.WithTimeout(context.WithoutCancel(w.ctx), s.opts.MaxWait)
+			err := w.apply(writeCtx)
+			cancel()
+			if IsFailover(err) {
+				// Failed over again: this write's caller may already have given up waiting, so
+				// it fails; the rest stay held and probing goes on
+				held.WithLabelValues(resultFailed).Inc()
+				w.done <- fmt.Errorf("%w: %w", ErrFailover, err)
+				s.mu.Lock()
+				s.queue = append(batch[i+1:], s.queue...)
+				s.mu.Unlock()
+				s.logger.Warn("Database failed over again while replaying held status writes", zap.Error(err))
+				s.primary.Reset()
+				return
+			}
+			if err != nil {
+				held.WithLabelValues(resultFailed).Inc()
+			} else {
+				held.WithLabelValues(resultReplayed).Inc()
+				replayed++
+			}
+			w.done <- err
+		}
+	}
+}
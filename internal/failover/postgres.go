@@ -0,0 +1,30 @@
+package failover
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Postgres is a primary reached through a connection pool, by an address that follows the
+// primary such as a Patroni or RDS endpoint
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgres creates the primary of pool
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Reset closes the pool's connections; new ones resolve the address again
+func (p *Postgres) Reset() {
+	p.db.Reset()
+}
+
+// Writable reports whether the server reached is a primary accepting writes
+func (p *Postgres) Writable(ctx context.Context) (bool, error) {
+	var writable bool
+	err := p.db.QueryRow(ctx, `SELECT NOT pg_is_in_recovery() AND current_setting('transaction_read_only') = 'off'`).Scan(&writable)
+	return writable, err
+}
@@ -0,0 +1,216 @@
+// Package featureflag gates risky behaviors behind feature flags that can be rolled out to a
+// percentage of serials, changed on reload, and optionally shared by replicas through Postgres
+package featureflag
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Known flags
+const (
+	// RevokedForUnknown answers lookups for serials with no status as revoked (certificateHold,
+	// revoked at the epoch) as RFC 6960 section 2.2 allows for never-issued certificates
+	RevokedForUnknown = "revoked_for_unknown"
+	// UpstreamFallback resolves serials with no status at the upstream OCSP responder, when
+	// upstream_ocsp is enabled
+	UpstreamFallback = "upstream_fallback"
+)
+
+// defaults is the rule of every known flag when neither the config nor the database sets one
+var defaults = map[string]Rule{
+	RevokedForUnknown: {},
+	UpstreamFallback:  {Enabled: true, Percent: 100},
+}
+
+// Known reports whether name is a flag the service evaluates
+func Known(name string) bool {
+	_, ok := defaults[name]
+	return ok
+}
+
+// Names returns the known flag names, sorted
+func Names() []string {
+	names := make([]string, 0, len(defaults))
+	for name := range defaults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var rollout = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metrics.Namespace,
+	Name:      "feature_flag_rollout_percent",
+	Help:      "Percentage of serials each feature flag is on for (0 when disabled).",
+}, []string{"flag"})
+
+func init() {
+	metrics.Registry.MustRegister(rollout)
+}
+
+// Rule decides which serials a flag is on for: the listed serials always, and otherwise a
+// stable Percent of serials chosen by hash, so a serial sees the same behavior on every replica
+type Rule struct {
+	Enabled bool     `json:"enabled"`
+	Percent int      `json:"percent"`
+	Serials []string `json:"serials,omitempty"`
+}
+
+// Validate checks the rollout percentage
+func (r Rule) Validate() error {
+	if r.Percent < 0 || r.Percent > 100 {
+		return fmt.Errorf("percent must be between 0 and 100, got %d", r.Percent)
+	}
+	return nil
+}
+
+func (r Rule) on(serial string) bool {
+	if !r.Enabled {
+		return false
+	}
+	for _, s := range r.Serials {
+		if s == serial {
+			return true
+		}
+	}
+	if r.Percent >= 100 {
+		return true
+	}
+	return bucket(serial) < r.Percent
+}
+
+// bucket maps a serial to 0..99
+func bucket(serial string) int {
+	h := fnv.New32a()
+	h.Write([]byte(serial))
+	return int(h.Sum32() % 100)
+}
+
+// Set holds the effective flag rules: database rules override config rules, which override
+// the defaults
+type Set struct {
+	mu       sync.RWMutex
+	config   map[string]Rule
+	database map[string]Rule
+}
+
+// NewSet creates a flag set from configured rules
+func NewSet(rules map[string]Rule) *Set {
+	s := &Set{}
+	s.SetConfig(rules)
+	return s
+}
+
+// SetConfig replaces the configured rules, as on reload
+func (s *Set) SetConfig(rules map[string]Rule) {
+	s.mu.Lock()
+	s.config = rules
+	s.mu.Unlock()
+	s.export()
+}
+
+// SetDatabase replaces the rules loaded from the database
+func (s *Set) SetDatabase(rules map[string]Rule) {
+	s.mu.Lock()
+	s.database = rules
+	s.mu.Unlock()
+	s.export()
+}
+
+// Rule returns the effective rule for a flag
+func (s *Set) Rule(name string) Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rule(name)
+}
+
+func (s *Set) rule(name string) Rule {
+	if rule, ok := s.database[name]; ok {
+		return rule
+	}
+	if rule, ok := s.config[name]; ok {
+		return rule
+	}
+	return defaults[name]
+}
+
+// On reports whether a flag is on for serial
+func (s *Set) On(name, serial string) bool {
+	return s.Rule(name).on(serial)
+}
+
+// Rules returns the effective rule of every known flag
+func (s *Set) Rules() map[string]Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rules := make(map[string]Rule, len(defaults))
+	for name := range defaults {
+		rules[name] = s.rule(name)
+	}
+	return rules
+}
+
+func (s *Set) export() {
+	for name, rule := range s.Rules() {
+		percent := 0
+		if rule.Enabled {
+			percent = rule.Percent
+		}
+		rollout.WithLabelValues(name).Set(float64(percent))
+	}
+}
+
+// Watch reloads database rules every interval until the context is cancelled; a failed load
+// keeps the previous rules
+func (s *Set) Watch(ctx context.Context, db *Postgres, interval time.Duration, logger *logger.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		rules, err := db.Load(ctx)
+		if err != nil {
+			logger.Warn("Failed to load feature flags", zap.Error(err))
+		} else {
+			s.SetDatabase(rules)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Gate sends Get to on for serials a flag is on for, and to off otherwise; every other
+// method goes to off
+type Gate struct {
+	storage.Store
+	on   storage.Store
+	flag string
+	set  *Set
+}
+
+// NewGate routes lookups between off and on by flag
+func NewGate(set *Set, flag string, off, on storage.Store) *Gate {
+	return &Gate{Store: off, on: on, flag: flag, set: set}
+}
+
+// Get returns the status from the store the flag selects for serial
+func (g *Gate) Get(ctx context.Context, serial string) (*storage.Record, error) {
+	if g.set.On(g.flag, serial) {
+		return g.on.Get(ctx, serial)
+	}
+	return g.Store.Get(ctx, serial)
+}
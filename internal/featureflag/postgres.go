@@ -0,0 +1,61 @@
+package featureflag
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Postgres stores flag rules in the feature_flags table so every replica shares them
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgres creates a Postgres flag store
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Load returns every stored rule keyed by flag name
+func (p *Postgres) Load(ctx context.Context) (map[string]Rule, error) {
+	rows, err := p.db.Query(ctx, `SELECT name, enabled, percent, serials FROM feature_flags`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make(map[string]Rule)
+	for rows.Next() {
+		var name string
+		var rule Rule
+		if err := rows.Scan(&name, &rule.Enabled, &rule.Percent, &rule.Serials); err != nil {
+			return nil, err
+		}
+		rules[name] = rule
+	}
+	return rules, rows.Err()
+}
+
+// Save inserts or replaces the rule for a flag
+func (p *Postgres) Save(ctx context.Context, name string, rule Rule) error {
+	serials := rule.Serials
+	if serials == nil {
+		serials = []string{}
+	}
+	_, err := p.db.Exec(ctx, `
+		INSERT INTO feature_flags (name, enabled, percent, serials, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (name) DO UPDATE SET
+			enabled = EXCLUDED.enabled,
+			percent = EXCLUDED.percent,
+			serials = EXCLUDED.serials,
+			updated_at = NOW()
+	`, name, rule.Enabled, rule.Percent, serials)
+	return err
+}
+
+// Delete removes the stored rule for a flag, falling back to the configured one
+func (p *Postgres) Delete(ctx context.Context, name string) error {
+	_, err := p.db.Exec(ctx, `DELETE FROM feature_flags WHERE name = $1`, name)
+	return err
+}
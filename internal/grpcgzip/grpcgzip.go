@@ -0,0 +1,88 @@
+// Package grpcgzip provides the gzip compressor for gRPC. Unlike google.golang.org/grpc/encoding/gzip,
+// which registers itself on import, it is registered only when compression is configured, so a
+// server without it refuses compressed requests instead of accepting them unasked
+package grpcgzip
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// Name is the grpc-encoding clients request
+const Name = "gzip"
+
+// Register makes gzip available to every gRPC server and client of the process, compressing at
+// level. It must be called before any server starts or connection is made
+func Register(level int) error {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return fmt.Errorf("invalid gzip level %d", level)
+	}
+	c := &compressor{level: level}
+	c.writers.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, c.level)
+		return &writer{Writer: w, pool: &c.writers}
+	}
+	encoding.RegisterCompressor(c)
+	return nil
+}
+
+type compressor struct {
+	level   int
+	writers sync.Pool
+	readers sync.Pool
+}
+
+func (c *compressor) Name() string {
+	return Name
+}
+
+func (c *compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	z := c.writers.Get().(*writer)
+	z.Reset(w)
+	return z, nil
+}
+
+func (c *compressor) Decompress(r io.Reader) (io.Reader, error) {
+	z, ok := c.readers.Get().(*reader)
+	if !ok {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &reader{Reader: gz, pool: &c.readers}, nil
+	}
+	if err := z.Reset(r); err != nil {
+		c.readers.Put(z)
+		return nil, err
+	}
+	return z, nil
+}
+
+// writer returns itself to the pool once closed
+type writer struct {
+	*gzip.Writer
+	pool *sync.Pool
+}
+
+func (w *writer) Close() error {
+	defer w.pool.Put(w)
+	return w.Writer.Close()
+}
+
+// reader returns itself to the pool once the message has been read to the end
+type reader struct {
+	*gzip.Reader
+	pool *sync.Pool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		r.pool.Put(r)
+	}
+	return n, err
+}
@@ -0,0 +1,244 @@
+// Package guardrail stops writes that would revoke more serials in a time window than an
+// operator would plausibly intend, unless the caller confirms them
+package guardrail
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Actions taken when a write exceeds a limit
+const (
+	ActionBlock   = "block"
+	ActionConfirm = "confirm"
+)
+
+// ConfirmHeader and ConfirmMetadataKey carry a confirmation token over HTTP and gRPC
+const (
+	ConfirmHeader      = "X-Confirm-Revocations"
+	ConfirmMetadataKey = "x-confirm-revocations"
+)
+
+// populationTTL is how long the status population count is reused; it moves slowly and
+// counting it scans the whole table
+const populationTTL = 5 * time.Minute
+
+var tripped = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "guardrail_tripped_total",
+	Help:      "Writes that exceeded a mass-revocation limit, by rule (count or percent) and outcome (blocked or confirmed).",
+}, []string{"rule", "outcome"})
+
+func init() {
+	metrics.Registry.MustRegister(tripped)
+}
+
+// Limits bound revocations per window
+type Limits struct {
+	Window time.Duration
+	// MaxRevocations is the most serials that may be revoked in Window; 0 disables the rule
+	MaxRevocations int
+	// MaxPercent is the largest share of known serials that may be revoked in Window; 0
+	// disables the rule
+	MaxPercent float64
+	// MinPopulation keeps the percent rule from firing while few serials are known
+	MinPopulation int64
+	// Action is ActionBlock or ActionConfirm
+	Action string
+}
+
+// LimitError reports a write refused by a limit. Token is set when the write may be retried
+// with a confirmation
+type LimitError struct {
+	Rule    string
+	Message string
+	Token   string
+}
+
+func (e *LimitError) Error() string {
+	if e.Token == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s; resend with confirmation %s to proceed", e.Message, e.Token)
+}
+
+type confirmKey struct{}
+
+type checkedKey struct{}
+
+// WithConfirmation returns a context carrying a caller's confirmation token
+func WithConfirmation(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, confirmKey{}, token)
+}
+
+func confirmationFrom(ctx context.Context) string {
+	token, _ := ctx.Value(confirmKey{}).(string)
+	return token
+}
+
+// HTTPMiddleware attaches the X-Confirm-Revocations header to the request context
+func HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := r.Header.Get(ConfirmHeader); token != "" {
+			r = r.WithContext(WithConfirmation(r.Context(), token))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UnaryServerInterceptor attaches x-confirm-revocations metadata to the RPC context
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if values := md.Get(ConfirmMetadataKey); len(values) > 0 {
+			ctx = WithConfirmation(ctx, values[0])
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Store checks revocations against the limits before passing writes to next. Counts come from
+// the database, so the limits hold across replicas
+type Store struct {
+	next   storage.Store
+	db     *Postgres
+	limits Limits
+	logger *logger.Logger
+
+	mu           sync.Mutex
+	population   int64
+	populationAt time.Time
+}
+
+// NewStore creates a guardrail in front of next
+func NewStore(next storage.Store, db *Postgres, limits Limits, logger *logger.Logger) *Store {
+	return &Store{next: next, db: db, limits: limits, logger: logger}
+}
+
+// Get passes through
+func (s *Store) Get(ctx context.Context, serial string) (*storage.Record, error) {
+	return s.next.Get(ctx, serial)
+}
+
+// ListRevoked passes through
+func (s *Store) ListRevoked(ctx context.Context) ([]storage.Record, error) {
+	return s.next.ListRevoked(ctx)
+}
+
+// Upsert refuses a revocation over the limits with a *LimitError
+func (s *Store) Upsert(ctx context.Context, update storage.Update) error {
+	if err := s.Check(ctx, []storage.Update{update}); err != nil {
+		return err
+	}
+	return s.next.Upsert(ctx, update)
+}
+
+// ApplyBatch refuses a batch whose revocations would exceed the limits with a *LimitError
+func (s *Store) ApplyBatch(ctx context.Context, updates []storage.Update) error {
+	if err := s.Check(ctx, updates); err != nil {
+		return err
+	}
+	return s.next.ApplyBatch(ctx, updates)
+}
+
+// Check returns a *LimitError when updates, written together, would exceed the limits
+func (s *Store) Check(ctx context.Context, updates []storage.Update) error {
+	revocations := 0
+	for _, u := range updates {
+		if u.Status == storage.StatusRevoked {
+			revocations++
+		}
+	}
+	if revocations == 0 || ctx.Value(checkedKey{}) != nil {
+		return nil
+	}
+
+	recent, err := s.db.CountRevokedSince(ctx, s.limits.Window)
+	if err != nil {
+		return fmt.Errorf("failed to count recent revocations: %w", err)
+	}
+	total := recent + int64(revocations)
+
+	rule, message := "", ""
+	if s.limits.MaxRevocations > 0 && total > int64(s.limits.MaxRevocations) {
+		rule = "count"
+		message = fmt.Sprintf("revoking %d more serials would make %d in %s, over the limit of %d",
+			revocations, total, s.limits.Window, s.limits.MaxRevocations)
+	} else if s.limits.MaxPercent > 0 {
+		population, err := s.countPopulation(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to count known serials: %w", err)
+		}
+		if population >= s.limits.MinPopulation && population > 0 {
+			percent := float64(total) * 100 / float64(population)
+			if percent > s.limits.MaxPercent {
+				rule = "percent"
+				message = fmt.Sprintf("revoking %d more serials would make %.1f%% of %d known serials in %s, over the limit of %s%%",
+					revocations, percent, population, s.limits.Window, strconv.FormatFloat(s.limits.MaxPercent, 'f', -1, 64))
+			}
+		}
+	}
+	if rule == "" {
+		return nil
+	}
+
+	if s.limits.Action == ActionConfirm {
+		now := time.Now()
+		if token := confirmationFrom(ctx); token != "" && (token == s.token(now) || token == s.token(now.Add(-s.limits.Window))) {
+			tripped.WithLabelValues(rule, "confirmed").Inc()
+			s.logger.Warn("Confirmed revocations over the mass-revocation limit",
+				zap.String("rule", rule),
+				zap.Int("revocations", revocations),
+				zap.Int64("in_window", total),
+			)
+			return nil
+		}
+		tripped.WithLabelValues(rule, "blocked").Inc()
+		return &LimitError{Rule: rule, Message: message, Token: s.token(now)}
+	}
+	tripped.WithLabelValues(rule, "blocked").Inc()
+	s.logger.Warn("Blocked revocations over the mass-revocation limit", zap.String("rule", rule), zap.Int("revocations", revocations))
+	return &LimitError{Rule: rule, Message: message}
+}
+
+// Checked returns a context whose writes skip the limits, for items of a batch that already
+// passed Check as a whole
+func (s *Store) Checked(ctx context.Context) context.Context {
+	return context.WithValue(ctx, checkedKey{}, true)
+}
+
+// token is the confirmation for the window containing t. It only proves the caller read the
+// refusal, not who they are; approvals cover authorization
+func (s *Store) token(t time.Time) string {
+	bucket := t.UTC().Truncate(s.limits.Window).Unix()
+	sum := sha256.Sum256([]byte("ocsp-mass-revocation:" + strconv.FormatInt(bucket, 10)))
+	return hex.EncodeToString(sum[:6])
+}
+
+func (s *Store) countPopulation(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.populationAt) < populationTTL {
+		return s.population, nil
+	}
+	population, err := s.db.CountSerials(ctx)
+	if err != nil {
+		return 0, err
+	}
+	s.population, s.populationAt = population, time.Now()
+	return population, nil
+}
@@ -0,0 +1,35 @@
+package guardrail
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Postgres counts revocations in the ocsp_responses table
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgres creates a Postgres revocation counter
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+// CountRevokedSince returns how many serials had a revoked status written within window
+func (p *Postgres) CountRevokedSince(ctx context.Context, window time.Duration) (int64, error) {
+	var n int64
+	err := p.db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM ocsp_responses
+		WHERE status = 'revoked' AND this_update > NOW() - make_interval(secs => $1::float8)
+	`, window.Seconds()).Scan(&n)
+	return n, err
+}
+
+// CountSerials returns how many serials have a status
+func (p *Postgres) CountSerials(ctx context.Context) (int64, error) {
+	var n int64
+	err := p.db.QueryRow(ctx, `SELECT COUNT(*) FROM ocsp_responses`).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,303 @@
+// Package hold suspends certificates for a bounded time: a timed hold revokes a serial with
+// certificateHold and records when it ends, and the leader returns the serial to good at that
+// time unless the hold was extended, released early or made a permanent revocation
+package hold
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Hold states. A lapsed hold found its serial changed by another write when it ended, and was
+// left alone
+const (
+	StateHeld     = "held"
+	StateReleased = "released"
+	StateRevoked  = "revoked"
+	StateLapsed   = "lapsed"
+)
+
+var (
+	// ErrNotFound is returned for serials that were never held
+	ErrNotFound = errors.New("no timed hold for this serial")
+	// ErrNotHeld is returned when the hold already ended
+	ErrNotHeld = errors.New("the timed hold has already ended")
+	// ErrAlreadyHeld is returned when placing a hold on a serial that has one; extend it instead
+	ErrAlreadyHeld = errors.New("serial already has a timed hold; extend it instead")
+	// ErrNeedsApproval is returned for changes the approval policy would stage, which must be
+	// submitted through the approval flow
+	ErrNeedsApproval = errors.New("this change needs a second approval; submit it as a status update")
+	// ErrInvalid is wrapped by the errors for holds that cannot be placed or changed as given
+	ErrInvalid = errors.New("invalid timed hold")
+)
+
+var outcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "timed_holds_total",
+	Help:      "Timed certificate holds, by outcome: held, extended, released, revoked or lapsed.",
+}, []string{"outcome"})
+
+func init() {
+	metrics.Registry.MustRegister(outcomes)
+}
+
+// Hold is a timed hold of a serial
+type Hold struct {
+	Serial    string     `json:"serial"`
+	State     string     `json:"state"`
+	ReleaseAt time.Time  `json:"release_at"`
+	HeldBy    string     `json:"held_by,omitempty"`
+	HeldAt    time.Time  `json:"held_at"`
+	DecidedBy string     `json:"decided_by,omitempty"`
+	DecidedAt *time.Time `json:"decided_at,omitempty"`
+}
+
+// ApprovalGate reports whether updates would be staged for a second approval
+type ApprovalGate interface {
+	RequiresApproval(updates []storage.Update) bool
+}
+
+// Manager places, changes and releases timed holds
+type Manager struct {
+	db          *Postgres
+	store       storage.Store
+	approvals   ApprovalGate
+	maxDuration time.Duration
+	batchSize   int
+	logger      *logger.Logger
+}
+
+// NewManager creates a manager writing statuses to store. A hold may end at most maxDuration
+// ahead, also when extended; each run releases at most batchSize holds
+func NewManager(db *Postgres, store storage.Store, maxDuration time.Duration, batchSize int, logger *logger.Logger) *Manager {
+	return &Manager{db: db, store: store, maxDuration: maxDuration, batchSize: batchSize, logger: logger}
+}
+
+// SetApprovalGate makes the manager refuse holds and permanent revocations the approval policy
+// would stage, rather than writing them around it
+func (m *Manager) SetApprovalGate(gate ApprovalGate) {
+	m.approvals = gate
+}
+
+// Hold revokes serial with certificateHold until releaseAt on behalf of the principal in ctx. A
+// serial already on hold keeps its revocation time and gains the release time
+func (m *Manager) Hold(ctx context.Context, serial string, releaseAt time.Time) (*Hold, error) {
+	if err := m.checkRelease(releaseAt); err != nil {
+		return nil, err
+	}
+	update := storage.Update{Serial: serial, Status: storage.StatusRevoked, RevocationReason: revocation.ReasonCertificateHold}
+	if err := m.gate(update); err != nil {
+		return nil, err
+	}
+	current, err := m.current(ctx, serial)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && current.Status == storage.StatusRevoked && !onHold(current) {
+		return nil, fmt.Errorf("%w: %s is permanently revoked", ErrInvalid, serial)
+	}
+
+	if err := m.db.Create(ctx, serial, releaseAt, approval.PrincipalFrom(ctx)); err != nil {
+		return nil, err
+	}
+	if !onHold(current) {
+		now := time.Now().UTC()
+		update.RevokedAt = &now
+		if err := m.store.Upsert(ctx, update); err != nil {
+			if deleteErr := m.db.Delete(ctx, serial); deleteErr != nil {
+				m.logger.Error("Failed to remove timed hold after its write failed", zap.String("serial", serial), zap.Error(deleteErr))
+			}
+			return nil, err
+		}
+	}
+	outcomes.WithLabelValues("held").Inc()
+	m.logger.Info("Placed timed hold", zap.String("serial", serial), zap.Time("release_at", releaseAt))
+	return m.db.Get(ctx, serial)
+}
+
+// Extend moves the end of a hold to releaseAt, which may also bring it forward
+func (m *Manager) Extend(ctx context.Context, serial string, releaseAt time.Time) (*Hold, error) {
+	if err := m.checkRelease(releaseAt); err != nil {
+		return nil, err
+	}
+	if err := m.db.Extend(ctx, serial, releaseAt); err != nil {
+		return nil, err
+	}
+	outcomes.WithLabelValues("extended").Inc()
+	m.logger.Info("Extended timed hold", zap.String("serial", serial), zap.Time("release_at", releaseAt))
+	return m.db.Get(ctx, serial)
+}
+
+// Release ends a hold now on behalf of the principal in ctx, returning the serial to good
+func (m *Manager) Release(ctx context.Context, serial string) (*Hold, error) {
+	if err := m.release(ctx, serial, approval.PrincipalFrom(ctx)); err != nil {
+		return nil, err
+	}
+	return m.db.Get(ctx, serial)
+}
+
+// Revoke makes a hold a permanent revocation for reason on behalf of the principal in ctx,
+// keeping the time the hold was placed as the revocation time
+func (m *Manager) Revoke(ctx context.Context, serial, reason string) (*Hold, error) {
+	if reason == revocation.ReasonCertificateHold {
+		return nil, fmt.Errorf("%w: a permanent revocation needs a reason other than %s", ErrInvalid, reason)
+	}
+	update := storage.Update{Serial: serial, Status: storage.StatusRevoked, RevocationReason: reason}
+	if err := m.gate(update); err != nil {
+		return nil, err
+	}
+	by := approval.PrincipalFrom(ctx)
+	if err := m.db.Transition(ctx, serial, StateHeld, StateRevoked, by); err != nil {
+		return nil, err
+	}
+
+	current, err := m.current(ctx, serial)
+	if err == nil && !onHold(current) {
+		m.lapse(ctx, serial, StateRevoked, by)
+		return m.db.Get(ctx, serial)
+	}
+	if err == nil {
+		update.RevokedAt = current.RevokedAt
+		if err = revocation.CheckTransition(current, update); err != nil {
+			err = fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+	}
+	if err == nil {
+		err = m.store.Upsert(ctx, update)
+	}
+	if err != nil {
+		m.restore(ctx, serial, StateRevoked)
+		return nil, err
+	}
+	outcomes.WithLabelValues("revoked").Inc()
+	m.logger.Info("Made timed hold permanent", zap.String("serial", serial), zap.String("reason", reason))
+	return m.db.Get(ctx, serial)
+}
+
+// Get returns the hold of serial
+func (m *Manager) Get(ctx context.Context, serial string) (*Hold, error) {
+	return m.db.Get(ctx, serial)
+}
+
+// List returns holds in a state, most recently placed first; an empty state lists every hold
+func (m *Manager) List(ctx context.Context, state string) ([]Hold, error) {
+	return m.db.List(ctx, state)
+}
+
+// Run releases due holds immediately and then on every interval until ctx is cancelled
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.ReleaseDue(ctx); err != nil {
+			m.logger.Error("Failed to release timed holds", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReleaseDue releases the holds that have ended, returning their serials to good. A hold that
+// could not be released stays held for the next run
+func (m *Manager) ReleaseDue(ctx context.Context) error {
+	due, err := m.db.Due(ctx, time.Now(), m.batchSize)
+	if err != nil {
+		return err
+	}
+	for _, h := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err := m.release(ctx, h.Serial, "")
+		if err != nil && !errors.Is(err, ErrNotHeld) {
+			m.logger.Error("Failed to release timed hold; retrying on the next run", zap.String("serial", h.Serial), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// release claims a hold and returns its serial to good, unless another write changed the
+// serial since the hold was placed
+func (m *Manager) release(ctx context.Context, serial, by string) error {
+	// Claiming the hold first keeps a concurrent extension or revocation from being lost
+	if err := m.db.Transition(ctx, serial, StateHeld, StateReleased, by); err != nil {
+		return err
+	}
+	current, err := m.current(ctx, serial)
+	if err == nil && !onHold(current) {
+		m.lapse(ctx, serial, StateReleased, by)
+		return nil
+	}
+	if err == nil {
+		err = m.store.Upsert(ctx, storage.Update{Serial: serial, Status: storage.StatusGood})
+	}
+	if err != nil {
+		m.restore(ctx, serial, StateReleased)
+		return err
+	}
+	outcomes.WithLabelValues("released").Inc()
+	m.logger.Info("Released timed hold", zap.String("serial", serial))
+	return nil
+}
+
+// lapse marks a claimed hold whose serial another write changed, leaving the status alone
+func (m *Manager) lapse(ctx context.Context, serial, from, by string) {
+	if err := m.db.Transition(ctx, serial, from, StateLapsed, by); err != nil {
+		m.logger.Error("Failed to mark timed hold as lapsed", zap.String("serial", serial), zap.Error(err))
+	}
+	outcomes.WithLabelValues("lapsed").Inc()
+	m.logger.Info("Timed hold lapsed; the serial is no longer on hold", zap.String("serial", serial))
+}
+
+// restore returns a claimed hold to held after its write failed
+func (m *Manager) restore(ctx context.Context, serial, from string) {
+	if err := m.db.Transition(ctx, serial, from, StateHeld, ""); err != nil {
+		m.logger.Error("Failed to return timed hold to held", zap.String("serial", serial), zap.Error(err))
+	}
+}
+
+func (m *Manager) checkRelease(releaseAt time.Time) error {
+	now := time.Now()
+	switch {
+	case !releaseAt.After(now):
+		return fmt.Errorf("%w: release_at must be in the future", ErrInvalid)
+	case releaseAt.Sub(now) > m.maxDuration:
+		return fmt.Errorf("%w: release_at is more than %s ahead", ErrInvalid, m.maxDuration)
+	}
+	return nil
+}
+
+func (m *Manager) gate(update storage.Update) error {
+	if m.approvals != nil && m.approvals.RequiresApproval([]storage.Update{update}) {
+		return ErrNeedsApproval
+	}
+	return nil
+}
+
+// current returns the stored status of serial, or nil when it has none
+func (m *Manager) current(ctx context.Context, serial string) (*storage.Record, error) {
+	rec, err := m.store.Get(ctx, serial)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	return rec, err
+}
+
+func onHold(rec *storage.Record) bool {
+	return rec != nil && rec.Status == storage.StatusRevoked && rec.RevocationReason == revocation.ReasonCertificateHold
+}
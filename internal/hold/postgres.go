@@ -0,0 +1,148 @@
+package hold
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// listLimit bounds how many holds List returns
+const listLimit = 500
+
+// Postgres keeps timed holds in the certificate_holds table so every replica sees them and
+// whichever leads releases them
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgres creates a Postgres hold store
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Create records a hold of serial until releaseAt, replacing a finished one. It returns
+// ErrAlreadyHeld when the serial is already held
+func (p *Postgres) Create(ctx context.Context, serial string, releaseAt time.Time, heldBy string) error {
+	tag, err := p.db.Exec(ctx, `
+		INSERT INTO certificate_holds (serial, state, release_at, held_by, held_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (serial) DO UPDATE SET
+			state = EXCLUDED.state,
+			release_at = EXCLUDED.release_at,
+			held_by = EXCLUDED.held_by,
+			held_at = EXCLUDED.held_at,
+			decided_by = NULL,
+			decided_at = NULL
+		WHERE certificate_holds.state <> $2
+	`, serial, StateHeld, releaseAt.UTC(), heldBy)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAlreadyHeld
+	}
+	return nil
+}
+
+// Delete removes the hold of serial if it is still held, undoing Create
+func (p *Postgres) Delete(ctx context.Context, serial string) error {
+	_, err := p.db.Exec(ctx, `DELETE FROM certificate_holds WHERE serial = $1 AND state = $2`, serial, StateHeld)
+	return err
+}
+
+const selectHold = `SELECT serial, state, release_at, held_by, held_at, COALESCE(decided_by, ''), decided_at FROM certificate_holds`
+
+// Get returns the hold of serial, or ErrNotFound
+func (p *Postgres) Get(ctx context.Context, serial string) (*Hold, error) {
+	h, err := scanHold(p.db.QueryRow(ctx, selectHold+` WHERE serial = $1`, serial))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return h, err
+}
+
+// List returns the most recently placed holds in a state, or in any state when state is empty
+func (p *Postgres) List(ctx context.Context, state string) ([]Hold, error) {
+	return p.query(ctx, selectHold+`
+		WHERE $1 = '' OR state = $1
+		ORDER BY held_at DESC
+		LIMIT $2
+	`, state, listLimit)
+}
+
+// Due returns up to limit holds whose release time is at or before now, earliest first
+func (p *Postgres) Due(ctx context.Context, now time.Time, limit int) ([]Hold, error) {
+	return p.query(ctx, selectHold+`
+		WHERE state = $1 AND release_at <= $2
+		ORDER BY release_at, serial
+		LIMIT $3
+	`, StateHeld, now.UTC(), limit)
+}
+
+// Extend moves the release time of a held serial. It returns ErrNotHeld when the serial is
+// not held
+func (p *Postgres) Extend(ctx context.Context, serial string, releaseAt time.Time) error {
+	tag, err := p.db.Exec(ctx, `UPDATE certificate_holds SET release_at = $3 WHERE serial = $1 AND state = $2`,
+		serial, StateHeld, releaseAt.UTC())
+	if err != nil {
+		return err
+	}
+	return p.affected(ctx, serial, tag.RowsAffected())
+}
+
+// Transition moves a hold from one state to another, recording decidedBy when set. It returns
+// ErrNotHeld when the hold is not in state from
+func (p *Postgres) Transition(ctx context.Context, serial, from, to, decidedBy string) error {
+	tag, err := p.db.Exec(ctx, `
+		UPDATE certificate_holds SET
+			state = $3,
+			decided_by = CASE WHEN $3 = $5 THEN NULL ELSE NULLIF($4, '') END,
+			decided_at = CASE WHEN $3 = $5 THEN NULL ELSE NOW() END
+		WHERE serial = $1 AND state = $2
+	`, serial, from, to, decidedBy, StateHeld)
+	if err != nil {
+		return err
+	}
+	return p.affected(ctx, serial, tag.RowsAffected())
+}
+
+// affected returns ErrNotHeld or ErrNotFound when an update of serial matched no row
+func (p *Postgres) affected(ctx context.Context, serial string, rows int64) error {
+	if rows > 0 {
+		return nil
+	}
+	if _, err := p.Get(ctx, serial); err != nil {
+		return err
+	}
+	return ErrNotHeld
+}
+
+func (p *Postgres) query(ctx context.Context, sql string, args ...interface{}) ([]Hold, error) {
+	rows, err := p.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var holds []Hold
+	for rows.Next() {
+		h, err := scanHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, *h)
+	}
+	return holds, rows.Err()
+}
+
+func scanHold(row pgx.Row) (*Hold, error) {
+	var h Hold
+	if err := row.Scan(&h.Serial, &h.State, &h.ReleaseAt, &h.HeldBy, &h.HeldAt, &h.DecidedBy, &h.DecidedAt); err != nil {
+		return nil, err
+	}
+	h.ReleaseAt = h.ReleaseAt.UTC()
+	return &h, nil
+}
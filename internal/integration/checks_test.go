@@ -0,0 +1,550 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/api"
+	"github.com/gigvault/ocsp/internal/precomputed"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	pb "github.com/gigvault/shared/api/proto/ocsp"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Serials the checks write, in canonical form: lowercase hex without leading zeros, as the
+// responder looks them up
+const (
+	serialGood       = "10a1"
+	serialRevoked    = "10a2"
+	serialReinstated = "10a3"
+	serialDryRun     = "10a4"
+	serialBatchGood  = "10b1"
+	serialBatchRev   = "10b2"
+	serialBatchUnk   = "10b3"
+	serialNever      = "10ff"
+)
+
+// propagation bounds how long a change may take to reach the signed responses and the CRL,
+// which the service refreshes every second
+const propagation = 20 * time.Second
+
+// checker holds the clients of one service
+type checker struct {
+	svc  *service
+	grpc pb.OCSPServiceClient
+	http *http.Client
+}
+
+// run checks every gRPC method, then the RFC 6960 responder and the CRL, which depend on the
+// statuses the gRPC checks wrote. Each check is a subtest, and a failed one does not stop the rest
+func run(ctx context.Context, t *testing.T, svc *service) {
+	conn, err := grpc.NewClient(svc.grpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &checker{svc: svc, grpc: pb.NewOCSPServiceClient(conn), http: &http.Client{Timeout: 10 * time.Second}}
+
+	for _, check := range []struct {
+		name string
+		fn   func(context.Context) (string, error)
+	}{
+		{"health", c.health},
+		{"UpdateStatus good", c.updateGood},
+		{"UpdateStatus revoked", c.updateRevoked},
+		{"UpdateStatus revoked then good", c.reinstate},
+		{"UpdateStatus invalid", c.invalidUpdates},
+		{"UpdateStatus dry run", c.dryRun},
+		{"BatchUpdateStatus", c.batch},
+		{"CheckStatus never issued", c.neverIssued},
+		{"responder revoked (POST)", c.responderRevoked},
+		{"responder good (GET)", c.responderGood},
+		{"responder never issued", c.responderNeverIssued},
+		{"responder follows changes", c.responderChange},
+		{"CRL", c.crl},
+		{"drift verifier", c.drift},
+	} {
+		t.Run(check.name, func(t *testing.T) {
+			note, err := check.fn(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Log(note)
+		})
+	}
+}
+
+func (c *checker) health(ctx context.Context) (string, error) {
+	for _, path := range []string{"/health", "/ready"} {
+		status, _, err := c.get(ctx, path)
+		if err != nil {
+			return "", err
+		}
+		if status != http.StatusOK {
+			return "", fmt.Errorf("%s answered HTTP %d", path, status)
+		}
+	}
+	return "/health and /ready answer 200", nil
+}
+
+func (c *checker) updateGood(ctx context.Context) (string, error) {
+	before := time.Now()
+	if err := c.update(ctx, &pb.UpdateStatusRequest{SerialNumber: serialGood, Status: storage.StatusGood}); err != nil {
+		return "", err
+	}
+	resp, err := c.expectStatus(ctx, serialGood, storage.StatusGood)
+	if err != nil {
+		return "", err
+	}
+	thisUpdate, nextUpdate := resp.ThisUpdate.AsTime(), resp.NextUpdate.AsTime()
+	// The database clock may differ a little from the local one
+	if thisUpdate.Before(before.Add(-time.Minute)) || thisUpdate.After(time.Now().Add(time.Minute)) {
+		return "", fmt.Errorf("this_update %s is not the time of the write", thisUpdate)
+	}
+	if d := nextUpdate.Sub(thisUpdate); d != 24*time.Hour {
+		return "", fmt.Errorf("next_update is %s after this_update, want 24h", d)
+	}
+	if resp.RevokedAt != nil {
+		return "", errors.New("good status has a revocation time")
+	}
+	return "written and read back with a 24h validity", nil
+}
+
+func (c *checker) updateRevoked(ctx context.Context) (string, error) {
+	revokedAt := time.Now().Add(-time.Hour).Truncate(time.Second).UTC()
+	err := c.update(ctx, &pb.UpdateStatusRequest{
+		SerialNumber:     serialRevoked,
+		Status:           storage.StatusRevoked,
+		RevokedAt:        timestamppb.New(revokedAt),
+		RevocationReason: revocation.ReasonKeyCompromise,
+	})
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.expectStatus(ctx, serialRevoked, storage.StatusRevoked)
+	if err != nil {
+		return "", err
+	}
+	if resp.RevokedAt == nil || !resp.RevokedAt.AsTime().Equal(revokedAt) {
+		return "", fmt.Errorf("revoked_at read back as %v, want %s", resp.RevokedAt.AsTime(), revokedAt)
+	}
+	if resp.RevocationReason != revocation.ReasonKeyCompromise {
+		return "", fmt.Errorf("reason read back as %q", resp.RevocationReason)
+	}
+	return "revocation time and reason read back", nil
+}
+
+func (c *checker) reinstate(ctx context.Context) (string, error) {
+	revokedAt := timestamppb.New(time.Now().Add(-time.Hour))
+	if err := c.update(ctx, &pb.UpdateStatusRequest{SerialNumber: serialReinstated, Status: storage.StatusRevoked, RevokedAt: revokedAt, RevocationReason: revocation.ReasonCertificateHold}); err != nil {
+		return "", err
+	}
+	if err := c.update(ctx, &pb.UpdateStatusRequest{SerialNumber: serialReinstated, Status: storage.StatusGood}); err != nil {
+		return "", err
+	}
+	resp, err := c.expectStatus(ctx, serialReinstated, storage.StatusGood)
+	if err != nil {
+		return "", err
+	}
+	if resp.RevokedAt != nil {
+		return "", errors.New("revocation time kept after the status became good")
+	}
+	return "second write replaced the first", nil
+}
+
+func (c *checker) invalidUpdates(ctx context.Context) (string, error) {
+	calls := []struct {
+		name string
+		call func() error
+	}{
+		{"unknown status", func() error {
+			_, err := c.grpc.UpdateStatus(ctx, &pb.UpdateStatusRequest{SerialNumber: serialNever, Status: "suspended"})
+			return err
+		}},
+		{"update without serial", func() error {
+			_, err := c.grpc.UpdateStatus(ctx, &pb.UpdateStatusRequest{Status: storage.StatusGood})
+			return err
+		}},
+		{"check without serial", func() error {
+			_, err := c.grpc.CheckStatus(ctx, &pb.CheckStatusRequest{})
+			return err
+		}},
+	}
+	for _, call := range calls {
+		if code := status.Code(call.call()); code != codes.InvalidArgument {
+			return "", fmt.Errorf("%s: got %s, want InvalidArgument", call.name, code)
+		}
+	}
+	if _, err := c.expectStatus(ctx, serialNever, storage.StatusUnknown); err != nil {
+		return "", fmt.Errorf("refused write was stored: %w", err)
+	}
+	return "refused with InvalidArgument and nothing written", nil
+}
+
+func (c *checker) dryRun(ctx context.Context) (string, error) {
+	dry := metadata.AppendToOutgoingContext(ctx, api.DryRunMetadataKey, "true")
+	resp, err := c.grpc.UpdateStatus(dry, &pb.UpdateStatusRequest{SerialNumber: serialDryRun, Status: storage.StatusRevoked})
+	if err != nil {
+		return "", err
+	}
+	if !resp.Success {
+		return "", fmt.Errorf("dry run failed: %s", resp.Message)
+	}
+	if _, err := c.expectStatus(ctx, serialDryRun, storage.StatusUnknown); err != nil {
+		return "", fmt.Errorf("dry run wrote the status: %w", err)
+	}
+	return resp.Message, nil
+}
+
+func (c *checker) batch(ctx context.Context) (string, error) {
+	resp, err := c.grpc.BatchUpdateStatus(ctx, &pb.BatchUpdateStatusRequest{Updates: []*pb.UpdateStatusRequest{
+		{SerialNumber: serialBatchGood, Status: storage.StatusGood},
+		{SerialNumber: serialBatchRev, Status: storage.StatusRevoked, RevokedAt: timestamppb.Now(), RevocationReason: revocation.ReasonSuperseded},
+		{SerialNumber: serialBatchUnk, Status: storage.StatusUnknown},
+		{SerialNumber: serialNever, Status: "suspended"},
+	}})
+	if err != nil {
+		return "", err
+	}
+	if resp.SuccessCount != 3 || resp.FailureCount != 1 || len(resp.Errors) != 1 {
+		return "", fmt.Errorf("got %d succeeded, %d failed, %d errors; want 3, 1, 1", resp.SuccessCount, resp.FailureCount, len(resp.Errors))
+	}
+	for serial, want := range map[string]string{
+		serialBatchGood: storage.StatusGood,
+		serialBatchRev:  storage.StatusRevoked,
+		serialBatchUnk:  storage.StatusUnknown,
+	} {
+		if _, err := c.expectStatus(ctx, serial, want); err != nil {
+			return "", err
+		}
+	}
+	return "3 written, the invalid update reported", nil
+}
+
+func (c *checker) neverIssued(ctx context.Context) (string, error) {
+	resp, err := c.expectStatus(ctx, serialNever, storage.StatusUnknown)
+	if err != nil {
+		return "", err
+	}
+	if resp.ThisUpdate == nil || resp.NextUpdate == nil {
+		return "", errors.New("unknown status has no validity")
+	}
+	return "answered unknown", nil
+}
+
+func (c *checker) responderRevoked(ctx context.Context) (string, error) {
+	resp, err := c.waitForResponse(ctx, serialRevoked, false, ocsp.Revoked)
+	if err != nil {
+		return "", err
+	}
+	if resp.RevocationReason != ocsp.KeyCompromise {
+		return "", fmt.Errorf("reason code %d, want keyCompromise (%d)", resp.RevocationReason, ocsp.KeyCompromise)
+	}
+	return "signed revoked response with reason keyCompromise", nil
+}
+
+func (c *checker) responderGood(ctx context.Context) (string, error) {
+	if _, err := c.waitForResponse(ctx, serialGood, true, ocsp.Good); err != nil {
+		return "", err
+	}
+	return "signed good response", nil
+}
+
+func (c *checker) responderNeverIssued(ctx context.Context) (string, error) {
+	_, err := c.lookup(ctx, serialNever, false)
+	var responseErr ocsp.ResponseError
+	if !errors.As(err, &responseErr) || responseErr.Status != ocsp.Unauthorized {
+		return "", fmt.Errorf("got %v, want an unauthorized response", err)
+	}
+	return "answered unauthorized", nil
+}
+
+func (c *checker) responderChange(ctx context.Context) (string, error) {
+	err := c.update(ctx, &pb.UpdateStatusRequest{
+		SerialNumber:     serialGood,
+		Status:           storage.StatusRevoked,
+		RevokedAt:        timestamppb.Now(),
+		RevocationReason: revocation.ReasonCessationOfOperation,
+	})
+	if err != nil {
+		return "", err
+	}
+	if _, err := c.waitForResponse(ctx, serialGood, false, ocsp.Revoked); err != nil {
+		return "", err
+	}
+	return "revocation re-signed within " + propagation.String(), nil
+}
+
+func (c *checker) crl(ctx context.Context) (string, error) {
+	want := map[string]bool{serialRevoked: true, serialBatchRev: true, serialGood: true}
+	notWant := []string{serialReinstated, serialBatchGood}
+	var last error
+	deadline := time.Now().Add(propagation)
+	for {
+		list, err := c.fetchCRL(ctx)
+		if err == nil {
+			listed := make(map[string]bool)
+			for _, entry := range list.RevokedCertificateEntries {
+				listed[entry.SerialNumber.Text(16)] = true
+			}
+			err = nil
+			for serial := range want {
+				if !listed[serial] {
+					err = fmt.Errorf("revoked serial %s is not listed", serial)
+				}
+			}
+			for _, serial := range notWant {
+				if listed[serial] {
+					err = fmt.Errorf("good serial %s is listed", serial)
+				}
+			}
+			if err == nil {
+				return fmt.Sprintf("CRL number %s lists the %d revoked serials", list.Number, len(want)), nil
+			}
+		}
+		last = err
+		if time.Now().After(deadline) {
+			return "", last
+		}
+		if err := sleep(ctx, time.Second); err != nil {
+			return "", err
+		}
+	}
+}
+
+// drift runs the drift verifier's sampling queries over the responses the service signed, then
+// swaps one stored response for another serial's behind the refresher and expects it reported
+func (c *checker) drift(ctx context.Context) (string, error) {
+	pool, err := pgxpool.New(ctx, c.svc.db.url)
+	if err != nil {
+		return "", err
+	}
+	defer pool.Close()
+	table, err := precomputed.NewTable(pool, c.svc.issuer)
+	if err != nil {
+		return "", err
+	}
+	var serials []string
+	rows, err := pool.Query(ctx, `SELECT serial FROM signed_responses ORDER BY serial`)
+	if err != nil {
+		return "", err
+	}
+	for rows.Next() {
+		var serial string
+		if err := rows.Scan(&serial); err != nil {
+			rows.Close()
+			return "", err
+		}
+		serials = append(serials, serial)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if !slices.Contains(serials, serialBatchGood) || !slices.Contains(serials, serialRevoked) {
+		return "", fmt.Errorf("signed responses for %v, want %s and %s among them", serials, serialBatchGood, serialRevoked)
+	}
+
+	// A sample from the middle wraps around to the lowest serials
+	start := slices.Index(serials, serialBatchGood)
+	sample, err := table.Sample(ctx, serialBatchGood, len(serials))
+	if err != nil {
+		return "", fmt.Errorf("Sample: %w", err)
+	}
+	var sampled []string
+	for _, s := range sample {
+		sampled = append(sampled, s.Status.Serial)
+	}
+	if want := append(slices.Clone(serials[start:]), serials[:start]...); !slices.Equal(sampled, want) {
+		return "", fmt.Errorf("Sample from %s returned %v, want %v", serialBatchGood, sampled, want)
+	}
+
+	revoked, err := table.RecentlyRevoked(ctx, time.Now().Add(-time.Hour), len(serials))
+	if err != nil {
+		return "", fmt.Errorf("RecentlyRevoked: %w", err)
+	}
+	var revokedSerials []string
+	for i, s := range revoked {
+		if s.Status.Status != storage.StatusRevoked || i > 0 && s.Status.ThisUpdate.After(revoked[i-1].Status.ThisUpdate) {
+			return "", fmt.Errorf("RecentlyRevoked returned %s %s out of order", s.Status.Serial, s.Status.Status)
+		}
+		revokedSerials = append(revokedSerials, s.Status.Serial)
+	}
+	for _, serial := range []string{serialRevoked, serialBatchRev, serialGood} {
+		if !slices.Contains(revokedSerials, serial) {
+			return "", fmt.Errorf("RecentlyRevoked returned %v, missing %s", revokedSerials, serial)
+		}
+	}
+
+	refresher := precomputed.NewRefresher(table, &precomputed.Signer{Issuer: c.svc.issuer}, 100, &logger.Logger{Logger: zap.NewNop()})
+	verifier := precomputed.NewDriftVerifier(refresher, precomputed.DriftOptions{
+		SampleSize:        len(serials),
+		RevokedSampleSize: len(serials),
+		Lookback:          time.Hour,
+		Grace:             propagation,
+	}, &logger.Logger{Logger: zap.NewNop()})
+	if drift, err := verifier.Verify(ctx); err != nil || len(drift) > 0 {
+		return "", fmt.Errorf("verifying the service's responses found %v, %v", drift, err)
+	}
+	_, err = pool.Exec(ctx, `
+		UPDATE signed_responses SET der = (SELECT der FROM signed_responses WHERE serial = $1)
+		WHERE serial = $2
+	`, serialRevoked, serialBatchGood)
+	if err != nil {
+		return "", err
+	}
+	drift, err := verifier.Verify(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(drift) != 1 || drift[0].Serial != serialBatchGood || drift[0].Kind != precomputed.DriftSerial {
+		return "", fmt.Errorf("after swapping the response of %s, found %+v", serialBatchGood, drift)
+	}
+	return fmt.Sprintf("sampled %d responses, %d recently revoked; a swapped response was reported", len(sample), len(revoked)), nil
+}
+
+func (c *checker) fetchCRL(ctx context.Context) (*x509.RevocationList, error) {
+	status, body, err := c.get(ctx, crlPath)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%s answered HTTP %d", crlPath, status)
+	}
+	list, err := x509.ParseRevocationList(body)
+	if err != nil {
+		return nil, err
+	}
+	if err := list.CheckSignatureFrom(c.svc.issuer); err != nil {
+		return nil, fmt.Errorf("CRL signature: %w", err)
+	}
+	return list, nil
+}
+
+func (c *checker) update(ctx context.Context, req *pb.UpdateStatusRequest) error {
+	resp, err := c.grpc.UpdateStatus(ctx, req)
+	if err != nil {
+		return fmt.Errorf("UpdateStatus %s: %w", req.SerialNumber, err)
+	}
+	if !resp.Success {
+		return fmt.Errorf("UpdateStatus %s: %s", req.SerialNumber, resp.Message)
+	}
+	return nil
+}
+
+func (c *checker) expectStatus(ctx context.Context, serial, want string) (*pb.CheckStatusResponse, error) {
+	resp, err := c.grpc.CheckStatus(ctx, &pb.CheckStatusRequest{SerialNumber: serial})
+	if err != nil {
+		return nil, fmt.Errorf("CheckStatus %s: %w", serial, err)
+	}
+	if resp.Status != want {
+		return nil, fmt.Errorf("CheckStatus %s: got %s, want %s", serial, resp.Status, want)
+	}
+	return resp, nil
+}
+
+// waitForResponse polls the responder until it answers status for serial, as signed responses
+// are refreshed in the background
+func (c *checker) waitForResponse(ctx context.Context, serial string, get bool, want int) (*ocsp.Response, error) {
+	deadline := time.Now().Add(propagation)
+	for {
+		resp, err := c.lookup(ctx, serial, get)
+		if err == nil && resp.Status == want {
+			return resp, nil
+		}
+		if time.Now().After(deadline) {
+			if err == nil {
+				err = fmt.Errorf("status %d after %s, want %d", resp.Status, propagation, want)
+			}
+			return nil, err
+		}
+		if err := sleep(ctx, time.Second); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// lookup sends an RFC 6960 request for serial, by POST or base64 GET, and verifies the
+// response is signed by the issuer
+func (c *checker) lookup(ctx context.Context, serial string, get bool) (*ocsp.Response, error) {
+	n, _ := new(big.Int).SetString(serial, 16)
+	der, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: n}, c.svc.issuer, &ocsp.RequestOptions{Hash: crypto.SHA1})
+	if err != nil {
+		return nil, err
+	}
+	endpoint := c.svc.httpURL + responderPath
+	var req *http.Request
+	if get {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/"+url.PathEscape(base64.StdEncoding.EncodeToString(der)), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(der))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/ocsp-request")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("responder answered HTTP %d", resp.StatusCode)
+	}
+	return ocsp.ParseResponseForCert(body, &x509.Certificate{SerialNumber: n}, c.svc.issuer)
+}
+
+func (c *checker) get(ctx context.Context, path string) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.svc.httpURL+path, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	return resp.StatusCode, body, err
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,6 @@
+// Package integration runs the service end to end against a throwaway Postgres database. Its
+// tests are behind the integration build tag and start Postgres with testcontainers-go unless
+// -db names a server:
+//
+//	go test -tags integration ./internal/integration -args -db postgres://...
+package integration
@@ -0,0 +1,137 @@
+//go:build integration
+
+package integration
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+)
+
+// container is a Postgres server started with testcontainers-go
+type container struct {
+	id  string
+	url string
+}
+
+// startPostgres runs image and waits until it accepts connections. The container is removed
+// when the test ends, unless -keep is set
+func startPostgres(ctx context.Context, t *testing.T, image string) (c *container, err error) {
+	// testcontainers panics when it finds no Docker host
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v; give -db to use an existing server", r)
+		}
+	}()
+	ctr, err := postgres.Run(ctx, image,
+		postgres.WithUsername("ocsp"),
+		postgres.WithPassword("ocsp"),
+		postgres.WithDatabase("postgres"),
+		postgres.BasicWaitStrategies(),
+	)
+	if !*keep {
+		testcontainers.CleanupContainer(t, ctr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	server, err := ctr.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		return nil, err
+	}
+	id := ctr.GetContainerID()
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return &container{id: id, url: server}, nil
+}
+
+// database is the throwaway database the service runs against
+type database struct {
+	name   string
+	url    string
+	server string
+	config *pgx.ConnConfig
+}
+
+// createDatabase creates a uniquely named database on the server at serverURL
+func createDatabase(ctx context.Context, serverURL string) (*database, error) {
+	conn, err := pgx.Connect(ctx, serverURL)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close(ctx)
+
+	name := fmt.Sprintf("ocsp_integration_%d", time.Now().UnixNano())
+	if _, err := conn.Exec(ctx, "CREATE DATABASE "+pgx.Identifier{name}.Sanitize()); err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/" + name
+	db := &database{name: name, url: u.String(), server: serverURL}
+	if db.config, err = pgx.ParseConfig(db.url); err != nil {
+		db.drop()
+		return nil, err
+	}
+	return db, nil
+}
+
+// drop removes the database, closing the connections left to it
+func (d *database) drop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conn, err := pgx.Connect(ctx, d.server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+	_, err = conn.Exec(ctx, "DROP DATABASE IF EXISTS "+pgx.Identifier{d.name}.Sanitize()+" WITH (FORCE)")
+	return err
+}
+
+// migrate applies every .sql file in dir in name order, each in its own transaction, and
+// returns how many were applied
+func migrate(ctx context.Context, dbURL, dir string) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return 0, err
+	}
+	if len(paths) == 0 {
+		return 0, fmt.Errorf("no migrations in %s", dir)
+	}
+	sort.Strings(paths)
+
+	conn, err := pgx.Connect(ctx, dbURL)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close(ctx)
+	for _, path := range paths {
+		sql, err := os.ReadFile(path)
+		if err != nil {
+			return 0, err
+		}
+		err = pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			// Without arguments the file is sent as one simple query, so it may hold several statements
+			_, err := tx.Exec(ctx, string(sql))
+			return err
+		})
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+	}
+	return len(paths), nil
+}
@@ -0,0 +1,241 @@
+//go:build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+// Paths of the responders the service is configured with
+const (
+	responderPath = "/ocsp"
+	crlPath       = "/crl"
+)
+
+// service is a running ocsp process
+type service struct {
+	cmd      *exec.Cmd
+	exited   chan error
+	logPath  string
+	httpURL  string
+	grpcAddr string
+	issuer   *x509.Certificate
+	db       *database
+}
+
+// startService writes a CA and a configuration for db to work, starts binary with it and
+// waits until it answers health checks
+func startService(ctx context.Context, binary, work string, db *database, showLogs bool) (*service, error) {
+	issuer, err := writeCA(work)
+	if err != nil {
+		return nil, err
+	}
+	httpPort, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	grpcPort, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := config.Default()
+	cfg.Service.Name = "ocsp"
+	cfg.Service.Environment = "integration"
+	cfg.Service.Version = "integration"
+	cfg.Server.Host = "127.0.0.1"
+	cfg.Server.HTTPPort = httpPort
+	cfg.Server.GRPCPort = grpcPort
+	cfg.Logging.Level = "info"
+	cfg.Logging.Format = "json"
+	cfg.Database.Host = db.config.Host
+	cfg.Database.Port = int(db.config.Port)
+	cfg.Database.Database = db.name
+	cfg.Database.User = db.config.User
+	cfg.Database.Password = db.config.Password
+	cfg.Database.SSLMode = "disable"
+	if db.config.TLSConfig != nil {
+		cfg.Database.SSLMode = "require"
+	}
+	// Short intervals, so changes reach the signed responses and the CRL within seconds
+	cfg.CRL.Enabled = true
+	cfg.CRL.IssuerCertPath = filepath.Join(work, "ca.crt")
+	cfg.CRL.IssuerKeyPath = filepath.Join(work, "ca.key")
+	cfg.CRL.Path = crlPath
+	cfg.CRL.Interval = time.Second
+	cfg.Precomputed.Enabled = true
+	cfg.Precomputed.Path = responderPath
+	cfg.Precomputed.IssuerCertPath = cfg.CRL.IssuerCertPath
+	cfg.Precomputed.SigningKeyPath = cfg.CRL.IssuerKeyPath
+	cfg.Precomputed.Interval = time.Second
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid generated configuration: %w", err)
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	configPath := filepath.Join(work, "config.yaml")
+	if err := os.WriteFile(configPath, data, 0o600); err != nil {
+		return nil, err
+	}
+
+	logPath := filepath.Join(work, "ocsp.log")
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return nil, err
+	}
+	var output io.Writer = logFile
+	if showLogs {
+		output = io.MultiWriter(logFile, os.Stderr)
+	}
+	cmd := exec.Command(binary, "-config", configPath)
+	cmd.Stdout, cmd.Stderr = output, output
+	// OCSP_* overrides in the caller's environment would change what is tested
+	cmd.Env = filterEnv(os.Environ(), "OCSP_")
+	if err := cmd.Start(); err != nil {
+		logFile.Close()
+		return nil, err
+	}
+	s := &service{
+		cmd:      cmd,
+		exited:   make(chan error, 1),
+		logPath:  logPath,
+		httpURL:  "http://127.0.0.1:" + strconv.Itoa(httpPort),
+		grpcAddr: "127.0.0.1:" + strconv.Itoa(grpcPort),
+		issuer:   issuer,
+		db:       db,
+	}
+	go func() {
+		s.exited <- cmd.Wait()
+		logFile.Close()
+	}()
+
+	if err := s.waitHealthy(ctx, time.Minute); err != nil {
+		s.stop()
+		return nil, fmt.Errorf("%w\n%s", err, s.tail(20))
+	}
+	return s, nil
+}
+
+func (s *service) waitHealthy(ctx context.Context, limit time.Duration) error {
+	deadline := time.Now().Add(limit)
+	for {
+		select {
+		case err := <-s.exited:
+			s.exited <- err
+			return fmt.Errorf("ocsp exited during startup: %v", err)
+		default:
+		}
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, s.httpURL+"/health", nil)
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) || ctx.Err() != nil {
+			return fmt.Errorf("ocsp did not become healthy within %s", limit)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// stop sends SIGTERM and waits for a graceful shutdown, killing the process after 30s
+func (s *service) stop() {
+	s.cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-s.exited:
+	case <-time.After(30 * time.Second):
+		s.cmd.Process.Kill()
+		<-s.exited
+	}
+}
+
+// tail returns the last n lines of the service log
+func (s *service) tail(n int) string {
+	data, err := os.ReadFile(s.logPath)
+	if err != nil {
+		return ""
+	}
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return string(bytes.Join(lines, []byte("\n")))
+}
+
+// writeCA writes a new CA certificate and key to ca.crt and ca.key in dir. The service signs
+// both CRLs and OCSP responses with it
+func writeCA(dir string) (*x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ocspinteg issuer"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ca.crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ca.key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// freePort returns a TCP port nothing listens on at the moment
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func filterEnv(env []string, prefix string) []string {
+	var kept []string
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, prefix) {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
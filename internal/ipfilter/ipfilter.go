@@ -0,0 +1,174 @@
+// Package ipfilter admits or rejects clients by source address before their requests are read
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Surfaces filters are configured for
+const (
+	SurfaceAdmin     = "admin"
+	SurfaceResponder = "responder"
+)
+
+var rejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "ip_filter_rejected_total",
+	Help:      "Connections and requests rejected by source address, by surface and rule.",
+}, []string{"surface", "rule"})
+
+func init() {
+	metrics.Registry.MustRegister(rejected)
+}
+
+// Filter holds the allow and deny rules of one surface. A source matching a deny rule is
+// rejected; otherwise, if there are allow rules, it must match one of them
+type Filter struct {
+	surface string
+	allow   []netip.Prefix
+	deny    []netip.Prefix
+}
+
+// New parses CIDR rules for a surface; bare addresses match only themselves. It returns nil
+// when there are no rules, and a nil Filter admits everyone
+func New(surface string, allow, deny []string) (*Filter, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	f := &Filter{surface: surface}
+	var err error
+	if f.allow, err = ParsePrefixes(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = ParsePrefixes(deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ParsePrefixes parses CIDR prefixes or bare addresses
+func ParsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", value)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", value)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Allowed reports whether addr may use the surface, counting rejections
+func (f *Filter) Allowed(addr netip.Addr) bool {
+	if f == nil {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, prefix := range f.deny {
+		if prefix.Contains(addr) {
+			rejected.WithLabelValues(f.surface, "deny").Inc()
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, prefix := range f.allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	rejected.WithLabelValues(f.surface, "not_allowed").Inc()
+	return false
+}
+
+// AllowedAddr is Allowed for a "host:port" source; unparseable sources are rejected whenever
+// there are rules
+func (f *Filter) AllowedAddr(remote string) bool {
+	if f == nil {
+		return true
+	}
+	addrPort, err := netip.ParseAddrPort(remote)
+	if err != nil {
+		rejected.WithLabelValues(f.surface, "unparseable").Inc()
+		return false
+	}
+	return f.Allowed(addrPort.Addr())
+}
+
+// Listener wraps l so connections from rejected sources are closed as soon as they are
+// accepted, before any bytes are read. A nil filter returns l unchanged
+func Listener(l net.Listener, f *Filter) net.Listener {
+	if f == nil {
+		return l
+	}
+	return &listener{Listener: l, filter: f}
+}
+
+type listener struct {
+	net.Listener
+	filter *Filter
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.filter.AllowedAddr(conn.RemoteAddr().String()) {
+			return conn, nil
+		}
+		conn.Close()
+	}
+}
+
+// Route applies a filter to request paths starting with Prefix
+type Route struct {
+	Prefix string
+	Filter *Filter
+}
+
+// Middleware answers 403 to requests whose path falls under a route whose filter rejects the
+// source, before the request body is read. The first matching route applies
+func Middleware(routes []Route, next http.Handler) http.Handler {
+	var active []Route
+	for _, route := range routes {
+		if route.Filter != nil {
+			active = append(active, route)
+		}
+	}
+	if len(active) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, route := range active {
+			if !strings.HasPrefix(r.URL.Path, route.Prefix) {
+				continue
+			}
+			if !route.Filter.AllowedAddr(r.RemoteAddr) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			break
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,152 @@
+package issuers
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// maxIssuerSize bounds the size of a caIssuers response
+const maxIssuerSize = 1 << 20
+
+// oidSignedData is the PKCS #7 content type of certs-only bundles (.p7c)
+var oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+// fetchParent fetches the certificate that signed cert from its caIssuers URLs, in order, and
+// returns it with the URL it came from
+func (r *Registry) fetchParent(ctx context.Context, cert *x509.Certificate) (*x509.Certificate, string, error) {
+	var errs []error
+	for _, raw := range cert.IssuingCertificateURL {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		candidates, err := r.fetch(ctx, raw)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, candidate := range candidates {
+			if bytes.Equal(candidate.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(candidate) == nil {
+				return candidate, raw, nil
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s holds no certificate that signed %s", raw, cert.Subject))
+	}
+	if len(errs) == 0 {
+		return nil, "", fmt.Errorf("%w: %s", ErrNoAIA, cert.Subject)
+	}
+	return nil, "", errors.Join(errs...)
+}
+
+// fetch downloads the certificates at a caIssuers URL: DER, as RFC 5280 asks, a PKCS #7
+// certs-only bundle or PEM
+func (r *Registry) fetch(ctx context.Context, raw string) ([]*x509.Certificate, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, raw, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", raw, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: status %d", raw, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxIssuerSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", raw, err)
+	}
+	if len(data) > maxIssuerSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", raw, maxIssuerSize)
+	}
+	certs, err := ParseCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", raw, err)
+	}
+	return certs, nil
+}
+
+// LoadRoots reads the trust roots in a PEM, DER or PKCS #7 file
+func LoadRoots(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trust roots: %w", err)
+	}
+	certs, err := ParseCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse trust roots in %s: %w", path, err)
+	}
+	roots := x509.NewCertPool()
+	for _, cert := range certs {
+		roots.AddCert(cert)
+	}
+	return roots, nil
+}
+
+// ParseCertificates parses DER, PEM or PKCS #7 certs-only encoded certificates
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if strings.HasPrefix(strings.TrimSpace(string(data[:min(len(data), 64)])), "-----BEGIN") {
+		var certs []*x509.Certificate
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type == "PKCS7" {
+				return parsePKCS7(block.Bytes)
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+		}
+		if len(certs) == 0 {
+			return nil, errors.New("no CERTIFICATE block")
+		}
+		return certs, nil
+	}
+	if certs, err := x509.ParseCertificates(data); err == nil && len(certs) > 0 {
+		return certs, nil
+	}
+	return parsePKCS7(data)
+}
+
+// parsePKCS7 returns the certificates of a PKCS #7 SignedData (RFC 2315), ignoring the rest
+func parsePKCS7(der []byte) ([]*x509.Certificate, error) {
+	var info struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(der, &info); err != nil || !info.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("neither a certificate nor a PKCS #7 bundle")
+	}
+	var signed struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      asn1.RawValue
+		Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signed); err != nil {
+		return nil, fmt.Errorf("invalid PKCS #7 bundle: %w", err)
+	}
+	if len(signed.Certificates.Bytes) == 0 {
+		return nil, errors.New("PKCS #7 bundle holds no certificates")
+	}
+	return x509.ParseCertificates(signed.Certificates.Bytes)
+}
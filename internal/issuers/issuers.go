@@ -0,0 +1,211 @@
+// Package issuers registers the issuers of leaf certificates without manual configuration:
+// the issuer certificate is fetched from the leaf's AIA caIssuers URL, its chain is verified up
+// to a configured trust root and its CertID hashes and subject are recorded
+package issuers
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrNotFound is returned for issuers that were never registered
+	ErrNotFound = errors.New("issuer not registered")
+	// ErrNoAIA is returned for leaf certificates without an HTTP caIssuers URL
+	ErrNoAIA = errors.New("certificate has no HTTP caIssuers URL")
+	// ErrUntrusted is wrapped by the errors for issuers whose chain does not verify up to a
+	// trust root
+	ErrUntrusted = errors.New("issuer does not chain to a trust root")
+)
+
+var discoveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "issuer_discovery_total",
+	Help:      "Issuer lookups for leaf certificates, by result: known, registered or failed.",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(discoveries)
+}
+
+// Issuer is a registered issuer certificate. Hashes are hex, computed as OCSP CertIDs do
+type Issuer struct {
+	KeyHash        string    `json:"key_hash"`
+	NameHash       string    `json:"name_hash"`
+	KeyHashSHA256  string    `json:"key_hash_sha256"`
+	NameHashSHA256 string    `json:"name_hash_sha256"`
+	SubjectKeyID   string    `json:"subject_key_id,omitempty"`
+	Subject        string    `json:"subject"`
+	Serial         string    `json:"serial"`
+	NotBefore      time.Time `json:"not_before"`
+	NotAfter       time.Time `json:"not_after"`
+	SourceURL      string    `json:"source_url"`
+	DiscoveredAt   time.Time `json:"discovered_at"`
+	// Certificate is the DER issuer certificate
+	Certificate []byte `json:"-"`
+}
+
+// Store keeps registered issuers
+type Store interface {
+	// Put records an issuer, keeping the first registration of its key
+	Put(ctx context.Context, issuer *Issuer) error
+	// Get returns the issuer with a hex SHA-1 key hash, or ErrNotFound
+	Get(ctx context.Context, keyHash string) (*Issuer, error)
+	// Find returns an issuer with the name hash, and the subject key ID when it is not empty,
+	// or ErrNotFound
+	Find(ctx context.Context, nameHash, subjectKeyID string) (*Issuer, error)
+	// List returns every registered issuer
+	List(ctx context.Context) ([]Issuer, error)
+}
+
+// Registry registers the issuers of leaf certificates. Issuers already registered are
+// remembered, so each is fetched and verified once
+type Registry struct {
+	store    Store
+	roots    *x509.CertPool
+	client   *http.Client
+	maxDepth int
+	logger   *logger.Logger
+
+	mu    sync.Mutex
+	known map[string]*Issuer
+}
+
+// NewRegistry creates a registry trusting roots. Issuer certificates are fetched within
+// timeout, following AIA through at most maxDepth certificates to reach a root
+func NewRegistry(store Store, roots *x509.CertPool, timeout time.Duration, maxDepth int, logger *logger.Logger) *Registry {
+	return &Registry{
+		store:    store,
+		roots:    roots,
+		client:   &http.Client{Timeout: timeout},
+		maxDepth: maxDepth,
+		logger:   logger,
+		known:    make(map[string]*Issuer),
+	}
+}
+
+// Register returns the issuer of leaf, registering it first when it is new
+func (r *Registry) Register(ctx context.Context, leaf *x509.Certificate) (*Issuer, error) {
+	nameHash, keyID := hexSHA1(leaf.RawIssuer), hex.EncodeToString(leaf.AuthorityKeyId)
+	cacheKey := nameHash + ":" + keyID
+	r.mu.Lock()
+	known := r.known[cacheKey]
+	r.mu.Unlock()
+	if known != nil {
+		discoveries.WithLabelValues("known").Inc()
+		return known, nil
+	}
+
+	issuer, err := r.store.Find(ctx, nameHash, keyID)
+	switch {
+	case err == nil:
+		discoveries.WithLabelValues("known").Inc()
+	case errors.Is(err, ErrNotFound):
+		if issuer, err = r.discover(ctx, leaf); err != nil {
+			discoveries.WithLabelValues("failed").Inc()
+			return nil, err
+		}
+		if err := r.store.Put(ctx, issuer); err != nil {
+			return nil, fmt.Errorf("failed to record issuer: %w", err)
+		}
+		discoveries.WithLabelValues("registered").Inc()
+		r.logger.Info("Registered issuer",
+			zap.String("subject", issuer.Subject),
+			zap.String("key_hash", issuer.KeyHash),
+			zap.String("source", issuer.SourceURL))
+	default:
+		return nil, fmt.Errorf("failed to look up issuer: %w", err)
+	}
+
+	r.mu.Lock()
+	r.known[cacheKey] = issuer
+	r.mu.Unlock()
+	return issuer, nil
+}
+
+// Get returns the registered issuer with a hex SHA-1 key hash
+func (r *Registry) Get(ctx context.Context, keyHash string) (*Issuer, error) {
+	return r.store.Get(ctx, keyHash)
+}
+
+// List returns every registered issuer
+func (r *Registry) List(ctx context.Context) ([]Issuer, error) {
+	return r.store.List(ctx)
+}
+
+// discover fetches the issuer of leaf and verifies its chain up to a trust root, fetching the
+// intermediates above it through their own AIA URLs
+func (r *Registry) discover(ctx context.Context, leaf *x509.Certificate) (*Issuer, error) {
+	issuer, source, err := r.fetchParent(ctx, leaf)
+	if err != nil {
+		return nil, err
+	}
+	intermediates := x509.NewCertPool()
+	opts := x509.VerifyOptions{Roots: r.roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+	for cert, depth := issuer, 1; ; depth++ {
+		_, err := issuer.Verify(opts)
+		if err == nil {
+			return newIssuer(issuer, source)
+		}
+		var unknown x509.UnknownAuthorityError
+		if !errors.As(err, &unknown) || depth >= r.maxDepth || isSelfSigned(cert) {
+			return nil, fmt.Errorf("%w: %s: %v", ErrUntrusted, issuer.Subject, err)
+		}
+		parent, _, err := r.fetchParent(ctx, cert)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrUntrusted, issuer.Subject, err)
+		}
+		intermediates.AddCert(parent)
+		cert = parent
+	}
+}
+
+func newIssuer(cert *x509.Certificate, source string) (*Issuer, error) {
+	keyHash, err := ocspreq.IssuerKeyHash(cert, crypto.SHA1)
+	if err != nil {
+		return nil, err
+	}
+	keyHash256, err := ocspreq.IssuerKeyHash(cert, crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	nameHash256 := sha256.Sum256(cert.RawSubject)
+	return &Issuer{
+		KeyHash:        hex.EncodeToString(keyHash),
+		NameHash:       hexSHA1(cert.RawSubject),
+		KeyHashSHA256:  hex.EncodeToString(keyHash256),
+		NameHashSHA256: hex.EncodeToString(nameHash256[:]),
+		SubjectKeyID:   hex.EncodeToString(cert.SubjectKeyId),
+		Subject:        cert.Subject.String(),
+		Serial:         fmt.Sprintf("%x", cert.SerialNumber),
+		NotBefore:      cert.NotBefore.UTC(),
+		NotAfter:       cert.NotAfter.UTC(),
+		SourceURL:      source,
+		DiscoveredAt:   time.Now().UTC(),
+		Certificate:    cert.Raw,
+	}, nil
+}
+
+func hexSHA1(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return string(cert.RawIssuer) == string(cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
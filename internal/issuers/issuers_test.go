@@ -0,0 +1,240 @@
+package issuers
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+)
+
+type memStore struct {
+	mu      sync.Mutex
+	issuers map[string]Issuer
+}
+
+func (m *memStore) Put(ctx context.Context, i *Issuer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.issuers[i.KeyHash]; !ok {
+		m.issuers[i.KeyHash] = *i
+	}
+	return nil
+}
+
+func (m *memStore) Get(ctx context.Context, keyHash string) (*Issuer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if i, ok := m.issuers[keyHash]; ok {
+		return &i, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memStore) Find(ctx context.Context, nameHash, subjectKeyID string) (*Issuer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, i := range m.issuers {
+		if i.NameHash == nameHash && (subjectKeyID == "" || i.SubjectKeyID == subjectKeyID) {
+			return &i, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memStore) List(ctx context.Context) ([]Issuer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var list []Issuer
+	for _, i := range m.issuers {
+		list = append(list, i)
+	}
+	return list, nil
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+var nextSerial atomic.Int64
+
+// issue creates a certificate for name signed by parent, or self-signed when parent is nil,
+// whose caIssuers URL is aia
+func issue(t *testing.T, name string, parent *testCA, isCA bool, aia string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(nextSerial.Add(1)),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+	}
+	if isCA {
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+	if aia != "" {
+		template.IssuingCertificateURL = []string{aia}
+	}
+	signerCert, signerKey := template, crypto.Signer(key)
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, key.Public(), signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// pkcs7 wraps certificates in a certs-only SignedData
+func pkcs7(t *testing.T, certs ...*x509.Certificate) []byte {
+	t.Helper()
+	var raw []byte
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw...)
+	}
+	signed, err := asn1.Marshal(struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+		Certificates     asn1.RawValue
+		SignerInfos      asn1.RawValue
+	}{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true},
+		ContentInfo:      struct{ ContentType asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      asn1.RawValue{Tag: asn1.TagSet, IsCompound: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{oidSignedData, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestRegisterFollowsAIA(t *testing.T) {
+	var fetches atomic.Int32
+	files := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		data, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+
+	root := issue(t, "Root", nil, true, "")
+	intermediate := issue(t, "Intermediate", root, true, server.URL+"/root.cer")
+	issuing := issue(t, "Issuing", intermediate, true, server.URL+"/intermediate.p7c")
+	leaf := issue(t, "leaf.example.com", issuing, false, server.URL+"/issuing.pem")
+	files["/root.cer"] = root.cert.Raw
+	files["/intermediate.p7c"] = pkcs7(t, root.cert, intermediate.cert)
+	files["/issuing.pem"] = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuing.cert.Raw})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	store := &memStore{issuers: map[string]Issuer{}}
+	registry := NewRegistry(store, roots, 5*time.Second, 4, &logger.Logger{Logger: zap.NewNop()})
+
+	issuer, err := registry.Register(context.Background(), leaf.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyHash, _ := ocspreq.IssuerKeyHash(issuing.cert, crypto.SHA1)
+	if issuer.KeyHash != hex.EncodeToString(keyHash) || issuer.Subject != "CN=Issuing" || issuer.SourceURL != server.URL+"/issuing.pem" {
+		t.Errorf("Register() = %+v", issuer)
+	}
+	if stored, err := store.Get(context.Background(), issuer.KeyHash); err != nil || stored.NameHash != hexSHA1(issuing.cert.RawSubject) {
+		t.Errorf("stored issuer = %+v, %v", stored, err)
+	}
+
+	// Another leaf of the same issuer is answered from memory
+	fetched := fetches.Load()
+	sibling := issue(t, "other.example.com", issuing, false, server.URL+"/issuing.pem")
+	if again, err := registry.Register(context.Background(), sibling.cert); err != nil || again.KeyHash != issuer.KeyHash || fetches.Load() != fetched {
+		t.Errorf("second Register() = %+v, %v after %d more fetches", again, err, fetches.Load()-fetched)
+	}
+}
+
+func TestRegisterRefuses(t *testing.T) {
+	files := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(files[r.URL.Path])
+	}))
+	t.Cleanup(server.Close)
+
+	root := issue(t, "Root", nil, true, "")
+	issuing := issue(t, "Issuing", root, true, server.URL+"/root.cer")
+	impostor := issue(t, "Issuing", root, true, "")
+	leaf := issue(t, "leaf.example.com", issuing, false, server.URL+"/issuing.cer")
+	files["/root.cer"] = root.cert.Raw
+	files["/issuing.cer"] = issuing.cert.Raw
+	files["/impostor.cer"] = impostor.cert.Raw
+
+	otherRoots := x509.NewCertPool()
+	otherRoots.AddCert(issue(t, "Other Root", nil, true, "").cert)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+	nop := &logger.Logger{Logger: zap.NewNop()}
+
+	// A chain ending at a root that is not trusted
+	registry := NewRegistry(&memStore{issuers: map[string]Issuer{}}, otherRoots, 5*time.Second, 4, nop)
+	if _, err := registry.Register(context.Background(), leaf.cert); !errors.Is(err, ErrUntrusted) {
+		t.Errorf("Register() with another root error = %v, want ErrUntrusted", err)
+	}
+
+	registry = NewRegistry(&memStore{issuers: map[string]Issuer{}}, roots, 5*time.Second, 4, nop)
+	// A certificate with the right subject that did not sign the leaf
+	forged := *leaf.cert
+	forged.IssuingCertificateURL = []string{server.URL + "/impostor.cer"}
+	if _, err := registry.Register(context.Background(), &forged); err == nil {
+		t.Error("Register() accepted an issuer that did not sign the leaf")
+	}
+	// No HTTP caIssuers URL
+	bare := *leaf.cert
+	bare.IssuingCertificateURL = []string{"ldap://dir.example.com/cn=Issuing"}
+	if _, err := registry.Register(context.Background(), &bare); !errors.Is(err, ErrNoAIA) {
+		t.Errorf("Register() without AIA error = %v, want ErrNoAIA", err)
+	}
+	if list, _ := registry.List(context.Background()); len(list) != 0 {
+		t.Errorf("registered %d issuers after refusals", len(list))
+	}
+}
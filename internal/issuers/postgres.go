@@ -0,0 +1,86 @@
+package issuers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Postgres keeps registered issuers in the issuers table so every replica sees them
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgres creates a Postgres issuer store
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Put records an issuer, keeping the first registration of its key
+func (p *Postgres) Put(ctx context.Context, i *Issuer) error {
+	_, err := p.db.Exec(ctx, `
+		INSERT INTO issuers (key_hash, name_hash, key_hash_sha256, name_hash_sha256, subject_key_id,
+			subject, serial, not_before, not_after, certificate, source_url, discovered_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (key_hash) DO NOTHING
+	`, i.KeyHash, i.NameHash, i.KeyHashSHA256, i.NameHashSHA256, i.SubjectKeyID,
+		i.Subject, i.Serial, i.NotBefore, i.NotAfter, i.Certificate, i.SourceURL, i.DiscoveredAt)
+	return err
+}
+
+const selectIssuer = `SELECT key_hash, name_hash, key_hash_sha256, name_hash_sha256, subject_key_id,
+	subject, serial, not_before, not_after, certificate, source_url, discovered_at FROM issuers`
+
+// Get returns the issuer with a hex SHA-1 key hash, or ErrNotFound
+func (p *Postgres) Get(ctx context.Context, keyHash string) (*Issuer, error) {
+	return p.one(ctx, selectIssuer+` WHERE key_hash = $1`, keyHash)
+}
+
+// Find returns an issuer with the name hash, and the subject key ID when it is not empty,
+// or ErrNotFound
+func (p *Postgres) Find(ctx context.Context, nameHash, subjectKeyID string) (*Issuer, error) {
+	return p.one(ctx, selectIssuer+`
+		WHERE name_hash = $1 AND ($2 = '' OR subject_key_id = $2)
+		ORDER BY discovered_at DESC
+		LIMIT 1
+	`, nameHash, subjectKeyID)
+}
+
+// List returns every registered issuer, by subject
+func (p *Postgres) List(ctx context.Context) ([]Issuer, error) {
+	rows, err := p.db.Query(ctx, selectIssuer+` ORDER BY subject, not_before`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var issuers []Issuer
+	for rows.Next() {
+		i, err := scanIssuer(rows)
+		if err != nil {
+			return nil, err
+		}
+		issuers = append(issuers, *i)
+	}
+	return issuers, rows.Err()
+}
+
+func (p *Postgres) one(ctx context.Context, sql string, args ...interface{}) (*Issuer, error) {
+	i, err := scanIssuer(p.db.QueryRow(ctx, sql, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return i, err
+}
+
+func scanIssuer(row pgx.Row) (*Issuer, error) {
+	var i Issuer
+	if err := row.Scan(&i.KeyHash, &i.NameHash, &i.KeyHashSHA256, &i.NameHashSHA256, &i.SubjectKeyID,
+		&i.Subject, &i.Serial, &i.NotBefore, &i.NotAfter, &i.Certificate, &i.SourceURL, &i.DiscoveredAt); err != nil {
+		return nil, err
+	}
+	i.NotBefore, i.NotAfter, i.DiscoveredAt = i.NotBefore.UTC(), i.NotAfter.UTC(), i.DiscoveredAt.UTC()
+	return &i, nil
+}
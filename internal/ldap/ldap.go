@@ -0,0 +1,154 @@
+// Package ldap reads revocation lists from the directories legacy enterprise PKIs publish them
+// to, using go-ldap. Each search binds, runs one search and unbinds; referrals are not followed
+package ldap
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+// maxMessage bounds a single LDAP message; a search entry carries a whole CRL
+const maxMessage = 256 << 20
+
+func init() {
+	ber.MaxPacketLengthBytes = maxMessage
+}
+
+// Entry is one entry a search returned
+type Entry struct {
+	DN string
+	// Attributes holds the values of each attribute description as the server returned it
+	Attributes map[string][][]byte
+}
+
+// Values returns the values of the attribute named by desc, matching the attribute type case
+// insensitively and ignoring options such as ;binary, which servers add or drop
+func (e Entry) Values(desc string) [][]byte {
+	want := baseType(desc)
+	var values [][]byte
+	for name, vals := range e.Attributes {
+		if baseType(name) == want {
+			values = append(values, vals...)
+		}
+	}
+	return values
+}
+
+func baseType(desc string) string {
+	name, _, _ := strings.Cut(desc, ";")
+	return strings.ToLower(name)
+}
+
+// Client searches directories with one set of credentials
+type Client struct {
+	bindDN    string
+	password  string
+	startTLS  bool
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+// NewClient creates a client that binds as bindDN, or searches anonymously when it is empty.
+// With startTLS, ldap:// connections are upgraded before binding. tlsConfig, which may be
+// nil, holds the roots and client certificate for ldaps:// and StartTLS. Each search,
+// connection included, must finish within timeout
+func NewClient(bindDN, password string, startTLS bool, tlsConfig *tls.Config, timeout time.Duration) *Client {
+	return &Client{bindDN: bindDN, password: password, startTLS: startTLS, tlsConfig: tlsConfig, timeout: timeout}
+}
+
+// Search runs the search an LDAP URL describes and returns the entries found
+func (c *Client) Search(ctx context.Context, u *URL) ([]Entry, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	raw, err := dialer.DialContext(ctx, "tcp", u.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer raw.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		raw.SetDeadline(deadline)
+	}
+	// Closing the connection unblocks reads when the context is cancelled early
+	stop := context.AfterFunc(ctx, func() { raw.Close() })
+	defer stop()
+
+	netConn := raw
+	if u.TLS {
+		if netConn, err = c.handshake(ctx, raw, u.Host); err != nil {
+			return nil, err
+		}
+	}
+	conn := goldap.NewConn(netConn, u.TLS)
+	conn.SetTimeout(c.timeout)
+	conn.Start()
+	defer conn.Close()
+
+	encrypted := u.TLS
+	if !u.TLS && c.startTLS {
+		if err := conn.StartTLS(c.config(u.Host)); err != nil {
+			return nil, fmt.Errorf("StartTLS with %s failed: %w", u.Host, err)
+		}
+		encrypted = true
+	}
+	defer conn.Unbind()
+
+	if c.bindDN != "" {
+		if c.password != "" && !encrypted {
+			return nil, errors.New("refusing to send the LDAP bind password unencrypted; use ldaps:// or StartTLS")
+		}
+		_, err := conn.SimpleBind(&goldap.SimpleBindRequest{Username: c.bindDN, Password: c.password, AllowEmptyPassword: true})
+		if err != nil {
+			return nil, fmt.Errorf("LDAP bind as %s failed: %w", c.bindDN, err)
+		}
+	}
+
+	result, err := conn.Search(goldap.NewSearchRequest(u.DN, u.Scope, goldap.NeverDerefAliases, 0, 0, false, u.Filter, u.Attributes, nil))
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	// Entries returned before the server's size limit was hit are still usable
+	if goldap.IsErrorWithCode(err, goldap.LDAPResultSizeLimitExceeded) && result != nil && len(result.Entries) > 0 {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, len(result.Entries))
+	for i, found := range result.Entries {
+		entries[i] = Entry{DN: found.DN, Attributes: make(map[string][][]byte, len(found.Attributes))}
+		for _, attr := range found.Attributes {
+			entries[i].Attributes[attr.Name] = append(entries[i].Attributes[attr.Name], attr.ByteValues...)
+		}
+	}
+	return entries, nil
+}
+
+// config returns the TLS configuration for a server, by default verified against host
+func (c *Client) config(host string) *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.tlsConfig != nil {
+		config = c.tlsConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	return config
+}
+
+func (c *Client) handshake(ctx context.Context, conn net.Conn, host string) (net.Conn, error) {
+	tlsConn := tls.Client(conn, c.config(host))
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("TLS handshake with %s failed: %w", host, err)
+	}
+	return tlsConn, nil
+}
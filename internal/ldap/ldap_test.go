@@ -0,0 +1,244 @@
+package ldap
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+func TestParseURL(t *testing.T) {
+	for _, tc := range []struct {
+		raw  string
+		want URL
+		err  string
+	}{
+		{
+			raw:  "ldap://dir.example.com/cn=CA,o=Example",
+			want: URL{Address: "dir.example.com:389", Host: "dir.example.com", DN: "cn=CA,o=Example", Attributes: []string{DefaultAttribute}, Scope: ScopeBase, Filter: defaultFilter},
+		},
+		{
+			raw:  "LDAPS://dir.example.com:1636/cn=CA%20One,o=Example?certificateRevocationList,deltaRevocationList?sub?objectClass=cRLDistributionPoint?ext",
+			want: URL{TLS: true, Address: "dir.example.com:1636", Host: "dir.example.com", DN: "cn=CA One,o=Example", Attributes: []string{"certificateRevocationList", "deltaRevocationList"}, Scope: ScopeSubtree, Filter: "(objectClass=cRLDistributionPoint)"},
+		},
+		{raw: "http://dir.example.com/cn=CA", err: "not an LDAP URL"},
+		{raw: "ldap:///cn=CA", err: "names no server"},
+		{raw: "ldap://dir.example.com/cn=CA???", want: URL{Address: "dir.example.com:389", Host: "dir.example.com", DN: "cn=CA", Attributes: []string{DefaultAttribute}, Scope: ScopeBase, Filter: defaultFilter}},
+		{raw: "ldap://dir.example.com/cn=CA?a?b?c?d?e", err: "too many"},
+		{raw: "ldap://dir.example.com/cn=CA??children", err: "unknown scope"},
+		{raw: "ldap://dir.example.com/cn=CA???(objectClass=", err: "invalid filter"},
+		{raw: "ldap://dir.example.com/cn=CA????!bindname=cn=x", err: "critical extension"},
+	} {
+		got, err := ParseURL(tc.raw)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("ParseURL(%q) error = %v, want %q", tc.raw, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseURL(%q) error = %v", tc.raw, err)
+			continue
+		}
+		if got.TLS != tc.want.TLS || got.Address != tc.want.Address || got.Host != tc.want.Host || got.DN != tc.want.DN ||
+			strings.Join(got.Attributes, ",") != strings.Join(tc.want.Attributes, ",") || got.Scope != tc.want.Scope || got.Filter != tc.want.Filter {
+			t.Errorf("ParseURL(%q) = %+v, want %+v", tc.raw, got, tc.want)
+		}
+	}
+}
+
+// request is an operation the fake directory received
+type request struct {
+	tag ber.Tag
+	op  *ber.Packet
+}
+
+// fakeDirectory accepts one connection at a time and answers each operation with answer.
+// Operations are also sent to requests so the test can inspect them
+func fakeDirectory(t *testing.T, answer func(req request) []*ber.Packet) (string, <-chan request) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	requests := make(chan request, 16)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			for {
+				packet, err := ber.ReadPacket(conn)
+				if err != nil {
+					break
+				}
+				id, op := packet.Children[0].Value.(int64), packet.Children[1]
+				req := request{tag: op.Tag, op: op}
+				requests <- req
+				if op.Tag == goldap.ApplicationUnbindRequest {
+					break
+				}
+				for _, reply := range answer(req) {
+					message := ber.NewSequence("LDAPMessage")
+					message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "messageID"))
+					message.AppendChild(reply)
+					conn.Write(message.Bytes())
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String(), requests
+}
+
+func result(tag ber.Tag, code int64, diagnostic string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, diagnostic, "diagnosticMessage"))
+	return op
+}
+
+func entry(dn, attr string, values ...[]byte) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, goldap.ApplicationSearchResultEntry, nil, "entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "objectName"))
+	attrs := ber.NewSequence("attributes")
+	attribute := ber.NewSequence("attribute")
+	attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attr, "type"))
+	set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+	for _, value := range values {
+		set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(value), "value"))
+	}
+	attribute.AppendChild(set)
+	attrs.AppendChild(attribute)
+	op.AppendChild(attrs)
+	return op
+}
+
+func reference(uri string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, goldap.ApplicationSearchResultReference, nil, "reference")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, uri, "uri"))
+	return op
+}
+
+// crl stands in for a DER CRL: binary, and not valid UTF-8
+var crl = []byte{0x30, 0x82, 0x01, 0xff, 0x00, 0xfe}
+
+func TestSearch(t *testing.T) {
+	addr, requests := fakeDirectory(t, func(req request) []*ber.Packet {
+		return []*ber.Packet{
+			entry("cn=CA,o=Example", "certificateRevocationList;binary", crl),
+			reference("ldap://other.example.com/cn=CA,o=Example"),
+			result(goldap.ApplicationSearchResultDone, goldap.LDAPResultSuccess, ""),
+		}
+	})
+	u, err := ParseURL("ldap://" + addr + "/cn=CA,o=Example?certificateRevocationList;binary?one?(objectClass=*)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := NewClient("", "", false, nil, 5*time.Second).Search(context.Background(), u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].DN != "cn=CA,o=Example" {
+		t.Fatalf("Search() = %+v", entries)
+	}
+	if values := entries[0].Values("CertificateRevocationList"); len(values) != 1 || !bytes.Equal(values[0], crl) {
+		t.Errorf("Values() = %x, want %x", values, crl)
+	}
+
+	search := <-requests
+	if search.tag != goldap.ApplicationSearchRequest {
+		t.Fatalf("first operation has tag %d, want an anonymous search", search.tag)
+	}
+	base, scope, attrs := search.op.Children[0].Value, search.op.Children[1].Value, search.op.Children[7].Children
+	if base != "cn=CA,o=Example" || scope != int64(ScopeOne) || len(attrs) != 1 || attrs[0].Value != "certificateRevocationList;binary" {
+		t.Errorf("search for %v, scope %v, attributes %d", base, scope, len(attrs))
+	}
+	if unbind := <-requests; unbind.tag != goldap.ApplicationUnbindRequest {
+		t.Errorf("search ended with tag %d, want an unbind", unbind.tag)
+	}
+}
+
+func TestSearchBinds(t *testing.T) {
+	addr, requests := fakeDirectory(t, func(req request) []*ber.Packet {
+		if req.tag == goldap.ApplicationBindRequest {
+			return []*ber.Packet{result(goldap.ApplicationBindResponse, goldap.LDAPResultInvalidCredentials, "bad credentials")}
+		}
+		return []*ber.Packet{result(goldap.ApplicationSearchResultDone, goldap.LDAPResultSuccess, "")}
+	})
+	u, err := ParseURL("ldap://" + addr + "/cn=CA,o=Example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An unauthenticated bind, a DN without a password, may go over plain LDAP
+	_, err = NewClient("cn=reader,o=Example", "", false, nil, 5*time.Second).Search(context.Background(), u)
+	if !goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) || !strings.Contains(err.Error(), "bind as cn=reader,o=Example") {
+		t.Errorf("Search() error = %v, want the bind failure", err)
+	}
+	if bind := <-requests; bind.tag != goldap.ApplicationBindRequest || bind.op.Children[1].Value != "cn=reader,o=Example" {
+		t.Errorf("first operation = tag %d, want a bind as cn=reader,o=Example", bind.tag)
+	}
+	<-requests
+
+	_, err = NewClient("cn=reader,o=Example", "secret", false, nil, 5*time.Second).Search(context.Background(), u)
+	if err == nil || !strings.Contains(err.Error(), "unencrypted") {
+		t.Errorf("Search() error = %v, want the password refused over plain LDAP", err)
+	}
+	select {
+	case req := <-requests:
+		if req.tag == goldap.ApplicationBindRequest {
+			t.Error("password sent without TLS")
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSearchSizeLimit(t *testing.T) {
+	var withEntry atomic.Bool
+	withEntry.Store(true)
+	addr, _ := fakeDirectory(t, func(req request) []*ber.Packet {
+		done := result(goldap.ApplicationSearchResultDone, goldap.LDAPResultSizeLimitExceeded, "")
+		if withEntry.Load() {
+			return []*ber.Packet{entry("cn=CA,o=Example", "certificateRevocationList", crl), done}
+		}
+		return []*ber.Packet{done}
+	})
+	u, err := ParseURL("ldap://" + addr + "/o=Example??sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewClient("", "", false, nil, 5*time.Second)
+
+	// Entries returned before the limit are used; a limit with nothing returned is an error
+	if entries, err := client.Search(context.Background(), u); err != nil || len(entries) != 1 {
+		t.Errorf("Search() = %d entries, %v", len(entries), err)
+	}
+	withEntry.Store(false)
+	if _, err := client.Search(context.Background(), u); !goldap.IsErrorWithCode(err, goldap.LDAPResultSizeLimitExceeded) {
+		t.Errorf("Search() error = %v, want size limit exceeded", err)
+	}
+}
+
+func TestSearchTimeout(t *testing.T) {
+	addr, _ := fakeDirectory(t, func(req request) []*ber.Packet { return nil })
+	u, err := ParseURL("ldap://" + addr + "/cn=CA,o=Example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err = NewClient("", "", false, nil, 200*time.Millisecond).Search(context.Background(), u)
+	if err == nil || time.Since(start) > 2*time.Second {
+		t.Errorf("Search() of a silent server returned %v after %v", err, time.Since(start))
+	}
+}
@@ -0,0 +1,106 @@
+package ldap
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+// Search scopes
+const (
+	ScopeBase     = 0
+	ScopeOne      = 1
+	ScopeSubtree  = 2
+	defaultFilter = "(objectClass=*)"
+)
+
+// DefaultAttribute is read when a URL names no attributes
+const DefaultAttribute = "certificateRevocationList;binary"
+
+// URL is a parsed LDAP URL (RFC 4516): ldap[s]://host[:port]/dn?attributes?scope?filter
+type URL struct {
+	// TLS is set for ldaps:// URLs
+	TLS bool
+	// Address is host:port, with the port defaulting to 389 or 636
+	Address    string
+	Host       string
+	DN         string
+	Attributes []string
+	Scope      int
+	Filter     string
+}
+
+// ParseURL parses an ldap:// or ldaps:// URL. A URL without a host, which Active Directory
+// uses to mean the nearest domain controller, is refused, as is any critical extension
+func ParseURL(raw string) (*URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	parsed := &URL{Scope: ScopeBase, Filter: defaultFilter}
+	port := "389"
+	switch strings.ToLower(u.Scheme) {
+	case "ldap":
+	case "ldaps":
+		parsed.TLS, port = true, "636"
+	default:
+		return nil, fmt.Errorf("not an LDAP URL: %q", raw)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("LDAP URL %q names no server", raw)
+	}
+	parsed.Host = u.Hostname()
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	parsed.Address = net.JoinHostPort(parsed.Host, port)
+	parsed.DN = strings.TrimPrefix(u.Path, "/")
+
+	fields := strings.Split(u.RawQuery, "?")
+	if len(fields) > 4 {
+		return nil, fmt.Errorf("LDAP URL %q has too many ? fields", raw)
+	}
+	for len(fields) < 4 {
+		fields = append(fields, "")
+	}
+	for i, field := range fields {
+		if fields[i], err = url.PathUnescape(field); err != nil {
+			return nil, fmt.Errorf("invalid escape in LDAP URL %q: %w", raw, err)
+		}
+	}
+	for _, attr := range strings.Split(fields[0], ",") {
+		if attr = strings.TrimSpace(attr); attr != "" {
+			parsed.Attributes = append(parsed.Attributes, attr)
+		}
+	}
+	if len(parsed.Attributes) == 0 {
+		parsed.Attributes = []string{DefaultAttribute}
+	}
+	switch strings.ToLower(fields[1]) {
+	case "", "base":
+	case "one":
+		parsed.Scope = ScopeOne
+	case "sub":
+		parsed.Scope = ScopeSubtree
+	default:
+		return nil, fmt.Errorf("LDAP URL %q has an unknown scope %q", raw, fields[1])
+	}
+	if filter := fields[2]; filter != "" {
+		if !strings.HasPrefix(filter, "(") {
+			filter = "(" + filter + ")"
+		}
+		if _, err := goldap.CompileFilter(filter); err != nil {
+			return nil, fmt.Errorf("LDAP URL %q has an invalid filter: %w", raw, err)
+		}
+		parsed.Filter = filter
+	}
+	for _, ext := range strings.Split(fields[3], ",") {
+		if strings.HasPrefix(strings.TrimSpace(ext), "!") {
+			return nil, fmt.Errorf("LDAP URL %q has an unsupported critical extension %q", raw, ext)
+		}
+	}
+	return parsed, nil
+}
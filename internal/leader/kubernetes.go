@@ -0,0 +1,150 @@
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// namespaceFile holds the namespace of the pod, mounted with its service account
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// Lease holds a coordination.k8s.io/v1 Lease through client-go's leader election
+type Lease struct {
+	lock     *resourcelock.LeaseLock
+	duration time.Duration
+	interval time.Duration
+	logger   *logger.Logger
+}
+
+// NewLease connects to the API server of the cluster the pod runs in with its service
+// account. An empty namespace is the pod's own; an empty identity is the hostname, which is
+// the pod name, with a random suffix so a restarted pod does not resume its predecessor's term
+func NewLease(namespace, name, identity string, duration, interval time.Duration, logger *logger.Logger) (*Lease, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster: %w", err)
+	}
+	config.Timeout = interval
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		data, err := os.ReadFile(namespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the pod namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		suffix := make([]byte, 4)
+		rand.Read(suffix)
+		identity = hostname + "_" + hex.EncodeToString(suffix)
+	}
+	return newLease(client, namespace, name, identity, duration, interval, logger), nil
+}
+
+func newLease(client kubernetes.Interface, namespace, name, identity string, duration, interval time.Duration, logger *logger.Logger) *Lease {
+	return &Lease{
+		lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Client:     client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		duration: duration,
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// Campaign waits until the lease is free or has expired, takes it and leads while renewing
+// it every interval. The leader steps down when it has not renewed the lease for two thirds
+// of its duration, so its jobs stop before a follower may take over
+func (l *Lease) Campaign(ctx context.Context, lead func(ctx context.Context)) error {
+	// Run starts lead in a goroutine and returns once the term ends without waiting for it;
+	// over keeps a late start from leading after the term
+	var mu sync.Mutex
+	over, leading := false, false
+	done := make(chan struct{})
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          l.lock,
+		Name:          l.lock.LeaseMeta.Name,
+		LeaseDuration: l.duration,
+		RenewDeadline: l.duration * 2 / 3,
+		RetryPeriod:   l.interval,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(termCtx context.Context) {
+				mu.Lock()
+				if over {
+					mu.Unlock()
+					return
+				}
+				leading = true
+				mu.Unlock()
+				defer close(done)
+				lead(termCtx)
+			},
+			OnStoppedLeading: func() {},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	elector.Run(ctx)
+	mu.Lock()
+	over = true
+	led := leading
+	mu.Unlock()
+	if led {
+		<-done
+		if ctx.Err() == nil {
+			l.logger.Error("Lost leader lease", zap.String("lease", l.lock.LeaseMeta.Name))
+		}
+	}
+	// The lease is handed back only after the jobs have stopped
+	return l.release()
+}
+
+// release hands the lease back if this replica still holds it, so a follower takes over at
+// its next attempt instead of after the lease expires
+func (l *Lease) release() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	record, _, err := l.lock.Get(ctx)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if record.HolderIdentity != l.lock.Identity() {
+		return nil
+	}
+	now := metav1.NewTime(time.Now())
+	return l.lock.Update(ctx, resourcelock.LeaderElectionRecord{
+		LeaderTransitions:    record.LeaderTransitions,
+		LeaseDurationSeconds: 1,
+		AcquireTime:          now,
+		RenewTime:            now,
+	})
+}
@@ -0,0 +1,81 @@
+package leader
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLeaseHandsOver(t *testing.T) {
+	client := fake.NewClientset()
+	nop := &logger.Logger{Logger: zap.NewNop()}
+	first := newLease(client, "ocsp", "ocsp-jobs", "first", 3*time.Second, 100*time.Millisecond, nop)
+	second := newLease(client, "ocsp", "ocsp-jobs", "second", 3*time.Second, 100*time.Millisecond, nop)
+
+	var leaders atomic.Int32
+	firstLeading := make(chan struct{})
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	firstDone := make(chan error, 1)
+	go func() {
+		firstDone <- first.Campaign(firstCtx, func(ctx context.Context) {
+			leaders.Add(1)
+			close(firstLeading)
+			<-ctx.Done()
+			leaders.Add(-1)
+		})
+	}()
+	select {
+	case <-firstLeading:
+	case <-time.After(5 * time.Second):
+		t.Fatal("first replica never led")
+	}
+
+	secondLeading := make(chan struct{})
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	secondDone := make(chan error, 1)
+	go func() {
+		secondDone <- second.Campaign(secondCtx, func(ctx context.Context) {
+			if leaders.Add(1) != 1 {
+				t.Error("both replicas led at once")
+			}
+			close(secondLeading)
+			<-ctx.Done()
+		})
+	}()
+	time.Sleep(300 * time.Millisecond)
+	select {
+	case <-secondLeading:
+		t.Fatal("second replica led while the first held the lease")
+	default:
+	}
+
+	// Stepping down hands the lease back, so the second takes over well before it expires
+	stopFirst()
+	if err := <-firstDone; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-secondLeading:
+	case <-time.After(time.Second):
+		t.Fatal("second replica did not take over the released lease")
+	}
+
+	lease, err := client.CoordinationV1().Leases("ocsp").Get(context.Background(), "ocsp-jobs", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder := lease.Spec.HolderIdentity; holder == nil || *holder != "second" {
+		t.Errorf("holder = %v, want second", holder)
+	}
+	stopSecond()
+	if err := <-secondDone; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,121 @@
+// Package leader elects one replica to run background jobs, holding a Postgres advisory lock
+// or a Kubernetes Lease
+package leader
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	isLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "leader",
+		Help:      "Whether this replica holds the background job lock (1) or not (0).",
+	})
+	leaderTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "leader_transitions_total",
+		Help:      "Number of times this replica gained or lost leadership.",
+	}, []string{"transition"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(isLeader, leaderTransitions)
+}
+
+// job is a background loop run only while this replica leads
+type job struct {
+	name string
+	run  func(ctx context.Context)
+}
+
+// Backend is a lock at most one replica holds at a time
+type Backend interface {
+	// Campaign tries once to take the lock. When it does, it calls lead with a context that
+	// ends when the lock is lost or ctx ends, and gives the lock up once lead returns
+	Campaign(ctx context.Context, lead func(ctx context.Context)) error
+}
+
+// Elector runs its jobs while this replica holds the backend's lock. When the lock is lost or
+// the context ends they are cancelled and the lock is given up, so another replica can take
+// over within one check interval
+type Elector struct {
+	backend  Backend
+	interval time.Duration
+	logger   *logger.Logger
+
+	jobs   []job
+	leader atomic.Bool
+}
+
+// NewElector creates an elector competing for backend's lock; interval is how often a
+// follower retries
+func NewElector(backend Backend, interval time.Duration, logger *logger.Logger) *Elector {
+	return &Elector{
+		backend:  backend,
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// Go registers a job started with every leadership term and cancelled when it ends. Jobs
+// must be registered before Run
+func (e *Elector) Go(name string, run func(ctx context.Context)) {
+	e.jobs = append(e.jobs, job{name: name, run: run})
+}
+
+// IsLeader reports whether this replica currently holds the lock
+func (e *Elector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run competes for the lock until the context is cancelled
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.backend.Campaign(ctx, e.lead); err != nil && ctx.Err() == nil {
+			e.logger.Warn("Leader election attempt failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lead runs the jobs until the term ends
+func (e *Elector) lead(termCtx context.Context) {
+	var wg sync.WaitGroup
+
+	e.leader.Store(true)
+	isLeader.Set(1)
+	leaderTransitions.WithLabelValues("acquired").Inc()
+	e.logger.Info("Acquired background job leadership", zap.Int("jobs", len(e.jobs)))
+
+	for _, j := range e.jobs {
+		wg.Add(1)
+		go func(j job) {
+			defer wg.Done()
+			j.run(termCtx)
+		}(j)
+	}
+
+	<-termCtx.Done()
+	wg.Wait()
+	e.leader.Store(false)
+	isLeader.Set(0)
+	leaderTransitions.WithLabelValues("released").Inc()
+	e.logger.Info("Released background job leadership")
+}
@@ -0,0 +1,89 @@
+package leader
+
+import (
+	"context"
+	"hash/fnv"
+	"time"
+
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// AdvisoryLock holds a session-level advisory lock on a dedicated connection, checking the
+// connection every interval while it leads. A broken connection drops the lock with its
+// session, so another replica can take it at once
+type AdvisoryLock struct {
+	pool     *pgxpool.Pool
+	key      int64
+	interval time.Duration
+	logger   *logger.Logger
+}
+
+// NewAdvisoryLock creates a lock named name in the database behind pool
+func NewAdvisoryLock(pool *pgxpool.Pool, name string, interval time.Duration, logger *logger.Logger) *AdvisoryLock {
+	return &AdvisoryLock{
+		pool:     pool,
+		key:      lockKey(name),
+		interval: interval,
+		logger:   logger,
+	}
+}
+
+// lockKey maps a lock name to the 64-bit advisory lock key space
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// Campaign takes the lock if it is free and leads until the connection stops answering
+func (l *AdvisoryLock) Campaign(ctx context.Context, lead func(ctx context.Context)) error {
+	conn, err := l.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	var acquired bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&acquired); err != nil {
+		return err
+	}
+	if !acquired {
+		return nil
+	}
+
+	termCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(termCtx)
+	}()
+
+	ticker := time.NewTicker(l.interval)
+	for termCtx.Err() == nil {
+		select {
+		case <-termCtx.Done():
+		case <-ticker.C:
+			if _, err := conn.Exec(termCtx, "SELECT 1"); err != nil && termCtx.Err() == nil {
+				l.logger.Error("Lost leader lock connection", zap.Error(err))
+				conn.Conn().Close(context.Background())
+				cancel()
+			}
+		}
+	}
+	ticker.Stop()
+	cancel()
+	<-done
+
+	// A broken connection has already dropped the lock with its session
+	if !conn.Conn().IsClosed() {
+		unlockCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if _, err := conn.Exec(unlockCtx, "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+			conn.Conn().Close(unlockCtx)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package legacy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+// certutil column names, as printed by certutil -view with an English locale
+const (
+	certutilSerialColumn = "serial number"
+	certutilDateColumn   = "revocation date"
+	certutilReasonColumn = "revocation reason"
+)
+
+// certutilDateLayouts are the date formats certutil prints, tried in order; dates carry no
+// zone and are read as UTC
+var certutilDateLayouts = []string{
+	"1/2/2006 3:04 PM",
+	"1/2/2006 3:04:05 PM",
+	"1/2/2006 15:04",
+	"1/2/2006 15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// ParseCertutil reads a Microsoft AD CS database export produced by
+//
+//	certutil -view -out "SerialNumber,RevokedWhen,RevokedReason"
+//
+// either as the default "Row N:" text dump or with the csv option. Rows with an EMPTY
+// revocation date are reported as good.
+func ParseCertutil(r io.Reader) ([]storage.Update, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certutil export: %w", err)
+	}
+
+	trimmed := bytes.TrimLeft(decodeUTF16(data), "\ufeff \t\r\n")
+	if bytes.HasPrefix(trimmed, []byte(`"`)) {
+		return parseCertutilCSV(trimmed)
+	}
+	return parseCertutilDump(trimmed)
+}
+
+// decodeUTF16 converts little-endian UTF-16 exports, as written by PowerShell redirection, to UTF-8
+func decodeUTF16(data []byte) []byte {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xfe {
+		return data
+	}
+
+	units := make([]uint16, 0, len(data)/2)
+	for i := 2; i+1 < len(data); i += 2 {
+		units = append(units, uint16(data[i])|uint16(data[i+1])<<8)
+	}
+	return []byte(string(utf16.Decode(units)))
+}
+
+func parseCertutilDump(data []byte) ([]storage.Update, error) {
+	var updates []storage.Update
+	var row map[string]string
+	rowLine := 0
+
+	flush := func() error {
+		if row == nil {
+			return nil
+		}
+		update, err := certutilUpdate(row)
+		if err != nil {
+			return lineError(rowLine, "%v", err)
+		}
+		updates = append(updates, update)
+		row = nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(text, "Row ") && strings.HasSuffix(text, ":"):
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			row = make(map[string]string)
+			rowLine = line
+		case strings.HasPrefix(text, "Maximum Row Index"):
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		case row != nil:
+			name, value, ok := strings.Cut(text, ":")
+			if ok {
+				row[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read certutil export: %w", err)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+func parseCertutilCSV(data []byte) ([]storage.Update, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certutil header: %w", err)
+	}
+	columns := make([]string, len(header))
+	for i, name := range header {
+		columns[i] = strings.ToLower(strings.TrimSpace(name))
+	}
+
+	var updates []storage.Update
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, lineError(line, "%v", err)
+		}
+
+		row := make(map[string]string, len(record))
+		for i, value := range record {
+			if i < len(columns) {
+				row[columns[i]] = strings.TrimSpace(value)
+			}
+		}
+		update, err := certutilUpdate(row)
+		if err != nil {
+			return nil, lineError(line, "%v", err)
+		}
+		updates = append(updates, update)
+	}
+	return updates, nil
+}
+
+func certutilUpdate(row map[string]string) (storage.Update, error) {
+	raw, ok := row[certutilSerialColumn]
+	if !ok {
+		return storage.Update{}, fmt.Errorf("missing %q column", certutilSerialColumn)
+	}
+	serial, err := certutilSerialHex(raw)
+	if err != nil {
+		return storage.Update{}, err
+	}
+
+	date := strings.Trim(row[certutilDateColumn], `"`)
+	if date == "" || strings.EqualFold(date, "EMPTY") {
+		return storage.Update{Serial: serial, Status: storage.StatusGood}, nil
+	}
+	revokedAt, err := parseCertutilDate(date)
+	if err != nil {
+		return storage.Update{}, err
+	}
+
+	reason, err := certutilReason(row[certutilReasonColumn])
+	if err != nil {
+		return storage.Update{}, err
+	}
+	if reason == revocation.ReasonRemoveFromCRL {
+		return storage.Update{Serial: serial, Status: storage.StatusGood}, nil
+	}
+
+	return storage.Update{
+		Serial:           serial,
+		Status:           storage.StatusRevoked,
+		RevokedAt:        &revokedAt,
+		RevocationReason: reason,
+	}, nil
+}
+
+// certutilSerialHex normalizes a quoted, possibly space-separated hex serial
+func certutilSerialHex(raw string) (string, error) {
+	cleaned := strings.NewReplacer(`"`, "", " ", "").Replace(raw)
+	n, ok := new(big.Int).SetString(cleaned, 16)
+	if !ok || n.Sign() <= 0 {
+		return "", fmt.Errorf("invalid serial number %q", raw)
+	}
+	return n.Text(16), nil
+}
+
+func parseCertutilDate(value string) (time.Time, error) {
+	for _, layout := range certutilDateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized revocation date %q", value)
+}
+
+// certutilReason parses reasons printed as "0x1 -- Key Compromise" or a bare code
+func certutilReason(value string) (string, error) {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if value == "" || strings.EqualFold(value, "EMPTY") {
+		return revocation.ReasonUnspecified, nil
+	}
+
+	code, _, _ := strings.Cut(value, " ")
+	n, err := strconv.ParseInt(code, 0, 32)
+	if err != nil {
+		return "", fmt.Errorf("unrecognized revocation reason %q", value)
+	}
+	return revocation.ReasonName(int(n)), nil
+}
@@ -0,0 +1,124 @@
+package legacy
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+// EJBCA CertificateData status values
+const (
+	ejbcaActive               = 20
+	ejbcaNotifiedAboutExpiry  = 21
+	ejbcaTemporarilyRevoked   = 30
+	ejbcaRevoked              = 40
+	ejbcaRolloverPending      = 50
+	ejbcaNotRevokedReasonCode = -1
+)
+
+// ParseEJBCA reads a CSV export of EJBCA's CertificateData table. The header must name the
+// serialNumber (decimal), status, revocationDate (epoch milliseconds) and revocationReason
+// columns; other columns are ignored. Inactive and archived certificates that were never
+// revoked are skipped.
+func ParseEJBCA(r io.Reader) ([]storage.Update, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read EJBCA header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"serialnumber", "status", "revocationdate", "revocationreason"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("EJBCA export is missing the %s column", required)
+		}
+	}
+
+	var updates []storage.Update
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, lineError(line, "%v", err)
+		}
+
+		field := func(name string) string {
+			if i := columns[name]; i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		update, ok, err := ejbcaUpdate(field("serialnumber"), field("status"), field("revocationdate"), field("revocationreason"))
+		if err != nil {
+			return nil, lineError(line, "%v", err)
+		}
+		if ok {
+			updates = append(updates, update)
+		}
+	}
+	return updates, nil
+}
+
+func ejbcaUpdate(rawSerial, rawStatus, rawDate, rawReason string) (storage.Update, bool, error) {
+	n, ok := new(big.Int).SetString(rawSerial, 10)
+	if !ok || n.Sign() <= 0 {
+		return storage.Update{}, false, fmt.Errorf("invalid serial number %q", rawSerial)
+	}
+	serial := n.Text(16)
+
+	status, err := strconv.Atoi(rawStatus)
+	if err != nil {
+		return storage.Update{}, false, fmt.Errorf("invalid status %q", rawStatus)
+	}
+	reasonCode := ejbcaNotRevokedReasonCode
+	if rawReason != "" {
+		if reasonCode, err = strconv.Atoi(rawReason); err != nil {
+			return storage.Update{}, false, fmt.Errorf("invalid revocation reason %q", rawReason)
+		}
+	}
+
+	revoked := status == ejbcaRevoked || status == ejbcaTemporarilyRevoked || reasonCode != ejbcaNotRevokedReasonCode
+	if !revoked {
+		switch status {
+		case ejbcaActive, ejbcaNotifiedAboutExpiry, ejbcaRolloverPending:
+			return storage.Update{Serial: serial, Status: storage.StatusGood}, true, nil
+		default:
+			return storage.Update{}, false, nil
+		}
+	}
+
+	millis, err := strconv.ParseInt(rawDate, 10, 64)
+	if err != nil || millis <= 0 {
+		return storage.Update{}, false, fmt.Errorf("revoked certificate has invalid revocation date %q", rawDate)
+	}
+	revokedAt := time.UnixMilli(millis).UTC()
+
+	reason := revocation.ReasonUnspecified
+	if reasonCode != ejbcaNotRevokedReasonCode {
+		reason = revocation.ReasonName(reasonCode)
+	}
+	if reason == revocation.ReasonRemoveFromCRL {
+		return storage.Update{Serial: serial, Status: storage.StatusGood}, true, nil
+	}
+
+	return storage.Update{
+		Serial:           serial,
+		Status:           storage.StatusRevoked,
+		RevokedAt:        &revokedAt,
+		RevocationReason: reason,
+	}, true, nil
+}
@@ -0,0 +1,33 @@
+// Package legacy moves statuses out of other CA products: it parses their revocation exports
+// into status updates and scrapes their OCSP responders
+package legacy
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+// Supported export formats
+const (
+	FormatCertutil = "certutil"
+	FormatEJBCA    = "ejbca"
+)
+
+// Parse reads an export in the given format
+func Parse(format string, r io.Reader) ([]storage.Update, error) {
+	switch format {
+	case FormatCertutil:
+		return ParseCertutil(r)
+	case FormatEJBCA:
+		return ParseEJBCA(r)
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+// lineError ties a parse failure to its position in the export
+func lineError(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
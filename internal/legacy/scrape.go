@@ -0,0 +1,233 @@
+package legacy
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/pkg/ocspclient"
+	"golang.org/x/crypto/ocsp"
+)
+
+// Mismatch kinds
+const (
+	MismatchMissing = "missing"
+	MismatchStatus  = "status"
+	MismatchReason  = "reason"
+	MismatchTime    = "revocation_time"
+)
+
+// ScrapeOptions configures a Scraper
+type ScrapeOptions struct {
+	// URL is the legacy responder
+	URL string
+	// Issuer is the CA whose certificates are queried; answers must be signed by it or by a
+	// responder it delegated OCSP signing to
+	Issuer *x509.Certificate
+	// TrustedResponders are responder certificates accepted although the issuer did not
+	// delegate to them, as commercial responders are often configured
+	TrustedResponders []*x509.Certificate
+	// Concurrency is the number of queries in flight
+	Concurrency int
+	// Timeout bounds each query
+	Timeout time.Duration
+	// UseGET sends requests short enough as GET rather than POST
+	UseGET bool
+	// ClockSkew is tolerated between the legacy responder's clock and ours
+	ClockSkew time.Duration
+}
+
+// Answer is the legacy responder's verified answer about one serial. Err is set when the
+// query failed or the response did not verify, and Update is then empty
+type Answer struct {
+	Serial string
+	Update storage.Update
+	Err    error
+}
+
+// Mismatch is a serial on which the legacy responder and another source disagree
+type Mismatch struct {
+	Serial    string
+	Kind      string
+	Responder string
+	Other     string
+}
+
+// Scraper queries a legacy OCSP responder for the serials a migration moves
+type Scraper struct {
+	opts   ScrapeOptions
+	client *ocspclient.Client
+}
+
+// NewScraper creates a scraper
+func NewScraper(opts ScrapeOptions) *Scraper {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	return &Scraper{
+		opts:   opts,
+		client: ocspclient.NewClient(&http.Client{Timeout: opts.Timeout}, opts.UseGET),
+	}
+}
+
+// Scrape queries every serial and returns the answers in the order of serials. progress,
+// which may be nil, is called with the number of answers so far
+func (s *Scraper) Scrape(ctx context.Context, serials []string, progress func(done int)) []Answer {
+	answers := make([]Answer, len(serials))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	for range s.opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				answers[i] = s.query(ctx, serials[i])
+				if progress != nil {
+					mu.Lock()
+					done++
+					progress(done)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range serials {
+		if ctx.Err() != nil {
+			answers[i] = Answer{Serial: serials[i], Err: ctx.Err()}
+			continue
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return answers
+}
+
+func (s *Scraper) query(ctx context.Context, serial string) Answer {
+	answer := Answer{Serial: serial}
+	n, ok := new(big.Int).SetString(serial, 16)
+	if !ok {
+		answer.Err = fmt.Errorf("invalid serial %q", serial)
+		return answer
+	}
+	req, err := ocspclient.NewRequest(n, s.opts.Issuer, ocspclient.RequestOptions{})
+	if err != nil {
+		answer.Err = err
+		return answer
+	}
+	resp, err := s.client.Check(ctx, s.opts.URL, req, ocspclient.VerifyOptions{
+		ClockSkew:         s.opts.ClockSkew,
+		TrustedResponders: s.opts.TrustedResponders,
+	})
+	if err != nil {
+		answer.Err = err
+		return answer
+	}
+
+	answer.Update = storage.Update{Serial: serial, Status: storage.StatusUnknown}
+	switch resp.Status {
+	case ocsp.Good:
+		answer.Update.Status = storage.StatusGood
+	case ocsp.Revoked:
+		revokedAt := resp.RevokedAt.UTC()
+		answer.Update.Status = storage.StatusRevoked
+		answer.Update.RevokedAt = &revokedAt
+		answer.Update.RevocationReason = revocation.ReasonName(resp.RevocationReason)
+	}
+	return answer
+}
+
+// Compare reports the verified answers that disagree with want, keyed by serial. A serial
+// absent from want is a MismatchMissing unless the responder answered unknown. Revocation
+// times are compared to the second, the precision of OCSP. Failed answers are skipped
+func Compare(answers []Answer, want map[string]storage.Update) []Mismatch {
+	var mismatches []Mismatch
+	for _, answer := range answers {
+		if answer.Err != nil {
+			continue
+		}
+		got := answer.Update
+		other, ok := want[answer.Serial]
+		kind := ""
+		switch {
+		case !ok:
+			if got.Status != storage.StatusUnknown {
+				kind = MismatchMissing
+			}
+		case got.Status != other.Status:
+			kind = MismatchStatus
+		case got.Status != storage.StatusRevoked:
+		case got.RevocationReason != other.RevocationReason:
+			kind = MismatchReason
+		case !sameSecond(got.RevokedAt, other.RevokedAt):
+			kind = MismatchTime
+		}
+		if kind == "" {
+			continue
+		}
+		mismatch := Mismatch{Serial: answer.Serial, Kind: kind, Responder: Describe(got), Other: "none"}
+		if ok {
+			mismatch.Other = Describe(other)
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Serial < mismatches[j].Serial })
+	return mismatches
+}
+
+func sameSecond(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Truncate(time.Second).Equal(b.Truncate(time.Second))
+}
+
+// Describe formats a status for reports: its status, and for revocations the reason and time
+func Describe(u storage.Update) string {
+	if u.Status != storage.StatusRevoked {
+		return u.Status
+	}
+	desc := u.Status + " " + u.RevocationReason
+	if u.RevokedAt != nil {
+		desc += " " + u.RevokedAt.UTC().Format(time.RFC3339)
+	}
+	return desc
+}
+
+// ReadSerials reads one hex serial per line, optionally 0x-prefixed or colon separated, and
+// returns them in lowercase hex without duplicates. Blank lines and lines starting with # are
+// skipped
+func ReadSerials(r io.Reader) ([]string, error) {
+	var serials []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		cleaned := strings.NewReplacer(":", "", " ", "").Replace(text)
+		cleaned = strings.TrimPrefix(strings.TrimPrefix(cleaned, "0x"), "0X")
+		n, ok := new(big.Int).SetString(cleaned, 16)
+		if !ok || n.Sign() <= 0 {
+			return nil, lineError(line, "invalid serial %q", text)
+		}
+		if serial := n.Text(16); !seen[serial] {
+			seen[serial] = true
+			serials = append(serials, serial)
+		}
+	}
+	return serials, scanner.Err()
+}
@@ -0,0 +1,184 @@
+// Package loadshed bounds the requests in flight on the serving path with a limit that adapts
+// to latency, so a traffic spike is turned away early instead of queueing on the database or
+// signer until every request is slow
+package loadshed
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/shared/api/proto/ocsp"
+	"github.com/prometheus/client_golang/prometheus"
+	xocsp "golang.org/x/crypto/ocsp"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Surfaces, used in metric labels
+const (
+	surfaceHTTP = "http"
+	surfaceGRPC = "grpc"
+)
+
+// retryAfter is the delay suggested to shed clients
+const retryAfter = time.Second
+
+var (
+	shed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "shed_requests_total",
+		Help:      "Serving-path requests turned away because the concurrency limit was reached, by surface.",
+	}, []string{"surface"})
+	limitGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "concurrency_limit",
+		Help:      "Current adaptive limit on serving-path requests in flight.",
+	})
+	inflightGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "concurrency_inflight",
+		Help:      "Serving-path requests in flight.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(shed, limitGauge, inflightGauge)
+}
+
+// Options configures a limiter
+type Options struct {
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+	// LatencyTarget is the latency above which a request counts as a sign of overload
+	LatencyTarget time.Duration
+	// Backoff multiplies the limit on overload, between 0 and 1
+	Backoff float64
+}
+
+// Limiter admits requests while fewer than its limit are in flight, adjusting the limit by AIMD
+// against the latency target
+type Limiter struct {
+	opts Options
+
+	mu          sync.Mutex
+	limit       float64
+	inflight    int
+	lastBackoff time.Time
+}
+
+// New creates a limiter. It returns nil when opts.MaxLimit is not positive, and a nil Limiter
+// admits everything
+func New(opts Options) *Limiter {
+	if opts.MaxLimit <= 0 {
+		return nil
+	}
+	limitGauge.Set(float64(opts.InitialLimit))
+	return &Limiter{opts: opts, limit: float64(opts.InitialLimit)}
+}
+
+// Acquire admits a request unless the limit is reached. done must be called once the request
+// is finished, with overloaded set when it failed because a dependency was saturated
+func (l *Limiter) Acquire() (done func(overloaded bool), ok bool) {
+	if l == nil {
+		return func(bool) {}, true
+	}
+	l.mu.Lock()
+	if l.inflight >= int(l.limit) {
+		l.mu.Unlock()
+		return nil, false
+	}
+	l.inflight++
+	inflightGauge.Set(float64(l.inflight))
+	l.mu.Unlock()
+
+	started := time.Now()
+	return func(overloaded bool) {
+		l.release(time.Since(started), overloaded)
+	}, true
+}
+
+func (l *Limiter) release(latency time.Duration, overloaded bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	utilized := l.inflight*2 >= int(l.limit)
+	l.inflight--
+	inflightGauge.Set(float64(l.inflight))
+
+	now := time.Now()
+	switch {
+	case overloaded || latency > l.opts.LatencyTarget:
+		if now.Sub(l.lastBackoff) < l.opts.LatencyTarget {
+			return
+		}
+		l.lastBackoff = now
+		l.limit = math.Max(float64(l.opts.MinLimit), l.limit*l.opts.Backoff)
+	case utilized:
+		l.limit = math.Min(float64(l.opts.MaxLimit), l.limit+1/l.limit)
+	default:
+		return
+	}
+	limitGauge.Set(l.limit)
+}
+
+// Middleware answers requests under prefixes with an OCSP tryLater response and Retry-After
+// while the limit is reached. Other paths pass through unlimited
+func Middleware(l *Limiter, prefixes []string, next http.Handler) http.Handler {
+	if l == nil || len(prefixes) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range prefixes {
+			if !strings.HasPrefix(r.URL.Path, prefix) {
+				continue
+			}
+			done, ok := l.Acquire()
+			if !ok {
+				shed.WithLabelValues(surfaceHTTP).Inc()
+				w.Header().Set("Content-Type", "application/ocsp-response")
+				w.Header().Set("Retry-After", "1")
+				w.Write(xocsp.TryLaterErrorResponse)
+				return
+			}
+			defer done(false)
+			break
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// UnaryServerInterceptor limits CheckStatus calls, answering UNAVAILABLE with retry info while
+// the limit is reached. Status changes are never shed. Calls failing with UNAVAILABLE,
+// DEADLINE_EXCEEDED or RESOURCE_EXHAUSTED count as overload
+func UnaryServerInterceptor(l *Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if info.FullMethod != ocsp.OCSPService_CheckStatus_FullMethodName {
+			return handler(ctx, req)
+		}
+		done, ok := l.Acquire()
+		if !ok {
+			shed.WithLabelValues(surfaceGRPC).Inc()
+			st := status.New(codes.Unavailable, "too many requests in flight")
+			if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)}); err == nil {
+				st = detailed
+			}
+			return nil, st.Err()
+		}
+		resp, err := handler(ctx, req)
+		switch status.Code(err) {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+			done(true)
+		default:
+			done(false)
+		}
+		return resp, err
+	}
+}
@@ -0,0 +1,189 @@
+// Package loadtest replays lookups against a responder at a fixed request rate and reports
+// latency percentiles, errors, statuses and cache behavior, for capacity planning
+package loadtest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Options control a run. Requests are sent open loop: every 1/RPS whether or not earlier
+// ones have been answered, as clients do, so a slow responder shows up as latency rather than
+// a lower rate. At most MaxInFlight requests are outstanding; a request due beyond that is
+// dropped and counted, which means the load generator, not the responder, is the bottleneck
+type Options struct {
+	RPS         float64
+	Duration    time.Duration
+	Timeout     time.Duration
+	MaxInFlight int
+	// Progress, if set, receives a report of the run so far every ProgressInterval
+	Progress         func(Report)
+	ProgressInterval time.Duration
+}
+
+// Report summarizes a run
+type Report struct {
+	Elapsed   time.Duration
+	Sent      int
+	Completed int
+	Dropped   int
+	Errors    map[string]int
+	Statuses  map[string]int
+	CacheHits int
+	// CacheObserved counts responses that said whether a cache served them
+	CacheObserved int
+	Latency       Percentiles
+}
+
+// Percentiles of request latency, errors included
+type Percentiles struct {
+	P50, P90, P99, P999, Max time.Duration
+}
+
+// ErrorRate is the share of completed requests that failed
+func (r Report) ErrorRate() float64 {
+	if r.Completed == 0 {
+		return 0
+	}
+	failed := 0
+	for _, n := range r.Errors {
+		failed += n
+	}
+	return float64(failed) / float64(r.Completed)
+}
+
+// Throughput is completed requests per second
+func (r Report) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Completed) / r.Elapsed.Seconds()
+}
+
+// recorder collects outcomes from concurrent requests
+type recorder struct {
+	mu        sync.Mutex
+	started   time.Time
+	sent      int
+	dropped   int
+	latencies []time.Duration
+	errors    map[string]int
+	statuses  map[string]int
+	hits      int
+	observed  int
+}
+
+func (r *recorder) record(latency time.Duration, outcome Outcome) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies = append(r.latencies, latency)
+	if outcome.Error != "" {
+		r.errors[outcome.Error]++
+	} else {
+		r.statuses[outcome.Status]++
+	}
+	switch outcome.Cache {
+	case "hit":
+		r.hits++
+		r.observed++
+	case "miss":
+		r.observed++
+	}
+}
+
+func (r *recorder) report() Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep := Report{
+		Elapsed:       time.Since(r.started),
+		Sent:          r.sent,
+		Completed:     len(r.latencies),
+		Dropped:       r.dropped,
+		Errors:        make(map[string]int, len(r.errors)),
+		Statuses:      make(map[string]int, len(r.statuses)),
+		CacheHits:     r.hits,
+		CacheObserved: r.observed,
+	}
+	for k, v := range r.errors {
+		rep.Errors[k] = v
+	}
+	for k, v := range r.statuses {
+		rep.Statuses[k] = v
+	}
+	if n := len(r.latencies); n > 0 {
+		sorted := append([]time.Duration(nil), r.latencies...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		at := func(q float64) time.Duration { return sorted[min(n-1, int(q*float64(n)))] }
+		rep.Latency = Percentiles{P50: at(0.5), P90: at(0.9), P99: at(0.99), P999: at(0.999), Max: sorted[n-1]}
+	}
+	return rep
+}
+
+// Run sends lookups of sampled serials to target at opts.RPS until opts.Duration has passed or
+// ctx is cancelled, waits for outstanding requests and reports on them
+func Run(ctx context.Context, opts Options, sampler Sampler, target Target) Report {
+	rec := &recorder{started: time.Now(), errors: make(map[string]int), statuses: make(map[string]int)}
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	if opts.Progress != nil && opts.ProgressInterval > 0 {
+		ticker := time.NewTicker(opts.ProgressInterval)
+		defer ticker.Stop()
+		go func() {
+			for {
+				select {
+				case <-ticker.C:
+					opts.Progress(rec.report())
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	slots := make(chan struct{}, opts.MaxInFlight)
+	var wg sync.WaitGroup
+	interval := time.Duration(float64(time.Second) / opts.RPS)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for i := 0; ; i++ {
+		// Requests are due on a fixed schedule; after a stall the overdue ones go out at once
+		if wait := time.Until(rec.started.Add(time.Duration(i) * interval)); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		serial := sampler.Next()
+		select {
+		case slots <- struct{}{}:
+		default:
+			rec.mu.Lock()
+			rec.dropped++
+			rec.mu.Unlock()
+			continue
+		}
+		rec.mu.Lock()
+		rec.sent++
+		rec.mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			// Outstanding requests may finish after the run ends, within their own timeout
+			reqCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), opts.Timeout)
+			defer cancel()
+			started := time.Now()
+			outcome := target.Lookup(reqCtx, serial)
+			rec.record(time.Since(started), outcome)
+		}()
+	}
+	wg.Wait()
+	return rec.report()
+}
@@ -0,0 +1,150 @@
+package loadtest
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/rand"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gigvault/ocsp/internal/ocspreq"
+)
+
+// Sampler picks the serial of the next request. It is called from one goroutine
+type Sampler interface {
+	Next() string
+}
+
+// zipf picks serials by rank: the first serial is the most requested, as a few popular
+// certificates dominate real OCSP traffic
+type zipf struct {
+	serials []string
+	dist    *rand.Zipf
+}
+
+// NewZipf samples serials with a Zipf distribution of exponent s > 1 over their order
+func NewZipf(serials []string, s float64, seed int64) (Sampler, error) {
+	if len(serials) == 0 {
+		return nil, errors.New("no serials to sample")
+	}
+	if s <= 1 {
+		return nil, errors.New("zipf exponent must be greater than 1")
+	}
+	dist := rand.NewZipf(rand.New(rand.NewSource(seed)), s, 1, uint64(len(serials)-1))
+	return &zipf{serials: serials, dist: dist}, nil
+}
+
+func (z *zipf) Next() string {
+	return z.serials[z.dist.Uint64()]
+}
+
+// empirical picks serials as often as they were requested in a log
+type empirical struct {
+	serials    []string
+	cumulative []int
+	random     *rand.Rand
+}
+
+// NewEmpirical samples serials in proportion to counts
+func NewEmpirical(counts map[string]int, seed int64) (Sampler, error) {
+	if len(counts) == 0 {
+		return nil, errors.New("no serials to sample")
+	}
+	e := &empirical{random: rand.New(rand.NewSource(seed))}
+	for serial := range counts {
+		e.serials = append(e.serials, serial)
+	}
+	// Map order is random; sorting keeps runs with one seed comparable
+	sort.Strings(e.serials)
+	total := 0
+	for _, serial := range e.serials {
+		total += counts[serial]
+		e.cumulative = append(e.cumulative, total)
+	}
+	return e, nil
+}
+
+func (e *empirical) Next() string {
+	n := e.random.Intn(e.cumulative[len(e.cumulative)-1])
+	return e.serials[sort.SearchInts(e.cumulative, n+1)]
+}
+
+// ReadSerials reads one hex serial per line, most requested first. Blank lines and lines
+// starting with # are skipped
+func ReadSerials(r io.Reader) ([]string, error) {
+	var serials []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		serials = append(serials, strings.ToLower(line))
+	}
+	return serials, scanner.Err()
+}
+
+var (
+	// jsonSerial matches a serial field of a structured log line
+	jsonSerial = regexp.MustCompile(`"serial(?:_number)?"\s*:\s*"([0-9a-fA-F]+)"`)
+	// getRequest matches a base64 RFC 6960 GET request in a request line
+	getRequest = regexp.MustCompile(`/((?:[A-Za-z0-9+/=]|%[0-9A-Fa-f]{2}){40,})`)
+)
+
+// ReadAccessLog counts the serials requested in an access log: serial fields of JSON lines,
+// as the responder logs them, and base64 GET requests in the request lines of proxy and CDN
+// logs. Lines with neither are skipped
+func ReadAccessLog(r io.Reader) (map[string]int, error) {
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := jsonSerial.FindStringSubmatch(line); m != nil {
+			counts[strings.ToLower(m[1])]++
+			continue
+		}
+		for _, m := range getRequest.FindAllStringSubmatch(line, -1) {
+			if serial, ok := serialFromGET(m[1]); ok {
+				counts[serial]++
+				break
+			}
+		}
+	}
+	return counts, scanner.Err()
+}
+
+// serialFromGET decodes the serial of a base64 GET request at the end of a path. The path
+// prefix is unknown and base64 may contain slashes, so each suffix after a slash is tried
+func serialFromGET(path string) (string, bool) {
+	for {
+		if serial, ok := decodeGET(path); ok {
+			return serial, true
+		}
+		i := strings.IndexByte(path, '/')
+		if i < 0 {
+			return "", false
+		}
+		path = path[i+1:]
+	}
+}
+
+func decodeGET(encoded string) (string, bool) {
+	unescaped, err := url.PathUnescape(encoded)
+	if err != nil {
+		return "", false
+	}
+	der, err := base64.StdEncoding.DecodeString(unescaped)
+	if err != nil {
+		return "", false
+	}
+	req, err := ocspreq.Parse(der, ocspreq.Limits{})
+	if err != nil {
+		return "", false
+	}
+	return req.SerialNumber.Text(16), true
+}
@@ -0,0 +1,178 @@
+package loadtest
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gigvault/shared/api/proto/ocsp"
+	xocsp "golang.org/x/crypto/ocsp"
+	"google.golang.org/grpc/status"
+)
+
+// Outcome is the result of one request. Error is empty for an answered lookup, whose status
+// is good, revoked or unknown; otherwise it names the failure, such as try_later, http_503 or
+// a gRPC code. Cache is hit or miss when the response said whether a cache served it
+type Outcome struct {
+	Status string
+	Error  string
+	Cache  string
+}
+
+// Target sends one lookup
+type Target interface {
+	Lookup(ctx context.Context, serial string) Outcome
+}
+
+// HTTPTarget sends RFC 6960 requests for one issuer, by POST or base64 GET
+type HTTPTarget struct {
+	url    string
+	get    bool
+	issuer *x509.Certificate
+	client *http.Client
+}
+
+// NewHTTPTarget creates a target posting requests to responderURL, or with get, sending them
+// base64-encoded below it as CDNs cache them
+func NewHTTPTarget(responderURL string, get bool, issuer *x509.Certificate, client *http.Client) *HTTPTarget {
+	return &HTTPTarget{url: strings.TrimSuffix(responderURL, "/"), get: get, issuer: issuer, client: client}
+}
+
+func (t *HTTPTarget) Lookup(ctx context.Context, serial string) Outcome {
+	n, ok := new(big.Int).SetString(serial, 16)
+	if !ok {
+		return Outcome{Error: "invalid_serial"}
+	}
+	der, err := xocsp.CreateRequest(&x509.Certificate{SerialNumber: n}, t.issuer, &xocsp.RequestOptions{Hash: crypto.SHA1})
+	if err != nil {
+		return Outcome{Error: "invalid_request"}
+	}
+
+	var req *http.Request
+	if t.get {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, t.url+"/"+url.QueryEscape(base64.StdEncoding.EncodeToString(der)), nil)
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(der))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/ocsp-request")
+		}
+	}
+	if err != nil {
+		return Outcome{Error: "invalid_request"}
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return Outcome{Error: transportError(ctx, err)}
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Outcome{Error: transportError(ctx, err)}
+	}
+
+	outcome := Outcome{Cache: cacheResult(resp.Header)}
+	if resp.StatusCode != http.StatusOK {
+		outcome.Error = "http_" + strconv.Itoa(resp.StatusCode)
+		return outcome
+	}
+	parsed, err := xocsp.ParseResponse(body, nil)
+	var responseErr xocsp.ResponseError
+	switch {
+	case err == nil:
+		outcome.Status = statusName(parsed.Status)
+	case errors.As(err, &responseErr):
+		outcome.Error = errorName(responseErr.Status)
+	default:
+		outcome.Error = "invalid_response"
+	}
+	return outcome
+}
+
+// cacheResult reads the cache headers CDNs and proxies commonly add
+func cacheResult(header http.Header) string {
+	for _, name := range []string{"X-Cache", "X-Cache-Status", "CF-Cache-Status", "CDN-Cache"} {
+		value := strings.ToUpper(header.Get(name))
+		switch {
+		case value == "":
+		case strings.Contains(value, "HIT"):
+			return "hit"
+		default:
+			return "miss"
+		}
+	}
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil {
+		if age > 0 {
+			return "hit"
+		}
+		return "miss"
+	}
+	return ""
+}
+
+// GRPCTarget looks serials up with CheckStatus
+type GRPCTarget struct {
+	client ocsp.OCSPServiceClient
+}
+
+func NewGRPCTarget(client ocsp.OCSPServiceClient) *GRPCTarget {
+	return &GRPCTarget{client: client}
+}
+
+func (t *GRPCTarget) Lookup(ctx context.Context, serial string) Outcome {
+	resp, err := t.client.CheckStatus(ctx, &ocsp.CheckStatusRequest{SerialNumber: serial})
+	if err != nil {
+		if ctx.Err() != nil {
+			return Outcome{Error: "timeout"}
+		}
+		return Outcome{Error: strings.ToLower(status.Code(err).String())}
+	}
+	return Outcome{Status: resp.Status}
+}
+
+func transportError(ctx context.Context, err error) string {
+	if ctx.Err() != nil {
+		return "timeout"
+	}
+	if urlErr, ok := err.(*url.Error); ok && urlErr.Timeout() {
+		return "timeout"
+	}
+	return "connection"
+}
+
+func statusName(status int) string {
+	switch status {
+	case xocsp.Good:
+		return "good"
+	case xocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
+
+// errorName names an unsuccessful OCSP response status as the responder's metrics do
+func errorName(status xocsp.ResponseStatus) string {
+	switch status {
+	case xocsp.Malformed:
+		return "malformed"
+	case xocsp.InternalError:
+		return "internal_error"
+	case xocsp.TryLater:
+		return "try_later"
+	case xocsp.SignatureRequired:
+		return "sig_required"
+	case xocsp.Unauthorized:
+		return "unauthorized"
+	default:
+		return "status_" + strconv.Itoa(int(status))
+	}
+}
@@ -0,0 +1,192 @@
+package logging
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gigvault/ocsp/internal/config"
+	"github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Component names used by the service when deriving named loggers
+const (
+	ComponentGRPC    = "grpc"
+	ComponentHTTP    = "http"
+	ComponentAnomaly = "anomaly"
+	ComponentMetrics = "metrics"
+)
+
+// New builds the root logger, applying per-component levels, sampling, and redaction from the policy
+func New(level, format string, policy config.LogPolicyConfig) (*logger.Logger, error) {
+	global, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	components := make(map[string]zapcore.Level, len(policy.Components))
+	for name, lvl := range policy.Components {
+		parsed, err := zapcore.ParseLevel(lvl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log level for component %s: %w", name, err)
+		}
+		components[name] = parsed
+	}
+	lv := &levels{global: zap.NewAtomicLevel(), min: zap.NewAtomicLevel(), components: components}
+	lv.set(global)
+
+	mode := policy.Redaction.Mode
+	if mode == "" {
+		mode = RedactHash
+	}
+	if mode != RedactHash && mode != RedactMask && mode != RedactDrop {
+		return nil, fmt.Errorf("invalid redaction mode %q (must be: hash, mask, or drop)", mode)
+	}
+
+	var zcfg zap.Config
+	if format == "json" {
+		zcfg = zap.NewProductionConfig()
+	} else {
+		zcfg = zap.NewDevelopmentConfig()
+		zcfg.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	}
+	// Sampling is applied per component by the policy core instead
+	zcfg.Sampling = nil
+	zcfg.Level = lv.min
+
+	base, err := zcfg.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		if len(policy.Redaction.Fields) > 0 {
+			core = newRedactCore(core, policy.Redaction.Fields, mode)
+		}
+		return newPolicyCore(core, lv, policy.Sampling)
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	root.Store(lv)
+	return &logger.Logger{Logger: base}, nil
+}
+
+// levels holds the global level, which can change at runtime, and the fixed per-component
+// levels. min, the lowest of them, gates entries before any component is looked at
+type levels struct {
+	global     zap.AtomicLevel
+	min        zap.AtomicLevel
+	components map[string]zapcore.Level
+}
+
+func (l *levels) set(global zapcore.Level) {
+	minLevel := global
+	for _, lvl := range l.components {
+		minLevel = min(minLevel, lvl)
+	}
+	l.global.SetLevel(global)
+	l.min.SetLevel(minLevel)
+}
+
+// root holds the levels of the logger New built last
+var root atomic.Pointer[levels]
+
+// Level returns the global level of the logger New built last
+func Level() string {
+	lv := root.Load()
+	if lv == nil {
+		return ""
+	}
+	return lv.global.Level().String()
+}
+
+// SetLevel changes the global level of the logger New built last, and of every logger derived
+// from it. Components with a level of their own keep it
+func SetLevel(level string) error {
+	parsed, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	lv := root.Load()
+	if lv == nil {
+		return fmt.Errorf("no logger to set the level of")
+	}
+	lv.set(parsed)
+	return nil
+}
+
+// Component returns a child logger whose entries are governed by the named component's policy
+func Component(l *logger.Logger, name string) *logger.Logger {
+	return &logger.Logger{Logger: l.Named(name)}
+}
+
+// policyCore routes entries to per-component level filters and samplers based on the logger name
+type policyCore struct {
+	inner    zapcore.Core
+	levels   *levels
+	samplers map[string]zapcore.Core
+}
+
+func newPolicyCore(inner zapcore.Core, levels *levels, sampling config.LogSamplingConfig) *policyCore {
+	samplers := make(map[string]zapcore.Core, len(sampling.Components))
+	if sampling.Tick > 0 {
+		for _, name := range sampling.Components {
+			samplers[name] = zapcore.NewSamplerWithOptions(inner, sampling.Tick, sampling.Initial, sampling.Thereafter)
+		}
+	}
+
+	return &policyCore{
+		inner:    inner,
+		levels:   levels,
+		samplers: samplers,
+	}
+}
+
+func (c *policyCore) Enabled(lvl zapcore.Level) bool {
+	return c.levels.min.Enabled(lvl)
+}
+
+func (c *policyCore) With(fields []zapcore.Field) zapcore.Core {
+	samplers := make(map[string]zapcore.Core, len(c.samplers))
+	for name, sampler := range c.samplers {
+		samplers[name] = sampler.With(fields)
+	}
+
+	return &policyCore{
+		inner:    c.inner.With(fields),
+		levels:   c.levels,
+		samplers: samplers,
+	}
+}
+
+func (c *policyCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	name := componentOf(ent.LoggerName)
+
+	lvl, ok := c.levels.components[name]
+	if !ok {
+		lvl = c.levels.global.Level()
+	}
+	if ent.Level < lvl {
+		return ce
+	}
+
+	if sampler, ok := c.samplers[name]; ok {
+		return sampler.Check(ent, ce)
+	}
+	return c.inner.Check(ent, ce)
+}
+
+func (c *policyCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.inner.Write(ent, fields)
+}
+
+func (c *policyCore) Sync() error {
+	return c.inner.Sync()
+}
+
+// componentOf returns the top-level component of a dotted logger name
+func componentOf(loggerName string) string {
+	if i := strings.IndexByte(loggerName, '.'); i >= 0 {
+		return loggerName[:i]
+	}
+	return loggerName
+}
@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Redaction modes applied to configured field names
+const (
+	RedactHash = "hash"
+	RedactMask = "mask"
+	RedactDrop = "drop"
+)
+
+const redacted = "[redacted]"
+
+// redactCore rewrites sensitive fields before they reach the encoder
+type redactCore struct {
+	zapcore.Core
+	fields map[string]bool
+	mode   string
+}
+
+func newRedactCore(inner zapcore.Core, fields []string, mode string) *redactCore {
+	set := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		set[f] = true
+	}
+	return &redactCore{Core: inner, fields: set, mode: mode}
+}
+
+func (c *redactCore) With(fields []zapcore.Field) zapcore.Core {
+	return &redactCore{
+		Core:   c.Core.With(c.redact(fields)),
+		fields: c.fields,
+		mode:   c.mode,
+	}
+}
+
+func (c *redactCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *redactCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	return c.Core.Write(ent, c.redact(fields))
+}
+
+func (c *redactCore) redact(fields []zapcore.Field) []zapcore.Field {
+	var out []zapcore.Field
+	for i, f := range fields {
+		if !c.fields[f.Key] {
+			if out != nil {
+				out = append(out, f)
+			}
+			continue
+		}
+
+		// Copy on first match so callers' field slices are never mutated
+		if out == nil {
+			out = make([]zapcore.Field, i, len(fields))
+			copy(out, fields[:i])
+		}
+		if c.mode == RedactDrop {
+			continue
+		}
+		out = append(out, zapcore.Field{
+			Key:    f.Key,
+			Type:   zapcore.StringType,
+			String: c.redactValue(f),
+		})
+	}
+
+	if out == nil {
+		return fields
+	}
+	return out
+}
+
+func (c *redactCore) redactValue(f zapcore.Field) string {
+	if f.Type != zapcore.StringType || f.String == "" {
+		return redacted
+	}
+
+	switch c.mode {
+	case RedactMask:
+		if len(f.String) <= 4 {
+			return strings.Repeat("*", len(f.String))
+		}
+		return strings.Repeat("*", len(f.String)-4) + f.String[len(f.String)-4:]
+	default:
+		sum := sha256.Sum256([]byte(f.String))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	}
+}
@@ -0,0 +1,82 @@
+package metering
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gigvault/ocsp/internal/apikeys"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/api/proto/ocsp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type issuerKey struct{}
+
+// WithIssuer returns a context attributing the writes made with it to the issuer with the hex
+// key hash
+func WithIssuer(ctx context.Context, issuer string) context.Context {
+	return context.WithValue(ctx, issuerKey{}, strings.ToLower(issuer))
+}
+
+func issuerFrom(ctx context.Context) string {
+	issuer, _ := ctx.Value(issuerKey{}).(string)
+	return issuer
+}
+
+// tenantFrom returns the tenant of the API key a call authenticated with, or ""
+func tenantFrom(ctx context.Context) string {
+	if key := apikeys.KeyFrom(ctx); key != nil {
+		return key.Tenant
+	}
+	return ""
+}
+
+// UnaryServerInterceptor counts answered CheckStatus calls as lookups and attributes status
+// changes to the issuer named in x-issuer metadata. It must come after the API key interceptor
+// for calls to be attributed to tenants
+func UnaryServerInterceptor(m *Meter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		var issuer string
+		if values := md.Get(apikeys.IssuerMetadataKey); len(values) > 0 {
+			issuer = strings.TrimSpace(values[0])
+			ctx = WithIssuer(ctx, issuer)
+		}
+		resp, err := handler(ctx, req)
+		if err == nil && info.FullMethod == ocsp.OCSPService_CheckStatus_FullMethodName {
+			m.Add(tenantFrom(ctx), strings.ToLower(issuer), KindLookup, 1)
+		}
+		return resp, err
+	}
+}
+
+// Store counts the status changes that commit through it as mutations of the tenant and
+// issuer in their context
+type Store struct {
+	storage.Store
+	meter *Meter
+}
+
+// NewStore wraps store so its committed writes are metered
+func NewStore(store storage.Store, meter *Meter) *Store {
+	return &Store{Store: store, meter: meter}
+}
+
+// Upsert writes the update and counts it
+func (s *Store) Upsert(ctx context.Context, update storage.Update) error {
+	if err := s.Store.Upsert(ctx, update); err != nil {
+		return err
+	}
+	s.meter.Add(tenantFrom(ctx), issuerFrom(ctx), KindMutation, 1)
+	return nil
+}
+
+// ApplyBatch writes the updates and counts each of them
+func (s *Store) ApplyBatch(ctx context.Context, updates []storage.Update) error {
+	if err := s.Store.ApplyBatch(ctx, updates); err != nil {
+		return err
+	}
+	s.meter.Add(tenantFrom(ctx), issuerFrom(ctx), KindMutation, int64(len(updates)))
+	return nil
+}
@@ -0,0 +1,308 @@
+// Package metering counts lookups, status changes and presigned responses per tenant and issuer
+package metering
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/archive"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Kinds of metered usage
+const (
+	KindLookup    = "lookup"
+	KindMutation  = "mutation"
+	KindPresigned = "presigned"
+)
+
+// Other is the issuer usage is counted under once a period holds maxKeys distinct tenant,
+// issuer and kind combinations, since request issuers are chosen by clients
+const Other = "other"
+
+const (
+	// maxKeys bounds the combinations counted in one period
+	maxKeys = 10000
+	// keepPeriods is how many closed periods are kept for the usage endpoint and for
+	// retrying their export
+	keepPeriods = 48
+	// closeTimeout bounds the export of the last period at shutdown
+	closeTimeout = 10 * time.Second
+)
+
+// Header is the CSV header of exported periods
+var Header = []string{"period_start", "period_end", "replica", "tenant", "issuer", "kind", "count"}
+
+var exports = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "usage_exports_total",
+	Help:      "Usage periods written to object storage, by result.",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(exports)
+}
+
+// Usage is a count of one kind for a tenant and issuer. Tenant is empty for usage no API key
+// is attributed, such as public RFC 6960 requests and background jobs, and Issuer is empty
+// when the issuer is unknown
+type Usage struct {
+	Tenant string `json:"tenant"`
+	Issuer string `json:"issuer"`
+	Kind   string `json:"kind"`
+	Count  int64  `json:"count"`
+}
+
+// Period is the usage counted from Start until End
+type Period struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Exported is set once the period is written to object storage
+	Exported bool    `json:"exported"`
+	Usage    []Usage `json:"usage"`
+}
+
+// Options configures a Meter
+type Options struct {
+	// Period is the span of each count; periods end on multiples of it
+	Period time.Duration
+	// Replica names this replica in exports
+	Replica string
+	// Prefix is prepended to export object keys
+	Prefix string
+}
+
+type usageKey struct {
+	tenant, issuer, kind string
+}
+
+// Meter counts usage and exports closed periods
+type Meter struct {
+	opts   Options
+	store  archive.ObjectStore
+	logger *logger.Logger
+
+	mu      sync.Mutex
+	start   time.Time
+	current map[usageKey]int64
+	closed  []Period
+}
+
+// New creates a meter writing closed periods to store, or keeping them in memory only when
+// store is nil
+func New(opts Options, store archive.ObjectStore, logger *logger.Logger) *Meter {
+	if opts.Prefix != "" && !strings.HasSuffix(opts.Prefix, "/") {
+		opts.Prefix += "/"
+	}
+	return &Meter{
+		opts:    opts,
+		store:   store,
+		logger:  logger,
+		start:   time.Now().UTC(),
+		current: make(map[usageKey]int64),
+	}
+}
+
+// Replica returns the name the meter exports under
+func (m *Meter) Replica() string {
+	return m.opts.Replica
+}
+
+// Add counts n of kind for tenant and issuer in the current period
+func (m *Meter) Add(tenant, issuer, kind string, n int64) {
+	if n <= 0 {
+		return
+	}
+	key := usageKey{tenant: tenant, issuer: issuer, kind: kind}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollLocked(time.Now())
+	if _, ok := m.current[key]; !ok && len(m.current) >= maxKeys {
+		key.issuer = Other
+	}
+	m.current[key] += n
+}
+
+// ObserveRequest counts an RFC 6960 request as a lookup of its issuer
+func (m *Meter) ObserveRequest(req *ocspreq.Request) {
+	m.Add("", hex.EncodeToString(req.IssuerKeyHash), KindLookup, 1)
+}
+
+// Presigned counts responses signed ahead of requests for the issuer with the hex key hash
+func (m *Meter) Presigned(issuer string, n int) {
+	m.Add("", issuer, KindPresigned, int64(n))
+}
+
+// Periods returns the closed periods still kept, oldest first, followed by the current one
+// so far
+func (m *Meter) Periods() []Period {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollLocked(time.Now())
+	periods := make([]Period, 0, len(m.closed)+1)
+	periods = append(periods, m.closed...)
+	return append(periods, Period{Start: m.start, End: time.Now().UTC(), Usage: usage(m.current)})
+}
+
+// Run closes each period as it ends and exports the closed periods not yet written, retrying
+// failed exports every minute until ctx is cancelled
+func (m *Meter) Run(ctx context.Context) {
+	ticker := time.NewTicker(min(time.Minute, m.opts.Period))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		m.mu.Lock()
+		m.rollLocked(time.Now())
+		m.mu.Unlock()
+		m.export(ctx)
+	}
+}
+
+// Close ends the current period early and exports it with every closed period not yet
+// written, so counts are not lost at shutdown
+func (m *Meter) Close() {
+	now := time.Now().UTC()
+	m.mu.Lock()
+	m.rollLocked(now)
+	if len(m.current) > 0 {
+		m.closeLocked(now)
+		m.start = now
+	}
+	m.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	m.export(ctx)
+}
+
+// rollLocked closes the current period once now is past its end. Periods end on multiples of
+// the period; the first one starts when the meter does, so a restarted replica never writes
+// over the export of the period its predecessor closed at shutdown
+func (m *Meter) rollLocked(now time.Time) {
+	end := m.start.Truncate(m.opts.Period).Add(m.opts.Period)
+	if now.Before(end) {
+		return
+	}
+	m.closeLocked(end)
+	m.start = now.UTC().Truncate(m.opts.Period)
+}
+
+// closeLocked moves the current counts into a closed period ending at end. Periods without
+// usage are not kept
+func (m *Meter) closeLocked(end time.Time) {
+	if len(m.current) > 0 {
+		m.closed = append(m.closed, Period{Start: m.start, End: end, Usage: usage(m.current)})
+		if dropped := len(m.closed) - keepPeriods; dropped > 0 {
+			for _, period := range m.closed[:dropped] {
+				if !period.Exported && m.store != nil {
+					m.logger.Error("Dropping usage period that could not be exported", zap.Time("start", period.Start))
+				}
+			}
+			m.closed = append([]Period(nil), m.closed[dropped:]...)
+		}
+	}
+	m.current = make(map[usageKey]int64)
+}
+
+// export writes the closed periods not yet exported, oldest first, stopping at a failure
+func (m *Meter) export(ctx context.Context) {
+	if m.store == nil {
+		return
+	}
+	m.mu.Lock()
+	var pending []Period
+	for _, period := range m.closed {
+		if !period.Exported {
+			pending = append(pending, period)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, period := range pending {
+		key, body, err := m.encode(period)
+		if err == nil {
+			err = m.store.Put(ctx, key, "text/csv", body)
+		}
+		if err != nil {
+			exports.WithLabelValues("error").Inc()
+			m.logger.Error("Failed to export usage", zap.Time("start", period.Start), zap.Error(err))
+			return
+		}
+		exports.WithLabelValues("success").Inc()
+		m.logger.Info("Exported usage", zap.String("key", key), zap.Int("rows", len(period.Usage)))
+
+		m.mu.Lock()
+		for i := range m.closed {
+			if m.closed[i].Start.Equal(period.Start) {
+				m.closed[i].Exported = true
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// encode lays a period out as <prefix>usage/YYYY/MM/DD/<start>-<replica>.csv
+func (m *Meter) encode(period Period) (string, []byte, error) {
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, m.opts.Replica, []Period{period}); err != nil {
+		return "", nil, err
+	}
+	start := period.Start.UTC()
+	name := fmt.Sprintf("%s-%s.csv", start.Format("20060102T150405Z"), m.opts.Replica)
+	return path.Join(m.opts.Prefix+"usage", start.Format("2006/01/02"), name), buf.Bytes(), nil
+}
+
+// WriteCSV writes periods as CSV rows under Header
+func WriteCSV(w io.Writer, replica string, periods []Period) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(Header); err != nil {
+		return err
+	}
+	for _, period := range periods {
+		start, end := period.Start.UTC().Format(time.RFC3339), period.End.UTC().Format(time.RFC3339)
+		for _, u := range period.Usage {
+			if err := cw.Write([]string{start, end, replica, u.Tenant, u.Issuer, u.Kind, strconv.FormatInt(u.Count, 10)}); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// usage lists counts ordered by tenant, issuer and kind
+func usage(counts map[usageKey]int64) []Usage {
+	list := make([]Usage, 0, len(counts))
+	for key, count := range counts {
+		list = append(list, Usage{Tenant: key.tenant, Issuer: key.issuer, Kind: key.kind, Count: count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		if a.Issuer != b.Issuer {
+			return a.Issuer < b.Issuer
+		}
+		return a.Kind < b.Kind
+	})
+	return list
+}
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+var (
+	oldestNextUpdate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "oldest_next_update_seconds",
+		Help:      "Seconds until the oldest served response of an issuer reaches its nextUpdate (negative once expired).",
+	}, []string{"issuer"})
+	refreshedWithinSLA = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "responses_refreshed_within_sla_ratio",
+		Help:      "Fraction of an issuer's served responses refreshed within the refresh SLA.",
+	}, []string{"issuer"})
+	freshnessLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "freshness_last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful freshness measurement.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(oldestNextUpdate, refreshedWithinSLA, freshnessLastSuccess)
+}
+
+// FreshnessExporter periodically measures response freshness and publishes it as gauges
+// labelled by issuer, the hex SHA-1 of its public key. Statuses record no issuer, so gauges
+// measured on the status table carry an empty issuer
+type FreshnessExporter struct {
+	db         *pgxpool.Pool
+	logger     *logger.Logger
+	interval   time.Duration
+	refreshSLA time.Duration
+	signed     bool
+
+	issuers map[string]bool // issuers with published gauges
+}
+
+// NewFreshnessExporter creates a new freshness exporter
+func NewFreshnessExporter(db *pgxpool.Pool, logger *logger.Logger, interval, refreshSLA time.Duration) *FreshnessExporter {
+	return &FreshnessExporter{
+		db:         db,
+		logger:     logger,
+		interval:   interval,
+		refreshSLA: refreshSLA,
+		issuers:    make(map[string]bool),
+	}
+}
+
+// MeasureSigned measures the precomputed responses in signed_responses, which are what
+// clients are served, instead of the status table
+func (e *FreshnessExporter) MeasureSigned() {
+	e.signed = true
+}
+
+// Run measures freshness on every interval until the context is cancelled
+func (e *FreshnessExporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := e.measure(ctx); err != nil {
+			e.logger.Warn("Failed to measure response freshness", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *FreshnessExporter) measure(ctx context.Context) error {
+	query := `
+		SELECT
+			'',
+			EXTRACT(EPOCH FROM (MIN(next_update) - NOW()))::float8,
+			COUNT(*) FILTER (WHERE this_update >= NOW() - $1::interval),
+			COUNT(*)
+		FROM ocsp_responses
+	`
+	if e.signed {
+		query = `
+			SELECT
+				encode(issuer_key_hash, 'hex'),
+				EXTRACT(EPOCH FROM (MIN(next_update) - NOW()))::float8,
+				COUNT(*) FILTER (WHERE signed_at >= NOW() - $1::interval),
+				COUNT(*)
+			FROM signed_responses
+			GROUP BY issuer_key_hash
+		`
+	}
+
+	rows, err := e.db.Query(ctx, query, e.refreshSLA)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	measured := make(map[string]bool)
+	for rows.Next() {
+		var issuer string
+		var secondsUntil *float64
+		var fresh, total int64
+		if err := rows.Scan(&issuer, &secondsUntil, &fresh, &total); err != nil {
+			return err
+		}
+		measured[issuer] = true
+
+		if secondsUntil != nil {
+			oldestNextUpdate.WithLabelValues(issuer).Set(*secondsUntil)
+		}
+		if total > 0 {
+			refreshedWithinSLA.WithLabelValues(issuer).Set(float64(fresh) / float64(total))
+		} else {
+			refreshedWithinSLA.WithLabelValues(issuer).Set(1)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Issuers with no responses left, such as a retired one, stop being reported
+	for issuer := range e.issuers {
+		if !measured[issuer] {
+			oldestNextUpdate.DeleteLabelValues(issuer)
+			refreshedWithinSLA.DeleteLabelValues(issuer)
+		}
+	}
+	e.issuers = measured
+	freshnessLastSuccess.SetToCurrentTime()
+
+	return nil
+}
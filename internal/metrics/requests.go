@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	grpcRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "grpc_requests_total",
+		Help:      "Number of gRPC requests handled, by method and status code.",
+	}, []string{"method", "code"})
+	grpcLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Latency of gRPC requests, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+var requestTotal, requestErrors atomic.Int64
+
+func init() {
+	Registry.MustRegister(grpcRequests, grpcLatency)
+}
+
+// RequestTotals returns the number of gRPC requests and failed requests since startup
+func RequestTotals() (total, errors int64) {
+	return requestTotal.Load(), requestErrors.Load()
+}
+
+// UnaryServerInterceptor records request counts and latency for every RPC
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+
+		grpcLatency.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+		grpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+
+		requestTotal.Add(1)
+		if err != nil {
+			requestErrors.Add(1)
+		}
+
+		return resp, err
+	}
+}
@@ -0,0 +1,208 @@
+// Package mode switches the service between normal, read-only and maintenance operation at runtime
+package mode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Operating modes
+const (
+	// Normal serves lookups and accepts mutations
+	Normal = "normal"
+	// ReadOnly serves lookups but rejects every status mutation
+	ReadOnly = "read_only"
+	// Maintenance rejects lookups and mutations so the database can be taken down
+	Maintenance = "maintenance"
+)
+
+var (
+	// ErrReadOnly is returned for status mutations outside normal mode
+	ErrReadOnly = errors.New("service is read-only")
+	// ErrMaintenance matches the error returned for status lookups in maintenance mode
+	ErrMaintenance = errors.New("service is in maintenance")
+)
+
+var operatingMode = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metrics.Namespace,
+	Name:      "operating_mode",
+	Help:      "Current operating mode (1 for the active mode, 0 otherwise).",
+}, []string{"mode"})
+
+func init() {
+	metrics.Registry.MustRegister(operatingMode)
+}
+
+// MaintenanceError is returned for lookups in maintenance mode and matches ErrMaintenance;
+// RetryAfter tells clients when to try again, like an OCSP tryLater response
+type MaintenanceError struct {
+	RetryAfter time.Duration
+}
+
+func (e *MaintenanceError) Error() string {
+	return ErrMaintenance.Error()
+}
+
+func (e *MaintenanceError) Is(target error) bool {
+	return target == ErrMaintenance
+}
+
+// Valid reports whether m is a known operating mode
+func Valid(m string) bool {
+	return m == Normal || m == ReadOnly || m == Maintenance
+}
+
+// State is the current mode and why it was entered
+type State struct {
+	Mode   string    `json:"mode"`
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+}
+
+// Switch holds the operating mode of this process
+type Switch struct {
+	retryAfter time.Duration
+	logger     *logger.Logger
+
+	mu    sync.RWMutex
+	state State
+}
+
+// NewSwitch creates a switch starting in the given mode; clients are told to retry after
+// retryAfter while lookups are unavailable
+func NewSwitch(initial string, retryAfter time.Duration, logger *logger.Logger) (*Switch, error) {
+	s := &Switch{retryAfter: retryAfter, logger: logger}
+	if err := s.Set(initial, "initial mode"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Set enters a mode, recording the reason for operators
+func (s *Switch) Set(m, reason string) error {
+	if !Valid(m) {
+		return fmt.Errorf("unknown mode %q (must be %s, %s or %s)", m, Normal, ReadOnly, Maintenance)
+	}
+
+	s.mu.Lock()
+	previous := s.state.Mode
+	s.state = State{Mode: m, Reason: reason, Since: time.Now().UTC()}
+	s.mu.Unlock()
+
+	for _, known := range []string{Normal, ReadOnly, Maintenance} {
+		value := 0.0
+		if known == m {
+			value = 1
+		}
+		operatingMode.WithLabelValues(known).Set(value)
+	}
+	if previous != "" && previous != m {
+		s.logger.Warn("Operating mode changed",
+			zap.String("from", previous),
+			zap.String("to", m),
+			zap.String("reason", reason),
+		)
+	}
+	return nil
+}
+
+// State returns the current mode
+func (s *Switch) State() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.state
+}
+
+// CheckWrite returns ErrReadOnly unless mutations are allowed
+func (s *Switch) CheckWrite() error {
+	if s.State().Mode != Normal {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// CheckRead returns a *MaintenanceError while lookups are suspended
+func (s *Switch) CheckRead() error {
+	if s.State().Mode == Maintenance {
+		return &MaintenanceError{RetryAfter: s.retryAfter}
+	}
+	return nil
+}
+
+// Store wraps a status store so reads and writes honour the operating mode
+type Store struct {
+	storage.Store
+	sw *Switch
+}
+
+// NewStore guards store with the switch
+func NewStore(store storage.Store, sw *Switch) *Store {
+	return &Store{Store: store, sw: sw}
+}
+
+// Get returns ErrMaintenance in maintenance mode
+func (s *Store) Get(ctx context.Context, serial string) (*storage.Record, error) {
+	if err := s.sw.CheckRead(); err != nil {
+		return nil, err
+	}
+	return s.Store.Get(ctx, serial)
+}
+
+// ListRevoked returns ErrMaintenance in maintenance mode
+func (s *Store) ListRevoked(ctx context.Context) ([]storage.Record, error) {
+	if err := s.sw.CheckRead(); err != nil {
+		return nil, err
+	}
+	return s.Store.ListRevoked(ctx)
+}
+
+// Upsert returns ErrReadOnly outside normal mode
+func (s *Store) Upsert(ctx context.Context, update storage.Update) error {
+	if err := s.sw.CheckWrite(); err != nil {
+		return err
+	}
+	return s.Store.Upsert(ctx, update)
+}
+
+// ApplyBatch returns ErrReadOnly outside normal mode
+func (s *Store) ApplyBatch(ctx context.Context, updates []storage.Update) error {
+	if err := s.sw.CheckWrite(); err != nil {
+		return err
+	}
+	return s.Store.ApplyBatch(ctx, updates)
+}
+
+// InsertMissing seeds statuses through the wrapped store, which must be a storage.Seeder,
+// and returns ErrReadOnly outside normal mode
+func (s *Store) InsertMissing(ctx context.Context, updates []storage.Update) (int, error) {
+	if err := s.sw.CheckWrite(); err != nil {
+		return 0, err
+	}
+	seeder, ok := s.Store.(storage.Seeder)
+	if !ok {
+		return 0, fmt.Errorf("store does not support seeding")
+	}
+	return seeder.InsertMissing(ctx, updates)
+}
+
+// ApplyIfNewer applies a replicated status through the wrapped store, which must be a
+// storage.Replica, and returns ErrReadOnly outside normal mode
+func (s *Store) ApplyIfNewer(ctx context.Context, rec storage.Record) (bool, error) {
+	if err := s.sw.CheckWrite(); err != nil {
+		return false, err
+	}
+	replica, ok := s.Store.(storage.Replica)
+	if !ok {
+		return false, fmt.Errorf("store does not support replication")
+	}
+	return replica.ApplyIfNewer(ctx, rec)
+}
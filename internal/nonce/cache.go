@@ -0,0 +1,158 @@
+// Package nonce remembers recently seen OCSP request nonces so a replayed request is refused
+// instead of being answered again
+package nonce
+
+import (
+	"errors"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// ErrReplayed is returned for a nonce already seen within the window
+var ErrReplayed = errors.New("nonce was already used")
+
+type seen struct {
+	nonce string
+	at    time.Time
+}
+
+type entry struct {
+	at     time.Time
+	client string
+}
+
+// queue holds nonces oldest first; entries whose nonce was since forgotten are skipped
+type queue struct {
+	items []seen
+	head  int
+}
+
+func (q *queue) push(s seen) {
+	q.items = append(q.items, s)
+}
+
+func (q *queue) pop() {
+	q.items[q.head] = seen{}
+	q.head++
+	// Reclaim the consumed prefix once it is the larger part of the queue
+	if q.head > len(q.items)/2 {
+		q.items = append(q.items[:0], q.items[q.head:]...)
+		q.head = 0
+	}
+}
+
+// client is the nonces of one source, and how many of them are still remembered
+type client struct {
+	queue
+	live int
+}
+
+// Cache holds nonces for a fixed window. Each client keeps at most maxPerClient of them and
+// the cache at most maxEntries; past either bound the oldest nonce is forgotten early, so a
+// flood of fresh nonces shortens the replay window instead of refusing requests. It is safe
+// for concurrent use
+type Cache struct {
+	window       time.Duration
+	maxEntries   int
+	maxPerClient int
+
+	mu      sync.Mutex
+	seen    map[string]entry
+	all     queue
+	clients map[string]*client
+}
+
+// NewCache creates a cache remembering nonces for window, holding at most maxEntries in all and
+// maxPerClient from any one client
+func NewCache(window time.Duration, maxEntries, maxPerClient int) *Cache {
+	return &Cache{
+		window:       window,
+		maxEntries:   maxEntries,
+		maxPerClient: maxPerClient,
+		seen:         make(map[string]entry),
+		clients:      make(map[string]*client),
+	}
+}
+
+// Check records nonce for source, such as Source of the request's remote address, returning
+// ErrReplayed if any client sent it within the window
+func (c *Cache) Check(source string, nonce []byte) error {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(now)
+
+	key := string(nonce)
+	if _, ok := c.seen[key]; ok {
+		return ErrReplayed
+	}
+
+	if cl := c.clients[source]; cl != nil {
+		for cl.live >= c.maxPerClient && c.popLive(&cl.queue, source) {
+		}
+	}
+	for len(c.seen) >= c.maxEntries && c.popLive(&c.all, "") {
+	}
+	// Forgetting a client's last nonce drops it, so look it up again
+	cl := c.clients[source]
+	if cl == nil {
+		cl = &client{}
+		c.clients[source] = cl
+	}
+
+	c.seen[key] = entry{at: now, client: source}
+	c.all.push(seen{nonce: key, at: now})
+	cl.push(seen{nonce: key, at: now})
+	cl.live++
+	return nil
+}
+
+// Source identifies the client at remote, a request's RemoteAddr, by its address, or its /64
+// for IPv6, so one host cannot claim many sources
+func Source(remote string) string {
+	addrPort, err := netip.ParseAddrPort(remote)
+	if err != nil {
+		return remote
+	}
+	addr := addrPort.Addr().Unmap()
+	if !addr.Is6() {
+		return addr.String()
+	}
+	prefix, _ := addr.Prefix(64)
+	return prefix.String()
+}
+
+// popLive forgets the oldest nonce of q still remembered, reporting whether there was one.
+// source names the client q belongs to, or is empty for the queue of all nonces
+func (c *Cache) popLive(q *queue, source string) bool {
+	for q.head < len(q.items) {
+		s := q.items[q.head]
+		q.pop()
+		if e, ok := c.seen[s.nonce]; ok && e.at.Equal(s.at) && (source == "" || e.client == source) {
+			c.forget(s.nonce, e)
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Cache) forget(nonce string, e entry) {
+	delete(c.seen, nonce)
+	if cl := c.clients[e.client]; cl != nil {
+		if cl.live--; cl.live <= 0 {
+			delete(c.clients, e.client)
+		}
+	}
+}
+
+func (c *Cache) expire(now time.Time) {
+	cutoff := now.Add(-c.window)
+	for c.all.head < len(c.all.items) && !c.all.items[c.all.head].at.After(cutoff) {
+		s := c.all.items[c.all.head]
+		c.all.pop()
+		if e, ok := c.seen[s.nonce]; ok && e.at.Equal(s.at) {
+			c.forget(s.nonce, e)
+		}
+	}
+}
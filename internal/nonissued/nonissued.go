@@ -0,0 +1,48 @@
+// Package nonissued answers lookups for serials the responder has no status for as revoked,
+// the RFC 6960 section 2.2 convention for certificates that were never issued
+package nonissued
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+// validity matches the nextUpdate given to unknown answers
+const validity = 24 * time.Hour
+
+// epoch is the revocation time RFC 6960 prescribes for non-issued certificates
+var epoch = time.Unix(0, 0).UTC()
+
+// Store answers Get with a certificateHold revocation at the epoch when the wrapped store has
+// no status for the serial
+type Store struct {
+	storage.Store
+}
+
+// NewStore wraps store so missing serials read as revoked
+func NewStore(store storage.Store) *Store {
+	return &Store{Store: store}
+}
+
+// Get returns the stored status, or a non-issued revocation for a missing serial
+func (s *Store) Get(ctx context.Context, serial string) (*storage.Record, error) {
+	rec, err := s.Store.Get(ctx, serial)
+	if !errors.Is(err, storage.ErrNotFound) {
+		return rec, err
+	}
+
+	now := time.Now().UTC()
+	revokedAt := epoch
+	return &storage.Record{
+		Serial:           serial,
+		Status:           storage.StatusRevoked,
+		ThisUpdate:       now,
+		NextUpdate:       now.Add(validity),
+		RevokedAt:        &revokedAt,
+		RevocationReason: revocation.ReasonCertificateHold,
+	}, nil
+}
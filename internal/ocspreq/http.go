@@ -0,0 +1,90 @@
+package ocspreq
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Reader extracts the DER request from RFC 6960 HTTP requests, POST bodies or base64 GET paths
+// below a prefix, into pooled buffers
+type Reader struct {
+	prefix  string
+	limits  Limits
+	buffers sync.Pool
+}
+
+// NewReader creates a reader for requests below prefix
+func NewReader(prefix string, limits Limits) *Reader {
+	return &Reader{prefix: strings.TrimSuffix(prefix, "/"), limits: limits}
+}
+
+// Buffer is a pooled buffer with room for the largest request and its base64 form
+type Buffer struct {
+	b []byte
+}
+
+// Acquire returns a buffer for Read; Release it once the request parsed from it is done with
+func (r *Reader) Acquire() *Buffer {
+	if buf, ok := r.buffers.Get().(*Buffer); ok {
+		return buf
+	}
+	limit := r.limits.BodyLimit()
+	return &Buffer{b: make([]byte, limit+1+base64.StdEncoding.EncodedLen(limit))}
+}
+
+// Release returns a buffer to the pool
+func (r *Reader) Release(buf *Buffer) {
+	r.buffers.Put(buf)
+}
+
+// Read returns the DER request carried by a POST or GET request, decoded into buf. It
+// returns ErrTooLarge or ErrMalformed when the request cannot be extracted
+func (r *Reader) Read(req *http.Request, buf *Buffer) ([]byte, error) {
+	limit := r.limits.BodyLimit()
+	if req.Method == http.MethodPost {
+		return readBody(req.Body, buf.b[:limit+1])
+	}
+
+	encoded := strings.TrimPrefix(strings.TrimPrefix(req.URL.EscapedPath(), r.prefix), "/")
+	// Every byte may be percent-escaped, tripling the length of the base64 form
+	if len(encoded) > 3*base64.StdEncoding.EncodedLen(limit) {
+		return nil, ErrTooLarge
+	}
+	unescaped, err := url.PathUnescape(encoded)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if len(unescaped) > base64.StdEncoding.EncodedLen(limit) {
+		return nil, ErrTooLarge
+	}
+	// The base64 form is copied behind the room for the decoded request, which base64
+	// cannot outgrow
+	src := buf.b[limit+1:]
+	src = src[:copy(src, unescaped)]
+	n, err := base64.StdEncoding.Decode(buf.b[:limit+1], src)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return buf.b[:n], nil
+}
+
+// readBody reads into buf until EOF or until buf is full. A full buffer means the body is
+// longer than the limit, which Parse rejects
+func readBody(body io.Reader, buf []byte) ([]byte, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := body.Read(buf[n:])
+		n += m
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ErrMalformed
+		}
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,105 @@
+package ocspreq
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// certIDAlgorithms are the CertID hash algorithms a request may use
+var certIDAlgorithms = []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512}
+
+type certIDHashes struct {
+	hash      crypto.Hash
+	name, key string
+}
+
+// Issuer holds an issuer's CertID name and key hashes under every supported algorithm, so
+// matching a request against it hashes and allocates nothing. Earlier certificates of the
+// issuer added with AddPrevious let requests built from them match across a key rollover
+type Issuer struct {
+	Certificate *x509.Certificate
+	hashes      []certIDHashes
+	previous    []*Issuer
+}
+
+// NewIssuer precomputes the CertID hashes of cert
+func NewIssuer(cert *x509.Certificate) (*Issuer, error) {
+	issuer := &Issuer{Certificate: cert}
+	for _, h := range certIDAlgorithms {
+		if !h.Available() {
+			continue
+		}
+		key, err := IssuerKeyHash(cert, h)
+		if err != nil {
+			return nil, err
+		}
+		name := h.New()
+		name.Write(cert.RawSubject)
+		issuer.hashes = append(issuer.hashes, certIDHashes{hash: h, name: string(name.Sum(nil)), key: string(key)})
+	}
+	return issuer, nil
+}
+
+// AddPrevious registers an earlier certificate of the issuer: one with the same subject and a
+// key it has rolled away from
+func (i *Issuer) AddPrevious(cert *x509.Certificate) error {
+	if !bytes.Equal(cert.RawSubject, i.Certificate.RawSubject) {
+		return errors.New("previous issuer certificate has a different subject")
+	}
+	previous, err := NewIssuer(cert)
+	if err != nil {
+		return err
+	}
+	for _, known := range append([]*Issuer{i}, i.previous...) {
+		if known.hashes[0].key == previous.hashes[0].key {
+			return errors.New("previous issuer certificate has a key already registered")
+		}
+	}
+	i.previous = append(i.previous, previous)
+	return nil
+}
+
+// Match returns the certificate a request's CertID names: Certificate, one added with
+// AddPrevious, or nil for another issuer
+func (i *Issuer) Match(req *ocsp.Request) *x509.Certificate {
+	if i.Matches(req) {
+		return i.Certificate
+	}
+	for _, previous := range i.previous {
+		if previous.Matches(req) {
+			return previous.Certificate
+		}
+	}
+	return nil
+}
+
+// Matches reports whether a request's CertID names Certificate, in the hash it was built with
+func (i *Issuer) Matches(req *ocsp.Request) bool {
+	for _, h := range i.hashes {
+		if h.hash == req.HashAlgorithm {
+			return h.key == string(req.IssuerKeyHash) && h.name == string(req.IssuerNameHash)
+		}
+	}
+	return false
+}
+
+// IssuerKeyHash hashes the issuer's subject public key bit string as OCSP CertIDs do
+func IssuerKeyHash(issuer *x509.Certificate, h crypto.Hash) ([]byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, fmt.Errorf("failed to parse issuer public key: %w", err)
+	}
+	hasher := h.New()
+	hasher.Write(spki.PublicKey.RightAlign())
+	return hasher.Sum(nil), nil
+}
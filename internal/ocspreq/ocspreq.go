@@ -0,0 +1,284 @@
+// Package ocspreq parses RFC 6960 OCSP requests within explicit resource limits, so hostile
+// DER is rejected before it can make the responder allocate or work without bound
+package ocspreq
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/cryptobyte"
+	casn1 "golang.org/x/crypto/cryptobyte/asn1"
+	"golang.org/x/crypto/ocsp"
+)
+
+// Rejections; every one is answered with malformedRequest
+var (
+	ErrMalformed         = errors.New("malformed OCSP request")
+	ErrTooLarge          = errors.New("OCSP request exceeds the size limit")
+	ErrTooManyCertIDs    = errors.New("OCSP request has too many CertIDs")
+	ErrExtensionTooLarge = errors.New("OCSP request extensions exceed the limits")
+)
+
+// Reason names a parse error for metrics
+func Reason(err error) string {
+	switch {
+	case errors.Is(err, ErrTooLarge):
+		return "too_large"
+	case errors.Is(err, ErrTooManyCertIDs):
+		return "too_many_cert_ids"
+	case errors.Is(err, ErrExtensionTooLarge):
+		return "extension_too_large"
+	default:
+		return "malformed"
+	}
+}
+
+// maxDERBytes caps a request even when MaxBodyBytes is unlimited; real requests stay well
+// under 1 KiB unless signed
+const maxDERBytes = 64 << 10
+
+// maxSerialBits bounds the CertID serial; RFC 5280 limits serials to 20 octets
+const maxSerialBits = 20 * 8
+
+// Limits bounds what a request may contain; zero fields are unlimited
+type Limits struct {
+	// MaxBodyBytes bounds the DER request; it is capped at 64 KiB even when unlimited
+	MaxBodyBytes int
+	// MaxCertIDs bounds the requestList; only the first CertID is answered
+	MaxCertIDs int
+	// MaxExtensions bounds the extensions of the request and of each CertID
+	MaxExtensions int
+	// MaxExtensionBytes bounds each extension value
+	MaxExtensionBytes int
+}
+
+// Request is a parsed request: the first CertID and the request nonce, if any. The hashes
+// and nonce alias the parsed DER, which must not be modified while the request is in use
+type Request struct {
+	ocsp.Request
+	// Nonce is the extnValue of the id-pkix-ocsp-nonce request extension
+	Nonce []byte
+
+	serial big.Int // backs SerialNumber, so parsing allocates once
+}
+
+// Observer sees every well-formed request a responder receives, before it is answered
+type Observer interface {
+	ObserveRequest(req *Request)
+}
+
+// LookupObserver is told of every request a responder answers for one of its issuers, from
+// source, and whether it had a status for serial
+type LookupObserver interface {
+	ObserveServed(ctx context.Context, source, serial string, known bool)
+}
+
+// Observers shows every request to each of its observers in turn
+type Observers []Observer
+
+// ObserveRequest passes req to every observer
+func (o Observers) ObserveRequest(req *Request) {
+	for _, observer := range o {
+		observer.ObserveRequest(req)
+	}
+}
+
+// BodyLimit returns the most bytes of DER a request may have
+func (l Limits) BodyLimit() int {
+	if l.MaxBodyBytes <= 0 || l.MaxBodyBytes > maxDERBytes {
+		return maxDERBytes
+	}
+	return l.MaxBodyBytes
+}
+
+var (
+	// OIDs are compared in their DER content encoding, so matching one allocates nothing
+	oidNonce = mustOIDBytes(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2})
+
+	hashOIDs = []struct {
+		oid  []byte
+		hash crypto.Hash
+	}{
+		{mustOIDBytes(asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}), crypto.SHA1},
+		{mustOIDBytes(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}), crypto.SHA256},
+		{mustOIDBytes(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}), crypto.SHA384},
+		{mustOIDBytes(asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}), crypto.SHA512},
+	}
+
+	tagVersion           = casn1.Tag(0).ContextSpecific().Constructed()
+	tagRequestorName     = casn1.Tag(1).ContextSpecific().Constructed()
+	tagRequestExtensions = casn1.Tag(2).ContextSpecific().Constructed()
+	tagSingleExtensions  = casn1.Tag(0).ContextSpecific().Constructed()
+	tagSignature         = casn1.Tag(0).ContextSpecific().Constructed()
+)
+
+// mustOIDBytes returns the content octets of an OID's DER encoding
+func mustOIDBytes(oid asn1.ObjectIdentifier) []byte {
+	der, err := asn1.Marshal(oid)
+	if err != nil {
+		panic(err)
+	}
+	var content cryptobyte.String
+	input := cryptobyte.String(der)
+	if !input.ReadASN1(&content, casn1.OBJECT_IDENTIFIER) {
+		panic("invalid OID encoding")
+	}
+	return content
+}
+
+func malformed(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrMalformed, fmt.Sprintf(format, args...))
+}
+
+// Parse parses a DER OCSPRequest within limits, without recursion. A request signature is ignored
+func Parse(der []byte, limits Limits) (*Request, error) {
+	if len(der) > limits.BodyLimit() {
+		return nil, ErrTooLarge
+	}
+
+	input := cryptobyte.String(der)
+	var ocspRequest, tbs cryptobyte.String
+	if !input.ReadASN1(&ocspRequest, casn1.SEQUENCE) || !input.Empty() {
+		return nil, malformed("not a DER OCSPRequest")
+	}
+	if !ocspRequest.ReadASN1(&tbs, casn1.SEQUENCE) {
+		return nil, malformed("invalid tbsRequest")
+	}
+	if !ocspRequest.SkipOptionalASN1(tagSignature) || !ocspRequest.Empty() {
+		return nil, malformed("trailing data after tbsRequest")
+	}
+
+	var version cryptobyte.String
+	var hasVersion bool
+	if !tbs.ReadOptionalASN1(&version, &hasVersion, tagVersion) {
+		return nil, malformed("invalid version")
+	}
+	if hasVersion {
+		var v int64
+		if !version.ReadASN1Integer(&v) || v != 0 {
+			return nil, malformed("unsupported version")
+		}
+	}
+	if !tbs.SkipOptionalASN1(tagRequestorName) {
+		return nil, malformed("invalid requestorName")
+	}
+
+	var list cryptobyte.String
+	if !tbs.ReadASN1(&list, casn1.SEQUENCE) {
+		return nil, malformed("invalid requestList")
+	}
+	req := &Request{}
+	for n := 1; !list.Empty(); n++ {
+		if limits.MaxCertIDs > 0 && n > limits.MaxCertIDs {
+			return nil, ErrTooManyCertIDs
+		}
+		var single, certID cryptobyte.String
+		if !list.ReadASN1(&single, casn1.SEQUENCE) || !single.ReadASN1(&certID, casn1.SEQUENCE) {
+			return nil, malformed("invalid Request %d", n)
+		}
+		if n == 1 {
+			if err := parseCertID(certID, req); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := readExtensions(&single, tagSingleExtensions, limits); err != nil {
+			return nil, err
+		}
+		if !single.Empty() {
+			return nil, malformed("trailing data in Request %d", n)
+		}
+	}
+	if req.SerialNumber == nil {
+		return nil, malformed("empty requestList")
+	}
+
+	nonce, err := readExtensions(&tbs, tagRequestExtensions, limits)
+	if err != nil {
+		return nil, err
+	}
+	req.Nonce = nonce
+	if !tbs.Empty() {
+		return nil, malformed("trailing data in tbsRequest")
+	}
+	return req, nil
+}
+
+func parseCertID(certID cryptobyte.String, req *Request) error {
+	var algorithm, oid cryptobyte.String
+	if !certID.ReadASN1(&algorithm, casn1.SEQUENCE) || !algorithm.ReadASN1(&oid, casn1.OBJECT_IDENTIFIER) {
+		return malformed("invalid hashAlgorithm")
+	}
+	var hash crypto.Hash
+	for _, h := range hashOIDs {
+		if bytes.Equal(oid, h.oid) {
+			hash = h.hash
+			break
+		}
+	}
+	if hash == 0 {
+		return malformed("unsupported hash algorithm")
+	}
+
+	var nameHash, keyHash cryptobyte.String
+	if !certID.ReadASN1(&nameHash, casn1.OCTET_STRING) ||
+		!certID.ReadASN1(&keyHash, casn1.OCTET_STRING) ||
+		!certID.ReadASN1Integer(&req.serial) ||
+		!certID.Empty() {
+		return malformed("invalid CertID")
+	}
+	if len(nameHash) != hash.Size() || len(keyHash) != hash.Size() {
+		return malformed("CertID hashes do not match %s", hash)
+	}
+	if req.serial.BitLen() > maxSerialBits {
+		return malformed("serial number longer than 20 octets")
+	}
+
+	req.HashAlgorithm = hash
+	req.IssuerNameHash = []byte(nameHash)
+	req.IssuerKeyHash = []byte(keyHash)
+	req.SerialNumber = &req.serial
+	return nil
+}
+
+// readExtensions reads an optional explicitly tagged Extensions field, enforcing the limits,
+// and returns the nonce extension's value if present
+func readExtensions(s *cryptobyte.String, tag casn1.Tag, limits Limits) ([]byte, error) {
+	var wrapper, extensions cryptobyte.String
+	var present bool
+	if !s.ReadOptionalASN1(&wrapper, &present, tag) {
+		return nil, malformed("invalid extensions")
+	}
+	if !present {
+		return nil, nil
+	}
+	if !wrapper.ReadASN1(&extensions, casn1.SEQUENCE) || !wrapper.Empty() {
+		return nil, malformed("invalid extensions")
+	}
+
+	var nonce []byte
+	for n := 1; !extensions.Empty(); n++ {
+		if limits.MaxExtensions > 0 && n > limits.MaxExtensions {
+			return nil, ErrExtensionTooLarge
+		}
+		var extension, oid, value cryptobyte.String
+		if !extensions.ReadASN1(&extension, casn1.SEQUENCE) ||
+			!extension.ReadASN1(&oid, casn1.OBJECT_IDENTIFIER) ||
+			!extension.SkipOptionalASN1(casn1.BOOLEAN) ||
+			!extension.ReadASN1(&value, casn1.OCTET_STRING) ||
+			!extension.Empty() {
+			return nil, malformed("invalid extension %d", n)
+		}
+		if limits.MaxExtensionBytes > 0 && len(value) > limits.MaxExtensionBytes {
+			return nil, ErrExtensionTooLarge
+		}
+		if bytes.Equal(oid, oidNonce) {
+			nonce = []byte(value)
+		}
+	}
+	return nonce, nil
+}
@@ -0,0 +1,269 @@
+package ocspreq
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gigvault/ocsp/pkg/testsupport"
+	"golang.org/x/crypto/cryptobyte"
+	casn1 "golang.org/x/crypto/cryptobyte/asn1"
+	"golang.org/x/crypto/ocsp"
+)
+
+var testLimits = Limits{MaxBodyBytes: 4096, MaxCertIDs: 4, MaxExtensions: 4, MaxExtensionBytes: 64}
+
+// spec describes a request for build; the zero value is a valid single-CertID request
+type spec struct {
+	serial     []byte // INTEGER content octets
+	hashOID    []byte
+	certIDs    int
+	nonce      []byte
+	extensions int // extra request extensions besides the nonce
+	inTBS      []byte
+	inRequest  []byte
+	after      []byte
+}
+
+// build encodes s as DER by hand, so malformed variants can be produced that no encoder
+// would emit
+func build(s spec) []byte {
+	if s.serial == nil {
+		s.serial = []byte{0x01, 0x02, 0x03}
+	}
+	if s.hashOID == nil {
+		s.hashOID = hashOIDs[0].oid
+	}
+	if s.certIDs == 0 {
+		s.certIDs = 1
+	}
+	hash := bytes.Repeat([]byte{0xaa}, crypto.SHA1.Size())
+
+	var b cryptobyte.Builder
+	b.AddASN1(casn1.SEQUENCE, func(req *cryptobyte.Builder) {
+		req.AddASN1(casn1.SEQUENCE, func(tbs *cryptobyte.Builder) {
+			tbs.AddASN1(casn1.SEQUENCE, func(list *cryptobyte.Builder) {
+				for i := 0; i < s.certIDs; i++ {
+					list.AddASN1(casn1.SEQUENCE, func(single *cryptobyte.Builder) {
+						single.AddASN1(casn1.SEQUENCE, func(certID *cryptobyte.Builder) {
+							certID.AddASN1(casn1.SEQUENCE, func(algorithm *cryptobyte.Builder) {
+								algorithm.AddASN1(casn1.OBJECT_IDENTIFIER, func(oid *cryptobyte.Builder) {
+									oid.AddBytes(s.hashOID)
+								})
+								algorithm.AddASN1NULL()
+							})
+							certID.AddASN1OctetString(hash)
+							certID.AddASN1OctetString(hash)
+							certID.AddASN1(casn1.INTEGER, func(serial *cryptobyte.Builder) {
+								serial.AddBytes(s.serial)
+							})
+						})
+					})
+				}
+			})
+			if s.nonce != nil || s.extensions > 0 {
+				tbs.AddASN1(tagRequestExtensions, func(wrapper *cryptobyte.Builder) {
+					wrapper.AddASN1(casn1.SEQUENCE, func(extensions *cryptobyte.Builder) {
+						for i := 0; i < s.extensions; i++ {
+							addExtension(extensions, []byte{0x2a, 0x03, byte(i)}, []byte{0x05, 0x00})
+						}
+						if s.nonce != nil {
+							addExtension(extensions, oidNonce, s.nonce)
+						}
+					})
+				})
+			}
+			tbs.AddBytes(s.inTBS)
+		})
+		req.AddBytes(s.inRequest)
+	})
+	b.AddBytes(s.after)
+	return b.BytesOrPanic()
+}
+
+func addExtension(b *cryptobyte.Builder, oid, value []byte) {
+	b.AddASN1(casn1.SEQUENCE, func(extension *cryptobyte.Builder) {
+		extension.AddASN1(casn1.OBJECT_IDENTIFIER, func(o *cryptobyte.Builder) {
+			o.AddBytes(oid)
+		})
+		extension.AddASN1OctetString(value)
+	})
+}
+
+// created returns a request made by x/crypto/ocsp for a certificate of the test issuer
+func created(tb testing.TB, hash crypto.Hash) []byte {
+	tb.Helper()
+	issuer, err := testsupport.NewIssuer(testsupport.NewSigner())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	leaf := &x509.Certificate{SerialNumber: new(big.Int).Lsh(big.NewInt(1), 159)}
+	req, err := ocsp.CreateRequest(leaf, issuer, &ocsp.RequestOptions{Hash: hash})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return req
+}
+
+func TestParse(t *testing.T) {
+	twentyOctets := append([]byte{0x00}, bytes.Repeat([]byte{0xff}, 20)...)
+	tests := []struct {
+		name   string
+		der    []byte
+		err    error
+		serial *big.Int
+		nonce  []byte
+	}{
+		{name: "minimal", der: build(spec{}), serial: big.NewInt(0x010203)},
+		{name: "nonce", der: build(spec{nonce: []byte("0123456789abcdef")}), serial: big.NewInt(0x010203), nonce: []byte("0123456789abcdef")},
+		{name: "twenty octet serial", der: build(spec{serial: twentyOctets}), serial: new(big.Int).SetBytes(twentyOctets)},
+		{name: "over-long serial", der: build(spec{serial: append([]byte{0x01}, make([]byte, 20)...)}), err: ErrMalformed},
+		{name: "non-minimal serial", der: build(spec{serial: []byte{0x00, 0x01}}), err: ErrMalformed},
+		{name: "unsupported hash", der: build(spec{hashOID: []byte{0x2a, 0x03, 0x04}}), err: ErrMalformed},
+		{name: "trailing data after request", der: build(spec{after: []byte{0x00}}), err: ErrMalformed},
+		{name: "trailing data after tbsRequest", der: build(spec{inRequest: []byte{0x05, 0x00}}), err: ErrMalformed},
+		{name: "trailing data in tbsRequest", der: build(spec{inTBS: []byte{0x05, 0x00}}), err: ErrMalformed},
+		{name: "too many CertIDs", der: build(spec{certIDs: 5}), err: ErrTooManyCertIDs},
+		{name: "too many extensions", der: build(spec{extensions: 5}), err: ErrExtensionTooLarge},
+		{name: "extension too large", der: build(spec{nonce: make([]byte, 65)}), err: ErrExtensionTooLarge},
+		{name: "body too large", der: build(spec{nonce: make([]byte, 64), inTBS: make([]byte, 4096)}), err: ErrTooLarge},
+		{name: "truncated", der: build(spec{})[:10], err: ErrMalformed},
+		{name: "empty", der: nil, err: ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := Parse(tt.der, testLimits)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Parse() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if req.SerialNumber.Cmp(tt.serial) != 0 {
+				t.Errorf("serial = %x, want %x", req.SerialNumber, tt.serial)
+			}
+			if !bytes.Equal(req.Nonce, tt.nonce) {
+				t.Errorf("nonce = %x, want %x", req.Nonce, tt.nonce)
+			}
+		})
+	}
+}
+
+func TestParseMatchesCrypto(t *testing.T) {
+	for _, hash := range []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		der := created(t, hash)
+		want, err := ocsp.ParseRequest(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := Parse(der, testLimits)
+		if err != nil {
+			t.Fatalf("%s: Parse() error = %v", hash, err)
+		}
+		if got.HashAlgorithm != want.HashAlgorithm || got.SerialNumber.Cmp(want.SerialNumber) != 0 ||
+			!bytes.Equal(got.IssuerNameHash, want.IssuerNameHash) || !bytes.Equal(got.IssuerKeyHash, want.IssuerKeyHash) {
+			t.Errorf("%s: Parse() = %+v, want %+v", hash, got.Request, *want)
+		}
+	}
+}
+
+func TestReaderGet(t *testing.T) {
+	der := build(spec{nonce: []byte("nonce")})
+	reader := NewReader("/ocsp", testLimits)
+	buf := reader.Acquire()
+	defer reader.Release(buf)
+
+	got, err := reader.Read(httptest.NewRequest("GET", "/ocsp/"+base64.StdEncoding.EncodeToString(der), nil), buf)
+	if err != nil || !bytes.Equal(got, der) {
+		t.Fatalf("Read() = %x, %v, want %x", got, err, der)
+	}
+	long := base64.StdEncoding.EncodeToString(make([]byte, testLimits.MaxBodyBytes+3))
+	if _, err := reader.Read(httptest.NewRequest("GET", "/ocsp/"+long, nil), buf); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Read() error = %v, want %v", err, ErrTooLarge)
+	}
+}
+
+// FuzzParse checks that Parse never panics, that anything it accepts is within the limits,
+// and that it agrees with x/crypto/ocsp wherever both accept a request
+func FuzzParse(f *testing.F) {
+	f.Add(build(spec{}))
+	f.Add(build(spec{nonce: []byte("0123456789abcdef"), certIDs: 2}))
+	f.Add(created(f, crypto.SHA256))
+
+	f.Fuzz(func(t *testing.T, der []byte) {
+		req, err := Parse(der, testLimits)
+		if err != nil {
+			if !errors.Is(err, ErrMalformed) && !errors.Is(err, ErrTooLarge) &&
+				!errors.Is(err, ErrTooManyCertIDs) && !errors.Is(err, ErrExtensionTooLarge) {
+				t.Fatalf("Parse() returned unexpected error %v", err)
+			}
+			return
+		}
+		if len(der) > testLimits.BodyLimit() {
+			t.Fatalf("accepted %d bytes over the %d byte limit", len(der), testLimits.BodyLimit())
+		}
+		if req.SerialNumber.BitLen() > maxSerialBits {
+			t.Fatalf("accepted a %d bit serial", req.SerialNumber.BitLen())
+		}
+		if size := req.HashAlgorithm.Size(); len(req.IssuerNameHash) != size || len(req.IssuerKeyHash) != size {
+			t.Fatalf("accepted CertID hashes that do not match %s", req.HashAlgorithm)
+		}
+		if len(req.Nonce) > testLimits.MaxExtensionBytes {
+			t.Fatalf("accepted a %d byte nonce", len(req.Nonce))
+		}
+
+		want, err := ocsp.ParseRequest(der)
+		if err != nil {
+			return
+		}
+		if req.HashAlgorithm != want.HashAlgorithm || req.SerialNumber.Cmp(want.SerialNumber) != 0 ||
+			!bytes.Equal(req.IssuerNameHash, want.IssuerNameHash) || !bytes.Equal(req.IssuerKeyHash, want.IssuerKeyHash) {
+			t.Fatalf("Parse() = %+v, x/crypto/ocsp parsed %+v", req.Request, *want)
+		}
+	})
+}
+
+// FuzzReaderGet checks that a GET path never decodes to more than the body limit
+func FuzzReaderGet(f *testing.F) {
+	f.Add("/ocsp/" + base64.StdEncoding.EncodeToString(build(spec{})))
+	f.Add("/ocsp/MEMwQTA%2FMD0wOzAJBgUrDgMCGgUABBQ%3D")
+	f.Add("/ocsp/%zz")
+
+	reader := NewReader("/ocsp", testLimits)
+	f.Fuzz(func(t *testing.T, path string) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.URL.RawPath, req.URL.Path = path, path
+		buf := reader.Acquire()
+		defer reader.Release(buf)
+
+		der, err := reader.Read(req, buf)
+		if err != nil {
+			if !errors.Is(err, ErrMalformed) && !errors.Is(err, ErrTooLarge) {
+				t.Fatalf("Read() returned unexpected error %v", err)
+			}
+			return
+		}
+		if len(der) > testLimits.BodyLimit() {
+			t.Fatalf("decoded %d bytes over the %d byte limit", len(der), testLimits.BodyLimit())
+		}
+	})
+}
+
+// BenchmarkParse parses a request made by x/crypto/ocsp
+func BenchmarkParse(b *testing.B) {
+	der := created(b, crypto.SHA1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(der, testLimits); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package precomputed
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/crl"
+	"github.com/gigvault/ocsp/pkg/ocspext"
+	"golang.org/x/crypto/ocsp"
+)
+
+// oidCRLID is id-pkix-ocsp-crl, RFC 6960 section 4.4.2
+var oidCRLID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 3}
+
+// crlID is the CrlID syntax; fields left at their zero value are omitted
+type crlID struct {
+	URL    string    `asn1:"ia5,explicit,tag:0,optional"`
+	Number *big.Int  `asn1:"explicit,tag:1,optional"`
+	Time   time.Time `asn1:"generalized,explicit,tag:2,optional"`
+}
+
+// CRLSource returns the CRL covering a serial and the URL it is published at, which may be
+// empty. The CRL is nil until one has been generated
+type CRLSource func(serial *big.Int) (*crl.CRL, string)
+
+// CRLID returns a builder adding a CrlID single extension to the responses of revoked
+// certificates, naming the CRL that lists them when the response is signed
+func CRLID(source CRLSource) ocspext.Builder {
+	return ocspext.BuilderFunc(func(ctx context.Context, resp *ocspext.Response) (ocspext.Extensions, error) {
+		if resp.Status != ocsp.Revoked {
+			return ocspext.Extensions{}, nil
+		}
+		current, url := source(resp.SerialNumber)
+		if current == nil {
+			return ocspext.Extensions{}, nil
+		}
+		value, err := asn1.Marshal(crlID{URL: url, Number: current.Number, Time: current.ThisUpdate.UTC()})
+		if err != nil {
+			return ocspext.Extensions{}, err
+		}
+		return ocspext.Extensions{Single: []pkix.Extension{{Id: oidCRLID, Value: value}}}, nil
+	})
+}
@@ -0,0 +1,70 @@
+package precomputed
+
+import (
+	"context"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/crl"
+	"github.com/gigvault/ocsp/internal/storage"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestCRLIDExtension(t *testing.T) {
+	signer := testSigner(t)
+	crlTime := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	current := &crl.CRL{Number: big.NewInt(42), ThisUpdate: crlTime}
+	signer.Extensions = CRLID(func(serial *big.Int) (*crl.CRL, string) {
+		return current, "http://crl.example.com/ca.crl"
+	})
+	now := time.Now()
+	revokedAt := now.Add(-time.Hour)
+
+	signed, err := signer.Sign(context.Background(), storage.Record{
+		Serial:           "0a1b",
+		Status:           storage.StatusRevoked,
+		ThisUpdate:       now,
+		RevokedAt:        &revokedAt,
+		RevocationReason: "keyCompromise",
+	}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ocsp.ParseResponse(signed.DER, signer.Issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []crlID
+	for _, ext := range resp.Extensions {
+		if !ext.Id.Equal(oidCRLID) {
+			continue
+		}
+		var id crlID
+		if rest, err := asn1.Unmarshal(ext.Value, &id); err != nil || len(rest) > 0 {
+			t.Fatalf("invalid CrlID %x: %v", ext.Value, err)
+		}
+		found = append(found, id)
+	}
+	if len(found) != 1 {
+		t.Fatalf("response carries %d CrlID extensions, want 1", len(found))
+	}
+	if id := found[0]; id.URL != "http://crl.example.com/ca.crl" || id.Number.Cmp(big.NewInt(42)) != 0 || !id.Time.Equal(crlTime) {
+		t.Errorf("CrlID = %+v", id)
+	}
+
+	// Good certificates are on no CRL
+	signed, err = signer.Sign(context.Background(), storage.Record{Serial: "0a1c", Status: storage.StatusGood, ThisUpdate: now}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = ocsp.ParseResponse(signed.DER, signer.Issuer); err != nil {
+		t.Fatal(err)
+	}
+	for _, ext := range resp.Extensions {
+		if ext.Id.Equal(oidCRLID) {
+			t.Error("good response carries a CrlID extension")
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package precomputed
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/anomaly"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+)
+
+// Drift kinds, used in metric labels
+const (
+	DriftUnparseable = "unparseable"
+	DriftSerial      = "serial"
+	DriftStatus      = "status"
+	DriftReason      = "reason"
+	DriftTime        = "revocation_time"
+)
+
+// reasonDrift labels responses re-signed because they drifted
+const reasonDrift = "drift"
+
+// maxDriftReported bounds the drifted serials spelled out in an alert message
+const maxDriftReported = 5
+
+var (
+	driftChecked = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "precomputed_drift_checked_total",
+		Help:      "Stored precomputed responses compared with their status by the drift verifier.",
+	})
+	driftFound = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "precomputed_drift_total",
+		Help:      "Stored precomputed responses that disagreed with their status, by kind.",
+	}, []string{"kind"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(driftChecked, driftFound)
+}
+
+// DriftOptions configures a DriftVerifier
+type DriftOptions struct {
+	// SampleSize stored responses are checked on every pass, from a random serial on
+	SampleSize int
+	// RevokedSampleSize responses of the statuses revoked most recently within Lookback are
+	// checked on every pass too
+	RevokedSampleSize int
+	Lookback          time.Duration
+	// Grace is how long a response may lag a status change before it is compared, leaving the
+	// refresher time to re-sign it
+	Grace time.Duration
+	// Correct re-signs drifted responses instead of only reporting them
+	Correct bool
+}
+
+// Drift is a stored response that disagrees with its status
+type Drift struct {
+	Serial string
+	Kind   string
+	Stored string
+	Status string
+}
+
+// DriftVerifier compares a sample of stored responses with the statuses they answer for, in
+// case a response escaped the refresher: a missed invalidation, a row written by hand or a
+// status changed without its this_update. Drift fires a hard alert and, with Correct, is
+// re-signed through the refresher
+type DriftVerifier struct {
+	refresher *Refresher
+	opts      DriftOptions
+	hooks     []anomaly.Hook
+	logger    *logger.Logger
+}
+
+// NewDriftVerifier creates a verifier of refresher's table, re-signing with refresher
+func NewDriftVerifier(refresher *Refresher, opts DriftOptions, logger *logger.Logger, hooks ...anomaly.Hook) *DriftVerifier {
+	return &DriftVerifier{refresher: refresher, opts: opts, hooks: hooks, logger: logger}
+}
+
+// Verify checks one sample and returns the drift found; with Correct it is re-signed
+func (v *DriftVerifier) Verify(ctx context.Context) ([]Drift, error) {
+	now := time.Now()
+	var sample []Stored
+	if v.opts.RevokedSampleSize > 0 {
+		revoked, err := v.refresher.table.RecentlyRevoked(ctx, now.Add(-v.opts.Lookback), v.opts.RevokedSampleSize)
+		if err != nil {
+			return nil, fmt.Errorf("sample recent revocations: %w", err)
+		}
+		sample = append(sample, revoked...)
+	}
+	if v.opts.SampleSize > 0 {
+		random, err := v.refresher.table.Sample(ctx, randomSerial(), v.opts.SampleSize)
+		if err != nil {
+			return nil, fmt.Errorf("sample stored responses: %w", err)
+		}
+		sample = append(sample, random...)
+	}
+
+	var drift []Drift
+	var records []storage.Record
+	seen := make(map[string]bool, len(sample))
+	for _, stored := range sample {
+		if seen[stored.Status.Serial] {
+			continue
+		}
+		seen[stored.Status.Serial] = true
+		driftChecked.Inc()
+		d, ok := v.compare(stored, now)
+		if !ok {
+			continue
+		}
+		driftFound.WithLabelValues(d.Kind).Inc()
+		drift = append(drift, d)
+		records = append(records, stored.Status)
+	}
+	if len(drift) == 0 {
+		return nil, nil
+	}
+
+	corrected := 0
+	if v.opts.Correct {
+		n, err := v.refresher.sign(ctx, records, reasonDrift)
+		if err != nil {
+			v.logger.Error("Failed to re-sign drifted responses", zap.Error(err))
+		}
+		corrected = n
+	}
+	for _, d := range drift {
+		v.logger.Error("Precomputed response drifted from its status",
+			zap.String("serial", d.Serial), zap.String("kind", d.Kind), zap.String("stored", d.Stored), zap.String("status", d.Status))
+	}
+	v.fire(ctx, drift, len(seen), corrected, now)
+	return drift, nil
+}
+
+// compare reports how stored disagrees with its status, if it does. A response signed for an
+// older status is only compared once the change outlives the grace period
+func (v *DriftVerifier) compare(stored Stored, now time.Time) (Drift, bool) {
+	rec := stored.Status
+	d := Drift{Serial: rec.Serial, Status: describeRecord(rec)}
+	resp, err := ocsp.ParseResponse(stored.DER, nil)
+	if err != nil {
+		d.Kind, d.Stored = DriftUnparseable, err.Error()
+		return d, true
+	}
+	d.Stored = describeResponse(resp)
+	if !stored.StatusThisUpdate.Equal(rec.ThisUpdate) && now.Sub(rec.ThisUpdate) < v.opts.Grace {
+		return Drift{}, false
+	}
+
+	want := v.expected(rec, resp.ThisUpdate)
+	switch {
+	case resp.SerialNumber.Text(16) != rec.Serial:
+		d.Kind = DriftSerial
+	case resp.Status != want:
+		d.Kind = DriftStatus
+	case want != ocsp.Revoked:
+		return Drift{}, false
+	case resp.RevocationReason != reasonCode(rec.RevocationReason):
+		d.Kind = DriftReason
+	case !resp.RevokedAt.Equal(revokedAt(rec).Truncate(time.Second)):
+		d.Kind = DriftTime
+	default:
+		return Drift{}, false
+	}
+	return d, true
+}
+
+// expected is the status the signer answers rec with at signedAt, as SignUntil decides it
+func (v *DriftVerifier) expected(rec storage.Record, signedAt time.Time) int {
+	if expired := v.refresher.signer.Expired; expired != nil && expired.Decide(rec.NotAfter, signedAt).Unknown {
+		return ocsp.Unknown
+	}
+	switch rec.Status {
+	case storage.StatusGood:
+		return ocsp.Good
+	case storage.StatusRevoked:
+		return ocsp.Revoked
+	default:
+		return ocsp.Unknown
+	}
+}
+
+func reasonCode(name string) int {
+	if code, ok := revocation.ReasonCode(name); ok {
+		return code
+	}
+	return ocsp.Unspecified
+}
+
+// revokedAt is the revocation time signed for rec, which defaults to its this_update
+func revokedAt(rec storage.Record) time.Time {
+	if rec.RevokedAt != nil {
+		return rec.RevokedAt.UTC()
+	}
+	return rec.ThisUpdate.UTC()
+}
+
+func describeRecord(rec storage.Record) string {
+	if rec.Status != storage.StatusRevoked {
+		return rec.Status
+	}
+	return fmt.Sprintf("%s %s %s", rec.Status, rec.RevocationReason, revokedAt(rec).Format(time.RFC3339))
+}
+
+func describeResponse(resp *ocsp.Response) string {
+	status := statusName(resp.Status)
+	if resp.Status != ocsp.Revoked {
+		return status
+	}
+	return fmt.Sprintf("%s %s %s", status, revocation.ReasonName(resp.RevocationReason), resp.RevokedAt.UTC().Format(time.RFC3339))
+}
+
+// randomSerial is a random point in the serial space to start a sample at
+func randomSerial() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return strings.TrimLeft(hex.EncodeToString(b), "0")
+}
+
+func (v *DriftVerifier) fire(ctx context.Context, drift []Drift, checked, corrected int, now time.Time) {
+	var listed []string
+	for _, d := range drift[:min(len(drift), maxDriftReported)] {
+		listed = append(listed, fmt.Sprintf("%s %s: stored %s, status %s", d.Serial, d.Kind, d.Stored, d.Status))
+	}
+	message := fmt.Sprintf("%d of %d sampled precomputed responses disagree with their status (%d re-signed): %s",
+		len(drift), checked, corrected, strings.Join(listed, "; "))
+	if len(drift) > maxDriftReported {
+		message += fmt.Sprintf("; and %d more", len(drift)-maxDriftReported)
+	}
+	alert := anomaly.Alert{
+		Kind:    anomaly.KindResponseDrift,
+		Message: message,
+		Source:  "precomputed",
+		Count:   int64(len(drift)),
+		FiredAt: now,
+	}
+	for _, hook := range v.hooks {
+		hook.Fire(ctx, alert)
+	}
+}
+
+// Run verifies a sample every interval until ctx is cancelled
+func (v *DriftVerifier) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := v.Verify(ctx); err != nil && ctx.Err() == nil {
+			v.logger.Error("Failed to verify precomputed responses", zap.Error(err))
+		}
+	}
+}
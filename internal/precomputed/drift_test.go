@@ -0,0 +1,119 @@
+package precomputed
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/anomaly"
+	"github.com/gigvault/ocsp/internal/expiry"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/pkg/testsupport"
+	"github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+)
+
+func testSigner(t *testing.T) *Signer {
+	t.Helper()
+	key := testsupport.NewSigner()
+	issuer, err := testsupport.NewIssuer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Signer{Issuer: issuer, Key: key, Validity: time.Hour, RefreshBefore: 10 * time.Minute}
+}
+
+func TestDriftCompare(t *testing.T) {
+	signer := testSigner(t)
+	expiring := *signer
+	expiring.Expired = &expiry.Policy{Name: expiry.PolicyUnknown}
+
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d).UTC()
+		return &t
+	}
+	good := storage.Record{Serial: "1a2b", Status: storage.StatusGood, ThisUpdate: now.Add(-3 * time.Hour)}
+	revoked := storage.Record{
+		Serial:           "1a2b",
+		Status:           storage.StatusRevoked,
+		ThisUpdate:       now.Add(-2 * time.Hour),
+		RevokedAt:        at(-2 * time.Hour),
+		RevocationReason: revocation.ReasonKeyCompromise,
+	}
+	justRevoked := revoked
+	justRevoked.ThisUpdate = now.Add(-10 * time.Second)
+	otherReason := revoked
+	otherReason.RevocationReason = revocation.ReasonCessationOfOperation
+	otherTime := revoked
+	otherTime.RevokedAt = at(-90 * time.Minute)
+	otherSerial := good
+	otherSerial.Serial = "1a2c"
+	expired := good
+	expired.NotAfter = at(-time.Hour)
+
+	// stored returns the response signer signs for signed, as stored next to the status rec
+	stored := func(signer *Signer, signed, rec storage.Record) Stored {
+		resp, err := signer.Sign(context.Background(), signed, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Stored{DER: resp.DER, StatusThisUpdate: resp.StatusThisUpdate, Status: rec}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		signer *Signer
+		stored Stored
+		want   string
+	}{
+		{"good", signer, stored(signer, good, good), ""},
+		{"revoked", signer, stored(signer, revoked, revoked), ""},
+		{"missed revocation", signer, stored(signer, good, revoked), DriftStatus},
+		{"revocation within grace", signer, stored(signer, good, justRevoked), ""},
+		{"reason", signer, stored(signer, revoked, otherReason), DriftReason},
+		{"revocation time", signer, stored(signer, revoked, otherTime), DriftTime},
+		{"serial", signer, stored(signer, good, otherSerial), DriftSerial},
+		{"unparseable", signer, Stored{DER: []byte{0x30, 0x00}, StatusThisUpdate: justRevoked.ThisUpdate, Status: justRevoked}, DriftUnparseable},
+		{"expired answered unknown", &expiring, stored(&expiring, expired, expired), ""},
+		{"expired answered good", &expiring, stored(signer, expired, expired), DriftStatus},
+	} {
+		v := NewDriftVerifier(&Refresher{signer: tc.signer}, DriftOptions{Grace: time.Minute}, &logger.Logger{Logger: zap.NewNop()})
+		d, ok := v.compare(tc.stored, now)
+		if ok != (tc.want != "") || d.Kind != tc.want {
+			t.Errorf("%s: compare() = %+v, %v, want kind %q", tc.name, d, ok, tc.want)
+		}
+	}
+}
+
+type recordedHook struct {
+	alerts []anomaly.Alert
+}
+
+func (h *recordedHook) Fire(ctx context.Context, alert anomaly.Alert) {
+	h.alerts = append(h.alerts, alert)
+}
+
+func TestDriftAlert(t *testing.T) {
+	hook := &recordedHook{}
+	v := NewDriftVerifier(&Refresher{}, DriftOptions{}, &logger.Logger{Logger: zap.NewNop()}, hook)
+	var drift []Drift
+	for _, serial := range []string{"a1", "a2", "a3", "a4", "a5", "a6", "a7"} {
+		drift = append(drift, Drift{Serial: serial, Kind: DriftStatus, Stored: "good", Status: "revoked"})
+	}
+	v.fire(context.Background(), drift, 100, 0, time.Now())
+
+	if len(hook.alerts) != 1 {
+		t.Fatalf("fired %d alerts", len(hook.alerts))
+	}
+	alert := hook.alerts[0]
+	if alert.Kind != anomaly.KindResponseDrift || alert.Count != 7 {
+		t.Errorf("alert = %+v", alert)
+	}
+	if !strings.HasPrefix(alert.Message, "7 of 100 sampled") || !strings.Contains(alert.Message, "a5 status") ||
+		strings.Contains(alert.Message, "a6") || !strings.HasSuffix(alert.Message, "; and 2 more") {
+		t.Errorf("alert message = %q", alert.Message)
+	}
+}
@@ -0,0 +1,243 @@
+package precomputed
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Response is a signed response ready to be served
+type Response struct {
+	Serial string
+	DER    []byte
+	// StatusThisUpdate is the this_update of the status that was signed, which tells the
+	// refresh job whether the status has changed since
+	StatusThisUpdate time.Time
+	NextUpdate       time.Time
+	// RefreshAt is when the refresh job re-signs the response
+	RefreshAt time.Time
+}
+
+// Table keeps one issuer's signed responses in the signed_responses table
+type Table struct {
+	db      *pgxpool.Pool
+	keyHash []byte
+}
+
+// NewTable creates a table of responses signed for issuer
+func NewTable(db *pgxpool.Pool, issuer *x509.Certificate) (*Table, error) {
+	keyHash, err := ocspreq.IssuerKeyHash(issuer, crypto.SHA1)
+	if err != nil {
+		return nil, err
+	}
+	return &Table{db: db, keyHash: keyHash}, nil
+}
+
+// Writable reports whether the database user may change signed responses
+func (t *Table) Writable(ctx context.Context) (bool, error) {
+	var writable bool
+	err := t.db.QueryRow(ctx, `SELECT has_table_privilege('signed_responses', 'INSERT, UPDATE, DELETE, TRUNCATE')`).Scan(&writable)
+	return writable, err
+}
+
+// Lookup returns the signed response for a serial in lowercase hex and its nextUpdate, or
+// storage.ErrNotFound
+func (t *Table) Lookup(ctx context.Context, serial string) ([]byte, time.Time, error) {
+	var der []byte
+	var nextUpdate time.Time
+	err := t.db.QueryRow(ctx, `
+		SELECT der, next_update FROM signed_responses
+		WHERE issuer_key_hash = $1 AND serial = $2
+	`, t.keyHash, serial).Scan(&der, &nextUpdate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, time.Time{}, storage.ErrNotFound
+	}
+	return der, nextUpdate, err
+}
+
+// NotAfter returns the recorded expiry of the certificate with serial, or nil when none is
+// recorded
+func (t *Table) NotAfter(ctx context.Context, serial string) (*time.Time, error) {
+	var notAfter *time.Time
+	err := t.db.QueryRow(ctx, `SELECT not_after FROM ocsp_responses WHERE serial = $1`, serial).Scan(&notAfter)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return notAfter, err
+}
+
+// Changed returns up to limit statuses with no response signed for their current this_update,
+// ordered by this_update and serial, starting after the given position
+func (t *Table) Changed(ctx context.Context, afterUpdate time.Time, afterSerial string, limit int) ([]storage.Record, error) {
+	return t.query(ctx, `
+		SELECT o.serial, o.status, o.this_update, o.next_update, o.revoked_at, o.revocation_reason, o.not_after, o.validity_seconds
+		FROM ocsp_responses o
+		LEFT JOIN signed_responses s ON s.issuer_key_hash = $1 AND s.serial = o.serial
+		WHERE (o.this_update, o.serial) > ($2, $3)
+		  AND (s.serial IS NULL OR s.status_this_update <> o.this_update)
+		ORDER BY o.this_update, o.serial
+		LIMIT $4
+	`, t.keyHash, afterUpdate.UTC(), afterSerial, limit)
+}
+
+// Expiring returns up to limit statuses whose signed response is due for re-signing at now,
+// soonest first. Responses stored without a refresh time are due once they expire before
+// expiresBefore
+func (t *Table) Expiring(ctx context.Context, now, expiresBefore time.Time, limit int) ([]storage.Record, error) {
+	return t.query(ctx, `
+		SELECT o.serial, o.status, o.this_update, o.next_update, o.revoked_at, o.revocation_reason, o.not_after, o.validity_seconds
+		FROM signed_responses s
+		JOIN ocsp_responses o ON o.serial = s.serial
+		WHERE s.issuer_key_hash = $1
+		  AND (s.refresh_at <= $2 OR (s.refresh_at IS NULL AND s.next_update < $3))
+		ORDER BY s.refresh_at NULLS FIRST, s.next_update
+		LIMIT $4
+	`, t.keyHash, now.UTC(), expiresBefore.UTC(), limit)
+}
+
+func (t *Table) query(ctx context.Context, query string, args ...interface{}) ([]storage.Record, error) {
+	rows, err := t.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []storage.Record
+	for rows.Next() {
+		var rec storage.Record
+		var validitySeconds *int64
+		if err := rows.Scan(&rec.Serial, &rec.Status, &rec.ThisUpdate, &rec.NextUpdate, &rec.RevokedAt, &rec.RevocationReason, &rec.NotAfter, &validitySeconds); err != nil {
+			return nil, err
+		}
+		if validitySeconds != nil {
+			rec.Validity = time.Duration(*validitySeconds) * time.Second
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// Stored is a signed response as stored, next to the status it should carry
+type Stored struct {
+	DER              []byte
+	StatusThisUpdate time.Time
+	Status           storage.Record
+}
+
+// Sample returns up to limit stored responses in serial order from a random start, wrapping
+// around to the lowest serials when fewer follow it
+func (t *Table) Sample(ctx context.Context, start string, limit int) ([]Stored, error) {
+	const query = `
+		SELECT s.der, s.status_this_update,
+			o.serial, o.status, o.this_update, o.next_update, o.revoked_at, o.revocation_reason, o.not_after, o.validity_seconds
+		FROM signed_responses s
+		JOIN ocsp_responses o ON o.serial = s.serial
+		WHERE s.issuer_key_hash = $1 AND s.serial >= $2
+		ORDER BY s.serial
+		LIMIT $3
+	`
+	stored, err := t.queryStored(ctx, query, t.keyHash, start, limit)
+	if err != nil || len(stored) == limit || start == "" {
+		return stored, err
+	}
+	more, err := t.queryStored(ctx, query, t.keyHash, "", limit-len(stored))
+	for _, m := range more {
+		if m.Status.Serial >= start {
+			break
+		}
+		stored = append(stored, m)
+	}
+	return stored, err
+}
+
+// RecentlyRevoked returns the stored responses of up to limit statuses revoked since, newest
+// first: those a missed invalidation would leave answering good
+func (t *Table) RecentlyRevoked(ctx context.Context, since time.Time, limit int) ([]Stored, error) {
+	return t.queryStored(ctx, `
+		SELECT s.der, s.status_this_update,
+			o.serial, o.status, o.this_update, o.next_update, o.revoked_at, o.revocation_reason, o.not_after, o.validity_seconds
+		FROM ocsp_responses o
+		JOIN signed_responses s ON s.issuer_key_hash = $1 AND s.serial = o.serial
+		WHERE o.status = 'revoked' AND o.this_update >= $2
+		ORDER BY o.this_update DESC
+		LIMIT $3
+	`, t.keyHash, since.UTC(), limit)
+}
+
+func (t *Table) queryStored(ctx context.Context, query string, args ...interface{}) ([]Stored, error) {
+	rows, err := t.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stored []Stored
+	for rows.Next() {
+		var s Stored
+		rec := &s.Status
+		var validitySeconds *int64
+		if err := rows.Scan(&s.DER, &s.StatusThisUpdate, &rec.Serial, &rec.Status, &rec.ThisUpdate, &rec.NextUpdate, &rec.RevokedAt, &rec.RevocationReason, &rec.NotAfter, &validitySeconds); err != nil {
+			return nil, err
+		}
+		if validitySeconds != nil {
+			rec.Validity = time.Duration(*validitySeconds) * time.Second
+		}
+		stored = append(stored, s)
+	}
+	return stored, rows.Err()
+}
+
+// Store writes responses in a single statement. A response never replaces one signed for a
+// newer status, and responses for serials whose status has since been deleted are dropped
+func (t *Table) Store(ctx context.Context, responses []Response) error {
+	if len(responses) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO signed_responses (issuer_key_hash, serial, der, status_this_update, next_update, refresh_at)
+		SELECT $1, u.serial, u.der, u.status_this_update, u.next_update, u.refresh_at
+		FROM UNNEST($2::TEXT[], $3::BYTEA[], $4::TIMESTAMP[], $5::TIMESTAMP[], $6::TIMESTAMP[])
+			AS u(serial, der, status_this_update, next_update, refresh_at)
+		JOIN ocsp_responses o ON o.serial = u.serial
+		ON CONFLICT (issuer_key_hash, serial) DO UPDATE SET
+			der = EXCLUDED.der,
+			status_this_update = EXCLUDED.status_this_update,
+			next_update = EXCLUDED.next_update,
+			refresh_at = EXCLUDED.refresh_at,
+			signed_at = NOW()
+		WHERE signed_responses.status_this_update <= EXCLUDED.status_this_update
+	`
+
+	serials := make([]string, len(responses))
+	ders := make([][]byte, len(responses))
+	thisUpdates := make([]time.Time, len(responses))
+	nextUpdates := make([]time.Time, len(responses))
+	refreshAts := make([]time.Time, len(responses))
+	for i, resp := range responses {
+		serials[i] = resp.Serial
+		ders[i] = resp.DER
+		thisUpdates[i] = resp.StatusThisUpdate.UTC()
+		nextUpdates[i] = resp.NextUpdate.UTC()
+		refreshAts[i] = resp.RefreshAt.UTC()
+	}
+	_, err := t.db.Exec(ctx, query, t.keyHash, serials, ders, thisUpdates, nextUpdates, refreshAts)
+	return err
+}
+
+// PruneOtherIssuers deletes responses signed for any other issuer, left behind when the
+// issuer changes. Responses for deleted statuses go with them through the foreign key
+func (t *Table) PruneOtherIssuers(ctx context.Context) (int64, error) {
+	tag, err := t.db.Exec(ctx, `DELETE FROM signed_responses WHERE issuer_key_hash <> $1`, t.keyHash)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
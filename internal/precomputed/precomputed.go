@@ -0,0 +1,409 @@
+// Package precomputed keeps the latest signed OCSP response for every certificate in the
+// database, so the responder answers a request with one indexed read of ready-made DER: no
+// joins, no signing and no encoding on the serving path
+package precomputed
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/expiry"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/responselog"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/pkg/ocspext"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+)
+
+// oidNonce is id-pkix-ocsp-nonce, echoed in responses signed for a request that carries one
+var oidNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+
+// Why a response was signed, used in metric labels
+const (
+	reasonChanged  = "changed"
+	reasonExpiring = "expiring"
+)
+
+// overlap is how far behind its last pass the refresh job looks for changed statuses, so
+// rows committed late by long transactions, or stamped by a database clock running behind
+// ours, are still picked up
+const overlap = 5 * time.Minute
+
+var (
+	signed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "precomputed_signed_total",
+		Help:      "Responses signed into the precomputed table, by reason.",
+	}, []string{"reason"})
+	served = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "precomputed_responses_total",
+		Help:      "OCSP requests answered from the precomputed table, by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(signed, served)
+}
+
+// Signer signs responses for one issuer
+type Signer struct {
+	Issuer *x509.Certificate
+	// Responder signs the responses; nil means the issuer signs them directly. A delegated
+	// responder certificate is embedded in every response
+	Responder *x509.Certificate
+	Key       crypto.Signer
+	// Validity is how long after signing a response is valid, unless the certificate's
+	// validity override is shorter
+	Validity time.Duration
+	// Jitter shortens each response's validity by a random part of it, spreading out when
+	// responses signed together expire. Overridden validities have none
+	Jitter time.Duration
+	// RefreshBefore is how long before its nextUpdate a response of Validity is re-signed;
+	// shorter responses are re-signed proportionally closer to theirs
+	RefreshBefore time.Duration
+	// Extensions, when set, adds deployment-specific extensions to every response
+	Extensions ocspext.Builder
+	// Expired, when set, decides how responses answer for certificates past their notAfter
+	Expired *expiry.Policy
+}
+
+// Sign signs a response carrying rec's status, valid from now
+func (s *Signer) Sign(ctx context.Context, rec storage.Record, now time.Time) (Response, error) {
+	return s.SignUntil(ctx, rec, now, time.Time{}, nil)
+}
+
+// SignUntil signs like Sign, but with a nextUpdate no later than limit when it is set. req is
+// the request the response answers, or nil when it is signed ahead of any request
+func (s *Signer) SignUntil(ctx context.Context, rec storage.Record, now, limit time.Time, req *ocspext.Request) (Response, error) {
+	serial, ok := new(big.Int).SetString(rec.Serial, 16)
+	if !ok || serial.Sign() <= 0 {
+		return Response{}, fmt.Errorf("invalid serial %q", rec.Serial)
+	}
+	status := rec.Status
+	var decision expiry.Decision
+	if s.Expired != nil {
+		decision = s.Expired.Decide(rec.NotAfter, now)
+		if decision.Unknown {
+			status = storage.StatusUnknown
+		}
+		// The answer changes when the certificate expires or its grace ends, so the response
+		// must be re-signed by then
+		if !decision.Until.IsZero() && (limit.IsZero() || decision.Until.Before(limit)) {
+			limit = decision.Until
+		}
+	}
+	thisUpdate := now.UTC().Truncate(time.Second)
+	validity := s.Validity
+	switch {
+	case rec.Validity > 0 && rec.Validity < s.Validity:
+		validity = rec.Validity
+	case s.Jitter > 0:
+		validity -= time.Duration(rand.Int63n(int64(s.Jitter))).Truncate(time.Second)
+	}
+	template := ocsp.Response{
+		SerialNumber: serial,
+		ThisUpdate:   thisUpdate,
+		NextUpdate:   thisUpdate.Add(validity),
+	}
+	// Responses are re-signed ahead of their nextUpdate in proportion to their validity, except
+	// those ending early because their answer changes then, which are re-signed when it does
+	refreshAt := template.NextUpdate.Add(-time.Duration(float64(s.RefreshBefore) * float64(validity) / float64(s.Validity)))
+	if !limit.IsZero() && limit.Before(template.NextUpdate) {
+		template.NextUpdate = limit.UTC().Truncate(time.Second)
+		refreshAt = template.NextUpdate
+	}
+	responder := s.Issuer
+	if s.Responder != nil {
+		responder = s.Responder
+		template.Certificate = s.Responder
+	}
+	switch status {
+	case storage.StatusGood:
+		template.Status = ocsp.Good
+	case storage.StatusRevoked:
+		template.Status = ocsp.Revoked
+		template.RevokedAt = rec.ThisUpdate
+		if rec.RevokedAt != nil {
+			template.RevokedAt = *rec.RevokedAt
+		}
+		template.RevocationReason = ocsp.Unspecified
+		if code, ok := revocation.ReasonCode(rec.RevocationReason); ok {
+			template.RevocationReason = code
+		}
+	default:
+		template.Status = ocsp.Unknown
+	}
+
+	var responseExtensions []pkix.Extension
+	if s.Extensions != nil {
+		exts, err := s.Extensions.Build(ctx, &ocspext.Response{
+			Issuer:           s.Issuer,
+			SerialNumber:     serial,
+			Status:           template.Status,
+			RevokedAt:        template.RevokedAt,
+			RevocationReason: template.RevocationReason,
+			ThisUpdate:       template.ThisUpdate,
+			NextUpdate:       template.NextUpdate,
+			Request:          req,
+		})
+		if err != nil {
+			return Response{}, fmt.Errorf("failed to build extensions for %s: %w", rec.Serial, err)
+		}
+		template.ExtraExtensions, responseExtensions = exts.Single, exts.Response
+	}
+	if req != nil && req.Nonce != nil && !hasExtension(responseExtensions, oidNonce) {
+		// Copied since the nonce aliases the request buffer, and clipped so a builder's own
+		// slice is never appended to
+		echo := pkix.Extension{Id: oidNonce, Value: append([]byte(nil), req.Nonce...)}
+		responseExtensions = append(slices.Clip(responseExtensions), echo)
+	}
+	if !decision.ArchiveCutoff.IsZero() {
+		ext, err := expiry.Extension(decision.ArchiveCutoff)
+		if err != nil {
+			return Response{}, fmt.Errorf("failed to encode archive cutoff for %s: %w", rec.Serial, err)
+		}
+		// Clipped so a builder's own slice is never appended to
+		template.ExtraExtensions = append(slices.Clip(template.ExtraExtensions), ext)
+	}
+
+	// Keys in an HSM or KMS can take long to sign and cannot be interrupted, so nothing is
+	// signed for a caller that has already given up
+	if err := ctx.Err(); err != nil {
+		return Response{}, fmt.Errorf("not signing response for %s: %w", rec.Serial, err)
+	}
+	der, err := ocspext.CreateResponse(s.Issuer, responder, template, s.Key, responseExtensions)
+	if err != nil {
+		return Response{}, fmt.Errorf("failed to sign response for %s: %w", rec.Serial, err)
+	}
+	return Response{Serial: rec.Serial, DER: der, StatusThisUpdate: rec.ThisUpdate, NextUpdate: template.NextUpdate, RefreshAt: refreshAt}, nil
+}
+
+func hasExtension(exts []pkix.Extension, oid asn1.ObjectIdentifier) bool {
+	for _, ext := range exts {
+		if ext.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// Refresher keeps the table current: every pass signs the statuses that changed since the
+// previous one and re-signs responses about to expire
+type Refresher struct {
+	table     *Table
+	signer    *Signer
+	batchSize int
+	logger    *logger.Logger
+	log       ResponseLog
+	meter     Meter
+	// concurrency is how many responses of a batch are signed at once
+	concurrency atomic.Int32
+
+	// since is where the next pass starts looking for changed statuses; the zero time makes
+	// the first pass walk the whole status table. Only Refresh touches it
+	since time.Time
+	// wake asks Run for a pass before the next interval
+	wake chan struct{}
+}
+
+// NewRefresher creates a refresher re-signing responses when signer set them to be refreshed,
+// batchSize at a time
+func NewRefresher(table *Table, signer *Signer, batchSize int, logger *logger.Logger) *Refresher {
+	r := &Refresher{table: table, signer: signer, batchSize: batchSize, logger: logger, wake: make(chan struct{}, 1)}
+	r.concurrency.Store(1)
+	return r
+}
+
+// SetConcurrency signs up to n responses of a batch at once, from the next batch on
+func (r *Refresher) SetConcurrency(n int) {
+	r.concurrency.Store(int32(n))
+}
+
+// Concurrency returns how many responses of a batch are signed at once
+func (r *Refresher) Concurrency() int {
+	return int(r.concurrency.Load())
+}
+
+// Changed asks Run for a pass now, so a status change is signed without waiting for the
+// interval. Changes arriving during a pass are picked up by one more pass
+func (r *Refresher) Changed(serial string) {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Missed asks Run for a pass now, like Changed
+func (r *Refresher) Missed() {
+	r.Changed("")
+}
+
+// ResponseLog records responses signed with the private half of key before they are stored or
+// served
+type ResponseLog interface {
+	Append(ctx context.Context, source string, key crypto.PublicKey, ders ...[]byte) error
+}
+
+// SetLog records every batch in log before storing it; a batch that cannot be logged fails
+// the pass. Responses the table then declines, because a newer status was stored meanwhile,
+// stay logged: they were signed
+func (r *Refresher) SetLog(log ResponseLog) {
+	r.log = log
+}
+
+// Meter counts the responses signed ahead of requests, by hex issuer key hash
+type Meter interface {
+	Presigned(issuer string, n int)
+}
+
+// SetMeter counts every batch the refresher stores in meter
+func (r *Refresher) SetMeter(meter Meter) {
+	r.meter = meter
+}
+
+// Refresh runs one pass. A failed pass is retried in full by the next one
+func (r *Refresher) Refresh(ctx context.Context) error {
+	started := time.Now()
+	changed, err := r.signChanged(ctx)
+	if err != nil {
+		return err
+	}
+	expiring, err := r.signExpiring(ctx)
+	if err != nil {
+		return err
+	}
+	r.since = started.Add(-overlap)
+	if changed > 0 || expiring > 0 {
+		r.logger.Info("Refreshed precomputed responses", zap.Int("changed", changed), zap.Int("expiring", expiring))
+	}
+	return nil
+}
+
+// signChanged signs every status changed since the last pass, walking them in batches
+func (r *Refresher) signChanged(ctx context.Context) (int, error) {
+	afterUpdate, afterSerial := r.since, ""
+	total := 0
+	for {
+		records, err := r.table.Changed(ctx, afterUpdate, afterSerial, r.batchSize)
+		if err != nil {
+			return total, err
+		}
+		n, err := r.sign(ctx, records, reasonChanged)
+		total += n
+		if err != nil || len(records) < r.batchSize {
+			return total, err
+		}
+		last := records[len(records)-1]
+		afterUpdate, afterSerial = last.ThisUpdate, last.Serial
+	}
+}
+
+// signExpiring re-signs responses whose refresh time has come. Each batch moves the responses
+// it signs out of the next one
+func (r *Refresher) signExpiring(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		now := time.Now()
+		records, err := r.table.Expiring(ctx, now, now.Add(r.signer.RefreshBefore), r.batchSize)
+		if err != nil {
+			return total, err
+		}
+		n, err := r.sign(ctx, records, reasonExpiring)
+		total += n
+		if err != nil || len(records) < r.batchSize {
+			return total, err
+		}
+	}
+}
+
+// sign signs and stores a batch. Statuses with serials that cannot be signed are logged and
+// skipped
+func (r *Refresher) sign(ctx context.Context, records []storage.Record, reason string) (int, error) {
+	now := time.Now()
+	results := make([]Response, len(records))
+	errs := make([]error, len(records))
+	sem := make(chan struct{}, max(r.Concurrency(), 1))
+	var wg sync.WaitGroup
+	for i, rec := range records {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			results[i], errs[i] = r.signer.Sign(ctx, rec, now)
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	responses := make([]Response, 0, len(records))
+	for i, rec := range records {
+		if errs[i] != nil {
+			r.logger.Warn("Skipping status that cannot be signed", zap.String("serial", rec.Serial), zap.Error(errs[i]))
+			continue
+		}
+		responses = append(responses, results[i])
+	}
+	if r.log != nil && len(responses) > 0 {
+		ders := make([][]byte, len(responses))
+		for i, resp := range responses {
+			ders[i] = resp.DER
+		}
+		if err := r.log.Append(ctx, responselog.SourceRefresh, r.signer.Key.Public(), ders...); err != nil {
+			return 0, fmt.Errorf("log signed responses: %w", err)
+		}
+	}
+	if err := r.table.Store(ctx, responses); err != nil {
+		return 0, err
+	}
+	signed.WithLabelValues(reason).Add(float64(len(responses)))
+	if r.meter != nil {
+		r.meter.Presigned(hex.EncodeToString(r.table.keyHash), len(responses))
+	}
+	return len(responses), nil
+}
+
+// Run removes responses signed for other issuers, then refreshes immediately, on every
+// interval and when woken by Changed until ctx is cancelled
+func (r *Refresher) Run(ctx context.Context, interval time.Duration) {
+	if n, err := r.table.PruneOtherIssuers(ctx); err != nil {
+		r.logger.Error("Failed to prune responses signed for other issuers", zap.Error(err))
+	} else if n > 0 {
+		r.logger.Info("Pruned responses signed for other issuers", zap.Int64("deleted", n))
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Refresh(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Error("Failed to refresh precomputed responses", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
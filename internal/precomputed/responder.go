@@ -0,0 +1,329 @@
+package precomputed
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/nonce"
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"github.com/gigvault/ocsp/internal/responselog"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/pkg/ocspext"
+	"github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+)
+
+// Responder answers RFC 6960 requests, by POST or base64 GET below its path, with the
+// response stored for the serial. Requests for other issuers or serials with no stored
+// response are answered unauthorized, and expired responses tryLater, since the refresh job
+// will replace them
+type Responder struct {
+	table  *Table
+	issuer *ocspreq.Issuer
+	reader *ocspreq.Reader
+	limits ocspreq.Limits
+	logger *logger.Logger
+
+	shortLived ShortLived
+	signer     *Signer
+	validity   time.Duration
+	aliases    Aliases
+	perRequest bool
+	log        ResponseLog
+	observer   ocspreq.Observer
+	lookups    ocspreq.LookupObserver
+	nonces     *nonce.Cache
+	// requireNonce refuses requests without a nonce where responses are signed on demand
+	requireNonce bool
+	// previous signs for the issuer's earlier keys, by certificate
+	previous map[*x509.Certificate]*Signer
+}
+
+// ShortLived proves the serials of short-lived certificates, which have no stored response
+type ShortLived interface {
+	// NotAfter returns the expiry of a proven, unexpired certificate with serial
+	NotAfter(serial string) (time.Time, bool)
+}
+
+// Aliases resolves serials that share another certificate's status, such as the serials of CT
+// precertificates, which have no stored response of their own
+type Aliases interface {
+	// Aliased returns the status of the serial alias stands for, or storage.ErrNotFound
+	Aliased(ctx context.Context, alias string) (*storage.Record, error)
+}
+
+// NewResponder creates a responder for issuer mounted at prefix. Requests exceeding limits
+// are answered malformedRequest without being decoded further
+func NewResponder(table *Table, issuer *x509.Certificate, prefix string, limits ocspreq.Limits, logger *logger.Logger) (*Responder, error) {
+	certID, err := ocspreq.NewIssuer(issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &Responder{table: table, issuer: certID, reader: ocspreq.NewReader(prefix, limits), limits: limits, logger: logger}, nil
+}
+
+// SetShortLived answers good for serials missing from the table that proofs vouch for, signed
+// on demand by signer and valid for validity or until the certificate expires, if sooner
+func (r *Responder) SetShortLived(proofs ShortLived, signer *Signer, validity time.Duration) {
+	r.shortLived, r.signer, r.validity = proofs, signer, validity
+}
+
+// SetAliases answers serials missing from the table with the status of the serial they alias,
+// signed on demand by signer
+func (r *Responder) SetAliases(aliases Aliases, signer *Signer) {
+	r.aliases, r.signer = aliases, signer
+}
+
+// SetPerRequest re-signs every stored response on demand with signer, for the request it
+// answers, so signer's extension builder sees each request. This trades the precomputed
+// responder's cheap reads for a signature per request
+func (r *Responder) SetPerRequest(signer *Signer) {
+	r.signer, r.perRequest = signer, true
+}
+
+// AddPrevious answers requests naming an earlier key of the issuer, that of signer.Issuer, so
+// they keep being answered across a key rollover. Stored responses name the current key, so
+// these requests are answered with the stored status signed again on demand by signer, valid
+// no later than the stored response
+func (r *Responder) AddPrevious(signer *Signer) error {
+	if err := r.issuer.AddPrevious(signer.Issuer); err != nil {
+		return err
+	}
+	if r.previous == nil {
+		r.previous = make(map[*x509.Certificate]*Signer)
+	}
+	r.previous[signer.Issuer] = signer
+	return nil
+}
+
+// SetObserver shows observer every well-formed request
+func (r *Responder) SetObserver(observer ocspreq.Observer) {
+	r.observer = observer
+}
+
+// SetLookupObserver tells observer of every request answered for the issuer
+func (r *Responder) SetLookupObserver(observer ocspreq.LookupObserver) {
+	r.lookups = observer
+}
+
+// SetNonceCache refuses requests answered with a response signed on demand, which echoes the
+// nonce, when their nonce was seen within the cache's window, and with require, when they
+// carry none. Stored responses are served regardless
+func (r *Responder) SetNonceCache(cache *nonce.Cache, require bool) {
+	r.nonces, r.requireNonce = cache, require
+}
+
+// SetLog records every response signed on demand in log before serving it; one that cannot
+// be logged is answered tryLater
+func (r *Responder) SetLog(log ResponseLog) {
+	r.log = log
+}
+
+// ServeHTTP answers one OCSP request with a single read of the table
+func (r *Responder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	buf := r.reader.Acquire()
+	defer r.reader.Release(buf)
+
+	body, err := r.reader.Read(req, buf)
+	if err != nil {
+		write(w, ocspreq.Reason(err), ocsp.MalformedRequestErrorResponse, time.Time{})
+		return
+	}
+	request, err := ocspreq.Parse(body, r.limits)
+	if err != nil {
+		write(w, ocspreq.Reason(err), ocsp.MalformedRequestErrorResponse, time.Time{})
+		return
+	}
+	if r.observer != nil {
+		r.observer.ObserveRequest(request)
+	}
+	issuer := r.issuer.Match(&request.Request)
+	if issuer == nil {
+		write(w, "unauthorized", ocsp.UnauthorizedErrorResponse, time.Time{})
+		return
+	}
+	signer, previous := r.signer, r.previous[issuer]
+	if previous != nil {
+		signer = previous
+	}
+
+	serial := request.SerialNumber.Text(16)
+	info := &ocspext.Request{Hash: request.HashAlgorithm, Nonce: request.Nonce}
+	source := nonce.Source(req.RemoteAddr)
+	der, nextUpdate, err := r.table.Lookup(req.Context(), serial)
+	if err == nil {
+		r.observeLookup(req.Context(), source, serial, true)
+	}
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		known := r.serveMissing(req.Context(), w, signer, serial, info, source)
+		r.observeLookup(req.Context(), source, serial, known)
+	case err != nil:
+		r.logger.Error("Failed to read precomputed response", zap.Error(err))
+		write(w, "try_later", ocsp.TryLaterErrorResponse, time.Time{})
+	case !nextUpdate.After(time.Now()):
+		write(w, "expired", ocsp.TryLaterErrorResponse, time.Time{})
+	case previous != nil:
+		r.resign(req.Context(), w, previous, "previous_key", der, nextUpdate, info, source)
+	case r.perRequest && degraded(signer):
+		// The stored response lacks what per-request signing adds, but answers at once
+		write(w, "cached", der, nextUpdate)
+	case r.perRequest:
+		r.resign(req.Context(), w, signer, "ok", der, nextUpdate, info, source)
+	default:
+		write(w, "ok", der, nextUpdate)
+	}
+}
+
+// Degradable is a signing key that can report being too slow to sign on demand, such as one
+// watched by package signwatch
+type Degradable interface {
+	Degraded() bool
+}
+
+// degraded reports whether signer's key is too slow to sign on demand, so stored responses are
+// preferred where there is one
+func degraded(signer *Signer) bool {
+	key, ok := signer.Key.(Degradable)
+	return ok && key.Degraded()
+}
+
+// serveMissing answers a serial with no stored response: with the status of the serial it
+// aliases, with good for a proven short-lived certificate, or else unauthorized. It reports
+// false when it answered unauthorized for want of a status
+func (r *Responder) serveMissing(ctx context.Context, w http.ResponseWriter, signer *Signer, serial string, info *ocspext.Request, source string) bool {
+	now := time.Now()
+	if r.aliases != nil {
+		rec, err := r.aliases.Aliased(ctx, serial)
+		switch {
+		case err == nil:
+			rec.Serial = serial
+			r.sign(ctx, w, signer, "alias", *rec, now, time.Time{}, info, source)
+			return true
+		case !errors.Is(err, storage.ErrNotFound):
+			r.logger.Error("Failed to resolve serial alias", zap.String("serial", serial), zap.Error(err))
+			write(w, "try_later", ocsp.TryLaterErrorResponse, time.Time{})
+			return true
+		}
+	}
+	if r.shortLived != nil {
+		if notAfter, ok := r.shortLived.NotAfter(serial); ok {
+			limit := now.Add(r.validity)
+			if notAfter.Before(limit) {
+				limit = notAfter
+			}
+			r.sign(ctx, w, signer, "short_lived", storage.Record{Serial: serial, Status: storage.StatusGood, ThisUpdate: now}, now, limit, info, source)
+			return true
+		}
+	}
+	write(w, "unauthorized", ocsp.UnauthorizedErrorResponse, time.Time{})
+	return false
+}
+
+func (r *Responder) observeLookup(ctx context.Context, source, serial string, known bool) {
+	if r.lookups != nil {
+		r.lookups.ObserveServed(ctx, source, serial, known)
+	}
+}
+
+// resign answers with the status of a stored response, signed again by signer for the request
+// and valid no later than the stored response
+func (r *Responder) resign(ctx context.Context, w http.ResponseWriter, signer *Signer, result string, der []byte, nextUpdate time.Time, info *ocspext.Request, source string) {
+	stored, err := ocsp.ParseResponse(der, nil)
+	if err != nil {
+		r.logger.Error("Failed to parse precomputed response", zap.Error(err))
+		write(w, "try_later", ocsp.TryLaterErrorResponse, time.Time{})
+		return
+	}
+	rec := storage.Record{Serial: stored.SerialNumber.Text(16), Status: statusName(stored.Status), ThisUpdate: stored.ThisUpdate}
+	if stored.Status == ocsp.Revoked {
+		rec.RevokedAt = &stored.RevokedAt
+		rec.RevocationReason = revocation.ReasonName(stored.RevocationReason)
+	}
+	if signer.Expired != nil {
+		if rec.NotAfter, err = r.table.NotAfter(ctx, rec.Serial); err != nil {
+			r.logger.Error("Failed to read certificate expiry", zap.String("serial", rec.Serial), zap.Error(err))
+			write(w, "try_later", ocsp.TryLaterErrorResponse, time.Time{})
+			return
+		}
+	}
+	r.sign(ctx, w, signer, result, rec, time.Now(), nextUpdate, info, source)
+}
+
+func statusName(status int) string {
+	switch status {
+	case ocsp.Good:
+		return storage.StatusGood
+	case ocsp.Revoked:
+		return storage.StatusRevoked
+	default:
+		return storage.StatusUnknown
+	}
+}
+
+// sign answers with a response signed on demand by signer for the request from source, valid
+// no later than limit when it is set
+func (r *Responder) sign(ctx context.Context, w http.ResponseWriter, signer *Signer, result string, rec storage.Record, now, limit time.Time, info *ocspext.Request, source string) {
+	if r.nonces != nil {
+		switch {
+		case info.Nonce == nil && r.requireNonce:
+			write(w, "missing_nonce", ocsp.MalformedRequestErrorResponse, time.Time{})
+			return
+		case info.Nonce != nil && r.nonces.Check(source, info.Nonce) != nil:
+			write(w, "replayed_nonce", ocsp.UnauthorizedErrorResponse, time.Time{})
+			return
+		}
+	}
+	resp, err := signer.SignUntil(ctx, rec, now, limit, info)
+	if err != nil {
+		r.logger.Error("Failed to sign response on demand", zap.String("serial", rec.Serial), zap.Error(err))
+		write(w, "try_later", ocsp.TryLaterErrorResponse, time.Time{})
+		return
+	}
+	if r.log != nil {
+		if err := r.log.Append(ctx, responselog.SourceOnDemand, signer.Key.Public(), resp.DER); err != nil {
+			r.logger.Error("Failed to log response signed on demand", zap.String("serial", rec.Serial), zap.Error(err))
+			write(w, "try_later", ocsp.TryLaterErrorResponse, time.Time{})
+			return
+		}
+	}
+	if info.Nonce != nil {
+		writeNonced(w, result, resp.DER)
+		return
+	}
+	write(w, result, resp.DER, resp.NextUpdate)
+}
+
+// writeNonced sends a response echoing the request's nonce. It answers that request alone, so
+// caches must neither store nor revalidate it
+func writeNonced(w http.ResponseWriter, result string, der []byte) {
+	served.WithLabelValues(result).Inc()
+	header := w.Header()
+	header.Set("Content-Type", "application/ocsp-response")
+	header.Set("Cache-Control", "no-cache, no-store, private")
+	header.Del("Expires")
+	header.Del("ETag")
+	header.Del("Last-Modified")
+	w.Write(der)
+}
+
+func write(w http.ResponseWriter, result string, der []byte, nextUpdate time.Time) {
+	served.WithLabelValues(result).Inc()
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	if maxAge := time.Until(nextUpdate); maxAge > 0 {
+		w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(maxAge.Seconds()))+", public, no-transform, must-revalidate")
+		w.Header().Set("Expires", nextUpdate.UTC().Format(http.TimeFormat))
+	}
+	w.Write(der)
+}
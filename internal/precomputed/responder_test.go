@@ -0,0 +1,56 @@
+package precomputed
+
+import (
+	"context"
+	"crypto"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/pkg/ocspclient"
+	"github.com/gigvault/ocsp/pkg/ocspext"
+	"github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+)
+
+func TestNoncedResponsesAreNotCached(t *testing.T) {
+	signer := testSigner(t)
+	r := &Responder{logger: &logger.Logger{Logger: zap.NewNop()}}
+	now := time.Now()
+	rec := storage.Record{Serial: "0a1b", Status: storage.StatusGood, ThisUpdate: now}
+
+	req, err := ocspclient.NewRequest(big.NewInt(0x0a1b), signer.Issuer, ocspclient.RequestOptions{NonceSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	w.Header().Set("ETag", `"stale"`)
+	w.Header().Set("Last-Modified", now.Format(time.RFC1123))
+	r.sign(context.Background(), w, signer, "ok", rec, now, time.Time{}, &ocspext.Request{Hash: crypto.SHA1, Nonce: req.Nonce}, "192.0.2.1")
+
+	header := w.Header()
+	if got := header.Get("Cache-Control"); got != "no-cache, no-store, private" {
+		t.Errorf("Cache-Control = %q", got)
+	}
+	for _, name := range []string{"ETag", "Last-Modified", "Expires"} {
+		if got := header.Get(name); got != "" {
+			t.Errorf("%s = %q on a nonced response", name, got)
+		}
+	}
+	if verified, err := ocspclient.Verify(w.Body.Bytes(), req, ocspclient.VerifyOptions{RequireNonce: true}); err != nil || !verified.NonceEchoed {
+		t.Errorf("Verify() = %+v, %v, want the nonce echoed", verified, err)
+	}
+
+	// Without a nonce the response is shared and cached until its nextUpdate
+	w = httptest.NewRecorder()
+	r.sign(context.Background(), w, signer, "ok", rec, now, time.Time{}, &ocspext.Request{Hash: crypto.SHA1}, "192.0.2.1")
+	if got := w.Header().Get("Cache-Control"); !strings.HasPrefix(got, "max-age=") {
+		t.Errorf("Cache-Control without a nonce = %q", got)
+	}
+	if w.Header().Get("Expires") == "" {
+		t.Error("no Expires without a nonce")
+	}
+}
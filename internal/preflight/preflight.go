@@ -0,0 +1,363 @@
+// Package preflight checks at startup what the enabled features depend on
+package preflight
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/config"
+	"github.com/gigvault/ocsp/internal/crl"
+	"github.com/jackc/pgx/v5"
+)
+
+// Severities. Errors stop the service from starting; warnings only do in strict mode
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Endpoints the CDN purgers call
+const (
+	fastlyAPI     = "https://api.fastly.com"
+	cloudFrontAPI = "https://cloudfront.amazonaws.com"
+)
+
+// Problem is one thing wrong with the configuration or what it points at
+type Problem struct {
+	Severity string `json:"severity"`
+	// Path is the dotted YAML path of the setting concerned
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Path, p.Message)
+}
+
+// Report lists every problem found
+type Report struct {
+	Problems []Problem `json:"problems"`
+}
+
+func (r *Report) errorf(path, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{Severity: SeverityError, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (r *Report) warnf(path, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, Problem{Severity: SeverityWarning, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Count returns the number of problems of severity
+func (r Report) Count(severity string) int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// Err joins the errors, and with strict the warnings too, or returns nil when there are none
+func (r Report) Err(strict bool) error {
+	var errs []error
+	for _, p := range r.Problems {
+		if p.Severity == SeverityError || strict {
+			errs = append(errs, errors.New(p.String()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Database is the status database as preflight queries it
+type Database interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// Options configures a check
+type Options struct {
+	// Connect opens the database, returning a function that closes it. Nil skips the database
+	// checks, for roles that run without one
+	Connect func(ctx context.Context) (Database, func(), error)
+	// Timeout bounds each connection attempt
+	Timeout time.Duration
+	// Now is when certificates must be valid; the current time when zero
+	Now time.Time
+}
+
+// Run checks everything the enabled features of cfg depend on
+func Run(ctx context.Context, cfg *config.Config, opts Options) Report {
+	c := &checker{cfg: cfg, opts: opts, now: opts.Now}
+	if c.now.IsZero() {
+		c.now = time.Now()
+	}
+	c.keys()
+	if opts.Connect != nil {
+		c.database(ctx)
+	}
+	c.endpoints(ctx)
+	return c.report
+}
+
+type checker struct {
+	cfg    *config.Config
+	opts   Options
+	now    time.Time
+	report Report
+}
+
+// keys checks every certificate and key the enabled features load
+func (c *checker) keys() {
+	cfg := c.cfg
+	if cfg.Precomputed.Enabled {
+		keyPath := cfg.Precomputed.SigningKeyPath
+		if cfg.Role == config.RoleResponder {
+			// The responder role serves what the writer signed and loads no key
+			keyPath = ""
+		}
+		issuer := c.responder("precomputed", cfg.Precomputed.IssuerCertPath, cfg.Precomputed.ResponderCertPath, keyPath, cfg.Precomputed.Validity)
+		for i, previous := range cfg.Precomputed.PreviousIssuers {
+			path := fmt.Sprintf("precomputed.previous_issuers[%d]", i)
+			old := c.responder(path, previous.IssuerCertPath, previous.ResponderCertPath, previous.SigningKeyPath, cfg.Precomputed.Validity)
+			if issuer != nil && old != nil && !slices.Equal(issuer.RawSubject, old.RawSubject) {
+				c.report.errorf(path+".issuer_cert_path", "subject %s differs from the current issuer's %s; only a rolled key of the same issuer can be listed", old.Subject, issuer.Subject)
+			}
+		}
+	}
+	if cfg.Presigned.Enabled {
+		c.certificate("presigned.issuer_cert_path", cfg.Presigned.IssuerCertPath)
+	}
+	if cfg.CRL.Enabled {
+		if issuer := c.pair("crl.issuer_cert_path", cfg.CRL.IssuerCertPath, "crl.issuer_key_path", cfg.CRL.IssuerKeyPath); issuer != nil {
+			if issuer.KeyUsage != 0 && issuer.KeyUsage&x509.KeyUsageCRLSign == 0 {
+				c.report.errorf("crl.issuer_cert_path", "certificate lacks the cRLSign key usage, so relying parties reject the CRLs it signs")
+			}
+			c.outlives("crl.validity", "crl.issuer_cert_path", issuer, cfg.CRL.Validity, "CRLs")
+		}
+	}
+	if cfg.Audit.Enabled && cfg.Audit.SigningKeyPath != "" {
+		c.pair("audit.certificate_path", cfg.Audit.CertificatePath, "audit.signing_key_path", cfg.Audit.SigningKeyPath)
+	}
+	if cfg.Compromise.Enabled {
+		c.pair("compromise.standby_cert_path", cfg.Compromise.StandbyCertPath, "compromise.standby_key_path", cfg.Compromise.StandbyKeyPath)
+	}
+	if cfg.Watchdog.Enabled {
+		c.certificate("watchdog.issuer_cert_path", cfg.Watchdog.IssuerCertPath)
+	}
+	if cfg.ShortLived.Enabled {
+		for i, issuer := range cfg.ShortLived.Issuers {
+			c.certificate(fmt.Sprintf("short_lived.issuers[%d].issuer_cert_path", i), issuer.IssuerCertPath)
+		}
+	}
+	if cfg.SerialAliases.Enabled {
+		for i, path := range cfg.SerialAliases.IssuerCertPaths {
+			c.certificate(fmt.Sprintf("serial_aliases.issuer_cert_paths[%d]", i), path)
+		}
+	}
+}
+
+// certificate loads the certificate at file and checks that it is valid now
+func (c *checker) certificate(path, file string) *x509.Certificate {
+	if file == "" {
+		return nil
+	}
+	cert, err := crl.LoadCertificate(file)
+	if err != nil {
+		c.report.errorf(path, "%v", err)
+		return nil
+	}
+	switch {
+	case c.now.After(cert.NotAfter):
+		c.report.errorf(path, "certificate %s expired on %s", cert.Subject, cert.NotAfter.UTC().Format(time.RFC3339))
+	case c.now.Before(cert.NotBefore):
+		c.report.errorf(path, "certificate %s is not valid before %s", cert.Subject, cert.NotBefore.UTC().Format(time.RFC3339))
+	}
+	return cert
+}
+
+// key loads the key at file and checks that it belongs to cert
+func (c *checker) key(path, file string, cert *x509.Certificate, certPath string) {
+	if file == "" {
+		return
+	}
+	key, err := crl.LoadSigner(file)
+	if err != nil {
+		c.report.errorf(path, "%v", err)
+		return
+	}
+	if cert == nil {
+		return
+	}
+	pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		c.report.errorf(path, "key does not match the certificate %s (%s)", cert.Subject, certPath)
+	}
+}
+
+// pair checks a certificate and the key for it, returning the certificate if it loaded
+func (c *checker) pair(certPath, certFile, keyPath, keyFile string) *x509.Certificate {
+	cert := c.certificate(certPath, certFile)
+	c.key(keyPath, keyFile, cert, certPath)
+	return cert
+}
+
+// responder checks an OCSP signing setup under prefix: the issuer, the delegated responder
+// certificate if any, and the key signing as either. Responses signed shortly before the
+// signing certificate expires must not outlive it. It returns the issuer if it loaded
+func (c *checker) responder(prefix, issuerFile, responderFile, keyFile string, validity time.Duration) *x509.Certificate {
+	issuer := c.certificate(prefix+".issuer_cert_path", issuerFile)
+	if responderFile == "" {
+		c.key(prefix+".signing_key_path", keyFile, issuer, prefix+".issuer_cert_path")
+		if issuer != nil {
+			c.outlives("precomputed.validity", prefix+".issuer_cert_path", issuer, validity, "responses")
+		}
+		return issuer
+	}
+
+	responder := c.certificate(prefix+".responder_cert_path", responderFile)
+	c.key(prefix+".signing_key_path", keyFile, responder, prefix+".responder_cert_path")
+	if responder == nil {
+		return issuer
+	}
+	if !slices.Contains(responder.ExtKeyUsage, x509.ExtKeyUsageOCSPSigning) {
+		c.report.errorf(prefix+".responder_cert_path", "certificate %s lacks the OCSPSigning extended key usage, so relying parties reject its responses", responder.Subject)
+	}
+	if issuer != nil {
+		if err := responder.CheckSignatureFrom(issuer); err != nil {
+			c.report.errorf(prefix+".responder_cert_path", "certificate %s is not issued by %s: %v", responder.Subject, issuer.Subject, err)
+		}
+	}
+	c.outlives("precomputed.validity", prefix+".responder_cert_path", responder, validity, "responses")
+	return issuer
+}
+
+// outlives warns when something signed now for validity would still be valid after cert, which
+// signs it, expires
+func (c *checker) outlives(validityPath, certPath string, cert *x509.Certificate, validity time.Duration, what string) {
+	if c.now.After(cert.NotAfter) || validity <= 0 {
+		return
+	}
+	if c.now.Add(validity).After(cert.NotAfter) {
+		c.report.warnf(certPath, "certificate expires on %s, within %s (%s): %s signed from now on outlive it; renew it",
+			cert.NotAfter.UTC().Format(time.RFC3339), validity, validityPath, what)
+	}
+}
+
+// tables lists the tables each enabled feature reads or writes, by the setting enabling it
+func (c *checker) tables() []struct{ path, table string } {
+	cfg := c.cfg
+	var tables []struct{ path, table string }
+	add := func(enabled bool, path, table string) {
+		if enabled {
+			tables = append(tables, struct{ path, table string }{path, table})
+		}
+	}
+	add(!cfg.Cassandra.Enabled && !cfg.Spanner.Enabled || cfg.Precomputed.Enabled, "database", "ocsp_responses")
+	add(cfg.CRL.Enabled, "crl.enabled", "crl_numbers")
+	add(cfg.FeatureFlags.Database.Enabled, "feature_flags.database.enabled", "feature_flags")
+	add(cfg.Approvals.Enabled, "approvals.enabled", "approval_requests")
+	add(cfg.Audit.Enabled, "audit.enabled", "audit_log")
+	add(cfg.Compromise.Enabled, "compromise.enabled", "compromised_keys")
+	add(cfg.Precomputed.Enabled, "precomputed.enabled", "signed_responses")
+	add(cfg.SerialAliases.Enabled, "serial_aliases.enabled", "serial_aliases")
+	add(cfg.Scheduled.Enabled, "scheduled_revocations.enabled", "scheduled_revocations")
+	add(cfg.Holds.Enabled, "timed_holds.enabled", "certificate_holds")
+	add(cfg.ResponseLog.Enabled, "response_log.enabled", "response_log")
+	add(cfg.DeadLetters.Enabled, "dead_letters.enabled", "dead_letters")
+	add(cfg.Events.Outbox.Enabled, "events.outbox.enabled", "event_outbox")
+	add(cfg.APIKeys.Enabled, "api_keys.enabled", "api_keys")
+	add(cfg.Tunables.Enabled, "tunables.enabled", "runtime_tunables")
+	add(cfg.Tunables.Enabled, "tunables.enabled", "tunable_changes")
+	add(cfg.Issuers.Enabled, "issuer_discovery.enabled", "issuers")
+	return tables
+}
+
+// database connects and checks that the tables of the enabled features exist
+func (c *checker) database(ctx context.Context) {
+	connectCtx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+	db, closeDB, err := c.opts.Connect(connectCtx)
+	if err != nil {
+		c.report.errorf("database", "%v", err)
+		return
+	}
+	defer closeDB()
+
+	for _, t := range c.tables() {
+		var exists bool
+		if err := db.QueryRow(connectCtx, `SELECT to_regclass($1) IS NOT NULL`, t.table).Scan(&exists); err != nil {
+			c.report.errorf("database", "failed to look up table %s: %v", t.table, err)
+			return
+		}
+		if !exists {
+			c.report.errorf(t.path, "table %s does not exist; apply the migrations", t.table)
+		}
+	}
+}
+
+// endpoints checks that the CDNs and brokers the enabled features publish to accept
+// connections. HTTP APIs are reached through any configured proxy; any HTTP answer will do
+func (c *checker) endpoints(ctx context.Context) {
+	cfg := c.cfg
+	if cfg.CDN.Enabled && cfg.CDN.Fastly.Enabled {
+		c.httpEndpoint(ctx, "cdn.fastly", fastlyAPI)
+	}
+	if cfg.CDN.Enabled && cfg.CDN.CloudFront.Enabled {
+		c.httpEndpoint(ctx, "cdn.cloudfront", cloudFrontAPI)
+	}
+	if cfg.Events.NATS.Enabled {
+		for _, server := range strings.Split(cfg.Events.NATS.URL, ",") {
+			c.tcpEndpoint(ctx, "events.nats.url", server, "4222")
+		}
+	}
+	if cfg.Events.Kafka.Enabled {
+		for i, broker := range cfg.Events.Kafka.Brokers {
+			c.tcpEndpoint(ctx, fmt.Sprintf("events.kafka.brokers[%d]", i), broker, "9092")
+		}
+	}
+}
+
+func (c *checker) httpEndpoint(ctx context.Context, path, endpoint string) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		c.report.errorf(path, "%v", err)
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.report.errorf(path, "%s is unreachable: %v", endpoint, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// tcpEndpoint dials address, a URL or host:port, adding defaultPort when it has none
+func (c *checker) tcpEndpoint(ctx context.Context, path, address, defaultPort string) {
+	address = strings.TrimSpace(address)
+	if u, err := url.Parse(address); err == nil && u.Host != "" {
+		address = u.Host
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultPort)
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		c.report.errorf(path, "%s is unreachable: %v", address, err)
+		return
+	}
+	conn.Close()
+}
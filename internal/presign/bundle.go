@@ -0,0 +1,435 @@
+// Package presign signs OCSP responses ahead of time in an offline environment and serves
+// them from a bundle on a responder that holds no key material
+package presign
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"golang.org/x/crypto/ocsp"
+)
+
+// Bundle identification; Version changes whenever the line layout does
+const (
+	Format  = "gigvault-ocsp-presigned"
+	Version = 1
+)
+
+// Line types. A bundle is gzipped NDJSON: a header, one DER response per serial, and a
+// trailer with the response count. Each response carries its own signature, so the bundle
+// needs no further integrity protection
+const (
+	lineHeader   = "header"
+	lineResponse = "response"
+	lineTrailer  = "trailer"
+)
+
+// Header opens a bundle
+type Header struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	CreatedAt  time.Time `json:"created_at"`
+	ThisUpdate time.Time `json:"this_update"`
+	NextUpdate time.Time `json:"next_update"`
+	// Issuer is the hex SHA-1 of the issuer's subject public key, as in OCSP CertIDs
+	Issuer string `json:"issuer"`
+}
+
+// Summary describes a signed or loaded bundle
+type Summary struct {
+	Header
+	Responses int `json:"responses"`
+	Skipped   int `json:"skipped,omitempty"`
+}
+
+type line struct {
+	Type string `json:"type"`
+}
+
+type headerLine struct {
+	Type string `json:"type"`
+	Header
+}
+
+type responseLine struct {
+	Type     string `json:"type"`
+	Serial   string `json:"serial"`
+	Response string `json:"response"` // base64 DER
+}
+
+type trailerLine struct {
+	Type      string `json:"type"`
+	Responses int    `json:"responses"`
+}
+
+// SignOptions configures offline signing
+type SignOptions struct {
+	Issuer *x509.Certificate
+	// Responder signs the responses; nil means the issuer signs them directly. A delegated
+	// responder certificate is embedded in every response
+	Responder *x509.Certificate
+	Signer    crypto.Signer
+	// ThisUpdate defaults to now; responses are valid until ThisUpdate plus Validity
+	ThisUpdate time.Time
+	Validity   time.Duration
+	// Workers is how many responses are signed in parallel; 0 means one per CPU
+	Workers int
+}
+
+// Sign writes a bundle holding one signed response per record to w. Records whose serial is
+// not valid hex are skipped and counted
+func Sign(ctx context.Context, w io.Writer, records []storage.Record, opts SignOptions) (*Summary, error) {
+	return sign(ctx, records, opts, Format, Version, newBundleWriter(w))
+}
+
+// writer receives a header, then the signed responses in record order
+type writer interface {
+	header(Header) error
+	response(serial *big.Int, der []byte) error
+	close(responses int) error
+}
+
+// sign signs records into out, identifying the output as format and version
+func sign(ctx context.Context, records []storage.Record, opts SignOptions, format string, version int, out writer) (*Summary, error) {
+	if opts.Issuer == nil || opts.Signer == nil {
+		return nil, errors.New("an issuer certificate and signing key are required")
+	}
+	if opts.Validity <= 0 {
+		return nil, errors.New("validity must be positive")
+	}
+	responder := opts.Responder
+	if responder == nil {
+		responder = opts.Issuer
+	}
+	thisUpdate := opts.ThisUpdate
+	if thisUpdate.IsZero() {
+		thisUpdate = time.Now()
+	}
+	thisUpdate = thisUpdate.UTC().Truncate(time.Second)
+
+	keyHash, err := ocspreq.IssuerKeyHash(opts.Issuer, crypto.SHA1)
+	if err != nil {
+		return nil, err
+	}
+	summary := &Summary{Header: Header{
+		Format:     format,
+		Version:    version,
+		CreatedAt:  time.Now().UTC(),
+		ThisUpdate: thisUpdate,
+		NextUpdate: thisUpdate.Add(opts.Validity),
+		Issuer:     hex.EncodeToString(keyHash),
+	}}
+
+	producedAt := time.Now().Truncate(time.Minute)
+	enc, err := newEncoder(opts.Issuer, responder, opts.Signer, producedAt, summary.ThisUpdate, summary.NextUpdate)
+	if err != nil {
+		return nil, err
+	}
+	if err := out.header(summary.Header); err != nil {
+		return nil, err
+	}
+
+	// Chunks are signed in parallel while the previous one is written, so compression and
+	// encoding overlap with signing
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	chunks := make(chan *chunk, 1)
+	go signChunks(ctx, enc, records, workers(opts.Workers), chunks)
+	for c := range chunks {
+		if c.err != nil {
+			return nil, c.err
+		}
+		for i, der := range c.ders {
+			if der == nil {
+				summary.Skipped++
+				continue
+			}
+			if err := out.response(c.serials[i], der); err != nil {
+				return nil, err
+			}
+			summary.Responses++
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := out.close(summary.Responses); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// bundleWriter writes the gzipped NDJSON bundle format
+type bundleWriter struct {
+	gz      *gzip.Writer
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+func newBundleWriter(w io.Writer) *bundleWriter {
+	gz := gzip.NewWriter(w)
+	buf := bufio.NewWriter(gz)
+	return &bundleWriter{gz: gz, buf: buf, encoder: json.NewEncoder(buf)}
+}
+
+func (b *bundleWriter) header(h Header) error {
+	return b.encoder.Encode(headerLine{Type: lineHeader, Header: h})
+}
+
+func (b *bundleWriter) response(serial *big.Int, der []byte) error {
+	return b.encoder.Encode(responseLine{
+		Type:     lineResponse,
+		Serial:   serial.Text(16),
+		Response: base64.StdEncoding.EncodeToString(der),
+	})
+}
+
+func (b *bundleWriter) close(responses int) error {
+	if err := b.encoder.Encode(trailerLine{Type: lineTrailer, Responses: responses}); err != nil {
+		return err
+	}
+	if err := b.buf.Flush(); err != nil {
+		return err
+	}
+	return b.gz.Close()
+}
+
+func revocationCode(reason string) int {
+	if reason == "" {
+		return ocsp.Unspecified
+	}
+	code, ok := revocation.ReasonCode(reason)
+	if !ok {
+		return ocsp.Unspecified
+	}
+	return code
+}
+
+// maxSerialBytes is the longest serial RFC 5280 allows, and the longest a request may carry
+const maxSerialBytes = 20
+
+// maxLineBytes bounds one bundle line, so a corrupt or hostile bundle cannot decompress into
+// an unbounded allocation
+const maxLineBytes = 1 << 20
+
+// entry is one loaded response
+type entry struct {
+	der    []byte
+	record storage.Record
+}
+
+// Bundle is a loaded set of pre-signed responses, every one verified against the issuer
+type Bundle struct {
+	Summary
+	issuer    *x509.Certificate
+	responses map[string]entry
+
+	// The serving path looks responses up by big-endian serial bytes and compares CertIDs
+	// with hashes computed at load, so answering a request allocates nothing here
+	bySerial map[string][]byte
+	certID   *ocspreq.Issuer
+	expires  []string
+}
+
+// Load reads a bundle, verifying that every response is signed for issuer and is for the
+// serial it is listed under. A truncated bundle is rejected
+func Load(r io.Reader, issuer *x509.Certificate) (*Bundle, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a presigned bundle: %w", err)
+	}
+	defer gz.Close()
+
+	keyHash, err := ocspreq.IssuerKeyHash(issuer, crypto.SHA1)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{issuer: issuer, responses: make(map[string]entry)}
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	for n := 1; scanner.Scan(); n++ {
+		data := scanner.Bytes()
+
+		var l line
+		if err := json.Unmarshal(data, &l); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if n == 1 && l.Type != lineHeader {
+			return nil, errors.New("bundle does not start with a header")
+		}
+
+		switch l.Type {
+		case lineHeader:
+			var h headerLine
+			if err := json.Unmarshal(data, &h); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if n != 1 || h.Format != Format {
+				return nil, fmt.Errorf("line %d: unexpected header", n)
+			}
+			if h.Version != Version {
+				return nil, fmt.Errorf("unsupported bundle version %d (this build reads version %d)", h.Version, Version)
+			}
+			if h.Issuer != hex.EncodeToString(keyHash) {
+				return nil, errors.New("bundle was signed for a different issuer")
+			}
+			bundle.Header = h.Header
+
+		case lineResponse:
+			var rl responseLine
+			if err := json.Unmarshal(data, &rl); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			der, err := base64.StdEncoding.DecodeString(rl.Response)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			resp, err := ocsp.ParseResponse(der, issuer)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid response for %s: %w", n, rl.Serial, err)
+			}
+			if resp.SerialNumber.Text(16) != rl.Serial {
+				return nil, fmt.Errorf("line %d: response is for serial %s, not %s", n, resp.SerialNumber.Text(16), rl.Serial)
+			}
+			bundle.responses[rl.Serial] = entry{der: der, record: toRecord(rl.Serial, resp)}
+
+		case lineTrailer:
+			var t trailerLine
+			if err := json.Unmarshal(data, &t); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if t.Responses != len(bundle.responses) {
+				return nil, fmt.Errorf("bundle lists %d responses but holds %d", t.Responses, len(bundle.responses))
+			}
+			bundle.Responses = len(bundle.responses)
+			if err := bundle.index(); err != nil {
+				return nil, err
+			}
+			return bundle, nil
+
+		default:
+			return nil, fmt.Errorf("line %d: unknown line type %q", n, l.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	return nil, errors.New("bundle is truncated: no trailer")
+}
+
+func toRecord(serial string, resp *ocsp.Response) storage.Record {
+	rec := storage.Record{
+		Serial:     serial,
+		ThisUpdate: resp.ThisUpdate,
+		NextUpdate: resp.NextUpdate,
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		rec.Status = storage.StatusGood
+	case ocsp.Revoked:
+		rec.Status = storage.StatusRevoked
+		revokedAt := resp.RevokedAt
+		rec.RevokedAt = &revokedAt
+		rec.RevocationReason = revocation.ReasonName(resp.RevocationReason)
+	default:
+		rec.Status = storage.StatusUnknown
+	}
+	return rec
+}
+
+// index builds the lookups the serving path uses
+func (b *Bundle) index() error {
+	b.bySerial = make(map[string][]byte, len(b.responses))
+	for serial, e := range b.responses {
+		n, ok := new(big.Int).SetString(serial, 16)
+		if !ok {
+			return fmt.Errorf("invalid serial %q", serial)
+		}
+		b.bySerial[string(n.Bytes())] = e.der
+	}
+	certID, err := ocspreq.NewIssuer(b.issuer)
+	if err != nil {
+		return err
+	}
+	b.certID = certID
+	b.expires = []string{b.NextUpdate.UTC().Format(http.TimeFormat)}
+	return nil
+}
+
+// Response returns the signed DER response for a serial in canonical lowercase hex
+func (b *Bundle) Response(serial string) ([]byte, bool) {
+	e, ok := b.responses[serial]
+	return e.der, ok
+}
+
+// responseFor returns the signed DER response for a request's serial without allocating
+func (b *Bundle) responseFor(serial *big.Int) ([]byte, bool) {
+	var buf [maxSerialBytes]byte
+	n := (serial.BitLen() + 7) / 8
+	if serial.Sign() <= 0 || n > len(buf) {
+		return nil, false
+	}
+	serial.FillBytes(buf[:n])
+	der, ok := b.bySerial[string(buf[:n])]
+	return der, ok
+}
+
+// matches reports whether a request's CertID names the bundle's issuer
+func (b *Bundle) matches(req *ocsp.Request) bool {
+	return b.certID.Matches(req)
+}
+
+// Record returns the status a serial's response carries
+func (b *Bundle) Record(serial string) (storage.Record, bool) {
+	e, ok := b.responses[serial]
+	return e.record, ok
+}
+
+// Issuer returns the certificate the bundle was verified against
+func (b *Bundle) Issuer() *x509.Certificate {
+	return b.issuer
+}
+
+// Info returns the bundle's summary
+func (b *Bundle) Info() Summary {
+	return b.Summary
+}
+
+// Close does nothing; a bundle is held in memory
+func (b *Bundle) Close() error {
+	return nil
+}
+
+func (b *Bundle) nextUpdate() time.Time {
+	return b.NextUpdate
+}
+
+func (b *Bundle) expiresHeader() []string {
+	return b.expires
+}
+
+func (b *Bundle) revoked() []storage.Record {
+	var revoked []storage.Record
+	for _, e := range b.responses {
+		if e.record.Status == storage.StatusRevoked {
+			revoked = append(revoked, e.record)
+		}
+	}
+	return revoked
+}
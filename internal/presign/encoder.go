@@ -0,0 +1,326 @@
+package presign
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/storage"
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+	"golang.org/x/crypto/ocsp"
+)
+
+// Object identifiers of the fixed response fields
+var (
+	oidSHA1            = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPBasic       = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+// Context-specific tags of the response structures in RFC 6960
+var (
+	tagGood          = cbasn1.Tag(0).ContextSpecific()
+	tagRevoked       = cbasn1.Tag(1).ContextSpecific().Constructed()
+	tagUnknown       = cbasn1.Tag(2).ContextSpecific()
+	tagExplicit0     = cbasn1.Tag(0).ContextSpecific().Constructed()
+	tagResponderName = cbasn1.Tag(1).ContextSpecific().Constructed()
+)
+
+// encoder signs responses that share an issuer, responder, producedAt and validity period.
+// It encodes what they share once, so each response costs its serial and status, one hash
+// and one signature: the same DER ocsp.CreateResponse produces, without the reflection-based
+// encoding and the issuer hashing it repeats on every call
+type encoder struct {
+	signer crypto.Signer
+	hash   crypto.Hash
+
+	// Pre-encoded elements, in the order they appear
+	certID     []byte // CertID contents up to the serial number
+	responder  []byte
+	producedAt []byte
+	thisUpdate []byte
+	nextUpdate []byte
+	sigAlg     []byte
+	certs      []byte
+}
+
+// newEncoder prepares the shared fields. responder is the certificate whose subject names the
+// responder and, when it is not the issuer, is embedded in every response
+func newEncoder(issuer, responder *x509.Certificate, signer crypto.Signer, producedAt, thisUpdate, nextUpdate time.Time) (*encoder, error) {
+	hash, sigAlg, err := signatureAlgorithm(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	e := &encoder{signer: signer, hash: hash, sigAlg: sigAlg}
+
+	spki := cryptobyte.String(issuer.RawSubjectPublicKeyInfo)
+	var keyInfo, algorithm cryptobyte.String
+	var key asn1.BitString
+	if !spki.ReadASN1(&keyInfo, cbasn1.SEQUENCE) || !keyInfo.ReadASN1(&algorithm, cbasn1.SEQUENCE) || !keyInfo.ReadASN1BitString(&key) {
+		return nil, errors.New("invalid issuer public key")
+	}
+	h := crypto.SHA1.New()
+	h.Write(issuer.RawSubject)
+	nameHash := h.Sum(nil)
+	h.Reset()
+	h.Write(key.RightAlign())
+	keyHash := h.Sum(nil)
+
+	e.certID = build(func(b *cryptobyte.Builder) {
+		b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1ObjectIdentifier(oidSHA1)
+			b.AddASN1NULL()
+		})
+		b.AddASN1OctetString(nameHash)
+		b.AddASN1OctetString(keyHash)
+	})
+	e.responder = build(func(b *cryptobyte.Builder) {
+		b.AddASN1(tagResponderName, func(b *cryptobyte.Builder) {
+			b.AddBytes(responder.RawSubject)
+		})
+	})
+	e.producedAt = build(func(b *cryptobyte.Builder) {
+		b.AddASN1GeneralizedTime(producedAt.UTC())
+	})
+	e.thisUpdate = build(func(b *cryptobyte.Builder) {
+		b.AddASN1GeneralizedTime(thisUpdate.UTC())
+	})
+	e.nextUpdate = build(func(b *cryptobyte.Builder) {
+		b.AddASN1(tagExplicit0, func(b *cryptobyte.Builder) {
+			b.AddASN1GeneralizedTime(nextUpdate.UTC())
+		})
+	})
+	if responder != issuer {
+		e.certs = build(func(b *cryptobyte.Builder) {
+			b.AddASN1(tagExplicit0, func(b *cryptobyte.Builder) {
+				b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					b.AddBytes(responder.Raw)
+				})
+			})
+		})
+	}
+	for _, field := range [][]byte{e.certID, e.responder, e.producedAt, e.thisUpdate, e.nextUpdate} {
+		if field == nil {
+			return nil, errors.New("failed to encode response template")
+		}
+	}
+	return e, nil
+}
+
+// signatureAlgorithm returns the digest and encoded AlgorithmIdentifier ocsp.CreateResponse
+// signs with for a public key
+func signatureAlgorithm(pub crypto.PublicKey) (crypto.Hash, []byte, error) {
+	var hash crypto.Hash
+	var oid asn1.ObjectIdentifier
+	withNull := false
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		hash, oid, withNull = crypto.SHA256, oidSHA256WithRSA, true
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P224(), elliptic.P256():
+			hash, oid = crypto.SHA256, oidECDSAWithSHA256
+		case elliptic.P384():
+			hash, oid = crypto.SHA384, oidECDSAWithSHA384
+		case elliptic.P521():
+			hash, oid = crypto.SHA512, oidECDSAWithSHA512
+		default:
+			return 0, nil, errors.New("unsupported elliptic curve")
+		}
+	default:
+		return 0, nil, errors.New("only RSA and ECDSA keys can sign OCSP responses")
+	}
+	return hash, build(func(b *cryptobyte.Builder) {
+		b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1ObjectIdentifier(oid)
+			if withNull {
+				b.AddASN1NULL()
+			}
+		})
+	}), nil
+}
+
+// sign returns the signed response for a serial carrying rec's status
+func (e *encoder) sign(serial *big.Int, rec storage.Record) ([]byte, error) {
+	tbs := build(func(b *cryptobyte.Builder) {
+		b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddBytes(e.responder)
+			b.AddBytes(e.producedAt)
+			b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+						b.AddBytes(e.certID)
+						b.AddASN1BigInt(serial)
+					})
+					addStatus(b, rec)
+					b.AddBytes(e.thisUpdate)
+					b.AddBytes(e.nextUpdate)
+				})
+			})
+		})
+	})
+	if tbs == nil {
+		return nil, errors.New("failed to encode response")
+	}
+
+	h := e.hash.New()
+	h.Write(tbs)
+	signature, err := e.signer.Sign(rand.Reader, h.Sum(nil), e.hash)
+	if err != nil {
+		return nil, err
+	}
+
+	der := build(func(b *cryptobyte.Builder) {
+		b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1Enum(int64(ocsp.Success))
+			b.AddASN1(tagExplicit0, func(b *cryptobyte.Builder) {
+				b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					b.AddASN1ObjectIdentifier(oidOCSPBasic)
+					b.AddASN1(cbasn1.OCTET_STRING, func(b *cryptobyte.Builder) {
+						b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+							b.AddBytes(tbs)
+							b.AddBytes(e.sigAlg)
+							b.AddASN1(cbasn1.BIT_STRING, func(b *cryptobyte.Builder) {
+								b.AddUint8(0)
+								b.AddBytes(signature)
+							})
+							b.AddBytes(e.certs)
+						})
+					})
+				})
+			})
+		})
+	})
+	if der == nil {
+		return nil, errors.New("failed to encode response")
+	}
+	return der, nil
+}
+
+// addStatus encodes the CertStatus choice. A revocation reason of unspecified is omitted, as
+// ocsp.CreateResponse does
+func addStatus(b *cryptobyte.Builder, rec storage.Record) {
+	switch rec.Status {
+	case storage.StatusGood:
+		b.AddASN1(tagGood, func(*cryptobyte.Builder) {})
+	case storage.StatusRevoked:
+		revokedAt := rec.ThisUpdate
+		if rec.RevokedAt != nil {
+			revokedAt = *rec.RevokedAt
+		}
+		b.AddASN1(tagRevoked, func(b *cryptobyte.Builder) {
+			b.AddASN1GeneralizedTime(revokedAt.UTC())
+			if reason := revocationCode(rec.RevocationReason); reason != ocsp.Unspecified {
+				b.AddASN1(tagExplicit0, func(b *cryptobyte.Builder) {
+					b.AddASN1Enum(int64(reason))
+				})
+			}
+		})
+	default:
+		b.AddASN1(tagUnknown, func(*cryptobyte.Builder) {})
+	}
+}
+
+// build returns what fn adds, or nil if it failed
+func build(fn func(*cryptobyte.Builder)) []byte {
+	var b cryptobyte.Builder
+	fn(&b)
+	der, err := b.Bytes()
+	if err != nil {
+		return nil
+	}
+	return der
+}
+
+// chunkSize is how many records are signed between writes
+const chunkSize = 1024
+
+// chunk is a run of signed responses in record order. ders[i] is nil for a record whose
+// serial is invalid
+type chunk struct {
+	serials []*big.Int
+	ders    [][]byte
+	err     error
+}
+
+func workers(n int) int {
+	if n <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return n
+}
+
+// signChunks signs records chunk by chunk with n workers and sends the chunks to out in
+// order, then closes it. It stops after a chunk that failed, or when ctx is cancelled
+func signChunks(ctx context.Context, enc *encoder, records []storage.Record, n int, out chan<- *chunk) {
+	defer close(out)
+	for start := 0; start < len(records); start += chunkSize {
+		end := min(start+chunkSize, len(records))
+		c := enc.signChunk(ctx, records[start:end], n)
+		select {
+		case out <- c:
+		case <-ctx.Done():
+			return
+		}
+		if c.err != nil {
+			return
+		}
+	}
+}
+
+// signChunk signs records with n workers taking the next unsigned record in turn
+func (e *encoder) signChunk(ctx context.Context, records []storage.Record, n int) *chunk {
+	c := &chunk{serials: make([]*big.Int, len(records)), ders: make([][]byte, len(records))}
+	var next atomic.Int64
+	var failed atomic.Pointer[error]
+	var wg sync.WaitGroup
+	for range min(n, len(records)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(records) || failed.Load() != nil {
+					return
+				}
+				if err := ctx.Err(); err != nil {
+					failed.CompareAndSwap(nil, &err)
+					return
+				}
+				rec := records[i]
+				serial, ok := new(big.Int).SetString(rec.Serial, 16)
+				if !ok || serial.Sign() <= 0 {
+					continue
+				}
+				der, err := e.sign(serial, rec)
+				if err != nil {
+					err = fmt.Errorf("failed to sign response for %s: %w", rec.Serial, err)
+					failed.CompareAndSwap(nil, &err)
+					return
+				}
+				c.serials[i], c.ders[i] = serial, der
+			}
+		}()
+	}
+	wg.Wait()
+	if err := failed.Load(); err != nil {
+		c.err = *err
+	}
+	return c
+}
@@ -0,0 +1,399 @@
+package presign
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"github.com/gigvault/ocsp/internal/storage"
+	"golang.org/x/crypto/ocsp"
+)
+
+// Mapped file identification; MappedVersion changes whenever the layout does
+const (
+	MappedFormat  = "gigvault-ocsp-mapped"
+	MappedVersion = 1
+)
+
+// A mapped file holds the DER responses back to back, then an index of one fixed-size entry
+// per response sorted by serial, then a fixed-size footer. All integers are big-endian. The
+// responder maps the file and answers a request with a binary search of the index, so it
+// holds tens of millions of responses in the page cache rather than on the heap
+const (
+	// indexEntrySize is the serial left-padded to maxSerialBytes, the response offset (uint64)
+	// and its length (uint32). Padding makes byte order equal numeric order
+	indexEntrySize = maxSerialBytes + 8 + 4
+	footerSize     = 96
+)
+
+// mappedMagic opens the footer
+var mappedMagic = [8]byte{'G', 'V', 'O', 'C', 'S', 'P', 'M', 'F'}
+
+// footer layout: magic [8], version uint32, reserved uint32, count uint64, index offset
+// uint64, created at, thisUpdate and nextUpdate as Unix seconds, the SHA-1 issuer key hash
+// [20] and zero padding
+type footer struct {
+	version     uint32
+	count       uint64
+	indexOffset uint64
+	createdAt   int64
+	thisUpdate  int64
+	nextUpdate  int64
+	issuer      [20]byte
+}
+
+func (f *footer) marshal() []byte {
+	buf := make([]byte, footerSize)
+	copy(buf, mappedMagic[:])
+	binary.BigEndian.PutUint32(buf[8:], f.version)
+	binary.BigEndian.PutUint64(buf[16:], f.count)
+	binary.BigEndian.PutUint64(buf[24:], f.indexOffset)
+	binary.BigEndian.PutUint64(buf[32:], uint64(f.createdAt))
+	binary.BigEndian.PutUint64(buf[40:], uint64(f.thisUpdate))
+	binary.BigEndian.PutUint64(buf[48:], uint64(f.nextUpdate))
+	copy(buf[56:76], f.issuer[:])
+	return buf
+}
+
+func parseFooter(buf []byte) (*footer, error) {
+	if len(buf) != footerSize || !bytes.Equal(buf[:8], mappedMagic[:]) {
+		return nil, errors.New("not a mapped presigned file")
+	}
+	f := &footer{
+		version:     binary.BigEndian.Uint32(buf[8:]),
+		count:       binary.BigEndian.Uint64(buf[16:]),
+		indexOffset: binary.BigEndian.Uint64(buf[24:]),
+		createdAt:   int64(binary.BigEndian.Uint64(buf[32:])),
+		thisUpdate:  int64(binary.BigEndian.Uint64(buf[40:])),
+		nextUpdate:  int64(binary.BigEndian.Uint64(buf[48:])),
+	}
+	copy(f.issuer[:], buf[56:76])
+	if f.version != MappedVersion {
+		return nil, fmt.Errorf("unsupported mapped file version %d (this build reads version %d)", f.version, MappedVersion)
+	}
+	return f, nil
+}
+
+// SignMapped writes a mapped file holding one signed response per record to w. Records whose
+// serial is not valid hex are skipped and counted, and duplicate serials are refused. The
+// index is kept in memory until the end, at 32 bytes per response
+func SignMapped(ctx context.Context, w io.Writer, records []storage.Record, opts SignOptions) (*Summary, error) {
+	return sign(ctx, records, opts, MappedFormat, MappedVersion, &mappedWriter{w: bufio.NewWriter(w)})
+}
+
+// mappedWriter writes responses as they come and the sorted index at the end
+type mappedWriter struct {
+	w      *bufio.Writer
+	hdr    Header
+	offset uint64
+	index  []byte
+}
+
+func (m *mappedWriter) header(h Header) error {
+	m.hdr = h
+	return nil
+}
+
+func (m *mappedWriter) response(serial *big.Int, der []byte) error {
+	n := (serial.BitLen() + 7) / 8
+	if n > maxSerialBytes {
+		return fmt.Errorf("serial %s is longer than %d bytes", serial.Text(16), maxSerialBytes)
+	}
+	if _, err := m.w.Write(der); err != nil {
+		return err
+	}
+	var entry [indexEntrySize]byte
+	serial.FillBytes(entry[:maxSerialBytes])
+	binary.BigEndian.PutUint64(entry[maxSerialBytes:], m.offset)
+	binary.BigEndian.PutUint32(entry[maxSerialBytes+8:], uint32(len(der)))
+	m.index = append(m.index, entry[:]...)
+	m.offset += uint64(len(der))
+	return nil
+}
+
+func (m *mappedWriter) close(responses int) error {
+	entries := entrySlice(m.index)
+	sort.Sort(entries)
+	for i := 1; i < entries.Len(); i++ {
+		if bytes.Equal(entries.serial(i-1), entries.serial(i)) {
+			return fmt.Errorf("duplicate serial %s", new(big.Int).SetBytes(entries.serial(i)).Text(16))
+		}
+	}
+	if _, err := m.w.Write(m.index); err != nil {
+		return err
+	}
+
+	f := footer{
+		version:     MappedVersion,
+		count:       uint64(responses),
+		indexOffset: m.offset,
+		createdAt:   m.hdr.CreatedAt.Unix(),
+		thisUpdate:  m.hdr.ThisUpdate.Unix(),
+		nextUpdate:  m.hdr.NextUpdate.Unix(),
+	}
+	issuer, err := hex.DecodeString(m.hdr.Issuer)
+	if err != nil || len(issuer) != len(f.issuer) {
+		return errors.New("invalid issuer key hash")
+	}
+	copy(f.issuer[:], issuer)
+	if _, err := m.w.Write(f.marshal()); err != nil {
+		return err
+	}
+	return m.w.Flush()
+}
+
+// entrySlice sorts index entries in place by serial
+type entrySlice []byte
+
+func (e entrySlice) Len() int { return len(e) / indexEntrySize }
+
+func (e entrySlice) serial(i int) []byte {
+	return e[i*indexEntrySize : i*indexEntrySize+maxSerialBytes]
+}
+
+func (e entrySlice) Less(i, j int) bool {
+	return bytes.Compare(e.serial(i), e.serial(j)) < 0
+}
+
+func (e entrySlice) Swap(i, j int) {
+	var tmp [indexEntrySize]byte
+	a, b := e[i*indexEntrySize:(i+1)*indexEntrySize], e[j*indexEntrySize:(j+1)*indexEntrySize]
+	copy(tmp[:], a)
+	copy(a, b)
+	copy(b, tmp[:])
+}
+
+// verifySamples is how many responses, spread across the index, OpenMapped verifies
+const verifySamples = 256
+
+// Mapped serves presigned responses from a memory-mapped file
+type Mapped struct {
+	summary Summary
+	issuer  *x509.Certificate
+	certID  *ocspreq.Issuer
+	expires []string
+
+	data  []byte // the whole mapping
+	index entrySlice
+	unmap func() error
+}
+
+// isMapped reports whether the file at path ends with a mapped file footer
+func isMapped(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return false, err
+	}
+	if info.Size() < footerSize {
+		return false, nil
+	}
+	var magic [8]byte
+	if _, err := file.ReadAt(magic[:], info.Size()-footerSize); err != nil {
+		return false, err
+	}
+	return magic == mappedMagic, nil
+}
+
+// OpenMapped maps the file at path and checks its footer, its index and a sample of its signatures
+// against issuer
+func OpenMapped(path string, issuer *x509.Certificate) (*Mapped, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map presigned file: %w", err)
+	}
+	m, err := newMapped(data, issuer)
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	m.unmap = unmap
+	return m, nil
+}
+
+func newMapped(data []byte, issuer *x509.Certificate) (*Mapped, error) {
+	if len(data) < footerSize {
+		return nil, errors.New("not a mapped presigned file")
+	}
+	f, err := parseFooter(data[len(data)-footerSize:])
+	if err != nil {
+		return nil, err
+	}
+	keyHash, err := ocspreq.IssuerKeyHash(issuer, crypto.SHA1)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(keyHash, f.issuer[:]) {
+		return nil, errors.New("mapped file was signed for a different issuer")
+	}
+	size := uint64(len(data)) - footerSize
+	if f.indexOffset > size || (size-f.indexOffset)%indexEntrySize != 0 || (size-f.indexOffset)/indexEntrySize != f.count {
+		return nil, errors.New("mapped file is truncated or corrupt")
+	}
+
+	index := entrySlice(data[f.indexOffset:size])
+	for i := 0; i < index.Len(); i++ {
+		if i > 0 && bytes.Compare(index.serial(i-1), index.serial(i)) >= 0 {
+			return nil, fmt.Errorf("mapped file index is not sorted at entry %d", i)
+		}
+		offset, length := index.location(i)
+		if offset > f.indexOffset || length > f.indexOffset-offset {
+			return nil, fmt.Errorf("mapped file index entry %d points outside the responses", i)
+		}
+	}
+
+	certID, err := ocspreq.NewIssuer(issuer)
+	if err != nil {
+		return nil, err
+	}
+	nextUpdate := time.Unix(f.nextUpdate, 0).UTC()
+	m := &Mapped{
+		summary: Summary{
+			Header: Header{
+				Format:     MappedFormat,
+				Version:    int(f.version),
+				CreatedAt:  time.Unix(f.createdAt, 0).UTC(),
+				ThisUpdate: time.Unix(f.thisUpdate, 0).UTC(),
+				NextUpdate: nextUpdate,
+				Issuer:     hex.EncodeToString(keyHash),
+			},
+			Responses: index.Len(),
+		},
+		issuer:  issuer,
+		certID:  certID,
+		expires: []string{nextUpdate.Format(http.TimeFormat)},
+		data:    data,
+		index:   index,
+	}
+
+	step := max(1, index.Len()/verifySamples)
+	for i := 0; i < index.Len(); i += step {
+		if err := m.verify(i); err != nil {
+			return nil, err
+		}
+	}
+	if n := index.Len(); n > 0 {
+		if err := m.verify(n - 1); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// location returns where entry i's response lies in the file
+func (e entrySlice) location(i int) (uint64, uint64) {
+	entry := e[i*indexEntrySize : (i+1)*indexEntrySize]
+	return binary.BigEndian.Uint64(entry[maxSerialBytes:]), uint64(binary.BigEndian.Uint32(entry[maxSerialBytes+8:]))
+}
+
+// verify checks the signature and serial of entry i's response
+func (m *Mapped) verify(i int) error {
+	serial := new(big.Int).SetBytes(m.index.serial(i))
+	resp, err := ocsp.ParseResponse(m.response(i), m.issuer)
+	if err != nil {
+		return fmt.Errorf("invalid response for %s: %w", serial.Text(16), err)
+	}
+	if resp.SerialNumber.Cmp(serial) != 0 {
+		return fmt.Errorf("response is for serial %s, not %s", resp.SerialNumber.Text(16), serial.Text(16))
+	}
+	return nil
+}
+
+func (m *Mapped) response(i int) []byte {
+	offset, length := m.index.location(i)
+	return m.data[offset : offset+length : offset+length]
+}
+
+// responseFor binary searches the index for a serial without allocating
+func (m *Mapped) responseFor(serial *big.Int) ([]byte, bool) {
+	var key [maxSerialBytes]byte
+	if serial.Sign() <= 0 || serial.BitLen() > maxSerialBytes*8 {
+		return nil, false
+	}
+	serial.FillBytes(key[:])
+	n := m.index.Len()
+	i := sort.Search(n, func(i int) bool {
+		return bytes.Compare(m.index.serial(i), key[:]) >= 0
+	})
+	if i == n || !bytes.Equal(m.index.serial(i), key[:]) {
+		return nil, false
+	}
+	return m.response(i), true
+}
+
+func (m *Mapped) matches(req *ocsp.Request) bool {
+	return m.certID.Matches(req)
+}
+
+// Record parses the serial's response for the status it carries
+func (m *Mapped) Record(serial string) (storage.Record, bool) {
+	n, ok := new(big.Int).SetString(serial, 16)
+	if !ok {
+		return storage.Record{}, false
+	}
+	der, ok := m.responseFor(n)
+	if !ok {
+		return storage.Record{}, false
+	}
+	resp, err := ocsp.ParseResponse(der, nil)
+	if err != nil {
+		return storage.Record{}, false
+	}
+	return toRecord(n.Text(16), resp), true
+}
+
+// Info returns the file's summary
+func (m *Mapped) Info() Summary {
+	return m.summary
+}
+
+// Issuer returns the certificate the file was checked against
+func (m *Mapped) Issuer() *x509.Certificate {
+	return m.issuer
+}
+
+// Close unmaps the file
+func (m *Mapped) Close() error {
+	if m.unmap == nil {
+		return nil
+	}
+	return m.unmap()
+}
+
+func (m *Mapped) nextUpdate() time.Time {
+	return m.summary.NextUpdate
+}
+
+func (m *Mapped) expiresHeader() []string {
+	return m.expires
+}
+
+// revoked parses every response, so it reads the whole file
+func (m *Mapped) revoked() []storage.Record {
+	var revoked []storage.Record
+	for i := 0; i < m.index.Len(); i++ {
+		resp, err := ocsp.ParseResponse(m.response(i), nil)
+		if err != nil || resp.Status != ocsp.Revoked {
+			continue
+		}
+		revoked = append(revoked, toRecord(resp.SerialNumber.Text(16), resp))
+	}
+	return revoked
+}
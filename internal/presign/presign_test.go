@@ -0,0 +1,129 @@
+package presign
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/pkg/testsupport"
+	"golang.org/x/crypto/ocsp"
+)
+
+func testIssuer(tb testing.TB) (*x509.Certificate, crypto.Signer) {
+	tb.Helper()
+	key := testsupport.NewSigner()
+	cert, err := testsupport.NewIssuer(key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return cert, key
+}
+
+// testRecords returns n records, every tenth of them revoked
+func testRecords(n int) []storage.Record {
+	now := time.Now().UTC()
+	records := make([]storage.Record, n)
+	for i := range records {
+		records[i] = storage.Record{Serial: fmt.Sprintf("%x", 0x100000+i), Status: storage.StatusGood, ThisUpdate: now}
+		if i%10 == 0 {
+			revokedAt := now.Add(-time.Hour)
+			records[i].Status = storage.StatusRevoked
+			records[i].RevokedAt = &revokedAt
+			records[i].RevocationReason = "keyCompromise"
+		}
+	}
+	return records
+}
+
+// BenchmarkSign signs a bundle of 1000 P-256 responses, 10% of them revoked
+func BenchmarkSign(b *testing.B) {
+	issuer, signer := testIssuer(b)
+	records := testRecords(1000)
+	opts := SignOptions{Issuer: issuer, Signer: signer, Validity: time.Hour}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Sign(context.Background(), io.Discard, records, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// discard is a ResponseWriter that keeps nothing, so the benchmark measures the responder
+type discard struct {
+	header http.Header
+	status int
+}
+
+func (d *discard) Header() http.Header         { return d.header }
+func (d *discard) Write(p []byte) (int, error) { return len(p), nil }
+func (d *discard) WriteHeader(status int)      { d.status = status }
+
+// BenchmarkResponder serves one serial from a 100-response bundle, by POST and by GET
+func BenchmarkResponder(b *testing.B) {
+	issuer, signer := testIssuer(b)
+	records := testRecords(100)
+	path := filepath.Join(b.TempDir(), "bundle.ndjson.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if _, err := Sign(context.Background(), file, records, SignOptions{Issuer: issuer, Signer: signer, Validity: time.Hour}); err != nil {
+		b.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		b.Fatal(err)
+	}
+	holder := &Holder{}
+	if _, err := holder.LoadFile(path, issuer); err != nil {
+		b.Fatal(err)
+	}
+	responder := NewResponder(holder, "/ocsp", ocspreq.Limits{MaxBodyBytes: 4096, MaxCertIDs: 4})
+
+	serial, _ := new(big.Int).SetString(records[42].Serial, 16)
+	request, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: serial}, issuer, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// One request is checked end to end so the benchmark cannot measure an error path
+	rec := httptest.NewRecorder()
+	responder.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ocsp", bytes.NewReader(request)))
+	resp, err := ocsp.ParseResponseForCert(rec.Body.Bytes(), &x509.Certificate{SerialNumber: serial}, issuer)
+	if err != nil || resp.Status != ocsp.Good {
+		b.Fatalf("responder answered %v, %v", resp, err)
+	}
+
+	b.Run("POST", func(b *testing.B) {
+		body := bytes.NewReader(request)
+		req := httptest.NewRequest(http.MethodPost, "/ocsp", body)
+		w := &discard{header: http.Header{}}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			body.Reset(request)
+			responder.ServeHTTP(w, req)
+		}
+	})
+	b.Run("GET", func(b *testing.B) {
+		req := httptest.NewRequest(http.MethodGet, "/ocsp/"+base64.StdEncoding.EncodeToString(request), nil)
+		w := &discard{header: http.Header{}}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			responder.ServeHTTP(w, req)
+		}
+	})
+}
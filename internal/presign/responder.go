@@ -0,0 +1,307 @@
+package presign
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/mode"
+	"github.com/gigvault/ocsp/internal/nonce"
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ErrOffline is returned for status changes; a presigned responder is read-only by design.
+// It matches mode.ErrReadOnly so APIs answer it the same way
+var ErrOffline = fmt.Errorf("responder serves a presigned bundle: %w", mode.ErrReadOnly)
+
+var (
+	served = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "presigned_responses_total",
+		Help:      "OCSP requests answered from the presigned bundle, by result.",
+	}, []string{"result"})
+	bundleNextUpdate = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "presigned_bundle_next_update_timestamp_seconds",
+		Help:      "nextUpdate of the responses in the loaded presigned bundle.",
+	})
+)
+
+// servedByResult resolves the counters for every result up front, so counting one does not
+// hash label values
+var servedByResult = map[string]prometheus.Counter{}
+
+func init() {
+	metrics.Registry.MustRegister(served, bundleNextUpdate)
+	for _, result := range []string{
+		"ok", "unauthorized", "try_later", "malformed", "too_large", "too_many_cert_ids",
+		"extension_too_large",
+	} {
+		servedByResult[result] = served.WithLabelValues(result)
+	}
+}
+
+func countResult(result string) {
+	if counter, ok := servedByResult[result]; ok {
+		counter.Inc()
+		return
+	}
+	served.WithLabelValues(result).Inc()
+}
+
+// Source is a loaded set of presigned responses, verified against the issuer: a Bundle held
+// in memory, or a Mapped file served from disk
+type Source interface {
+	// Info describes the responses
+	Info() Summary
+	// Issuer returns the certificate the responses were verified against
+	Issuer() *x509.Certificate
+	// Record returns the status a serial's response carries, for a serial in lowercase hex
+	Record(serial string) (storage.Record, bool)
+	// Close releases the source; nothing may read it afterwards
+	Close() error
+
+	responseFor(serial *big.Int) ([]byte, bool)
+	matches(req *ocsp.Request) bool
+	nextUpdate() time.Time
+	expiresHeader() []string
+	revoked() []storage.Record
+}
+
+// retireDelay is how long a replaced source stays open, so requests still reading it finish
+// first. It exceeds the HTTP write timeout
+const retireDelay = time.Minute
+
+// Holder holds the current source; a new one can be swapped in without interrupting lookups
+type Holder struct {
+	current atomic.Pointer[held]
+}
+
+type held struct {
+	Source
+}
+
+// LoadFile loads and verifies the bundle or mapped file at path for issuer and makes it
+// current. A file that fails to load leaves the current source in place
+func (h *Holder) LoadFile(path string, issuer *x509.Certificate) (Source, error) {
+	mapped, err := isMapped(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open presigned bundle: %w", err)
+	}
+	var src Source
+	if mapped {
+		src, err = OpenMapped(path, issuer)
+	} else {
+		src, err = loadBundleFile(path, issuer)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if old := h.current.Swap(&held{src}); old != nil {
+		time.AfterFunc(retireDelay, func() { old.Close() })
+	}
+	bundleNextUpdate.Set(float64(src.nextUpdate().Unix()))
+	return src, nil
+}
+
+func loadBundleFile(path string, issuer *x509.Certificate) (*Bundle, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open presigned bundle: %w", err)
+	}
+	defer file.Close()
+	return Load(file, issuer)
+}
+
+// Source returns the current source, or nil before one is loaded
+func (h *Holder) Source() Source {
+	if held := h.current.Load(); held != nil {
+		return held.Source
+	}
+	return nil
+}
+
+// Responder answers RFC 6960 requests, by POST or base64 GET below its path, with the
+// presigned response for the serial. Requests for other issuers or serials missing from the
+// bundle are answered unauthorized, as RFC 5019 prescribes for responders that cannot answer
+type Responder struct {
+	holder *Holder
+	reader *ocspreq.Reader
+	limits ocspreq.Limits
+
+	observer ocspreq.Observer
+	lookups  ocspreq.LookupObserver
+
+	control atomic.Pointer[cachedControl]
+}
+
+// NewResponder creates a responder mounted at prefix. Requests exceeding limits are answered
+// malformedRequest without being decoded further
+func NewResponder(holder *Holder, prefix string, limits ocspreq.Limits) *Responder {
+	return &Responder{holder: holder, reader: ocspreq.NewReader(prefix, limits), limits: limits}
+}
+
+// SetObserver shows observer every well-formed request
+func (r *Responder) SetObserver(observer ocspreq.Observer) {
+	r.observer = observer
+}
+
+// SetLookupObserver tells observer of every request answered for the bundle's issuer
+func (r *Responder) SetLookupObserver(observer ocspreq.LookupObserver) {
+	r.lookups = observer
+}
+
+// ServeHTTP answers one OCSP request. The request is decoded into a pooled buffer and the
+// response is written from the bundle, so a successful lookup allocates almost nothing
+func (r *Responder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	buf := r.reader.Acquire()
+	defer r.reader.Release(buf)
+
+	body, err := r.reader.Read(req, buf)
+	if err != nil {
+		r.write(w, ocspreq.Reason(err), ocsp.MalformedRequestErrorResponse, time.Time{})
+		return
+	}
+
+	request, err := ocspreq.Parse(body, r.limits)
+	if err != nil {
+		r.write(w, ocspreq.Reason(err), ocsp.MalformedRequestErrorResponse, time.Time{})
+		return
+	}
+	if r.observer != nil {
+		r.observer.ObserveRequest(request)
+	}
+
+	src := r.holder.Source()
+	if src == nil {
+		r.write(w, "try_later", ocsp.TryLaterErrorResponse, time.Time{})
+		return
+	}
+	if !src.matches(&request.Request) {
+		r.write(w, "unauthorized", ocsp.UnauthorizedErrorResponse, time.Time{})
+		return
+	}
+	der, ok := src.responseFor(request.SerialNumber)
+	if r.lookups != nil {
+		r.lookups.ObserveServed(req.Context(), nonce.Source(req.RemoteAddr), request.SerialNumber.Text(16), ok)
+	}
+	if !ok {
+		r.write(w, "unauthorized", ocsp.UnauthorizedErrorResponse, time.Time{})
+		return
+	}
+	r.writeBundled(w, src, der)
+}
+
+// Header values are shared between responses; net/http only reads them
+var (
+	contentType = []string{"application/ocsp-response"}
+)
+
+func (r *Responder) write(w http.ResponseWriter, result string, der []byte, nextUpdate time.Time) {
+	countResult(result)
+	header := w.Header()
+	header["Content-Type"] = contentType
+	if maxAge := time.Until(nextUpdate); maxAge > 0 {
+		header["Cache-Control"] = r.cacheControl(nextUpdate, time.Now())
+		header["Expires"] = []string{nextUpdate.UTC().Format(http.TimeFormat)}
+	}
+	w.Write(der)
+}
+
+// writeBundled writes a response from src with the source's precomputed cache headers
+func (r *Responder) writeBundled(w http.ResponseWriter, src Source, der []byte) {
+	countResult("ok")
+	header := w.Header()
+	header["Content-Type"] = contentType
+	now := time.Now()
+	if nextUpdate := src.nextUpdate(); nextUpdate.After(now) {
+		header["Cache-Control"] = r.cacheControl(nextUpdate, now)
+		header["Expires"] = src.expiresHeader()
+	}
+	w.Write(der)
+}
+
+// cachedControl is a Cache-Control value for one nextUpdate, valid for one second
+type cachedControl struct {
+	second     int64
+	nextUpdate time.Time
+	value      []string
+}
+
+// cacheControl returns the Cache-Control header for responses valid until nextUpdate. max-age
+// only changes every second, so the value is rebuilt at most once a second
+func (r *Responder) cacheControl(nextUpdate, now time.Time) []string {
+	second := now.Unix()
+	if c := r.control.Load(); c != nil && c.second == second && c.nextUpdate.Equal(nextUpdate) {
+		return c.value
+	}
+	maxAge := int(nextUpdate.Sub(now).Seconds())
+	c := &cachedControl{
+		second:     second,
+		nextUpdate: nextUpdate,
+		value:      []string{"max-age=" + strconv.Itoa(maxAge) + ", public, no-transform, must-revalidate"},
+	}
+	r.control.Store(c)
+	return c.value
+}
+
+// Store serves lookups from the current source and rejects every status change
+type Store struct {
+	holder *Holder
+}
+
+// NewStore creates a read-only store over the source in holder
+func NewStore(holder *Holder) *Store {
+	return &Store{holder: holder}
+}
+
+// Get returns the status carried by the serial's presigned response
+func (s *Store) Get(ctx context.Context, serial string) (*storage.Record, error) {
+	src := s.holder.Source()
+	if src == nil {
+		return nil, storage.ErrNotFound
+	}
+	rec, ok := src.Record(serial)
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return &rec, nil
+}
+
+// Upsert returns ErrOffline
+func (s *Store) Upsert(ctx context.Context, update storage.Update) error {
+	return ErrOffline
+}
+
+// ApplyBatch returns ErrOffline
+func (s *Store) ApplyBatch(ctx context.Context, updates []storage.Update) error {
+	return ErrOffline
+}
+
+// ListRevoked returns the revoked serials in the current source, ordered by serial
+func (s *Store) ListRevoked(ctx context.Context) ([]storage.Record, error) {
+	src := s.holder.Source()
+	if src == nil {
+		return nil, nil
+	}
+	revoked := src.revoked()
+	sort.Slice(revoked, func(i, j int) bool { return revoked[i].Serial < revoked[j].Serial })
+	return revoked, nil
+}
@@ -0,0 +1,358 @@
+// Package replication propagates status writes between regional instances over JetStream
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/events"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// retryDelay is how long a replicated write that failed to apply waits before redelivery
+const retryDelay = 5 * time.Second
+
+// maxConflicts bounds the recent conflicts kept for operators
+const maxConflicts = 100
+
+// replicatedValidity matches the next_update the store gives local writes
+const replicatedValidity = 24 * time.Hour
+
+// Conflict kinds
+const (
+	// ConflictReason is a serial revoked in both regions with different reasons or times
+	ConflictReason = "revocation_mismatch"
+	// ConflictRevokedGood is a serial permanently revoked in one region and not in the other
+	ConflictRevokedGood = "revoked_vs_not_revoked"
+)
+
+var (
+	replicated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "replication_received_total",
+		Help:      "Replicated status writes received from other regions, by origin and outcome.",
+	}, []string{"origin", "result"})
+	conflicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "replication_conflicts_total",
+		Help:      "Contradictory revocations detected between regions.",
+	}, []string{"kind"})
+	replicationLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "replication_lag_seconds",
+		Help:      "Delay between a write in another region and its arrival here, for the last message.",
+	}, []string{"origin"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(replicated, conflicts, replicationLag)
+}
+
+// Message is a status write as replicated between regions. NotAfter and ValiditySeconds are
+// the certificate details stored with the serial when it was published; messages from
+// publishers without a source leave them out, and the receiving region keeps its own
+type Message struct {
+	events.Event
+	Origin          string     `json:"origin"`
+	NotAfter        *time.Time `json:"not_after,omitempty"`
+	ValiditySeconds *int64     `json:"validity_seconds,omitempty"`
+}
+
+// Source is where a publisher reads the certificate details stored with a serial
+type Source interface {
+	Get(ctx context.Context, serial string) (*storage.Record, error)
+}
+
+// Publisher publishes local status changes to the region's replication subject
+type Publisher struct {
+	js      jetstream.JetStream
+	subject string
+	region  string
+	source  Source
+}
+
+// NewPublisher creates a replication publisher for region under subjectPrefix
+func NewPublisher(js jetstream.JetStream, subjectPrefix, region string) *Publisher {
+	return &Publisher{js: js, subject: subjectPrefix + "." + region, region: region}
+}
+
+// SetSource makes every message carry the expiry and validity override stored with its
+// serial, read from source
+func (p *Publisher) SetSource(source Source) {
+	p.source = source
+}
+
+// Publish publishes each event, deduplicated by event ID, and returns the first failure
+func (p *Publisher) Publish(ctx context.Context, batch []events.Event) error {
+	for _, event := range batch {
+		m := Message{Event: event, Origin: p.region}
+		if p.source != nil {
+			rec, err := p.source.Get(ctx, event.Serial)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return fmt.Errorf("failed to read %s for replication: %w", event.Serial, err)
+			}
+			if rec != nil {
+				seconds := int64(rec.Validity / time.Second)
+				m.NotAfter, m.ValiditySeconds = rec.NotAfter, &seconds
+			}
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("failed to encode replication message: %w", err)
+		}
+		if _, err := p.js.Publish(ctx, p.subject, data, jetstream.WithMsgID(event.ID)); err != nil {
+			return fmt.Errorf("failed to replicate event %s: %w", event.ID, err)
+		}
+	}
+	return nil
+}
+
+// Close is a no-op; the connection is owned by the caller
+func (p *Publisher) Close() error {
+	return nil
+}
+
+// Store is what replicated writes are applied to: it must not republish them
+type Store interface {
+	Get(ctx context.Context, serial string) (*storage.Record, error)
+	storage.Replica
+}
+
+// Conflict is a contradictory revocation between this region and another
+type Conflict struct {
+	Serial     string    `json:"serial"`
+	Kind       string    `json:"kind"`
+	Origin     string    `json:"origin"`
+	Local      string    `json:"local"`
+	Remote     string    `json:"remote"`
+	Winner     string    `json:"winner"` // "local" or "remote", by last writer
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// ConsumerOptions configures replication intake
+type ConsumerOptions struct {
+	Region        string
+	Stream        string
+	SubjectPrefix string
+	MaxDeliver    int
+}
+
+// Consumer applies writes replicated from other regions, last writer wins on this_update.
+// Applied writes are passed to region-local sinks such as CDN purging and watchers, but not
+// republished, so changes never loop between regions
+type Consumer struct {
+	js     jetstream.JetStream
+	store  Store
+	notify []events.Sink
+	opts   ConsumerOptions
+	logger *logger.Logger
+
+	mu     sync.Mutex
+	recent []Conflict
+}
+
+// NewConsumer creates a replication consumer
+func NewConsumer(js jetstream.JetStream, store Store, notify []events.Sink, opts ConsumerOptions, logger *logger.Logger) *Consumer {
+	return &Consumer{js: js, store: store, notify: notify, opts: opts, logger: logger}
+}
+
+// Run consumes replicated writes until the context is cancelled
+func (c *Consumer) Run(ctx context.Context) error {
+	consumer, err := c.js.CreateOrUpdateConsumer(ctx, c.opts.Stream, jetstream.ConsumerConfig{
+		Durable:       "ocsp-replication-" + c.opts.Region,
+		FilterSubject: c.opts.SubjectPrefix + ".>",
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		MaxDeliver:    c.opts.MaxDeliver,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create replication consumer: %w", err)
+	}
+
+	consumeCtx, err := consumer.Consume(func(msg jetstream.Msg) {
+		c.handle(ctx, msg)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start replication consumer: %w", err)
+	}
+	defer consumeCtx.Stop()
+
+	c.logger.Info("Replicating status writes from other regions",
+		zap.String("region", c.opts.Region),
+		zap.String("stream", c.opts.Stream),
+	)
+	<-ctx.Done()
+	return nil
+}
+
+// Conflicts returns the most recent conflicts, newest last
+func (c *Consumer) Conflicts() []Conflict {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Conflict(nil), c.recent...)
+}
+
+func (c *Consumer) handle(ctx context.Context, msg jetstream.Msg) {
+	var m Message
+	if err := json.Unmarshal(msg.Data(), &m); err != nil || m.Serial == "" || !storage.ValidStatus(m.Status) {
+		c.logger.Warn("Rejected invalid replication message", zap.String("subject", msg.Subject()))
+		msg.Term()
+		return
+	}
+	if m.Origin == c.opts.Region {
+		msg.Ack()
+		return
+	}
+
+	if err := c.Apply(ctx, m); err != nil {
+		replicated.WithLabelValues(m.Origin, "error").Inc()
+		c.logger.Error("Failed to apply replicated status", zap.String("serial", m.Serial), zap.String("origin", m.Origin), zap.Error(err))
+		msg.NakWithDelay(retryDelay)
+		return
+	}
+	msg.Ack()
+}
+
+// Apply applies one replicated write, recording a conflict if it contradicts the local revocation
+func (c *Consumer) Apply(ctx context.Context, m Message) error {
+	local, err := c.store.Get(ctx, m.Serial)
+	if errors.Is(err, storage.ErrNotFound) {
+		local, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	remote := storage.Record{
+		Serial:     m.Serial,
+		Status:     m.Status,
+		ThisUpdate: m.Time,
+		NextUpdate: m.Time.Add(replicatedValidity),
+	}
+	if m.Status == storage.StatusRevoked {
+		remote.RevokedAt = m.RevokedAt
+		remote.RevocationReason = m.RevocationReason
+	}
+	remote.NotAfter = m.NotAfter
+	if m.ValiditySeconds != nil {
+		remote.Validity = time.Duration(*m.ValiditySeconds) * time.Second
+	}
+	if local != nil {
+		if m.NotAfter == nil {
+			remote.NotAfter = local.NotAfter
+		}
+		if m.ValiditySeconds == nil {
+			remote.Validity = local.Validity
+		}
+	}
+
+	applied, err := c.store.ApplyIfNewer(ctx, remote)
+	if err != nil {
+		return err
+	}
+	replicationLag.WithLabelValues(m.Origin).Set(time.Since(m.Time).Seconds())
+	result := "stale"
+	if applied {
+		result = "applied"
+	}
+	replicated.WithLabelValues(m.Origin, result).Inc()
+
+	if kind := conflictKind(local, &remote); kind != "" {
+		winner := "local"
+		if applied {
+			winner = "remote"
+		}
+		c.record(Conflict{
+			Serial:     m.Serial,
+			Kind:       kind,
+			Origin:     m.Origin,
+			Local:      describe(local),
+			Remote:     describe(&remote),
+			Winner:     winner,
+			DetectedAt: time.Now().UTC(),
+		})
+	}
+
+	if applied {
+		update := storage.Update{Serial: remote.Serial, Status: remote.Status, RevokedAt: remote.RevokedAt, RevocationReason: remote.RevocationReason}
+		batch := []events.Event{events.NewEvent(update, m.Time)}
+		for _, sink := range c.notify {
+			if err := sink.Publisher.Publish(ctx, batch); err != nil {
+				c.logger.Warn("Failed to notify replicated status change", zap.String("sink", sink.Name), zap.Error(err))
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Consumer) record(conflict Conflict) {
+	conflicts.WithLabelValues(conflict.Kind).Inc()
+	c.logger.Warn("Replication conflict",
+		zap.String("serial", conflict.Serial),
+		zap.String("kind", conflict.Kind),
+		zap.String("origin", conflict.Origin),
+		zap.String("local", conflict.Local),
+		zap.String("remote", conflict.Remote),
+		zap.String("winner", conflict.Winner),
+	)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recent = append(c.recent, conflict)
+	if len(c.recent) > maxConflicts {
+		c.recent = c.recent[len(c.recent)-maxConflicts:]
+	}
+}
+
+// conflictKind classifies contradictory revocations; holds and agreeing states are not conflicts
+func conflictKind(local, remote *storage.Record) string {
+	if local == nil {
+		return ""
+	}
+	localRevoked := permanentlyRevoked(local)
+	remoteRevoked := permanentlyRevoked(remote)
+
+	switch {
+	case local.Status == storage.StatusRevoked && remote.Status == storage.StatusRevoked:
+		if local.RevocationReason != remote.RevocationReason || !sameTime(local.RevokedAt, remote.RevokedAt) {
+			return ConflictReason
+		}
+	case localRevoked != remoteRevoked && (localRevoked || remoteRevoked):
+		return ConflictRevokedGood
+	}
+	return ""
+}
+
+func permanentlyRevoked(rec *storage.Record) bool {
+	return rec.Status == storage.StatusRevoked && rec.RevocationReason != revocation.ReasonCertificateHold
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Truncate(time.Second).Equal(b.Truncate(time.Second))
+}
+
+func describe(rec *storage.Record) string {
+	if rec == nil {
+		return "none"
+	}
+	if rec.Status != storage.StatusRevoked {
+		return fmt.Sprintf("%s at %s", rec.Status, rec.ThisUpdate.UTC().Format(time.RFC3339))
+	}
+	reason := rec.RevocationReason
+	if reason == "" {
+		reason = revocation.ReasonUnspecified
+	}
+	return fmt.Sprintf("revoked (%s) at %s", reason, rec.ThisUpdate.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,82 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/events"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/pkg/testsupport"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+)
+
+// capture records what is published instead of sending it to a server
+type capture struct {
+	jetstream.JetStream
+	published [][]byte
+}
+
+func (c *capture) Publish(ctx context.Context, subject string, data []byte, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	c.published = append(c.published, data)
+	return &jetstream.PubAck{}, nil
+}
+
+func TestReplicationKeepsExpiryAndValidity(t *testing.T) {
+	ctx := context.Background()
+	clock := testsupport.NewClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	now := clock.Now()
+	notAfter := now.Add(90 * 24 * time.Hour)
+
+	origin := testsupport.NewStore(clock.Now)
+	origin.Put(storage.Record{
+		Serial:     "0a1b",
+		Status:     storage.StatusGood,
+		ThisUpdate: now.Add(-time.Minute),
+		NextUpdate: now.Add(time.Hour),
+		NotAfter:   &notAfter,
+		Validity:   6 * time.Hour,
+	})
+	js := &capture{}
+	publisher := NewPublisher(js, "ocsp.replication", "eu")
+	publisher.SetSource(origin)
+	revokedAt := now
+	update := storage.Update{Serial: "0a1b", Status: storage.StatusRevoked, RevokedAt: &revokedAt, RevocationReason: "keyCompromise"}
+	if err := publisher.Publish(ctx, []events.Event{events.NewEvent(update, now)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.published) != 1 {
+		t.Fatalf("published %d messages, want 1", len(js.published))
+	}
+
+	var m Message
+	if err := json.Unmarshal(js.published[0], &m); err != nil {
+		t.Fatal(err)
+	}
+	replica := testsupport.NewStore(clock.Now)
+	consumer := NewConsumer(nil, replica, nil, ConsumerOptions{Region: "us"}, &logger.Logger{Logger: zap.NewNop()})
+	if err := consumer.Apply(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, err := replica.Get(ctx, "0a1b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != storage.StatusRevoked || rec.NotAfter == nil || !rec.NotAfter.Equal(notAfter) || rec.Validity != 6*time.Hour {
+		t.Errorf("replicated record = %+v, want revoked with not_after %s and validity 6h", rec, notAfter)
+	}
+
+	// A message from a publisher without a source keeps what the replica already stores
+	clock.Advance(time.Minute)
+	m.Time, m.NotAfter, m.ValiditySeconds = clock.Now(), nil, nil
+	if err := consumer.Apply(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	if rec, _ := replica.Get(ctx, "0a1b"); rec.NotAfter == nil || !rec.NotAfter.Equal(notAfter) || rec.Validity != 6*time.Hour {
+		t.Errorf("record after a message without details = %+v", rec)
+	}
+}
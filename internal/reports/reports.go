@@ -0,0 +1,233 @@
+package reports
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// Report summarizes responder activity over a period
+type Report struct {
+	Service            string        `json:"service"`
+	PeriodStart        time.Time     `json:"period_start"`
+	PeriodEnd          time.Time     `json:"period_end"`
+	Revocations        int64         `json:"revocations"`
+	TopReasons         []ReasonCount `json:"top_reasons"`
+	TotalStatuses      int64         `json:"total_statuses"`
+	RequestVolume      int64         `json:"request_volume"`
+	RequestErrors      int64         `json:"request_errors"`
+	ErrorRate          float64       `json:"error_rate"`
+	EarliestNextUpdate *time.Time    `json:"earliest_next_update,omitempty"`
+	GeneratedAt        time.Time     `json:"generated_at"`
+}
+
+// ReasonCount is the number of revocations for one reason
+type ReasonCount struct {
+	Reason string `json:"reason"`
+	Count  int64  `json:"count"`
+}
+
+// EmailGateway describes an HTTP email gateway that accepts JSON messages
+type EmailGateway struct {
+	URL  string
+	From string
+	To   []string
+}
+
+// Options configures the reporting job
+type Options struct {
+	Service    string
+	Interval   time.Duration
+	TopReasons int
+	WebhookURL string
+	Email      EmailGateway
+}
+
+// Scheduler periodically generates reports and delivers them
+type Scheduler struct {
+	db     *pgxpool.Pool
+	logger *logger.Logger
+	opts   Options
+	client *http.Client
+
+	lastRun                  time.Time
+	lastRequests, lastErrors int64
+}
+
+// NewScheduler creates a new report scheduler
+func NewScheduler(db *pgxpool.Pool, logger *logger.Logger, opts Options) *Scheduler {
+	if opts.TopReasons <= 0 {
+		opts.TopReasons = 5
+	}
+	return &Scheduler{
+		db:      db,
+		logger:  logger,
+		opts:    opts,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		lastRun: time.Now(),
+	}
+}
+
+// Run produces and delivers a report every interval until the context is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := s.Generate(ctx, s.lastRun, time.Now())
+		if err != nil {
+			s.logger.Error("Failed to generate operational report", zap.Error(err))
+			continue
+		}
+		s.lastRun = report.PeriodEnd
+
+		if err := s.deliver(ctx, report); err != nil {
+			s.logger.Error("Failed to deliver operational report", zap.Error(err))
+			continue
+		}
+		s.logger.Info("Operational report delivered",
+			zap.Time("period_start", report.PeriodStart),
+			zap.Time("period_end", report.PeriodEnd),
+		)
+	}
+}
+
+// Generate builds a report for the given period
+func (s *Scheduler) Generate(ctx context.Context, from, to time.Time) (*Report, error) {
+	report := &Report{
+		Service:     s.opts.Service,
+		PeriodStart: from,
+		PeriodEnd:   to,
+		GeneratedAt: time.Now(),
+	}
+
+	summaryQuery := `
+		SELECT
+			COUNT(*) FILTER (WHERE status = 'revoked' AND revoked_at >= $1 AND revoked_at < $2),
+			COUNT(*),
+			MIN(next_update)
+		FROM ocsp_responses
+	`
+	err := s.db.QueryRow(ctx, summaryQuery, from, to).Scan(
+		&report.Revocations,
+		&report.TotalStatuses,
+		&report.EarliestNextUpdate,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query status summary: %w", err)
+	}
+
+	reasonsQuery := `
+		SELECT COALESCE(NULLIF(revocation_reason, ''), 'unspecified'), COUNT(*)
+		FROM ocsp_responses
+		WHERE status = 'revoked' AND revoked_at >= $1 AND revoked_at < $2
+		GROUP BY 1
+		ORDER BY 2 DESC
+		LIMIT $3
+	`
+	rows, err := s.db.Query(ctx, reasonsQuery, from, to, s.opts.TopReasons)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query revocation reasons: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rc ReasonCount
+		if err := rows.Scan(&rc.Reason, &rc.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan revocation reason: %w", err)
+		}
+		report.TopReasons = append(report.TopReasons, rc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read revocation reasons: %w", err)
+	}
+
+	// Request counters are process-local, so volume covers this replica since the last report
+	total, errors := metrics.RequestTotals()
+	report.RequestVolume = total - s.lastRequests
+	report.RequestErrors = errors - s.lastErrors
+	s.lastRequests, s.lastErrors = total, errors
+	if report.RequestVolume > 0 {
+		report.ErrorRate = float64(report.RequestErrors) / float64(report.RequestVolume)
+	}
+
+	return report, nil
+}
+
+func (s *Scheduler) deliver(ctx context.Context, report *Report) error {
+	if s.opts.WebhookURL != "" {
+		if err := s.post(ctx, s.opts.WebhookURL, report); err != nil {
+			return fmt.Errorf("webhook delivery failed: %w", err)
+		}
+	}
+
+	if s.opts.Email.URL != "" {
+		message := map[string]interface{}{
+			"from":    s.opts.Email.From,
+			"to":      s.opts.Email.To,
+			"subject": fmt.Sprintf("[%s] OCSP report %s - %s", s.opts.Service, report.PeriodStart.Format("2006-01-02"), report.PeriodEnd.Format("2006-01-02")),
+			"text":    report.Text(),
+		}
+		if err := s.post(ctx, s.opts.Email.URL, message); err != nil {
+			return fmt.Errorf("email gateway delivery failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *Scheduler) post(ctx context.Context, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Text renders the report as plain text for email delivery
+func (r *Report) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "OCSP operational report for %s\n", r.Service)
+	fmt.Fprintf(&b, "Period: %s to %s\n\n", r.PeriodStart.Format(time.RFC3339), r.PeriodEnd.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Revocations in period: %d\n", r.Revocations)
+	for _, rc := range r.TopReasons {
+		fmt.Fprintf(&b, "  %-24s %d\n", rc.Reason, rc.Count)
+	}
+	fmt.Fprintf(&b, "Certificates tracked: %d\n", r.TotalStatuses)
+	fmt.Fprintf(&b, "Requests served: %d (errors: %d, rate %.4f)\n", r.RequestVolume, r.RequestErrors, r.ErrorRate)
+	if r.EarliestNextUpdate != nil {
+		fmt.Fprintf(&b, "Earliest nextUpdate: %s\n", r.EarliestNextUpdate.Format(time.RFC3339))
+	}
+	return b.String()
+}
@@ -0,0 +1,115 @@
+package responselog
+
+import (
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/revocation"
+)
+
+// The RFC 6960 structures, decoded without verifying the signature: the log records what was
+// signed, and golang.org/x/crypto/ocsp would check the signature against an embedded responder
+// certificate on every parse
+type responseASN1 struct {
+	Status   asn1.Enumerated
+	Response responseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type responseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type basicResponse struct {
+	TBSResponseData    responseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseData struct {
+	Version            int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID     asn1.RawValue
+	ProducedAt         time.Time `asn1:"generalized"`
+	Responses          []singleResponse
+	ResponseExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type singleResponse struct {
+	CertID           certID
+	Good             asn1.Flag        `asn1:"tag:0,optional"`
+	Revoked          revokedInfo      `asn1:"tag:1,optional"`
+	Unknown          asn1.Flag        `asn1:"tag:2,optional"`
+	ThisUpdate       time.Time        `asn1:"generalized"`
+	NextUpdate       time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	SingleExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type certID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type revokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+var oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+// Parse decodes the entry for a signed response. The responder signs one SingleResponse per
+// response; others are rejected
+func Parse(der []byte) (Entry, error) {
+	var resp responseASN1
+	if rest, err := asn1.Unmarshal(der, &resp); err != nil {
+		return Entry{}, fmt.Errorf("parse response: %w", err)
+	} else if len(rest) > 0 {
+		return Entry{}, errors.New("parse response: trailing data")
+	}
+	if resp.Status != 0 || !resp.Response.ResponseType.Equal(oidOCSPBasic) {
+		return Entry{}, errors.New("parse response: not a successful basic response")
+	}
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return Entry{}, fmt.Errorf("parse basic response: %w", err)
+	}
+	tbs := basic.TBSResponseData
+	if len(tbs.Responses) != 1 {
+		return Entry{}, fmt.Errorf("parse basic response: %d single responses, want 1", len(tbs.Responses))
+	}
+	single := tbs.Responses[0]
+
+	signatureHash := sha256.Sum256(basic.Signature.RightAlign())
+	entry := Entry{
+		HashAlgorithm:   single.CertID.HashAlgorithm.Algorithm.String(),
+		IssuerNameHash:  hex.EncodeToString(single.CertID.NameHash),
+		IssuerKeyHash:   hex.EncodeToString(single.CertID.IssuerKeyHash),
+		Serial:          single.CertID.SerialNumber.Text(16),
+		ProducedAt:      tbs.ProducedAt.UTC(),
+		ThisUpdate:      single.ThisUpdate.UTC(),
+		SignatureSHA256: hex.EncodeToString(signatureHash[:]),
+	}
+	if !single.NextUpdate.IsZero() {
+		next := single.NextUpdate.UTC()
+		entry.NextUpdate = &next
+	}
+	switch {
+	case bool(single.Good):
+		entry.Status = "good"
+	case !single.Revoked.RevocationTime.IsZero():
+		revokedAt := single.Revoked.RevocationTime.UTC()
+		entry.Status, entry.RevokedAt = "revoked", &revokedAt
+		entry.RevocationReason = revocation.ReasonName(int(single.Revoked.Reason))
+	default:
+		entry.Status = "unknown"
+	}
+	return entry, nil
+}
@@ -0,0 +1,77 @@
+package responselog
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MaxLimit bounds the entries returned by one query
+const MaxLimit = 1000
+
+var columns = []string{
+	"source", "hash_algorithm", "issuer_name_hash", "issuer_key_hash", "serial", "status", "revoked_at",
+	"revocation_reason", "produced_at", "this_update", "next_update", "signature_sha256", "key_id",
+}
+
+// Postgres keeps entries in the append-only response_log table
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgres creates a Postgres response log
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Insert copies entries into the log; logged_at and id are assigned by the database
+func (p *Postgres) Insert(ctx context.Context, entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	rows := make([][]interface{}, len(entries))
+	for i, e := range entries {
+		rows[i] = []interface{}{
+			e.Source, e.HashAlgorithm, e.IssuerNameHash, e.IssuerKeyHash, e.Serial, e.Status, e.RevokedAt,
+			e.RevocationReason, e.ProducedAt, e.ThisUpdate, e.NextUpdate, e.SignatureSHA256, e.KeyID,
+		}
+	}
+	_, err := p.db.CopyFrom(ctx, pgx.Identifier{"response_log"}, columns, pgx.CopyFromRows(rows))
+	return err
+}
+
+// Query returns the entries of filter.Serial, oldest first
+func (p *Postgres) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	var at *time.Time
+	if !filter.At.IsZero() {
+		utc := filter.At.UTC()
+		at = &utc
+	}
+	rows, err := p.db.Query(ctx, `
+		SELECT id, logged_at, source, hash_algorithm, issuer_name_hash, issuer_key_hash, serial, status,
+			revoked_at, revocation_reason, produced_at, this_update, next_update, signature_sha256, key_id
+		FROM response_log
+		WHERE serial = $1 AND id > $2
+			AND ($3::TIMESTAMP IS NULL OR (this_update <= $3 AND (next_update IS NULL OR next_update > $3)))
+		ORDER BY id
+		LIMIT $4
+	`, filter.Serial, filter.AfterID, at, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.ID, &e.LoggedAt, &e.Source, &e.HashAlgorithm, &e.IssuerNameHash, &e.IssuerKeyHash,
+			&e.Serial, &e.Status, &e.RevokedAt, &e.RevocationReason, &e.ProducedAt, &e.ThisUpdate, &e.NextUpdate,
+			&e.SignatureSHA256, &e.KeyID); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,125 @@
+// Package responselog keeps an append-only record of every response the responder signs: its
+// CertID, status, validity period, signature hash and signing key, decoded from the signed
+// bytes themselves, so what the responder asserted about a certificate at any time can be
+// shown later
+package responselog
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Sources of logged responses
+const (
+	// SourceRefresh responses were signed into the precomputed table
+	SourceRefresh = "refresh"
+	// SourceOnDemand responses were signed for one request
+	SourceOnDemand = "on_demand"
+)
+
+var appended = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "response_log_entries_total",
+	Help:      "Signed responses recorded in the response log, by source: refresh or on_demand.",
+}, []string{"source"})
+
+func init() {
+	metrics.Registry.MustRegister(appended)
+}
+
+// Entry is one logged response. Hashes are in hex
+type Entry struct {
+	ID       int64     `json:"id"`
+	LoggedAt time.Time `json:"logged_at"`
+	Source   string    `json:"source"`
+	// HashAlgorithm is the OID of the CertID hash algorithm
+	HashAlgorithm    string     `json:"hash_algorithm"`
+	IssuerNameHash   string     `json:"issuer_name_hash"`
+	IssuerKeyHash    string     `json:"issuer_key_hash"`
+	Serial           string     `json:"serial"`
+	Status           string     `json:"status"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RevocationReason string     `json:"revocation_reason,omitempty"`
+	ProducedAt       time.Time  `json:"produced_at"`
+	ThisUpdate       time.Time  `json:"this_update"`
+	NextUpdate       *time.Time `json:"next_update,omitempty"`
+	// SignatureSHA256 is the SHA-256 of the response's signature value
+	SignatureSHA256 string `json:"signature_sha256"`
+	// KeyID is the SHA-1 of the public key that signed the response, as in a byKey ResponderID
+	KeyID string `json:"key_id"`
+}
+
+// Filter selects the logged responses of one serial
+type Filter struct {
+	Serial string
+	// At, when set, keeps the responses valid at that time
+	At time.Time
+	// AfterID continues a listing after the last entry of the previous page
+	AfterID int64
+	Limit   int
+}
+
+// Log appends signed responses
+type Log struct {
+	db *Postgres
+}
+
+// NewLog creates a response log
+func NewLog(db *Postgres) *Log {
+	return &Log{db: db}
+}
+
+// Append records responses from source signed with the private half of key. Responses must be
+// logged before they are served, so an error should keep them from being served
+func (l *Log) Append(ctx context.Context, source string, key crypto.PublicKey, ders ...[]byte) error {
+	keyID, err := KeyID(key)
+	if err != nil {
+		return err
+	}
+	entries := make([]Entry, 0, len(ders))
+	for _, der := range ders {
+		entry, err := Parse(der)
+		if err != nil {
+			return err
+		}
+		entry.Source, entry.KeyID = source, keyID
+		entries = append(entries, entry)
+	}
+	if err := l.db.Insert(ctx, entries); err != nil {
+		return err
+	}
+	appended.WithLabelValues(source).Add(float64(len(entries)))
+	return nil
+}
+
+// Query returns logged responses oldest first
+func (l *Log) Query(ctx context.Context, filter Filter) ([]Entry, error) {
+	return l.db.Query(ctx, filter)
+}
+
+// KeyID returns the SHA-1 of a public key's subjectPublicKey bit string in hex, as in a byKey
+// ResponderID
+func KeyID(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(spki.PublicKey.RightAlign())
+	return hex.EncodeToString(sum[:]), nil
+}
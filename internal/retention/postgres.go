@@ -0,0 +1,62 @@
+package retention
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Postgres purges rows in batches, so no statement holds locks on many rows for long
+type Postgres struct {
+	db        *pgxpool.Pool
+	batchSize int
+}
+
+// NewPostgres creates a Postgres purger deleting at most batchSize rows per statement
+func NewPostgres(db *pgxpool.Pool, batchSize int) *Postgres {
+	return &Postgres{db: db, batchSize: batchSize}
+}
+
+// PurgeStatuses deletes the statuses of certificates that expired before cutoff. Statuses
+// whose expiry was never recorded are kept
+func (p *Postgres) PurgeStatuses(ctx context.Context, cutoff time.Time) (int64, error) {
+	return p.purge(ctx, `
+		DELETE FROM ocsp_responses
+		WHERE serial IN (
+			SELECT serial FROM ocsp_responses
+			WHERE not_after IS NOT NULL AND not_after < $1
+			LIMIT $2
+		)
+	`, cutoff)
+}
+
+// PurgeAudit deletes audit records written before cutoff
+func (p *Postgres) PurgeAudit(ctx context.Context, cutoff time.Time) (int64, error) {
+	return p.purge(ctx, `
+		DELETE FROM audit_log
+		WHERE id IN (
+			SELECT id FROM audit_log
+			WHERE recorded_at < $1
+			ORDER BY id
+			LIMIT $2
+		)
+	`, cutoff)
+}
+
+func (p *Postgres) purge(ctx context.Context, query string, cutoff time.Time) (int64, error) {
+	var total int64
+	for {
+		tag, err := p.db.Exec(ctx, query, cutoff.UTC(), p.batchSize)
+		if err != nil {
+			return total, err
+		}
+		total += tag.RowsAffected()
+		if tag.RowsAffected() < int64(p.batchSize) {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Package retention enforces how long each class of data is kept: statuses of expired
+// certificates, audit records and archived CRLs and snapshots are purged once older than the
+// configured period, and every run is reported
+package retention
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Data classes, used in reports and metric labels
+const (
+	ClassStatuses = "statuses"
+	ClassAudit    = "audit"
+	ClassArchive  = "archive"
+)
+
+var purged = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "retention_purged_total",
+	Help:      "Rows or objects deleted by the retention policy, by data class.",
+}, []string{"class"})
+
+func init() {
+	metrics.Registry.MustRegister(purged)
+}
+
+// Purger deletes the data in one class that is older than cutoff and reports how much it deleted
+type Purger func(ctx context.Context, cutoff time.Time) (int64, error)
+
+// Class is one data class and how long it is kept
+type Class struct {
+	Name  string
+	Keep  time.Duration
+	Purge Purger
+}
+
+// ClassReport is what one run purged from a class
+type ClassReport struct {
+	Class  string    `json:"class"`
+	Keep   string    `json:"keep"`
+	Cutoff time.Time `json:"cutoff"`
+	Purged int64     `json:"purged"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Report describes one run of the policy
+type Report struct {
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Classes    []ClassReport `json:"classes"`
+}
+
+// Enforcer applies the policy to each class in turn
+type Enforcer struct {
+	classes []Class
+	logger  *logger.Logger
+	last    atomic.Pointer[Report]
+}
+
+// NewEnforcer creates an enforcer for classes
+func NewEnforcer(classes []Class, logger *logger.Logger) *Enforcer {
+	return &Enforcer{classes: classes, logger: logger}
+}
+
+// Enforce purges every class once. A failing class is reported and does not stop the others
+func (e *Enforcer) Enforce(ctx context.Context) *Report {
+	report := &Report{StartedAt: time.Now().UTC()}
+	for _, class := range e.classes {
+		cr := ClassReport{Class: class.Name, Keep: class.Keep.String(), Cutoff: report.StartedAt.Add(-class.Keep)}
+		n, err := class.Purge(ctx, cr.Cutoff)
+		cr.Purged = n
+		purged.WithLabelValues(class.Name).Add(float64(n))
+		if err != nil {
+			cr.Error = err.Error()
+			e.logger.Error("Retention purge failed", zap.String("class", class.Name), zap.Int64("purged", n), zap.Error(err))
+		} else {
+			e.logger.Info("Retention purge completed", zap.String("class", class.Name), zap.Time("cutoff", cr.Cutoff), zap.Int64("purged", n))
+		}
+		report.Classes = append(report.Classes, cr)
+	}
+	report.FinishedAt = time.Now().UTC()
+	e.last.Store(report)
+	return report
+}
+
+// Last returns the report of the latest run, or nil before the first
+func (e *Enforcer) Last() *Report {
+	return e.last.Load()
+}
+
+// Run enforces the policy immediately and then on every interval until ctx is cancelled
+func (e *Enforcer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		e.Enforce(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
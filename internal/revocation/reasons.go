@@ -0,0 +1,75 @@
+package revocation
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gigvault/shared/pkg/models"
+)
+
+// RFC 5280 revocation reason names as stored in revocation_reason
+const (
+	ReasonUnspecified          = "unspecified"
+	ReasonKeyCompromise        = "keyCompromise"
+	ReasonCACompromise         = "cACompromise"
+	ReasonAffiliationChanged   = "affiliationChanged"
+	ReasonSuperseded           = "superseded"
+	ReasonCessationOfOperation = "cessationOfOperation"
+	ReasonCertificateHold      = "certificateHold"
+	ReasonRemoveFromCRL        = "removeFromCRL"
+	ReasonPrivilegeWithdrawn   = "privilegeWithdrawn"
+	ReasonAACompromise         = "aACompromise"
+)
+
+var reasonNames = map[int]string{
+	models.ReasonUnspecified:          ReasonUnspecified,
+	models.ReasonKeyCompromise:        ReasonKeyCompromise,
+	models.ReasonCACompromise:         ReasonCACompromise,
+	models.ReasonAffiliationChanged:   ReasonAffiliationChanged,
+	models.ReasonSuperseded:           ReasonSuperseded,
+	models.ReasonCessationOfOperation: ReasonCessationOfOperation,
+	models.ReasonCertificateHold:      ReasonCertificateHold,
+	models.ReasonRemoveFromCRL:        ReasonRemoveFromCRL,
+	models.ReasonPrivilegeWithdrawn:   ReasonPrivilegeWithdrawn,
+	models.ReasonAACompromise:         ReasonAACompromise,
+}
+
+var reasonCodes = func() map[string]int {
+	codes := make(map[string]int, len(reasonNames))
+	for code, name := range reasonNames {
+		codes[name] = code
+	}
+	return codes
+}()
+
+// ReasonName returns the RFC 5280 name for a reason code, or unspecified for unknown codes
+func ReasonName(code int) string {
+	if name, ok := reasonNames[code]; ok {
+		return name
+	}
+	return ReasonUnspecified
+}
+
+// ReasonCode returns the RFC 5280 code for a reason name
+func ReasonCode(name string) (int, bool) {
+	code, ok := reasonCodes[name]
+	return code, ok
+}
+
+// ParseReason normalizes a reason given as an RFC 5280 name (in any case) or numeric code
+func ParseReason(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return ReasonUnspecified, true
+	}
+	if code, err := strconv.Atoi(value); err == nil {
+		name, ok := reasonNames[code]
+		return name, ok
+	}
+	for name := range reasonCodes {
+		if strings.EqualFold(name, value) {
+			return name, true
+		}
+	}
+	return "", false
+}
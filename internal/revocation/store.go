@@ -0,0 +1,141 @@
+package revocation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+// TransitionError is returned for a write CheckTransition refuses
+type TransitionError struct {
+	Serial string
+	Err    error
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Serial, e.Err)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return e.Err
+}
+
+type overrideKey struct{}
+
+// WithOverride returns a context whose writes may leave a permanent revocation, for operators
+// correcting a mistaken one. Revocations themselves are still checked
+func WithOverride(ctx context.Context) context.Context {
+	return context.WithValue(ctx, overrideKey{}, true)
+}
+
+// Overridden reports whether ctx was returned by WithOverride
+func Overridden(ctx context.Context) bool {
+	override, _ := ctx.Value(overrideKey{}).(bool)
+	return override
+}
+
+// batchReader reads the stored statuses of many serials in one query; serials without a status
+// are missing from the result
+type batchReader interface {
+	GetMany(ctx context.Context, serials []string) (map[string]*storage.Record, error)
+}
+
+// Store refuses writes that break the revocation rules of CheckTransition, comparing each
+// update with the stored status
+type Store struct {
+	storage.Store
+}
+
+// NewStore wraps store
+func NewStore(store storage.Store) *Store {
+	return &Store{Store: store}
+}
+
+// Upsert returns a *TransitionError instead of writing an update CheckTransition refuses
+func (s *Store) Upsert(ctx context.Context, update storage.Update) error {
+	if err := s.check(ctx, []storage.Update{update}); err != nil {
+		return err
+	}
+	return s.Store.Upsert(ctx, update)
+}
+
+// ApplyBatch returns a *TransitionError, writing nothing, when any update is refused
+func (s *Store) ApplyBatch(ctx context.Context, updates []storage.Update) error {
+	if err := s.check(ctx, updates); err != nil {
+		return err
+	}
+	return s.Store.ApplyBatch(ctx, updates)
+}
+
+// InsertMissing seeds statuses through the wrapped store, which must be a storage.Seeder
+func (s *Store) InsertMissing(ctx context.Context, updates []storage.Update) (int, error) {
+	seeder, ok := s.Store.(storage.Seeder)
+	if !ok {
+		return 0, fmt.Errorf("store does not support seeding")
+	}
+	return seeder.InsertMissing(ctx, updates)
+}
+
+// ApplyIfNewer applies a replicated status through the wrapped store, which must be a
+// storage.Replica. Replicated statuses were checked where they were written
+func (s *Store) ApplyIfNewer(ctx context.Context, rec storage.Record) (bool, error) {
+	replica, ok := s.Store.(storage.Replica)
+	if !ok {
+		return false, fmt.Errorf("store does not support replication")
+	}
+	return replica.ApplyIfNewer(ctx, rec)
+}
+
+// check applies CheckTransition to updates in order, each against the stored status or the
+// update before it to the same serial
+func (s *Store) check(ctx context.Context, updates []storage.Update) error {
+	override := Overridden(ctx)
+	current, err := s.current(ctx, updates)
+	if err != nil {
+		return err
+	}
+	for _, update := range updates {
+		from := current[update.Serial]
+		if override {
+			from = nil
+		}
+		if err := CheckTransition(from, update); err != nil {
+			return &TransitionError{Serial: update.Serial, Err: err}
+		}
+		current[update.Serial] = &storage.Record{
+			Serial:           update.Serial,
+			Status:           update.Status,
+			RevokedAt:        update.RevokedAt,
+			RevocationReason: update.RevocationReason,
+		}
+	}
+	return nil
+}
+
+func (s *Store) current(ctx context.Context, updates []storage.Update) (map[string]*storage.Record, error) {
+	if reader, ok := s.Store.(batchReader); ok && len(updates) > 1 {
+		serials := make([]string, len(updates))
+		for i, update := range updates {
+			serials[i] = update.Serial
+		}
+		return reader.GetMany(ctx, serials)
+	}
+
+	current := make(map[string]*storage.Record, len(updates))
+	for _, update := range updates {
+		if _, ok := current[update.Serial]; ok {
+			continue
+		}
+		rec, err := s.Store.Get(ctx, update.Serial)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read status of %s: %w", update.Serial, err)
+		}
+		current[update.Serial] = rec
+	}
+	return current, nil
+}
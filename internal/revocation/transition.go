@@ -0,0 +1,41 @@
+package revocation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+// CheckTransition reports whether changing a serial from its current record (nil when it has
+// none) to the update breaks RFC 5280 revocation rules: revocation is permanent except for
+// certificateHold, and removeFromCRL only has meaning inside delta CRLs
+func CheckTransition(from *storage.Record, to storage.Update) error {
+	if to.Status == storage.StatusRevoked {
+		if to.RevocationReason == ReasonRemoveFromCRL {
+			return fmt.Errorf("%s is only valid in delta CRLs, not as a stored revocation reason", ReasonRemoveFromCRL)
+		}
+		if to.RevokedAt != nil && to.RevokedAt.After(time.Now().Add(time.Minute)) {
+			return fmt.Errorf("revocation time %s is in the future", to.RevokedAt.UTC().Format(time.RFC3339))
+		}
+	}
+
+	if from == nil || from.Status != storage.StatusRevoked {
+		return nil
+	}
+	reason := from.RevocationReason
+	if reason == "" {
+		reason = ReasonUnspecified
+	}
+	if reason == ReasonCertificateHold {
+		return nil
+	}
+
+	switch {
+	case to.Status != storage.StatusRevoked:
+		return fmt.Errorf("%s revocation is permanent; only %s can be released", reason, ReasonCertificateHold)
+	case to.RevocationReason == ReasonCertificateHold:
+		return fmt.Errorf("a certificate revoked for %s cannot be placed on hold", reason)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// listLimit bounds how many revocations List returns
+const listLimit = 500
+
+// Postgres keeps scheduled revocations in the scheduled_revocations table so every replica sees
+// them and whichever leads applies them
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgres creates a Postgres scheduled revocation store
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Create stores a pending revocation and returns its ID. It returns ErrAlreadyScheduled when
+// the serial already has one pending
+func (p *Postgres) Create(ctx context.Context, serial, reason string, effectiveAt time.Time, requestedBy string) (int64, error) {
+	var id int64
+	err := p.db.QueryRow(ctx, `
+		INSERT INTO scheduled_revocations (state, serial, revocation_reason, effective_at, requested_by)
+		SELECT $1, $2, $3, $4, $5
+		WHERE NOT EXISTS (
+			SELECT 1 FROM scheduled_revocations WHERE serial = $2 AND state IN ($1, $6)
+		)
+		RETURNING id
+	`, StatePending, serial, reason, effectiveAt.UTC(), requestedBy, StateApplying).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, ErrAlreadyScheduled
+	}
+	return id, err
+}
+
+const selectRevocation = `SELECT id, state, serial, revocation_reason, effective_at, requested_by, requested_at, COALESCE(decided_by, ''), decided_at, COALESCE(error, '') FROM scheduled_revocations`
+
+// Get returns one revocation, or ErrNotFound
+func (p *Postgres) Get(ctx context.Context, id int64) (*Revocation, error) {
+	rev, err := scanRevocation(p.db.QueryRow(ctx, selectRevocation+` WHERE id = $1`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return rev, err
+}
+
+// List returns the newest revocations in a state, or in any state when state is empty
+func (p *Postgres) List(ctx context.Context, state string) ([]Revocation, error) {
+	return p.query(ctx, selectRevocation+`
+		WHERE $1 = '' OR state = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, state, listLimit)
+}
+
+// Due returns up to limit pending revocations effective at or before now, earliest first
+func (p *Postgres) Due(ctx context.Context, now time.Time, limit int) ([]Revocation, error) {
+	return p.query(ctx, selectRevocation+`
+		WHERE state = $1 AND effective_at <= $2
+		ORDER BY effective_at, id
+		LIMIT $3
+	`, StatePending, now.UTC(), limit)
+}
+
+// Transition moves a revocation from one state to another, recording decidedBy and the error
+// message when set. It returns ErrNotPending when the revocation is not in state from
+func (p *Postgres) Transition(ctx context.Context, id int64, from, to, decidedBy, message string) error {
+	tag, err := p.db.Exec(ctx, `
+		UPDATE scheduled_revocations SET
+			state = $3,
+			decided_by = COALESCE(NULLIF($4, ''), decided_by),
+			decided_at = CASE WHEN $3 IN ($5, $6, $7) THEN NOW() ELSE decided_at END,
+			error = NULLIF($8, '')
+		WHERE id = $1 AND state = $2
+	`, id, from, to, decidedBy, StateApplied, StateCancelled, StateFailed, message)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		if _, err := p.Get(ctx, id); err != nil {
+			return err
+		}
+		return ErrNotPending
+	}
+	return nil
+}
+
+func (p *Postgres) query(ctx context.Context, sql string, args ...interface{}) ([]Revocation, error) {
+	rows, err := p.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revocations []Revocation
+	for rows.Next() {
+		rev, err := scanRevocation(rows)
+		if err != nil {
+			return nil, err
+		}
+		revocations = append(revocations, *rev)
+	}
+	return revocations, rows.Err()
+}
+
+func scanRevocation(row pgx.Row) (*Revocation, error) {
+	var rev Revocation
+	if err := row.Scan(&rev.ID, &rev.State, &rev.Serial, &rev.Reason, &rev.EffectiveAt, &rev.RequestedBy, &rev.RequestedAt, &rev.DecidedBy, &rev.DecidedAt, &rev.Error); err != nil {
+		return nil, err
+	}
+	rev.EffectiveAt = rev.EffectiveAt.UTC()
+	return &rev, nil
+}
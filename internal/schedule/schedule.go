@@ -0,0 +1,247 @@
+// Package schedule holds revocations submitted ahead of the time they take effect, such as the
+// end of a migration window. They wait as pending until effective_at, when the scheduler applies
+// them with that time as the revocation time, and can be cancelled until then
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Revocation states
+const (
+	StatePending   = "pending"
+	StateApplying  = "applying"
+	StateApplied   = "applied"
+	StateCancelled = "cancelled"
+	StateFailed    = "failed"
+)
+
+var (
+	// ErrNotFound is returned for unknown revocation IDs
+	ErrNotFound = errors.New("scheduled revocation not found")
+	// ErrNotPending is returned when a revocation was already applied, cancelled or failed, or
+	// is being applied
+	ErrNotPending = errors.New("scheduled revocation is no longer pending")
+	// ErrAlreadyScheduled is returned when the serial already has a pending revocation
+	ErrAlreadyScheduled = errors.New("serial already has a pending scheduled revocation")
+	// ErrNeedsApproval is returned for revocations the approval policy would stage, which must
+	// be submitted when they are to take effect so they can be approved
+	ErrNeedsApproval = errors.New("revocations that need a second approval cannot be scheduled")
+	// ErrInvalid is wrapped by the errors for revocations that cannot be scheduled as given
+	ErrInvalid = errors.New("invalid scheduled revocation")
+)
+
+var outcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "scheduled_revocations_total",
+	Help:      "Scheduled revocations, by outcome: scheduled, applied, cancelled or failed.",
+}, []string{"outcome"})
+
+func init() {
+	metrics.Registry.MustRegister(outcomes)
+}
+
+// Revocation is a revocation waiting for, or past, the time it takes effect
+type Revocation struct {
+	ID          int64      `json:"id"`
+	State       string     `json:"state"`
+	Serial      string     `json:"serial"`
+	Reason      string     `json:"revocation_reason"`
+	EffectiveAt time.Time  `json:"effective_at"`
+	RequestedBy string     `json:"requested_by,omitempty"`
+	RequestedAt time.Time  `json:"requested_at"`
+	DecidedBy   string     `json:"decided_by,omitempty"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// ApprovalGate reports whether updates would be staged for a second approval
+type ApprovalGate interface {
+	RequiresApproval(updates []storage.Update) bool
+}
+
+// Scheduler accepts scheduled revocations and applies them once they are due
+type Scheduler struct {
+	db         *Postgres
+	store      storage.Store
+	approvals  ApprovalGate
+	maxHorizon time.Duration
+	batchSize  int
+	logger     *logger.Logger
+}
+
+// NewScheduler creates a scheduler applying revocations to store. Revocations may be scheduled
+// at most maxHorizon ahead; each run applies at most batchSize of them
+func NewScheduler(db *Postgres, store storage.Store, maxHorizon time.Duration, batchSize int, logger *logger.Logger) *Scheduler {
+	return &Scheduler{db: db, store: store, maxHorizon: maxHorizon, batchSize: batchSize, logger: logger}
+}
+
+// SetApprovalGate makes Schedule refuse revocations the approval policy would stage, since
+// applying them later would bypass it
+func (s *Scheduler) SetApprovalGate(gate ApprovalGate) {
+	s.approvals = gate
+}
+
+// Schedule stores a revocation of serial taking effect at effectiveAt on behalf of the principal
+// in ctx. serial must be normalized and reason a known RFC 5280 reason name
+func (s *Scheduler) Schedule(ctx context.Context, serial, reason string, effectiveAt time.Time) (*Revocation, error) {
+	now := time.Now()
+	switch {
+	case !effectiveAt.After(now):
+		return nil, fmt.Errorf("%w: effective_at must be in the future; revoke immediately instead", ErrInvalid)
+	case effectiveAt.Sub(now) > s.maxHorizon:
+		return nil, fmt.Errorf("%w: effective_at is more than %s ahead", ErrInvalid, s.maxHorizon)
+	case reason == revocation.ReasonCertificateHold:
+		return nil, fmt.Errorf("%w: a hold cannot be scheduled", ErrInvalid)
+	}
+
+	update := storage.Update{Serial: serial, Status: storage.StatusRevoked, RevocationReason: reason}
+	if s.approvals != nil && s.approvals.RequiresApproval([]storage.Update{update}) {
+		return nil, ErrNeedsApproval
+	}
+	if err := s.check(ctx, update); err != nil {
+		return nil, err
+	}
+
+	id, err := s.db.Create(ctx, serial, reason, effectiveAt, approval.PrincipalFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	outcomes.WithLabelValues("scheduled").Inc()
+	s.logger.Info("Scheduled revocation",
+		zap.Int64("id", id),
+		zap.String("serial", serial),
+		zap.String("reason", reason),
+		zap.Time("effective_at", effectiveAt),
+	)
+	return s.db.Get(ctx, id)
+}
+
+// check returns why update cannot be applied to the current status of its serial, wrapping
+// ErrInvalid
+func (s *Scheduler) check(ctx context.Context, update storage.Update) error {
+	current, err := s.store.Get(ctx, update.Serial)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.Status == storage.StatusRevoked && current.RevocationReason != revocation.ReasonCertificateHold {
+		return fmt.Errorf("%w: %s is already revoked", ErrInvalid, update.Serial)
+	}
+	if err := revocation.CheckTransition(current, update); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return nil
+}
+
+// Cancel withdraws a pending revocation on behalf of the principal in ctx
+func (s *Scheduler) Cancel(ctx context.Context, id int64) (*Revocation, error) {
+	if err := s.db.Transition(ctx, id, StatePending, StateCancelled, approval.PrincipalFrom(ctx), ""); err != nil {
+		return nil, err
+	}
+	outcomes.WithLabelValues("cancelled").Inc()
+	s.logger.Info("Cancelled scheduled revocation", zap.Int64("id", id), zap.String("cancelled_by", approval.PrincipalFrom(ctx)))
+	return s.db.Get(ctx, id)
+}
+
+// Get returns one revocation
+func (s *Scheduler) Get(ctx context.Context, id int64) (*Revocation, error) {
+	return s.db.Get(ctx, id)
+}
+
+// List returns revocations in a state, newest first; an empty state lists every revocation
+func (s *Scheduler) List(ctx context.Context, state string) ([]Revocation, error) {
+	return s.db.List(ctx, state)
+}
+
+// Run applies due revocations immediately and then on every interval until ctx is cancelled
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Activate(ctx); err != nil {
+			s.logger.Error("Failed to apply scheduled revocations", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Activate applies the revocations that are due. A revocation the current status no longer
+// allows fails; one that could not be written stays pending for the next run
+func (s *Scheduler) Activate(ctx context.Context) error {
+	due, err := s.db.Due(ctx, time.Now(), s.batchSize)
+	if err != nil {
+		return err
+	}
+	for _, rev := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Claiming the revocation first keeps a concurrent cancel from being lost
+		if err := s.db.Transition(ctx, rev.ID, StatePending, StateApplying, "", ""); err != nil {
+			if !errors.Is(err, ErrNotPending) {
+				return err
+			}
+			continue
+		}
+		s.apply(ctx, rev)
+	}
+	return nil
+}
+
+func (s *Scheduler) apply(ctx context.Context, rev Revocation) {
+	effectiveAt := rev.EffectiveAt
+	update := storage.Update{
+		Serial:           rev.Serial,
+		Status:           storage.StatusRevoked,
+		RevokedAt:        &effectiveAt,
+		RevocationReason: rev.Reason,
+	}
+	// The write is attributed to whoever scheduled it
+	if rev.RequestedBy != "" {
+		ctx = approval.WithPrincipal(ctx, rev.RequestedBy)
+	}
+
+	err := s.check(ctx, update)
+	if err == nil {
+		err = s.store.Upsert(ctx, update)
+	}
+	switch {
+	case err == nil:
+		if err := s.db.Transition(ctx, rev.ID, StateApplying, StateApplied, "", ""); err != nil {
+			s.logger.Error("Applied scheduled revocation but failed to record it", zap.Int64("id", rev.ID), zap.String("serial", rev.Serial), zap.Error(err))
+		}
+		outcomes.WithLabelValues("applied").Inc()
+		s.logger.Info("Applied scheduled revocation", zap.Int64("id", rev.ID), zap.String("serial", rev.Serial), zap.Time("effective_at", rev.EffectiveAt))
+	case errors.Is(err, ErrInvalid):
+		if err := s.db.Transition(ctx, rev.ID, StateApplying, StateFailed, "", err.Error()); err != nil {
+			s.logger.Error("Failed to record failed scheduled revocation", zap.Int64("id", rev.ID), zap.String("serial", rev.Serial), zap.Error(err))
+		}
+		outcomes.WithLabelValues("failed").Inc()
+		s.logger.Warn("Scheduled revocation no longer applies", zap.Int64("id", rev.ID), zap.String("serial", rev.Serial), zap.Error(err))
+	default:
+		if resetErr := s.db.Transition(ctx, rev.ID, StateApplying, StatePending, "", ""); resetErr != nil {
+			s.logger.Error("Failed to return scheduled revocation to pending", zap.Int64("id", rev.ID), zap.String("serial", rev.Serial), zap.Error(resetErr))
+		}
+		s.logger.Error("Failed to apply scheduled revocation; retrying on the next run", zap.Int64("id", rev.ID), zap.String("serial", rev.Serial), zap.Error(err))
+	}
+}
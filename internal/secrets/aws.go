@@ -0,0 +1,95 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/config"
+	"github.com/gigvault/ocsp/internal/sigv4"
+)
+
+// aws reads secrets from AWS Secrets Manager with static SigV4 credentials
+type aws struct {
+	region   string
+	endpoint string
+	creds    sigv4.Credentials
+	client   *http.Client
+}
+
+func newAWS(cfg config.AWSSecretsConfig, timeout time.Duration) (*aws, error) {
+	if cfg.Region == "" {
+		return nil, errors.New("secrets.aws.region is required for awssm references")
+	}
+	creds := sigv4.Credentials{AccessKeyID: cfg.AccessKeyID, SecretAccessKey: cfg.SecretAccessKey}
+	if creds.AccessKeyID == "" {
+		creds.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if creds.SecretAccessKey == "" {
+		creds.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, errors.New("AWS credentials are required for awssm references")
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", cfg.Region)
+	}
+	return &aws{
+		region:   cfg.Region,
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/",
+		creds:    creds,
+		client:   &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Fetch reads <secret id>[#<field>]; a field selects a key of a JSON secret string
+func (a *aws) Fetch(ctx context.Context, ref string) (Secret, error) {
+	id, name, _ := strings.Cut(ref, "#")
+	body, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return Secret{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return Secret{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	sigv4.Sign(req, body, a.creds, a.region, "secretsmanager", time.Now())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return Secret{}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Secret{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Secret{}, fmt.Errorf("GetSecretValue for %s failed with status %d: %s", id, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	var decoded struct {
+		SecretString *string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return Secret{}, fmt.Errorf("invalid GetSecretValue response: %w", err)
+	}
+	if decoded.SecretString == nil {
+		return Secret{}, fmt.Errorf("%s is a binary secret; only string secrets are supported", id)
+	}
+	value, err := field(*decoded.SecretString, name)
+	if err != nil {
+		return Secret{}, err
+	}
+	return Secret{Value: value}, nil
+}
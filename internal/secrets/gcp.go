@@ -0,0 +1,99 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	gcpTokenURL    = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	gcpSecretsBase = "https://secretmanager.googleapis.com/v1/"
+)
+
+// gcp reads Google Secret Manager versions as the instance's service account
+type gcp struct {
+	client *http.Client
+	token  string
+}
+
+func newGCP(timeout time.Duration) *gcp {
+	return &gcp{client: &http.Client{Timeout: timeout}}
+}
+
+// Fetch reads a version resource name such as projects/p/secrets/db/versions/latest, with an
+// optional #field selecting a key of a JSON payload
+func (g *gcp) Fetch(ctx context.Context, ref string) (Secret, error) {
+	name, key, _ := strings.Cut(ref, "#")
+	if !strings.HasPrefix(name, "projects/") || !strings.Contains(name, "/versions/") {
+		return Secret{}, fmt.Errorf("%q is not a secret version resource name", name)
+	}
+	if g.token == "" {
+		token, err := g.accessToken(ctx)
+		if err != nil {
+			return Secret{}, err
+		}
+		g.token = token
+	}
+
+	var decoded struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := g.get(ctx, gcpSecretsBase+name+":access", map[string]string{"Authorization": "Bearer " + g.token}, &decoded); err != nil {
+		return Secret{}, err
+	}
+	payload, err := base64.StdEncoding.DecodeString(decoded.Payload.Data)
+	if err != nil {
+		return Secret{}, fmt.Errorf("invalid secret payload: %w", err)
+	}
+	value, err := field(string(payload), key)
+	if err != nil {
+		return Secret{}, err
+	}
+	return Secret{Value: value}, nil
+}
+
+func (g *gcp) accessToken(ctx context.Context) (string, error) {
+	var decoded struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := g.get(ctx, gcpTokenURL, map[string]string{"Metadata-Flavor": "Google"}, &decoded); err != nil {
+		return "", fmt.Errorf("failed to get a token from the metadata server: %w", err)
+	}
+	if decoded.AccessToken == "" {
+		return "", errors.New("metadata server returned no access token")
+	}
+	return decoded.AccessToken, nil
+}
+
+func (g *gcp) get(ctx context.Context, url string, headers map[string]string, into interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, into)
+}
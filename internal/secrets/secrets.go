@@ -0,0 +1,197 @@
+// Package secrets replaces secret references in the configuration with values fetched from
+// HashiCorp Vault, AWS Secrets Manager or Google Secret Manager, so deployment manifests
+// carry references instead of plaintext credentials
+package secrets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/config"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reference schemes; a setting is a reference when its value starts with one followed by ':'
+const (
+	SchemeVault = "vault"
+	SchemeAWS   = "awssm"
+	SchemeGCP   = "gcpsm"
+)
+
+var fetches = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "secret_fetches_total",
+	Help:      "Secret references resolved, by store and result.",
+}, []string{"store", "result"})
+
+func init() {
+	metrics.Registry.MustRegister(fetches)
+}
+
+// Secret is a fetched value; a positive TTL is the lease it was issued under
+type Secret struct {
+	Value string
+	TTL   time.Duration
+}
+
+// store fetches the secret a reference names, without its scheme
+type store interface {
+	Fetch(ctx context.Context, ref string) (Secret, error)
+}
+
+// Parse splits a setting value into scheme and reference, reporting whether it is a reference
+func Parse(value string) (scheme, ref string, ok bool) {
+	scheme, ref, ok = strings.Cut(value, ":")
+	if !ok || ref == "" {
+		return "", "", false
+	}
+	switch scheme {
+	case SchemeVault, SchemeAWS, SchemeGCP:
+		return scheme, ref, true
+	}
+	return "", "", false
+}
+
+// Resolve replaces every secret reference in cfg with its value, logging in to each store only
+// if a reference needs it. It returns when the secrets should be fetched again: two thirds into
+// the shortest lease, or RefreshInterval if that is sooner, and zero when no reference was
+// resolved or nothing needs refreshing
+func Resolve(ctx context.Context, cfg *config.Config) (time.Time, error) {
+	// Resolution runs before validation, so guard against an unset timeout here
+	settings := cfg.Secrets
+	if settings.Timeout <= 0 {
+		settings.Timeout = config.Default().Secrets.Timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, settings.Timeout)
+	defer cancel()
+
+	stores := make(map[string]store)
+	resolved := 0
+	var shortest time.Duration
+
+	err := cfg.VisitStrings(func(path string, value *string) error {
+		if strings.HasPrefix(path, "secrets.") {
+			return nil
+		}
+		scheme, ref, ok := Parse(*value)
+		if !ok {
+			return nil
+		}
+		s, ok := stores[scheme]
+		if !ok {
+			var err error
+			if s, err = newStore(ctx, scheme, settings); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			stores[scheme] = s
+		}
+
+		secret, err := s.Fetch(ctx, ref)
+		if err != nil {
+			fetches.WithLabelValues(scheme, "error").Inc()
+			return fmt.Errorf("%s: failed to fetch %s secret: %w", path, scheme, err)
+		}
+		fetches.WithLabelValues(scheme, "ok").Inc()
+		resolved++
+		if secret.TTL > 0 && (shortest == 0 || secret.TTL < shortest) {
+			shortest = secret.TTL
+		}
+
+		if strings.HasSuffix(path, "_path") {
+			file, err := materialize(settings.Dir, *value, secret.Value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			*value = file
+			return nil
+		}
+		*value = secret.Value
+		return nil
+	})
+	if err != nil || resolved == 0 {
+		return time.Time{}, err
+	}
+
+	refresh := settings.RefreshInterval
+	if lease := shortest * 2 / 3; lease > 0 && (refresh == 0 || lease < refresh) {
+		refresh = lease
+	}
+	if refresh <= 0 {
+		return time.Time{}, nil
+	}
+	return time.Now().Add(refresh), nil
+}
+
+func newStore(ctx context.Context, scheme string, settings config.SecretsConfig) (store, error) {
+	switch scheme {
+	case SchemeVault:
+		return newVault(ctx, settings.Vault, settings.Timeout)
+	case SchemeAWS:
+		return newAWS(settings.AWS, settings.Timeout)
+	case SchemeGCP:
+		return newGCP(settings.Timeout), nil
+	}
+	return nil, fmt.Errorf("unknown secret store %q", scheme)
+}
+
+// materialize writes a secret to a file readable only by this process' user, named after the
+// reference so every resolution of it rewrites the same file, and returns the file's path
+func materialize(dir, ref, value string) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create secrets directory: %w", err)
+	}
+	sum := sha256.Sum256([]byte(ref))
+	path := filepath.Join(dir, hex.EncodeToString(sum[:8]))
+
+	tmp, err := os.CreateTemp(dir, ".secret-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to write secret file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(value); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write secret file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write secret file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to write secret file: %w", err)
+	}
+	return path, nil
+}
+
+// field picks a field from a JSON object secret; an empty name returns the secret unchanged
+func field(value, name string) (string, error) {
+	if name == "" {
+		return value, nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, so field %q cannot be selected", name)
+	}
+	return pick(fields, name)
+}
+
+func pick(fields map[string]interface{}, name string) (string, error) {
+	v, ok := fields[name]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", name)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,179 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/config"
+)
+
+// vault reads secrets over Vault's HTTP API with a token obtained at construction
+type vault struct {
+	address   string
+	namespace string
+	token     string
+	client    *http.Client
+	// read caches responses by path: each read of a dynamic secret issues new credentials, so
+	// database/creds/ocsp#username and #password must come from the same read
+	read map[string]*vaultResponse
+}
+
+type vaultResponse struct {
+	LeaseDuration int                    `json:"lease_duration"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+func newVault(ctx context.Context, cfg config.VaultSecretsConfig, timeout time.Duration) (*vault, error) {
+	address := cfg.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if address == "" {
+		return nil, errors.New("secrets.vault.address or VAULT_ADDR is required for vault references")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CAPath != "" {
+		pem, err := os.ReadFile(cfg.CAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Vault CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in Vault CA file")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	v := &vault{
+		address:   strings.TrimSuffix(address, "/"),
+		namespace: cfg.Namespace,
+		client:    &http.Client{Timeout: timeout, Transport: transport},
+		read:      make(map[string]*vaultResponse),
+	}
+
+	mount := cfg.AuthMount
+	if mount == "" {
+		mount = cfg.Auth
+	}
+	var login map[string]string
+	switch cfg.Auth {
+	case "token":
+		v.token = cfg.Token
+		if v.token == "" {
+			v.token = os.Getenv("VAULT_TOKEN")
+		}
+		if v.token == "" {
+			return nil, errors.New("secrets.vault.token or VAULT_TOKEN is required for token auth")
+		}
+		return v, nil
+	case "kubernetes":
+		jwt, err := os.ReadFile(cfg.JWTPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account token: %w", err)
+		}
+		login = map[string]string{"role": cfg.Role, "jwt": strings.TrimSpace(string(jwt))}
+	case "approle":
+		login = map[string]string{"role_id": cfg.RoleID, "secret_id": cfg.SecretID}
+	default:
+		return nil, fmt.Errorf("unknown Vault auth method %q", cfg.Auth)
+	}
+
+	resp, err := v.do(ctx, http.MethodPost, "auth/"+mount+"/login", login)
+	if err != nil {
+		return nil, fmt.Errorf("Vault %s login failed: %w", cfg.Auth, err)
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("Vault %s login returned no token", cfg.Auth)
+	}
+	v.token = resp.Auth.ClientToken
+	return v, nil
+}
+
+// Fetch reads <api path>#<field>. KV version 2 paths include data/, as in
+// secret/data/ocsp#db_password; dynamic secrets such as database/creds/ocsp carry a lease
+func (v *vault) Fetch(ctx context.Context, ref string) (Secret, error) {
+	path, name, _ := strings.Cut(ref, "#")
+	resp, ok := v.read[path]
+	if !ok {
+		var err error
+		if resp, err = v.do(ctx, http.MethodGet, path, nil); err != nil {
+			return Secret{}, err
+		}
+		v.read[path] = resp
+	}
+
+	data := resp.Data
+	// KV version 2 nests the secret under data, next to its metadata
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	if name == "" {
+		if len(data) != 1 {
+			return Secret{}, fmt.Errorf("%s holds %d fields; name one with #field", path, len(data))
+		}
+		for key := range data {
+			name = key
+		}
+	}
+	value, err := pick(data, name)
+	if err != nil {
+		return Secret{}, err
+	}
+	return Secret{Value: value, TTL: time.Duration(resp.LeaseDuration) * time.Second}, nil
+}
+
+func (v *vault) do(ctx context.Context, method, path string, body interface{}) (*vaultResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.address+"/v1/"+strings.TrimPrefix(path, "/"), reader)
+	if err != nil {
+		return nil, err
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var decoded vaultResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&decoded); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid Vault response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Vault returned status %d for %s: %s", resp.StatusCode, path, strings.Join(decoded.Errors, "; "))
+	}
+	return &decoded, nil
+}
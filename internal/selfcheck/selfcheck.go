@@ -0,0 +1,268 @@
+// Package selfcheck answers load balancer probes with a full request, lookup and signing round trip
+// for a canary serial
+package selfcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"github.com/gigvault/ocsp/internal/precomputed"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/pkg/ocspclient"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+)
+
+// Stages, in the order they run
+const (
+	StageRequest = "request"
+	StageParse   = "parse"
+	StageLookup  = "lookup"
+	StageSign    = "sign"
+	StageVerify  = "verify"
+	StageRespond = "respond"
+)
+
+// Stage results
+const (
+	ResultPass    = "pass"
+	ResultFail    = "fail"
+	ResultSkipped = "skipped"
+)
+
+// nonceSize is the nonce sent with the canary request, so a responder echoing nonces is
+// checked to echo the right one
+const nonceSize = 16
+
+var stages = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "selfcheck_stages_total",
+	Help:      "Self-check stages run, by stage and result: pass, fail, or skipped after an earlier failure or without a signing key.",
+}, []string{"stage", "result"})
+
+func init() {
+	metrics.Registry.MustRegister(stages)
+}
+
+// Source holds the stored status of the canary serial
+type Source interface {
+	Get(ctx context.Context, serial string) (*storage.Record, error)
+}
+
+// Options configures a Checker
+type Options struct {
+	// Serial is the hex serial of the canary certificate, which must have a stored status and a
+	// precomputed response
+	Serial string
+	Issuer *x509.Certificate
+	// Path is where the responder answers POST requests
+	Path   string
+	Limits ocspreq.Limits
+	// Timeout bounds a whole check
+	Timeout time.Duration
+	// CacheFor reuses a result for that long, so frequent probes cost one check per period
+	CacheFor time.Duration
+}
+
+// StageResult is the outcome of one stage
+type StageResult struct {
+	Name     string  `json:"name"`
+	Result   string  `json:"result"`
+	Duration float64 `json:"duration_ms"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Report is the outcome of a check
+type Report struct {
+	Result    string        `json:"result"`
+	Serial    string        `json:"serial"`
+	CheckedAt time.Time     `json:"checked_at"`
+	Stages    []StageResult `json:"stages"`
+}
+
+// Checker runs self-checks against one responder
+type Checker struct {
+	opts      Options
+	source    Source
+	signer    *precomputed.Signer
+	responder http.Handler
+	logger    *logger.Logger
+
+	// mu serializes checks, so concurrent probes share one check rather than each signing
+	mu     sync.Mutex
+	last   *Report
+	issuer *ocspreq.Issuer
+}
+
+// New creates a checker for responder. signer may be nil where the replica holds no signing
+// key, as in the responder role; the sign and verify stages are then skipped, and only the
+// response served is verified
+func New(opts Options, source Source, signer *precomputed.Signer, responder http.Handler, logger *logger.Logger) (*Checker, error) {
+	serial, ok := new(big.Int).SetString(opts.Serial, 16)
+	if !ok || serial.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid canary serial %q", opts.Serial)
+	}
+	opts.Serial = serial.Text(16)
+	issuer, err := ocspreq.NewIssuer(opts.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &Checker{opts: opts, source: source, signer: signer, responder: responder, logger: logger, issuer: issuer}, nil
+}
+
+// ServeHTTP answers with the JSON report of a check: 200 when every stage passed, else 503
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	report := c.Check(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Result != ResultPass {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// Check runs every stage, or returns the last report while it is within CacheFor
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last != nil && time.Since(c.last.CheckedAt) < c.opts.CacheFor {
+		return *c.last
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+	report := c.run(checkCtx)
+	// A probe that gave up says nothing about the replica, so its result is not reused
+	if ctx.Err() == nil {
+		c.last = &report
+	}
+	if report.Result != ResultPass {
+		for _, stage := range report.Stages {
+			if stage.Result == ResultFail {
+				c.logger.Warn("Self-check failed", zap.String("stage", stage.Name), zap.String("serial", c.opts.Serial), zap.String("error", stage.Error))
+			}
+		}
+	}
+	return report
+}
+
+// run executes the stages in order; once one fails the rest are skipped
+func (c *Checker) run(ctx context.Context) Report {
+	report := Report{Result: ResultPass, Serial: c.opts.Serial, CheckedAt: time.Now()}
+	failed := false
+	stage := func(name string, fn func() error) {
+		result := StageResult{Name: name, Result: ResultSkipped}
+		if !failed && fn != nil {
+			start := time.Now()
+			err := fn()
+			result.Duration = float64(time.Since(start).Microseconds()) / 1000
+			result.Result = ResultPass
+			if err != nil {
+				result.Result, result.Error = ResultFail, err.Error()
+				report.Result, failed = ResultFail, true
+			}
+		}
+		stages.WithLabelValues(name, result.Result).Inc()
+		report.Stages = append(report.Stages, result)
+	}
+
+	var (
+		req    *ocspclient.Request
+		rec    *storage.Record
+		signed []byte
+	)
+	stage(StageRequest, func() (err error) {
+		serial, _ := new(big.Int).SetString(c.opts.Serial, 16)
+		req, err = ocspclient.NewRequest(serial, c.opts.Issuer, ocspclient.RequestOptions{NonceSize: nonceSize})
+		return err
+	})
+	stage(StageParse, func() error {
+		parsed, err := ocspreq.Parse(req.DER, c.opts.Limits)
+		if err != nil {
+			return err
+		}
+		if c.issuer.Match(&parsed.Request) == nil {
+			return errors.New("request does not name the responder's issuer")
+		}
+		if parsed.SerialNumber.Cmp(req.Serial) != 0 {
+			return fmt.Errorf("parsed serial %s, want %s", parsed.SerialNumber.Text(16), c.opts.Serial)
+		}
+		return nil
+	})
+	stage(StageLookup, func() (err error) {
+		rec, err = c.source.Get(ctx, c.opts.Serial)
+		if errors.Is(err, storage.ErrNotFound) {
+			return errors.New("canary serial has no stored status")
+		}
+		return err
+	})
+	var sign func() error
+	if c.signer != nil {
+		sign = func() error {
+			resp, err := c.signer.Sign(ctx, *rec, time.Now())
+			signed = resp.DER
+			return err
+		}
+	}
+	stage(StageSign, sign)
+	var verify func() error
+	if c.signer != nil {
+		verify = func() error { return verifyStatus(signed, req, rec) }
+	}
+	stage(StageVerify, verify)
+	stage(StageRespond, func() error {
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.opts.Path, bytes.NewReader(req.DER))
+		if err != nil {
+			return err
+		}
+		httpReq.Header.Set("Content-Type", "application/ocsp-request")
+		recorder := httptest.NewRecorder()
+		c.responder.ServeHTTP(recorder, httpReq)
+		if recorder.Code != http.StatusOK {
+			return fmt.Errorf("responder answered HTTP %d", recorder.Code)
+		}
+		return verifyStatus(recorder.Body.Bytes(), req, rec)
+	})
+	return report
+}
+
+// verifyStatus checks that der is a valid, current response to req carrying the stored status
+func verifyStatus(der []byte, req *ocspclient.Request, rec *storage.Record) error {
+	resp, err := ocspclient.Verify(der, req, ocspclient.VerifyOptions{})
+	if err != nil {
+		return err
+	}
+	if got := statusName(resp.Status); got != rec.Status {
+		return fmt.Errorf("response says %s, stored status is %s", got, rec.Status)
+	}
+	return nil
+}
+
+func statusName(status int) string {
+	switch status {
+	case ocsp.Good:
+		return storage.StatusGood
+	case ocsp.Revoked:
+		return storage.StatusRevoked
+	default:
+		return storage.StatusUnknown
+	}
+}
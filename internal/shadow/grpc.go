@@ -0,0 +1,71 @@
+package shadow
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/api/proto/ocsp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// UnaryServerInterceptor mirrors sampled CheckStatus calls to shadow once they have been
+// answered, and compares the shadow's answer with the one served. Other methods are never
+// mirrored, since they write
+func UnaryServerInterceptor(m *Mirror, shadow ocsp.OCSPServiceClient) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if info.FullMethod != ocsp.OCSPService_CheckStatus_FullMethodName || !m.sample() {
+			return resp, err
+		}
+		request := proto.Clone(req.(*ocsp.CheckStatusRequest)).(*ocsp.CheckStatusRequest)
+		var served *ocsp.CheckStatusResponse
+		if err == nil {
+			served = proto.Clone(resp.(*ocsp.CheckStatusResponse)).(*ocsp.CheckStatusResponse)
+		}
+		servedErr := err
+		m.compare(surfaceGRPC, request.SerialNumber, func(ctx context.Context) ([]string, error) {
+			got, err := shadow.CheckStatus(ctx, request)
+			if servedErr != nil || err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				if want, have := status.Code(servedErr), status.Code(err); want != have {
+					return []string{fmt.Sprintf("code: served %s, shadow %s", want, have)}, nil
+				}
+				return nil, nil
+			}
+			return diffStatus(served, got), nil
+		})
+		return resp, err
+	}
+}
+
+// diffStatus lists the fields of two CheckStatus answers that differ. The validity of unknown
+// answers is the time of the lookup, so it is only compared for stored statuses
+func diffStatus(served, shadow *ocsp.CheckStatusResponse) []string {
+	var diffs []string
+	add := func(field string, want, have interface{}) {
+		if fmt.Sprint(want) != fmt.Sprint(have) {
+			diffs = append(diffs, fmt.Sprintf("%s: served %v, shadow %v", field, want, have))
+		}
+	}
+	add("status", served.Status, shadow.Status)
+	add("revoked_at", timestamp(served.RevokedAt), timestamp(shadow.RevokedAt))
+	add("revocation_reason", served.RevocationReason, shadow.RevocationReason)
+	if served.Status != storage.StatusUnknown || shadow.Status != storage.StatusUnknown {
+		add("this_update", timestamp(served.ThisUpdate), timestamp(shadow.ThisUpdate))
+		add("next_update", timestamp(served.NextUpdate), timestamp(shadow.NextUpdate))
+	}
+	return diffs
+}
+
+func timestamp(t *timestamppb.Timestamp) string {
+	if t == nil {
+		return "none"
+	}
+	return t.AsTime().UTC().Format("2006-01-02T15:04:05.000000Z")
+}
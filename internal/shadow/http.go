@@ -0,0 +1,208 @@
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	xocsp "golang.org/x/crypto/ocsp"
+)
+
+// maxBody bounds the requests and responses held for a comparison
+const maxBody = 1 << 20
+
+// Middleware mirrors sampled RFC 6960 requests under prefixes to the instance at shadowURL,
+// keeping their method, path and body, and compares its response with the one served. Other
+// paths pass through untouched
+func Middleware(m *Mirror, shadowURL string, client *http.Client, prefixes []string, next http.Handler) http.Handler {
+	if m == nil || len(prefixes) == 0 {
+		return next
+	}
+	shadowURL = strings.TrimSuffix(shadowURL, "/")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrored := false
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				mirrored = m.sample()
+				break
+			}
+		}
+		if !mirrored {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var body []byte
+		if r.Method == http.MethodPost {
+			var err error
+			if body, err = io.ReadAll(io.LimitReader(r.Body, maxBody)); err != nil {
+				http.Error(w, "failed to read request", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		target := shadowURL + r.URL.EscapedPath()
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		method, contentType := r.Method, r.Header.Get("Content-Type")
+		served := exchange{status: rec.status, contentType: rec.Header().Get("Content-Type"), body: rec.body.Bytes()}
+		m.compare(surfaceHTTP, servedSerial(served.body), func(ctx context.Context) ([]string, error) {
+			got, err := send(ctx, client, method, target, contentType, body)
+			if err != nil {
+				return nil, err
+			}
+			return diffExchanges(served, got), nil
+		})
+	})
+}
+
+// recorder passes a response through while keeping a copy of it
+type recorder struct {
+	http.ResponseWriter
+	status  int
+	body    bytes.Buffer
+	written bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.written {
+		r.status, r.written = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.written = true
+	if room := maxBody - r.body.Len(); room > 0 {
+		r.body.Write(p[:min(len(p), room)])
+	}
+	return r.ResponseWriter.Write(p)
+}
+
+// exchange is what one responder answered
+type exchange struct {
+	status      int
+	contentType string
+	body        []byte
+}
+
+func send(ctx context.Context, client *http.Client, method, target, contentType string, body []byte) (exchange, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return exchange{}, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return exchange{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return exchange{}, err
+	}
+	return exchange{status: resp.StatusCode, contentType: resp.Header.Get("Content-Type"), body: data}, nil
+}
+
+// servedSerial names the serial a response is about, for logs
+func servedSerial(der []byte) string {
+	resp, err := xocsp.ParseResponse(der, nil)
+	if err != nil || resp.SerialNumber == nil {
+		return ""
+	}
+	return resp.SerialNumber.Text(16)
+}
+
+// diffExchanges lists how two responses to one request differ. Signatures and producedAt are
+// expected to differ between signers and are not compared; everything else in the response
+// structure is
+func diffExchanges(served, shadow exchange) []string {
+	var diffs []string
+	add := func(field string, want, have interface{}) {
+		if fmt.Sprint(want) != fmt.Sprint(have) {
+			diffs = append(diffs, fmt.Sprintf("%s: served %v, shadow %v", field, want, have))
+		}
+	}
+	add("http_status", served.status, shadow.status)
+	add("content_type", served.contentType, shadow.contentType)
+	if served.status != http.StatusOK || shadow.status != http.StatusOK {
+		return diffs
+	}
+
+	want, wantErr := xocsp.ParseResponse(served.body, nil)
+	have, haveErr := xocsp.ParseResponse(shadow.body, nil)
+	if wantErr != nil || haveErr != nil {
+		add("response_status", responseStatus(wantErr), responseStatus(haveErr))
+		return diffs
+	}
+	add("cert_status", want.Status, have.Status)
+	add("serial", want.SerialNumber, have.SerialNumber)
+	add("revoked_at", optionalTime(want.RevokedAt), optionalTime(have.RevokedAt))
+	add("revocation_reason", want.RevocationReason, have.RevocationReason)
+	add("this_update", optionalTime(want.ThisUpdate), optionalTime(have.ThisUpdate))
+	add("next_update", optionalTime(want.NextUpdate), optionalTime(have.NextUpdate))
+	add("cert_id_hash", want.IssuerHash, have.IssuerHash)
+	add("responder_id", responderID(want), responderID(have))
+	add("signature_algorithm", want.SignatureAlgorithm, have.SignatureAlgorithm)
+	add("certificate", certificate(want), certificate(have))
+	add("single_extensions", extensions(want), extensions(have))
+	return diffs
+}
+
+// responseStatus names the outcome of parsing a response: successful, or its error status
+func responseStatus(err error) string {
+	var responseErr xocsp.ResponseError
+	switch {
+	case err == nil:
+		return "successful"
+	case errors.As(err, &responseErr):
+		return responseErr.Status.String()
+	default:
+		return "unparseable"
+	}
+}
+
+func optionalTime(t time.Time) string {
+	if t.IsZero() {
+		return "none"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func responderID(resp *xocsp.Response) string {
+	if len(resp.ResponderKeyHash) > 0 {
+		return "key:" + hex.EncodeToString(resp.ResponderKeyHash)
+	}
+	return "name:" + hex.EncodeToString(resp.RawResponderName)
+}
+
+// certificate names the responder certificate embedded in a response, if any
+func certificate(resp *xocsp.Response) string {
+	if resp.Certificate == nil {
+		return "none"
+	}
+	return resp.Certificate.SerialNumber.Text(16)
+}
+
+// extensions lists the single response extensions by OID, with their values, in a stable order
+func extensions(resp *xocsp.Response) string {
+	list := make([]string, 0, len(resp.Extensions))
+	for _, ext := range resp.Extensions {
+		list = append(list, fmt.Sprintf("%s=%x", ext.Id, ext.Value))
+	}
+	sort.Strings(list)
+	return "[" + strings.Join(list, " ") + "]"
+}
@@ -0,0 +1,105 @@
+// Package shadow mirrors a sample of lookups to a second target and compares its answers
+package shadow
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Surfaces, used in metric labels
+const (
+	surfaceHTTP = "http"
+	surfaceGRPC = "grpc"
+)
+
+// Comparison results, used in metric labels
+const (
+	resultMatch    = "match"
+	resultMismatch = "mismatch"
+	resultError    = "error"
+	resultSkipped  = "skipped"
+)
+
+var comparisons = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "shadow_comparisons_total",
+	Help:      "Lookups mirrored to the shadow target, by surface and result: match, mismatch, error, or skipped when too many were in flight.",
+}, []string{"surface", "result"})
+
+func init() {
+	metrics.Registry.MustRegister(comparisons)
+}
+
+// Options configures a mirror
+type Options struct {
+	// Percent of lookups mirrored, between 0 and 100
+	Percent float64
+	// Timeout bounds each shadow call
+	Timeout time.Duration
+	// MaxInFlight bounds the shadow calls outstanding; sampled lookups beyond it are skipped
+	MaxInFlight int
+}
+
+// Mirror samples lookups and runs their shadow comparisons in the background
+type Mirror struct {
+	opts   Options
+	slots  chan struct{}
+	logger *logger.Logger
+
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// New creates a mirror
+func New(opts Options, logger *logger.Logger) *Mirror {
+	return &Mirror{
+		opts:   opts,
+		slots:  make(chan struct{}, opts.MaxInFlight),
+		logger: logger,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// sample reports whether a lookup is mirrored
+func (m *Mirror) sample() bool {
+	if m.opts.Percent <= 0 {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.random.Float64()*100 < m.opts.Percent
+}
+
+// compare runs fn in the background with a context bounded by the timeout, and records its
+// differences. fn returns the fields that differ, or an error when the shadow did not answer
+func (m *Mirror) compare(surface, serial string, fn func(ctx context.Context) ([]string, error)) {
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		comparisons.WithLabelValues(surface, resultSkipped).Inc()
+		return
+	}
+	go func() {
+		defer func() { <-m.slots }()
+		ctx, cancel := context.WithTimeout(context.Background(), m.opts.Timeout)
+		defer cancel()
+		diffs, err := fn(ctx)
+		switch {
+		case err != nil:
+			comparisons.WithLabelValues(surface, resultError).Inc()
+			m.logger.Warn("Shadow lookup failed", zap.String("surface", surface), zap.String("serial", serial), zap.Error(err))
+		case len(diffs) > 0:
+			comparisons.WithLabelValues(surface, resultMismatch).Inc()
+			m.logger.Warn("Shadow answer differs", zap.String("surface", surface), zap.String("serial", serial), zap.Strings("differences", diffs))
+		default:
+			comparisons.WithLabelValues(surface, resultMatch).Inc()
+		}
+	}()
+}
@@ -0,0 +1,123 @@
+// Package shortlived answers good for short-lived certificates without a stored status. Their
+// serials, minted with pkg/shortserial, prove the issuer issued them and when they expire, so
+// high-issuance CAs skip the status write for every new certificate. A stored status, such as a
+// revocation, always wins
+package shortlived
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/pkg/shortserial"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var proofs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "short_lived_proofs_total",
+	Help:      "Serials without a stored status checked for a short-lived proof, by result: valid, expired, or unproven.",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(proofs)
+}
+
+// Issuer issues short-lived certificates with serials minted with Secret
+type Issuer struct {
+	Certificate *x509.Certificate
+	Secret      []byte
+	// MaxLifetime refuses serials expiring further ahead, bounding what a leaked secret vouches for
+	MaxLifetime time.Duration
+}
+
+// Verifier proves serials against the secrets of its issuers
+type Verifier struct {
+	issuers []Issuer
+	now     func() time.Time
+}
+
+// NewVerifier creates a verifier for issuers
+func NewVerifier(issuers []Issuer) *Verifier {
+	return &Verifier{issuers: issuers, now: time.Now}
+}
+
+// ForIssuer returns a verifier for cert alone, or nil when cert issues no short-lived certificates
+func (v *Verifier) ForIssuer(cert *x509.Certificate) *Verifier {
+	for _, issuer := range v.issuers {
+		if issuer.Certificate.Equal(cert) {
+			return &Verifier{issuers: []Issuer{issuer}, now: v.now}
+		}
+	}
+	return nil
+}
+
+// NotAfter returns the expiry of the short-lived certificate with serial, if one of the issuers
+// minted it and it has not expired
+func (v *Verifier) NotAfter(serial string) (time.Time, bool) {
+	n, ok := new(big.Int).SetString(serial, 16)
+	if !ok {
+		proofs.WithLabelValues("unproven").Inc()
+		return time.Time{}, false
+	}
+	now := v.now()
+	for _, issuer := range v.issuers {
+		notAfter, ok := shortserial.Verify(issuer.Secret, n)
+		if !ok {
+			continue
+		}
+		switch {
+		case !now.Before(notAfter):
+			proofs.WithLabelValues("expired").Inc()
+			return time.Time{}, false
+		case notAfter.Sub(now) > issuer.MaxLifetime:
+			proofs.WithLabelValues("unproven").Inc()
+			return time.Time{}, false
+		}
+		proofs.WithLabelValues("valid").Inc()
+		return notAfter, true
+	}
+	proofs.WithLabelValues("unproven").Inc()
+	return time.Time{}, false
+}
+
+// Store answers Get with good for proven short-lived serials the wrapped store has no status for
+type Store struct {
+	storage.Store
+	verifier *Verifier
+	validity time.Duration
+}
+
+// NewStore wraps store so proven short-lived serials read as good, valid for validity or until
+// the certificate expires, whichever is sooner
+func NewStore(store storage.Store, verifier *Verifier, validity time.Duration) *Store {
+	return &Store{Store: store, verifier: verifier, validity: validity}
+}
+
+// Get returns the stored status, or good for a proven short-lived serial
+func (s *Store) Get(ctx context.Context, serial string) (*storage.Record, error) {
+	rec, err := s.Store.Get(ctx, serial)
+	if !errors.Is(err, storage.ErrNotFound) {
+		return rec, err
+	}
+	notAfter, ok := s.verifier.NotAfter(serial)
+	if !ok {
+		return nil, err
+	}
+
+	now := s.verifier.now().UTC()
+	nextUpdate := now.Add(s.validity)
+	if notAfter.Before(nextUpdate) {
+		nextUpdate = notAfter
+	}
+	return &storage.Record{
+		Serial:     serial,
+		Status:     storage.StatusGood,
+		ThisUpdate: now,
+		NextUpdate: nextUpdate,
+	}, nil
+}
@@ -0,0 +1,239 @@
+// Package signwatch measures how long each signing key takes and flags keys that have become
+// slow or stopped answering, so callers can fall back to responses signed earlier. Keys held
+// behind a remote service degrade without failing, which otherwise shows only as latency
+package signwatch
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/anomaly"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Reasons a key is degraded, used in metric labels
+const (
+	reasonSlow    = "slow"
+	reasonStalled = "stalled"
+)
+
+// maxSamples bounds the latencies kept per key within the window
+const maxSamples = 4096
+
+var (
+	duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Name:      "signing_duration_seconds",
+		Help:      "Time taken by each signature, by signing backend and key.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"backend", "key"})
+	degradedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "signing_degraded",
+		Help:      "1 while a signing key is too slow or unresponsive and stored responses are preferred, by signing backend and key.",
+	}, []string{"backend", "key"})
+	degradations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "signing_degraded_total",
+		Help:      "Times a signing key became degraded, by signing backend, key and reason.",
+	}, []string{"backend", "key", "reason"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(duration, degradedGauge, degradations)
+}
+
+// Options configures when a key counts as degraded
+type Options struct {
+	// Threshold is the p99 signing latency above which a key is slow
+	Threshold time.Duration
+	// Window is how far back latencies are considered
+	Window time.Duration
+	// MinSamples is the number of signatures within Window the p99 needs; with fewer a key is
+	// only judged by stalls
+	MinSamples int
+	// StallTimeout is how long a signature may be outstanding before the key counts as
+	// unresponsive
+	StallTimeout time.Duration
+}
+
+// Watch tracks the keys it wrapped
+type Watch struct {
+	opts   Options
+	hooks  []anomaly.Hook
+	logger *logger.Logger
+
+	mu   sync.Mutex
+	keys []*Key
+}
+
+// New creates a watch that fires a slow_signing alert through hooks whenever a key degrades
+func New(opts Options, logger *logger.Logger, hooks ...anomaly.Hook) *Watch {
+	return &Watch{opts: opts, hooks: hooks, logger: logger}
+}
+
+// Key is a watched signing key. It signs like the key it wraps and reports whether it is
+// degraded
+type Key struct {
+	crypto.Signer
+	backend string
+	id      string
+
+	degraded atomic.Bool
+
+	mu      sync.Mutex
+	samples []sample
+	nextID  uint64
+	pending map[uint64]time.Time
+}
+
+type sample struct {
+	at      time.Time
+	latency time.Duration
+}
+
+// Wrap returns key, watched. backend names where the key is held, such as file; the key is
+// identified in metrics and alerts by a hash of its public key
+func (w *Watch) Wrap(key crypto.Signer, backend string) *Key {
+	watched := &Key{Signer: key, backend: backend, id: keyID(key.Public()), pending: make(map[uint64]time.Time)}
+	degradedGauge.WithLabelValues(backend, watched.id).Set(0)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.keys = append(w.keys, watched)
+	return watched
+}
+
+// keyID is the first 8 bytes of the SHA-256 of the key's SubjectPublicKeyInfo, in hex
+func keyID(public crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return "unknown"
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Sign signs with the wrapped key, timing the signature
+func (k *Key) Sign(random io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	start := time.Now()
+	k.mu.Lock()
+	k.nextID++
+	id := k.nextID
+	k.pending[id] = start
+	k.mu.Unlock()
+
+	signature, err := k.Signer.Sign(random, digest, opts)
+
+	latency := time.Since(start)
+	duration.WithLabelValues(k.backend, k.id).Observe(latency.Seconds())
+	k.mu.Lock()
+	delete(k.pending, id)
+	if len(k.samples) == maxSamples {
+		k.samples = append(k.samples[:0], k.samples[1:]...)
+	}
+	k.samples = append(k.samples, sample{at: start, latency: latency})
+	k.mu.Unlock()
+	return signature, err
+}
+
+// Degraded reports whether the key was too slow or unresponsive at the latest check
+func (k *Key) Degraded() bool {
+	return k.degraded.Load()
+}
+
+// Run checks every key each interval until ctx is cancelled
+func (w *Watch) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.Check(ctx, time.Now())
+		}
+	}
+}
+
+// Check judges every key as of now, alerting on the keys that became degraded
+func (w *Watch) Check(ctx context.Context, now time.Time) {
+	w.mu.Lock()
+	keys := append([]*Key(nil), w.keys...)
+	w.mu.Unlock()
+	for _, k := range keys {
+		reason, detail := k.judge(w.opts, now)
+		was := k.degraded.Swap(reason != "")
+		switch {
+		case reason != "" && !was:
+			degradedGauge.WithLabelValues(k.backend, k.id).Set(1)
+			degradations.WithLabelValues(k.backend, k.id, reason).Inc()
+			w.logger.Warn("Signing key degraded; preferring stored responses",
+				zap.String("backend", k.backend), zap.String("key", k.id), zap.String("reason", reason), zap.String("detail", detail))
+			w.alert(ctx, k, detail, now)
+		case reason == "" && was:
+			degradedGauge.WithLabelValues(k.backend, k.id).Set(0)
+			w.logger.Info("Signing key recovered", zap.String("backend", k.backend), zap.String("key", k.id))
+		}
+	}
+}
+
+// judge returns why the key is degraded, or nothing, and a description for the alert
+func (k *Key) judge(opts Options, now time.Time) (string, string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for _, start := range k.pending {
+		if stalled := now.Sub(start); stalled >= opts.StallTimeout {
+			return reasonStalled, fmt.Sprintf("a signature has been outstanding for %s", stalled.Round(time.Millisecond))
+		}
+	}
+
+	cutoff := now.Add(-opts.Window)
+	kept := k.samples[:0]
+	for _, s := range k.samples {
+		if s.at.After(cutoff) {
+			kept = append(kept, s)
+		}
+	}
+	k.samples = kept
+	if len(kept) == 0 || len(kept) < opts.MinSamples {
+		return "", ""
+	}
+	latencies := make([]time.Duration, len(kept))
+	for i, s := range kept {
+		latencies[i] = s.latency
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	p99 := latencies[(len(latencies)*99+99)/100-1]
+	if p99 > opts.Threshold {
+		return reasonSlow, fmt.Sprintf("p99 signing latency %s over %d signatures exceeds %s", p99.Round(time.Millisecond), len(latencies), opts.Threshold)
+	}
+	return "", ""
+}
+
+func (w *Watch) alert(ctx context.Context, k *Key, detail string, now time.Time) {
+	alert := anomaly.Alert{
+		Kind:      anomaly.KindSlowSigning,
+		Message:   fmt.Sprintf("Signing key %s (%s) is degraded: %s; preferring stored responses", k.id, k.backend, detail),
+		Source:    k.backend + ":" + k.id,
+		Count:     1,
+		Threshold: w.opts.Threshold.Milliseconds(),
+		Window:    w.opts.Window.String(),
+		FiredAt:   now,
+	}
+	for _, hook := range w.hooks {
+		hook.Fire(ctx, alert)
+	}
+}
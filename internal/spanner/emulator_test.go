@@ -0,0 +1,156 @@
+package spanner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+	spannerapi "google.golang.org/api/spanner/v1"
+)
+
+// emulatorDatabase creates a database holding the tables of migrations/spanner on the
+// emulator whose REST endpoint is in SPANNER_EMULATOR_REST, such as http://localhost:9020,
+// and skips the test when it is not set
+func emulatorDatabase(t *testing.T) (endpoint, database string) {
+	t.Helper()
+	endpoint = os.Getenv("SPANNER_EMULATOR_REST")
+	if endpoint == "" {
+		t.Skip("SPANNER_EMULATOR_REST is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	service, err := spannerapi.NewService(ctx, option.WithEndpoint(strings.TrimSuffix(endpoint, "/")+"/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ddl []string
+	for _, path := range []string{"../../migrations/spanner/001_ocsp_responses.sql"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for _, line := range strings.Split(string(data), "\n") {
+			if i := strings.Index(line, "--"); i >= 0 {
+				line = line[:i]
+			}
+			lines = append(lines, line)
+		}
+		for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";") {
+			if stmt = strings.TrimSpace(stmt); stmt != "" {
+				ddl = append(ddl, stmt)
+			}
+		}
+	}
+
+	id := fmt.Sprintf("t%d", time.Now().UnixNano()%1e12)
+	instance := "projects/test/instances/" + id
+	op, err := service.Projects.Instances.Create("projects/test", &spannerapi.CreateInstanceRequest{
+		InstanceId: id,
+		Instance:   &spannerapi.Instance{Config: "projects/test/instanceConfigs/emulator-config", DisplayName: id, NodeCount: 1},
+	}).Context(ctx).Do()
+	if err != nil {
+		t.Fatalf("failed to create instance: %v", err)
+	}
+	for !op.Done {
+		time.Sleep(100 * time.Millisecond)
+		if op, err = service.Projects.Instances.Operations.Get(op.Name).Context(ctx).Do(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() { service.Projects.Instances.Delete(instance).Do() })
+
+	op, err = service.Projects.Instances.Databases.Create(instance, &spannerapi.CreateDatabaseRequest{
+		CreateStatement: "CREATE DATABASE `ocsp`",
+		ExtraStatements: ddl,
+	}).Context(ctx).Do()
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	for !op.Done {
+		time.Sleep(100 * time.Millisecond)
+		if op, err = service.Projects.Instances.Databases.Operations.Get(op.Name).Context(ctx).Do(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if op.Error != nil {
+		t.Fatalf("failed to create database: %s", op.Error.Message)
+	}
+	return endpoint, instance + "/databases/ocsp"
+}
+
+func TestEmulator(t *testing.T) {
+	endpoint, database := emulatorDatabase(t)
+	ctx := context.Background()
+	client, err := NewClient(ctx, Options{Database: database, Endpoint: endpoint, Emulator: true, Sessions: 2, Timeout: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	columns := []string{"serial", "status", "this_update", "next_update", "revoked_at", "revocation_reason"}
+	mutation, err := InsertOrUpdate("ocsp_responses", columns, [][]interface{}{
+		{"0a", "good", now, now.Add(time.Hour), (*time.Time)(nil), ""},
+		{"0b", "good", now, now.Add(time.Hour), (*time.Time)(nil), ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Apply(ctx, []Mutation{mutation}); err != nil {
+		t.Fatal(err)
+	}
+
+	update := "UPDATE ocsp_responses SET status = 'revoked', revoked_at = @at, validity_seconds = @validity WHERE serial = @serial"
+	validity := int64(3600)
+	counts, err := client.BatchUpdate(ctx, []Statement{
+		{SQL: update, Params: map[string]interface{}{"serial": "0a", "at": now, "validity": &validity}},
+		{SQL: update, Params: map[string]interface{}{"serial": "0c", "at": now, "validity": (*int64)(nil)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(counts) != "[1 0]" {
+		t.Errorf("BatchUpdate() counts = %v, want [1 0]", counts)
+	}
+
+	rows, err := client.Query(ctx, 0, Statement{
+		SQL:    "SELECT serial, status, revoked_at, validity_seconds FROM ocsp_responses WHERE serial = @serial",
+		Params: map[string]interface{}{"serial": "0a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows.Rows) != 1 {
+		t.Fatalf("Query() = %v", rows.Rows)
+	}
+	if row := rows.Rows[0]; row[1] != "revoked" || !row[2].(time.Time).Equal(now) || row[3] != int64(3600) {
+		t.Errorf("row = %#v", row)
+	}
+
+	var serials []interface{}
+	err = client.Snapshot(ctx, func(query func(Statement) (*Rows, error)) error {
+		serials = serials[:0]
+		for _, status := range []string{"good", "revoked"} {
+			rows, err := query(Statement{SQL: "SELECT serial FROM ocsp_responses WHERE status = @status", Params: map[string]interface{}{"status": status}})
+			if err != nil {
+				return err
+			}
+			for _, row := range rows.Rows {
+				serials = append(serials, row[0])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(serials) != "[0b 0a]" {
+		t.Errorf("Snapshot() read %v, want [0b 0a]", serials)
+	}
+}
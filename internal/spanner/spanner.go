@@ -0,0 +1,403 @@
+// Package spanner runs statements against a Cloud Spanner database through the Google API
+// client, with a session pool and retries of aborted transactions
+package spanner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	spannerapi "google.golang.org/api/spanner/v1"
+)
+
+const (
+	// DefaultEndpoint is the Spanner API
+	DefaultEndpoint = "https://spanner.googleapis.com"
+
+	// commitAttempts bounds the tries of a read-write transaction Spanner aborts
+	commitAttempts = 3
+)
+
+// Error is an error returned by the API
+type Error struct {
+	HTTPStatus int
+	// Status is the gRPC status name, such as NOT_FOUND or ABORTED
+	Status  string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("spanner: %s: %s", e.Status, e.Message)
+}
+
+func isStatus(err error, status string) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Status == status
+}
+
+// sessionLost reports whether err says the session used no longer exists
+func sessionLost(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Status == "NOT_FOUND" && strings.Contains(apiErr.Message, "Session not found")
+}
+
+// toError converts the errors of the API client to Error, naming their gRPC status
+func toError(err error) error {
+	var googleErr *googleapi.Error
+	if !errors.As(err, &googleErr) {
+		return err
+	}
+	var decoded struct {
+		Error struct {
+			Status string `json:"status"`
+		} `json:"error"`
+	}
+	status := ""
+	if json.Unmarshal([]byte(googleErr.Body), &decoded) == nil {
+		status = decoded.Error.Status
+	}
+	if status == "" {
+		status = strconv.Itoa(googleErr.Code)
+	}
+	return &Error{HTTPStatus: googleErr.Code, Status: status, Message: googleErr.Message}
+}
+
+// Options configures a Client
+type Options struct {
+	// Database is the resource name projects/<p>/instances/<i>/databases/<d>
+	Database string
+	// Endpoint is the API base URL, DefaultEndpoint when empty
+	Endpoint string
+	// Emulator sends requests without credentials, as the emulator expects. Otherwise the
+	// application default credentials are used
+	Emulator bool
+	// Sessions is the most sessions kept, and so the most requests in flight
+	Sessions int
+	// Timeout bounds each request
+	Timeout time.Duration
+}
+
+// Client runs statements against one database
+type Client struct {
+	opts     Options
+	sessions *spannerapi.ProjectsInstancesDatabasesSessionsService
+
+	// idle holds sessions not in use; slots limits how many exist
+	idle  chan string
+	slots chan struct{}
+}
+
+// NewClient creates a client and checks it can create a session
+func NewClient(ctx context.Context, opts Options) (*Client, error) {
+	if opts.Endpoint == "" {
+		opts.Endpoint = DefaultEndpoint
+	}
+	clientOpts := []option.ClientOption{option.WithEndpoint(strings.TrimSuffix(opts.Endpoint, "/") + "/")}
+	if opts.Emulator {
+		clientOpts = append(clientOpts, option.WithoutAuthentication())
+	} else {
+		clientOpts = append(clientOpts, option.WithScopes(spannerapi.SpannerDataScope))
+	}
+	service, err := spannerapi.NewService(ctx, clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the Spanner client: %w", err)
+	}
+	c := &Client{
+		opts:     opts,
+		sessions: service.Projects.Instances.Databases.Sessions,
+		idle:     make(chan string, opts.Sessions),
+		slots:    make(chan struct{}, opts.Sessions),
+	}
+	session, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.release(session)
+	return c, nil
+}
+
+// Close deletes the idle sessions
+func (c *Client) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		select {
+		case session := <-c.idle:
+			c.sessions.Delete(session).Context(ctx).Do()
+		default:
+			return
+		}
+	}
+}
+
+// timeout bounds a request by Options.Timeout
+func (c *Client) timeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.opts.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.opts.Timeout)
+}
+
+// acquire takes an idle session or creates one, waiting while Sessions are in use
+func (c *Client) acquire(ctx context.Context) (string, error) {
+	select {
+	case session := <-c.idle:
+		return session, nil
+	default:
+	}
+	select {
+	case session := <-c.idle:
+		return session, nil
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	ctx, cancel := c.timeout(ctx)
+	defer cancel()
+	created, err := c.sessions.Create(c.opts.Database, &spannerapi.CreateSessionRequest{}).Context(ctx).Do()
+	if err != nil {
+		<-c.slots
+		return "", toError(err)
+	}
+	return created.Name, nil
+}
+
+func (c *Client) release(session string) {
+	c.idle <- session
+}
+
+// discard gives up a session Spanner no longer knows
+func (c *Client) discard() {
+	<-c.slots
+}
+
+// withSession runs fn with a pooled session, on a new one if Spanner dropped the first
+func (c *Client) withSession(ctx context.Context, fn func(session string) error) error {
+	for attempt := 0; ; attempt++ {
+		session, err := c.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		err = fn(session)
+		if sessionLost(err) {
+			c.discard()
+			if attempt == 0 {
+				continue
+			}
+			return err
+		}
+		c.release(session)
+		return err
+	}
+}
+
+// Statement is SQL with named parameters, written @name in the SQL
+type Statement struct {
+	SQL    string
+	Params map[string]interface{}
+}
+
+// Rows is the result of a query
+type Rows struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// Staleness chooses the timestamp reads see: zero for a strong read of the latest data,
+// otherwise data at most that old, which the nearest replica can serve without a round trip
+// to the leader
+type Staleness time.Duration
+
+func (s Staleness) readOnly() *spannerapi.ReadOnly {
+	if s <= 0 {
+		return &spannerapi.ReadOnly{Strong: true}
+	}
+	return &spannerapi.ReadOnly{MaxStaleness: durationString(time.Duration(s))}
+}
+
+// Query runs a statement in a single-use read-only transaction
+func (c *Client) Query(ctx context.Context, staleness Staleness, stmt Statement) (*Rows, error) {
+	var rows *Rows
+	err := c.withSession(ctx, func(session string) error {
+		var err error
+		rows, err = c.executeSQL(ctx, session, stmt, &spannerapi.TransactionSelector{
+			SingleUse: &spannerapi.TransactionOptions{ReadOnly: staleness.readOnly()},
+		}, nil)
+		return err
+	})
+	return rows, err
+}
+
+// Snapshot runs fn with a strong read-only transaction, so every query it makes sees the
+// database at one timestamp. fn runs again from the start if Spanner drops the session
+func (c *Client) Snapshot(ctx context.Context, fn func(query func(stmt Statement) (*Rows, error)) error) error {
+	return c.withSession(ctx, func(session string) error {
+		var txID string
+		return fn(func(stmt Statement) (*Rows, error) {
+			selector := &spannerapi.TransactionSelector{Id: txID}
+			if txID == "" {
+				selector = &spannerapi.TransactionSelector{Begin: &spannerapi.TransactionOptions{ReadOnly: &spannerapi.ReadOnly{Strong: true}}}
+			}
+			return c.executeSQL(ctx, session, stmt, selector, &txID)
+		})
+	})
+}
+
+// Apply commits mutations in a single-use read-write transaction
+func (c *Client) Apply(ctx context.Context, mutations []Mutation) error {
+	req := &spannerapi.CommitRequest{
+		SingleUseTransaction: &spannerapi.TransactionOptions{ReadWrite: &spannerapi.ReadWrite{}},
+	}
+	for _, mutation := range mutations {
+		req.Mutations = append(req.Mutations, mutation.write)
+	}
+	return c.retryAborted(ctx, func(session string) error {
+		ctx, cancel := c.timeout(ctx)
+		defer cancel()
+		_, err := c.sessions.Commit(session, req).Context(ctx).Do()
+		return toError(err)
+	})
+}
+
+// BatchUpdate runs DML statements in order in one read-write transaction and commits it,
+// returning how many rows each changed. It stops at the first failing statement and commits
+// nothing
+func (c *Client) BatchUpdate(ctx context.Context, stmts []Statement) ([]int64, error) {
+	encoded := make([]*spannerapi.Statement, len(stmts))
+	for i, stmt := range stmts {
+		params, types, err := encodeParams(stmt.Params)
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = &spannerapi.Statement{Sql: stmt.SQL, Params: params, ParamTypes: types}
+	}
+
+	var counts []int64
+	err := c.retryAborted(ctx, func(session string) error {
+		ctx, cancel := c.timeout(ctx)
+		defer cancel()
+		result, err := c.sessions.ExecuteBatchDml(session, &spannerapi.ExecuteBatchDmlRequest{
+			Transaction: &spannerapi.TransactionSelector{Begin: &spannerapi.TransactionOptions{ReadWrite: &spannerapi.ReadWrite{}}},
+			Statements:  encoded,
+			Seqno:       1,
+		}).Context(ctx).Do()
+		if err != nil {
+			return toError(err)
+		}
+		status := result.Status
+		if status == nil {
+			status = &spannerapi.Status{}
+		}
+		if len(result.ResultSets) == 0 {
+			return fmt.Errorf("spanner: batch DML returned no results: %s", status.Message)
+		}
+		var txID string
+		if metadata := result.ResultSets[0].Metadata; metadata != nil && metadata.Transaction != nil {
+			txID = metadata.Transaction.Id
+		}
+		if status.Code != 0 {
+			c.sessions.Rollback(session, &spannerapi.RollbackRequest{TransactionId: txID}).Context(ctx).Do()
+			return &Error{Status: codeName(int(status.Code)), Message: fmt.Sprintf("statement %d: %s", len(result.ResultSets), status.Message)}
+		}
+		counts = counts[:0]
+		for _, set := range result.ResultSets {
+			var n int64
+			if set.Stats != nil {
+				n = set.Stats.RowCountExact
+			}
+			counts = append(counts, n)
+		}
+		_, err = c.sessions.Commit(session, &spannerapi.CommitRequest{TransactionId: txID}).Context(ctx).Do()
+		return toError(err)
+	})
+	return counts, err
+}
+
+// retryAborted runs a read-write transaction, again when Spanner aborts it for contention
+func (c *Client) retryAborted(ctx context.Context, fn func(session string) error) error {
+	var err error
+	for attempt := 0; attempt < commitAttempts; attempt++ {
+		err = c.withSession(ctx, fn)
+		if !isStatus(err, "ABORTED") {
+			return err
+		}
+		select {
+		case <-time.After(time.Duration(attempt+1) * 50 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// executeSQL runs a statement in the selected transaction, recording the ID of a transaction
+// it begins in txID
+func (c *Client) executeSQL(ctx context.Context, session string, stmt Statement, selector *spannerapi.TransactionSelector, txID *string) (*Rows, error) {
+	params, types, err := encodeParams(stmt.Params)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.timeout(ctx)
+	defer cancel()
+	result, err := c.sessions.ExecuteSql(session, &spannerapi.ExecuteSqlRequest{
+		Sql:         stmt.SQL,
+		Params:      params,
+		ParamTypes:  types,
+		Transaction: selector,
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, toError(err)
+	}
+	var fields []*spannerapi.Field
+	if metadata := result.Metadata; metadata != nil {
+		if txID != nil && metadata.Transaction != nil && metadata.Transaction.Id != "" {
+			*txID = metadata.Transaction.Id
+		}
+		if metadata.RowType != nil {
+			fields = metadata.RowType.Fields
+		}
+	}
+
+	rows := &Rows{Rows: make([][]interface{}, 0, len(result.Rows))}
+	codes := make([]string, len(fields))
+	for i, field := range fields {
+		rows.Columns = append(rows.Columns, field.Name)
+		if field.Type != nil {
+			codes[i] = field.Type.Code
+		}
+	}
+	for _, raw := range result.Rows {
+		if len(raw) != len(codes) {
+			return nil, fmt.Errorf("spanner: row of %d values for %d columns", len(raw), len(codes))
+		}
+		row := make([]interface{}, len(raw))
+		for i, value := range raw {
+			if row[i], err = decodeValue(codes[i], value); err != nil {
+				return nil, fmt.Errorf("spanner: column %s: %w", rows.Columns[i], err)
+			}
+		}
+		rows.Rows = append(rows.Rows, row)
+	}
+	return rows, nil
+}
+
+// codeName names the gRPC status codes batch DML reports numerically
+func codeName(code int) string {
+	names := []string{"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND",
+		"ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED",
+		"OUT_OF_RANGE", "UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED"}
+	if code >= 0 && code < len(names) {
+		return names[code]
+	}
+	return strconv.Itoa(code)
+}
+
+func durationString(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
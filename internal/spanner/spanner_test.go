@@ -0,0 +1,283 @@
+package spanner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testDatabase = "projects/p/instances/i/databases/d"
+
+type call struct {
+	Method string
+	Path   string
+	Body   map[string]interface{}
+}
+
+// fakeSpanner is the part of the Spanner REST API the client uses. Session creation and
+// deletion are answered by the fake; every other call goes to handle
+type fakeSpanner struct {
+	handle func(path string, body map[string]interface{}) (int, interface{})
+
+	mu       sync.Mutex
+	calls    []call
+	sessions int
+}
+
+func newFake(t *testing.T, handle func(path string, body map[string]interface{}) (int, interface{})) (*fakeSpanner, *Client) {
+	t.Helper()
+	fake := &fakeSpanner{handle: handle}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client, err := NewClient(context.Background(), Options{
+		Database: testDatabase,
+		Endpoint: server.URL,
+		Emulator: true,
+		Sessions: 2,
+		Timeout:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, client
+}
+
+func (f *fakeSpanner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+	f.mu.Lock()
+	f.calls = append(f.calls, call{Method: r.Method, Path: r.URL.Path, Body: body})
+	var status int
+	var out interface{}
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/"+testDatabase+"/sessions":
+		f.sessions++
+		status, out = http.StatusOK, map[string]string{"name": fmt.Sprintf("%s/sessions/s%d", testDatabase, f.sessions)}
+	case r.Method == http.MethodDelete:
+		status, out = http.StatusOK, map[string]string{}
+	default:
+		f.mu.Unlock()
+		status, out = f.handle(r.URL.Path, body)
+		f.mu.Lock()
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(out)
+}
+
+// called returns the calls whose path ends with suffix
+func (f *fakeSpanner) called(suffix string) []call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var matched []call
+	for _, c := range f.calls {
+		if strings.HasSuffix(c.Path, suffix) {
+			matched = append(matched, c)
+		}
+	}
+	return matched
+}
+
+func apiError(status int, code, message string) (int, interface{}) {
+	return status, map[string]interface{}{"error": map[string]interface{}{"code": status, "status": code, "message": message}}
+}
+
+func resultSet(txID string, fields map[string]string, order []string, rows ...[]interface{}) map[string]interface{} {
+	var rowType []interface{}
+	for _, name := range order {
+		rowType = append(rowType, map[string]interface{}{"name": name, "type": map[string]string{"code": fields[name]}})
+	}
+	metadata := map[string]interface{}{"rowType": map[string]interface{}{"fields": rowType}}
+	if txID != "" {
+		metadata["transaction"] = map[string]string{"id": txID}
+	}
+	return map[string]interface{}{"metadata": metadata, "rows": rows}
+}
+
+func TestQueryDecodesRows(t *testing.T) {
+	fake, client := newFake(t, func(path string, body map[string]interface{}) (int, interface{}) {
+		return http.StatusOK, resultSet("",
+			map[string]string{"serial": "STRING", "validity": "INT64", "revoked_at": "TIMESTAMP", "held": "BOOL"},
+			[]string{"serial", "validity", "revoked_at", "held"},
+			[]interface{}{"0a1b", "3600", "2024-05-01T12:00:00.5Z", true},
+			[]interface{}{"0a1c", nil, nil, false},
+		)
+	})
+
+	rows, err := client.Query(context.Background(), Staleness(15*time.Second), Statement{
+		SQL:    "SELECT serial, validity, revoked_at, held FROM ocsp_responses WHERE serial >= @from",
+		Params: map[string]interface{}{"from": "0a", "limit": int64(2), "since": (*time.Time)(nil)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows.Rows) != 2 || strings.Join(rows.Columns, ",") != "serial,validity,revoked_at,held" {
+		t.Fatalf("Query() = %+v", rows)
+	}
+	revokedAt := time.Date(2024, 5, 1, 12, 0, 0, 5e8, time.UTC)
+	if got := rows.Rows[0]; got[0] != "0a1b" || got[1] != int64(3600) || !got[2].(time.Time).Equal(revokedAt) || got[3] != true {
+		t.Errorf("first row = %#v", got)
+	}
+	if got := rows.Rows[1]; got[1] != nil || got[2] != nil || got[3] != false {
+		t.Errorf("second row = %#v", got)
+	}
+
+	sent := fake.called(":executeSql")[0].Body
+	readOnly := sent["transaction"].(map[string]interface{})["singleUse"].(map[string]interface{})["readOnly"].(map[string]interface{})
+	if readOnly["maxStaleness"] != "15s" {
+		t.Errorf("readOnly = %v, want maxStaleness 15s", readOnly)
+	}
+	params, types := sent["params"].(map[string]interface{}), sent["paramTypes"].(map[string]interface{})
+	if params["limit"] != "2" || params["since"] != nil || types["since"].(map[string]interface{})["code"] != "TIMESTAMP" {
+		t.Errorf("params = %v, types = %v", params, types)
+	}
+}
+
+func TestQueryReplacesLostSession(t *testing.T) {
+	var mu sync.Mutex
+	sessions := map[string]bool{}
+	fake, client := newFake(t, func(path string, body map[string]interface{}) (int, interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		session := strings.TrimSuffix(strings.TrimPrefix(path, "/v1/"), ":executeSql")
+		if len(sessions) == 0 {
+			sessions[session] = true
+			return apiError(http.StatusNotFound, "NOT_FOUND", "Session not found: "+session)
+		}
+		sessions[session] = true
+		return http.StatusOK, resultSet("", map[string]string{"n": "INT64"}, []string{"n"}, []interface{}{"1"})
+	})
+
+	rows, err := client.Query(context.Background(), 0, Statement{SQL: "SELECT 1 AS n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows.Rows[0][0] != int64(1) {
+		t.Errorf("Query() = %v", rows.Rows)
+	}
+	if len(sessions) != 2 || fake.sessions != 2 {
+		t.Errorf("used sessions %v after creating %d, want the lost one replaced", sessions, fake.sessions)
+	}
+	if strong := fake.called(":executeSql")[1].Body["transaction"].(map[string]interface{})["singleUse"].(map[string]interface{})["readOnly"].(map[string]interface{})["strong"]; strong != true {
+		t.Errorf("zero staleness sent strong = %v", strong)
+	}
+
+	client.Close()
+	if deleted := fake.called("/sessions/s2"); len(deleted) != 1 || deleted[0].Method != http.MethodDelete {
+		t.Errorf("Close() did not delete the replacement session: %v", deleted)
+	}
+}
+
+func TestApplyRetriesAborted(t *testing.T) {
+	commits := 0
+	fake, client := newFake(t, func(path string, body map[string]interface{}) (int, interface{}) {
+		commits++
+		if commits == 1 {
+			return apiError(http.StatusConflict, "ABORTED", "Transaction was aborted")
+		}
+		return http.StatusOK, map[string]string{"commitTimestamp": "2024-05-01T12:00:00Z"}
+	})
+
+	mutation, err := InsertOrUpdate("ocsp_responses", []string{"serial", "revoked_at"}, [][]interface{}{{"0a", (*time.Time)(nil)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Apply(context.Background(), []Mutation{mutation}); err != nil {
+		t.Fatal(err)
+	}
+	calls := fake.called(":commit")
+	if len(calls) != 2 {
+		t.Fatalf("committed %d times, want a retry after the abort", len(calls))
+	}
+	write := calls[1].Body["mutations"].([]interface{})[0].(map[string]interface{})["insertOrUpdate"].(map[string]interface{})
+	if write["table"] != "ocsp_responses" || fmt.Sprint(write["values"]) != "[[0a <nil>]]" {
+		t.Errorf("mutation = %v", write)
+	}
+}
+
+func TestBatchUpdate(t *testing.T) {
+	fail := false
+	fake, client := newFake(t, func(path string, body map[string]interface{}) (int, interface{}) {
+		switch {
+		case strings.HasSuffix(path, ":executeBatchDml"):
+			first := map[string]interface{}{
+				"metadata": map[string]interface{}{"transaction": map[string]string{"id": "tx1"}},
+				"stats":    map[string]string{"rowCountExact": "1"},
+			}
+			if fail {
+				return http.StatusOK, map[string]interface{}{
+					"resultSets": []interface{}{first},
+					"status":     map[string]interface{}{"code": 9, "message": "check constraint violated"},
+				}
+			}
+			second := map[string]interface{}{"stats": map[string]string{"rowCountExact": "0"}}
+			return http.StatusOK, map[string]interface{}{"resultSets": []interface{}{first, second}, "status": map[string]interface{}{}}
+		default:
+			return http.StatusOK, map[string]string{}
+		}
+	})
+	stmts := []Statement{
+		{SQL: "UPDATE ocsp_responses SET status = @status WHERE serial = @serial", Params: map[string]interface{}{"status": "revoked", "serial": "0a"}},
+		{SQL: "UPDATE ocsp_responses SET status = @status WHERE serial = @serial", Params: map[string]interface{}{"status": "revoked", "serial": "0b"}},
+	}
+
+	counts, err := client.BatchUpdate(context.Background(), stmts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(counts) != "[1 0]" {
+		t.Errorf("counts = %v", counts)
+	}
+	if commits := fake.called(":commit"); len(commits) != 1 || commits[0].Body["transactionId"] != "tx1" {
+		t.Errorf("commits = %v, want tx1 committed", commits)
+	}
+
+	fail = true
+	_, err = client.BatchUpdate(context.Background(), stmts)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Status != "FAILED_PRECONDITION" {
+		t.Fatalf("BatchUpdate() error = %v, want FAILED_PRECONDITION", err)
+	}
+	if rollbacks := fake.called(":rollback"); len(rollbacks) != 1 || rollbacks[0].Body["transactionId"] != "tx1" {
+		t.Errorf("rollbacks = %v, want tx1 rolled back", rollbacks)
+	}
+	if commits := fake.called(":commit"); len(commits) != 1 {
+		t.Errorf("a failed batch was committed")
+	}
+}
+
+func TestSnapshotReusesTransaction(t *testing.T) {
+	fake, client := newFake(t, func(path string, body map[string]interface{}) (int, interface{}) {
+		return http.StatusOK, resultSet("tx7", map[string]string{"serial": "STRING"}, []string{"serial"}, []interface{}{"0a"})
+	})
+
+	err := client.Snapshot(context.Background(), func(query func(Statement) (*Rows, error)) error {
+		for i := 0; i < 2; i++ {
+			if _, err := query(Statement{SQL: "SELECT serial FROM ocsp_responses"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := fake.called(":executeSql")
+	if _, ok := calls[0].Body["transaction"].(map[string]interface{})["begin"]; !ok {
+		t.Errorf("first query did not begin a transaction: %v", calls[0].Body["transaction"])
+	}
+	if id := calls[1].Body["transaction"].(map[string]interface{})["id"]; id != "tx7" {
+		t.Errorf("second query ran in transaction %v, want tx7", id)
+	}
+}
@@ -0,0 +1,108 @@
+package spanner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	spannerapi "google.golang.org/api/spanner/v1"
+)
+
+// Mutation is a write committed with Apply
+type Mutation struct {
+	write *spannerapi.Mutation
+}
+
+// InsertOrUpdate writes rows of the columns, inserting those whose key is new. Columns not
+// listed keep their values in rows that exist
+func InsertOrUpdate(table string, columns []string, rows [][]interface{}) (Mutation, error) {
+	values := make([][]interface{}, len(rows))
+	for i, row := range rows {
+		values[i] = make([]interface{}, len(row))
+		for j, value := range row {
+			encoded, _, err := encodeValue(value)
+			if err != nil {
+				return Mutation{}, fmt.Errorf("spanner: row %d, column %s: %w", i, columns[j], err)
+			}
+			values[i][j] = encoded
+		}
+	}
+	return Mutation{write: &spannerapi.Mutation{InsertOrUpdate: &spannerapi.Write{Table: table, Columns: columns, Values: values}}}, nil
+}
+
+func encodeParams(params map[string]interface{}) (googleapi.RawMessage, map[string]spannerapi.Type, error) {
+	values := make(map[string]interface{}, len(params))
+	types := make(map[string]spannerapi.Type, len(params))
+	for name, value := range params {
+		encoded, code, err := encodeValue(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("spanner: parameter %s: %w", name, err)
+		}
+		values[name] = encoded
+		types[name] = spannerapi.Type{Code: code}
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, types, nil
+}
+
+// encodeValue converts a value to its JSON form and type code: string to STRING, int64 to
+// INT64, bool to BOOL and time.Time to TIMESTAMP. Nil pointers are typed nulls
+func encodeValue(value interface{}) (interface{}, string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, "STRING", nil
+	case int64:
+		return strconv.FormatInt(v, 10), "INT64", nil
+	case *int64:
+		if v == nil {
+			return nil, "INT64", nil
+		}
+		return strconv.FormatInt(*v, 10), "INT64", nil
+	case bool:
+		return v, "BOOL", nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano), "TIMESTAMP", nil
+	case *time.Time:
+		if v == nil {
+			return nil, "TIMESTAMP", nil
+		}
+		return v.UTC().Format(time.RFC3339Nano), "TIMESTAMP", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported type %T", value)
+	}
+}
+
+// decodeValue converts a JSON value of a column of type code: STRING to string, INT64 to int64,
+// BOOL to bool and TIMESTAMP to time.Time. Nulls decode to nil
+func decodeValue(code string, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	switch code {
+	case "BOOL":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("invalid BOOL %v", value)
+		}
+		return b, nil
+	case "STRING", "INT64", "TIMESTAMP":
+	default:
+		return nil, fmt.Errorf("unsupported column type %s", code)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s %v", code, value)
+	}
+	switch code {
+	case "INT64":
+		return strconv.ParseInt(s, 10, 64)
+	case "TIMESTAMP":
+		return time.Parse(time.RFC3339Nano, s)
+	}
+	return s, nil
+}
@@ -0,0 +1,327 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// scanPageSize is the number of rows fetched per page when scanning the status table
+const scanPageSize = 5000
+
+const cassandraColumns = `serial, status, this_update, next_update, revoked_at, revocation_reason, not_after, validity_seconds`
+
+// CassandraOptions sets the keyspace of the status table and the consistency level of each
+// kind of operation
+type CassandraOptions struct {
+	Keyspace string
+	// Read is used by Get
+	Read gocql.Consistency
+	// Write is used by single status changes, seeding, expiry recording and replication
+	Write gocql.Consistency
+	// Batch is used by ApplyBatch, so mass issuance can trade durability for throughput
+	// separately from single changes
+	Batch gocql.Consistency
+	// Scan is used by the listings, which read the whole table
+	Scan gocql.Consistency
+	// Serial is the consistency of the Paxos round of conditional writes
+	Serial gocql.SerialConsistency
+	// BatchSize is the most statements sent in one logged batch
+	BatchSize int
+}
+
+// Cassandra stores statuses in Cassandra or ScyllaDB; CRL numbers stay in the home database.
+// Listings scan the table, and large batches are applied as several logged batches
+type Cassandra struct {
+	session *gocql.Session
+	home    *Postgres
+	opts    CassandraOptions
+	table   string
+}
+
+// NewCassandra stores statuses through session, allocating CRL numbers from home
+func NewCassandra(session *gocql.Session, home *Postgres, opts CassandraOptions) *Cassandra {
+	return &Cassandra{session: session, home: home, opts: opts, table: opts.Keyspace + ".ocsp_responses"}
+}
+
+// Get returns the status for a serial
+func (c *Cassandra) Get(ctx context.Context, serial string) (*Record, error) {
+	var row cassandraRow
+	err := c.session.Query(`SELECT `+cassandraColumns+` FROM `+c.table+` WHERE serial = ?`, serial).
+		WithContext(ctx).Consistency(c.opts.Read).Scan(row.dest()...)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	rec := row.record()
+	return &rec, nil
+}
+
+// Upsert inserts or replaces the status for a single serial
+func (c *Cassandra) Upsert(ctx context.Context, update Update) error {
+	stmt := c.upsert(update, time.Now())
+	return c.session.Query(stmt.query, stmt.values...).WithContext(ctx).Consistency(c.opts.Write).Exec()
+}
+
+// ApplyBatch applies the updates in logged batches of up to BatchSize
+func (c *Cassandra) ApplyBatch(ctx context.Context, updates []Update) error {
+	now := time.Now()
+	for start := 0; start < len(updates); start += c.opts.BatchSize {
+		end := min(start+c.opts.BatchSize, len(updates))
+		batch := c.session.NewBatch(gocql.LoggedBatch).WithContext(ctx)
+		batch.SetConsistency(c.opts.Batch)
+		for _, update := range updates[start:end] {
+			stmt := c.upsert(update, now)
+			batch.Query(stmt.query, stmt.values...)
+		}
+		if err := c.session.ExecuteBatch(batch); err != nil {
+			return fmt.Errorf("failed to apply updates %d-%d: %w", start, end, err)
+		}
+	}
+	return nil
+}
+
+type cassandraStatement struct {
+	query  string
+	values []interface{}
+}
+
+// upsert writes an update the way Postgres does: timestamps from now, the revocation cleared
+// for other statuses and the validity override left alone when the update has none. The
+// expiry is not a column of the statement, so it survives
+func (c *Cassandra) upsert(update Update, now time.Time) cassandraStatement {
+	var revokedAt *time.Time
+	if update.Status == StatusRevoked {
+		revokedAt = update.RevokedAt
+	}
+	query := `INSERT INTO ` + c.table + ` (serial, status, this_update, next_update, revoked_at, revocation_reason) VALUES (?, ?, ?, ?, ?, ?)`
+	values := []interface{}{update.Serial, update.Status, now, now.Add(24 * time.Hour), revokedAt, update.RevocationReason}
+	if update.Validity != nil {
+		query = `INSERT INTO ` + c.table + ` (serial, status, this_update, next_update, revoked_at, revocation_reason, validity_seconds) VALUES (?, ?, ?, ?, ?, ?, ?)`
+		var seconds *int32
+		if *update.Validity > 0 {
+			s := int32(update.Validity.Seconds())
+			seconds = &s
+		}
+		values = append(values, seconds)
+	}
+	return cassandraStatement{query: query, values: values}
+}
+
+// ListRevoked returns every revoked serial, ordered by serial
+func (c *Cassandra) ListRevoked(ctx context.Context) ([]Record, error) {
+	var records []Record
+	err := c.scan(ctx, `WHERE status = ? ALLOW FILTERING`, []interface{}{StatusRevoked}, func(rec Record) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Serial < records[j].Serial })
+	return records, nil
+}
+
+// ForEachRecord streams every stored status in the table's token order, which is not serial
+// order
+func (c *Cassandra) ForEachRecord(ctx context.Context, fn func(Record) error) error {
+	return c.scan(ctx, "", nil, fn)
+}
+
+// ListRevokedSince returns serials whose revoked status was written at or after since, oldest first
+func (c *Cassandra) ListRevokedSince(ctx context.Context, since time.Time) ([]Record, error) {
+	var records []Record
+	err := c.scan(ctx, `WHERE status = ? AND this_update >= ? ALLOW FILTERING`, []interface{}{StatusRevoked, since}, func(rec Record) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].ThisUpdate.Before(records[j].ThisUpdate) })
+	return records, nil
+}
+
+// ListRange returns statuses in rng in numeric serial order. Partitions are not stored in
+// serial order, so the whole table is scanned, keeping the lowest rng.Limit matches
+func (c *Cassandra) ListRange(ctx context.Context, rng SerialRange) ([]Record, error) {
+	var records []Record
+	err := c.scan(ctx, "", nil, func(rec Record) error {
+		if !rng.contains(rec) {
+			return nil
+		}
+		i := sort.Search(len(records), func(i int) bool { return SerialLess(rec.Serial, records[i].Serial) })
+		if i >= rng.Limit {
+			return nil
+		}
+		records = append(records, Record{})
+		copy(records[i+1:], records[i:])
+		records[i] = rec
+		if len(records) > rng.Limit {
+			records = records[:rng.Limit]
+		}
+		return nil
+	})
+	return records, err
+}
+
+// contains reports whether rec falls in the range, past After
+func (rng SerialRange) contains(rec Record) bool {
+	switch {
+	case rng.Prefix != "" && !strings.HasPrefix(rec.Serial, rng.Prefix):
+		return false
+	case rng.From != "" && SerialLess(rec.Serial, rng.From):
+		return false
+	case rng.To != "" && SerialLess(rng.To, rec.Serial):
+		return false
+	case rng.After != "" && !SerialLess(rng.After, rec.Serial):
+		return false
+	case rng.Status != "" && rec.Status != rng.Status:
+		return false
+	}
+	return true
+}
+
+func (c *Cassandra) scan(ctx context.Context, where string, values []interface{}, fn func(Record) error) error {
+	query := `SELECT ` + cassandraColumns + ` FROM ` + c.table
+	if where != "" {
+		query += " " + where
+	}
+	iter := c.session.Query(query, values...).WithContext(ctx).Consistency(c.opts.Scan).PageSize(scanPageSize).Iter()
+	var row cassandraRow
+	for iter.Scan(row.dest()...) {
+		if err := fn(row.record()); err != nil {
+			iter.Close()
+			return err
+		}
+		row = cassandraRow{}
+	}
+	return iter.Close()
+}
+
+// conditional runs a lightweight transaction and reports whether it was applied
+func (c *Cassandra) conditional(ctx context.Context, query string, values ...interface{}) (bool, error) {
+	return c.session.Query(query, values...).WithContext(ctx).
+		Consistency(c.opts.Write).SerialConsistency(c.opts.Serial).
+		MapScanCAS(make(map[string]interface{}))
+}
+
+// InsertMissing inserts statuses for serials without a row, one conditional insert each
+func (c *Cassandra) InsertMissing(ctx context.Context, updates []Update) (int, error) {
+	inserted := 0
+	for _, update := range updates {
+		now := time.Now()
+		applied, err := c.conditional(ctx,
+			`INSERT INTO `+c.table+` (serial, status, this_update, next_update, revocation_reason) VALUES (?, ?, ?, ?, '') IF NOT EXISTS`,
+			update.Serial, update.Status, now, now.Add(24*time.Hour))
+		if err != nil {
+			return inserted, err
+		}
+		if applied {
+			inserted++
+		}
+	}
+	return inserted, nil
+}
+
+// RecordExpiry sets not_after for the listed serials that have a status. Each is a
+// conditional update, since a plain one would create a row for a serial without a status
+func (c *Cassandra) RecordExpiry(ctx context.Context, notAfter map[string]time.Time) error {
+	for serial, t := range notAfter {
+		_, err := c.conditional(ctx,
+			`UPDATE `+c.table+` SET not_after = ? WHERE serial = ? IF EXISTS`, t.UTC(), serial)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyIfNewer stores rec, timestamps, expiry and validity override included, unless the stored
+// status has a later or equal this_update: a conditional insert for a new serial, then a
+// conditional update
+func (c *Cassandra) ApplyIfNewer(ctx context.Context, rec Record) (bool, error) {
+	var revokedAt, notAfter *time.Time
+	if rec.Status == StatusRevoked && rec.RevokedAt != nil {
+		t := rec.RevokedAt.UTC()
+		revokedAt = &t
+	}
+	if rec.NotAfter != nil {
+		t := rec.NotAfter.UTC()
+		notAfter = &t
+	}
+	var validity *int32
+	if rec.Validity > 0 {
+		s := int32(rec.Validity.Seconds())
+		validity = &s
+	}
+	applied, err := c.conditional(ctx,
+		`INSERT INTO `+c.table+` (serial, status, this_update, next_update, revoked_at, revocation_reason, not_after, validity_seconds) VALUES (?, ?, ?, ?, ?, ?, ?, ?) IF NOT EXISTS`,
+		rec.Serial, rec.Status, rec.ThisUpdate.UTC(), rec.NextUpdate.UTC(), revokedAt, rec.RevocationReason, notAfter, validity)
+	if err != nil || applied {
+		return applied, err
+	}
+	return c.conditional(ctx,
+		`UPDATE `+c.table+` SET status = ?, this_update = ?, next_update = ?, revoked_at = ?, revocation_reason = ?, not_after = ?, validity_seconds = ? WHERE serial = ? IF this_update < ?`,
+		rec.Status, rec.ThisUpdate.UTC(), rec.NextUpdate.UTC(), revokedAt, rec.RevocationReason, notAfter, validity, rec.Serial, rec.ThisUpdate.UTC())
+}
+
+// NextCRLNumber allocates the number from the home database
+func (c *Cassandra) NextCRLNumber(ctx context.Context, issuer string) (int64, error) {
+	return c.home.NextCRLNumber(ctx, issuer)
+}
+
+// ListCRLNumbers lists the numbers in the home database
+func (c *Cassandra) ListCRLNumbers(ctx context.Context) ([]CRLNumber, error) {
+	return c.home.ListCRLNumbers(ctx)
+}
+
+// Close closes the connections to the nodes
+func (c *Cassandra) Close() {
+	c.session.Close()
+}
+
+// Ping checks that a node answers
+func (c *Cassandra) Ping(ctx context.Context) error {
+	return c.session.Query(`SELECT release_version FROM system.local`).WithContext(ctx).Consistency(gocql.LocalOne).Exec()
+}
+
+// cassandraRow holds a row of cassandraColumns as scanned; null timestamps and validity
+// scan as zero values
+type cassandraRow struct {
+	serial, status, reason string
+	thisUpdate, nextUpdate time.Time
+	revokedAt, notAfter    time.Time
+	validitySeconds        int
+}
+
+func (r *cassandraRow) dest() []interface{} {
+	return []interface{}{&r.serial, &r.status, &r.thisUpdate, &r.nextUpdate, &r.revokedAt, &r.reason, &r.notAfter, &r.validitySeconds}
+}
+
+func (r *cassandraRow) record() Record {
+	rec := Record{
+		Serial:           r.serial,
+		Status:           r.status,
+		ThisUpdate:       r.thisUpdate,
+		NextUpdate:       r.nextUpdate,
+		RevocationReason: r.reason,
+		Validity:         time.Duration(r.validitySeconds) * time.Second,
+	}
+	if !r.revokedAt.IsZero() {
+		revokedAt := r.revokedAt
+		rec.RevokedAt = &revokedAt
+	}
+	if !r.notAfter.IsZero() {
+		notAfter := r.notAfter
+		rec.NotAfter = &notAfter
+	}
+	return rec
+}
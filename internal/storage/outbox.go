@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/gigvault/shared/pkg/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// outboxLockKey is the transaction advisory lock held while relaying an outbox, so replicas
+// relay it one at a time and in order
+const outboxLockKey = 0x6f7574626f78 // "outbox"
+
+const outboxInsertQuery = `
+	INSERT INTO event_outbox (serial, status, revoked_at, revocation_reason)
+	VALUES ($1, $2, $3, $4)
+`
+
+type outboxKey struct{}
+
+// WithOutbox marks writes made with ctx so Postgres also records their events in the
+// event_outbox table, in the same transaction as the status change
+func WithOutbox(ctx context.Context) context.Context {
+	return context.WithValue(ctx, outboxKey{}, true)
+}
+
+func outboxed(ctx context.Context) bool {
+	marked, _ := ctx.Value(outboxKey{}).(bool)
+	return marked
+}
+
+// OutboxEntry is a recorded status change awaiting publication
+type OutboxEntry struct {
+	ID               int64
+	EventID          string
+	CreatedAt        time.Time
+	Serial           string
+	Status           string
+	RevokedAt        *time.Time
+	RevocationReason string
+}
+
+func outboxArgs(update Update) []interface{} {
+	if update.Status != StatusRevoked {
+		return []interface{}{update.Serial, update.Status, nil, ""}
+	}
+	return []interface{}{update.Serial, update.Status, update.RevokedAt, update.RevocationReason}
+}
+
+// RelayOutbox passes up to limit unpublished entries, oldest first, to publish and marks them
+// published once it returns nil. It holds a transaction advisory lock meanwhile and returns 0
+// without calling publish when another replica holds it
+func (p *Postgres) RelayOutbox(ctx context.Context, limit int, publish func([]OutboxEntry) error) (int, error) {
+	relayed := 0
+	err := db.WithTransaction(ctx, p.db, func(tx pgx.Tx) error {
+		var locked bool
+		if err := tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, int64(outboxLockKey)).Scan(&locked); err != nil || !locked {
+			return err
+		}
+
+		rows, err := tx.Query(ctx, `
+			SELECT id, event_id, created_at, serial, status, revoked_at, revocation_reason
+			FROM event_outbox
+			WHERE published_at IS NULL
+			ORDER BY id
+			LIMIT $1
+		`, limit)
+		if err != nil {
+			return err
+		}
+		entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (OutboxEntry, error) {
+			var e OutboxEntry
+			err := row.Scan(&e.ID, &e.EventID, &e.CreatedAt, &e.Serial, &e.Status, &e.RevokedAt, &e.RevocationReason)
+			return e, err
+		})
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+
+		if err := publish(entries); err != nil {
+			return err
+		}
+		ids := make([]int64, len(entries))
+		for i, e := range entries {
+			ids[i] = e.ID
+		}
+		if _, err := tx.Exec(ctx, `UPDATE event_outbox SET published_at = NOW() WHERE id = ANY($1)`, ids); err != nil {
+			return err
+		}
+		relayed = len(entries)
+		return nil
+	})
+	return relayed, err
+}
+
+// OutboxBacklog returns how many entries await publication
+func (p *Postgres) OutboxBacklog(ctx context.Context) (int64, error) {
+	var n int64
+	err := p.db.QueryRow(ctx, `SELECT COUNT(*) FROM event_outbox WHERE published_at IS NULL`).Scan(&n)
+	return n, err
+}
+
+// PurgeOutbox deletes entries published before cutoff
+func (p *Postgres) PurgeOutbox(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := p.db.Exec(ctx, `DELETE FROM event_outbox WHERE published_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
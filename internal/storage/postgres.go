@@ -0,0 +1,559 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gigvault/shared/pkg/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// batchChunkSize bounds the number of statements queued per pgx batch
+const batchChunkSize = 1000
+
+const upsertQuery = `
+	INSERT INTO ocsp_responses (serial, status, this_update, next_update, revoked_at, revocation_reason, validity_seconds)
+	VALUES ($1, $2, NOW(), NOW() + INTERVAL '24 hours', $3, $4, NULLIF($5::INTEGER, 0))
+	ON CONFLICT (serial) DO UPDATE SET
+		status = EXCLUDED.status,
+		this_update = NOW(),
+		next_update = NOW() + INTERVAL '24 hours',
+		revoked_at = EXCLUDED.revoked_at,
+		revocation_reason = EXCLUDED.revocation_reason,
+		validity_seconds = CASE WHEN $5::INTEGER IS NULL THEN ocsp_responses.validity_seconds ELSE EXCLUDED.validity_seconds END
+`
+
+// Postgres stores statuses in the ocsp_responses table
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgres creates a new Postgres-backed store
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Get returns the status for a serial
+func (p *Postgres) Get(ctx context.Context, serial string) (*Record, error) {
+	query := `
+		SELECT status, this_update, next_update, revoked_at, revocation_reason, not_after, validity_seconds
+		FROM ocsp_responses
+		WHERE serial = $1
+	`
+
+	rec := &Record{Serial: serial}
+	var validitySeconds *int64
+	err := p.db.QueryRow(ctx, query, serial).Scan(
+		&rec.Status,
+		&rec.ThisUpdate,
+		&rec.NextUpdate,
+		&rec.RevokedAt,
+		&rec.RevocationReason,
+		&rec.NotAfter,
+		&validitySeconds,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if validitySeconds != nil {
+		rec.Validity = time.Duration(*validitySeconds) * time.Second
+	}
+
+	return rec, nil
+}
+
+// GetMany returns the statuses of serials by serial, leaving out those without one
+func (p *Postgres) GetMany(ctx context.Context, serials []string) (map[string]*Record, error) {
+	query := `
+		SELECT serial, status, this_update, next_update, revoked_at, revocation_reason
+		FROM ocsp_responses
+		WHERE serial = ANY($1)
+	`
+
+	rows, err := p.db.Query(ctx, query, serials)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]*Record, len(serials))
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		found[rec.Serial] = &rec
+	}
+	return found, rows.Err()
+}
+
+// Upsert inserts or replaces the status for a single serial. With a WithOutbox context, its
+// event is recorded in the same transaction
+func (p *Postgres) Upsert(ctx context.Context, update Update) error {
+	if !outboxed(ctx) {
+		_, err := p.db.Exec(ctx, upsertQuery, upsertArgs(update)...)
+		return err
+	}
+	return db.WithTransaction(ctx, p.db, func(tx pgx.Tx) error {
+		batch := &pgx.Batch{}
+		batch.Queue(upsertQuery, upsertArgs(update)...)
+		batch.Queue(outboxInsertQuery, outboxArgs(update)...)
+		return tx.SendBatch(ctx, batch).Close()
+	})
+}
+
+// ApplyBatch applies all updates in a single transaction, along with their events when ctx is
+// a WithOutbox context
+func (p *Postgres) ApplyBatch(ctx context.Context, updates []Update) error {
+	outbox := outboxed(ctx)
+	return db.WithTransaction(ctx, p.db, func(tx pgx.Tx) error {
+		for start := 0; start < len(updates); start += batchChunkSize {
+			end := start + batchChunkSize
+			if end > len(updates) {
+				end = len(updates)
+			}
+
+			batch := &pgx.Batch{}
+			for _, update := range updates[start:end] {
+				batch.Queue(upsertQuery, upsertArgs(update)...)
+				if outbox {
+					batch.Queue(outboxInsertQuery, outboxArgs(update)...)
+				}
+			}
+			if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+				return fmt.Errorf("failed to apply updates %d-%d: %w", start, end, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Writable reports whether the database user may change statuses
+func (p *Postgres) Writable(ctx context.Context) (bool, error) {
+	var writable bool
+	err := p.db.QueryRow(ctx, `SELECT has_table_privilege('ocsp_responses', 'INSERT, UPDATE, DELETE, TRUNCATE')`).Scan(&writable)
+	return writable, err
+}
+
+// ListRevoked returns every revoked serial, ordered by serial
+func (p *Postgres) ListRevoked(ctx context.Context) ([]Record, error) {
+	query := `
+		SELECT serial, status, this_update, next_update, revoked_at, revocation_reason
+		FROM ocsp_responses
+		WHERE status = 'revoked'
+		ORDER BY serial
+	`
+
+	rows, err := p.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRecords(rows)
+}
+
+// ForEachRecord streams every stored status, ordered by serial, without loading them all
+func (p *Postgres) ForEachRecord(ctx context.Context, fn func(Record) error) error {
+	query := `
+		SELECT serial, status, this_update, next_update, revoked_at, revocation_reason, not_after, validity_seconds
+		FROM ocsp_responses
+		ORDER BY serial
+	`
+
+	rows, err := p.db.Query(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rec Record
+		var validitySeconds *int64
+		err := rows.Scan(&rec.Serial, &rec.Status, &rec.ThisUpdate, &rec.NextUpdate, &rec.RevokedAt, &rec.RevocationReason,
+			&rec.NotAfter, &validitySeconds)
+		if err != nil {
+			return err
+		}
+		if validitySeconds != nil {
+			rec.Validity = time.Duration(*validitySeconds) * time.Second
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func scanRecords(rows pgx.Rows) ([]Record, error) {
+	var records []Record
+	for rows.Next() {
+		rec, err := scanRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+func scanRecord(rows pgx.Rows) (Record, error) {
+	var rec Record
+	err := rows.Scan(
+		&rec.Serial,
+		&rec.Status,
+		&rec.ThisUpdate,
+		&rec.NextUpdate,
+		&rec.RevokedAt,
+		&rec.RevocationReason,
+	)
+	return rec, err
+}
+
+// InsertMissing inserts statuses for serials without a row in a single statement
+func (p *Postgres) InsertMissing(ctx context.Context, updates []Update) (int, error) {
+	if len(updates) == 0 {
+		return 0, nil
+	}
+
+	query := `
+		INSERT INTO ocsp_responses (serial, status, this_update, next_update, revoked_at, revocation_reason)
+		SELECT u.serial, u.status, NOW(), NOW() + INTERVAL '24 hours', NULL, ''
+		FROM UNNEST($1::TEXT[], $2::TEXT[]) AS u(serial, status)
+		ON CONFLICT (serial) DO NOTHING
+	`
+
+	serials := make([]string, len(updates))
+	statuses := make([]string, len(updates))
+	for i, update := range updates {
+		serials[i] = update.Serial
+		statuses[i] = update.Status
+	}
+
+	tag, err := p.db.Exec(ctx, query, serials, statuses)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// RecordExpiry sets not_after for the listed serials in a single statement
+func (p *Postgres) RecordExpiry(ctx context.Context, notAfter map[string]time.Time) error {
+	if len(notAfter) == 0 {
+		return nil
+	}
+
+	query := `
+		UPDATE ocsp_responses o
+		SET not_after = u.not_after
+		FROM UNNEST($1::TEXT[], $2::TIMESTAMP[]) AS u(serial, not_after)
+		WHERE o.serial = u.serial AND o.not_after IS DISTINCT FROM u.not_after
+	`
+
+	serials := make([]string, 0, len(notAfter))
+	times := make([]time.Time, 0, len(notAfter))
+	for serial, t := range notAfter {
+		serials = append(serials, serial)
+		times = append(times, t.UTC())
+	}
+	_, err := p.db.Exec(ctx, query, serials, times)
+	return err
+}
+
+// ApplyIfNewer upserts rec with its own this_update and next_update, expiry and validity
+// override, only replacing a stored status whose this_update is older
+func (p *Postgres) ApplyIfNewer(ctx context.Context, rec Record) (bool, error) {
+	query := `
+		INSERT INTO ocsp_responses (serial, status, this_update, next_update, revoked_at, revocation_reason, not_after, validity_seconds)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8::INTEGER, 0))
+		ON CONFLICT (serial) DO UPDATE SET
+			status = EXCLUDED.status,
+			this_update = EXCLUDED.this_update,
+			next_update = EXCLUDED.next_update,
+			revoked_at = EXCLUDED.revoked_at,
+			revocation_reason = EXCLUDED.revocation_reason,
+			not_after = EXCLUDED.not_after,
+			validity_seconds = EXCLUDED.validity_seconds
+		WHERE ocsp_responses.this_update < EXCLUDED.this_update
+	`
+
+	var revokedAt, notAfter *time.Time
+	if rec.Status == StatusRevoked && rec.RevokedAt != nil {
+		t := rec.RevokedAt.UTC()
+		revokedAt = &t
+	}
+	if rec.NotAfter != nil {
+		t := rec.NotAfter.UTC()
+		notAfter = &t
+	}
+	tag, err := p.db.Exec(ctx, query, rec.Serial, rec.Status, rec.ThisUpdate.UTC(), rec.NextUpdate.UTC(), revokedAt, rec.RevocationReason,
+		notAfter, int64(rec.Validity/time.Second))
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// DeleteIfUnchanged deletes a serial's status unless it has been written since thisUpdate,
+// reporting whether it was deleted
+func (p *Postgres) DeleteIfUnchanged(ctx context.Context, serial string, thisUpdate time.Time) (bool, error) {
+	tag, err := p.db.Exec(ctx, `DELETE FROM ocsp_responses WHERE serial = $1 AND this_update = $2`, serial, thisUpdate.UTC())
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Ping checks that the database answers
+func (p *Postgres) Ping(ctx context.Context) error {
+	return p.db.Ping(ctx)
+}
+
+// ListRevokedSince returns serials whose revoked status was written at or after since, oldest first
+func (p *Postgres) ListRevokedSince(ctx context.Context, since time.Time) ([]Record, error) {
+	query := `
+		SELECT serial, status, this_update, next_update, revoked_at, revocation_reason
+		FROM ocsp_responses
+		WHERE status = 'revoked' AND this_update >= $1
+		ORDER BY this_update
+	`
+
+	rows, err := p.db.Query(ctx, query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRecords(rows)
+}
+
+// ListRange returns statuses in rng in numeric serial order, which for stored serials is their
+// length and then their hex, compared bytewise
+func (p *Postgres) ListRange(ctx context.Context, rng SerialRange) ([]Record, error) {
+	query := `
+		SELECT serial, status, this_update, next_update, revoked_at, revocation_reason
+		FROM ocsp_responses
+		WHERE ($1 = '' OR serial COLLATE "C" LIKE $1 || '%')
+			AND ($2 = '' OR (LENGTH(serial), serial COLLATE "C") >= (LENGTH($2), $2 COLLATE "C"))
+			AND ($3 = '' OR (LENGTH(serial), serial COLLATE "C") <= (LENGTH($3), $3 COLLATE "C"))
+			AND ($4 = '' OR (LENGTH(serial), serial COLLATE "C") > (LENGTH($4), $4 COLLATE "C"))
+			AND ($5 = '' OR status = $5)
+		ORDER BY LENGTH(serial), serial COLLATE "C"
+		LIMIT $6
+	`
+
+	rows, err := p.db.Query(ctx, query, rng.Prefix, rng.From, rng.To, rng.After, rng.Status, rng.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanRecords(rows)
+}
+
+// NextCRLNumber atomically allocates the next CRL number for the issuer
+func (p *Postgres) NextCRLNumber(ctx context.Context, issuer string) (int64, error) {
+	query := `
+		INSERT INTO crl_numbers (issuer, number, updated_at)
+		VALUES ($1, 1, NOW())
+		ON CONFLICT (issuer) DO UPDATE SET
+			number = crl_numbers.number + 1,
+			updated_at = NOW()
+		RETURNING number
+	`
+
+	var number int64
+	if err := p.db.QueryRow(ctx, query, issuer).Scan(&number); err != nil {
+		return 0, err
+	}
+	return number, nil
+}
+
+// CRLNumber is the last CRL number issued for an issuer
+type CRLNumber struct {
+	Issuer    string    `json:"issuer"`
+	Number    int64     `json:"number"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ErrNotEmpty is returned when restoring into a database that already holds state
+var ErrNotEmpty = errors.New("database already holds revocation state")
+
+// ListCRLNumbers returns the CRL number of every issuer, ordered by issuer
+func (p *Postgres) ListCRLNumbers(ctx context.Context) ([]CRLNumber, error) {
+	rows, err := p.db.Query(ctx, `SELECT issuer, number, updated_at FROM crl_numbers ORDER BY issuer`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var numbers []CRLNumber
+	for rows.Next() {
+		var n CRLNumber
+		if err := rows.Scan(&n.Issuer, &n.Number, &n.UpdatedAt); err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers, rows.Err()
+}
+
+// ArchivedTables are the tables besides statuses and CRL numbers that backups carry whole
+var ArchivedTables = []string{
+	"compromised_keys", "serial_aliases", "scheduled_revocations", "certificate_holds",
+	"feature_flags", "approval_requests", "audit_log", "dead_letters", "api_keys",
+	"runtime_tunables", "tunable_changes", "issuers", "response_log",
+}
+
+// unarchivedTables are the tables backups leave out, with why; every other table a migration
+// creates must be archived
+var unarchivedTables = map[string]string{
+	"signed_responses": "a cache, signed again from the restored statuses",
+	"event_outbox":     "restored events would be relayed to sinks a second time",
+}
+
+// sequencedTables are the archived tables whose BIGSERIAL id must move past restored rows
+var sequencedTables = map[string]bool{
+	"scheduled_revocations": true,
+	"approval_requests":     true,
+	"audit_log":             true,
+	"dead_letters":          true,
+	"tunable_changes":       true,
+	"response_log":          true,
+}
+
+// ForEachRow calls fn with every row of one of ArchivedTables, as a JSON object
+func (p *Postgres) ForEachRow(ctx context.Context, table string, fn func(json.RawMessage) error) error {
+	rows, err := p.db.Query(ctx, `SELECT row_to_json(t) FROM `+pgx.Identifier{table}.Sanitize()+` t`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var row json.RawMessage
+		if err := rows.Scan(&row); err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Restore loads records, CRL numbers and archived tables as they were, timestamps included
+type Restore struct {
+	tx pgx.Tx
+}
+
+// InsertRecords copies records into the status table
+func (r *Restore) InsertRecords(ctx context.Context, records []Record) error {
+	rows := make([][]interface{}, len(records))
+	for i, rec := range records {
+		var validitySeconds *int64
+		if rec.Validity > 0 {
+			seconds := int64(rec.Validity / time.Second)
+			validitySeconds = &seconds
+		}
+		rows[i] = []interface{}{rec.Serial, rec.Status, rec.ThisUpdate, rec.NextUpdate, rec.RevokedAt, rec.RevocationReason,
+			rec.NotAfter, validitySeconds}
+	}
+	_, err := r.tx.CopyFrom(ctx, pgx.Identifier{"ocsp_responses"},
+		[]string{"serial", "status", "this_update", "next_update", "revoked_at", "revocation_reason", "not_after", "validity_seconds"},
+		pgx.CopyFromRows(rows))
+	return err
+}
+
+// InsertRows inserts rows, JSON objects as ForEachRow returns them, into one of ArchivedTables
+func (r *Restore) InsertRows(ctx context.Context, table string, rows []json.RawMessage) error {
+	ident := pgx.Identifier{table}.Sanitize()
+	_, err := r.tx.Exec(ctx, `
+		INSERT INTO `+ident+`
+		SELECT r.* FROM json_array_elements($1::json) AS e, json_populate_record(NULL::`+ident+`, e) AS r
+	`, rows)
+	if err != nil || !sequencedTables[table] {
+		return err
+	}
+	_, err = r.tx.Exec(ctx, `SELECT setval(pg_get_serial_sequence($1, 'id'), MAX(id)) FROM `+ident+` HAVING MAX(id) IS NOT NULL`, table)
+	return err
+}
+
+// InsertCRLNumbers copies CRL numbers into the numbering table
+func (r *Restore) InsertCRLNumbers(ctx context.Context, numbers []CRLNumber) error {
+	rows := make([][]interface{}, len(numbers))
+	for i, n := range numbers {
+		rows[i] = []interface{}{n.Issuer, n.Number, n.UpdatedAt}
+	}
+	_, err := r.tx.CopyFrom(ctx, pgx.Identifier{"crl_numbers"}, []string{"issuer", "number", "updated_at"}, pgx.CopyFromRows(rows))
+	return err
+}
+
+// Restore runs fn in one transaction against an empty database, committing only if fn
+// succeeds; it returns ErrNotEmpty if any status, CRL number or archived row already exists
+func (p *Postgres) Restore(ctx context.Context, fn func(*Restore) error) error {
+	return db.WithTransaction(ctx, p.db, func(tx pgx.Tx) error {
+		tables := append([]string{"ocsp_responses", "crl_numbers"}, ArchivedTables...)
+		// Block concurrent writers so the emptiness check holds until commit
+		for _, table := range tables {
+			ident := pgx.Identifier{table}.Sanitize()
+			if _, err := tx.Exec(ctx, `LOCK TABLE `+ident+` IN EXCLUSIVE MODE`); err != nil {
+				return err
+			}
+			var populated bool
+			if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM `+ident+`)`).Scan(&populated); err != nil {
+				return err
+			}
+			if populated {
+				return ErrNotEmpty
+			}
+		}
+
+		return fn(&Restore{tx: tx})
+	})
+}
+
+func upsertArgs(update Update) []interface{} {
+	var revokedAt *time.Time
+	if update.Status == StatusRevoked {
+		revokedAt = update.RevokedAt
+	}
+	var validitySeconds *int64
+	if update.Validity != nil {
+		seconds := int64(update.Validity.Seconds())
+		validitySeconds = &seconds
+	}
+	return []interface{}{update.Serial, update.Status, revokedAt, update.RevocationReason, validitySeconds}
+}
+
+// Listen calls fn with the payload of every notification on channel until ctx is cancelled or
+// the connection fails, calling listening once the subscription is in place. It takes a
+// connection out of the pool for good, and closes it on return
+func (p *Postgres) Listen(ctx context.Context, channel string, listening func(), fn func(payload string)) error {
+	pooled, err := p.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// A connection left listening must not go back to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+	listening()
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		fn(notification.Payload)
+	}
+}
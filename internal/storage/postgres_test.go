@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+var (
+	createTable = regexp.MustCompile(`(?i)CREATE TABLE (?:IF NOT EXISTS )?(\w+) \(([^;]*)\);`)
+	serialID    = regexp.MustCompile(`(?i)\bid BIGSERIAL\b`)
+	comment     = regexp.MustCompile(`--.*`)
+)
+
+// TestArchivedTablesCoverMigrations fails when a migration creates a table backups neither
+// carry nor leave out on purpose, so a new table cannot be lost by a restore unnoticed
+func TestArchivedTablesCoverMigrations(t *testing.T) {
+	paths, err := filepath.Glob("../../migrations/*.sql")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no migrations found: %v", err)
+	}
+	created := make(map[string]bool)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range createTable.FindAllStringSubmatch(comment.ReplaceAllString(string(data), ""), -1) {
+			table := m[1]
+			created[table] = true
+			archived := slices.Contains(ArchivedTables, table)
+			switch {
+			case table == "ocsp_responses" || table == "crl_numbers":
+			case archived && unarchivedTables[table] != "":
+				t.Errorf("%s: table %s is both archived and left out", filepath.Base(path), table)
+			case !archived && unarchivedTables[table] == "":
+				t.Errorf("%s: table %s is missing from ArchivedTables", filepath.Base(path), table)
+			case archived && serialID.MatchString(m[2]) != sequencedTables[table]:
+				t.Errorf("%s: table %s has a BIGSERIAL id but is not in sequencedTables, or the reverse", filepath.Base(path), table)
+			}
+		}
+	}
+	for _, table := range ArchivedTables {
+		if !created[table] {
+			t.Errorf("archived table %s is created by no migration", table)
+		}
+	}
+	for table := range unarchivedTables {
+		if !created[table] {
+			t.Errorf("left out table %s is created by no migration", table)
+		}
+	}
+}
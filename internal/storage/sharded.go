@@ -0,0 +1,467 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var shardUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metrics.Namespace,
+	Name:      "storage_shard_up",
+	Help:      "Whether the status database shard answered its last health check.",
+}, []string{"shard"})
+
+func init() {
+	metrics.Registry.MustRegister(shardUp)
+}
+
+// ShardIndex returns which of n shards holds serial. It is a jump consistent hash of the
+// serial's FNV-1a hash, so growing from n to n+1 shards moves only a 1/(n+1) share of the
+// serials, all of them to the new shard
+func ShardIndex(serial string, n int) int {
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(serial)))
+	key := h.Sum64()
+
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Shard is one database holding part of the serial space
+type Shard struct {
+	Name  string
+	Store *Postgres
+
+	health atomic.Pointer[ShardHealth]
+}
+
+// ShardHealth is the outcome of a shard's latest health check
+type ShardHealth struct {
+	Name      string    `json:"name"`
+	Retiring  bool      `json:"retiring,omitempty"`
+	Healthy   bool      `json:"healthy"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Sharded spreads statuses across databases by ShardIndex of the serial. Batches are atomic per
+// shard, and while resharding, reads that miss fall back to the other shards
+type Sharded struct {
+	home     *Postgres
+	shards   []*Shard
+	retiring []*Shard
+	// resharding enables the fallbacks while statuses are being moved
+	resharding bool
+}
+
+// NewSharded routes statuses across shards. retiring shards receive no writes; with
+// resharding, they and the other active shards are searched when a serial's shard misses
+func NewSharded(home *Postgres, shards, retiring []*Shard, resharding bool) (*Sharded, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("at least one shard is required")
+	}
+	return &Sharded{home: home, shards: shards, retiring: retiring, resharding: resharding}, nil
+}
+
+func (s *Sharded) shardFor(serial string) *Shard {
+	return s.shards[ShardIndex(serial, len(s.shards))]
+}
+
+// all returns the active shards followed by the retiring ones
+func (s *Sharded) all() []*Shard {
+	return append(append([]*Shard{}, s.shards...), s.retiring...)
+}
+
+// Databases returns the database of every active and retiring shard
+func (s *Sharded) Databases() []*Postgres {
+	var databases []*Postgres
+	for _, shard := range s.all() {
+		databases = append(databases, shard.Store)
+	}
+	return databases
+}
+
+// Get returns the status from the serial's shard or, while resharding, from whichever shard
+// holds the newest status for it
+func (s *Sharded) Get(ctx context.Context, serial string) (*Record, error) {
+	target := s.shardFor(serial)
+	rec, err := target.Store.Get(ctx, serial)
+	if !s.resharding || !errors.Is(err, ErrNotFound) {
+		return rec, err
+	}
+	for _, shard := range s.all() {
+		if shard == target {
+			continue
+		}
+		found, err := shard.Store.Get(ctx, serial)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %w", shard.Name, err)
+		}
+		if rec == nil || found.ThisUpdate.After(rec.ThisUpdate) {
+			rec = found
+		}
+	}
+	if rec == nil {
+		return nil, ErrNotFound
+	}
+	return rec, nil
+}
+
+// Upsert writes the status to the serial's shard
+func (s *Sharded) Upsert(ctx context.Context, update Update) error {
+	return s.shardFor(update.Serial).Store.Upsert(ctx, update)
+}
+
+// ApplyBatch applies each shard's share of the updates in one transaction on that shard. A
+// failing shard leaves the shares already applied in place
+func (s *Sharded) ApplyBatch(ctx context.Context, updates []Update) error {
+	for shard, share := range s.split(updates) {
+		if err := shard.Store.ApplyBatch(ctx, share); err != nil {
+			return fmt.Errorf("shard %s: %w", shard.Name, err)
+		}
+	}
+	return nil
+}
+
+// split groups updates by shard, keeping their order within each group
+func (s *Sharded) split(updates []Update) map[*Shard][]Update {
+	shares := make(map[*Shard][]Update)
+	for _, update := range updates {
+		shard := s.shardFor(update.Serial)
+		shares[shard] = append(shares[shard], update)
+	}
+	return shares
+}
+
+// ListRevoked returns every revoked serial across the shards, ordered by serial. While
+// resharding, a serial held by several shards is listed once, with its newest status
+func (s *Sharded) ListRevoked(ctx context.Context) ([]Record, error) {
+	var revoked []Record
+	for _, shard := range s.all() {
+		records, err := shard.Store.ListRevoked(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %w", shard.Name, err)
+		}
+		revoked = append(revoked, records...)
+	}
+	sort.SliceStable(revoked, func(i, j int) bool { return revoked[i].Serial < revoked[j].Serial })
+	if !s.resharding {
+		return revoked, nil
+	}
+
+	// A serial revoked on one shard may have a newer, unrevoked status on another
+	deduped := revoked[:0]
+	for i := 0; i < len(revoked); {
+		newest := revoked[i]
+		j := i + 1
+		for ; j < len(revoked) && revoked[j].Serial == newest.Serial; j++ {
+			if revoked[j].ThisUpdate.After(newest.ThisUpdate) {
+				newest = revoked[j]
+			}
+		}
+		if current, err := s.Get(ctx, newest.Serial); err == nil && current.Status == StatusRevoked {
+			deduped = append(deduped, *current)
+		} else if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		i = j
+	}
+	return deduped, nil
+}
+
+// ForEachRecord streams every status, shard by shard. While resharding a serial may be
+// visited once per shard holding it
+func (s *Sharded) ForEachRecord(ctx context.Context, fn func(Record) error) error {
+	for _, shard := range s.all() {
+		if err := shard.Store.ForEachRecord(ctx, fn); err != nil {
+			return fmt.Errorf("shard %s: %w", shard.Name, err)
+		}
+	}
+	return nil
+}
+
+// InsertMissing seeds each shard's share of the updates. While resharding, serials that have
+// a status on any shard are left alone, so a seeded status cannot shadow one not yet moved
+func (s *Sharded) InsertMissing(ctx context.Context, updates []Update) (int, error) {
+	if s.resharding {
+		missing := updates[:0:0]
+		for _, update := range updates {
+			_, err := s.Get(ctx, update.Serial)
+			if errors.Is(err, ErrNotFound) {
+				missing = append(missing, update)
+				continue
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+		updates = missing
+	}
+
+	inserted := 0
+	for shard, share := range s.split(updates) {
+		n, err := shard.Store.InsertMissing(ctx, share)
+		inserted += n
+		if err != nil {
+			return inserted, fmt.Errorf("shard %s: %w", shard.Name, err)
+		}
+	}
+	return inserted, nil
+}
+
+// RecordExpiry records expiry on the shards holding the serials
+func (s *Sharded) RecordExpiry(ctx context.Context, notAfter map[string]time.Time) error {
+	shares := make(map[*Shard]map[string]time.Time)
+	for serial, t := range notAfter {
+		shard := s.shardFor(serial)
+		if shares[shard] == nil {
+			shares[shard] = make(map[string]time.Time)
+		}
+		shares[shard][serial] = t
+	}
+	for shard, share := range shares {
+		if err := shard.Store.RecordExpiry(ctx, share); err != nil {
+			return fmt.Errorf("shard %s: %w", shard.Name, err)
+		}
+	}
+	return nil
+}
+
+// ApplyIfNewer applies a replicated status on the serial's shard
+func (s *Sharded) ApplyIfNewer(ctx context.Context, rec Record) (bool, error) {
+	return s.shardFor(rec.Serial).Store.ApplyIfNewer(ctx, rec)
+}
+
+// ListRevokedSince returns serials revoked at or after since across the shards, oldest first
+func (s *Sharded) ListRevokedSince(ctx context.Context, since time.Time) ([]Record, error) {
+	var revoked []Record
+	for _, shard := range s.all() {
+		records, err := shard.Store.ListRevokedSince(ctx, since)
+		if err != nil {
+			return nil, fmt.Errorf("shard %s: %w", shard.Name, err)
+		}
+		revoked = append(revoked, records...)
+	}
+	sort.SliceStable(revoked, func(i, j int) bool { return revoked[i].ThisUpdate.Before(revoked[j].ThisUpdate) })
+	return revoked, nil
+}
+
+// ListRange merges the statuses in rng of every shard. While resharding, a serial held by
+// several shards is listed once, with its newest status
+func (s *Sharded) ListRange(ctx context.Context, rng SerialRange) ([]Record, error) {
+	var listed []Record
+	for len(listed) < rng.Limit {
+		page, next, err := s.listRange(ctx, rng)
+		if err != nil {
+			return nil, err
+		}
+		listed = append(listed, page...)
+		if next == "" {
+			break
+		}
+		rng.After = next
+	}
+	if len(listed) > rng.Limit {
+		listed = listed[:rng.Limit]
+	}
+	return listed, nil
+}
+
+// listRange merges one page from every shard. It keeps serials up to the last one of the
+// lowest full page, past which a shard may hold serials it did not return, and returns that
+// serial to continue from, or "" when no page was full
+func (s *Sharded) listRange(ctx context.Context, rng SerialRange) ([]Record, string, error) {
+	var records []Record
+	next := ""
+	for _, shard := range s.all() {
+		found, err := shard.Store.ListRange(ctx, rng)
+		if err != nil {
+			return nil, "", fmt.Errorf("shard %s: %w", shard.Name, err)
+		}
+		if len(found) == rng.Limit {
+			if last := found[len(found)-1].Serial; next == "" || SerialLess(last, next) {
+				next = last
+			}
+		}
+		records = append(records, found...)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return SerialLess(records[i].Serial, records[j].Serial) })
+
+	merged := records[:0]
+	for _, rec := range records {
+		if next != "" && SerialLess(next, rec.Serial) {
+			break
+		}
+		if n := len(merged); n > 0 && merged[n-1].Serial == rec.Serial {
+			if rec.ThisUpdate.After(merged[n-1].ThisUpdate) {
+				merged[n-1] = rec
+			}
+			continue
+		}
+		merged = append(merged, rec)
+	}
+	if !s.resharding || rng.Status == "" {
+		return merged, next, nil
+	}
+
+	// A serial matching on one shard may have a newer status on another that does not
+	filtered := merged[:0]
+	for _, rec := range merged {
+		current, err := s.Get(ctx, rec.Serial)
+		if err == nil && current.Status == rng.Status {
+			filtered = append(filtered, *current)
+		} else if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, "", err
+		}
+	}
+	return filtered, next, nil
+}
+
+// NextCRLNumber allocates the number from the home database
+func (s *Sharded) NextCRLNumber(ctx context.Context, issuer string) (int64, error) {
+	return s.home.NextCRLNumber(ctx, issuer)
+}
+
+// ListCRLNumbers lists the numbers in the home database
+func (s *Sharded) ListCRLNumbers(ctx context.Context) ([]CRLNumber, error) {
+	return s.home.ListCRLNumbers(ctx)
+}
+
+// CheckHealth pings every shard and records the outcome
+func (s *Sharded) CheckHealth(ctx context.Context, timeout time.Duration) {
+	for _, shard := range s.all() {
+		pingCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := shard.Store.Ping(pingCtx)
+		cancel()
+
+		health := &ShardHealth{Name: shard.Name, Retiring: s.isRetiring(shard), Healthy: err == nil, CheckedAt: time.Now().UTC()}
+		if err != nil {
+			health.Error = err.Error()
+		}
+		shard.health.Store(health)
+		if health.Healthy {
+			shardUp.WithLabelValues(shard.Name).Set(1)
+		} else {
+			shardUp.WithLabelValues(shard.Name).Set(0)
+		}
+	}
+}
+
+func (s *Sharded) isRetiring(shard *Shard) bool {
+	for _, r := range s.retiring {
+		if r == shard {
+			return true
+		}
+	}
+	return false
+}
+
+// Health returns the latest health check of every shard; shards not yet checked are reported
+// unhealthy
+func (s *Sharded) Health() []ShardHealth {
+	var health []ShardHealth
+	for _, shard := range s.all() {
+		if h := shard.health.Load(); h != nil {
+			health = append(health, *h)
+			continue
+		}
+		health = append(health, ShardHealth{Name: shard.Name, Retiring: s.isRetiring(shard), Error: "not checked yet"})
+	}
+	return health
+}
+
+// RunHealthChecks checks every shard immediately and then on every interval until ctx is
+// cancelled
+func (s *Sharded) RunHealthChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.CheckHealth(ctx, interval)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReshardReport counts what a reshard moved out of one shard
+type ReshardReport struct {
+	Shard   string `json:"shard"`
+	Scanned int    `json:"scanned"`
+	Moved   int    `json:"moved"`
+	// Superseded statuses were already older than the copy on their new shard and were
+	// deleted without being copied
+	Superseded int `json:"superseded"`
+	// Skipped statuses changed while being moved and were left for the next run
+	Skipped int `json:"skipped"`
+}
+
+// Reshard moves every status not on its shard to that shard: it is copied unless the new
+// shard holds a newer status, then deleted from its old shard unless written in between. It
+// is safe to run while serving with resharding enabled, and to run again. With dryRun nothing
+// is written and Moved counts what would move
+func (s *Sharded) Reshard(ctx context.Context, dryRun bool, progress func(ReshardReport)) ([]ReshardReport, error) {
+	var reports []ReshardReport
+	for _, source := range s.all() {
+		report := ReshardReport{Shard: source.Name}
+		err := source.Store.ForEachRecord(ctx, func(rec Record) error {
+			if report.Scanned++; progress != nil && report.Scanned%10000 == 0 {
+				progress(report)
+			}
+			target := s.shardFor(rec.Serial)
+			if target == source {
+				return nil
+			}
+			if dryRun {
+				report.Moved++
+				return nil
+			}
+
+			copied, err := target.Store.ApplyIfNewer(ctx, rec)
+			if err != nil {
+				return fmt.Errorf("copy %s to shard %s: %w", rec.Serial, target.Name, err)
+			}
+			deleted, err := source.Store.DeleteIfUnchanged(ctx, rec.Serial, rec.ThisUpdate)
+			if err != nil {
+				return fmt.Errorf("delete %s: %w", rec.Serial, err)
+			}
+			switch {
+			case !deleted:
+				report.Skipped++
+			case copied:
+				report.Moved++
+			default:
+				report.Superseded++
+			}
+			return nil
+		})
+		reports = append(reports, report)
+		if err != nil {
+			return reports, fmt.Errorf("shard %s: %w", source.Name, err)
+		}
+		if progress != nil {
+			progress(report)
+		}
+	}
+	return reports, nil
+}
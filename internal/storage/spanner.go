@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/spanner"
+)
+
+// spannerPageSize is the number of rows read per query when listing the status table
+const spannerPageSize = 5000
+
+const spannerColumns = `serial, status, this_update, next_update, revoked_at, revocation_reason, not_after, validity_seconds`
+
+// SpannerOptions tunes how statuses are read and written
+type SpannerOptions struct {
+	// Staleness is how old the data a lookup reads may be; zero makes lookups strong reads
+	Staleness time.Duration
+	// BatchSize is the most rows written in one commit
+	BatchSize int
+}
+
+// Spanner stores statuses in Cloud Spanner; CRL numbers stay in the home database.
+// Lookups are stale reads and commits are atomic per BatchSize rows
+type Spanner struct {
+	client *spanner.Client
+	home   *Postgres
+	opts   SpannerOptions
+}
+
+// NewSpanner stores statuses through client, allocating CRL numbers from home
+func NewSpanner(client *spanner.Client, home *Postgres, opts SpannerOptions) *Spanner {
+	return &Spanner{client: client, home: home, opts: opts}
+}
+
+// Get returns the status for a serial, as of at most Staleness ago
+func (s *Spanner) Get(ctx context.Context, serial string) (*Record, error) {
+	rows, err := s.client.Query(ctx, spanner.Staleness(s.opts.Staleness), spanner.Statement{
+		SQL:    `SELECT ` + spannerColumns + ` FROM ocsp_responses WHERE serial = @serial`,
+		Params: map[string]interface{}{"serial": serial},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows.Rows) == 0 {
+		return nil, ErrNotFound
+	}
+	rec := spannerRecord(rows.Rows[0])
+	return &rec, nil
+}
+
+// Upsert inserts or replaces the status for a single serial
+func (s *Spanner) Upsert(ctx context.Context, update Update) error {
+	mutations, err := spannerMutations([]Update{update}, time.Now())
+	if err != nil {
+		return err
+	}
+	return s.client.Apply(ctx, mutations)
+}
+
+// ApplyBatch commits the updates as mutations, BatchSize rows per commit
+func (s *Spanner) ApplyBatch(ctx context.Context, updates []Update) error {
+	now := time.Now()
+	for start := 0; start < len(updates); start += s.opts.BatchSize {
+		end := min(start+s.opts.BatchSize, len(updates))
+		mutations, err := spannerMutations(updates[start:end], now)
+		if err == nil {
+			err = s.client.Apply(ctx, mutations)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply updates %d-%d: %w", start, end, err)
+		}
+	}
+	return nil
+}
+
+// spannerMutations writes updates the way Postgres does: timestamps from now, the revocation
+// cleared for other statuses and the validity override left alone when the update has none.
+// A mutation sets the same columns on every row, so updates that change the override go in a
+// mutation of their own
+func spannerMutations(updates []Update, now time.Time) ([]spanner.Mutation, error) {
+	var keeping, setting [][]interface{}
+	for _, update := range updates {
+		var revokedAt *time.Time
+		if update.Status == StatusRevoked {
+			revokedAt = update.RevokedAt
+		}
+		row := []interface{}{update.Serial, update.Status, now, now.Add(24 * time.Hour), revokedAt, update.RevocationReason}
+		if update.Validity == nil {
+			keeping = append(keeping, row)
+			continue
+		}
+		var seconds *int64
+		if *update.Validity > 0 {
+			n := int64(update.Validity.Seconds())
+			seconds = &n
+		}
+		setting = append(setting, append(row, seconds))
+	}
+
+	columns := []string{"serial", "status", "this_update", "next_update", "revoked_at", "revocation_reason"}
+	var mutations []spanner.Mutation
+	for _, group := range []struct {
+		columns []string
+		rows    [][]interface{}
+	}{
+		{columns, keeping},
+		{append(columns, "validity_seconds"), setting},
+	} {
+		if len(group.rows) == 0 {
+			continue
+		}
+		mutation, err := spanner.InsertOrUpdate("ocsp_responses", group.columns, group.rows)
+		if err != nil {
+			return nil, err
+		}
+		mutations = append(mutations, mutation)
+	}
+	return mutations, nil
+}
+
+// ListRevoked returns every revoked serial, ordered by serial
+func (s *Spanner) ListRevoked(ctx context.Context) ([]Record, error) {
+	var records []Record
+	err := s.scan(ctx, `status = 'revoked'`, nil, func(rec Record) error {
+		records = append(records, rec)
+		return nil
+	})
+	return records, err
+}
+
+// ForEachRecord streams every stored status, ordered by serial, from one snapshot
+func (s *Spanner) ForEachRecord(ctx context.Context, fn func(Record) error) error {
+	return s.scan(ctx, "", nil, fn)
+}
+
+// ListRevokedSince returns serials whose revoked status was written at or after since, oldest first
+func (s *Spanner) ListRevokedSince(ctx context.Context, since time.Time) ([]Record, error) {
+	var records []Record
+	err := s.scan(ctx, `status = 'revoked' AND this_update >= @since`, map[string]interface{}{"since": since}, func(rec Record) error {
+		records = append(records, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].ThisUpdate.Before(records[j].ThisUpdate) })
+	return records, nil
+}
+
+// scan pages through the statuses matching where in serial order, in one read-only
+// transaction so the listing is consistent
+func (s *Spanner) scan(ctx context.Context, where string, params map[string]interface{}, fn func(Record) error) error {
+	if where != "" {
+		where = "(" + where + ") AND "
+	}
+	sql := `SELECT ` + spannerColumns + ` FROM ocsp_responses WHERE ` + where + `serial > @after ORDER BY serial LIMIT ` + fmt.Sprint(spannerPageSize)
+	return s.client.Snapshot(ctx, func(query func(spanner.Statement) (*spanner.Rows, error)) error {
+		after := ""
+		for {
+			pageParams := map[string]interface{}{"after": after}
+			for name, value := range params {
+				pageParams[name] = value
+			}
+			rows, err := query(spanner.Statement{SQL: sql, Params: pageParams})
+			if err != nil {
+				return err
+			}
+			for _, row := range rows.Rows {
+				rec := spannerRecord(row)
+				if err := fn(rec); err != nil {
+					return err
+				}
+				after = rec.Serial
+			}
+			if len(rows.Rows) < spannerPageSize {
+				return nil
+			}
+		}
+	})
+}
+
+// ListRange returns statuses in rng in numeric serial order, which for stored serials is their
+// length and then their hex, compared bytewise
+func (s *Spanner) ListRange(ctx context.Context, rng SerialRange) ([]Record, error) {
+	rows, err := s.client.Query(ctx, 0, spanner.Statement{
+		SQL: `SELECT ` + spannerColumns + ` FROM ocsp_responses
+			WHERE (@prefix = '' OR STARTS_WITH(serial, @prefix))
+				AND (@from = '' OR CHAR_LENGTH(serial) > CHAR_LENGTH(@from) OR (CHAR_LENGTH(serial) = CHAR_LENGTH(@from) AND serial >= @from))
+				AND (@to = '' OR CHAR_LENGTH(serial) < CHAR_LENGTH(@to) OR (CHAR_LENGTH(serial) = CHAR_LENGTH(@to) AND serial <= @to))
+				AND (@after = '' OR CHAR_LENGTH(serial) > CHAR_LENGTH(@after) OR (CHAR_LENGTH(serial) = CHAR_LENGTH(@after) AND serial > @after))
+				AND (@status = '' OR status = @status)
+			ORDER BY CHAR_LENGTH(serial), serial
+			LIMIT @limit`,
+		Params: map[string]interface{}{
+			"prefix": rng.Prefix,
+			"from":   rng.From,
+			"to":     rng.To,
+			"after":  rng.After,
+			"status": rng.Status,
+			"limit":  int64(rng.Limit),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	records := make([]Record, 0, len(rows.Rows))
+	for _, row := range rows.Rows {
+		records = append(records, spannerRecord(row))
+	}
+	return records, nil
+}
+
+// InsertMissing inserts statuses for serials without a row, as batch DML of up to BatchSize
+// statements per transaction
+func (s *Spanner) InsertMissing(ctx context.Context, updates []Update) (int, error) {
+	now := time.Now()
+	stmts := make([]spanner.Statement, len(updates))
+	for i, update := range updates {
+		stmts[i] = spanner.Statement{
+			SQL: `INSERT OR IGNORE INTO ocsp_responses (serial, status, this_update, next_update, revocation_reason)
+				VALUES (@serial, @status, @this_update, @next_update, '')`,
+			Params: map[string]interface{}{
+				"serial":      update.Serial,
+				"status":      update.Status,
+				"this_update": now,
+				"next_update": now.Add(24 * time.Hour),
+			},
+		}
+	}
+	return s.batchUpdate(ctx, stmts)
+}
+
+// RecordExpiry sets not_after for the listed serials that have a status, as batch DML of up to
+// BatchSize statements per transaction
+func (s *Spanner) RecordExpiry(ctx context.Context, notAfter map[string]time.Time) error {
+	stmts := make([]spanner.Statement, 0, len(notAfter))
+	for serial, t := range notAfter {
+		stmts = append(stmts, spanner.Statement{
+			SQL: `UPDATE ocsp_responses SET not_after = @not_after
+				WHERE serial = @serial AND (not_after IS NULL OR not_after != @not_after)`,
+			Params: map[string]interface{}{"serial": serial, "not_after": t},
+		})
+	}
+	_, err := s.batchUpdate(ctx, stmts)
+	return err
+}
+
+// batchUpdate runs stmts BatchSize at a time and returns how many rows they changed
+func (s *Spanner) batchUpdate(ctx context.Context, stmts []spanner.Statement) (int, error) {
+	changed := 0
+	for start := 0; start < len(stmts); start += s.opts.BatchSize {
+		counts, err := s.client.BatchUpdate(ctx, stmts[start:min(start+s.opts.BatchSize, len(stmts))])
+		if err != nil {
+			return changed, err
+		}
+		for _, n := range counts {
+			changed += int(n)
+		}
+	}
+	return changed, nil
+}
+
+// ApplyIfNewer stores rec, timestamps, expiry and validity override included, unless the stored
+// status has a later or equal this_update. The insert and the conditional update run in one
+// transaction
+func (s *Spanner) ApplyIfNewer(ctx context.Context, rec Record) (bool, error) {
+	var revokedAt *time.Time
+	if rec.Status == StatusRevoked && rec.RevokedAt != nil {
+		revokedAt = rec.RevokedAt
+	}
+	var validity *int64
+	if rec.Validity > 0 {
+		n := int64(rec.Validity.Seconds())
+		validity = &n
+	}
+	params := map[string]interface{}{
+		"serial":            rec.Serial,
+		"status":            rec.Status,
+		"this_update":       rec.ThisUpdate,
+		"next_update":       rec.NextUpdate,
+		"revoked_at":        revokedAt,
+		"revocation_reason": rec.RevocationReason,
+		"not_after":         rec.NotAfter,
+		"validity_seconds":  validity,
+	}
+	counts, err := s.client.BatchUpdate(ctx, []spanner.Statement{
+		{
+			SQL: `INSERT OR IGNORE INTO ocsp_responses (serial, status, this_update, next_update, revoked_at, revocation_reason, not_after, validity_seconds)
+				VALUES (@serial, @status, @this_update, @next_update, @revoked_at, @revocation_reason, @not_after, @validity_seconds)`,
+			Params: params,
+		},
+		{
+			SQL: `UPDATE ocsp_responses SET status = @status, this_update = @this_update, next_update = @next_update,
+					revoked_at = @revoked_at, revocation_reason = @revocation_reason, not_after = @not_after,
+					validity_seconds = @validity_seconds
+				WHERE serial = @serial AND this_update < @this_update`,
+			Params: params,
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return counts[0]+counts[1] > 0, nil
+}
+
+// NextCRLNumber allocates the number from the home database
+func (s *Spanner) NextCRLNumber(ctx context.Context, issuer string) (int64, error) {
+	return s.home.NextCRLNumber(ctx, issuer)
+}
+
+// ListCRLNumbers lists the numbers in the home database
+func (s *Spanner) ListCRLNumbers(ctx context.Context) ([]CRLNumber, error) {
+	return s.home.ListCRLNumbers(ctx)
+}
+
+// Close deletes the client's idle sessions
+func (s *Spanner) Close() {
+	s.client.Close()
+}
+
+// spannerRecord reads a row of spannerColumns
+func spannerRecord(row []interface{}) Record {
+	rec := Record{}
+	rec.Serial, _ = row[0].(string)
+	rec.Status, _ = row[1].(string)
+	rec.ThisUpdate, _ = row[2].(time.Time)
+	rec.NextUpdate, _ = row[3].(time.Time)
+	if t, ok := row[4].(time.Time); ok {
+		rec.RevokedAt = &t
+	}
+	rec.RevocationReason, _ = row[5].(string)
+	if t, ok := row[6].(time.Time); ok {
+		rec.NotAfter = &t
+	}
+	if seconds, ok := row[7].(int64); ok {
+		rec.Validity = time.Duration(seconds) * time.Second
+	}
+	return rec
+}
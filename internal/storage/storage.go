@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Certificate status values
+const (
+	StatusGood    = "good"
+	StatusRevoked = "revoked"
+	StatusUnknown = "unknown"
+)
+
+// ErrNotFound is returned when no status exists for a serial
+var ErrNotFound = errors.New("certificate status not found")
+
+// Record is the stored status of a certificate
+type Record struct {
+	Serial           string     `json:"serial"`
+	Status           string     `json:"status"`
+	ThisUpdate       time.Time  `json:"this_update"`
+	NextUpdate       time.Time  `json:"next_update"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RevocationReason string     `json:"revocation_reason,omitempty"`
+	// NotAfter is the certificate's expiry, when it has been recorded
+	NotAfter *time.Time `json:"not_after,omitempty"`
+	// Validity, when set, overrides how long signed responses about the certificate are valid
+	Validity time.Duration `json:"validity,omitempty"`
+}
+
+// Update is a status change to apply to a serial
+type Update struct {
+	Serial           string     `json:"serial"`
+	Status           string     `json:"status"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RevocationReason string     `json:"revocation_reason,omitempty"`
+	// Validity, when set, replaces the certificate's validity override, and zero removes it.
+	// Nil leaves the override as it is
+	Validity *time.Duration `json:"validity,omitempty"`
+}
+
+// Store persists certificate statuses
+type Store interface {
+	// Get returns the status for a serial, or ErrNotFound
+	Get(ctx context.Context, serial string) (*Record, error)
+	// Upsert inserts or replaces the status for a single serial
+	Upsert(ctx context.Context, update Update) error
+	// ApplyBatch applies all updates atomically
+	ApplyBatch(ctx context.Context, updates []Update) error
+	// ListRevoked returns every revoked serial, ordered by serial
+	ListRevoked(ctx context.Context) ([]Record, error)
+}
+
+// CRLNumbers allocates persisted, monotonically increasing CRL numbers
+type CRLNumbers interface {
+	// NextCRLNumber returns the next CRL number for the issuer
+	NextCRLNumber(ctx context.Context, issuer string) (int64, error)
+}
+
+// Seeder creates statuses for serials that have none, leaving existing rows untouched
+type Seeder interface {
+	// InsertMissing inserts the updates whose serials are absent and returns how many were inserted
+	InsertMissing(ctx context.Context, updates []Update) (int, error)
+}
+
+// ExpiryRecorder records when certificates expire, so the retention policy can purge their
+// statuses once they are long expired
+type ExpiryRecorder interface {
+	// RecordExpiry sets the expiry of the serials that have a status, ignoring the others
+	RecordExpiry(ctx context.Context, notAfter map[string]time.Time) error
+}
+
+// Replica applies statuses replicated from other instances, keeping whichever write is newer
+type Replica interface {
+	// ApplyIfNewer stores rec, timestamps, expiry and validity override included, unless the
+	// stored status has a later or equal this_update; it reports whether rec was stored
+	ApplyIfNewer(ctx context.Context, rec Record) (bool, error)
+}
+
+// SerialRange selects statuses by serial. Serials are lowercase hex without leading zeros, as
+// stored
+type SerialRange struct {
+	// Prefix keeps serials whose hex starts with it
+	Prefix string
+	// From and To, when set, bound serials numerically, inclusive
+	From, To string
+	// Status, when set, keeps statuses of that value
+	Status string
+	// After continues a listing after the last serial of the previous page
+	After string
+	Limit int
+}
+
+// SerialRanger lists statuses by serial range
+type SerialRanger interface {
+	// ListRange returns up to rng.Limit statuses in rng, in numeric serial order
+	ListRange(ctx context.Context, rng SerialRange) ([]Record, error)
+}
+
+// SerialLess orders serials as stored numerically: shorter ones first, then by their hex
+func SerialLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// ValidStatus reports whether status is one of the known status values
+func ValidStatus(status string) bool {
+	return status == StatusGood || status == StatusRevoked || status == StatusUnknown
+}
@@ -0,0 +1,118 @@
+// Package throttle bounds how many requests one source may have in flight, so a single
+// scanner cannot occupy every worker of a public endpoint
+package throttle
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var throttled = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "throttled_requests_total",
+	Help:      "Requests answered 429 because their source had too many in flight.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(throttled)
+}
+
+// Limiter counts in-flight requests per source. IPv4 sources are counted per address and IPv6
+// sources per /64, since one host usually holds a whole /64
+type Limiter struct {
+	max      int
+	mu       sync.Mutex
+	inflight map[netip.Prefix]int
+}
+
+// New creates a limiter admitting max concurrent requests per source. It returns nil when max
+// is not positive, and a nil Limiter admits everything
+func New(max int) *Limiter {
+	if max <= 0 {
+		return nil
+	}
+	return &Limiter{max: max, inflight: make(map[netip.Prefix]int)}
+}
+
+// Acquire admits a request from a "host:port" source, returning false when the source is at
+// its limit. release must be called once the request is done. Unparseable sources share one
+// slot pool
+func (l *Limiter) Acquire(remote string) (release func(), ok bool) {
+	if l == nil {
+		return func() {}, true
+	}
+	key := source(remote)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight[key] >= l.max {
+		throttled.Inc()
+		return nil, false
+	}
+	l.inflight[key]++
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if l.inflight[key]--; l.inflight[key] <= 0 {
+			delete(l.inflight, key)
+		}
+	}, true
+}
+
+// SetMax changes how many concurrent requests each source is admitted; requests already in
+// flight are not affected
+func (l *Limiter) SetMax(max int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.max = max
+}
+
+// Max returns how many concurrent requests each source is admitted
+func (l *Limiter) Max() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.max
+}
+
+func source(remote string) netip.Prefix {
+	addrPort, err := netip.ParseAddrPort(remote)
+	if err != nil {
+		return netip.Prefix{}
+	}
+	addr := addrPort.Addr().Unmap()
+	bits := addr.BitLen()
+	if addr.Is6() {
+		bits = 64
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix
+}
+
+// Middleware answers 429 with Retry-After to requests under prefixes whose source is at its
+// limit. Other paths pass through unthrottled
+func Middleware(l *Limiter, prefixes []string, next http.Handler) http.Handler {
+	if l == nil || len(prefixes) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, prefix := range prefixes {
+			if !strings.HasPrefix(r.URL.Path, prefix) {
+				continue
+			}
+			release, ok := l.Acquire(r.RemoteAddr)
+			if !ok {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "too many concurrent requests", http.StatusTooManyRequests)
+				return
+			}
+			defer release()
+			break
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,18 @@
+package toprequests
+
+import (
+	"context"
+
+	"github.com/gigvault/shared/api/proto/ocsp"
+	"google.golang.org/grpc"
+)
+
+// UnaryServerInterceptor counts CheckStatus lookups, answered or not
+func UnaryServerInterceptor(t *Tracker) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if r, ok := req.(*ocsp.CheckStatusRequest); ok && r.SerialNumber != "" {
+			t.ObserveSerial(r.SerialNumber)
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,70 @@
+package toprequests
+
+import "container/heap"
+
+// summary is a Space-Saving summary: it counts at most capacity keys, and a new key arriving
+// when it is full replaces the least counted one, taking over its count
+type summary struct {
+	capacity int
+	entries  map[string]*entry
+	heap     minHeap
+}
+
+type entry struct {
+	key   string
+	count int64
+	index int
+}
+
+func newSummary(capacity int) *summary {
+	return &summary{capacity: capacity, entries: make(map[string]*entry, capacity)}
+}
+
+func (s *summary) add(key string) {
+	if e, ok := s.entries[key]; ok {
+		e.count++
+		heap.Fix(&s.heap, e.index)
+		return
+	}
+	if len(s.heap) < s.capacity {
+		e := &entry{key: key, count: 1}
+		s.entries[key] = e
+		heap.Push(&s.heap, e)
+		return
+	}
+	e := s.heap[0]
+	delete(s.entries, e.key)
+	e.key = key
+	e.count++
+	s.entries[key] = e
+	heap.Fix(&s.heap, 0)
+}
+
+func (s *summary) reset() {
+	clear(s.entries)
+	s.heap = s.heap[:0]
+}
+
+// minHeap orders entries by count, least first
+type minHeap []*entry
+
+func (h minHeap) Len() int           { return len(h) }
+func (h minHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h minHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *minHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *minHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,218 @@
+// Package toprequests counts the most requested serials and issuers over sliding windows
+// with bounded Space-Saving summaries
+package toprequests
+
+import (
+	"context"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	topSerials = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "top_requested_serial_requests",
+		Help:      "Requests for the most requested serials, by window and serial.",
+	}, []string{"window", "serial"})
+	topIssuers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "top_requested_issuer_requests",
+		Help:      "Requests naming the most requested issuer key hashes, by window and issuer.",
+	}, []string{"window", "issuer"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(topSerials, topIssuers)
+}
+
+// Options sizes a tracker
+type Options struct {
+	// Windows are the spans counts are reported over; each is a multiple of Bucket
+	Windows []time.Duration
+	// Bucket is how finely windows slide
+	Bucket time.Duration
+	// Capacity is how many serials, and separately issuers, each bucket counts
+	Capacity int
+}
+
+// Count is how often a key was requested within a window
+type Count struct {
+	Key      string `json:"key"`
+	Requests int64  `json:"requests"`
+}
+
+// Top is the most requested serials and issuers within a window
+type Top struct {
+	Window  string  `json:"window"`
+	Serials []Count `json:"serials"`
+	Issuers []Count `json:"issuers"`
+}
+
+// Tracker counts requests by serial and issuer
+type Tracker struct {
+	opts Options
+
+	mu      sync.Mutex
+	buckets []*bucket
+
+	// published are the gauge label sets set by the last Publish
+	published map[*prometheus.GaugeVec][][]string
+}
+
+type bucket struct {
+	start            time.Time
+	serials, issuers *summary
+}
+
+// New creates a tracker keeping buckets for the longest of opts.Windows
+func New(opts Options) *Tracker {
+	longest := opts.Bucket
+	for _, window := range opts.Windows {
+		longest = max(longest, window)
+	}
+	buckets := make([]*bucket, int(longest/opts.Bucket))
+	for i := range buckets {
+		buckets[i] = &bucket{serials: newSummary(opts.Capacity), issuers: newSummary(opts.Capacity)}
+	}
+	return &Tracker{opts: opts, buckets: buckets}
+}
+
+// Windows returns the spans counts are reported over
+func (t *Tracker) Windows() []time.Duration {
+	return t.opts.Windows
+}
+
+// Observe counts a request for serial naming issuer; either may be empty
+func (t *Tracker) Observe(serial, issuer string) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	start := now.Truncate(t.opts.Bucket)
+	b := t.buckets[int(start.UnixNano()/int64(t.opts.Bucket))%len(t.buckets)]
+	if !b.start.Equal(start) {
+		b.start = start
+		b.serials.reset()
+		b.issuers.reset()
+	}
+	if serial != "" {
+		b.serials.add(serial)
+	}
+	if issuer != "" {
+		b.issuers.add(issuer)
+	}
+}
+
+// ObserveRequest counts an RFC 6960 request by its serial and the issuer key hash it names,
+// in hex, as hashed with the request's CertID algorithm
+func (t *Tracker) ObserveRequest(req *ocspreq.Request) {
+	t.Observe(req.SerialNumber.Text(16), hex.EncodeToString(req.IssuerKeyHash))
+}
+
+// ObserveSerial counts a status lookup by serial, which names no issuer. Serials that do not
+// parse are counted as given, so malformed lookups show up too
+func (t *Tracker) ObserveSerial(serial string) {
+	if normalized, err := bulk.NormalizeSerial(serial); err == nil {
+		serial = normalized
+	} else {
+		serial = strings.ToLower(serial)
+	}
+	t.Observe(serial, "")
+}
+
+// Top returns the n most requested serials and issuers within the window ending now
+func (t *Tracker) Top(window time.Duration, n int) Top {
+	oldest := time.Now().Truncate(t.opts.Bucket).Add(-window + t.opts.Bucket)
+	serials, issuers := make(map[string]int64), make(map[string]int64)
+
+	t.mu.Lock()
+	for _, b := range t.buckets {
+		if b.start.Before(oldest) {
+			continue
+		}
+		for _, e := range b.serials.heap {
+			serials[e.key] += e.count
+		}
+		for _, e := range b.issuers.heap {
+			issuers[e.key] += e.count
+		}
+	}
+	t.mu.Unlock()
+
+	return Top{Window: window.String(), Serials: highest(serials, n), Issuers: highest(issuers, n)}
+}
+
+// highest returns the n largest counts, ties broken by key
+func highest(counts map[string]int64, n int) []Count {
+	top := make([]Count, 0, len(counts))
+	for key, requests := range counts {
+		top = append(top, Count{Key: key, Requests: requests})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Requests != top[j].Requests {
+			return top[i].Requests > top[j].Requests
+		}
+		return top[i].Key < top[j].Key
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// Publish sets the gauges to the n most requested serials and issuers of every window,
+// removing keys that dropped out since the last call
+func (t *Tracker) Publish(n int) {
+	current := map[*prometheus.GaugeVec][][]string{}
+	for _, window := range t.opts.Windows {
+		top := t.Top(window, n)
+		for _, c := range top.Serials {
+			topSerials.WithLabelValues(top.Window, c.Key).Set(float64(c.Requests))
+			current[topSerials] = append(current[topSerials], []string{top.Window, c.Key})
+		}
+		for _, c := range top.Issuers {
+			topIssuers.WithLabelValues(top.Window, c.Key).Set(float64(c.Requests))
+			current[topIssuers] = append(current[topIssuers], []string{top.Window, c.Key})
+		}
+	}
+	for vec, labelSets := range t.published {
+		for _, labels := range labelSets {
+			if !contains(current[vec], labels) {
+				vec.DeleteLabelValues(labels...)
+			}
+		}
+	}
+	t.published = current
+}
+
+func contains(labelSets [][]string, labels []string) bool {
+	for _, set := range labelSets {
+		if set[0] == labels[0] && set[1] == labels[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// Run publishes the n most requested keys on every interval until ctx is cancelled
+func (t *Tracker) Run(ctx context.Context, interval time.Duration, n int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Publish(n)
+		}
+	}
+}
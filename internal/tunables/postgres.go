@@ -0,0 +1,118 @@
+package tunables
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MaxHistory bounds the changes returned by one history listing
+const MaxHistory = 1000
+
+// Persisted is a value stored for every replica
+type Persisted struct {
+	Name      string
+	Value     string
+	ChangedBy string
+	ChangedAt time.Time
+	Reason    string
+}
+
+// Change is one recorded change of a tunable
+type Change struct {
+	ID        int64     `json:"id"`
+	Time      time.Time `json:"time"`
+	Name      string    `json:"name"`
+	OldValue  string    `json:"old_value"`
+	NewValue  string    `json:"new_value"`
+	Persisted bool      `json:"persisted"`
+	Actor     string    `json:"actor"`
+	Reason    string    `json:"reason,omitempty"`
+	Replica   string    `json:"replica"`
+}
+
+// Postgres keeps persisted values in the runtime_tunables table and every change in
+// tunable_changes
+type Postgres struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgres creates a Postgres tunables store
+func NewPostgres(db *pgxpool.Pool) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Load returns every persisted value
+func (p *Postgres) Load(ctx context.Context) ([]Persisted, error) {
+	rows, err := p.db.Query(ctx, `SELECT name, value, changed_by, changed_at, reason FROM runtime_tunables ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var persisted []Persisted
+	for rows.Next() {
+		var v Persisted
+		if err := rows.Scan(&v.Name, &v.Value, &v.ChangedBy, &v.ChangedAt, &v.Reason); err != nil {
+			return nil, err
+		}
+		persisted = append(persisted, v)
+	}
+	return persisted, rows.Err()
+}
+
+// Save inserts or replaces the persisted value of a tunable
+func (p *Postgres) Save(ctx context.Context, name, value, changedBy, reason string) error {
+	_, err := p.db.Exec(ctx, `
+		INSERT INTO runtime_tunables (name, value, changed_by, changed_at, reason)
+		VALUES ($1, $2, $3, NOW(), $4)
+		ON CONFLICT (name) DO UPDATE SET
+			value = EXCLUDED.value,
+			changed_by = EXCLUDED.changed_by,
+			changed_at = NOW(),
+			reason = EXCLUDED.reason
+	`, name, value, changedBy, reason)
+	return err
+}
+
+// Delete removes the persisted value of a tunable, falling back to the configured one
+func (p *Postgres) Delete(ctx context.Context, name string) error {
+	_, err := p.db.Exec(ctx, `DELETE FROM runtime_tunables WHERE name = $1`, name)
+	return err
+}
+
+// Record appends a change to the history
+func (p *Postgres) Record(ctx context.Context, c Change) error {
+	_, err := p.db.Exec(ctx, `
+		INSERT INTO tunable_changes (name, old_value, new_value, persisted, actor, reason, replica)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, c.Name, c.OldValue, c.NewValue, c.Persisted, c.Actor, c.Reason, c.Replica)
+	return err
+}
+
+// History returns up to limit changes of the named tunable, or of every one when name is
+// empty, newest first
+func (p *Postgres) History(ctx context.Context, name string, limit int) ([]Change, error) {
+	rows, err := p.db.Query(ctx, `
+		SELECT id, changed_at, name, old_value, new_value, persisted, actor, reason, replica
+		FROM tunable_changes
+		WHERE $1 = '' OR name = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, name, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []Change
+	for rows.Next() {
+		var c Change
+		if err := rows.Scan(&c.ID, &c.Time, &c.Name, &c.OldValue, &c.NewValue, &c.Persisted, &c.Actor, &c.Reason, &c.Replica); err != nil {
+			return nil, err
+		}
+		history = append(history, c)
+	}
+	return history, rows.Err()
+}
@@ -0,0 +1,245 @@
+// Package tunables adjusts selected runtime parameters without a restart
+package tunables
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// Anonymous is recorded as the actor of changes made without an authenticated principal
+const Anonymous = "anonymous"
+
+var (
+	// ErrUnknown is returned for names no tunable is registered under
+	ErrUnknown = errors.New("unknown tunable")
+	// ErrInvalid is wrapped by the errors for values a tunable refuses
+	ErrInvalid = errors.New("invalid tunable value")
+)
+
+var changes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "tunable_changes_total",
+	Help:      "Runtime tunable changes applied on this replica, by tunable and source: api, reset or persisted (applied at startup).",
+}, []string{"name", "source"})
+
+func init() {
+	metrics.Registry.MustRegister(changes)
+}
+
+// Change sources, used in metric labels
+const (
+	sourceAPI       = "api"
+	sourceReset     = "reset"
+	sourcePersisted = "persisted"
+)
+
+// Tunable is a parameter that can be read and changed while the service runs
+type Tunable struct {
+	Description string
+	// Get returns the current value
+	Get func() string
+	// Set applies a value, or returns an error wrapping ErrInvalid
+	Set func(value string) error
+}
+
+// State is a tunable's current value, next to the configured one it started with
+type State struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Value       string `json:"value"`
+	Configured  string `json:"configured"`
+	// Persisted is set while the value is stored for every replica and survives restarts
+	Persisted bool       `json:"persisted"`
+	ChangedBy string     `json:"changed_by,omitempty"`
+	ChangedAt *time.Time `json:"changed_at,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+// Registry holds the tunables of this replica
+type Registry struct {
+	db      *Postgres
+	replica string
+	logger  *logger.Logger
+
+	mu       sync.Mutex
+	tunables map[string]*entry
+}
+
+type entry struct {
+	Tunable
+	state State
+}
+
+// New creates a registry recording changes in db, made on replica
+func New(db *Postgres, replica string, logger *logger.Logger) *Registry {
+	return &Registry{db: db, replica: replica, logger: logger, tunables: make(map[string]*entry)}
+}
+
+// Register adds a tunable; its value now is its configured value
+func (r *Registry) Register(name string, t Tunable) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	value := t.Get()
+	r.tunables[name] = &entry{Tunable: t, state: State{Name: name, Description: t.Description, Configured: value}}
+}
+
+// List returns every tunable, by name
+func (r *Registry) List() []State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	states := make([]State, 0, len(r.tunables))
+	for _, e := range r.tunables {
+		states = append(states, e.current())
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+	return states
+}
+
+// Get returns one tunable
+func (r *Registry) Get(name string) (State, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.tunables[name]
+	if !ok {
+		return State{}, ErrUnknown
+	}
+	return e.current(), nil
+}
+
+func (e *entry) current() State {
+	state := e.state
+	state.Value = e.Get()
+	return state
+}
+
+// Set applies value on this replica and records the change, made by the principal in ctx for
+// reason. With persist the value is stored for every replica too; without it, a value
+// persisted earlier is removed, so the change lasts until this replica restarts
+func (r *Registry) Set(ctx context.Context, name, value, reason string, persist bool) (State, error) {
+	return r.change(ctx, name, value, reason, persist, sourceAPI)
+}
+
+// Reset applies the configured value again, removing any persisted value
+func (r *Registry) Reset(ctx context.Context, name, reason string) (State, error) {
+	r.mu.Lock()
+	e, ok := r.tunables[name]
+	r.mu.Unlock()
+	if !ok {
+		return State{}, ErrUnknown
+	}
+	return r.change(ctx, name, e.state.Configured, reason, false, sourceReset)
+}
+
+func (r *Registry) change(ctx context.Context, name, value, reason string, persist bool, source string) (State, error) {
+	actor := approval.PrincipalFrom(ctx)
+	if actor == "" {
+		actor = Anonymous
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.tunables[name]
+	if !ok {
+		return State{}, ErrUnknown
+	}
+
+	old := e.Get()
+	if err := e.Set(value); err != nil {
+		return State{}, err
+	}
+	// A value whose persistence failed is taken back, so the next restart cannot silently
+	// drop or revive a change
+	var err error
+	if persist {
+		err = r.db.Save(ctx, name, value, actor, reason)
+	} else if e.state.Persisted {
+		err = r.db.Delete(ctx, name)
+	}
+	if err != nil {
+		e.Set(old)
+		return State{}, fmt.Errorf("persist %s: %w", name, err)
+	}
+
+	now := time.Now().UTC()
+	e.state.Persisted = persist
+	e.state.ChangedBy, e.state.ChangedAt, e.state.Reason = actor, &now, reason
+	state := e.current()
+	changes.WithLabelValues(name, source).Inc()
+	r.logger.Warn("Runtime tunable changed",
+		zap.String("name", name),
+		zap.String("from", old),
+		zap.String("to", state.Value),
+		zap.Bool("persisted", persist),
+		zap.String("actor", actor),
+		zap.String("reason", reason),
+	)
+	r.record(ctx, Change{Name: name, OldValue: old, NewValue: state.Value, Persisted: persist, Actor: actor, Reason: reason, Replica: r.replica})
+	return state, nil
+}
+
+// record keeps the change in the history. The change has been applied, so a failure to record
+// it is logged but does not undo it
+func (r *Registry) record(ctx context.Context, change Change) {
+	if err := r.db.Record(context.WithoutCancel(ctx), change); err != nil {
+		r.logger.Error("Failed to record runtime tunable change", zap.String("name", change.Name), zap.Error(err))
+	}
+}
+
+// Load applies the persisted values; those for unknown tunables, or that a tunable refuses, are
+// logged and skipped so a stale value cannot keep the service from starting
+func (r *Registry) Load(ctx context.Context) error {
+	persisted, err := r.db.Load(ctx)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range persisted {
+		e, ok := r.tunables[p.Name]
+		if !ok {
+			r.logger.Warn("Ignoring persisted value of unknown runtime tunable", zap.String("name", p.Name))
+			continue
+		}
+		if err := e.Set(p.Value); err != nil {
+			r.logger.Warn("Ignoring invalid persisted runtime tunable", zap.String("name", p.Name), zap.String("value", p.Value), zap.Error(err))
+			continue
+		}
+		changedAt := p.ChangedAt
+		e.state.Persisted = true
+		e.state.ChangedBy, e.state.ChangedAt, e.state.Reason = p.ChangedBy, &changedAt, p.Reason
+		changes.WithLabelValues(p.Name, sourcePersisted).Inc()
+		r.logger.Info("Applied persisted runtime tunable", zap.String("name", p.Name), zap.String("value", p.Value), zap.String("changed_by", p.ChangedBy))
+	}
+	return nil
+}
+
+// History returns the most recent changes, newest first
+func (r *Registry) History(ctx context.Context, name string, limit int) ([]Change, error) {
+	return r.db.History(ctx, name, limit)
+}
+
+// Int is a tunable integer of at least min
+func Int(description string, min int, get func() int, set func(int)) Tunable {
+	return Tunable{
+		Description: description,
+		Get:         func() string { return strconv.Itoa(get()) },
+		Set: func(value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < min {
+				return fmt.Errorf("%w: %q is not an integer of at least %d", ErrInvalid, value, min)
+			}
+			set(n)
+			return nil
+		},
+	}
+}
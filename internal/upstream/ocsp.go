@@ -0,0 +1,262 @@
+// Package upstream resolves serials unknown locally by querying a legacy OCSP responder
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/revocation"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+)
+
+// maxResponseSize bounds an upstream OCSP response
+const maxResponseSize = 1 << 20
+
+var lookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "upstream_ocsp_lookups_total",
+	Help:      "Lookups of locally unknown serials at the upstream OCSP responder, by result.",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(lookups)
+}
+
+// Options configures the upstream resolver
+type Options struct {
+	URL    string
+	Issuer *x509.Certificate
+	// MaxCacheTTL caps how long a response is cached, even if its nextUpdate is later
+	MaxCacheTTL time.Duration
+	// CacheSize bounds the number of cached responses
+	CacheSize int
+}
+
+// Resolver queries an upstream OCSP responder and caches verified responses until their
+// nextUpdate or MaxCacheTTL, whichever comes first
+type Resolver struct {
+	opts   Options
+	client *http.Client
+	logger *logger.Logger
+
+	mu     sync.Mutex
+	cache  map[string]cached
+	issuer *x509.Certificate
+}
+
+type cached struct {
+	record  storage.Record
+	expires time.Time
+}
+
+// NewResolver creates an upstream OCSP resolver
+func NewResolver(opts Options, logger *logger.Logger) *Resolver {
+	return &Resolver{
+		opts:   opts,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: logger,
+		cache:  make(map[string]cached),
+		issuer: opts.Issuer,
+	}
+}
+
+// SetIssuer replaces the issuer requests are built for and responses are verified against.
+// Cached responses were verified when fetched and are kept
+func (r *Resolver) SetIssuer(issuer *x509.Certificate) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.issuer = issuer
+}
+
+// SetCacheSize bounds the cache at size responses, dropping arbitrary ones over it
+func (r *Resolver) SetCacheSize(size int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.opts.CacheSize = size
+	for key := range r.cache {
+		if len(r.cache) <= size {
+			break
+		}
+		delete(r.cache, key)
+	}
+}
+
+// CacheSize returns the bound on cached responses
+func (r *Resolver) CacheSize() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.opts.CacheSize
+}
+
+func (r *Resolver) currentIssuer() *x509.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.issuer
+}
+
+// Resolve returns the upstream status for a serial
+func (r *Resolver) Resolve(ctx context.Context, serial string) (*storage.Record, error) {
+	now := time.Now()
+	if rec, ok := r.cached(serial, now); ok {
+		lookups.WithLabelValues("cache_hit").Inc()
+		return rec, nil
+	}
+
+	n, ok := new(big.Int).SetString(serial, 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid serial %q", serial)
+	}
+
+	resp, err := r.query(ctx, n)
+	if err != nil {
+		lookups.WithLabelValues("error").Inc()
+		return nil, err
+	}
+	lookups.WithLabelValues("fetched").Inc()
+
+	rec := toRecord(serial, resp)
+	expires := now.Add(r.opts.MaxCacheTTL)
+	if !resp.NextUpdate.IsZero() && resp.NextUpdate.Before(expires) {
+		expires = resp.NextUpdate
+	}
+	r.store(serial, cached{record: *rec, expires: expires})
+	return rec, nil
+}
+
+func (r *Resolver) query(ctx context.Context, serial *big.Int) (*ocsp.Response, error) {
+	issuer := r.currentIssuer()
+	body, err := ocsp.CreateRequest(&x509.Certificate{SerialNumber: serial}, issuer, &ocsp.RequestOptions{Hash: crypto.SHA1})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build OCSP request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+
+	httpResp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("upstream OCSP request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream OCSP responder returned status %d", httpResp.StatusCode)
+	}
+
+	der, err := io.ReadAll(io.LimitReader(httpResp.Body, maxResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(der) > maxResponseSize {
+		return nil, fmt.Errorf("upstream OCSP response exceeds %d bytes", maxResponseSize)
+	}
+
+	// Verifies the signature against the issuer, or a delegated responder certificate it issued
+	resp, err := ocsp.ParseResponseForCert(der, &x509.Certificate{SerialNumber: serial}, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream OCSP response: %w", err)
+	}
+	if !resp.NextUpdate.IsZero() && time.Now().After(resp.NextUpdate) {
+		return nil, errors.New("upstream OCSP response is stale")
+	}
+	return resp, nil
+}
+
+func toRecord(serial string, resp *ocsp.Response) *storage.Record {
+	rec := &storage.Record{
+		Serial:     serial,
+		ThisUpdate: resp.ThisUpdate,
+		NextUpdate: resp.NextUpdate,
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		rec.Status = storage.StatusGood
+	case ocsp.Revoked:
+		rec.Status = storage.StatusRevoked
+		revokedAt := resp.RevokedAt
+		rec.RevokedAt = &revokedAt
+		rec.RevocationReason = revocation.ReasonName(resp.RevocationReason)
+	default:
+		rec.Status = storage.StatusUnknown
+	}
+	return rec
+}
+
+func (r *Resolver) cached(serial string, now time.Time) (*storage.Record, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.cache[serial]
+	if !ok || now.After(entry.expires) {
+		return nil, false
+	}
+	rec := entry.record
+	return &rec, true
+}
+
+func (r *Resolver) store(serial string, entry cached) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.cache) >= r.opts.CacheSize {
+		now := time.Now()
+		for key, existing := range r.cache {
+			if now.After(existing.expires) {
+				delete(r.cache, key)
+			}
+		}
+		// Still full of live entries: drop arbitrary ones rather than grow without bound
+		for key := range r.cache {
+			if len(r.cache) < r.opts.CacheSize {
+				break
+			}
+			delete(r.cache, key)
+		}
+	}
+	r.cache[serial] = entry
+}
+
+// Store answers Get from the upstream responder when the wrapped store has no status
+type Store struct {
+	storage.Store
+	resolver *Resolver
+	logger   *logger.Logger
+}
+
+// NewStore wraps store with an upstream fallback for unknown serials
+func NewStore(store storage.Store, resolver *Resolver, logger *logger.Logger) *Store {
+	return &Store{Store: store, resolver: resolver, logger: logger}
+}
+
+// Get returns the local status, falling back to the upstream responder; upstream failures
+// surface as ErrNotFound so callers keep answering unknown
+func (s *Store) Get(ctx context.Context, serial string) (*storage.Record, error) {
+	rec, err := s.Store.Get(ctx, serial)
+	if !errors.Is(err, storage.ErrNotFound) {
+		return rec, err
+	}
+
+	rec, err = s.resolver.Resolve(ctx, serial)
+	if err != nil {
+		s.logger.Warn("Upstream OCSP lookup failed", zap.String("serial", serial), zap.Error(err))
+		return nil, storage.ErrNotFound
+	}
+	return rec, nil
+}
@@ -0,0 +1,86 @@
+// Package watch notifies long-polling clients when a serial's status changes
+package watch
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gigvault/ocsp/internal/events"
+)
+
+// Hub tracks waiters per serial. It implements events.Publisher so it sees every mutation
+// committed through this instance. Changes written by other replicas are only observed when
+// a waiter times out and re-reads the store, unless the hub also subscribes to a
+// changefeed.Feed
+type Hub struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+// NewHub creates an empty hub
+func NewHub() *Hub {
+	return &Hub{waiters: make(map[string]map[chan struct{}]struct{})}
+}
+
+// Wait returns a channel closed on the next change to serial, and a function releasing it
+func (h *Hub) Wait(serial string) (<-chan struct{}, func()) {
+	ch := make(chan struct{})
+
+	h.mu.Lock()
+	if h.waiters[serial] == nil {
+		h.waiters[serial] = make(map[chan struct{}]struct{})
+	}
+	h.waiters[serial][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if set, ok := h.waiters[serial]; ok {
+			delete(set, ch)
+			if len(set) == 0 {
+				delete(h.waiters, serial)
+			}
+		}
+	}
+}
+
+// Publish wakes the waiters of every serial in events
+func (h *Hub) Publish(ctx context.Context, events []events.Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, event := range events {
+		h.wake(event.Serial)
+	}
+	return nil
+}
+
+// Changed wakes the waiters of serial, whichever replica changed it
+func (h *Hub) Changed(serial string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.wake(serial)
+}
+
+// Missed wakes every waiter, so each re-reads its status after changes may have been missed
+func (h *Hub) Missed() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for serial := range h.waiters {
+		h.wake(serial)
+	}
+}
+
+// wake closes the waiters of serial; h.mu must be held
+func (h *Hub) wake(serial string) {
+	for ch := range h.waiters[serial] {
+		close(ch)
+	}
+	delete(h.waiters, serial)
+}
+
+// Close is a no-op
+func (h *Hub) Close() error {
+	return nil
+}
@@ -0,0 +1,239 @@
+// Package watchdog queries the public OCSP endpoint for sample serials and alerts when the answers
+// fail to verify or disagree with the stored statuses
+package watchdog
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/anomaly"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/pkg/ocspclient"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+)
+
+// maxReported bounds the failures spelled out in an alert message
+const maxReported = 5
+
+var (
+	checks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "watchdog_checks_total",
+		Help:      "Serials looked up at the public endpoint by the watchdog, by result: ok, lagging behind a recent change, or failed.",
+	}, []string{"result"})
+
+	lastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "watchdog_last_success_timestamp_seconds",
+		Help:      "Unix time of the last watchdog run in which every lookup passed.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(checks, lastSuccess)
+}
+
+// Source holds the stored statuses served responses are compared with
+type Source interface {
+	Get(ctx context.Context, serial string) (*storage.Record, error)
+	ListRevokedSince(ctx context.Context, since time.Time) ([]storage.Record, error)
+}
+
+// Options configures the watchdog
+type Options struct {
+	// URL is the public RFC 6960 endpoint, as relying parties reach it
+	URL    string
+	Issuer *x509.Certificate
+	// Serials are looked up on every run, such as canary certificates of each status
+	Serials []string
+	// SampleSize serials revoked within Lookback are picked at random on every run
+	SampleSize int
+	Lookback   time.Duration
+	Interval   time.Duration
+	// Timeout bounds each lookup
+	Timeout time.Duration
+	// MaxAge and ClockSkew bound the freshness of responses, as in ocspclient.VerifyOptions
+	MaxAge    time.Duration
+	ClockSkew time.Duration
+	// PropagationDelay is how long a stored change may take to be served before a differing
+	// response is a failure
+	PropagationDelay time.Duration
+	// UseGET sends lookups as GET, through any cache in front of the responder
+	UseGET bool
+}
+
+// Watchdog periodically looks serials up at the public endpoint and alerts on failures
+type Watchdog struct {
+	source Source
+	opts   Options
+	client *ocspclient.Client
+	hooks  []anomaly.Hook
+	logger *logger.Logger
+	random *rand.Rand
+}
+
+// New creates a watchdog that reports failed runs to hooks
+func New(source Source, opts Options, logger *logger.Logger, hooks ...anomaly.Hook) *Watchdog {
+	return &Watchdog{
+		source: source,
+		opts:   opts,
+		client: ocspclient.NewClient(nil, opts.UseGET),
+		hooks:  hooks,
+		logger: logger,
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Run checks immediately and then on every interval until the context is cancelled
+func (w *Watchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.Check(ctx); err != nil {
+			w.logger.Error("Watchdog check failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check looks up the configured serials and a sample of recent revocations, and fires one
+// alert listing the lookups that failed. It returns an error when no serial could be checked
+func (w *Watchdog) Check(ctx context.Context) error {
+	serials, sampleErr := w.serials(ctx)
+	if sampleErr != nil {
+		w.logger.Warn("Watchdog failed to sample revoked serials", zap.Error(sampleErr))
+	}
+	if len(serials) == 0 {
+		if sampleErr != nil {
+			return sampleErr
+		}
+		return nil
+	}
+
+	var failures []string
+	for _, serial := range serials {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		lagging, err := w.lookup(ctx, serial)
+		switch {
+		case err != nil:
+			checks.WithLabelValues("failed").Inc()
+			failures = append(failures, fmt.Sprintf("%s: %v", serial, err))
+			w.logger.Warn("Watchdog lookup failed", zap.String("serial", serial), zap.Error(err))
+		case lagging:
+			checks.WithLabelValues("lagging").Inc()
+		default:
+			checks.WithLabelValues("ok").Inc()
+		}
+	}
+	if len(failures) == 0 {
+		lastSuccess.SetToCurrentTime()
+		return nil
+	}
+	w.fire(ctx, failures, len(serials))
+	return nil
+}
+
+// serials returns the configured serials followed by a random sample of recent revocations
+func (w *Watchdog) serials(ctx context.Context) ([]string, error) {
+	serials := append([]string(nil), w.opts.Serials...)
+	if w.opts.SampleSize <= 0 {
+		return serials, nil
+	}
+	revoked, err := w.source.ListRevokedSince(ctx, time.Now().Add(-w.opts.Lookback))
+	if err != nil {
+		return serials, err
+	}
+	w.random.Shuffle(len(revoked), func(i, j int) { revoked[i], revoked[j] = revoked[j], revoked[i] })
+	for _, rec := range revoked[:min(len(revoked), w.opts.SampleSize)] {
+		serials = append(serials, rec.Serial)
+	}
+	return serials, nil
+}
+
+// lookup queries and verifies the response for serial and compares its status with the stored
+// one. It reports lagging when they differ only because the change is newer than the
+// propagation delay
+func (w *Watchdog) lookup(ctx context.Context, serial string) (bool, error) {
+	n, ok := new(big.Int).SetString(serial, 16)
+	if !ok {
+		return false, fmt.Errorf("invalid serial %q", serial)
+	}
+	req, err := ocspclient.NewRequest(n, w.opts.Issuer, ocspclient.RequestOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.opts.Timeout)
+	defer cancel()
+	resp, err := w.client.Check(ctx, w.opts.URL, req, ocspclient.VerifyOptions{
+		MaxAge:    w.opts.MaxAge,
+		ClockSkew: w.opts.ClockSkew,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	rec, err := w.source.Get(ctx, n.Text(16))
+	if errors.Is(err, storage.ErrNotFound) {
+		// What unknown serials get depends on the non-issued policy; the response verified
+		return false, nil
+	}
+	if err != nil {
+		w.logger.Warn("Watchdog could not read the stored status", zap.String("serial", serial), zap.Error(err))
+		return false, nil
+	}
+	if served, stored := statusName(resp.Status), rec.Status; served != stored {
+		if time.Since(rec.ThisUpdate) < w.opts.PropagationDelay {
+			return true, nil
+		}
+		return false, fmt.Errorf("served %s, stored %s since %s", served, stored, rec.ThisUpdate.UTC().Format(time.RFC3339))
+	}
+	return false, nil
+}
+
+func statusName(status int) string {
+	switch status {
+	case ocsp.Good:
+		return storage.StatusGood
+	case ocsp.Revoked:
+		return storage.StatusRevoked
+	default:
+		return storage.StatusUnknown
+	}
+}
+
+func (w *Watchdog) fire(ctx context.Context, failures []string, checked int) {
+	reported := failures[:min(len(failures), maxReported)]
+	message := fmt.Sprintf("%d of %d lookups at %s failed: %s", len(failures), checked, w.opts.URL, strings.Join(reported, "; "))
+	if len(failures) > len(reported) {
+		message += fmt.Sprintf("; and %d more", len(failures)-len(reported))
+	}
+	alert := anomaly.Alert{
+		Kind:    anomaly.KindWatchdogFailure,
+		Message: message,
+		Source:  w.opts.URL,
+		Count:   int64(len(failures)),
+		FiredAt: time.Now(),
+	}
+	for _, hook := range w.hooks {
+		hook.Fire(ctx, alert)
+	}
+}
@@ -0,0 +1,76 @@
+package writebehind
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+// Barrier sits under every writer in the store chain and flushes the queued writes for a
+// serial before any other write to it, so a queued write can never land on top of a later
+// one made through the HTTP API, imports, CRL sync, holds or replication
+type Barrier struct {
+	storage.Store
+	queue atomic.Pointer[Store]
+}
+
+// NewBarrier wraps store; writes pass straight through until Attach is called
+func NewBarrier(store storage.Store) *Barrier {
+	return &Barrier{Store: store}
+}
+
+// Attach orders writes through the barrier after the writes queued in s
+func (b *Barrier) Attach(s *Store) {
+	b.queue.Store(s)
+}
+
+func (b *Barrier) await(ctx context.Context, updates ...storage.Update) error {
+	s := b.queue.Load()
+	if s == nil || isFlush(ctx) {
+		return nil
+	}
+	return s.flushSerials(ctx, updates...)
+}
+
+// Upsert flushes queued writes for the serial, then applies update
+func (b *Barrier) Upsert(ctx context.Context, update storage.Update) error {
+	if err := b.await(ctx, update); err != nil {
+		return err
+	}
+	return b.Store.Upsert(ctx, update)
+}
+
+// ApplyBatch flushes queued writes for the serials, then applies updates
+func (b *Barrier) ApplyBatch(ctx context.Context, updates []storage.Update) error {
+	if err := b.await(ctx, updates...); err != nil {
+		return err
+	}
+	return b.Store.ApplyBatch(ctx, updates)
+}
+
+// InsertMissing seeds statuses through the wrapped store, which must be a storage.Seeder
+func (b *Barrier) InsertMissing(ctx context.Context, updates []storage.Update) (int, error) {
+	if err := b.await(ctx, updates...); err != nil {
+		return 0, err
+	}
+	seeder, ok := b.Store.(storage.Seeder)
+	if !ok {
+		return 0, fmt.Errorf("store does not support seeding")
+	}
+	return seeder.InsertMissing(ctx, updates)
+}
+
+// ApplyIfNewer applies a replicated status through the wrapped store, which must be a
+// storage.Replica
+func (b *Barrier) ApplyIfNewer(ctx context.Context, rec storage.Record) (bool, error) {
+	if err := b.await(ctx, storage.Update{Serial: rec.Serial}); err != nil {
+		return false, err
+	}
+	replica, ok := b.Store.(storage.Replica)
+	if !ok {
+		return false, fmt.Errorf("store does not support replication")
+	}
+	return replica.ApplyIfNewer(ctx, rec)
+}
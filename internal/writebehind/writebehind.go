@@ -0,0 +1,383 @@
+// Package writebehind acknowledges status writes before they reach the database: they are
+// queued in process and flushed in batches, trading durability for write latency. It is
+// meant for issuance-time good statuses, which a lost write only delays
+package writebehind
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/metrics"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/shared/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// ErrFull is returned when the queue stayed full for the whole enqueue timeout
+var ErrFull = errors.New("write-behind queue is full")
+
+// Write results, used in metric labels
+const (
+	resultQueued       = "queued"
+	resultRejected     = "rejected"
+	resultFlushed      = "flushed"
+	resultDeadLettered = "dead_lettered"
+	resultDropped      = "dropped"
+)
+
+var (
+	writes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "write_behind_writes_total",
+		Help:      "Status writes through the write-behind queue, by result.",
+	}, []string{"result"})
+	depth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "write_behind_queue_depth",
+		Help:      "Status writes acknowledged but not yet flushed to the database.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(writes, depth)
+}
+
+type syncKey struct{}
+
+// WithSync returns a context whose writes are applied before they are acknowledged
+func WithSync(ctx context.Context) context.Context {
+	return context.WithValue(ctx, syncKey{}, true)
+}
+
+func isSync(ctx context.Context) bool {
+	sync, _ := ctx.Value(syncKey{}).(bool)
+	return sync
+}
+
+type flushKey struct{}
+
+// isFlush reports whether ctx belongs to a flush of queued writes, which a Barrier lets through
+func isFlush(ctx context.Context) bool {
+	flush, _ := ctx.Value(flushKey{}).(bool)
+	return flush
+}
+
+// Options configures a write-behind store
+type Options struct {
+	// Statuses are the statuses whose writes are queued; writes of other statuses are applied
+	// synchronously
+	Statuses       []string
+	QueueSize      int
+	BatchSize      int
+	FlushInterval  time.Duration
+	EnqueueTimeout time.Duration
+	// MaxAttempts bounds how often a failing batch is retried before its writes are applied
+	// one by one, and those that still fail are dead-lettered or dropped
+	MaxAttempts int
+}
+
+// item is a queued write and the principal that made it, so audit records stay attributed
+type item struct {
+	update    storage.Update
+	principal string
+}
+
+// Store queues Upsert calls for the configured statuses and flushes them to the wrapped store
+// in batches; Run must be running. A synchronous write to a serial with queued writes flushes
+// the queue first, so writes through the store are applied in order
+type Store struct {
+	storage.Store
+	opts  Options
+	async map[string]bool
+	// checkWrite refuses writes up front that the wrapped store would refuse at flush time
+	checkWrite func() error
+	// deadLetters keeps the writes the wrapped store refuses at flush time, if set
+	deadLetters bulk.DeadLetters
+	logger      *logger.Logger
+
+	queue   chan item
+	flushes chan chan struct{}
+	done    chan struct{}
+
+	// closing is held for reading while enqueueing, so shutdown never misses an item
+	closing sync.RWMutex
+	closed  bool
+
+	pendingMu sync.Mutex
+	pending   map[string]int // serial -> queued writes
+}
+
+// NewStore wraps store. checkWrite, if set, is called before a write is queued, so writes the
+// operating mode forbids are refused to the caller rather than dropped later
+func NewStore(store storage.Store, opts Options, checkWrite func() error, logger *logger.Logger) *Store {
+	async := make(map[string]bool, len(opts.Statuses))
+	for _, status := range opts.Statuses {
+		async[status] = true
+	}
+	return &Store{
+		Store:      store,
+		opts:       opts,
+		async:      async,
+		checkWrite: checkWrite,
+		logger:     logger,
+		queue:      make(chan item, opts.QueueSize),
+		flushes:    make(chan chan struct{}),
+		done:       make(chan struct{}),
+		pending:    make(map[string]int),
+	}
+}
+
+// SetDeadLetters makes a flush keep the queued writes the wrapped store refuses, such as writes
+// staged for approval or stopped by guardrails, in deadLetters instead of dropping them
+func (s *Store) SetDeadLetters(deadLetters bulk.DeadLetters) {
+	s.deadLetters = deadLetters
+}
+
+// Upsert queues the write when its status is queued and ctx does not ask for a synchronous
+// write. When the queue stays full for the enqueue timeout, ErrFull is returned, or ctx's error
+// when it ends first
+func (s *Store) Upsert(ctx context.Context, update storage.Update) error {
+	if !s.async[update.Status] || isSync(ctx) {
+		if err := s.flushSerials(ctx, update); err != nil {
+			return err
+		}
+		return s.Store.Upsert(ctx, update)
+	}
+	if s.checkWrite != nil {
+		if err := s.checkWrite(); err != nil {
+			return err
+		}
+	}
+
+	s.closing.RLock()
+	defer s.closing.RUnlock()
+	if s.closed {
+		return s.Store.Upsert(ctx, update)
+	}
+
+	s.track(update.Serial, 1)
+	timer := time.NewTimer(s.opts.EnqueueTimeout)
+	defer timer.Stop()
+	select {
+	case s.queue <- item{update: update, principal: approval.PrincipalFrom(ctx)}:
+		writes.WithLabelValues(resultQueued).Inc()
+		depth.Inc()
+		return nil
+	case <-timer.C:
+		s.track(update.Serial, -1)
+		writes.WithLabelValues(resultRejected).Inc()
+		return ErrFull
+	case <-ctx.Done():
+		s.track(update.Serial, -1)
+		writes.WithLabelValues(resultRejected).Inc()
+		return ctx.Err()
+	}
+}
+
+// ApplyBatch flushes the queue if it holds writes for any of the serials, then applies the
+// updates synchronously
+func (s *Store) ApplyBatch(ctx context.Context, updates []storage.Update) error {
+	if err := s.flushSerials(ctx, updates...); err != nil {
+		return err
+	}
+	return s.Store.ApplyBatch(ctx, updates)
+}
+
+// flushSerials flushes the queue until it holds no writes for any of the updates' serials,
+// including writes still being enqueued when it is called
+func (s *Store) flushSerials(ctx context.Context, updates ...storage.Update) error {
+	for s.queued(updates) {
+		select {
+		case <-s.done:
+			return nil
+		default:
+		}
+		if err := s.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) queued(updates []storage.Update) bool {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	for _, update := range updates {
+		if s.pending[update.Serial] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) track(serial string, delta int) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if s.pending[serial] += delta; s.pending[serial] <= 0 {
+		delete(s.pending, serial)
+	}
+}
+
+// Flush returns once every write queued before the call has been flushed
+func (s *Store) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	select {
+	case s.flushes <- flushed:
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed once Run has flushed the queue after its context was cancelled
+func (s *Store) Done() <-chan struct{} {
+	return s.done
+}
+
+// Run flushes the queue whenever a batch fills, every flush interval and on request. When ctx
+// is cancelled, later writes are applied synchronously and the queue is flushed one last time
+func (s *Store) Run(ctx context.Context) {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+
+	// Flushes outlive ctx, so cancellation does not discard acknowledged writes
+	writeCtx := context.WithValue(context.WithoutCancel(ctx), flushKey{}, true)
+	batch := make([]item, 0, s.opts.BatchSize)
+	flush := func() {
+		s.write(writeCtx, batch)
+		batch = batch[:0]
+	}
+	drain := func() {
+		for {
+			select {
+			case it := <-s.queue:
+				if batch = append(batch, it); len(batch) == s.opts.BatchSize {
+					flush()
+				}
+			default:
+				flush()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case it := <-s.queue:
+			if batch = append(batch, it); len(batch) == s.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case flushed := <-s.flushes:
+			drain()
+			close(flushed)
+		case <-ctx.Done():
+			s.closing.Lock()
+			s.closed = true
+			s.closing.Unlock()
+			drain()
+			return
+		}
+	}
+}
+
+// write applies a batch, each run of writes by the same principal in one transaction
+func (s *Store) write(ctx context.Context, batch []item) {
+	for start := 0; start < len(batch); {
+		end := start + 1
+		for end < len(batch) && batch[end].principal == batch[start].principal {
+			end++
+		}
+		s.apply(approval.WithPrincipal(ctx, batch[start].principal), batch[start:end])
+		start = end
+	}
+}
+
+// apply retries a run of writes with backoff, then falls back to applying them one by one so
+// a single bad write cannot hold back the rest
+func (s *Store) apply(ctx context.Context, run []item) {
+	updates := make([]storage.Update, len(run))
+	for i, it := range run {
+		updates[i] = it.update
+	}
+	defer func() {
+		for _, update := range updates {
+			s.track(update.Serial, -1)
+		}
+		depth.Sub(float64(len(updates)))
+	}()
+
+	backoff := 100 * time.Millisecond
+	var err error
+	for attempt := 1; attempt <= s.opts.MaxAttempts; attempt++ {
+		if err = s.Store.ApplyBatch(ctx, updates); err == nil {
+			writes.WithLabelValues(resultFlushed).Add(float64(len(updates)))
+			return
+		}
+		if attempt == s.opts.MaxAttempts {
+			break
+		}
+		s.logger.Warn("Failed to flush queued status writes, retrying", zap.Int("writes", len(updates)), zap.Int("attempt", attempt), zap.Error(err))
+		time.Sleep(time.Duration(rand.Int63n(int64(backoff) + 1)))
+		backoff *= 2
+	}
+
+	s.logger.Error("Failed to flush queued status writes, applying them one by one", zap.Int("writes", len(updates)), zap.Error(err))
+	var failures []bulk.Failure
+	for _, update := range updates {
+		if err := s.Store.Upsert(ctx, update); err != nil {
+			failures = append(failures, failure(update, err))
+			continue
+		}
+		writes.WithLabelValues(resultFlushed).Inc()
+	}
+	s.deadLetter(ctx, failures)
+}
+
+// deadLetter keeps writes that could not be flushed as dead letters, and logs and drops them
+// when there are none or they could not be kept
+func (s *Store) deadLetter(ctx context.Context, failures []bulk.Failure) {
+	if len(failures) == 0 {
+		return
+	}
+	if s.deadLetters != nil {
+		err := s.deadLetters.Add(ctx, bulk.SourceWriteBehind, failures)
+		if err == nil {
+			writes.WithLabelValues(resultDeadLettered).Add(float64(len(failures)))
+			return
+		}
+		s.logger.Error("Failed to dead-letter queued status writes", zap.Int("writes", len(failures)), zap.Error(err))
+	}
+	for _, f := range failures {
+		writes.WithLabelValues(resultDropped).Inc()
+		s.logger.Error("Dropped queued status write",
+			zap.String("serial", f.Row.Serial),
+			zap.String("status", f.Row.Status),
+			zap.String("error", f.Error),
+		)
+	}
+}
+
+// failure describes a queued write as a row, the way a caller would have submitted it
+func failure(update storage.Update, err error) bulk.Failure {
+	row := bulk.Row{Serial: update.Serial, Status: update.Status, Reason: update.RevocationReason}
+	if update.RevokedAt != nil {
+		row.Date = update.RevokedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return bulk.Failure{Row: row, Error: err.Error()}
+}
@@ -0,0 +1,95 @@
+package writebehind
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/approval"
+	"github.com/gigvault/ocsp/internal/bulk"
+	"github.com/gigvault/ocsp/internal/storage"
+	"github.com/gigvault/ocsp/pkg/testsupport"
+	"github.com/gigvault/shared/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// refusing refuses writes to one serial, the way approvals and guardrails refuse them
+type refusing struct {
+	*testsupport.Store
+	serial string
+}
+
+func (r refusing) Upsert(ctx context.Context, update storage.Update) error {
+	if update.Serial == r.serial {
+		return errors.New("refused")
+	}
+	return r.Store.Upsert(ctx, update)
+}
+
+func (r refusing) ApplyBatch(ctx context.Context, updates []storage.Update) error {
+	for _, update := range updates {
+		if update.Serial == r.serial {
+			return errors.New("refused")
+		}
+	}
+	return r.Store.ApplyBatch(ctx, updates)
+}
+
+type deadLetters struct {
+	source    string
+	principal string
+	failures  []bulk.Failure
+}
+
+func (d *deadLetters) Add(ctx context.Context, source string, failures []bulk.Failure) error {
+	d.source, d.principal = source, approval.PrincipalFrom(ctx)
+	d.failures = append(d.failures, failures...)
+	return nil
+}
+
+func TestRefusedWritesAreDeadLettered(t *testing.T) {
+	clock := testsupport.NewClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	inner := testsupport.NewStore(clock.Now)
+	store := NewStore(refusing{Store: inner, serial: "0b"}, Options{
+		Statuses:       []string{storage.StatusGood, storage.StatusRevoked},
+		QueueSize:      10,
+		BatchSize:      10,
+		FlushInterval:  time.Hour,
+		EnqueueTimeout: time.Second,
+		MaxAttempts:    1,
+	}, nil, &logger.Logger{Logger: zap.NewNop()})
+	dead := &deadLetters{}
+	store.SetDeadLetters(dead)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		<-store.Done()
+	}()
+	go store.Run(ctx)
+
+	revokedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	writeCtx := approval.WithPrincipal(context.Background(), "issuer-ca")
+	for _, update := range []storage.Update{
+		{Serial: "0a", Status: storage.StatusGood},
+		{Serial: "0b", Status: storage.StatusRevoked, RevokedAt: &revokedAt, RevocationReason: "keyCompromise"},
+	} {
+		if err := store.Upsert(writeCtx, update); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec, err := inner.Get(context.Background(), "0a"); err != nil || !rec.ThisUpdate.Equal(clock.Now()) {
+		t.Errorf("accepted write was not flushed: %+v, %v", rec, err)
+	}
+	if dead.source != bulk.SourceWriteBehind || dead.principal != "issuer-ca" || len(dead.failures) != 1 {
+		t.Fatalf("dead letters = %+v", dead)
+	}
+	want := bulk.Row{Serial: "0b", Status: storage.StatusRevoked, Reason: "keyCompromise", Date: "2026-01-02T03:04:05Z"}
+	if f := dead.failures[0]; f.Row != want || f.Error != "refused" {
+		t.Errorf("failure = %+v, want row %+v", f, want)
+	}
+}
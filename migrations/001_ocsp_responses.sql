@@ -0,0 +1,17 @@
+-- Migration: Create ocsp_responses table
+-- This table stores the current status of every certificate known to the responder
+
+CREATE TABLE IF NOT EXISTS ocsp_responses (
+    serial VARCHAR(64) PRIMARY KEY,        -- Lowercase hex serial number
+    status VARCHAR(16) NOT NULL,           -- 'good', 'revoked' or 'unknown'
+    this_update TIMESTAMP NOT NULL DEFAULT NOW(),
+    next_update TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    revocation_reason VARCHAR(64) NOT NULL DEFAULT '',
+
+    CONSTRAINT ocsp_responses_status CHECK (status IN ('good', 'revoked', 'unknown'))
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_ocsp_responses_status ON ocsp_responses(status);
+CREATE INDEX IF NOT EXISTS idx_ocsp_responses_next_update ON ocsp_responses(next_update);
@@ -0,0 +1,10 @@
+-- Migration: Create crl_numbers table
+-- CRL numbers must increase monotonically per issuer (RFC 5280 5.2.3), across restarts and replicas
+
+CREATE TABLE IF NOT EXISTS crl_numbers (
+    issuer VARCHAR(64) PRIMARY KEY,        -- Hex SHA-256 of the issuer's subject and public key
+    number BIGINT NOT NULL,                -- Last CRL number issued
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+COMMENT ON TABLE crl_numbers IS 'Last CRL number issued per issuer, shared by full and delta CRLs.';
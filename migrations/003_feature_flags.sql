@@ -0,0 +1,14 @@
+-- Migration: Create feature_flags table
+-- Rules stored here override the feature_flags section of every replica's configuration
+
+CREATE TABLE IF NOT EXISTS feature_flags (
+    name VARCHAR(64) PRIMARY KEY,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    percent INTEGER NOT NULL DEFAULT 0,     -- Share of serials the flag is on for, by hash
+    serials TEXT[] NOT NULL DEFAULT '{}',   -- Serials the flag is always on for
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    CONSTRAINT feature_flags_percent CHECK (percent BETWEEN 0 AND 100)
+);
+
+COMMENT ON TABLE feature_flags IS 'Feature flag rollouts shared by all replicas, polled by feature_flags.database.';
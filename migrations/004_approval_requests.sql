@@ -0,0 +1,19 @@
+-- Migration: Create approval_requests table
+-- High-impact revocations wait here until a second principal approves them
+
+CREATE TABLE IF NOT EXISTS approval_requests (
+    id BIGSERIAL PRIMARY KEY,
+    state VARCHAR(16) NOT NULL DEFAULT 'pending',  -- pending, applying, approved, rejected
+    reason TEXT NOT NULL,                          -- Policy rule that matched
+    updates JSONB NOT NULL,                        -- Staged status updates
+    requested_by VARCHAR(128) NOT NULL,
+    requested_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    decided_by VARCHAR(128),
+    decided_at TIMESTAMP,
+
+    CONSTRAINT approval_requests_state CHECK (state IN ('pending', 'applying', 'approved', 'rejected'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_approval_requests_state ON approval_requests(state, id DESC);
+
+COMMENT ON TABLE approval_requests IS 'Revocations staged for two-person approval by the approvals policy.';
@@ -0,0 +1,6 @@
+-- Migration: Index recent revocations
+-- The mass-revocation guardrails count revocations written within a time window on every write
+
+CREATE INDEX IF NOT EXISTS idx_ocsp_responses_revoked_this_update
+    ON ocsp_responses(this_update)
+    WHERE status = 'revoked';
@@ -0,0 +1,16 @@
+-- Migration: Create audit_log table
+-- Append-only record of every committed status change and who made it
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    recorded_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    serial VARCHAR(64) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    revoked_at TIMESTAMP,
+    revocation_reason VARCHAR(64) NOT NULL DEFAULT '',
+    actor VARCHAR(128) NOT NULL                    -- Authenticated principal, or 'anonymous'
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_recorded_at ON audit_log(recorded_at);
+
+COMMENT ON TABLE audit_log IS 'Status change audit trail, exported as signed bundles by GET /api/v1/audit/export.';
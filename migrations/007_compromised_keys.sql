@@ -0,0 +1,12 @@
+-- Migration: Create compromised_keys table
+-- Signing keys taken out of service by the compromise response; replicas refuse to sign with them
+
+CREATE TABLE IF NOT EXISTS compromised_keys (
+    key_hash VARCHAR(64) PRIMARY KEY,              -- Hex SHA-256 of the certificate's subject public key info
+    certificate_serial VARCHAR(64) NOT NULL,
+    subject TEXT NOT NULL,
+    actor VARCHAR(128) NOT NULL,                   -- Authenticated principal, or 'anonymous'
+    compromised_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+COMMENT ON TABLE compromised_keys IS 'Keys recorded by POST /api/v1/compromise; never deleted.';
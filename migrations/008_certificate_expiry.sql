@@ -0,0 +1,8 @@
+-- Migration: Record certificate expiry
+-- Filled in by CA sync so the retention policy can purge statuses of long-expired certificates
+
+ALTER TABLE ocsp_responses ADD COLUMN IF NOT EXISTS not_after TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_ocsp_responses_not_after
+    ON ocsp_responses(not_after)
+    WHERE not_after IS NOT NULL;
@@ -0,0 +1,21 @@
+-- Migration: Create signed_responses table
+-- Holds the latest signed DER response per CertID, kept current by the precomputed responder's
+-- refresh job so serving a request is a single primary key read
+
+CREATE TABLE IF NOT EXISTS signed_responses (
+    issuer_key_hash BYTEA NOT NULL,                 -- SHA-1 of the issuer's public key, as in CertIDs
+    serial VARCHAR(64) NOT NULL REFERENCES ocsp_responses(serial) ON DELETE CASCADE,
+    der BYTEA NOT NULL,                             -- Signed OCSPResponse
+    status_this_update TIMESTAMP NOT NULL,          -- this_update of the status that was signed
+    next_update TIMESTAMP NOT NULL,                 -- nextUpdate of the signed response
+    signed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (issuer_key_hash, serial)
+);
+
+CREATE INDEX IF NOT EXISTS idx_signed_responses_next_update ON signed_responses(issuer_key_hash, next_update);
+
+-- The refresh job walks statuses changed since its last pass in this order
+CREATE INDEX IF NOT EXISTS idx_ocsp_responses_this_update_serial ON ocsp_responses(this_update, serial);
+
+COMMENT ON TABLE signed_responses IS 'Latest signed OCSP response per CertID, kept current by precomputed.interval.';
@@ -0,0 +1,13 @@
+-- Migration: Create serial_aliases table
+-- Serials that share another serial's status, such as CT precertificates issued with a serial
+-- other than the final certificate's
+
+CREATE TABLE IF NOT EXISTS serial_aliases (
+    alias VARCHAR(64) PRIMARY KEY,                  -- Serial queried, e.g. the precertificate's
+    serial VARCHAR(64) NOT NULL,                    -- Serial whose status it shares
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_serial_aliases_serial ON serial_aliases(serial);
+
+COMMENT ON TABLE serial_aliases IS 'Serial aliases managed through /api/v1/aliases; resolved one level deep.';
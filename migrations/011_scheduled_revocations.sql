@@ -0,0 +1,22 @@
+-- Migration: Create scheduled_revocations table
+-- Revocations submitted ahead of time, applied by the scheduler once effective_at passes
+
+CREATE TABLE IF NOT EXISTS scheduled_revocations (
+    id BIGSERIAL PRIMARY KEY,
+    state VARCHAR(16) NOT NULL DEFAULT 'pending',  -- pending, applying, applied, cancelled, failed
+    serial VARCHAR(64) NOT NULL,
+    revocation_reason VARCHAR(32) NOT NULL,
+    effective_at TIMESTAMP NOT NULL,               -- Becomes the revocation time when applied
+    requested_by VARCHAR(128) NOT NULL DEFAULT '',
+    requested_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    decided_by VARCHAR(128),                       -- Who cancelled it
+    decided_at TIMESTAMP,                          -- When it was applied, cancelled or failed
+    error TEXT,                                    -- Why it failed
+
+    CONSTRAINT scheduled_revocations_state CHECK (state IN ('pending', 'applying', 'applied', 'cancelled', 'failed'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_scheduled_revocations_due ON scheduled_revocations(effective_at) WHERE state = 'pending';
+CREATE INDEX IF NOT EXISTS idx_scheduled_revocations_state ON scheduled_revocations(state, id DESC);
+
+COMMENT ON TABLE scheduled_revocations IS 'Future-effective revocations managed through /api/v1/revocations/scheduled.';
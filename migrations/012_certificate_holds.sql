@@ -0,0 +1,19 @@
+-- Migration: Create certificate_holds table
+-- Timed certificateHold revocations, released back to good by the leader at release_at unless
+-- extended or made permanent first
+
+CREATE TABLE IF NOT EXISTS certificate_holds (
+    serial VARCHAR(64) PRIMARY KEY,
+    state VARCHAR(16) NOT NULL DEFAULT 'held',     -- held, released, revoked, lapsed
+    release_at TIMESTAMP NOT NULL,
+    held_by VARCHAR(128) NOT NULL DEFAULT '',
+    held_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    decided_by VARCHAR(128),                       -- Who released it early or made it permanent
+    decided_at TIMESTAMP,
+
+    CONSTRAINT certificate_holds_state CHECK (state IN ('held', 'released', 'revoked', 'lapsed'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_certificate_holds_due ON certificate_holds(release_at) WHERE state = 'held';
+
+COMMENT ON TABLE certificate_holds IS 'Timed holds managed through /api/v1/holds; lapsed holds were changed by another write before release.';
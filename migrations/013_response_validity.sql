@@ -0,0 +1,11 @@
+-- Migration: Per-certificate response validity
+-- validity_seconds, set with UpdateStatus, overrides precomputed.validity for one certificate;
+-- refresh_at is when the refresh job re-signs a stored response
+
+ALTER TABLE ocsp_responses ADD COLUMN IF NOT EXISTS validity_seconds INTEGER;
+
+-- Responses signed before this migration have none and are re-signed refresh_before ahead of
+-- their nextUpdate, as before
+ALTER TABLE signed_responses ADD COLUMN IF NOT EXISTS refresh_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_signed_responses_refresh_at ON signed_responses(issuer_key_hash, refresh_at);
@@ -0,0 +1,38 @@
+-- Migration: Create response_log table
+-- Append-only record of every response the responder signs, decoded from the signed bytes, so
+-- what it asserted about a certificate at any time can be shown later. Retention does not
+-- purge it, and a trigger rejects updates and deletes
+
+CREATE TABLE IF NOT EXISTS response_log (
+    id BIGSERIAL PRIMARY KEY,
+    logged_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    source VARCHAR(16) NOT NULL,                    -- 'refresh' or 'on_demand'
+    hash_algorithm VARCHAR(64) NOT NULL,            -- OID of the CertID hash algorithm
+    issuer_name_hash VARCHAR(128) NOT NULL,         -- CertID hashes, in hex
+    issuer_key_hash VARCHAR(128) NOT NULL,
+    serial VARCHAR(64) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    revoked_at TIMESTAMP,
+    revocation_reason VARCHAR(64) NOT NULL DEFAULT '',
+    produced_at TIMESTAMP NOT NULL,
+    this_update TIMESTAMP NOT NULL,
+    next_update TIMESTAMP,
+    signature_sha256 CHAR(64) NOT NULL,             -- SHA-256 of the signature value, in hex
+    key_id CHAR(40) NOT NULL                        -- SHA-1 of the signing public key, in hex
+);
+
+CREATE INDEX IF NOT EXISTS idx_response_log_serial ON response_log(serial, id);
+CREATE INDEX IF NOT EXISTS idx_response_log_logged_at ON response_log(logged_at);
+
+CREATE OR REPLACE FUNCTION response_log_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'response_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS response_log_append_only ON response_log;
+CREATE TRIGGER response_log_append_only
+    BEFORE UPDATE OR DELETE ON response_log
+    FOR EACH ROW EXECUTE FUNCTION response_log_append_only();
+
+COMMENT ON TABLE response_log IS 'Every signed OCSP response, queried by GET /api/v1/response-log.';
@@ -0,0 +1,5 @@
+-- Migration: Index statuses in numeric serial order
+-- Stored serials are lowercase hex without leading zeros, so ordering by length and then
+-- bytewise is numeric order. GET /api/v1/statuses walks serial ranges along this index
+
+CREATE INDEX IF NOT EXISTS idx_ocsp_responses_serial_numeric ON ocsp_responses((LENGTH(serial)), (serial COLLATE "C"));
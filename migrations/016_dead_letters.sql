@@ -0,0 +1,26 @@
+-- Migration: Create dead_letters table
+-- Status rows a bulk import or gRPC batch could not apply, kept as submitted with the error so
+-- they can be corrected and replayed rather than lost
+
+CREATE TABLE IF NOT EXISTS dead_letters (
+    id BIGSERIAL PRIMARY KEY,
+    state VARCHAR(16) NOT NULL DEFAULT 'pending',  -- pending, replayed, discarded
+    source VARCHAR(16) NOT NULL,                   -- 'import' or 'batch'
+    line INTEGER NOT NULL DEFAULT 0,               -- Upload line, or position in the batch
+    serial TEXT NOT NULL DEFAULT '',               -- Row fields as submitted, unvalidated
+    status TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    date TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL,                           -- Why the row last failed
+    attempts INTEGER NOT NULL DEFAULT 1,
+    failed_at TIMESTAMP NOT NULL DEFAULT NOW(),    -- When the row last failed
+    submitted_by VARCHAR(128) NOT NULL DEFAULT '',
+    decided_by VARCHAR(128),                       -- Who replayed or discarded it
+    decided_at TIMESTAMP,
+
+    CONSTRAINT dead_letters_state CHECK (state IN ('pending', 'replayed', 'discarded'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_dead_letters_state ON dead_letters(state, id);
+
+COMMENT ON TABLE dead_letters IS 'Failed import and batch rows managed through /api/v1/dead-letters.';
@@ -0,0 +1,19 @@
+-- Migration: Create event_outbox table
+-- Status change events written in the same transaction as the change itself, so an event is
+-- recorded exactly when its change commits; the relay publishes them and marks them published
+
+CREATE TABLE IF NOT EXISTS event_outbox (
+    id BIGSERIAL PRIMARY KEY,                      -- Publishing order
+    event_id VARCHAR(32) NOT NULL DEFAULT md5(random()::text || clock_timestamp()::text),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),   -- Time of the change, the event time
+    serial VARCHAR(64) NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    revoked_at TIMESTAMP,                          -- Set for revocations only
+    revocation_reason VARCHAR(64) NOT NULL DEFAULT '',
+    published_at TIMESTAMP                         -- NULL until every sink accepted the event
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_outbox_unpublished ON event_outbox(id) WHERE published_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_event_outbox_published ON event_outbox(published_at) WHERE published_at IS NOT NULL;
+
+COMMENT ON TABLE event_outbox IS 'Status change events awaiting or past publication by the event outbox relay.';
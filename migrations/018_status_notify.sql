@@ -0,0 +1,19 @@
+-- Migration: Notify listeners of status changes
+-- Every committed change to ocsp_responses sends its serial on the ocsp_status_changed channel,
+-- so replicas listening with change_notifications.enabled learn of it without polling
+
+CREATE OR REPLACE FUNCTION notify_status_changed() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('ocsp_status_changed', OLD.serial);
+    ELSE
+        PERFORM pg_notify('ocsp_status_changed', NEW.serial);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ocsp_responses_notify ON ocsp_responses;
+CREATE TRIGGER ocsp_responses_notify
+    AFTER INSERT OR UPDATE OR DELETE ON ocsp_responses
+    FOR EACH ROW EXECUTE FUNCTION notify_status_changed();
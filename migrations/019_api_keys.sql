@@ -0,0 +1,26 @@
+-- Migration: Create api_keys table
+-- Managed credentials for the gRPC mutation API, each bound to a tenant or automation identity
+-- with its own rate limit and permitted issuers. Only SHA-256 digests of the secrets are kept
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id VARCHAR(32) PRIMARY KEY,                    -- Public part of the token
+    tenant VARCHAR(128) NOT NULL,
+    name VARCHAR(128) NOT NULL,
+    digest CHAR(64) NOT NULL,                      -- Hex SHA-256 of the current secret
+    previous_digest CHAR(64),                      -- Secret replaced by the last rotation
+    previous_expires_at TIMESTAMP,                 -- Until when the replaced secret still works
+    issuers TEXT[] NOT NULL DEFAULT '{}',          -- Hex SHA-1 issuer key hashes; empty allows any
+    rate_per_second DOUBLE PRECISION NOT NULL DEFAULT 0, -- 0 leaves the key unlimited
+    burst INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    created_by VARCHAR(128) NOT NULL DEFAULT '',
+    rotated_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    revoked_by VARCHAR(128)
+);
+
+-- Names are unique among a tenant's live keys; a revoked key's name may be reused
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_keys_tenant_name ON api_keys(tenant, name) WHERE revoked_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_api_keys_tenant ON api_keys(tenant, id);
+
+COMMENT ON TABLE api_keys IS 'API keys managed through /api/v1/api-keys.';
@@ -0,0 +1,28 @@
+-- Migration: Create runtime_tunables and tunable_changes tables
+-- Runtime tunables changed through /api/v1/tunables: values persisted for every replica, applied
+-- when a replica starts, and the history of every change on any replica
+
+CREATE TABLE IF NOT EXISTS runtime_tunables (
+    name VARCHAR(64) PRIMARY KEY,
+    value TEXT NOT NULL,
+    changed_by VARCHAR(128) NOT NULL DEFAULT '',
+    changed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    reason TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS tunable_changes (
+    id BIGSERIAL PRIMARY KEY,
+    changed_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    name VARCHAR(64) NOT NULL,
+    old_value TEXT NOT NULL,
+    new_value TEXT NOT NULL,
+    persisted BOOLEAN NOT NULL,              -- Whether the new value was stored for every replica
+    actor VARCHAR(128) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    replica VARCHAR(255) NOT NULL            -- Replica the change was applied on
+);
+
+CREATE INDEX IF NOT EXISTS idx_tunable_changes_name ON tunable_changes(name, id);
+
+COMMENT ON TABLE runtime_tunables IS 'Runtime tunable values persisted through /api/v1/tunables, applied by every replica at startup.';
+COMMENT ON TABLE tunable_changes IS 'History of runtime tunable changes, kept for auditors.';
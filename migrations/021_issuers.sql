@@ -0,0 +1,22 @@
+-- Migration: Create issuers table
+-- Issuer certificates discovered through the AIA caIssuers URL of leaf certificates, with the
+-- CertID hashes requests name them by. Each was verified up to a configured trust root
+
+CREATE TABLE IF NOT EXISTS issuers (
+    key_hash CHAR(40) PRIMARY KEY,                 -- Hex SHA-1 of the subject public key
+    name_hash CHAR(40) NOT NULL,                   -- Hex SHA-1 of the DER subject
+    key_hash_sha256 CHAR(64) NOT NULL,
+    name_hash_sha256 CHAR(64) NOT NULL,
+    subject_key_id VARCHAR(128) NOT NULL DEFAULT '', -- Hex, matched against leaves' authority key IDs
+    subject TEXT NOT NULL,
+    serial VARCHAR(64) NOT NULL,
+    not_before TIMESTAMP NOT NULL,
+    not_after TIMESTAMP NOT NULL,
+    certificate BYTEA NOT NULL,                    -- DER
+    source_url TEXT NOT NULL,
+    discovered_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_issuers_name_hash ON issuers(name_hash);
+
+COMMENT ON TABLE issuers IS 'Issuers registered by AIA discovery, listed at /api/v1/issuers.';
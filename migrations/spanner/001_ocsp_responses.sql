@@ -0,0 +1,18 @@
+-- Migration: Create ocsp_responses table in the Spanner database (GoogleSQL dialect)
+-- Apply with gcloud spanner databases ddl update <database> --instance=<instance> --ddl-file=<this file>
+-- Serials are random, so keying rows by serial spreads writes across splits
+
+CREATE TABLE ocsp_responses (
+    serial STRING(64) NOT NULL,               -- Lowercase hex without leading zeros
+    status STRING(16) NOT NULL,               -- good, revoked or unknown
+    this_update TIMESTAMP NOT NULL,
+    next_update TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP,
+    revocation_reason STRING(64),
+    not_after TIMESTAMP,                      -- Certificate expiry, once recorded
+    validity_seconds INT64                    -- Overrides the validity of signed responses when set
+) PRIMARY KEY (serial);
+
+-- CRL generation lists revoked serials in serial order without reading the base table
+CREATE INDEX ocsp_responses_by_status ON ocsp_responses (status, serial)
+    STORING (this_update, next_update, revoked_at, revocation_reason, not_after, validity_seconds);
@@ -0,0 +1,141 @@
+package ocspclient
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+)
+
+var (
+	oidNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+
+	hashOIDs = map[crypto.Hash]asn1.ObjectIdentifier{
+		crypto.SHA1:   {1, 3, 14, 3, 2, 26},
+		crypto.SHA256: {2, 16, 840, 1, 101, 3, 4, 2, 1},
+		crypto.SHA384: {2, 16, 840, 1, 101, 3, 4, 2, 2},
+		crypto.SHA512: {2, 16, 840, 1, 101, 3, 4, 2, 3},
+	}
+
+	tagRequestExtensions = cbasn1.Tag(2).ContextSpecific().Constructed()
+)
+
+// RFC 8954 bounds nonces to between 1 and 32 bytes
+const maxNonceSize = 32
+
+// RequestOptions configures a request
+type RequestOptions struct {
+	// Hash is the CertID hash algorithm; SHA-1, the one every responder supports, when zero
+	Hash crypto.Hash
+	// NonceSize is the length of a random nonce sent with the request, up to 32 bytes; no nonce
+	// is sent when zero
+	NonceSize int
+}
+
+// Request is an encoded OCSP request for one certificate, with what Verify needs to match the
+// response to it
+type Request struct {
+	DER    []byte
+	Serial *big.Int
+	Issuer *x509.Certificate
+	Hash   crypto.Hash
+	// Nonce is the extnValue of the nonce extension sent, nil when none was
+	Nonce []byte
+}
+
+// NewRequest builds an unsigned request for the certificate with serial issued by issuer
+func NewRequest(serial *big.Int, issuer *x509.Certificate, opts RequestOptions) (*Request, error) {
+	if serial == nil || issuer == nil {
+		return nil, errors.New("serial and issuer are required")
+	}
+	hash := opts.Hash
+	if hash == 0 {
+		hash = crypto.SHA1
+	}
+	if _, ok := hashOIDs[hash]; !ok || !hash.Available() {
+		return nil, fmt.Errorf("unsupported CertID hash %s", hash)
+	}
+	if opts.NonceSize < 0 || opts.NonceSize > maxNonceSize {
+		return nil, fmt.Errorf("nonce size must be between 0 and %d bytes", maxNonceSize)
+	}
+
+	nameHash, keyHash, err := certIDHashes(issuer, hash)
+	if err != nil {
+		return nil, err
+	}
+	req := &Request{Serial: serial, Issuer: issuer, Hash: hash}
+	if opts.NonceSize > 0 {
+		value := make([]byte, opts.NonceSize)
+		if _, err := rand.Read(value); err != nil {
+			return nil, err
+		}
+		if req.Nonce, err = asn1.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+
+	var b cryptobyte.Builder
+	b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+			b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+				b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+						b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+							b.AddASN1ObjectIdentifier(hashOIDs[hash])
+							b.AddASN1NULL()
+						})
+						b.AddASN1OctetString(nameHash)
+						b.AddASN1OctetString(keyHash)
+						b.AddASN1BigInt(serial)
+					})
+				})
+			})
+			if req.Nonce != nil {
+				b.AddASN1(tagRequestExtensions, func(b *cryptobyte.Builder) {
+					b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+						b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+							b.AddASN1ObjectIdentifier(oidNonce)
+							b.AddASN1OctetString(req.Nonce)
+						})
+					})
+				})
+			}
+		})
+	})
+	if req.DER, err = b.Bytes(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// certIDHashes hashes the issuer's subject and subject public key bit string as CertIDs do
+func certIDHashes(issuer *x509.Certificate, hash crypto.Hash) (name, key []byte, err error) {
+	h := hash.New()
+	h.Write(issuer.RawSubject)
+	name = h.Sum(nil)
+	if key, err = keyHash(issuer, hash); err != nil {
+		return nil, nil, err
+	}
+	return name, key, nil
+}
+
+// keyHash hashes the subject public key bit string of cert, without its algorithm and tag
+func keyHash(cert *x509.Certificate, hash crypto.Hash) ([]byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, fmt.Errorf("invalid subject public key: %w", err)
+	}
+	h := hash.New()
+	h.Write(spki.PublicKey.RightAlign())
+	return h.Sum(nil), nil
+}
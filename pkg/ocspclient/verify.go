@@ -0,0 +1,236 @@
+package ocspclient
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// Verification failures, wrapped with details by Verify
+var (
+	ErrUnauthorizedResponder = errors.New("response not signed by an authorized responder")
+	ErrResponderIDMismatch   = errors.New("responder ID does not name the signer")
+	ErrCertIDMismatch        = errors.New("response CertID does not match the request")
+	ErrNotYetValid           = errors.New("response is not yet valid")
+	ErrExpired               = errors.New("response has expired")
+	ErrNonceMismatch         = errors.New("response nonce differs from the request's")
+	ErrNonceMissing          = errors.New("response does not echo the request's nonce")
+)
+
+// VerifyOptions configures verification
+type VerifyOptions struct {
+	// Now is the time responses must be valid at; the current time when zero
+	Now time.Time
+	// ClockSkew is tolerated between the responder's clock and Now
+	ClockSkew time.Duration
+	// MaxAge refuses responses whose thisUpdate is older, even within their nextUpdate. Responses
+	// without a nextUpdate are only refused by age when it is set
+	MaxAge time.Duration
+	// RequireNonce refuses responses that do not echo the request's nonce. RFC 8954 lets
+	// responders leave it out, and precomputed responders always do; a nonce that is echoed must
+	// match either way
+	RequireNonce bool
+	// TrustedResponders are responder certificates trusted directly, for responders whose
+	// signing key the issuer did not authorize (RFC 6960 section 4.2.2.2, trusted locally)
+	TrustedResponders []*x509.Certificate
+}
+
+// Response is a verified response
+type Response struct {
+	*ocsp.Response
+	// Responder is the certificate whose key signed the response: the issuer, a responder it
+	// delegated OCSP signing to, or a trusted responder
+	Responder *x509.Certificate
+	// NonceEchoed reports whether the response carried the request's nonce
+	NonceEchoed bool
+}
+
+// Verify parses a response to req and checks it fully: the signature and the signer's
+// authority for the issuer, the responder ID, the CertID, the validity window and the nonce.
+// Unsuccessful responses are returned as ocsp.ResponseError. Of the certificates embedded in
+// a response only the first is considered as the signer
+func Verify(der []byte, req *Request, opts VerifyOptions) (*Response, error) {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	// Without an issuer this checks the signature only against an embedded certificate
+	resp, err := ocsp.ParseResponseForCert(der, &x509.Certificate{SerialNumber: req.Serial}, nil)
+	if err != nil {
+		return nil, err
+	}
+	tbs, err := parseResponseData(resp.TBSResponseData)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := authorize(resp, req.Issuer, now, opts.TrustedResponders)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponderID(resp, signer); err != nil {
+		return nil, err
+	}
+	if err := checkCertID(tbs, req); err != nil {
+		return nil, err
+	}
+
+	skew := opts.ClockSkew
+	if resp.ThisUpdate.After(now.Add(skew)) {
+		return nil, fmt.Errorf("%w: thisUpdate %s", ErrNotYetValid, resp.ThisUpdate.UTC().Format(time.RFC3339))
+	}
+	if !resp.NextUpdate.IsZero() && now.Add(-skew).After(resp.NextUpdate) {
+		return nil, fmt.Errorf("%w: nextUpdate %s", ErrExpired, resp.NextUpdate.UTC().Format(time.RFC3339))
+	}
+	if opts.MaxAge > 0 && now.Sub(resp.ThisUpdate) > opts.MaxAge+skew {
+		return nil, fmt.Errorf("%w: thisUpdate %s is older than %s", ErrExpired, resp.ThisUpdate.UTC().Format(time.RFC3339), opts.MaxAge)
+	}
+
+	verified := &Response{Response: resp, Responder: signer}
+	if req.Nonce != nil {
+		for _, ext := range tbs.Extensions {
+			if !ext.Id.Equal(oidNonce) {
+				continue
+			}
+			if !bytes.Equal(ext.Value, req.Nonce) {
+				return nil, ErrNonceMismatch
+			}
+			verified.NonceEchoed = true
+		}
+		if !verified.NonceEchoed && opts.RequireNonce {
+			return nil, ErrNonceMissing
+		}
+	}
+	return verified, nil
+}
+
+// authorize finds the certificate that signed resp and checks its authority for issuer
+func authorize(resp *ocsp.Response, issuer *x509.Certificate, now time.Time, trusted []*x509.Certificate) (*x509.Certificate, error) {
+	if embedded := resp.Certificate; embedded != nil {
+		// ParseResponseForCert checked the signature against the embedded certificate
+		if embedded.Equal(issuer) {
+			return issuer, nil
+		}
+		for _, cert := range trusted {
+			if embedded.Equal(cert) {
+				return cert, nil
+			}
+		}
+		if err := embedded.CheckSignatureFrom(issuer); err != nil {
+			return nil, fmt.Errorf("%w: embedded certificate %q was not issued by %q", ErrUnauthorizedResponder, embedded.Subject, issuer.Subject)
+		}
+		if !hasOCSPSigning(embedded) {
+			return nil, fmt.Errorf("%w: delegated responder certificate lacks id-kp-OCSPSigning", ErrUnauthorizedResponder)
+		}
+		if now.Before(embedded.NotBefore) || now.After(embedded.NotAfter) {
+			return nil, fmt.Errorf("%w: delegated responder certificate is not valid at %s", ErrUnauthorizedResponder, now.UTC().Format(time.RFC3339))
+		}
+		return embedded, nil
+	}
+
+	for _, cert := range append([]*x509.Certificate{issuer}, trusted...) {
+		if resp.CheckSignatureFrom(cert) == nil {
+			return cert, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: signature verifies against neither the issuer nor a trusted responder", ErrUnauthorizedResponder)
+}
+
+// checkResponderID checks that the responder ID names the signer, by subject or key hash
+func checkResponderID(resp *ocsp.Response, signer *x509.Certificate) error {
+	if len(resp.ResponderKeyHash) > 0 {
+		hash, err := keyHash(signer, crypto.SHA1)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(hash, resp.ResponderKeyHash) {
+			return fmt.Errorf("%w: key hash %x", ErrResponderIDMismatch, resp.ResponderKeyHash)
+		}
+		return nil
+	}
+	if !bytes.Equal(resp.RawResponderName, signer.RawSubject) {
+		return fmt.Errorf("%w: responder name differs from %q", ErrResponderIDMismatch, signer.Subject)
+	}
+	return nil
+}
+
+// checkCertID checks that the single response for the requested serial names the requested
+// issuer, under whichever hash algorithm the responder chose
+func checkCertID(tbs *responseData, req *Request) error {
+	for _, single := range tbs.Responses {
+		id := single.CertID
+		if id.SerialNumber.Cmp(req.Serial) != 0 {
+			continue
+		}
+		for hash, oid := range hashOIDs {
+			if !id.HashAlgorithm.Algorithm.Equal(oid) || !hash.Available() {
+				continue
+			}
+			name, key, err := certIDHashes(req.Issuer, hash)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(id.NameHash, name) || !bytes.Equal(id.IssuerKeyHash, key) {
+				return fmt.Errorf("%w: issuer hashes differ", ErrCertIDMismatch)
+			}
+			return nil
+		}
+		return fmt.Errorf("%w: unsupported hash algorithm %s", ErrCertIDMismatch, id.HashAlgorithm.Algorithm)
+	}
+	return fmt.Errorf("%w: no response for serial %x", ErrCertIDMismatch, req.Serial)
+}
+
+func hasOCSPSigning(cert *x509.Certificate) bool {
+	for _, usage := range cert.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageOCSPSigning {
+			return true
+		}
+	}
+	return false
+}
+
+// responseData is the signed part of a basic response, for the fields ocsp.Response leaves
+// out: the CertID hashes and the response extensions, where RFC 6960 puts the nonce
+type responseData struct {
+	Version     int `asn1:"optional,explicit,default:0,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []singleResponse
+	Extensions  []pkix.Extension `asn1:"optional,explicit,tag:1"`
+}
+
+type singleResponse struct {
+	CertID     certID
+	Status     asn1.RawValue
+	ThisUpdate time.Time        `asn1:"generalized"`
+	NextUpdate time.Time        `asn1:"generalized,explicit,tag:0,optional"`
+	Extensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+type certID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+func parseResponseData(der []byte) (*responseData, error) {
+	var tbs responseData
+	rest, err := asn1.Unmarshal(der, &tbs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response data: %w", err)
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data after response data")
+	}
+	return &tbs, nil
+}
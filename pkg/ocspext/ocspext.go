@@ -0,0 +1,165 @@
+// Package ocspext lets deployments add extensions to signed responses through Builders
+// registered by name with Register
+package ocspext
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Extensions are extensions to add to one response
+type Extensions struct {
+	// Single go in the singleExtensions of the SingleResponse
+	Single []pkix.Extension
+	// Response go in the responseExtensions of the ResponseData
+	Response []pkix.Extension
+}
+
+// Request is what a response is being signed for
+type Request struct {
+	// Hash is the algorithm of the request's CertID hashes
+	Hash crypto.Hash
+	// Nonce is the value of the request's id-pkix-ocsp-nonce extension, if any
+	Nonce []byte
+}
+
+// Response is the response being signed
+type Response struct {
+	Issuer       *x509.Certificate
+	SerialNumber *big.Int
+	// Status is ocsp.Good, ocsp.Revoked or ocsp.Unknown
+	Status           int
+	RevokedAt        time.Time
+	RevocationReason int
+	ThisUpdate       time.Time
+	NextUpdate       time.Time
+	// Request is nil for responses signed ahead of any request, such as precomputed ones
+	Request *Request
+}
+
+// Builder computes the extensions of each response. It must be safe for concurrent use; an
+// error fails the response it was asked for
+type Builder interface {
+	Build(ctx context.Context, resp *Response) (Extensions, error)
+}
+
+// BuilderFunc adapts a function to Builder
+type BuilderFunc func(ctx context.Context, resp *Response) (Extensions, error)
+
+// Build calls f
+func (f BuilderFunc) Build(ctx context.Context, resp *Response) (Extensions, error) {
+	return f(ctx, resp)
+}
+
+// Static returns a builder adding exts to every response
+func Static(exts Extensions) Builder {
+	return BuilderFunc(func(context.Context, *Response) (Extensions, error) {
+		return exts, nil
+	})
+}
+
+// Chain returns a builder adding the extensions of every builder in turn. An extension OID
+// returned twice for the same field fails the response, since RFC 5280 allows each only once
+func Chain(builders ...Builder) Builder {
+	return BuilderFunc(func(ctx context.Context, resp *Response) (Extensions, error) {
+		var all Extensions
+		for _, builder := range builders {
+			exts, err := builder.Build(ctx, resp)
+			if err != nil {
+				return Extensions{}, err
+			}
+			all.Single = append(all.Single, exts.Single...)
+			all.Response = append(all.Response, exts.Response...)
+		}
+		if err := unique(all.Single); err != nil {
+			return Extensions{}, fmt.Errorf("singleExtensions: %w", err)
+		}
+		if err := unique(all.Response); err != nil {
+			return Extensions{}, fmt.Errorf("responseExtensions: %w", err)
+		}
+		return all, nil
+	})
+}
+
+func unique(exts []pkix.Extension) error {
+	for i := range exts {
+		for j := range i {
+			if exts[i].Id.Equal(exts[j].Id) {
+				return fmt.Errorf("extension %s added twice", exts[i].Id)
+			}
+		}
+	}
+	return nil
+}
+
+// Factory creates a builder from the options given to it in the configuration
+type Factory func(options map[string]string) (Builder, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a builder available under name. It panics when name is taken, so two linked
+// packages cannot silently replace each other's builder
+func Register(name string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[name]; dup {
+		panic("ocspext: builder " + name + " registered twice")
+	}
+	registry[name] = factory
+}
+
+// New creates the builder registered under name
+func New(name string, options map[string]string) (Builder, error) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no extension builder registered as %q (registered: %s)", name, strings.Join(Registered(), ", "))
+	}
+	return factory(options)
+}
+
+// Registered returns the names of the registered builders, sorted
+func Registered() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseOID parses an object identifier in dotted decimal form, such as 1.3.6.1.4.1.99999.1
+func ParseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid[i] = n
+	}
+	if oid[0] > 2 || (oid[0] < 2 && oid[1] > 39) {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	return oid, nil
+}
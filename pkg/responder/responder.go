@@ -0,0 +1,357 @@
+// Package responder is the responder's serving core as a library, so other Go services can
+// answer RFC 6960 OCSP requests in-process without running the responder binary. A Responder
+// is an http.Handler: it parses each request within explicit limits, looks the certificate up
+// in a Storage, and signs the answer with a Signer for as long as a Policy allows
+package responder
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/nonce"
+	"github.com/gigvault/ocsp/internal/ocspreq"
+	"github.com/gigvault/ocsp/pkg/ocspext"
+	"golang.org/x/crypto/ocsp"
+)
+
+// Certificate statuses a Storage returns
+const (
+	Good    = "good"
+	Revoked = "revoked"
+	Unknown = "unknown"
+)
+
+// Errors Respond returns with an unauthorized response
+var (
+	// ErrNotFound is also returned by Storage.Lookup for a serial it has no status for
+	ErrNotFound      = errors.New("certificate status not found")
+	ErrUnknownIssuer = errors.New("request names an issuer without a signer")
+)
+
+// Record is the status of one certificate
+type Record struct {
+	// Status is Good, Revoked or Unknown
+	Status string
+	// RevokedAt is when a revoked certificate was revoked
+	RevokedAt time.Time
+	// RevocationReason is the RFC 5280 CRLReason code of a revoked certificate, such as
+	// ocsp.KeyCompromise
+	RevocationReason int
+}
+
+// Storage holds the status of the certificates the responder answers for. It must be safe
+// for concurrent use
+type Storage interface {
+	// Lookup returns the status of the certificate issuer issued with serial, or ErrNotFound
+	Lookup(ctx context.Context, issuer *x509.Certificate, serial *big.Int) (Record, error)
+}
+
+// Signer signs the responses about one issuer's certificates
+type Signer interface {
+	crypto.Signer
+	// Issuer returns the CA certificate the responses are about
+	Issuer() *x509.Certificate
+	// Certificate returns the certificate the responses are signed under: the issuer itself,
+	// or a delegated responder certificate it issued with the OCSPSigning extended key usage
+	Certificate() *x509.Certificate
+}
+
+// Policy decides how long answers are valid and how serials missing from Storage are answered
+type Policy interface {
+	// NextUpdate returns the nextUpdate of a response about rec signed at thisUpdate. The
+	// zero time leaves nextUpdate out, telling clients newer status is always available
+	NextUpdate(thisUpdate time.Time, rec Record) time.Time
+	// Missing returns the status to answer for a serial Storage has no status for; false
+	// answers unauthorized, as RFC 5019 prescribes for responders that cannot answer
+	Missing(issuer *x509.Certificate, serial *big.Int) (Record, bool)
+}
+
+// FixedPolicy makes every response valid for Validity and answers missing serials with
+// MissingStatus, or unauthorized when it is empty
+type FixedPolicy struct {
+	Validity      time.Duration
+	MissingStatus string
+}
+
+// NextUpdate returns thisUpdate plus Validity, or the zero time without one
+func (p FixedPolicy) NextUpdate(thisUpdate time.Time, rec Record) time.Time {
+	if p.Validity <= 0 {
+		return time.Time{}
+	}
+	return thisUpdate.Add(p.Validity)
+}
+
+// Missing returns MissingStatus
+func (p FixedPolicy) Missing(issuer *x509.Certificate, serial *big.Int) (Record, bool) {
+	return Record{Status: p.MissingStatus}, p.MissingStatus != ""
+}
+
+// Limits bounds what a request may contain; zero fields are unlimited, though a request is
+// never read past 64 KiB
+type Limits = ocspreq.Limits
+
+// NonceCache remembers request nonces for a window, so a replayed request is refused
+type NonceCache = nonce.Cache
+
+// NewNonceCache creates a cache remembering nonces for window, at most maxEntries in all and
+// maxPerClient from one source address; past either, the oldest are forgotten early
+func NewNonceCache(window time.Duration, maxEntries, maxPerClient int) *NonceCache {
+	return nonce.NewCache(window, maxEntries, maxPerClient)
+}
+
+// Options adjust a Responder; the zero value serves at the root of the handler's path
+type Options struct {
+	// Prefix is the path the handler is mounted at, stripped from GET requests before the
+	// base64 request is decoded
+	Prefix string
+	// Limits bounds requests; those exceeding it are answered malformedRequest
+	Limits Limits
+	// Extensions adds extensions to every response
+	Extensions ocspext.Builder
+	// Nonces, when set, makes ServeHTTP answer unauthorized to a request whose nonce it saw
+	// within the cache's window
+	Nonces *NonceCache
+	// RequireNonce answers malformedRequest to requests without a nonce; it needs Nonces
+	RequireNonce bool
+	// OnError is told about lookups and signatures that failed and were answered tryLater
+	OnError func(err error)
+	// Now returns the current time; nil is time.Now
+	Now func() time.Time
+}
+
+// Responder answers OCSP requests, by POST or base64 GET below its prefix, for the issuers of
+// its signers. Every answer is signed for the request, echoing its nonce as RFC 8954 asks
+type Responder struct {
+	storage Storage
+	policy  Policy
+	options Options
+	reader  *ocspreq.Reader
+	issuers []issuer
+}
+
+type issuer struct {
+	certID *ocspreq.Issuer
+	signer Signer
+}
+
+// New creates a responder answering for signer's issuer. More issuers are added with
+// AddSigner before the responder serves
+func New(storage Storage, signer Signer, policy Policy, options Options) (*Responder, error) {
+	if storage == nil || signer == nil || policy == nil {
+		return nil, errors.New("responder needs a storage, a signer and a policy")
+	}
+	if options.Now == nil {
+		options.Now = time.Now
+	}
+	r := &Responder{
+		storage: storage,
+		policy:  policy,
+		options: options,
+		reader:  ocspreq.NewReader(options.Prefix, options.Limits),
+	}
+	if err := r.AddSigner(signer); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// AddSigner answers requests about the certificates of another issuer. It is not safe to call
+// while the responder serves
+func (r *Responder) AddSigner(signer Signer) error {
+	if err := checkSigner(signer); err != nil {
+		return err
+	}
+	certID, err := ocspreq.NewIssuer(signer.Issuer())
+	if err != nil {
+		return err
+	}
+	for _, known := range r.issuers {
+		if known.certID.Certificate.Equal(signer.Issuer()) {
+			return fmt.Errorf("issuer %s already has a signer", signer.Issuer().Subject)
+		}
+	}
+	r.issuers = append(r.issuers, issuer{certID: certID, signer: signer})
+	return nil
+}
+
+// ServeHTTP answers one OCSP request
+func (r *Responder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	buf := r.reader.Acquire()
+	defer r.reader.Release(buf)
+
+	body, err := r.reader.Read(req, buf)
+	if err != nil {
+		write(w, ocsp.MalformedRequestErrorResponse, time.Time{}, time.Time{})
+		return
+	}
+	request, err := ocspreq.Parse(body, r.options.Limits)
+	if err != nil {
+		write(w, ocsp.MalformedRequestErrorResponse, time.Time{}, time.Time{})
+		return
+	}
+	if r.options.Nonces != nil {
+		switch {
+		case request.Nonce == nil && r.options.RequireNonce:
+			write(w, ocsp.MalformedRequestErrorResponse, time.Time{}, time.Time{})
+			return
+		case request.Nonce != nil && r.options.Nonces.Check(nonce.Source(req.RemoteAddr), request.Nonce) != nil:
+			write(w, ocsp.UnauthorizedErrorResponse, time.Time{}, time.Time{})
+			return
+		}
+	}
+	der, nextUpdate, err := r.Respond(req.Context(), &request.Request, request.Nonce)
+	if err != nil {
+		write(w, der, time.Time{}, time.Time{})
+		return
+	}
+	if request.Nonce != nil {
+		writeNonced(w, der)
+		return
+	}
+	write(w, der, nextUpdate, r.options.Now())
+}
+
+// Respond returns the signed response to a parsed request, for callers that receive requests
+// other than over HTTP, and its nextUpdate. A request that cannot be answered returns the
+// unauthorized or tryLater error response with the error that caused it
+func (r *Responder) Respond(ctx context.Context, req *ocsp.Request, nonce []byte) ([]byte, time.Time, error) {
+	var match *issuer
+	var issuerCert *x509.Certificate
+	for i := range r.issuers {
+		if issuerCert = r.issuers[i].certID.Match(req); issuerCert != nil {
+			match = &r.issuers[i]
+			break
+		}
+	}
+	if match == nil {
+		return ocsp.UnauthorizedErrorResponse, time.Time{}, ErrUnknownIssuer
+	}
+
+	rec, err := r.storage.Lookup(ctx, issuerCert, req.SerialNumber)
+	if errors.Is(err, ErrNotFound) {
+		var ok bool
+		if rec, ok = r.policy.Missing(issuerCert, req.SerialNumber); !ok {
+			return ocsp.UnauthorizedErrorResponse, time.Time{}, err
+		}
+	} else if err != nil {
+		return r.failed(fmt.Errorf("failed to look up serial %x: %w", req.SerialNumber, err))
+	}
+
+	der, nextUpdate, err := r.sign(ctx, match.signer, req, nonce, rec)
+	if err != nil {
+		return r.failed(fmt.Errorf("failed to sign response for serial %x: %w", req.SerialNumber, err))
+	}
+	return der, nextUpdate, nil
+}
+
+// failed reports err and returns the tryLater response for it
+func (r *Responder) failed(err error) ([]byte, time.Time, error) {
+	if r.options.OnError != nil {
+		r.options.OnError(err)
+	}
+	return ocsp.TryLaterErrorResponse, time.Time{}, err
+}
+
+// oidNonce is id-pkix-ocsp-nonce
+var oidNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+
+func (r *Responder) sign(ctx context.Context, signer Signer, req *ocsp.Request, nonce []byte, rec Record) ([]byte, time.Time, error) {
+	thisUpdate := r.options.Now().UTC().Truncate(time.Second)
+	template := ocsp.Response{
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   thisUpdate,
+		NextUpdate:   r.policy.NextUpdate(thisUpdate, rec),
+		IssuerHash:   req.HashAlgorithm,
+	}
+	if !signer.Certificate().Equal(signer.Issuer()) {
+		template.Certificate = signer.Certificate()
+	}
+	switch rec.Status {
+	case Good:
+		template.Status = ocsp.Good
+	case Revoked:
+		template.Status = ocsp.Revoked
+		template.RevokedAt = rec.RevokedAt
+		template.RevocationReason = rec.RevocationReason
+	case Unknown:
+		template.Status = ocsp.Unknown
+	default:
+		return nil, time.Time{}, fmt.Errorf("invalid status %q", rec.Status)
+	}
+
+	var responseExtensions []pkix.Extension
+	if r.options.Extensions != nil {
+		exts, err := r.options.Extensions.Build(ctx, &ocspext.Response{
+			Issuer:           signer.Issuer(),
+			SerialNumber:     req.SerialNumber,
+			Status:           template.Status,
+			RevokedAt:        template.RevokedAt,
+			RevocationReason: template.RevocationReason,
+			ThisUpdate:       template.ThisUpdate,
+			NextUpdate:       template.NextUpdate,
+			Request:          &ocspext.Request{Hash: req.HashAlgorithm, Nonce: nonce},
+		})
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("failed to build extensions: %w", err)
+		}
+		template.ExtraExtensions, responseExtensions = exts.Single, exts.Response
+	}
+	if nonce != nil && !hasExtension(responseExtensions, oidNonce) {
+		// Copied since the nonce aliases the request buffer, and clipped so a builder's own
+		// slice is never appended to
+		echo := pkix.Extension{Id: oidNonce, Value: append([]byte(nil), nonce...)}
+		responseExtensions = append(responseExtensions[:len(responseExtensions):len(responseExtensions)], echo)
+	}
+
+	der, err := ocspext.CreateResponse(signer.Issuer(), signer.Certificate(), template, signer, responseExtensions)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return der, template.NextUpdate, nil
+}
+
+func hasExtension(exts []pkix.Extension, oid asn1.ObjectIdentifier) bool {
+	for _, ext := range exts {
+		if ext.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNonced sends a response echoing the request's nonce, which caches must neither store
+// nor revalidate
+func writeNonced(w http.ResponseWriter, der []byte) {
+	header := w.Header()
+	header.Set("Content-Type", "application/ocsp-response")
+	header.Set("Cache-Control", "no-cache, no-store, private")
+	header.Del("Expires")
+	header.Del("ETag")
+	header.Del("Last-Modified")
+	w.Write(der)
+}
+
+// write sends a response, cacheable until nextUpdate when it is set
+func write(w http.ResponseWriter, der []byte, nextUpdate, now time.Time) {
+	header := w.Header()
+	header.Set("Content-Type", "application/ocsp-response")
+	if maxAge := nextUpdate.Sub(now); !nextUpdate.IsZero() && maxAge > 0 {
+		header.Set("Cache-Control", "max-age="+strconv.Itoa(int(maxAge.Seconds()))+", public, no-transform, must-revalidate")
+		header.Set("Expires", nextUpdate.UTC().Format(http.TimeFormat))
+	}
+	w.Write(der)
+}
@@ -0,0 +1,54 @@
+package responder
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+type keySigner struct {
+	crypto.Signer
+	issuer      *x509.Certificate
+	certificate *x509.Certificate
+}
+
+func (s *keySigner) Issuer() *x509.Certificate      { return s.issuer }
+func (s *keySigner) Certificate() *x509.Certificate { return s.certificate }
+
+// NewSigner signs responses about issuer's certificates with key, which belongs to responder,
+// a delegated responder certificate issuer issued, or to issuer itself when responder is nil
+func NewSigner(issuer, responder *x509.Certificate, key crypto.Signer) (Signer, error) {
+	if responder == nil {
+		responder = issuer
+	}
+	signer := &keySigner{Signer: key, issuer: issuer, certificate: responder}
+	if err := checkSigner(signer); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// checkSigner verifies that a signer's key belongs to its certificate and that a delegated
+// responder certificate is one clients accept for the issuer (RFC 6960 section 4.2.2.2)
+func checkSigner(signer Signer) error {
+	issuer, cert := signer.Issuer(), signer.Certificate()
+	if issuer == nil || cert == nil {
+		return errors.New("signer has no issuer or responder certificate")
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return fmt.Errorf("signer key does not belong to %s", cert.Subject)
+	}
+	if cert.Equal(issuer) {
+		return nil
+	}
+	if err := cert.CheckSignatureFrom(issuer); err != nil {
+		return fmt.Errorf("responder certificate %s was not issued by %s: %w", cert.Subject, issuer.Subject, err)
+	}
+	if !slices.Contains(cert.ExtKeyUsage, x509.ExtKeyUsageOCSPSigning) {
+		return fmt.Errorf("responder certificate %s lacks the OCSPSigning extended key usage", cert.Subject)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Package stapling is a client for the responder's status watch API, for TLS terminators that
+// refresh stapled status as soon as it changes
+package stapling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Status is a certificate status as served by the watch API
+type Status struct {
+	Serial           string     `json:"serial"`
+	Status           string     `json:"status"`
+	ThisUpdate       time.Time  `json:"this_update"`
+	NextUpdate       time.Time  `json:"next_update"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+	RevocationReason string     `json:"revocation_reason,omitempty"`
+}
+
+// Client talks to one responder
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewClient creates a client for the responder at baseURL, e.g. https://ocsp.internal:8080.
+// A nil httpClient uses one without an overall timeout, as long polls outlive typical ones
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), http: httpClient}
+}
+
+// Get returns the current status of serial
+func (c *Client) Get(ctx context.Context, serial string) (*Status, error) {
+	status, _, err := c.poll(ctx, serial, "", 0)
+	return status, err
+}
+
+// Watch calls fn with the current status of serial and again after every change, until the
+// context is cancelled or a request fails. Each request blocks server-side for up to wait
+func (c *Client) Watch(ctx context.Context, serial string, wait time.Duration, fn func(*Status)) error {
+	tag := ""
+	for {
+		status, next, err := c.poll(ctx, serial, tag, wait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if status != nil {
+			fn(status)
+		}
+		tag = next
+	}
+}
+
+// poll fetches serial, returning a nil status when it still matches tag
+func (c *Client) poll(ctx context.Context, serial, tag string, wait time.Duration) (*Status, string, error) {
+	endpoint := c.baseURL + "/api/v1/statuses/" + url.PathEscape(serial)
+	if wait > 0 {
+		endpoint += "?wait=" + wait.String()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if tag != "" {
+		req.Header.Set("If-None-Match", tag)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, tag, nil
+	case http.StatusOK:
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, "", fmt.Errorf("status request returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var body struct {
+		Data Status `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, "", fmt.Errorf("invalid status response: %w", err)
+	}
+	return &body.Data, resp.Header.Get("ETag"), nil
+}
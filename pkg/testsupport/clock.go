@@ -0,0 +1,47 @@
+// Package testsupport provides test doubles for code that embeds or extends the responder: an
+// in-memory status store, a signer whose signatures are deterministic and a fake clock, so
+// unit tests run without Postgres, key files or sleeping
+package testsupport
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a fake clock that only moves when told to. Its Now method can be passed wherever
+// a func() time.Time is expected
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock creates a clock stopped at start
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now returns the clock's current time
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Since returns the time elapsed on the clock since t
+func (c *Clock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Advance moves the clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to t, which may be in its past
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
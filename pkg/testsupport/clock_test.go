@@ -0,0 +1,27 @@
+package testsupport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	if !clock.Now().Equal(start) || !clock.Now().Equal(start) {
+		t.Fatalf("Now() = %s, want the stopped start %s", clock.Now(), start)
+	}
+
+	clock.Advance(90 * time.Second)
+	if got := clock.Now(); !got.Equal(start.Add(90 * time.Second)) {
+		t.Errorf("Now() after Advance = %s", got)
+	}
+	if got := clock.Since(start); got != 90*time.Second {
+		t.Errorf("Since(start) = %s, want 1m30s", got)
+	}
+
+	clock.Set(start.Add(-time.Hour))
+	if got := clock.Since(start); got != -time.Hour {
+		t.Errorf("Since(start) after Set to the past = %s, want -1h", got)
+	}
+}
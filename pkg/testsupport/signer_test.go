@@ -0,0 +1,49 @@
+package testsupport
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestSignerIsDeterministic(t *testing.T) {
+	digest := sha256.Sum256([]byte("tbsResponseData"))
+	first, err := NewSigner().Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewSigner().Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Error("two signatures of the same digest differ")
+	}
+	if !ecdsa.VerifyASN1(NewSigner().Public().(*ecdsa.PublicKey), digest[:], first) {
+		t.Error("signature does not verify with the signer's public key")
+	}
+}
+
+func TestNewIssuer(t *testing.T) {
+	signer := NewSigner()
+	first, err := NewIssuer(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewIssuer(signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Raw, second.Raw) {
+		t.Error("two issuers of the signer differ")
+	}
+	if !first.IsCA {
+		t.Error("issuer is not a CA")
+	}
+	if err := first.CheckSignatureFrom(first); err != nil {
+		t.Errorf("issuer is not self-signed: %v", err)
+	}
+}
@@ -0,0 +1,339 @@
+package testsupport
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+// validity is how long a written status stays current, as in the ocsp_responses table
+const validity = 24 * time.Hour
+
+// Store keeps statuses in memory with the semantics of the Postgres store: writes stamp
+// this_update from the clock and next_update a day later, revoked_at is only kept for revoked
+// statuses, lists are ordered by serial and timestamps have microsecond precision
+type Store struct {
+	mu         sync.Mutex
+	now        func() time.Time
+	records    map[string]storage.Record
+	notAfter   map[string]time.Time
+	validity   map[string]time.Duration
+	crlNumbers map[string]storage.CRLNumber
+	err        error
+}
+
+var (
+	_ storage.Store          = (*Store)(nil)
+	_ storage.CRLNumbers     = (*Store)(nil)
+	_ storage.Seeder         = (*Store)(nil)
+	_ storage.ExpiryRecorder = (*Store)(nil)
+	_ storage.Replica        = (*Store)(nil)
+)
+
+// NewStore creates an empty store stamping writes with now, usually a Clock's Now. A nil now
+// uses time.Now
+func NewStore(now func() time.Time) *Store {
+	if now == nil {
+		now = time.Now
+	}
+	return &Store{
+		now:        now,
+		records:    make(map[string]storage.Record),
+		notAfter:   make(map[string]time.Time),
+		validity:   make(map[string]time.Duration),
+		crlNumbers: make(map[string]storage.CRLNumber),
+	}
+}
+
+// SetError makes every later call fail with err, as if the database were unreachable, until
+// it is called again with nil
+func (s *Store) SetError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// Put stores rec as it is, timestamps, expiry and validity override included, replacing any
+// status of its serial
+func (s *Store) Put(rec storage.Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec.NotAfter != nil {
+		s.notAfter[rec.Serial] = rec.NotAfter.UTC().Truncate(time.Microsecond)
+	}
+	if rec.Validity > 0 {
+		s.validity[rec.Serial] = rec.Validity.Truncate(time.Second)
+	}
+	s.records[rec.Serial] = normalize(rec)
+}
+
+// Records returns a copy of every stored status, ordered by serial
+func (s *Store) Records() []storage.Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sorted(func(storage.Record) bool { return true })
+}
+
+// NotAfter returns the expiry recorded for a serial
+func (s *Store) NotAfter(serial string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.notAfter[serial]
+	return t, ok
+}
+
+// Get returns the status for a serial, or storage.ErrNotFound
+func (s *Store) Get(ctx context.Context, serial string) (*storage.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+	rec, ok := s.records[serial]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	if t, ok := s.notAfter[serial]; ok {
+		rec.NotAfter = &t
+	}
+	rec.Validity = s.validity[serial]
+	return &rec, nil
+}
+
+// Upsert inserts or replaces the status for a single serial
+func (s *Store) Upsert(ctx context.Context, update storage.Update) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+	s.apply(update, s.timestamp())
+	return nil
+}
+
+// ApplyBatch applies all updates with one timestamp, as a single transaction would
+func (s *Store) ApplyBatch(ctx context.Context, updates []storage.Update) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+	now := s.timestamp()
+	for _, update := range updates {
+		s.apply(update, now)
+	}
+	return nil
+}
+
+// ListRevoked returns every revoked serial, ordered by serial
+func (s *Store) ListRevoked(ctx context.Context) ([]storage.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+	return s.sorted(func(rec storage.Record) bool { return rec.Status == storage.StatusRevoked }), nil
+}
+
+// ForEachRecord calls fn with every stored status, ordered by serial. fn runs without the
+// store locked, so it may write to the store
+func (s *Store) ForEachRecord(ctx context.Context, fn func(storage.Record) error) error {
+	s.mu.Lock()
+	if err := s.check(ctx); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	records := s.sorted(func(storage.Record) bool { return true })
+	s.mu.Unlock()
+
+	for _, rec := range records {
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListRevokedSince returns serials whose revoked status was written at or after since, oldest first
+func (s *Store) ListRevokedSince(ctx context.Context, since time.Time) ([]storage.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+	records := s.sorted(func(rec storage.Record) bool {
+		return rec.Status == storage.StatusRevoked && !rec.ThisUpdate.Before(since)
+	})
+	sort.SliceStable(records, func(i, j int) bool { return records[i].ThisUpdate.Before(records[j].ThisUpdate) })
+	return records, nil
+}
+
+// ListRange returns up to rng.Limit statuses in rng, in numeric serial order
+func (s *Store) ListRange(ctx context.Context, rng storage.SerialRange) ([]storage.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+	records := s.sorted(func(rec storage.Record) bool {
+		return strings.HasPrefix(rec.Serial, rng.Prefix) &&
+			(rng.From == "" || !storage.SerialLess(rec.Serial, rng.From)) &&
+			(rng.To == "" || !storage.SerialLess(rng.To, rec.Serial)) &&
+			(rng.After == "" || storage.SerialLess(rng.After, rec.Serial)) &&
+			(rng.Status == "" || rec.Status == rng.Status)
+	})
+	sort.SliceStable(records, func(i, j int) bool { return storage.SerialLess(records[i].Serial, records[j].Serial) })
+	if len(records) > rng.Limit {
+		records = records[:rng.Limit]
+	}
+	return records, nil
+}
+
+// InsertMissing inserts statuses for serials that have none and returns how many were inserted
+func (s *Store) InsertMissing(ctx context.Context, updates []storage.Update) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(ctx); err != nil {
+		return 0, err
+	}
+	now := s.timestamp()
+	inserted := 0
+	for _, update := range updates {
+		if _, ok := s.records[update.Serial]; ok {
+			continue
+		}
+		// Seeded statuses carry no revocation details, as in Postgres
+		s.apply(storage.Update{Serial: update.Serial, Status: update.Status}, now)
+		inserted++
+	}
+	return inserted, nil
+}
+
+// RecordExpiry sets the expiry of the serials that have a status, ignoring the others
+func (s *Store) RecordExpiry(ctx context.Context, notAfter map[string]time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(ctx); err != nil {
+		return err
+	}
+	for serial, t := range notAfter {
+		if _, ok := s.records[serial]; ok {
+			s.notAfter[serial] = t.UTC().Truncate(time.Microsecond)
+		}
+	}
+	return nil
+}
+
+// ApplyIfNewer stores rec, timestamps, expiry and validity override included, unless the
+// stored status has a later or equal this_update
+func (s *Store) ApplyIfNewer(ctx context.Context, rec storage.Record) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(ctx); err != nil {
+		return false, err
+	}
+	if stored, ok := s.records[rec.Serial]; ok && !stored.ThisUpdate.Before(rec.ThisUpdate.UTC().Truncate(time.Microsecond)) {
+		return false, nil
+	}
+	delete(s.notAfter, rec.Serial)
+	delete(s.validity, rec.Serial)
+	if rec.NotAfter != nil {
+		s.notAfter[rec.Serial] = rec.NotAfter.UTC().Truncate(time.Microsecond)
+	}
+	if rec.Validity > 0 {
+		s.validity[rec.Serial] = rec.Validity.Truncate(time.Second)
+	}
+	s.records[rec.Serial] = normalize(rec)
+	return true, nil
+}
+
+// NextCRLNumber returns the next CRL number for the issuer, starting at 1
+func (s *Store) NextCRLNumber(ctx context.Context, issuer string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(ctx); err != nil {
+		return 0, err
+	}
+	n := s.crlNumbers[issuer]
+	n.Issuer = issuer
+	n.Number++
+	n.UpdatedAt = s.timestamp()
+	s.crlNumbers[issuer] = n
+	return n.Number, nil
+}
+
+// ListCRLNumbers returns the CRL number of every issuer, ordered by issuer
+func (s *Store) ListCRLNumbers(ctx context.Context) ([]storage.CRLNumber, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(ctx); err != nil {
+		return nil, err
+	}
+	numbers := make([]storage.CRLNumber, 0, len(s.crlNumbers))
+	for _, n := range s.crlNumbers {
+		numbers = append(numbers, n)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i].Issuer < numbers[j].Issuer })
+	return numbers, nil
+}
+
+func (s *Store) check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.err
+}
+
+// timestamp reads the clock at the precision Postgres stores
+func (s *Store) timestamp() time.Time {
+	return s.now().UTC().Truncate(time.Microsecond)
+}
+
+func (s *Store) apply(update storage.Update, now time.Time) {
+	if update.Validity != nil {
+		if *update.Validity > 0 {
+			s.validity[update.Serial] = update.Validity.Truncate(time.Second)
+		} else {
+			delete(s.validity, update.Serial)
+		}
+	}
+	s.records[update.Serial] = normalize(storage.Record{
+		Serial:           update.Serial,
+		Status:           update.Status,
+		ThisUpdate:       now,
+		NextUpdate:       now.Add(validity),
+		RevokedAt:        update.RevokedAt,
+		RevocationReason: update.RevocationReason,
+	})
+}
+
+func (s *Store) sorted(keep func(storage.Record) bool) []storage.Record {
+	var records []storage.Record
+	for _, rec := range s.records {
+		if keep(rec) {
+			records = append(records, rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Serial < records[j].Serial })
+	return records
+}
+
+// normalize copies rec as Postgres would store it: in UTC at microsecond precision, with
+// revoked_at dropped unless the status is revoked
+func normalize(rec storage.Record) storage.Record {
+	rec.ThisUpdate = rec.ThisUpdate.UTC().Truncate(time.Microsecond)
+	rec.NextUpdate = rec.NextUpdate.UTC().Truncate(time.Microsecond)
+	if rec.Status == storage.StatusRevoked && rec.RevokedAt != nil {
+		t := rec.RevokedAt.UTC().Truncate(time.Microsecond)
+		rec.RevokedAt = &t
+	} else {
+		rec.RevokedAt = nil
+	}
+	// Kept apart, as Postgres only reads them for single statuses
+	rec.NotAfter, rec.Validity = nil, 0
+	return rec
+}
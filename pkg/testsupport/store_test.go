@@ -0,0 +1,111 @@
+package testsupport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gigvault/ocsp/internal/storage"
+)
+
+func TestStoreStampsWrites(t *testing.T) {
+	ctx := context.Background()
+	clock := NewClock(time.Date(2026, 3, 1, 12, 0, 0, 123456789, time.UTC))
+	store := NewStore(clock.Now)
+
+	revokedAt := clock.Now().Add(-time.Hour)
+	if err := store.Upsert(ctx, storage.Update{Serial: "0b", Status: storage.StatusRevoked, RevokedAt: &revokedAt, RevocationReason: "keyCompromise"}); err != nil {
+		t.Fatal(err)
+	}
+	// A good status keeps no revocation time
+	if err := store.Upsert(ctx, storage.Update{Serial: "0a", Status: storage.StatusGood, RevokedAt: &revokedAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	stamped := clock.Now().Truncate(time.Microsecond)
+	rec, err := store.Get(ctx, "0b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.ThisUpdate.Equal(stamped) || !rec.NextUpdate.Equal(stamped.Add(24*time.Hour)) {
+		t.Errorf("0b stamped %s to %s, want %s for a day", rec.ThisUpdate, rec.NextUpdate, stamped)
+	}
+	if rec.RevokedAt == nil || !rec.RevokedAt.Equal(revokedAt.Truncate(time.Microsecond)) {
+		t.Errorf("0b revoked at %v", rec.RevokedAt)
+	}
+	if rec, _ := store.Get(ctx, "0a"); rec.RevokedAt != nil {
+		t.Errorf("good status kept revoked_at %s", rec.RevokedAt)
+	}
+	if _, err := store.Get(ctx, "0c"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Get(unknown) = %v, want ErrNotFound", err)
+	}
+
+	revoked, err := store.ListRevoked(ctx)
+	if err != nil || len(revoked) != 1 || revoked[0].Serial != "0b" {
+		t.Errorf("ListRevoked() = %+v, %v", revoked, err)
+	}
+	if records := store.Records(); len(records) != 2 || records[0].Serial != "0a" || records[1].Serial != "0b" {
+		t.Errorf("Records() = %+v, want 0a and 0b in order", records)
+	}
+}
+
+func TestStoreKeepsDetailsApart(t *testing.T) {
+	ctx := context.Background()
+	clock := NewClock(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	store := NewStore(clock.Now)
+	notAfter := clock.Now().Add(90 * 24 * time.Hour)
+	store.Put(storage.Record{Serial: "0a", Status: storage.StatusGood, ThisUpdate: clock.Now(), NotAfter: &notAfter, Validity: 6 * time.Hour})
+
+	// A status write leaves expiry and an unset validity override alone
+	clock.Advance(time.Minute)
+	if err := store.Upsert(ctx, storage.Update{Serial: "0a", Status: storage.StatusGood}); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := store.Get(ctx, "0a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.NotAfter == nil || !rec.NotAfter.Equal(notAfter) || rec.Validity != 6*time.Hour {
+		t.Errorf("record after Upsert = %+v", rec)
+	}
+	if got, ok := store.NotAfter("0a"); !ok || !got.Equal(notAfter) {
+		t.Errorf("NotAfter() = %s, %v", got, ok)
+	}
+
+	// Replication applies a record only over an older one
+	older := storage.Record{Serial: "0a", Status: storage.StatusGood, ThisUpdate: clock.Now().Add(-time.Second)}
+	if applied, err := store.ApplyIfNewer(ctx, older); err != nil || applied {
+		t.Errorf("ApplyIfNewer(older) = %v, %v", applied, err)
+	}
+	newer := storage.Record{Serial: "0a", Status: storage.StatusGood, ThisUpdate: clock.Now().Add(time.Second)}
+	if applied, err := store.ApplyIfNewer(ctx, newer); err != nil || !applied {
+		t.Errorf("ApplyIfNewer(newer) = %v, %v", applied, err)
+	}
+	if rec, _ := store.Get(ctx, "0a"); rec.NotAfter != nil || rec.Validity != 0 {
+		t.Errorf("ApplyIfNewer kept details the record did not carry: %+v", rec)
+	}
+}
+
+func TestStoreSetError(t *testing.T) {
+	ctx := context.Background()
+	store := NewStore(nil)
+	down := errors.New("connection refused")
+	store.SetError(down)
+	if err := store.Upsert(ctx, storage.Update{Serial: "0a", Status: storage.StatusGood}); !errors.Is(err, down) {
+		t.Errorf("Upsert() = %v, want the set error", err)
+	}
+	if _, err := store.NextCRLNumber(ctx, "ca"); !errors.Is(err, down) {
+		t.Errorf("NextCRLNumber() = %v, want the set error", err)
+	}
+
+	store.SetError(nil)
+	for want := int64(1); want <= 2; want++ {
+		if n, err := store.NextCRLNumber(ctx, "ca"); err != nil || n != want {
+			t.Errorf("NextCRLNumber() = %d, %v, want %d", n, err, want)
+		}
+	}
+	if _, err := store.Get(ctx, "0a"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("write while failing was kept: %v", err)
+	}
+}